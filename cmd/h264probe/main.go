@@ -0,0 +1,210 @@
+/*
+NAME
+  main.go
+
+DESCRIPTION
+  h264probe lists the NAL units or frames of an H.264 Annex B byte stream, as
+  text, JSON or CSV, for inspection or analysis by other tools.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+// h264probe lists the NAL units, or with -frames the frames, of an H.264
+// Annex B byte stream read from the given file, or standard input. The
+// listing is written to standard output in the format given by -format:
+// aligned text columns, a JSON array of objects, or CSV with a header row.
+// With -hexdump, a hexdump of each NAL unit annotated with its syntax elements
+// is written instead.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ausocean/h264decode/h264"
+)
+
+// Output formats.
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+var errBadFormat = errors.New("format must be one of text, json or csv")
+
+// table is a listing of records, being the values of each row and, for JSON
+// output, the records themselves.
+type table struct {
+	header  []string
+	rows    [][]string
+	records interface{}
+}
+
+// frame is a frame of the frame listing.
+type frame struct {
+	GOP int `json:"gop"` // Index of the GOP of the frame, counting from 0.
+	h264.RefFrame
+}
+
+func main() {
+	format := flag.String("format", formatText, "output format: text, json or csv")
+	frames := flag.Bool("frames", false, "list frames rather than NAL units")
+	version := flag.Bool("version", false, "print the decoder version and capabilities and exit")
+	hexdump := flag.Bool("hexdump", false, "print a hexdump of each NAL unit annotated with its syntax elements")
+	flag.Parse()
+
+	if *version {
+		fmt.Println(h264.FeatureSet())
+		return
+	}
+
+	var err error
+	if *hexdump {
+		err = dump(os.Stdout, flag.Arg(0))
+	} else {
+		err = run(os.Stdout, flag.Arg(0), *format, *frames)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "h264probe: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run writes the listing of the stream in the file at path, or standard input
+// if path is empty, to w in the given format.
+func run(w io.Writer, path, format string, frames bool) error {
+	switch format {
+	case formatText, formatJSON, formatCSV:
+	default:
+		return errBadFormat
+	}
+
+	in, err := open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var t *table
+	if frames {
+		t, err = frameTable(in)
+	} else {
+		t, err = nalTable(in)
+	}
+	if err != nil {
+		return err
+	}
+	return write(w, t, format)
+}
+
+// dump writes the annotated hexdump of each NAL unit of the stream in the file
+// at path, or standard input if path is empty, to w.
+func dump(w io.Writer, path string) error {
+	in, err := open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return h264.HexdumpStream(w, in)
+}
+
+// open opens the file at path, or returns standard input if path is empty.
+func open(path string) (io.ReadCloser, error) {
+	if path == "" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
+
+// nalTable returns the listing of the NAL units of the stream read from r.
+func nalTable(r io.Reader) (*table, error) {
+	info, err := h264.ReadNALInfo(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read NAL units: %w", err)
+	}
+	if info == nil {
+		info = []h264.NALInfo{} // Gives an empty JSON array rather than null.
+	}
+	t := &table{
+		header:  []string{"index", "offset", "size", "type", "ref_idc", "temporal_id", "slice_type"},
+		records: info,
+	}
+	for _, n := range info {
+		t.rows = append(t.rows, []string{
+			strconv.Itoa(n.Index),
+			strconv.FormatInt(n.Offset, 10),
+			strconv.Itoa(n.Size),
+			n.Type,
+			strconv.Itoa(n.RefIdc),
+			strconv.Itoa(n.TemporalID),
+			n.SliceType,
+		})
+	}
+	return t, nil
+}
+
+// frameTable returns the listing of the frames of the stream read from r.
+// The references of a frame are given as space separated frame indices.
+func frameTable(r io.Reader) (*table, error) {
+	g, err := h264.ReadRefGraph(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read frames: %w", err)
+	}
+	t := &table{header: []string{"gop", "index", "offset", "type", "idr", "reference", "field", "refs"}}
+	frames := []frame{}
+	for i, gop := range g.GOPs {
+		for _, f := range gop.Frames {
+			frames = append(frames, frame{GOP: i, RefFrame: f})
+			refs := make([]string, len(f.Refs))
+			for j, ref := range f.Refs {
+				refs[j] = strconv.Itoa(ref)
+			}
+			t.rows = append(t.rows, []string{
+				strconv.Itoa(i),
+				strconv.Itoa(f.Index),
+				strconv.FormatInt(f.Offset, 10),
+				f.Type,
+				strconv.FormatBool(f.IDR),
+				strconv.FormatBool(f.Reference),
+				strconv.FormatBool(f.Field),
+				strings.Join(refs, " "),
+			})
+		}
+	}
+	t.records = frames
+	return t, nil
+}
+
+// write writes the table to w in the given format.
+func write(w io.Writer, t *table, format string) error {
+	switch format {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t.records)
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(t.header)
+		cw.WriteAll(t.rows)
+		return cw.Error()
+	case formatText:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(t.header, "\t")))
+		for _, row := range t.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return errBadFormat
+	}
+}
@@ -0,0 +1,112 @@
+/*
+NAME
+  main_test.go
+
+DESCRIPTION
+  main_test.go provides testing for the listings written by h264probe.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testStream holds an SPS, PPS, IDR slice and P slice.
+var testStream = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0x00, 0x1e, 0xda, 0x79,
+	0x00, 0x00, 0x00, 0x01, 0x68, 0xce, 0x3c, 0x80,
+	0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84,
+	0x00, 0x00, 0x00, 0x01, 0x41, 0x9a,
+}
+
+// TestWrite checks the NAL unit and frame listings in each format.
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		frames bool
+		format string
+		want   string
+	}{
+		{
+			format: formatCSV,
+			want: "index,offset,size,type,ref_idc,temporal_id,slice_type\n" +
+				"0,4,6,sequence parameter set,3,0,\n" +
+				"1,14,4,picture parameter set,3,0,\n" +
+				"2,22,3,coded IDR slice of picture,3,0,I\n" +
+				"3,29,2,coded slice of non-IDR picture,2,0,P\n",
+		},
+		{
+			frames: true,
+			format: formatCSV,
+			want: "gop,index,offset,type,idr,reference,field,refs\n" +
+				"0,0,22,I,true,true,false,\n" +
+				"0,1,29,P,false,true,false,0\n",
+		},
+		{
+			frames: true,
+			format: formatJSON,
+			want: `[
+  {
+    "gop": 0,
+    "index": 0,
+    "offset": 22,
+    "type": "I",
+    "idr": true,
+    "reference": true,
+    "field": false,
+    "refs": null
+  },
+  {
+    "gop": 0,
+    "index": 1,
+    "offset": 29,
+    "type": "P",
+    "idr": false,
+    "reference": true,
+    "field": false,
+    "refs": [
+      0
+    ]
+  }
+]
+`,
+		},
+		{
+			frames: true,
+			format: formatText,
+			want: "GOP  INDEX  OFFSET  TYPE  IDR    REFERENCE  FIELD  REFS\n" +
+				"0    0      22      I     true   true       false  \n" +
+				"0    1      29      P     false  true       false  0\n",
+		},
+	}
+
+	for i, test := range tests {
+		var t0 *table
+		var err error
+		if test.frames {
+			t0, err = frameTable(bytes.NewReader(testStream))
+		} else {
+			t0, err = nalTable(bytes.NewReader(testStream))
+		}
+		if err != nil {
+			t.Fatalf("did not expect error: %v for test: %d", err, i)
+		}
+		var buf bytes.Buffer
+		err = write(&buf, t0, test.format)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from write for test: %d", err, i)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %q\nWant: %q", i, got, test.want)
+		}
+	}
+
+	err := run(&bytes.Buffer{}, "", "xml", false)
+	if err != errBadFormat {
+		t.Errorf("did not get expected error for bad format\nGot: %v\nWant: %v", err, errBadFormat)
+	}
+}
@@ -1,8 +1,3 @@
 module github.com/ausocean/h264decode
 
-go 1.12
-
-require (
-	github.com/icza/bitio v0.0.0-20180221120200-b25b30b42508
-	github.com/pkg/errors v0.8.1
-)
+go 1.13
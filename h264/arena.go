@@ -0,0 +1,220 @@
+/*
+NAME
+  arena.go
+
+DESCRIPTION
+  arena.go provides an arena from which the temporaries used while decoding a
+  picture are allocated, being reset between pictures so that steady state
+  decoding makes no allocations.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "image"
+
+// arena allocates the temporaries used while decoding a picture, such as the
+// reference and prediction sample buffers of intra macroblocks, and holds the
+// macroblock state of the picture and the construction state of its
+// macroblocks. Allocations are only valid until
+// reset is called, which is done before decoding each picture, and so must not
+// be retained in decoded output. An arena must only be used by one goroutine
+// at a time.
+//
+// Allocations are made from a slab by advancing an offset. Those made while
+// constructing a macroblock are released once it is constructed, see mark.
+// When a slab is exhausted a new one of at least twice its size is allocated, and
+// earlier allocations continue to refer to the old slab; once the slabs have
+// grown to the size needed by the largest picture, no further allocations are
+// made.
+type arena struct {
+	// Slab and the offset of its first free element.
+	ints []int
+	intN int
+
+	mbs *mbState
+	mb  mbConstruction // Of the macroblock being constructed.
+
+	// pic is the frame into which pictures are constructed, see picture,
+	// held in buf with the given layout, and mono whether it is that of
+	// monochrome pictures. Frames have rows aligned to multiples of align
+	// bytes and a border of padding luma samples, see WithOutputLayout.
+	pic            *image.YCbCr
+	buf            []byte
+	layout         FrameLayout
+	mono           bool
+	align, padding int
+
+	// first is the header of the first slice of the picture, with which the
+	// headers of its other slices must be consistent, and poc its
+	// PicOrderCnt.
+	first *SliceHeader
+	poc   int
+
+	// refs are the reference frames from which the reference picture lists
+	// of slices, held by slice number in lists, are initialised, to which
+	// the picture is added once finished, with marked whether it has been,
+	// see refFrames.add. refs is nil for arenas of intra pictures decoded
+	// concurrently, which are added when collected.
+	refs   *refFrames
+	lists  []refPicLists
+	marked bool
+
+	// sps is that of the picture, slices the deblocking parameters of its
+	// slices, by slice number, and finished whether it has been completed,
+	// with the given flags, see finish. decoded is the Picture to which its
+	// slices have been added, if decoded sequentially.
+	sps      *SPS
+	slices   []sliceDeblocking
+	finished bool
+	flags    FrameFlags
+	decoded  *Picture
+}
+
+// minSlabSize is the initial size, in elements, of an arena slab.
+const minSlabSize = 1 << 10
+
+// reset frees all allocations made from the arena, and marks all macroblocks
+// of its macroblock state as not yet decoded, for decoding of a new picture.
+func (a *arena) reset() {
+	a.intN = 0
+	a.first = nil
+	a.slices = a.slices[:0]
+	a.lists = a.lists[:0]
+	a.marked = false
+	a.finished, a.flags, a.decoded = false, 0, nil
+	if a.mbs != nil {
+		a.mbs.reset()
+	}
+}
+
+// allocInts returns a zeroed slice of n ints.
+func (a *arena) allocInts(n int) []int {
+	if a.intN+n > len(a.ints) {
+		a.ints = make([]int, slabSize(len(a.ints), n))
+		a.intN = 0
+	}
+	b := a.ints[a.intN : a.intN+n : a.intN+n]
+	a.intN += n
+	for i := range b {
+		b[i] = 0
+	}
+	return b
+}
+
+// mark returns the state of the arena's allocations, to which they may be
+// returned by release.
+func (a *arena) mark() int {
+	return a.intN
+}
+
+// release frees the allocations made since m was returned by mark. Those of
+// a slab replaced since then are not freed.
+func (a *arena) release(m int) {
+	if m <= a.intN {
+		a.intN = m
+	}
+}
+
+// slabSize returns the size of a slab to replace one of size cur that cannot
+// hold an allocation of n elements.
+func slabSize(cur, n int) int {
+	size := 2 * cur
+	if size < minSlabSize {
+		size = minSlabSize
+	}
+	for size < n {
+		size *= 2
+	}
+	return size
+}
+
+// mbState returns the macroblock state for pictures of the given dimensions
+// in macroblocks, reusing that of previous pictures if they have the same
+// dimensions.
+func (a *arena) mbState(widthMbs, heightMbs int) *mbState {
+	if a.mbs == nil || a.mbs.widthMbs != widthMbs || a.mbs.n != widthMbs*heightMbs {
+		a.mbs = newMbState(widthMbs, heightMbs)
+	}
+	return a.mbs
+}
+
+// sliceLists returns the reference picture lists of the slice with header h,
+// using sps, being begun, initialised from the reference frames of the arena,
+// see refPicLists.init.
+func (a *arena) sliceLists(h *SliceHeader, sps *SPS) *refPicLists {
+	n := len(a.lists)
+	if n < cap(a.lists) {
+		a.lists = a.lists[:n+1]
+	} else {
+		a.lists = append(a.lists, refPicLists{})
+	}
+	l := &a.lists[n]
+	l.init(a.refs, h, sps, a.poc)
+	return l
+}
+
+// picture returns the frame into which the samples of pictures using sps are
+// constructed, with the layout given by decodeLayout, reusing that of
+// previous pictures if it has the same layout. The chroma planes of monochrome pictures,
+// which are not decoded, hold the mid value of 8 bit samples. nil is returned
+// for bit depths greater than 8, samples of which are not constructed, as
+// image.YCbCr holds 8 bit samples.
+func (a *arena) picture(sps *SPS) *image.YCbCr {
+	if sps.BitDepthLumaMinus8 > 0 || sps.BitDepthChromaMinus8 > 0 {
+		return nil
+	}
+	a.sps = sps
+	l := decodeLayout(sps, a.align, a.padding)
+	mono := sps.ChromaFormat == chromaMonochrome
+	if a.pic != nil && a.layout == l && a.mono == mono {
+		return a.pic
+	}
+	a.buf = make([]byte, l.Size)
+	a.pic, _ = l.Frame(a.buf) // Bit depth, the only error, is checked above.
+	a.layout, a.mono = l, mono
+	if mono {
+		for i := range a.pic.Cb {
+			a.pic.Cb[i], a.pic.Cr[i] = 128, 128
+		}
+	}
+	return a.pic
+}
+
+// finish completes the picture decoded since the arena was last reset, if it
+// has not already been, concealing its macroblocks that were not decoded, see
+// concealMbs, and deblocking it, see deblockPicture. flags is set to
+// FrameCorrupt if macroblocks were not decoded, with FrameConcealed if their
+// samples were concealed, and to FrameDegraded if inter macroblocks were
+// decoded, as their samples are not yet constructed, if its colour planes are
+// coded separately, as they are neither placed nor filtered, or if it is an
+// MBAFF frame, whose filtering is yet to be checked against the interlaced
+// conformance streams. This must be done once all slices of the picture have
+// been decoded, before the arena is reset for the next.
+func (a *arena) finish() {
+	if a.finished || a.mbs == nil || a.first == nil || len(a.slices) == 0 {
+		return
+	}
+	a.finished = true
+	for mbAddr := 0; mbAddr < a.mbs.n; mbAddr++ {
+		switch {
+		case a.mbs.sliceNum[mbAddr] < 0:
+			a.flags |= FrameCorrupt
+		case a.pic != nil && !featureInter && !a.mbs.has(mbAddr, mbIntraCoded):
+			a.flags |= FrameDegraded
+		}
+	}
+	if a.pic == nil {
+		return
+	}
+	if a.sps.UseSeparateColorPlane || a.mbs.mbaff {
+		a.flags |= FrameDegraded
+	}
+	if a.flags.Has(FrameCorrupt) {
+		concealMbs(a.pic, a.sps, a.first, a.mbs)
+		a.flags |= FrameConcealed
+	}
+	deblockPicture(a.pic, a.sps, a.first, a.mbs, a.slices, a.lists)
+}
@@ -0,0 +1,208 @@
+/*
+NAME
+  arena_test.go
+
+DESCRIPTION
+  arena_test.go provides testing for functionality provided in arena.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestArenaAlloc checks that arena allocations are zeroed, independent of one
+// another and bounded in capacity, including across slab growth, release and
+// reset.
+func TestArenaAlloc(t *testing.T) {
+	var a arena
+	for pic := 0; pic < 3; pic++ {
+		a.reset()
+		var ints [][]int
+		for i, n := range []int{16, 256, 3000, 1, 0, 700} {
+			m := a.mark()
+			a.allocInts(n)
+			a.release(m)
+			ints = append(ints, a.allocInts(n))
+			if len(ints[i]) != n || cap(ints[i]) != n {
+				t.Fatalf("did not get allocation of expected size for picture: %d, allocation: %d", pic, i)
+			}
+			for j := range ints[i] {
+				if ints[i][j] != 0 {
+					t.Fatalf("allocation not zeroed for picture: %d, allocation: %d", pic, i)
+				}
+				ints[i][j] = i + 1
+			}
+		}
+		for i := range ints {
+			for j := range ints[i] {
+				if ints[i][j] != i+1 {
+					t.Fatalf("allocation overwritten for picture: %d, allocation: %d", pic, i)
+				}
+			}
+		}
+	}
+}
+
+// TestArenaSteadyState checks that once grown, the arena makes no allocations
+// for pictures needing no more than earlier pictures.
+func TestArenaSteadyState(t *testing.T) {
+	var a arena
+	picture := func() {
+		a.reset()
+		a.mbState(120, 68)
+		for i := 0; i < 100; i++ {
+			a.allocInts(maxBinStringLen)
+		}
+	}
+	picture()
+	if n := testing.AllocsPerRun(10, picture); n != 0 {
+		t.Errorf("did not get expected allocations per picture\nGot: %v\nWant: 0", n)
+	}
+
+	mbs := a.mbState(120, 68)
+	mbs.sliceNum[0] = 0
+	a.reset()
+	if a.mbState(120, 68) != mbs || mbs.sliceNum[0] != -1 {
+		t.Error("expected macroblock state to be reused and reset")
+	}
+	if a.mbState(80, 45) == mbs {
+		t.Error("expected new macroblock state for new dimensions")
+	}
+}
+
+// TestConstructSteadyState checks that once the arena has grown, constructing
+// the macroblocks of a 1080p picture, coded in Intra_16x16 and Intra_4x4
+// prediction modes, makes no allocations.
+func TestConstructSteadyState(t *testing.T) {
+	const widthMbs, heightMbs = 120, 68
+	sps := &SPS{
+		ChromaFormat:              chroma420,
+		PicWidthInMbsMinus1:       widthMbs - 1,
+		PicHeightInMapUnitsMinus1: heightMbs - 1,
+		FrameMbsOnly:              true,
+	}
+	a := &arena{}
+	ctx := &SliceContext{
+		SPS:   sps,
+		PPS:   &PPS{ScalingMatrix: FlatScalingMatrix()},
+		Slice: &Slice{Header: &SliceHeader{ChromaArrayType: chroma420}},
+		arena: a,
+	}
+	// Intra_4x4 blocks use predIntra4x4PredMode, being DC.
+	d := &SliceData{
+		PrevIntra4x4PredModeFlag: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+		RemIntra4x4PredMode:      make([]int, 16),
+	}
+
+	var err error
+	picture := func() {
+		a.reset()
+		mbs := a.mbState(widthMbs, heightMbs)
+		d.pic = a.picture(sps)
+		sliceNum := mbs.startSlice()
+		for mbAddr := 0; mbAddr < mbs.n && err == nil; mbAddr++ {
+			mbs.beginMb(mbAddr, sliceNum, mbIntraCoded)
+			d.predMode, d.intra16x16PredMode = intra16x16, intraPredDC
+			if mbAddr%2 == 1 {
+				d.predMode = intra4x4
+			}
+			err = d.constructMb(ctx, mbs, mbAddr)
+		}
+	}
+	picture()
+	if n := testing.AllocsPerRun(5, picture); n != 0 {
+		t.Errorf("did not get expected allocations per picture\nGot: %v\nWant: 0", n)
+	}
+	if err != nil {
+		t.Errorf("did not expect error: %v", err)
+	}
+}
+
+// TestArenaFinish checks the flags of a 2x1 macroblock picture finished with
+// intra, inter and missing macroblocks, or with separate colour planes, and
+// of a 1x2 macroblock MBAFF frame, and that its samples are only concealed
+// for 8 bit pictures.
+func TestArenaFinish(t *testing.T) {
+	const missing = mbFlags(1 << 15) // Macroblock not decoded.
+	tests := []struct {
+		bitDepthMinus8 int
+		separate       bool // separate_colour_plane_flag.
+		mbaff          bool
+		mbFlags        [2]mbFlags
+		want           FrameFlags
+	}{
+		{mbFlags: [2]mbFlags{mbIntraCoded, mbIntraCoded}},
+		{mbFlags: [2]mbFlags{mbIntraCoded, missing}, want: FrameCorrupt | FrameConcealed},
+		{mbFlags: [2]mbFlags{mbIntraCoded, mbSkipped}, want: FrameDegraded},
+		{bitDepthMinus8: 2, mbFlags: [2]mbFlags{mbIntraCoded, missing}, want: FrameCorrupt},
+		{bitDepthMinus8: 2, mbFlags: [2]mbFlags{mbIntraCoded, 0}},
+		{separate: true, mbFlags: [2]mbFlags{mbIntraCoded, mbIntraCoded}, want: FrameDegraded},
+		{mbaff: true, mbFlags: [2]mbFlags{mbIntraCoded, mbIntraCoded}, want: FrameDegraded},
+	}
+
+	for i, test := range tests {
+		sps := &SPS{
+			ChromaFormat:          chroma420,
+			PicWidthInMbsMinus1:   1 - flagVal(test.mbaff),
+			FrameMbsOnly:          !test.mbaff,
+			MBAdaptiveFrameField:  test.mbaff,
+			BitDepthLumaMinus8:    test.bitDepthMinus8,
+			BitDepthChromaMinus8:  test.bitDepthMinus8,
+			UseSeparateColorPlane: test.separate,
+		}
+		a := &arena{first: &SliceHeader{ChromaArrayType: chroma420}, slices: []sliceDeblocking{{}}}
+		pic := a.picture(sps)
+		if pic != nil {
+			for j := range pic.Y {
+				pic.Y[j] = 10
+			}
+		}
+		mbs := a.mbState(2-flagVal(test.mbaff), 1+flagVal(test.mbaff))
+		mbs.mbaff = test.mbaff
+		sliceNum := mbs.startSlice()
+		for mbAddr, f := range test.mbFlags {
+			if f != missing {
+				mbs.beginMb(mbAddr, sliceNum, f)
+			}
+		}
+
+		a.finish()
+		if a.flags != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, a.flags, test.want)
+		}
+		concealed := pic != nil && pic.Y[pic.YOffset(20, 8)] == 128
+		if concealed != test.want.Has(FrameConcealed) {
+			t.Errorf("did not get expected concealment for test: %d\nGot: %v\nWant: %v", i, concealed, test.want.Has(FrameConcealed))
+		}
+	}
+}
+
+// TestFinishedPictureFlags checks that the flags of finished pictures are set
+// on the decoded pictures of a stream and their slices, the skipped
+// macroblocks of P slices not having their samples constructed.
+func TestFinishedPictureFlags(t *testing.T) {
+	stream := annexB(skipSlice(2, 1), skipSlice(2, 2))
+	r, err := NewH264Reader(bytes.NewReader(stream), WithSPS(testSPS), WithPPS(testPPS))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = r.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+	pics := r.VideoStreams[0].Pictures
+	if len(pics) != 2 {
+		t.Fatalf("did not get expected number of pictures\nGot: %d\nWant: 2", len(pics))
+	}
+	for i, p := range pics {
+		if !p.Flags.Has(FrameDegraded) || !p.Slices[0].Flags.Has(FrameDegraded) {
+			t.Errorf("did not get expected flags for picture: %d\nGot: %v, %v\nWant: %v", i, p.Flags, p.Slices[0].Flags, FrameDegraded)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/*
+NAME
+  assemble.go
+
+DESCRIPTION
+  assemble.go provides the assembly of decoded slices into the pictures to
+  which they belong, being those slices sharing frame_num, picture order
+  count and idr_pic_id, ordered by the macroblocks they cover.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "sort"
+
+// Picture holds the decoded slices of a coded picture, being a frame or a
+// field.
+type Picture struct {
+	IdrPic       bool
+	IDRPicID     int
+	FrameNum     int
+	Structure    PictureStructure
+	POC          PictureOrder
+	Flags        FrameFlags // Conditions applying to the frame the picture belongs to.
+	PicSizeInMbs int
+
+	// PPS is the PPS referred to by the slices of the picture, which may
+	// differ from that of other pictures of the stream.
+	PPS *PPS
+
+	// Slices holds the slices of the picture ordered by the address of their
+	// first macroblock, see CurrMbAddr.
+	Slices []*SliceContext
+
+	// NumMbs is the number of macroblocks covered by the slices.
+	NumMbs int
+}
+
+// Complete returns true if the slices of the picture cover all of its
+// macroblocks.
+func (p *Picture) Complete() bool {
+	return p.NumMbs >= p.PicSizeInMbs
+}
+
+// has returns true if the slice belongs to the picture. All slices of a
+// picture refer to the same PPS (7.4.3).
+func (p *Picture) has(ctx *SliceContext) bool {
+	h := ctx.Slice.Header
+	return h.PPSID == p.Slices[0].Slice.Header.PPSID &&
+		h.IdrPic == p.IdrPic &&
+		(!h.IdrPic || h.IDRPicID == p.IDRPicID) &&
+		h.FrameNum == p.FrameNum &&
+		h.Structure() == p.Structure &&
+		ctx.POC == p.POC
+}
+
+// firstMb returns the address of the first macroblock of the slice.
+func firstMb(ctx *SliceContext) int {
+	return CurrMbAddr(ctx.SPS, ctx.Slice.Header)
+}
+
+// add inserts the slice into the picture in order of its first macroblock,
+// returning false if the picture already has a slice beginning at the same
+// macroblock, in which case the slice must begin a new picture.
+func (p *Picture) add(ctx *SliceContext) bool {
+	addr := firstMb(ctx)
+	i := sort.Search(len(p.Slices), func(i int) bool { return firstMb(p.Slices[i]) >= addr })
+	if i < len(p.Slices) && firstMb(p.Slices[i]) == addr {
+		return false
+	}
+	p.Slices = append(p.Slices, nil)
+	copy(p.Slices[i+1:], p.Slices[i:])
+	p.Slices[i] = ctx
+	p.NumMbs += ctx.Slice.Data.NumMbs
+	p.Flags |= ctx.Flags
+	return true
+}
+
+// addFlags sets the flags f on the picture and its slices.
+func (p *Picture) addFlags(f FrameFlags) {
+	p.Flags |= f
+	for _, s := range p.Slices {
+		s.Flags |= f
+	}
+}
+
+// newPicture returns a Picture beginning with the given slice.
+func newPicture(ctx *SliceContext) *Picture {
+	h := ctx.Slice.Header
+	p := &Picture{
+		IdrPic:       h.IdrPic,
+		IDRPicID:     h.IDRPicID,
+		FrameNum:     h.FrameNum,
+		Structure:    h.Structure(),
+		POC:          ctx.POC,
+		PicSizeInMbs: PicSizeInMbs(ctx.SPS, h),
+		PPS:          ctx.PPS,
+	}
+	p.add(ctx)
+	return p
+}
+
+// addSlice appends the decoded slice to the stream, adding it to the last of
+// its pictures if it belongs to it, or otherwise beginning a new picture.
+func (v *VideoStream) addSlice(ctx *SliceContext) {
+	v.Slices = append(v.Slices, ctx)
+	if n := len(v.Pictures); n != 0 {
+		last := v.Pictures[n-1]
+		if last.has(ctx) && last.add(ctx) {
+			return
+		}
+	}
+	v.Pictures = append(v.Pictures, newPicture(ctx))
+}
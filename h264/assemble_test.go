@@ -0,0 +1,229 @@
+/*
+NAME
+  assemble_test.go
+
+DESCRIPTION
+  assemble_test.go provides testing for functionality provided in
+  assemble.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// assembleSlice returns a decoded slice of a 4 macroblock picture with the
+// given frame_num and picture order count, covering numMbs macroblocks from
+// firstMb.
+func assembleSlice(frameNum, poc, firstMb, numMbs int) *SliceContext {
+	return &SliceContext{
+		SPS: &SPS{PicWidthInMbsMinus1: 1, PicHeightInMapUnitsMinus1: 1, FrameMbsOnly: true},
+		Slice: &Slice{
+			Header: &SliceHeader{FrameNum: frameNum, FirstMbInSlice: firstMb},
+			Data:   &SliceData{NumMbs: numMbs},
+		},
+		POC: frameOrder(poc),
+	}
+}
+
+func TestAddSlice(t *testing.T) {
+	idr := assembleSlice(0, 0, 0, 4)
+	idr.Slice.Header.IdrPic = true
+	otherIDR := assembleSlice(0, 0, 0, 4)
+	otherIDR.Slice.Header.IdrPic = true
+	otherIDR.Slice.Header.IDRPicID = 1
+
+	otherPPS := assembleSlice(1, 2, 2, 2)
+	otherPPS.Slice.Header.PPSID = 1
+
+	tests := []struct {
+		name     string
+		slices   []*SliceContext
+		want     [][]int // First macroblock of the slices of each picture.
+		complete []bool
+	}{
+		{
+			name:     "slices of one picture out of order",
+			slices:   []*SliceContext{assembleSlice(1, 2, 2, 2), assembleSlice(1, 2, 0, 1), assembleSlice(1, 2, 1, 1)},
+			want:     [][]int{{0, 1, 2}},
+			complete: []bool{true},
+		},
+		{
+			name:     "frame_num",
+			slices:   []*SliceContext{assembleSlice(1, 2, 0, 2), assembleSlice(2, 2, 2, 2)},
+			want:     [][]int{{0}, {2}},
+			complete: []bool{false, false},
+		},
+		{
+			name:     "picture order count",
+			slices:   []*SliceContext{assembleSlice(1, 2, 0, 2), assembleSlice(1, 3, 2, 2)},
+			want:     [][]int{{0}, {2}},
+			complete: []bool{false, false},
+		},
+		{
+			name:     "pic_parameter_set_id",
+			slices:   []*SliceContext{assembleSlice(1, 2, 0, 2), otherPPS},
+			want:     [][]int{{0}, {2}},
+			complete: []bool{false, false},
+		},
+		{
+			name:     "idr_pic_id",
+			slices:   []*SliceContext{idr, otherIDR},
+			want:     [][]int{{0}, {0}},
+			complete: []bool{true, true},
+		},
+		{
+			name:     "repeated first macroblock",
+			slices:   []*SliceContext{assembleSlice(1, 2, 0, 2), assembleSlice(1, 2, 2, 2), assembleSlice(1, 2, 2, 2)},
+			want:     [][]int{{0, 2}, {2}},
+			complete: []bool{true, false},
+		},
+	}
+
+	for _, test := range tests {
+		var v VideoStream
+		for _, s := range test.slices {
+			v.addSlice(s)
+		}
+		if len(v.Slices) != len(test.slices) {
+			t.Errorf("did not get expected number of slices for test: %s\nGot: %d\nWant: %d", test.name, len(v.Slices), len(test.slices))
+		}
+		var got [][]int
+		var complete []bool
+		for _, p := range v.Pictures {
+			var mbs []int
+			for _, s := range p.Slices {
+				mbs = append(mbs, s.Slice.Header.FirstMbInSlice)
+			}
+			got = append(got, mbs)
+			complete = append(complete, p.Complete())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected pictures for test: %s\nGot: %v\nWant: %v", test.name, got, test.want)
+		}
+		if !reflect.DeepEqual(complete, test.complete) {
+			t.Errorf("did not get expected completeness for test: %s\nGot: %v\nWant: %v", test.name, complete, test.complete)
+		}
+	}
+}
+
+// TestStreamPictures checks that the slices decoded by an H264Reader are
+// assembled into pictures.
+func TestStreamPictures(t *testing.T) {
+	stream := annexB(testSPS, testPPS, skipSlice(2, 1), skipSlice(2, 2), skipSlice(0, 3))
+	h, err := NewH264Reader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = h.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+
+	pictures := h.VideoStreams[0].Pictures
+	want := []int{1, 2, 3}
+	if len(pictures) != len(want) {
+		t.Fatalf("did not get expected number of pictures\nGot: %d\nWant: %d", len(pictures), len(want))
+	}
+	for i, p := range pictures {
+		if p.FrameNum != want[i] || len(p.Slices) != 1 || !p.Complete() {
+			t.Errorf("did not get expected picture: %d\nGot: %+v", i, p)
+		}
+	}
+}
+
+// multiPPS returns a CAVLC PPS NAL unit referring to testSPS with the given
+// pic_parameter_set_id and pic_init_qp_minus26, and with
+// deblocking_filter_control_present_flag set if deblock, as produced by
+// encoders switching PPS from frame to frame.
+func multiPPS(id, initQPMinus26 int, deblock bool) []byte {
+	deblockBit := "0"
+	if deblock {
+		deblockBit = "1"
+	}
+	return append([]byte{3<<5 | byte(NALTypePPS)}, binToSlice(
+		ueBits(id)+ueBits(0)+"0 0"+ // pic_parameter_set_id, seq_parameter_set_id, entropy_coding_mode_flag, bottom_field_pic_order_in_frame_present_flag.
+			ueBits(0)+ueBits(0)+ueBits(0)+"0 00"+ // num_slice_groups_minus1, num_ref_idx_l0/l1_default_active_minus1, weighted_pred_flag, weighted_bipred_idc.
+			seBits(initQPMinus26)+seBits(0)+seBits(0)+ // pic_init_qp_minus26, pic_init_qs_minus26, chroma_qp_index_offset.
+			deblockBit+"0 0"+ // deblocking_filter_control_present_flag, constrained_intra_pred_flag, redundant_pic_cnt_present_flag.
+			"1", // rbsp_stop_one_bit.
+	)...)
+}
+
+// multiPPSSlice returns a reference P slice NAL unit like skipSlice, but
+// referring to the PPS given by multiPPS with the given ID, and disabling the
+// deblocking filter if the PPS has deblocking_filter_control_present_flag
+// set.
+func multiPPSSlice(ppsID, frameNum int, deblock bool) []byte {
+	deblockBits := ""
+	if deblock {
+		deblockBits = ueBits(1) // disable_deblocking_filter_idc.
+	}
+	return append([]byte{2<<5 | byte(NALTypeSliceNonIDRPicture)}, binToSlice(
+		ueBits(0)+ueBits(5)+ueBits(ppsID)+fmt.Sprintf("%04b", frameNum)+ // first_mb_in_slice, slice_type, pic_parameter_set_id, frame_num.
+			"0 0 0"+ // num_ref_idx_active_override_flag, ref_pic_list_modification_flag_l0, adaptive_ref_pic_marking_mode_flag.
+			seBits(0)+deblockBits+ // slice_qp_delta.
+			ueBits(1)+ // mb_skip_run.
+			"1", // rbsp_stop_one_bit.
+	)...)
+}
+
+// TestMultiplePPS checks that each slice of a stream with several PPS
+// referring to its SPS is decoded using the PPS it refers to, including after
+// a PPS is replaced mid-stream, and that pictures are split where the PPS
+// changes.
+func TestMultiplePPS(t *testing.T) {
+	stream := annexB(
+		testSPS,
+		multiPPS(0, 0, true),
+		multiPPS(1, 6, false),
+		multiPPSSlice(0, 1, true),
+		multiPPSSlice(1, 2, false),
+		multiPPSSlice(0, 3, true),
+		multiPPS(1, -4, true), // Replaces PPS 1 between pictures.
+		multiPPSSlice(1, 4, true),
+		multiPPSSlice(0, 4, true), // Same frame_num, but a new picture as the PPS differs.
+	)
+	h, err := NewH264Reader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = h.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+
+	if len(h.VideoStreams) != 1 {
+		t.Fatalf("did not get expected number of video streams\nGot: %d\nWant: 1", len(h.VideoStreams))
+	}
+	vs := h.VideoStreams[0]
+	wantPPS := []int{0, 1, 0, 1, 0}
+	wantQP := []int{26, 32, 26, 22, 26}
+	wantDeblock := []int{1, 0, 1, 1, 1}
+	if len(vs.Slices) != len(wantPPS) || len(vs.Pictures) != len(wantPPS) {
+		t.Fatalf("did not get expected number of slices and pictures\nGot: %d, %d\nWant: %d", len(vs.Slices), len(vs.Pictures), len(wantPPS))
+	}
+	for i, ctx := range vs.Slices {
+		got := []int{ctx.PPS.ID, SliceQPy(ctx.PPS, ctx.Slice.Header), ctx.Slice.Header.DisableDeblockingFilter}
+		want := []int{wantPPS[i], wantQP[i], wantDeblock[i]}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("did not get expected PPS ID, SliceQPY and disable_deblocking_filter_idc for slice: %d\nGot: %v\nWant: %v", i, got, want)
+		}
+		if vs.Pictures[i].PPS != ctx.PPS {
+			t.Errorf("did not get expected PPS for picture: %d", i)
+		}
+	}
+	if vs.Slices[1].PPS == vs.Slices[3].PPS {
+		t.Errorf("expected replaced PPS to be used after replacement")
+	}
+	if vs.PPS != vs.Slices[4].PPS {
+		t.Errorf("expected video stream PPS to be that of the last slice")
+	}
+}
@@ -0,0 +1,122 @@
+/*
+NAME
+  ausize.go
+
+DESCRIPTION
+  ausize.go provides tracking of coded access unit sizes against their
+  expected size, raising events for access units that exceed it by a
+  configurable multiple, as is typical of encoder panic frames.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "fmt"
+
+const (
+	// auSizeWarmup is the number of access units of a kind, IDR or non-IDR,
+	// that must be seen before their running mean size is used as the
+	// expected size.
+	auSizeWarmup = 4
+
+	// auSizeWeight is the weight given to each new access unit size in the
+	// running mean.
+	auSizeWeight = 1.0 / 8
+)
+
+// auSizeMonitor tracks the size of access units, see WithAUSizeLimit.
+type auSizeMonitor struct {
+	multiple float64
+
+	// The access unit being read.
+	size   int   // Coded size in bytes, over all of its NAL units.
+	offset int64 // Stream byte offset of its first NAL unit.
+	sawVCL bool
+	idr    bool
+
+	// Running mean size and count of non-IDR and IDR access units, indexed
+	// by flagVal(idr).
+	mean [2]float64
+	n    [2]int
+}
+
+// trackAUSize adds the NAL unit to the size of the current access unit,
+// first checking the size of the previous access unit if the NAL unit begins
+// a new one.
+func (h *H264Reader) trackAUSize(nalUnit *NalUnit) {
+	m := h.auSize
+	if m == nil {
+		return
+	}
+	if m.sawVCL && startsAccessUnit(nalUnit) {
+		h.endAU()
+	}
+	if m.size == 0 {
+		m.offset = nalUnit.Offset
+	}
+	m.size += nalUnit.NumBytes
+	if nalUnit.Type.IsVCL() {
+		m.sawVCL = true
+		m.idr = m.idr || nalUnit.Type.IsIDR()
+	}
+}
+
+// endAU checks the size of the current access unit against the expected size,
+// emitting an EventAUSizeExceeded event if it exceeds the configured multiple,
+// and begins a new access unit.
+func (h *H264Reader) endAU() {
+	m := h.auSize
+	if m == nil || !m.sawVCL {
+		return
+	}
+	k := flagVal(m.idr)
+	expected, ok := hrdAUSize(h.ParameterSets.last)
+	if !ok && m.n[k] >= auSizeWarmup {
+		expected, ok = m.mean[k], true
+	}
+
+	size := float64(m.size)
+	if ok && size > m.multiple*expected {
+		var flags FrameFlags
+		if m.idr {
+			flags |= FrameKeyframe
+		}
+		h.emit(Event{
+			Type:   EventAUSizeExceeded,
+			Offset: int(m.offset),
+			Flags:  flags,
+			Detail: fmt.Sprintf("access unit of %d bytes exceeds %g times expected size of %.0f bytes", m.size, m.multiple, expected),
+		})
+	} else {
+		// Oversized access units are excluded so as not to raise the mean.
+		if m.n[k] == 0 {
+			m.mean[k] = size
+		}
+		m.mean[k] += auSizeWeight * (size - m.mean[k])
+		m.n[k]++
+	}
+	m.size, m.sawVCL, m.idr = 0, false, false
+}
+
+// hrdAUSize returns the expected size in bytes of an access unit of a stream
+// using the given SPS, being the bit rate of the first HRD schedule divided by
+// the frame rate given by the VUI timing information. False is returned if
+// these are not present.
+func hrdAUSize(sps *SPS) (float64, bool) {
+	if sps == nil || sps.VUI == nil {
+		return 0, false
+	}
+	vui := sps.VUI
+	hrd := vui.NalHRD
+	if hrd == nil {
+		hrd = vui.VclHRD
+	}
+	if hrd == nil || len(hrd.BitRateValueMinus1) == 0 || !vui.TimingInfoPresent || vui.NumUnitsInTick == 0 || vui.TimeScale == 0 {
+		return 0, false
+	}
+	// A frame lasts two ticks (see equation C-13 and section E.2.1).
+	frameDuration := 2 * float64(vui.NumUnitsInTick) / float64(vui.TimeScale)
+	return float64(hrd.BitRate(0)) / 8 * frameDuration, true
+}
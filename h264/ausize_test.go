@@ -0,0 +1,120 @@
+/*
+NAME
+  ausize_test.go
+
+DESCRIPTION
+  ausize_test.go provides testing for functionality in ausize.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// fillerNAL returns a filler data NAL unit of n bytes.
+func fillerNAL(n int) []byte {
+	b := bytes.Repeat([]byte{0xff}, n)
+	b[0], b[n-1] = 0x0c, 0x80
+	return b
+}
+
+// hrdSPS returns testSPS with VUI timing of 10 frames per second and an HRD
+// bit rate of 80000 bits per second, i.e. an expected access unit size of
+// 1000 bytes.
+func hrdSPS(t *testing.T) []byte {
+	nalUnit, err := NewNalUnit(testSPS, len(testSPS))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewNalUnit", err)
+	}
+	sps, err := NewSPS(nalUnit.RBSP(), false)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewSPS", err)
+	}
+	sps.VuiParametersPresent = true
+	sps.VUI = &VUIParameters{
+		TimingInfoPresent:       true,
+		NumUnitsInTick:          1,
+		TimeScale:               20,
+		NalHRDParametersPresent: true,
+		NalHRD: &HRDParameters{
+			BitRateValueMinus1: []int{1249},
+			CpbSizeValueMinus1: []int{1000},
+			Cbr:                []bool{true},
+		},
+	}
+	var buf bytes.Buffer
+	err = sps.Write(&buf)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Write", err)
+	}
+	return buf.Bytes()
+}
+
+func TestAUSizeLimit(t *testing.T) {
+	hrd := hrdSPS(t)
+	tests := []struct {
+		nalUnits [][]byte
+		want     []int // Indices of the NAL units starting oversized access units.
+	}{
+		// Running mean: the sixth access unit is oversized.
+		{
+			nalUnits: [][]byte{testSPS, testPPS, testIDR, testNonIDR, testNonIDR, testNonIDR, testNonIDR, testNonIDR, fillerNAL(20), testNonIDR},
+			want:     []int{7},
+		},
+		// Running mean: too few access units seen to judge.
+		{
+			nalUnits: [][]byte{testSPS, testPPS, testIDR, testNonIDR, fillerNAL(20), testNonIDR},
+		},
+		// HRD: expected size of 1000 bytes applies from the first access unit.
+		{
+			nalUnits: [][]byte{hrd, testPPS, testIDR, fillerNAL(4000), testNonIDR, fillerNAL(2000), testNonIDR},
+			want:     []int{0},
+		},
+	}
+
+	for i, test := range tests {
+		// Stream offsets of each NAL unit.
+		var offs []int
+		for j := range test.nalUnits {
+			offs = append(offs, len(annexB(test.nalUnits[:j]...))+len(InitialNALU))
+		}
+
+		var got []int
+		r, err := NewH264Reader(
+			bytes.NewReader(annexB(test.nalUnits...)),
+			WithAUSizeLimit(3),
+			WithRecovery(), // Sizes are tracked whether or not slices decode.
+			WithEventHandler(func(e Event) {
+				if e.Type != EventAUSizeExceeded {
+					return
+				}
+				for j, off := range offs {
+					if off == e.Offset {
+						got = append(got, j)
+					}
+				}
+			}),
+		)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader for test: %d", err, i)
+		}
+		err = r.Start()
+		if err != nil {
+			t.Fatalf("did not expect error: %v from Start for test: %d", err, i)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+
+	_, err := NewH264Reader(bytes.NewReader(nil), WithAUSizeLimit(0))
+	if err == nil {
+		t.Errorf("expected error for non-positive multiple")
+	}
+}
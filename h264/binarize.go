@@ -0,0 +1,233 @@
+/*
+NAME
+  binarize.go
+
+DESCRIPTION
+  binarize.go provides the binarization processes of CABAC, as specified in
+  section 9.3.2 of the specifications, both for forming the bin string of a
+  value and for decoding a value from bins, so that the decoding of each
+  syntax element may be composed of them.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// binReader returns the decoded value of the bin with index binIdx of the bin
+// string of a syntax element. Bins are read in order of binIdx, so that a
+// binReader may select the context, or bypass decoding, for each bin.
+type binReader func(binIdx int) (int, error)
+
+// readUnary decodes a value using the unary (U) binarization of 9.3.2.1, the
+// value being the number of bins equal to 1 before the first bin equal to 0.
+func readUnary(r binReader) (int, error) {
+	return readTruncatedUnary(r, -1)
+}
+
+// readTruncatedUnary decodes a value using the truncated unary (TU)
+// binarization of 9.3.2.2 with the given cMax, the bin string of cMax having
+// no terminating bin equal to 0. A negative cMax gives the unary binarization.
+func readTruncatedUnary(r binReader, cMax int) (int, error) {
+	for v := 0; v != cMax; v++ {
+		b, err := r(v)
+		if err != nil {
+			return v, fmt.Errorf("could not read bin %d: %w", v, err)
+		}
+		if b == 0 {
+			return v, nil
+		}
+	}
+	return cMax, nil
+}
+
+// readFixedLength decodes a value using the fixed-length (FL) binarization
+// of 9.3.2.5 with the given cMax, the bin with index 0 being the least
+// significant bit.
+func readFixedLength(r binReader, cMax int) (int, error) {
+	var v int
+	for binIdx := 0; binIdx < fixedLength(cMax); binIdx++ {
+		b, err := r(binIdx)
+		if err != nil {
+			return v, fmt.Errorf("could not read bin %d: %w", binIdx, err)
+		}
+		v |= b << uint(binIdx)
+	}
+	return v, nil
+}
+
+// fixedLength returns the number of bins of the fixed-length binarization
+// with the given cMax, that is Ceil(Log2(cMax + 1)).
+func fixedLength(cMax int) int {
+	var n int
+	for (1 << uint(n)) < cMax+1 {
+		n++
+	}
+	return n
+}
+
+// readUEGk decodes a value using the concatenated unary/k-th order Exp-Golomb
+// (UEGk) binarization of 9.3.2.3. The prefix, a truncated unary bin string
+// with cMax uCoff, is read using prefix, and any suffix, a k-th order
+// Exp-Golomb bin string followed by a sign bin if signed and the value is not
+// 0, using suffix. The bins of the suffix are indexed from uCoff.
+func readUEGk(prefix, suffix binReader, signed bool, uCoff, k int) (int, error) {
+	v, err := readTruncatedUnary(prefix, uCoff)
+	if err != nil {
+		return 0, fmt.Errorf("could not read prefix: %w", err)
+	}
+
+	binIdx := uCoff
+	next := func() (int, error) {
+		b, err := suffix(binIdx)
+		if err != nil {
+			return 0, fmt.Errorf("could not read suffix bin %d: %w", binIdx, err)
+		}
+		binIdx++
+		return b, nil
+	}
+
+	if v == uCoff {
+		for {
+			b, err := next()
+			if err != nil {
+				return 0, err
+			}
+			if b == 0 {
+				break
+			}
+			v += 1 << uint(k)
+			k++
+			if k > maxEGk {
+				return 0, errEGkOverflow
+			}
+		}
+		for k--; k >= 0; k-- {
+			b, err := next()
+			if err != nil {
+				return 0, err
+			}
+			v += b << uint(k)
+		}
+	}
+
+	if signed && v != 0 {
+		b, err := next()
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			v = -v
+		}
+	}
+	return v, nil
+}
+
+// maxEGk is the greatest order reached by the Exp-Golomb suffix of a UEGk
+// bin string, beyond which the value would exceed the range of any syntax
+// element and the bins are taken to be corrupt.
+const maxEGk = 30
+
+// readMbQpDelta decodes mb_qp_delta, whose bin string is the unary
+// binarization of the mapped value of Table 9-3, as specified by 9.3.2.7.
+func readMbQpDelta(r binReader) (int, error) {
+	v, err := readUnary(r)
+	if err != nil {
+		return 0, err
+	}
+	if v%2 == 0 {
+		return -v / 2, nil
+	}
+	return (v + 1) / 2, nil
+}
+
+// readCodedBlockPattern decodes coded_block_pattern using the concatenated
+// binarization of 9.3.2.6. The prefix, the fixed-length binarization of
+// CodedBlockPatternLuma with cMax 15, is read using prefix and, if
+// chromaArrayType is 1 or 2, the suffix, the truncated unary binarization of
+// CodedBlockPatternChroma with cMax 2, using suffix.
+func readCodedBlockPattern(prefix, suffix binReader, chromaArrayType int) (int, error) {
+	luma, err := readFixedLength(prefix, 15)
+	if err != nil {
+		return 0, fmt.Errorf("could not read prefix: %w", err)
+	}
+	if chromaArrayType != 1 && chromaArrayType != 2 {
+		return luma, nil
+	}
+	chroma, err := readTruncatedUnary(suffix, 2)
+	if err != nil {
+		return 0, fmt.Errorf("could not read suffix: %w", err)
+	}
+	return luma + chroma<<4, nil
+}
+
+// unaryBins returns the bin string of v using the unary binarization.
+func unaryBins(v int) []int {
+	return truncatedUnaryBins(v, -1)
+}
+
+// truncatedUnaryBins returns the bin string of v using the truncated unary
+// binarization with the given cMax, or the unary binarization if cMax is
+// negative.
+func truncatedUnaryBins(v, cMax int) []int {
+	bins := make([]int, v, v+1)
+	for i := range bins {
+		bins[i] = 1
+	}
+	if v != cMax {
+		bins = append(bins, 0)
+	}
+	return bins
+}
+
+// fixedLengthBins returns the bin string of v using the fixed-length
+// binarization with the given cMax.
+func fixedLengthBins(v, cMax int) []int {
+	bins := make([]int, fixedLength(cMax))
+	for i := range bins {
+		bins[i] = (v >> uint(i)) & 1
+	}
+	return bins
+}
+
+// uegkBins returns the bin string of v using the UEGk binarization with the
+// given signedValFlag, uCoff and k, as specified by the pseudo-code of
+// 9.3.2.3.
+func uegkBins(v int, signed bool, uCoff, k int) []int {
+	a := abs(v)
+	if a < uCoff {
+		return append(truncatedUnaryBins(a, uCoff), signBins(v, signed)...)
+	}
+	bins := truncatedUnaryBins(uCoff, uCoff)
+	sufS := a - uCoff
+	for sufS >= 1<<uint(k) {
+		bins = append(bins, 1)
+		sufS -= 1 << uint(k)
+		k++
+	}
+	bins = append(bins, 0)
+	for k--; k >= 0; k-- {
+		bins = append(bins, (sufS>>uint(k))&1)
+	}
+	return append(bins, signBins(v, signed)...)
+}
+
+// signBins returns the sign bin of a UEGk bin string of v, which is present
+// only if signed and v is not 0.
+func signBins(v int, signed bool) []int {
+	switch {
+	case !signed || v == 0:
+		return nil
+	case v > 0:
+		return []int{0}
+	default:
+		return []int{1}
+	}
+}
+
+var errEGkOverflow = errors.New("Exp-Golomb suffix of UEGk bin string too long")
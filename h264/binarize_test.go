@@ -0,0 +1,233 @@
+/*
+NAME
+  binarize_test.go
+
+DESCRIPTION
+  binarize_test.go provides testing for functionality provided in binarize.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// binString returns a binReader reading the given bins in order, returning
+// io.EOF once they are exhausted. The binIdx of each read is appended to
+// idxs if not nil.
+func binString(bins []int, idxs *[]int) binReader {
+	var i int
+	return func(binIdx int) (int, error) {
+		if i == len(bins) {
+			return 0, io.EOF
+		}
+		if idxs != nil {
+			*idxs = append(*idxs, binIdx)
+		}
+		i++
+		return bins[i-1], nil
+	}
+}
+
+func TestBinarizations(t *testing.T) {
+	tests := []struct {
+		name string
+		v    int
+		bins []int
+		enc  func(v int) []int
+		dec  func(r binReader) (int, error)
+	}{
+		{
+			name: "U",
+			v:    3,
+			bins: []int{1, 1, 1, 0},
+			enc:  unaryBins,
+			dec:  readUnary,
+		},
+		{
+			name: "U zero",
+			v:    0,
+			bins: []int{0},
+			enc:  unaryBins,
+			dec:  readUnary,
+		},
+		{
+			name: "TU cMax",
+			v:    3,
+			bins: []int{1, 1, 1},
+			enc:  func(v int) []int { return truncatedUnaryBins(v, 3) },
+			dec:  func(r binReader) (int, error) { return readTruncatedUnary(r, 3) },
+		},
+		{
+			name: "TU below cMax",
+			v:    2,
+			bins: []int{1, 1, 0},
+			enc:  func(v int) []int { return truncatedUnaryBins(v, 3) },
+			dec:  func(r binReader) (int, error) { return readTruncatedUnary(r, 3) },
+		},
+		{
+			name: "FL",
+			v:    6,
+			bins: []int{0, 1, 1},
+			enc:  func(v int) []int { return fixedLengthBins(v, 7) },
+			dec:  func(r binReader) (int, error) { return readFixedLength(r, 7) },
+		},
+		{
+			name: "FL cMax 1",
+			v:    1,
+			bins: []int{1},
+			enc:  func(v int) []int { return fixedLengthBins(v, 1) },
+			dec:  func(r binReader) (int, error) { return readFixedLength(r, 1) },
+		},
+		{
+			name: "UEG0 prefix only",
+			v:    13,
+			bins: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0},
+			enc:  func(v int) []int { return uegkBins(v, false, 14, 0) },
+			dec:  func(r binReader) (int, error) { return readUEGk(r, r, false, 14, 0) },
+		},
+		{
+			name: "UEG0 uCoff",
+			v:    14,
+			bins: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0},
+			enc:  func(v int) []int { return uegkBins(v, false, 14, 0) },
+			dec:  func(r binReader) (int, error) { return readUEGk(r, r, false, 14, 0) },
+		},
+		{
+			name: "UEG0 suffix",
+			v:    15,
+			bins: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0},
+			enc:  func(v int) []int { return uegkBins(v, false, 14, 0) },
+			dec:  func(r binReader) (int, error) { return readUEGk(r, r, false, 14, 0) },
+		},
+		{
+			name: "UEG3 signed zero",
+			v:    0,
+			bins: []int{0},
+			enc:  func(v int) []int { return uegkBins(v, true, 9, 3) },
+			dec:  func(r binReader) (int, error) { return readUEGk(r, r, true, 9, 3) },
+		},
+		{
+			name: "UEG3 signed negative",
+			v:    -2,
+			bins: []int{1, 1, 0, 1},
+			enc:  func(v int) []int { return uegkBins(v, true, 9, 3) },
+			dec:  func(r binReader) (int, error) { return readUEGk(r, r, true, 9, 3) },
+		},
+		{
+			name: "UEG3 signed suffix",
+			v:    19,
+			bins: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 1, 0, 0},
+			enc:  func(v int) []int { return uegkBins(v, true, 9, 3) },
+			dec:  func(r binReader) (int, error) { return readUEGk(r, r, true, 9, 3) },
+		},
+	}
+
+	for _, test := range tests {
+		bins := test.enc(test.v)
+		if !reflect.DeepEqual(bins, test.bins) {
+			t.Errorf("did not get expected bin string for test: %s\nGot: %v\nWant: %v", test.name, bins, test.bins)
+		}
+		got, err := test.dec(binString(test.bins, nil))
+		if err != nil {
+			t.Errorf("did not expect error: %v for test: %s", err, test.name)
+			continue
+		}
+		if got != test.v {
+			t.Errorf("did not get expected result for test: %s\nGot: %v\nWant: %v", test.name, got, test.v)
+		}
+	}
+}
+
+// TestUEGkRoundTrip checks that values decode from their own UEGk bin
+// strings, the bins of the suffix being indexed from uCoff.
+func TestUEGkRoundTrip(t *testing.T) {
+	for _, k := range []int{0, 3} {
+		for v := -300; v <= 300; v++ {
+			var prefix, suffix []int
+			bins := uegkBins(v, true, 9, k)
+			r := binString(bins, nil)
+			got, err := readUEGk(
+				func(binIdx int) (int, error) { prefix = append(prefix, binIdx); return r(binIdx) },
+				func(binIdx int) (int, error) { suffix = append(suffix, binIdx); return r(binIdx) },
+				true, 9, k,
+			)
+			if err != nil || got != v {
+				t.Fatalf("did not get expected result for k: %d\nGot: %v, %v\nWant: %v", k, got, err, v)
+			}
+			if len(prefix)+len(suffix) != len(bins) || (len(suffix) != 0 && suffix[0] != 9) {
+				t.Errorf("did not get expected bin indices for k: %d v: %d\nGot: %v, %v", k, v, prefix, suffix)
+			}
+		}
+	}
+}
+
+func TestReadMbQpDelta(t *testing.T) {
+	tests := []struct {
+		bins []int
+		want int
+	}{
+		{bins: []int{0}, want: 0},
+		{bins: []int{1, 0}, want: 1},
+		{bins: []int{1, 1, 0}, want: -1},
+		{bins: []int{1, 1, 1, 0}, want: 2},
+		{bins: []int{1, 1, 1, 1, 0}, want: -2},
+	}
+
+	for i, test := range tests {
+		got, err := readMbQpDelta(binString(test.bins, nil))
+		if err != nil {
+			t.Fatalf("did not expect error: %v for test: %d", err, i)
+		}
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestReadCodedBlockPattern(t *testing.T) {
+	tests := []struct {
+		prefix, suffix  []int
+		chromaArrayType int
+		want            int
+	}{
+		{prefix: []int{1, 0, 1, 1}, suffix: []int{1, 0}, chromaArrayType: 1, want: 13 | 1<<4},
+		{prefix: []int{1, 1, 1, 1}, suffix: []int{1, 1}, chromaArrayType: 2, want: 15 | 2<<4},
+		{prefix: []int{0, 0, 0, 0}, suffix: []int{0}, chromaArrayType: 1, want: 0},
+		{prefix: []int{0, 1, 0, 0}, chromaArrayType: 0, want: 2},
+		{prefix: []int{0, 1, 0, 0}, chromaArrayType: 3, want: 2},
+	}
+
+	for i, test := range tests {
+		var idxs []int
+		got, err := readCodedBlockPattern(binString(test.prefix, &idxs), binString(test.suffix, &idxs), test.chromaArrayType)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for test: %d", err, i)
+		}
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+		if len(idxs) != len(test.prefix)+len(test.suffix) {
+			t.Errorf("did not read expected number of bins for test: %d\nGot: %v", i, idxs)
+		}
+	}
+}
+
+func TestBinarizationErrors(t *testing.T) {
+	_, err := readTruncatedUnary(binString([]int{1, 1}, nil), 3)
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("did not get expected error from truncated bin string\nGot: %v\nWant: %v", err, io.EOF)
+	}
+
+	ones := func(int) (int, error) { return 1, nil }
+	_, err = readUEGk(ones, ones, false, 14, 0)
+	if !errors.Is(err, errEGkOverflow) {
+		t.Errorf("did not get expected error from unterminated suffix\nGot: %v\nWant: %v", err, errEGkOverflow)
+	}
+}
@@ -55,14 +55,27 @@ LICENSE
 */
 
 // Package bits provides a bit reader implementation that can read or peek from
-// an io.Reader data source.
+// an io.Reader data source, and a bit writer that can write to an io.Writer.
 package bits
 
 import (
 	"bufio"
+	"errors"
 	"io"
 )
 
+// ErrBadBitCount is returned when asked to read, peek or write a number of bits
+// outside the range 0 to 64.
+var ErrBadBitCount = errors.New("bits: bit count must be in range 0 to 64")
+
+// ErrNotAligned is returned by ReadByte and Read when the reader position is
+// not at the start of a byte, see AlignByte.
+var ErrNotAligned = errors.New("bits: reader is not byte aligned")
+
+// ErrNotSeekable is returned by Seek when the source of the reader is not an
+// io.Seeker.
+var ErrNotSeekable = errors.New("bits: source is not seekable")
+
 type bytePeeker interface {
 	io.ByteReader
 	Peek(int) ([]byte, error)
@@ -71,19 +84,32 @@ type bytePeeker interface {
 // BitReader is a bit reader that provides methods for reading bits from an
 // io.Reader source.
 type BitReader struct {
-	r     bytePeeker
-	n     uint64
-	bits  int
-	nRead int
+	r      bytePeeker
+	src    io.Reader     // Source given to NewBitReader.
+	buf    *bufio.Reader // Buffer of src if it is not a bytePeeker, else nil.
+	n      uint64
+	bits   int
+	nRead  int
+	tracer Tracer
+}
+
+// Tracer records the reads of a BitReader, see SetTracer.
+type Tracer interface {
+	// Read is called after each successful read of n bits, with the bit
+	// offset of the first bit read and the value read.
+	Read(off, n int, v uint64)
 }
 
 // NewBitReader returns a new BitReader.
 func NewBitReader(r io.Reader) *BitReader {
+	br := &BitReader{src: r}
 	byter, ok := r.(bytePeeker)
 	if !ok {
-		byter = bufio.NewReader(r)
+		br.buf = bufio.NewReader(r)
+		byter = br.buf
 	}
-	return &BitReader{r: byter}
+	br.r = byter
+	return br
 }
 
 // ReadBits reads n bits from the source and returns them the least-significant
@@ -95,9 +121,12 @@ func NewBitReader(r io.Reader) *BitReader {
 // n = 4, res = 0xf (1111)
 // n = 6, res = 0x23 (0010 0011)
 func (br *BitReader) ReadBits(n int) (uint64, error) {
+	if n < 0 || n > 64 {
+		return 0, ErrBadBitCount
+	}
 	for n > br.bits {
 		b, err := br.r.ReadByte()
-		if err == io.EOF {
+		if errors.Is(err, io.EOF) {
 			return 0, io.ErrUnexpectedEOF
 		}
 		if err != nil {
@@ -125,9 +154,23 @@ func (br *BitReader) ReadBits(n int) (uint64, error) {
 	// least-significant places and masks off anything above.
 	r := (br.n >> uint(br.bits-n)) & ((1 << uint(n)) - 1)
 	br.bits -= n
+	if br.tracer != nil {
+		br.tracer.Read(br.Off()-n, n, r)
+	}
 	return r, nil
 }
 
+// SetTracer sets a Tracer to be given each subsequent read, or removes it if t
+// is nil. Peeks are not traced.
+func (br *BitReader) SetTracer(t Tracer) {
+	br.tracer = t
+}
+
+// Tracer returns the Tracer given to SetTracer, or nil if there is none.
+func (br *BitReader) Tracer() Tracer {
+	return br.tracer
+}
+
 // PeekBits provides the next n bits returning them in the least-significant
 // part of a uint64, without advancing through the source.
 // For example, with a source as []byte{0x8f,0xe3} (1000 1111, 1110 0011), we
@@ -136,25 +179,27 @@ func (br *BitReader) ReadBits(n int) (uint64, error) {
 // n = 8, res = 0x8f (1000 1111)
 // n = 16, res = 0x8fe3 (1000 1111, 1110 0011)
 func (br *BitReader) PeekBits(n int) (uint64, error) {
+	if n < 0 || n > 64 {
+		return 0, ErrBadBitCount
+	}
 	byt, err := br.r.Peek(int((n-br.bits)+7) / 8)
 	bits := br.bits
 	if err != nil {
-		if err == io.EOF {
+		if errors.Is(err, io.EOF) {
 			return 0, io.ErrUnexpectedEOF
 		}
 		return 0, err
 	}
+	// Accumulate into a copy of br.n so that peeking does not disturb the
+	// reader's state.
+	v := br.n
 	for i := 0; n > bits; i++ {
-		b := byt[i]
-		if err != nil {
-			return 0, err
-		}
-		br.n <<= 8
-		br.n |= uint64(b)
+		v <<= 8
+		v |= uint64(byt[i])
 		bits += 8
 	}
 
-	r := (br.n >> uint(bits-n)) & ((1 << uint(n)) - 1)
+	r := (v >> uint(bits-n)) & ((1 << uint(n)) - 1)
 	return r, nil
 }
 
@@ -164,6 +209,91 @@ func (br *BitReader) ByteAligned() bool {
 	return br.bits == 0
 }
 
+// AlignByte discards any bits remaining in the current byte, so that the
+// reader position is at the start of the next byte, returning the number of
+// bits discarded. Discarded bits are not traced.
+func (br *BitReader) AlignByte() int {
+	n := br.bits
+	br.bits = 0
+	return n
+}
+
+// ReadByte implements io.ByteReader, reading the next byte of the source. The
+// reader must be byte aligned, see AlignByte, or ErrNotAligned is returned.
+// Unlike ReadBits, io.EOF is returned at the end of the source, as other
+// io.ByteReader users expect.
+func (br *BitReader) ReadByte() (byte, error) {
+	if !br.ByteAligned() {
+		return 0, ErrNotAligned
+	}
+	b, err := br.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	br.nRead++
+	if br.tracer != nil {
+		br.tracer.Read(br.Off()-8, 8, uint64(b))
+	}
+	return b, nil
+}
+
+// Read implements io.Reader, reading up to len(p) bytes of the source into
+// p, so that a BitReader may be given to other parsers, e.g. to binary.Read
+// for a fixed layout trailer, once byte aligned. As for ReadByte, the reader
+// must be byte aligned or ErrNotAligned is returned.
+func (br *BitReader) Read(p []byte) (int, error) {
+	if !br.ByteAligned() {
+		return 0, ErrNotAligned
+	}
+	for i := range p {
+		b, err := br.ReadByte()
+		if err != nil {
+			if i > 0 && errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return i, err
+		}
+		p[i] = b
+	}
+	return len(p), nil
+}
+
+// Off returns the number of bits that have been read by the BitReader, i.e.
+// the bit offset of the next bit to be read.
+func (br *BitReader) Off() int {
+	return br.nRead*8 - br.bits
+}
+
+// Seek sets the reader position to the bit offset off, as given by Off, so
+// that bits may be read again, e.g. after decoding speculatively. The source
+// must be an io.Seeker, at its start when the reader was created, or
+// ErrNotSeekable is returned. Seeks are not traced.
+func (br *BitReader) Seek(off int) error {
+	s, ok := br.src.(io.Seeker)
+	if !ok {
+		return ErrNotSeekable
+	}
+	if _, err := s.Seek(int64(off/8), io.SeekStart); err != nil {
+		return err
+	}
+	if br.buf != nil {
+		br.buf.Reset(br.src)
+	}
+	br.n, br.bits, br.nRead = 0, 0, off/8
+	if rem := off % 8; rem != 0 {
+		b, err := br.r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		br.nRead++
+		br.n, br.bits = uint64(b), 8-rem
+	}
+	return nil
+}
+
 // BytesRead returns the number of bytes that have been read by the BitReader.
 func (br *BitReader) BytesRead() int {
 	return br.nRead
@@ -26,8 +26,10 @@ package bits
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"reflect"
 	"testing"
 )
@@ -188,6 +190,14 @@ func TestReadOrPeek(t *testing.T) {
 			n:    []int{13, 3, 3, 7, 12},
 			want: []uint64{0x11fc, 0x3, 0x3, 0x38, 0xfe3},
 		},
+		{
+			// A peek that must fetch further bytes should not disturb
+			// following reads.
+			in:   []byte{0x8f, 0xe3, 0x8f},
+			op:   []int{read, peek, read, read},
+			n:    []int{4, 12, 4, 8},
+			want: []uint64{0x8, 0xfe3, 0xf, 0xe3},
+		},
 	}
 
 	for i, test := range tests {
@@ -309,3 +319,175 @@ func TestByteAligned(t *testing.T) {
 		}
 	}
 }
+
+// TestOff checks that Off gives the number of bits read, unaffected by peeks.
+func TestOff(t *testing.T) {
+	br := NewBitReader(bytes.NewReader([]byte{0x8f, 0xe3, 0x8f}))
+	for i, op := range []struct {
+		peek bool
+		n    int
+		want int
+	}{
+		{false, 3, 3},
+		{true, 12, 3},
+		{false, 8, 11},
+		{false, 5, 16},
+		{false, 1, 17},
+	} {
+		var err error
+		if op.peek {
+			_, err = br.PeekBits(op.n)
+		} else {
+			_, err = br.ReadBits(op.n)
+		}
+		if err != nil {
+			t.Fatalf("did not expect error: %v for operation: %d", err, i)
+		}
+		if got := br.Off(); got != op.want {
+			t.Errorf("did not get expected offset for operation: %d\nGot: %v\nWant: %v", i, got, op.want)
+		}
+	}
+}
+
+// traceRead is a read given to a Tracer.
+type traceRead struct {
+	off, n int
+	v      uint64
+}
+
+// readRecorder is a Tracer recording the reads it is given.
+type readRecorder []traceRead
+
+func (r *readRecorder) Read(off, n int, v uint64) {
+	*r = append(*r, traceRead{off, n, v})
+}
+
+// TestTracer checks that a Tracer is given each read, but not peeks or reads
+// made before it is set.
+func TestTracer(t *testing.T) {
+	br := NewBitReader(bytes.NewReader([]byte{0x8f, 0xe3}))
+	br.ReadBits(1)
+
+	var got readRecorder
+	br.SetTracer(&got)
+	if br.Tracer() != &got {
+		t.Errorf("did not get expected tracer")
+	}
+	br.ReadBits(3)
+	br.PeekBits(8)
+	br.ReadBits(9)
+	br.SetTracer(nil)
+	br.ReadBits(1)
+
+	want := readRecorder{{1, 3, 0x0}, {4, 9, 0x1fc}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("did not get expected reads\nGot: %v\nWant: %v", got, want)
+	}
+}
+
+// TestAlignByte checks that AlignByte discards the remaining bits of the
+// current byte.
+func TestAlignByte(t *testing.T) {
+	tests := []struct {
+		n    int // Bits read before aligning.
+		want int // Bits discarded.
+		off  int // Offset after aligning.
+	}{
+		{n: 0, want: 0, off: 0},
+		{n: 3, want: 5, off: 8},
+		{n: 8, want: 0, off: 8},
+		{n: 9, want: 7, off: 16},
+	}
+
+	for i, test := range tests {
+		br := NewBitReader(bytes.NewReader([]byte{0x8f, 0xe3}))
+		_, err := br.ReadBits(test.n)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from ReadBits for test: %d", err, i)
+		}
+		got := br.AlignByte()
+		if got != test.want || br.Off() != test.off || !br.ByteAligned() {
+			t.Errorf("did not get expected result for test: %d\nGot: %d, offset %d\nWant: %d, offset %d", i, got, br.Off(), test.want, test.off)
+		}
+	}
+}
+
+// TestReadByte checks byte aligned reads using ReadByte and Read, including
+// by binary.Read, and that they are refused when not byte aligned.
+func TestReadByte(t *testing.T) {
+	br := NewBitReader(bytes.NewReader([]byte{0x8f, 0xe3, 0x12, 0x34, 0x56, 0x78, 0x9a}))
+	_, err := br.ReadBits(4)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from ReadBits", err)
+	}
+	if _, err := br.ReadByte(); err != ErrNotAligned {
+		t.Errorf("did not get expected error from unaligned ReadByte\nGot: %v\nWant: %v", err, ErrNotAligned)
+	}
+	if _, err := br.Read(make([]byte, 1)); err != ErrNotAligned {
+		t.Errorf("did not get expected error from unaligned Read\nGot: %v\nWant: %v", err, ErrNotAligned)
+	}
+
+	br.AlignByte()
+	b, err := br.ReadByte()
+	if err != nil || b != 0xe3 {
+		t.Errorf("did not get expected result from ReadByte\nGot: %#x, %v\nWant: %#x, <nil>", b, err, 0xe3)
+	}
+	var trailer uint32
+	err = binary.Read(br, binary.BigEndian, &trailer)
+	if err != nil || trailer != 0x12345678 {
+		t.Errorf("did not get expected result from binary.Read\nGot: %#x, %v\nWant: %#x, <nil>", trailer, err, 0x12345678)
+	}
+	if br.Off() != 48 {
+		t.Errorf("did not get expected offset\nGot: %d\nWant: %d", br.Off(), 48)
+	}
+
+	// Bit reads may continue after byte reads.
+	v, err := br.ReadBits(4)
+	if err != nil || v != 0x9 {
+		t.Errorf("did not get expected result from ReadBits\nGot: %#x, %v\nWant: %#x, <nil>", v, err, 0x9)
+	}
+	br.AlignByte()
+	got, err := ioutil.ReadAll(br)
+	if err != nil || len(got) != 0 {
+		t.Errorf("did not get expected result from reading at end\nGot: %v, %v\nWant: [], <nil>", got, err)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("did not get expected error from ReadByte at end\nGot: %v\nWant: %v", err, io.EOF)
+	}
+}
+
+// TestSeek checks that bits are read again from the offset sought, within
+// and at the start of bytes, and that sources not seekable are refused.
+func TestSeek(t *testing.T) {
+	br := NewBitReader(bytes.NewReader([]byte{0x8f, 0xe3, 0x8f}))
+	if _, err := br.ReadBits(20); err != nil {
+		t.Fatalf("did not expect error: %v from ReadBits", err)
+	}
+	tests := []struct {
+		off  int
+		n    int
+		want uint64
+	}{
+		{off: 4, n: 8, want: 0xfe},
+		{off: 0, n: 4, want: 0x8},
+		{off: 13, n: 11, want: 0x38f},
+		{off: 16, n: 8, want: 0x8f},
+	}
+	for i, test := range tests {
+		if err := br.Seek(test.off); err != nil {
+			t.Fatalf("did not expect error: %v from Seek for test: %d", err, i)
+		}
+		if got := br.Off(); got != test.off {
+			t.Errorf("did not get expected offset for test: %d\nGot: %v\nWant: %v", i, got, test.off)
+		}
+		got, err := br.ReadBits(test.n)
+		if err != nil || got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %#x, %v\nWant: %#x, <nil>", i, got, err, test.want)
+		}
+	}
+
+	br = NewBitReader(bytes.NewBuffer([]byte{0x8f}))
+	if err := br.Seek(0); err != ErrNotSeekable {
+		t.Errorf("did not get expected error from Seek of unseekable source\nGot: %v\nWant: %v", err, ErrNotSeekable)
+	}
+}
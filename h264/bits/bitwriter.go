@@ -0,0 +1,87 @@
+/*
+DESCRIPTION
+  bitwriter.go provides a bit writer implementation that can write bits to an
+  io.Writer data destination.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+
+LICENSE
+  Copyright (C) 2017-2018 the Australian Ocean Lab (AusOcean)
+
+  It is free software: you can redistribute it and/or modify them
+  under the terms of the GNU General Public License as published by the
+  Free Software Foundation, either version 3 of the License, or (at your
+  option) any later version.
+
+  It is distributed in the hope that it will be useful, but WITHOUT
+  ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+  FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License
+  for more details.
+
+  You should have received a copy of the GNU General Public License
+  in gpl.txt.  If not, see http://www.gnu.org/licenses.
+*/
+
+package bits
+
+import "io"
+
+// BitWriter is a bit writer that provides methods for writing bits to an
+// io.Writer destination. Errors are sticky: once a write fails, following
+// writes do nothing and return the same error, so callers writing many fields
+// may check only the error returned by Flush.
+type BitWriter struct {
+	w    io.Writer
+	n    byte // Bits not yet written, in the most-significant places.
+	bits int  // Number of bits held in n.
+	err  error
+}
+
+// NewBitWriter returns a new BitWriter.
+func NewBitWriter(w io.Writer) *BitWriter {
+	return &BitWriter{w: w}
+}
+
+// WriteBits writes the n least-significant bits of v, most-significant first.
+// For example, with consecutive writes of v = 0x8, n = 4 and v = 0xf, n = 4,
+// the byte 0x8f (1000 1111) is written.
+func (bw *BitWriter) WriteBits(v uint64, n int) error {
+	if n < 0 || n > 64 {
+		return ErrBadBitCount
+	}
+	for i := n - 1; i >= 0 && bw.err == nil; i-- {
+		bw.n |= byte((v>>uint(i))&1) << uint(7-bw.bits)
+		bw.bits++
+		if bw.bits == 8 {
+			_, bw.err = bw.w.Write([]byte{bw.n})
+			bw.n, bw.bits = 0, 0
+		}
+	}
+	return bw.err
+}
+
+// WriteBool writes a single bit, 1 if b is true and 0 otherwise.
+func (bw *BitWriter) WriteBool(b bool) error {
+	var v uint64
+	if b {
+		v = 1
+	}
+	return bw.WriteBits(v, 1)
+}
+
+// ByteAligned returns true if the writer position is at the start of a byte,
+// and false otherwise.
+func (bw *BitWriter) ByteAligned() bool {
+	return bw.bits == 0
+}
+
+// Flush writes any remaining bits, padding the final byte with zero bits, and
+// returns the first error encountered by the BitWriter, if any.
+func (bw *BitWriter) Flush() error {
+	if bw.bits != 0 && bw.err == nil {
+		_, bw.err = bw.w.Write([]byte{bw.n})
+		bw.n, bw.bits = 0, 0
+	}
+	return bw.err
+}
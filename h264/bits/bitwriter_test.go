@@ -0,0 +1,124 @@
+/*
+DESCRIPTION
+  bitwriter_test.go provides testing for functionality defined in bitwriter.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+
+LICENSE
+  Copyright (C) 2017-2018 the Australian Ocean Lab (AusOcean)
+
+  It is free software: you can redistribute it and/or modify them
+  under the terms of the GNU General Public License as published by the
+  Free Software Foundation, either version 3 of the License, or (at your
+  option) any later version.
+
+  It is distributed in the hope that it will be useful, but WITHOUT
+  ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+  FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License
+  for more details.
+
+  You should have received a copy of the GNU General Public License
+  in gpl.txt.  If not, see http://www.gnu.org/licenses.
+*/
+
+package bits
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestWriteBits(t *testing.T) {
+	tests := []struct {
+		v       []uint64
+		n       []int
+		aligned bool
+		want    []byte
+	}{
+		{
+			v:       []uint64{0x8, 0xf},
+			n:       []int{4, 4},
+			aligned: true,
+			want:    []byte{0x8f},
+		},
+		{
+			v:       []uint64{1, 0, 1},
+			n:       []int{1, 1, 1},
+			aligned: false,
+			want:    []byte{0xa0},
+		},
+		{
+			v:       []uint64{0x3, 0xabcd, 0x1},
+			n:       []int{2, 16, 6},
+			aligned: true,
+			want:    []byte{0xea, 0xf3, 0x41},
+		},
+		{
+			v:       []uint64{0xff, 0x12345678},
+			n:       []int{4, 32},
+			aligned: false,
+			want:    []byte{0xf1, 0x23, 0x45, 0x67, 0x80},
+		},
+	}
+
+	for i, test := range tests {
+		var buf bytes.Buffer
+		bw := NewBitWriter(&buf)
+		for j, v := range test.v {
+			err := bw.WriteBits(v, test.n[j])
+			if err != nil {
+				t.Fatalf("did not expect error: %v for test: %d", err, i)
+			}
+		}
+		if bw.ByteAligned() != test.aligned {
+			t.Errorf("did not get expected alignment for test: %d\nGot: %v\nWant: %v\n", i, bw.ByteAligned(), test.aligned)
+		}
+		err := bw.Flush()
+		if err != nil {
+			t.Fatalf("did not expect error: %v from Flush for test: %d", err, i)
+		}
+		if !bytes.Equal(buf.Bytes(), test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %#v\nWant: %#v\n", i, buf.Bytes(), test.want)
+		}
+	}
+}
+
+type errWriter struct{}
+
+var errTest = errors.New("test error")
+
+func (errWriter) Write(p []byte) (int, error) { return 0, errTest }
+
+func TestWriteBitsError(t *testing.T) {
+	bw := NewBitWriter(errWriter{})
+	err := bw.WriteBits(0xff, 8)
+	if !errors.Is(err, errTest) {
+		t.Errorf("did not get expected error from WriteBits\nGot: %v\nWant: %v\n", err, errTest)
+	}
+	err = bw.WriteBool(true)
+	if !errors.Is(err, errTest) {
+		t.Errorf("did not get expected sticky error from WriteBool\nGot: %v\nWant: %v\n", err, errTest)
+	}
+	err = bw.Flush()
+	if !errors.Is(err, errTest) {
+		t.Errorf("did not get expected error from Flush\nGot: %v\nWant: %v\n", err, errTest)
+	}
+}
+
+func TestBadBitCount(t *testing.T) {
+	br := NewBitReader(bytes.NewReader([]byte{0xff}))
+	bw := NewBitWriter(&bytes.Buffer{})
+	for _, n := range []int{-1, 65} {
+		if _, err := br.ReadBits(n); !errors.Is(err, ErrBadBitCount) {
+			t.Errorf("did not get expected error from ReadBits(%d)\nGot: %v\nWant: %v\n", n, err, ErrBadBitCount)
+		}
+		if _, err := br.PeekBits(n); !errors.Is(err, ErrBadBitCount) {
+			t.Errorf("did not get expected error from PeekBits(%d)\nGot: %v\nWant: %v\n", n, err, ErrBadBitCount)
+		}
+		if err := bw.WriteBits(0, n); !errors.Is(err, ErrBadBitCount) {
+			t.Errorf("did not get expected error from WriteBits(%d)\nGot: %v\nWant: %v\n", n, err, ErrBadBitCount)
+		}
+	}
+}
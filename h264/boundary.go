@@ -0,0 +1,138 @@
+/*
+NAME
+  boundary.go
+
+DESCRIPTION
+  boundary.go provides detection of the first VCL NAL unit of each primary
+  coded picture, and so of access unit boundaries, by comparing the slice
+  headers of successive VCL NAL units as given by section 7.4.1.2.4, so that
+  streams are segmented into pictures without relying on access unit
+  delimiters.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// pictureDetector detects the first VCL NAL unit of each primary coded
+// picture of a stream read by an H264Reader, see detect.
+type pictureDetector struct {
+	prev        *SliceHeader // Header of the previous VCL NAL unit of a primary coded picture.
+	prevPOCType int          // pic_order_cnt_type of the SPS used by prev.
+}
+
+// detect records on the NAL unit, which must follow the processing of all
+// NAL units before it, whether it is the first VCL NAL unit of a primary
+// coded picture, as returned by startsPicture. This is the case for a slice
+// (or partition A) whose header differs from that of the previous VCL NAL
+// unit as given by firstOfPicture, or which follows an access unit
+// delimiter or the end of a sequence or stream.
+//
+// The slice header is parsed using the parameter sets it refers to. If it
+// cannot be, e.g. because they have not been received, the slice is taken to
+// begin a picture if first_mb_in_slice is 0, as by firstMbIsZero. Slices of
+// redundant coded pictures never begin a primary coded picture.
+func (d *pictureDetector) detect(ps *ParameterSets, nalUnit *NalUnit) {
+	switch nalUnit.Type {
+	case NALTypeAccessUnitDelimiter, NALTypeEndOfSequence, NALTypeEndOfStream:
+		d.prev = nil
+		return
+	case NALTypeSliceNonIDRPicture, NALTypeSlicePartA, NALTypeSliceIDRPicture:
+	default:
+		return
+	}
+	nalUnit.detected = true
+
+	header, sps, err := readPictureHeader(ps, nalUnit)
+	if err != nil {
+		logger.Printf("warning: could not detect picture boundary using slice header: %v\n", err)
+		nalUnit.newPicture = firstMbIsZero(nalUnit)
+		d.prev = nil
+		return
+	}
+	if header.RedundantPicCnt > 0 {
+		return
+	}
+	nalUnit.newPicture = d.prev == nil || firstOfPicture(d.prev, header, d.prevPOCType, sps.PicOrderCountType)
+	d.prev, d.prevPOCType = header, sps.PicOrderCountType
+}
+
+// readPictureHeader parses the slice header of the VCL NAL unit up to and
+// including redundant_pic_cnt, using the stored parameter sets, returning it
+// with the SPS it uses.
+func readPictureHeader(ps *ParameterSets, nalUnit *NalUnit) (*SliceHeader, *SPS, error) {
+	ppsID, err := slicePPSID(nalUnit.RBSP())
+	if err != nil {
+		return nil, nil, err
+	}
+	sps, pps, err := ps.Active(ppsID)
+	if err != nil {
+		return nil, nil, err
+	}
+	br := bits.NewBitReader(bytes.NewReader(nalUnit.RBSP()))
+	header, err := readSliceHeaderStart(br, nalUnit, sps)
+	if err != nil {
+		return nil, nil, withBitPos(br, err)
+	}
+	err = readSliceHeaderPicture(br, header, sps, pps)
+	if err != nil {
+		return nil, nil, withBitPos(br, err)
+	}
+	return header, sps, nil
+}
+
+// firstOfPicture returns true if the VCL NAL unit with slice header cur is
+// the first of a new primary coded picture, following that with slice header
+// prev, as given by section 7.4.1.2.4. prevPOCType and curPOCType are the
+// pic_order_cnt_type of the SPS used by each.
+func firstOfPicture(prev, cur *SliceHeader, prevPOCType, curPOCType int) bool {
+	switch {
+	case cur.FrameNum != prev.FrameNum,
+		cur.PPSID != prev.PPSID,
+		cur.FieldPic != prev.FieldPic,
+		cur.FieldPic && cur.BottomField != prev.BottomField,
+		(cur.NalRefIdc == 0) != (prev.NalRefIdc == 0),
+		cur.IdrPic != prev.IdrPic,
+		cur.IdrPic && cur.IDRPicID != prev.IDRPicID:
+		return true
+	}
+	switch {
+	case prevPOCType != curPOCType:
+		// Only possible with a change of PPS, so already detected.
+		return false
+	case curPOCType == 0:
+		return cur.PicOrderCntLsb != prev.PicOrderCntLsb || cur.DeltaPicOrderCntBottom != prev.DeltaPicOrderCntBottom
+	case curPOCType == 1:
+		var c, p [2]int
+		copy(c[:], cur.DeltaPicOrderCnt)
+		copy(p[:], prev.DeltaPicOrderCnt)
+		return c != p
+	}
+	return false
+}
+
+// firstMbIsZero returns true if the VCL NAL unit, which must begin with a
+// slice header, has first_mb_in_slice equal to 0, in which case it is taken
+// to be the first slice of a picture when its header cannot be fully parsed.
+func firstMbIsZero(nalUnit *NalUnit) bool {
+	firstMbInSlice, err := readUe(bits.NewBitReader(bytes.NewReader(nalUnit.RBSP())))
+	return err == nil && firstMbInSlice == 0
+}
+
+// startsPicture returns true if the given VCL NAL unit, which must begin with
+// a slice header, is the first of a primary coded picture. For NAL units read
+// by an H264Reader this is as detected by pictureDetector; for others, whose
+// preceding NAL units are not known, it is given by firstMbIsZero.
+func startsPicture(nalUnit *NalUnit) bool {
+	if nalUnit.detected {
+		return nalUnit.newPicture
+	}
+	return firstMbIsZero(nalUnit)
+}
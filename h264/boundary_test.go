@@ -0,0 +1,182 @@
+/*
+NAME
+  boundary_test.go
+
+DESCRIPTION
+  boundary_test.go provides testing for functionality provided in
+  boundary.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestFirstOfPicture(t *testing.T) {
+	base := SliceHeader{NalRefIdc: 1, FrameNum: 3, PPSID: 0, PicOrderCntLsb: 6}
+	with := func(f func(h *SliceHeader)) SliceHeader {
+		h := base
+		f(&h)
+		return h
+	}
+	field := with(func(h *SliceHeader) { h.FieldPic = true })
+	idr := with(func(h *SliceHeader) { h.IdrPic = true; h.IDRPicID = 1 })
+	type1 := with(func(h *SliceHeader) { h.DeltaPicOrderCnt = []int{1, 2} })
+
+	tests := []struct {
+		name              string
+		prev, cur         SliceHeader
+		prevType, curType int // pic_order_cnt_type of each.
+		want              bool
+	}{
+		{name: "same picture", prev: base, cur: with(func(h *SliceHeader) { h.FirstMbInSlice = 4 })},
+		{name: "same picture, first_mb_in_slice 0", prev: base, cur: base},
+		{name: "frame_num", prev: base, cur: with(func(h *SliceHeader) { h.FrameNum = 4 }), want: true},
+		{name: "pic_parameter_set_id", prev: base, cur: with(func(h *SliceHeader) { h.PPSID = 1 }), want: true},
+		{name: "field_pic_flag", prev: base, cur: field, want: true},
+		{name: "bottom_field_flag", prev: field, cur: with(func(h *SliceHeader) { h.FieldPic = true; h.BottomField = true }), want: true},
+		{name: "same field", prev: field, cur: field},
+		{name: "nal_ref_idc to 0", prev: base, cur: with(func(h *SliceHeader) { h.NalRefIdc = 0 }), want: true},
+		{name: "nal_ref_idc from 0", prev: with(func(h *SliceHeader) { h.NalRefIdc = 0 }), cur: base, want: true},
+		{name: "nal_ref_idc both non-zero", prev: base, cur: with(func(h *SliceHeader) { h.NalRefIdc = 3 })},
+		{name: "IdrPicFlag", prev: base, cur: idr, want: true},
+		{name: "idr_pic_id", prev: idr, cur: with(func(h *SliceHeader) { h.IdrPic = true; h.IDRPicID = 2 }), want: true},
+		{name: "same IDR picture", prev: idr, cur: idr},
+		{name: "pic_order_cnt_lsb", prev: base, cur: with(func(h *SliceHeader) { h.PicOrderCntLsb = 8 }), want: true},
+		{name: "delta_pic_order_cnt_bottom", prev: base, cur: with(func(h *SliceHeader) { h.DeltaPicOrderCntBottom = 1 }), want: true},
+		{name: "pic_order_cnt_lsb of type 1", prev: base, cur: with(func(h *SliceHeader) { h.PicOrderCntLsb = 8 }), prevType: 1, curType: 1},
+		{name: "delta_pic_order_cnt[0]", prev: type1, cur: with(func(h *SliceHeader) { h.DeltaPicOrderCnt = []int{0, 2} }), prevType: 1, curType: 1, want: true},
+		{name: "delta_pic_order_cnt[1]", prev: type1, cur: with(func(h *SliceHeader) { h.DeltaPicOrderCnt = []int{1, 0} }), prevType: 1, curType: 1, want: true},
+		{name: "delta_pic_order_cnt absent", prev: type1, cur: base, prevType: 1, curType: 1, want: true},
+		{name: "same type 1 picture", prev: type1, cur: type1, prevType: 1, curType: 1},
+		{name: "delta_pic_order_cnt of type 2", prev: type1, cur: base, prevType: 2, curType: 2},
+	}
+
+	for _, test := range tests {
+		got := firstOfPicture(&test.prev, &test.cur, test.prevType, test.curType)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %s\nGot: %v\nWant: %v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestDetectPicture checks the detection of the first slice of each picture
+// of a stream read by an H264Reader.
+func TestDetectPicture(t *testing.T) {
+	aud := []byte{0x09, 0x10}
+
+	// testPPS with redundant_pic_cnt_present_flag set, and slices like those
+	// of skipSlice using it with the given frame_num and redundant_pic_cnt.
+	redundantPPS := append([]byte{0x68}, binToSlice("1 1 0 0 1 1 1 0 00 1 1 1 1 0 1 1")...)
+	redundantSlice := func(frameNum, cnt int) []byte {
+		return append([]byte{0x41}, binToSlice(ueBits(0)+"00110 1"+fmt.Sprintf("%04b", frameNum)+ueBits(cnt)+"0 0 0 1 010 010 1")...)
+	}
+
+	tests := []struct {
+		name     string
+		nalUnits [][]byte
+		want     []bool // Result of startsPicture for each slice.
+	}{
+		{
+			name:     "slices of the same picture",
+			nalUnits: [][]byte{testSPS, testPPS, skipSlice(2, 1), skipSlice(2, 1), skipSlice(2, 2), skipSlice(2, 2)},
+			want:     []bool{true, false, true, false},
+		},
+		{
+			name:     "nal_ref_idc",
+			nalUnits: [][]byte{testSPS, testPPS, skipSlice(2, 1), skipSlice(0, 1), skipSlice(0, 1), skipSlice(1, 1)},
+			want:     []bool{true, true, false, true},
+		},
+		{
+			name:     "access unit delimiter",
+			nalUnits: [][]byte{testSPS, testPPS, skipSlice(2, 1), aud, skipSlice(2, 1), skipSlice(2, 1)},
+			want:     []bool{true, true, false},
+		},
+		{
+			name:     "missing parameter sets",
+			nalUnits: [][]byte{skipSlice(2, 1), skipSlice(2, 1), testSPS, testPPS, skipSlice(2, 1), skipSlice(2, 1)},
+			want:     []bool{true, true, true, false},
+		},
+		{
+			name:     "redundant coded picture",
+			nalUnits: [][]byte{testSPS, redundantPPS, redundantSlice(1, 0), redundantSlice(1, 1), redundantSlice(2, 0)},
+			want:     []bool{true, false, true},
+		},
+	}
+
+	for _, test := range tests {
+		r := &H264Reader{Stream: bytes.NewReader(annexB(test.nalUnits...))}
+		var got []bool
+		for {
+			nalUnit, err := r.nextNalUnit()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("did not expect error: %v from nextNalUnit for test: %s", err, test.name)
+			}
+			if nalUnit.Type.IsParameterSet() {
+				err = r.handleParameterSet(nalUnit)
+				if err != nil {
+					t.Fatalf("did not expect error: %v from handleParameterSet for test: %s", err, test.name)
+				}
+			}
+			if nalUnit.Type.IsVCL() {
+				got = append(got, startsPicture(nalUnit))
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %s\nGot: %v\nWant: %v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestSkipWithoutAUD checks that Skip finds access units by the slice headers
+// of pictures having more than one slice.
+func TestSkipWithoutAUD(t *testing.T) {
+	stream := annexB(testSPS, testPPS, skipSlice(2, 1), skipSlice(2, 1), skipSlice(2, 2), skipSlice(2, 2), skipSlice(2, 3))
+	r := &H264Reader{Stream: bytes.NewReader(stream)}
+	err := r.Skip(2)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Skip", err)
+	}
+	nalUnit, err := r.nextNalUnit()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from nextNalUnit", err)
+	}
+	if !bytes.Equal(nalUnit.raw, skipSlice(2, 3)) {
+		t.Errorf("did not get expected NAL unit after Skip\nGot: %x\nWant: %x", nalUnit.raw, skipSlice(2, 3))
+	}
+}
+
+// TestStartsPictureUndetected checks that first_mb_in_slice is used for NAL
+// units not read by an H264Reader.
+func TestStartsPictureUndetected(t *testing.T) {
+	tests := []struct {
+		raw  []byte
+		want bool
+	}{
+		{raw: skipSlice(2, 1), want: true},
+		{raw: append([]byte{0x41}, binToSlice(ueBits(3)+"1")...)},
+	}
+
+	for i, test := range tests {
+		nalUnit, err := NewNalUnit(test.raw, len(test.raw))
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewNalUnit for test: %d", err, i)
+		}
+		got := startsPicture(nalUnit)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
@@ -1,8 +1,9 @@
 package h264
 
 import (
+	"fmt"
+
 	"github.com/ausocean/h264decode/h264/bits"
-	"github.com/pkg/errors"
 )
 
 const (
@@ -11,6 +12,11 @@ const (
 	MbAddrNotAvailable = 10000
 )
 
+// maxBinStringLen is the maximum length of the bin string of mb_type, being
+// that of an I macroblock type in a B slice, with a prefix of 6 bins and a
+// suffix of up to 7 bins (see tables 9-36 and 9-37).
+const maxBinStringLen = 13
+
 // G.7.4.3.4 via G.7.3.3.4 via 7.3.2.13 for NalUnitType 20 or 21
 // refLayerMbWidthC is equal to MbWidthC for the reference layer representation
 func RefMbW(chromaFlag, refLayerMbWidthC int) int {
@@ -33,19 +39,21 @@ func XOffset(xRefMin16, refMbW int) int {
 func YOffset(yRefMin16, refMbH int) int {
 	return (((yRefMin16 - 64) >> 8) << 4) - (refMbH >> 1)
 }
+
+// MbWidthC and MbHeightC return the width and height of the chroma arrays of
+// a macroblock, which are 0 if there are none i.e. ChromaArrayType is 0 (see
+// section 6.2).
 func MbWidthC(sps *SPS) int {
-	mbWidthC := 16 / SubWidthC(sps)
-	if sps.ChromaFormat == chromaMonochrome || sps.UseSeparateColorPlane {
-		mbWidthC = 0
+	if sps.ChromaArrayType() == chromaMonochrome {
+		return 0
 	}
-	return mbWidthC
+	return 16 / SubWidthC(sps)
 }
 func MbHeightC(sps *SPS) int {
-	mbHeightC := 16 / SubHeightC(sps)
-	if sps.ChromaFormat == chromaMonochrome || sps.UseSeparateColorPlane {
-		mbHeightC = 0
+	if sps.ChromaArrayType() == chromaMonochrome {
+		return 0
 	}
-	return mbHeightC
+	return 16 / SubHeightC(sps)
 }
 
 // G.8.6.2.2.2
@@ -132,6 +140,14 @@ func Clipc(x, bitDepthC int) int {
 	return Clip3(0, (1<<uint(bitDepthC))-1, x)
 }
 
+// abs returns the absolute value of x (5-1).
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
 // 5-5
 func Clip3(x, y, z int) int {
 	if z < x {
@@ -151,29 +167,20 @@ type CABAC struct {
 
 // table 9-1
 func initCabac(binarization *Binarization, context *SliceContext) *CABAC {
-	var valMPS, pStateIdx int
 	// TODO: When to use prefix, when to use suffix?
 	ctxIdx := CtxIdx(
 		binarization.binIdx,
 		binarization.MaxBinIdxCtx.Prefix,
 		binarization.CtxIdxOffset.Prefix)
-	mn := MNVars[ctxIdx]
-
-	preCtxState := PreCtxState(mn[0].M, mn[0].N, SliceQPy(context.PPS, context.Header))
-	if preCtxState <= 63 {
-		pStateIdx = 63 - preCtxState
-		valMPS = 0
-	} else {
-		pStateIdx = preCtxState - 64
-		valMPS = 1
+	mn, err := ContextMN(ctxIdx, context.Slice.Data.SliceTypeName, context.Header.CabacInit)
+	if err != nil {
+		logger.Printf("warning: could not get m and n of context variable: %v\n", err)
 	}
-	_ = pStateIdx
-	_ = valMPS
-	// Initialization of context variables
+	v := newContextVariable(mn, SliceQPy(context.PPS, context.Header))
 	// Initialization of decoding engine
 	return &CABAC{
-		PStateIdx: pStateIdx,
-		ValMPS:    valMPS,
+		PStateIdx: v.PStateIdx,
+		ValMPS:    v.ValMPS,
 		Context:   context,
 	}
 }
@@ -446,7 +453,7 @@ func initDecodingEngine(bitReader *bits.BitReader) (int, int, error) {
 	codIRange := 510
 	codIOffset, err := bitReader.ReadBits(9)
 	if err != nil {
-		return 0, 0, errors.Wrap(err, "could not read codIOffset")
+		return 0, 0, fmt.Errorf("could not read codIOffset: %w", err)
 	}
 	logger.Printf("debug: codIRange: %d :: codIOffsset: %d\n", codIRange, codIOffset)
 	return codIRange, int(codIOffset), nil
@@ -462,7 +469,7 @@ func NewArithmeticDecoding(context *SliceContext, binarization *Binarization, ct
 		var err error
 		codIOffset, a.BinVal, err = a.DecodeBypass(context.Slice.Data, codIRange, codIOffset)
 		if err != nil {
-			return ArithmeticDecoding{}, errors.Wrap(err, "error from DecodeBypass getting codIOffset and BinVal")
+			return ArithmeticDecoding{}, fmt.Errorf("error from DecodeBypass getting codIOffset and BinVal: %w", err)
 		}
 
 	} else if binarization.UseDecodeBypass == 0 && ctxIdx == 276 {
@@ -483,7 +490,7 @@ func (a ArithmeticDecoding) DecodeBypass(sliceData *SliceData, codIRange, codIOf
 	// TODO: Possibly should be codIOffset | ReadOneBit
 	shift, err := sliceData.BitReader.ReadBits(1)
 	if err != nil {
-		return 0, 0, errors.Wrap(err, "coult not read shift bit from sliceData.")
+		return 0, 0, fmt.Errorf("coult not read shift bit from sliceData.: %w", err)
 	}
 	codIOffset = codIOffset << uint(shift)
 	if codIOffset >= codIRange {
@@ -511,7 +518,7 @@ func (a ArithmeticDecoding) DecodeTerminate(sliceData *SliceData, codIRange, cod
 	var err error
 	codIRange, codIOffset, err = a.RenormD(sliceData, codIRange, codIOffset)
 	if err != nil {
-		return 0, 0, 0, errors.Wrap(err, "error from RenormD")
+		return 0, 0, 0, fmt.Errorf("error from RenormD: %w", err)
 	}
 	return codIRange, codIOffset, a.BinVal, nil
 }
@@ -526,7 +533,7 @@ func (a ArithmeticDecoding) RenormD(sliceData *SliceData, codIRange, codIOffset
 	codIOffset = codIOffset << uint(1)
 	bit, err := sliceData.BitReader.ReadBits(1)
 	if err != nil {
-		return 0, 0, errors.Wrap(err, "could not read bit from sliceData")
+		return 0, 0, fmt.Errorf("could not read bit from sliceData: %w", err)
 	}
 	codIOffset = codIOffset | int(bit)
 	return a.RenormD(sliceData, codIRange, codIOffset)
@@ -548,7 +555,7 @@ func (a ArithmeticDecoding) BinaryDecision(ctxIdx, codIRange, codIOffset int) (i
 	pStateIdx := cabac.PStateIdx
 	codIRangeLPS, err := retCodIRangeLPS(pStateIdx, qCodIRangeIdx)
 	if err != nil {
-		return 0, 0, 0, errors.Wrap(err, "could not get codIRangeLPS from retCodIRangeLPS")
+		return 0, 0, 0, fmt.Errorf("could not get codIRangeLPS from retCodIRangeLPS: %w", err)
 	}
 
 	codIRange = codIRange - codIRangeLPS
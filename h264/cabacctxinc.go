@@ -0,0 +1,321 @@
+/*
+NAME
+  cabacctxinc.go
+
+DESCRIPTION
+  cabacctxinc.go provides the derivations of ctxIdxInc for the bins of CABAC
+  syntax elements whose context depends on neighbouring macroblocks,
+  partitions or blocks, as specified in section 9.3.3.1.1 of the
+  specifications, using the neighbour availability of section 6.4 as given by
+  mbState.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+// condTermSum returns the sum of condTermFlagA and condTermFlagB, as used for
+// the ctxIdxInc of many syntax elements (9.3.3.1.1.1), each being 0 if the
+// neighbouring macroblock is not available and otherwise given by cond.
+func (s *mbState) condTermSum(currMbAddr int, cond func(mbAddrN int) bool) int {
+	var sum int
+	mbAddrA, mbAddrB := s.mbNeighboursAB(currMbAddr)
+	for _, mbAddrN := range []int{mbAddrA, mbAddrB} {
+		if mbAddrN != MbAddrNotAvailable && cond(mbAddrN) {
+			sum++
+		}
+	}
+	return sum
+}
+
+// mbSkipFlagCtxIdxInc returns the ctxIdxInc of mb_skip_flag of the
+// macroblock with address currMbAddr, which must have been begun in mbs, as
+// specified by 9.3.3.1.1.1.
+func mbSkipFlagCtxIdxInc(mbs *mbState, currMbAddr int) int {
+	return mbs.condTermSum(currMbAddr, func(mbAddrN int) bool {
+		return !mbs.has(mbAddrN, mbSkipped)
+	})
+}
+
+// mbFieldDecodingFlagCtxIdxInc returns the ctxIdxInc of
+// mb_field_decoding_flag of the macroblock pair containing currMbAddr, being
+// the number of the pairs to its left and above that are field pairs, as
+// specified by 9.3.3.1.1.2.
+func mbFieldDecodingFlagCtxIdxInc(mbs *mbState, currMbAddr int) int {
+	var sum int
+	for _, mbAddrX := range []int{mbs.mbaffAddrA(currMbAddr), mbs.mbaffAddrB(currMbAddr)} {
+		if mbAddrX != MbAddrNotAvailable && mbs.has(mbAddrX, mbFieldDecoded) {
+			sum++
+		}
+	}
+	return sum
+}
+
+// mbTypeCtxIdxInc returns the ctxIdxInc of the first bin of mb_type, or of
+// the suffix of SI slice mb_type, with the given ctxIdxOffset for the
+// macroblock with address currMbAddr of a slice of the given type, as derived
+// from the macroblocks to its left and above by 9.3.3.1.1.3. The mb_type of
+// each neighbouring macroblock must be recorded in mbs.
+func mbTypeCtxIdxInc(mbs *mbState, currMbAddr int, sliceType string, ctxIdxOffset int) int {
+	iNxN := 0
+	if sliceType == "SI" {
+		iNxN = 1
+	}
+	condTermFlag := func(mbAddrN int) int {
+		if mbAddrN == MbAddrNotAvailable {
+			return 0
+		}
+		mbType := int(mbs.mbType[mbAddrN])
+		switch {
+		case ctxIdxOffset == 0 && mbType == 0: // SI.
+			return 0
+		case ctxIdxOffset == 3 && mbType == iNxN: // I_NxN.
+			return 0
+		case ctxIdxOffset == 27 && (mbs.has(mbAddrN, mbSkipped) || mbType == 0): // B_Skip or B_Direct_16x16.
+			return 0
+		}
+		return 1
+	}
+	mbAddrA, _, _ := mbs.neighbourLocation(currMbAddr, -1, 0, 16, 16)
+	mbAddrB, _, _ := mbs.neighbourLocation(currMbAddr, 0, -1, 16, 16)
+	return condTermFlag(mbAddrA) + condTermFlag(mbAddrB)
+}
+
+// cbpLumaCtxIdxInc returns the ctxIdxInc of the prefix bin of
+// coded_block_pattern with index b8, giving whether the 8x8 luma block b8 has
+// non-zero coefficients, as specified by 9.3.3.1.1.4. bins holds the prefix
+// bins of the current macroblock already decoded, which give the blocks to
+// the left of or above b8 within the macroblock. The codedBlockPattern of
+// neighbouring macroblocks must be recorded in mbs.
+func cbpLumaCtxIdxInc(mbs *mbState, currMbAddr, b8 int, bins []int) int {
+	x, y := (b8%2)*8, (b8/2)*8
+	condTermFlag := func(xN, yN int) int {
+		mbAddrN, xW, yW := mbs.neighbourLocation(currMbAddr, xN, yN, 16, 16)
+		b8N := luma8x8BlkIdx(xW, yW)
+		switch {
+		case mbAddrN == MbAddrNotAvailable || mbs.has(mbAddrN, mbPCM):
+			return 0
+		case mbAddrN == currMbAddr:
+			return flagVal(bins[b8N] == 0)
+		case mbs.has(mbAddrN, mbSkipped):
+			return 1
+		}
+		return flagVal((mbs.codedBlockPattern[mbAddrN]>>uint(b8N))&1 == 0)
+	}
+	return condTermFlag(x-1, y) + 2*condTermFlag(x, y-1)
+}
+
+// cbpChromaCtxIdxInc returns the ctxIdxInc of the suffix bin of
+// coded_block_pattern with index binIdx, 0 giving whether there are any
+// non-zero chroma coefficients and 1 whether there are non-zero chroma AC
+// coefficients, as specified by 9.3.3.1.1.4.
+func cbpChromaCtxIdxInc(mbs *mbState, currMbAddr, binIdx int) int {
+	condTermFlag := func(mbAddrN int) int {
+		switch {
+		case mbAddrN == MbAddrNotAvailable || mbs.has(mbAddrN, mbSkipped):
+			return 0
+		case mbs.has(mbAddrN, mbPCM):
+			return 1
+		}
+		chroma := mbs.codedBlockPattern[mbAddrN] >> 4
+		return flagVal(chroma != 0 && (binIdx == 0 || chroma == 2))
+	}
+	mbAddrA, mbAddrB := mbs.mbNeighboursAB(currMbAddr)
+	return condTermFlag(mbAddrA) + 2*condTermFlag(mbAddrB) + 4*binIdx
+}
+
+// mbQpDeltaCtxIdxInc returns the ctxIdxInc of the first bin of mb_qp_delta of
+// the macroblock with address currMbAddr, prevMbAddr being that of the
+// macroblock preceding it in decoding order, as specified by 9.3.3.1.1.5.
+// The ctxIdxInc is 1 only if the preceding macroblock of the slice had
+// mb_qp_delta not equal to 0, it being otherwise either absent, as for skipped
+// and I_PCM macroblocks and those with no coded residual, or 0.
+func mbQpDeltaCtxIdxInc(mbs *mbState, prevMbAddr, currMbAddr int) int {
+	if prevMbAddr < 0 || !mbs.available(prevMbAddr, currMbAddr) {
+		return 0
+	}
+	return flagVal(mbs.has(prevMbAddr, mbQpDelta))
+}
+
+// intraChromaPredModeCtxIdxInc returns the ctxIdxInc of the first bin of
+// intra_chroma_pred_mode, as specified by 9.3.3.1.1.8.
+func intraChromaPredModeCtxIdxInc(mbs *mbState, currMbAddr int) int {
+	return mbs.condTermSum(currMbAddr, func(mbAddrN int) bool {
+		return mbs.has(mbAddrN, mbIntraCoded) && !mbs.has(mbAddrN, mbPCM) && mbs.intraChromaPredMode[mbAddrN] != 0
+	})
+}
+
+// transformSize8x8FlagCtxIdxInc returns the ctxIdxInc of
+// transform_size_8x8_flag, as specified by 9.3.3.1.1.10.
+func transformSize8x8FlagCtxIdxInc(mbs *mbState, currMbAddr int) int {
+	return mbs.condTermSum(currMbAddr, func(mbAddrN int) bool {
+		return mbs.has(mbAddrN, mbTransform8x8)
+	})
+}
+
+// chromaDCCodedBlockFlagCtxIdxInc returns the ctxIdxInc of coded_block_flag
+// of the chroma DC block of the Cb, for iCbCr 0, or Cr component of the
+// macroblock with address currMbAddr, as specified by 9.3.3.1.1.9. If
+// constrainedIntra, as when constrained_intra_pred_flag is 1 for slice data
+// partitions, inter macroblocks give 0 to an intra macroblock. The
+// coded_block_flag of a neighbouring block is taken to be 0 where the block
+// is not available, as for skipped macroblocks and those with no chroma
+// coefficients, being recorded only when decoded as 1.
+func chromaDCCodedBlockFlagCtxIdxInc(mbs *mbState, currMbAddr, iCbCr int, constrainedIntra bool) int {
+	return dcCodedBlockFlagCtxIdxInc(mbs, currMbAddr, 1+iCbCr, constrainedIntra)
+}
+
+// dcCodedBlockFlagCtxIdxInc returns the ctxIdxInc of coded_block_flag of the
+// DC block of colour component comp, 0 to 2 for Y, Cb and Cr, of the
+// macroblock with address currMbAddr, being the Intra16x16 DC block of the
+// component, or for Cb and Cr when ChromaArrayType is 1 or 2 the chroma DC
+// block, as for chromaDCCodedBlockFlagCtxIdxInc.
+func dcCodedBlockFlagCtxIdxInc(mbs *mbState, currMbAddr, comp int, constrainedIntra bool) int {
+	mbAddrA, mbAddrB := mbs.mbNeighboursAB(currMbAddr)
+	cbf := func(blkN neighbourBlk) bool {
+		return mbs.has(blkN.mbAddr, mbCbfYDC<<uint(comp))
+	}
+	return cbfCondTermFlag(mbs, currMbAddr, neighbourBlk{mbAddr: mbAddrA}, constrainedIntra, cbf) +
+		2*cbfCondTermFlag(mbs, currMbAddr, neighbourBlk{mbAddr: mbAddrB}, constrainedIntra, cbf)
+}
+
+// blockCodedBlockFlagCtxIdxInc returns the ctxIdxInc of coded_block_flag of
+// the 4x4 or 8x8 block of colour component comp, being an AC block of an
+// Intra16x16 macroblock, a 4x4 or 8x8 luma block, or a chroma AC block,
+// whose top left sample is at (x, y) relative to the macroblock with address
+// currMbAddr, as specified by 9.3.3.1.1.9. maxW, maxH and constrainedIntra
+// are as for coeffTokenNC.
+//
+// The number of non-zero coefficients of each block, as stored in the
+// totalCoeff of mbs, gives its coded_block_flag, being 0 for blocks with no
+// coefficients by the coded_block_pattern, and for each 4x4 block of an 8x8
+// block being that of the 8x8 block.
+func blockCodedBlockFlagCtxIdxInc(mbs *mbState, currMbAddr, comp, x, y, maxW, maxH int, constrainedIntra bool) int {
+	cbf := func(blkN neighbourBlk) bool {
+		return mbs.totalCoeff[comp][blkN.mbAddr*blocksPerMb+blkN.blkIdx] != 0
+	}
+	blkA, blkB := mbs.neighbour4x4Blks(currMbAddr, x, y, maxW, maxH)
+	return cbfCondTermFlag(mbs, currMbAddr, blkA, constrainedIntra, cbf) +
+		2*cbfCondTermFlag(mbs, currMbAddr, blkB, constrainedIntra, cbf)
+}
+
+// block8x8CodedBlockFlagCtxIdxInc returns ctxIdxInc for the coded_block_flag
+// of the 8x8 block of colour component comp, of a 4:4:4 macroblock, whose top
+// left sample is at (x, y) relative to the macroblock with address
+// currMbAddr, as specified by 9.3.3.1.1.9 for ctxBlockCat 5, 9 and 13. The
+// 8x8 block of a neighbouring macroblock is only taken as coded if the
+// macroblock has transform_size_8x8_flag 1 and the block's bit of
+// CodedBlockPatternLuma is set, its coded_block_flag being recorded as the
+// number of non-zero coefficients of its 4x4 blocks.
+func block8x8CodedBlockFlagCtxIdxInc(mbs *mbState, currMbAddr, comp, x, y int, constrainedIntra bool) int {
+	cbf := func(blkN neighbourBlk) bool {
+		return mbs.has(blkN.mbAddr, mbTransform8x8) &&
+			(mbs.codedBlockPattern[blkN.mbAddr]>>uint(blkN.blkIdx))&1 != 0 &&
+			mbs.totalCoeff[comp][blkN.mbAddr*blocksPerMb+4*blkN.blkIdx] != 0
+	}
+	blkA, blkB := mbs.neighbour8x8Blks(currMbAddr, luma8x8BlkIdx(x, y))
+	return cbfCondTermFlag(mbs, currMbAddr, blkA, constrainedIntra, cbf) +
+		2*cbfCondTermFlag(mbs, currMbAddr, blkB, constrainedIntra, cbf)
+}
+
+// cbfCondTermFlag returns condTermFlagN for the coded_block_flag of a block of
+// the macroblock with address currMbAddr, given the neighbouring block blkN,
+// as specified by 9.3.3.1.1.9, cbf giving the coded_block_flag of the
+// neighbouring block.
+func cbfCondTermFlag(mbs *mbState, currMbAddr int, blkN neighbourBlk, constrainedIntra bool, cbf func(blkN neighbourBlk) bool) int {
+	switch {
+	case blkN.mbAddr == MbAddrNotAvailable:
+		return flagVal(mbs.has(currMbAddr, mbIntraCoded))
+	case !mbs.intraAvailable(blkN.mbAddr, currMbAddr, constrainedIntra):
+		return 0
+	case mbs.has(blkN.mbAddr, mbPCM):
+		return 1
+	case mbs.has(blkN.mbAddr, mbSkipped):
+		return 0
+	}
+	return flagVal(cbf(blkN))
+}
+
+// refIdxCtxIdxInc returns the ctxIdxInc of the first bin of ref_idx_lX, for
+// list 0 or 1, of the partition of the macroblock with address currMbAddr
+// whose top left luma sample is at (x, y) relative to the macroblock, in a
+// slice of the given type, as specified by 9.3.3.1.1.6. The refIdx of
+// neighbouring partitions, including those of the current macroblock already
+// decoded, must be recorded in mbs.
+func refIdxCtxIdxInc(mbs *mbState, currMbAddr int, sliceType string, list, x, y int) int {
+	currMbFrame := !mbs.has(currMbAddr, mbFieldDecoded)
+	condTermFlag := func(xN, yN int) int {
+		mbAddrN, xW, yW := mbs.neighbourLocation(currMbAddr, xN, yN, 16, 16)
+		if mbAddrN == MbAddrNotAvailable || mbs.has(mbAddrN, mbSkipped) || mbs.has(mbAddrN, mbIntraCoded) {
+			return 0
+		}
+		part := mbAddrN*partitionsPerMb + luma8x8BlkIdx(xW, yW)
+		if sliceType == "B" {
+			// Partitions predicted in direct mode have no ref_idx.
+			switch {
+			case mbs.mbType[mbAddrN] == bDirect16x16:
+				return 0
+			case mbs.mbType[mbAddrN] == b8x8 && mbs.subMbType[part] == bDirect8x8:
+				return 0
+			}
+		}
+		refIdx := int(mbs.refIdx[list][part])
+		if mbs.mbaff && currMbFrame && mbs.has(mbAddrN, mbFieldDecoded) {
+			// Field macroblocks have twice as many reference fields.
+			refIdx >>= 1
+		}
+		return flagVal(refIdx > 0)
+	}
+	return condTermFlag(x-1, y) + 2*condTermFlag(x, y-1)
+}
+
+// Values of mb_type and sub_mb_type of B slices predicted in direct mode or
+// with sub-macroblock partitions (tables 7-14 and 7-18).
+const (
+	bDirect16x16 = 0
+	b8x8         = 22
+	bDirect8x8   = 0
+)
+
+// mvdCtxIdxInc returns the ctxIdxInc of the first bin of component compIdx,
+// 0 for horizontal and 1 for vertical, of mvd_lX, for list 0 or 1, of the
+// sub-macroblock partition of the macroblock with address currMbAddr whose top
+// left luma sample is at (x, y) relative to the macroblock, as specified by
+// 9.3.3.1.1.7. The mvd and refIdx of neighbouring partitions, including those
+// of the current macroblock already decoded, must be recorded in mbs, with
+// mvd of 0 for partitions having none.
+func mvdCtxIdxInc(mbs *mbState, currMbAddr, list, compIdx, x, y int) int {
+	currMbField := mbs.has(currMbAddr, mbFieldDecoded)
+	absMvdComp := func(xN, yN int) int {
+		mbAddrN, xW, yW := mbs.neighbourLocation(currMbAddr, xN, yN, 16, 16)
+		if mbAddrN == MbAddrNotAvailable || mbs.has(mbAddrN, mbSkipped) || mbs.has(mbAddrN, mbIntraCoded) {
+			return 0
+		}
+		if mbs.refIdx[list][mbAddrN*partitionsPerMb+luma8x8BlkIdx(xW, yW)] < 0 {
+			return 0
+		}
+		mvd := mbs.mvd[list][mbAddrN*blocksPerMb+luma4x4BlkIdx(xW, yW)]
+		if compIdx == 0 {
+			return abs(int(mvd.X))
+		}
+		a := abs(int(mvd.Y))
+		if mbs.mbaff {
+			// Scale vertical components to the units of the current macroblock.
+			switch nField := mbs.has(mbAddrN, mbFieldDecoded); {
+			case !currMbField && nField:
+				a *= 2
+			case currMbField && !nField:
+				a /= 2
+			}
+		}
+		return a
+	}
+	switch sum := absMvdComp(x-1, y) + absMvdComp(x, y-1); {
+	case sum < 3:
+		return 0
+	case sum > 32:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,368 @@
+/*
+NAME
+  cabacctxinc_test.go
+
+DESCRIPTION
+  cabacctxinc_test.go provides testing for functionality provided in
+  cabacctxinc.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+// Addresses of macroblocks of the 3x3 macroblock picture given by
+// ctxIncState, the current macroblock having its neighbours A to the left, B
+// above, C above right and D above left.
+const (
+	ctxIncMbD    = 0
+	ctxIncMbB    = 1
+	ctxIncMbC    = 2
+	ctxIncMbA    = 3
+	ctxIncMbCurr = 4
+)
+
+// ctxIncState returns an mbState for a 3x3 macroblock picture in which the
+// given macroblocks and then the current macroblock have been begun in the
+// same slice, with the flags set by f for each of them.
+func ctxIncState(f func(mbAddr int) mbFlags, mbAddrs ...int) *mbState {
+	mbs := newMbState(3, 3)
+	sliceNum := mbs.startSlice()
+	for _, mbAddr := range append(mbAddrs, ctxIncMbCurr) {
+		mbs.beginMb(mbAddr, sliceNum, f(mbAddr))
+	}
+	return mbs
+}
+
+// noFlags is a flags function for ctxIncState giving no flags.
+func noFlags(int) mbFlags { return 0 }
+
+func TestMbSkipFlagCtxIdxInc(t *testing.T) {
+	tests := []struct {
+		mbAddrs []int
+		skipped int // Address of a skipped macroblock.
+		want    int
+	}{
+		{want: 0},
+		{mbAddrs: []int{ctxIncMbA}, skipped: -1, want: 1},
+		{mbAddrs: []int{ctxIncMbA, ctxIncMbB}, skipped: -1, want: 2},
+		{mbAddrs: []int{ctxIncMbA, ctxIncMbB}, skipped: ctxIncMbB, want: 1},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(func(mbAddr int) mbFlags {
+			if mbAddr == test.skipped {
+				return mbSkipped
+			}
+			return 0
+		}, test.mbAddrs...)
+		got := mbSkipFlagCtxIdxInc(mbs, ctxIncMbCurr)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestMbFieldDecodingFlagCtxIdxInc(t *testing.T) {
+	// 2x2 macroblock pair MBAFF frame, with the pairs to the left of and above
+	// pair 3 being field and frame pairs.
+	mbs := newMbState(2, 4)
+	mbs.mbaff = true
+	sliceNum := mbs.startSlice()
+	for mbAddr := 0; mbAddr < 6; mbAddr++ {
+		mbs.beginMb(mbAddr, sliceNum, 0)
+	}
+	mbs.setFieldDecoding(5, true)
+	mbs.beginMb(6, sliceNum, 0)
+
+	if got := mbFieldDecodingFlagCtxIdxInc(mbs, 6); got != 1 {
+		t.Errorf("did not get expected result\nGot: %v\nWant: %v", got, 1)
+	}
+	mbs.setFieldDecoding(3, true)
+	if got := mbFieldDecodingFlagCtxIdxInc(mbs, 6); got != 2 {
+		t.Errorf("did not get expected result\nGot: %v\nWant: %v", got, 2)
+	}
+}
+
+func TestMbTypeCtxIdxInc(t *testing.T) {
+	tests := []struct {
+		sliceType    string
+		ctxIdxOffset int
+		mbTypeA      int // mb_type of the macroblock to the left, or -1 if not available.
+		mbTypeB      int // mb_type of the macroblock above, or -1 if not available.
+		skipB        bool
+		want         int
+	}{
+		{sliceType: "I", ctxIdxOffset: 3, mbTypeA: -1, mbTypeB: -1, want: 0},
+		{sliceType: "I", ctxIdxOffset: 3, mbTypeA: 0, mbTypeB: 0, want: 0},
+		{sliceType: "I", ctxIdxOffset: 3, mbTypeA: 1, mbTypeB: 0, want: 1},
+		{sliceType: "I", ctxIdxOffset: 3, mbTypeA: 25, mbTypeB: 12, want: 2},
+		{sliceType: "SI", ctxIdxOffset: 0, mbTypeA: 0, mbTypeB: 1, want: 1},
+		{sliceType: "SI", ctxIdxOffset: 3, mbTypeA: 0, mbTypeB: 1, want: 1},
+		{sliceType: "B", ctxIdxOffset: 27, mbTypeA: 0, mbTypeB: 3, want: 1},
+		{sliceType: "B", ctxIdxOffset: 27, mbTypeA: 3, mbTypeB: 3, skipB: true, want: 1},
+		{sliceType: "B", ctxIdxOffset: 27, mbTypeA: 3, mbTypeB: -1, want: 1},
+	}
+
+	for i, test := range tests {
+		var mbAddrs []int
+		if test.mbTypeA >= 0 {
+			mbAddrs = append(mbAddrs, ctxIncMbA)
+		}
+		if test.mbTypeB >= 0 {
+			mbAddrs = append(mbAddrs, ctxIncMbB)
+		}
+		mbs := ctxIncState(func(mbAddr int) mbFlags {
+			if mbAddr == ctxIncMbB && test.skipB {
+				return mbSkipped
+			}
+			return 0
+		}, mbAddrs...)
+		mbs.mbType[ctxIncMbA] = uint8(test.mbTypeA)
+		mbs.mbType[ctxIncMbB] = uint8(test.mbTypeB)
+
+		got := mbTypeCtxIdxInc(mbs, ctxIncMbCurr, test.sliceType, test.ctxIdxOffset)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestCodedBlockPatternCtxIdxInc(t *testing.T) {
+	tests := []struct {
+		flagsA, flagsB mbFlags
+		cbpA, cbpB     uint8
+		b8             int
+		bins           []int
+		binIdx         int // Chroma bin index.
+		wantLuma       int
+		wantChroma     int
+	}{
+		// Block 0 has neighbours block 1 of A and block 2 of B.
+		{cbpA: 0x02, cbpB: 0x04, b8: 0, wantLuma: 0, wantChroma: 0},
+		{cbpA: 0x0d, cbpB: 0x0b, b8: 0, wantLuma: 3, wantChroma: 0},
+		// Block 3 has neighbours blocks 2 and 1 of the current macroblock.
+		{b8: 3, bins: []int{0, 1, 0}, wantLuma: 1, wantChroma: 0},
+		{flagsA: mbSkipped, flagsB: mbPCM, b8: 0, wantLuma: 1, wantChroma: 2},
+		{cbpA: 0x10, cbpB: 0x20, binIdx: 0, wantLuma: 3, wantChroma: 3},
+		{cbpA: 0x10, cbpB: 0x20, binIdx: 1, wantLuma: 3, wantChroma: 6},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(func(mbAddr int) mbFlags {
+			switch mbAddr {
+			case ctxIncMbA:
+				return test.flagsA
+			case ctxIncMbB:
+				return test.flagsB
+			}
+			return 0
+		}, ctxIncMbA, ctxIncMbB)
+		mbs.codedBlockPattern[ctxIncMbA] = test.cbpA
+		mbs.codedBlockPattern[ctxIncMbB] = test.cbpB
+
+		if got := cbpLumaCtxIdxInc(mbs, ctxIncMbCurr, test.b8, test.bins); got != test.wantLuma {
+			t.Errorf("did not get expected luma result for test: %d\nGot: %v\nWant: %v", i, got, test.wantLuma)
+		}
+		if got := cbpChromaCtxIdxInc(mbs, ctxIncMbCurr, test.binIdx); got != test.wantChroma {
+			t.Errorf("did not get expected chroma result for test: %d\nGot: %v\nWant: %v", i, got, test.wantChroma)
+		}
+	}
+
+	// Neither neighbour available.
+	mbs := ctxIncState(noFlags)
+	if got := cbpLumaCtxIdxInc(mbs, ctxIncMbCurr, 0, nil); got != 0 {
+		t.Errorf("did not get expected luma result with no neighbours\nGot: %v\nWant: 0", got)
+	}
+}
+
+func TestMbQpDeltaCtxIdxInc(t *testing.T) {
+	mbs := ctxIncState(func(mbAddr int) mbFlags {
+		if mbAddr == ctxIncMbA {
+			return mbQpDelta
+		}
+		return 0
+	}, 2, ctxIncMbA)
+
+	tests := []struct {
+		prevMbAddr int
+		want       int
+	}{
+		{prevMbAddr: ctxIncMbA, want: 1},
+		{prevMbAddr: 2, want: 0},
+		{prevMbAddr: 0, want: 0}, // Not in the slice.
+		{prevMbAddr: -1, want: 0},
+	}
+
+	for i, test := range tests {
+		got := mbQpDeltaCtxIdxInc(mbs, test.prevMbAddr, ctxIncMbCurr)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestIntraCtxIdxInc(t *testing.T) {
+	tests := []struct {
+		flagsA, flagsB mbFlags
+		modeA, modeB   uint8
+		wantChroma     int
+		wantTransform  int
+	}{
+		{flagsA: mbIntraCoded, flagsB: mbIntraCoded, modeA: 1, modeB: 3, wantChroma: 2},
+		{flagsA: mbIntraCoded, flagsB: mbIntraCoded | mbTransform8x8, modeA: 0, modeB: 3, wantChroma: 1, wantTransform: 1},
+		{flagsA: mbIntraCoded | mbPCM, flagsB: mbTransform8x8, modeA: 1, modeB: 1, wantChroma: 0, wantTransform: 1},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(func(mbAddr int) mbFlags {
+			switch mbAddr {
+			case ctxIncMbA:
+				return test.flagsA
+			case ctxIncMbB:
+				return test.flagsB
+			}
+			return 0
+		}, ctxIncMbA, ctxIncMbB)
+		mbs.intraChromaPredMode[ctxIncMbA] = test.modeA
+		mbs.intraChromaPredMode[ctxIncMbB] = test.modeB
+
+		if got := intraChromaPredModeCtxIdxInc(mbs, ctxIncMbCurr); got != test.wantChroma {
+			t.Errorf("did not get expected intra_chroma_pred_mode result for test: %d\nGot: %v\nWant: %v", i, got, test.wantChroma)
+		}
+		if got := transformSize8x8FlagCtxIdxInc(mbs, ctxIncMbCurr); got != test.wantTransform {
+			t.Errorf("did not get expected transform_size_8x8_flag result for test: %d\nGot: %v\nWant: %v", i, got, test.wantTransform)
+		}
+	}
+}
+
+func TestBlockCodedBlockFlagCtxIdxInc(t *testing.T) {
+	tests := []struct {
+		flags      func(mbAddr int) mbFlags
+		mbAddrs    []int
+		comp, x, y int
+		maxW, maxH int
+		totalCoeff map[int]uint8 // Indexed by mbAddr*blocksPerMb+blkIdx.
+		want       int
+	}{
+		// Neighbours not available to an intra macroblock.
+		{flags: func(int) mbFlags { return mbIntraCoded }, maxW: 16, maxH: 16, want: 3},
+
+		// Neighbouring blocks in macroblocks A and B, and in the current
+		// macroblock.
+		{flags: noFlags, mbAddrs: []int{ctxIncMbA, ctxIncMbB}, maxW: 16, maxH: 16, totalCoeff: map[int]uint8{ctxIncMbA*blocksPerMb + 5: 3}, want: 1},
+		{flags: noFlags, mbAddrs: []int{ctxIncMbA, ctxIncMbB}, maxW: 16, maxH: 16, totalCoeff: map[int]uint8{ctxIncMbB*blocksPerMb + 10: 1}, want: 2},
+		{flags: noFlags, x: 4, y: 4, maxW: 16, maxH: 16, totalCoeff: map[int]uint8{ctxIncMbCurr*blocksPerMb + 1: 2, ctxIncMbCurr*blocksPerMb + 2: 1}, want: 3},
+
+		// A Cb AC block of a 4:2:0 macroblock, neighbour A being block 1 of
+		// macroblock A.
+		{flags: noFlags, mbAddrs: []int{ctxIncMbA}, comp: 1, maxW: 8, maxH: 8, totalCoeff: map[int]uint8{ctxIncMbA*blocksPerMb + 1: 1}, want: 1},
+
+		// An I_PCM neighbour.
+		{
+			flags: func(mbAddr int) mbFlags {
+				return map[int]mbFlags{ctxIncMbB: mbIntraCoded | mbPCM}[mbAddr]
+			},
+			mbAddrs: []int{ctxIncMbA, ctxIncMbB},
+			maxW:    16,
+			maxH:    16,
+			want:    2,
+		},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(test.flags, test.mbAddrs...)
+		for blk, n := range test.totalCoeff {
+			mbs.totalCoeff[test.comp][blk] = n
+		}
+		got := blockCodedBlockFlagCtxIdxInc(mbs, ctxIncMbCurr, test.comp, test.x, test.y, test.maxW, test.maxH, false)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestRefIdxCtxIdxInc(t *testing.T) {
+	tests := []struct {
+		sliceType  string
+		flagsA     mbFlags
+		mbTypeA    uint8
+		subMbTypeA uint8
+		refIdxA    int8 // refIdx of partition 1 of A.
+		refIdxB    int8 // refIdx of partition 2 of B.
+		refIdxCurr int8 // refIdx of partition 0 of the current macroblock.
+		x, y       int
+		want       int
+	}{
+		{sliceType: "P", refIdxA: 1, refIdxB: 2, want: 3},
+		{sliceType: "P", refIdxA: 0, refIdxB: 2, want: 2},
+		{sliceType: "P", refIdxA: -1, refIdxB: 0, want: 0},
+		{sliceType: "P", flagsA: mbIntraCoded, refIdxA: 1, refIdxB: 1, want: 2},
+		{sliceType: "P", flagsA: mbSkipped, refIdxA: 1, refIdxB: 1, want: 2},
+		{sliceType: "B", mbTypeA: bDirect16x16, refIdxA: 1, refIdxB: 1, want: 2},
+		{sliceType: "B", mbTypeA: b8x8, subMbTypeA: bDirect8x8, refIdxA: 1, refIdxB: 1, want: 2},
+		{sliceType: "B", mbTypeA: b8x8, subMbTypeA: 1, refIdxA: 1, refIdxB: 1, want: 3},
+		// Partition 1 of the current macroblock has partition 0 to its left.
+		{sliceType: "P", refIdxCurr: 1, x: 8, want: 1},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(func(mbAddr int) mbFlags {
+			if mbAddr == ctxIncMbA {
+				return test.flagsA
+			}
+			return 0
+		}, ctxIncMbA, ctxIncMbB)
+		mbs.mbType[ctxIncMbA] = test.mbTypeA
+		mbs.mbType[ctxIncMbB] = 1
+		mbs.subMbType[ctxIncMbA*partitionsPerMb+1] = test.subMbTypeA
+		mbs.refIdx[1][ctxIncMbA*partitionsPerMb+1] = test.refIdxA
+		mbs.refIdx[1][ctxIncMbB*partitionsPerMb+2] = test.refIdxB
+		mbs.refIdx[1][ctxIncMbCurr*partitionsPerMb] = test.refIdxCurr
+
+		got := refIdxCtxIdxInc(mbs, ctxIncMbCurr, test.sliceType, 1, test.x, test.y)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestMvdCtxIdxInc(t *testing.T) {
+	tests := []struct {
+		flagsA        mbFlags
+		refIdxA       int8
+		mvdA, mvdB    motionVector // Of block 5 of A and block 10 of B.
+		compIdx, want int
+	}{
+		{mvdA: motionVector{X: 1}, mvdB: motionVector{X: 1}, want: 0},
+		{mvdA: motionVector{X: 1}, mvdB: motionVector{X: 2}, want: 1},
+		{mvdA: motionVector{X: 16}, mvdB: motionVector{X: -16}, want: 1},
+		{mvdA: motionVector{X: 16}, mvdB: motionVector{X: -17}, want: 2},
+		{mvdA: motionVector{Y: -20}, mvdB: motionVector{X: 20, Y: 20}, compIdx: 1, want: 2},
+		{flagsA: mbIntraCoded, mvdA: motionVector{Y: -20}, mvdB: motionVector{Y: 2}, compIdx: 1, want: 0},
+		{refIdxA: -1, mvdA: motionVector{X: 20}, mvdB: motionVector{X: 20}, want: 1},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(func(mbAddr int) mbFlags {
+			if mbAddr == ctxIncMbA {
+				return test.flagsA
+			}
+			return 0
+		}, ctxIncMbA, ctxIncMbB)
+		mbs.refIdx[0][ctxIncMbA*partitionsPerMb+1] = test.refIdxA
+		mbs.refIdx[0][ctxIncMbB*partitionsPerMb+2] = 0
+		mbs.mvd[0][ctxIncMbA*blocksPerMb+5] = test.mvdA
+		mbs.mvd[0][ctxIncMbB*blocksPerMb+10] = test.mvdB
+
+		// The 4x4 sub-macroblock partition at (0, 0) has neighbours block 5
+		// of A and block 10 of B.
+		got := mvdCtxIdxInc(mbs, ctxIncMbCurr, 0, test.compIdx, 0, 0)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+/*
+NAME
+  cabacdec.go
+
+DESCRIPTION
+  cabacdec.go provides the arithmetic decoding engine of CABAC, as specified
+  in sections 9.3.1.2 and 9.3.3.2 of the specifications, decoding the bins
+  of the syntax elements of a slice using the context variables initialised
+  for it.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	mathbits "math/bits"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// bitSource is the source of the bits decoded by a cabacDecoder. It is
+// satisfied by *bits.BitReader, for decoding slice data following the slice
+// header read by it, and by *byteBits, for decoding slice data, or data
+// partitions, already extracted.
+type bitSource interface {
+	// ReadBits reads n bits, returning them as the least significant bits
+	// of the result.
+	ReadBits(n int) (uint64, error)
+
+	// Off returns the number of bits read.
+	Off() int
+}
+
+// seekableBitSource is a bitSource whose position may be set, allowing a
+// cabacDecoder to be restored to an earlier state, see Restore. It is
+// satisfied by *byteBits, and by *bits.BitReader for sources that are
+// io.Seekers, as are the RBSPs of slices.
+type seekableBitSource interface {
+	bitSource
+	Seek(off int) error
+}
+
+// cabacDecoder decodes the bins of the slice data of a slice coded using
+// CABAC, holding the state of the arithmetic decoding engine and the context
+// variables of the slice.
+type cabacDecoder struct {
+	br         bitSource
+	codIRange  int
+	codIOffset int
+	ctx        [numCtxIdx]ContextVariable
+}
+
+// newCABACDecoder returns a cabacDecoder reading from br, which must be at
+// the byte aligned start of the slice data following any
+// cabac_alignment_one_bit, with context variables initialised for a slice of
+// the given type, cabac_init_idc and SliceQPY (9.3.1.1), and the decoding
+// engine initialised (9.3.1.2).
+func newCABACDecoder(br bitSource, sliceType string, cabacInitIdc, sliceQPY int) (*cabacDecoder, error) {
+	ctx, err := InitContextVariables(sliceType, cabacInitIdc, sliceQPY)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialise context variables: %w", err)
+	}
+	d := &cabacDecoder{br: br, ctx: ctx}
+	return d, d.initEngine()
+}
+
+// initEngine initialises the decoding engine, as at the start of slice data
+// and following I_PCM samples (9.3.1.2).
+func (d *cabacDecoder) initEngine() error {
+	off, err := d.br.ReadBits(9)
+	if err != nil {
+		return fmt.Errorf("could not read codIOffset: %w", err)
+	}
+	d.codIRange, d.codIOffset = 510, int(off)
+	if d.codIOffset >= 510 {
+		return fmt.Errorf("%w: codIOffset %d", errBadCodIOffset, d.codIOffset)
+	}
+	return nil
+}
+
+// decodeBin decodes a bin using the context variable with index ctxIdx, or
+// using decodeTerminate if ctxIdx is 276, as for the bins of mb_type
+// indicating I_PCM and for end_of_slice_flag (9.3.3.2).
+func (d *cabacDecoder) decodeBin(ctxIdx int) (int, error) {
+	if ctxIdx == ctxIdxEndOfSlice {
+		return d.decodeTerminate()
+	}
+	return d.decodeDecision(ctxIdx)
+}
+
+// decodeDecision decodes a bin using the context variable with index ctxIdx,
+// updating its state, as specified by 9.3.3.2.1.
+func (d *cabacDecoder) decodeDecision(ctxIdx int) (int, error) {
+	c := &d.ctx[ctxIdx]
+	codIRangeLPS := rangeTabLPS[c.PStateIdx][(d.codIRange>>6)&3]
+	d.codIRange -= codIRangeLPS
+
+	var binVal int
+	if d.codIOffset >= d.codIRange {
+		binVal = 1 - c.ValMPS
+		d.codIOffset -= d.codIRange
+		d.codIRange = codIRangeLPS
+		if c.PStateIdx == 0 {
+			c.ValMPS = 1 - c.ValMPS
+		}
+		c.PStateIdx = stateTransxTab[c.PStateIdx].TransIdxLPS
+	} else {
+		binVal = c.ValMPS
+		c.PStateIdx = stateTransxTab[c.PStateIdx].TransIdxMPS
+	}
+	return binVal, d.renormD()
+}
+
+// decodeBypass decodes a bin with equiprobable values, as specified by
+// 9.3.3.2.3.
+func (d *cabacDecoder) decodeBypass() (int, error) {
+	b, err := d.br.ReadBits(1)
+	if err != nil {
+		return 0, fmt.Errorf("could not read bit: %w", err)
+	}
+	d.codIOffset = d.codIOffset<<1 | int(b)
+	if d.codIOffset >= d.codIRange {
+		d.codIOffset -= d.codIRange
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// decodeTerminate decodes a bin before termination, as specified by
+// 9.3.3.2.2. When the bin is 1 no renormalization is performed, decoding of
+// the slice data having ended or, following I_PCM mb_type, the engine to be
+// initialised after the PCM samples.
+func (d *cabacDecoder) decodeTerminate() (int, error) {
+	d.codIRange -= 2
+	if d.codIOffset >= d.codIRange {
+		return 1, nil
+	}
+	return 0, d.renormD()
+}
+
+// renormD renormalizes the decoding engine, as specified by 9.3.3.2.2.
+// Rather than doubling codIRange a bit at a time until it is at least 256,
+// the number of doublings is found from its leading zeros, codIRange having
+// at most 9 bits, and that many bits are read at once.
+func (d *cabacDecoder) renormD() error {
+	n := 9 - mathbits.Len(uint(d.codIRange))
+	if n <= 0 {
+		return nil
+	}
+	b, err := d.br.ReadBits(n)
+	if err != nil {
+		return fmt.Errorf("could not read bits: %w", err)
+	}
+	d.codIRange <<= uint(n)
+	d.codIOffset = d.codIOffset<<uint(n) | int(b)
+	return nil
+}
+
+// cabacState is the state of a cabacDecoder, being that of its decoding
+// engine and context variables and the position of its bit reader, as a value
+// that may be copied, see Save and Restore.
+type cabacState struct {
+	codIRange  int
+	codIOffset int
+	ctx        [numCtxIdx]ContextVariable
+	off        int // Bit offset of the bit reader.
+}
+
+// Save returns the current state of d, such that decoding may be resumed from
+// it using Restore, e.g. after decoding speculatively, as for the skipped top
+// macroblocks of MBAFF frames, see pairFieldDecoding, or to decode the same
+// bins with context variables shared by another decoder.
+func (d *cabacDecoder) Save() cabacState {
+	return cabacState{codIRange: d.codIRange, codIOffset: d.codIOffset, ctx: d.ctx, off: d.br.Off()}
+}
+
+// Restore sets the state of d to s, as returned by Save. If bits have been
+// read since s was saved, the bit source of d is returned to the position at
+// which it was saved, an error wrapping errCABACRewind being returned if it is
+// not a seekableBitSource or its source cannot be sought.
+func (d *cabacDecoder) Restore(s cabacState) error {
+	if off := d.br.Off(); off != s.off {
+		sbs, ok := d.br.(seekableBitSource)
+		if !ok {
+			return fmt.Errorf("%w: state saved at bit %d, reader at bit %d", errCABACRewind, s.off, off)
+		}
+		if err := sbs.Seek(s.off); err != nil {
+			return fmt.Errorf("%w: could not seek from bit %d to %d: %v", errCABACRewind, off, s.off, err)
+		}
+	}
+	d.codIRange, d.codIOffset, d.ctx = s.codIRange, s.codIOffset, s.ctx
+	return nil
+}
+
+// byteBits is a seekableBitSource reading the bits of a byte slice, most
+// significant bit first.
+type byteBits struct {
+	buf []byte
+	off int // Bit offset in buf.
+}
+
+// newByteBits returns a byteBits reading from the start of buf.
+func newByteBits(buf []byte) *byteBits {
+	return &byteBits{buf: buf}
+}
+
+// ReadBits implements bitSource, n being at most 64. If fewer than n bits
+// remain none are read and io.ErrUnexpectedEOF, or io.EOF if none remain, is
+// returned.
+func (b *byteBits) ReadBits(n int) (uint64, error) {
+	if n < 0 || n > 64 {
+		return 0, bits.ErrBadBitCount
+	}
+	switch rem := len(b.buf)*8 - b.off; {
+	case rem == 0 && n > 0:
+		return 0, io.EOF
+	case rem < n:
+		return 0, io.ErrUnexpectedEOF
+	}
+	var v uint64
+	for n > 0 {
+		// Take as many bits as remain in the current byte, up to n.
+		used := b.off % 8
+		take := 8 - used
+		if take > n {
+			take = n
+		}
+		c := uint64(b.buf[b.off/8]) >> uint(8-used-take) & (1<<uint(take) - 1)
+		v = v<<uint(take) | c
+		b.off += take
+		n -= take
+	}
+	return v, nil
+}
+
+// Off implements bitSource.
+func (b *byteBits) Off() int { return b.off }
+
+// Seek implements seekableBitSource.
+func (b *byteBits) Seek(off int) error {
+	if off < 0 || off > len(b.buf)*8 {
+		return fmt.Errorf("%w: %d", errBadBitOffset, off)
+	}
+	b.off = off
+	return nil
+}
+
+// cabacZeroWords returns the number of cabac_zero_word appended to the RBSP of
+// a slice coded using CABAC, following the rbsp_trailing_bits whose
+// rbsp_stop_one_bit is at bit offset stopBit (7.3.2.10). On decoding an
+// end_of_slice_flag of 1 the decoding engine has read the rbsp_stop_one_bit,
+// so the remainder of the RBSP is the rbsp_alignment_zero_bits, to the end of
+// that byte, and then the 16 bit cabac_zero_words, each being 0x0000 once
+// emulation prevention bytes are removed.
+func cabacZeroWords(rbsp []byte, stopBit int) (int, error) {
+	n := len(rbsp) - (stopBit/8 + 1)
+	if n%2 != 0 {
+		return 0, fmt.Errorf("%w: %d bytes", errBadCabacZeroWords, n)
+	}
+	return n / 2, nil
+}
+
+// Errors used by the decoding engine.
+var (
+	errBadCodIOffset     = errors.New("codIOffset of 510 or 511 not permitted")
+	errBadCabacZeroWords = errors.New("bytes following rbsp_trailing_bits not whole cabac_zero_words")
+	errCABACRewind       = errors.New("cannot restore CABAC state to earlier bit reader position")
+	errBadBitOffset      = errors.New("bit offset outside data")
+)
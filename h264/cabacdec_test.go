@@ -0,0 +1,443 @@
+/*
+NAME
+  cabacdec_test.go
+
+DESCRIPTION
+  cabacdec_test.go provides testing for functionality provided in
+  cabacdec.go, using the arithmetic encoder of section 9.3.4 of the
+  specifications to produce the data decoded.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// cabacEncoder is the arithmetic encoding engine of 9.3.4.2, used to produce
+// data for testing the decoding engine.
+type cabacEncoder struct {
+	ctx             [numCtxIdx]ContextVariable
+	codILow         int
+	codIRange       int
+	firstBitFlag    bool
+	bitsOutstanding int
+	bits            []int
+}
+
+// newCABACEncoder returns a cabacEncoder with context variables initialised
+// as by newCABACDecoder.
+func newCABACEncoder(t *testing.T, sliceType string, cabacInitIdc, sliceQPY int) *cabacEncoder {
+	ctx, err := InitContextVariables(sliceType, cabacInitIdc, sliceQPY)
+	if err != nil {
+		t.Fatalf("could not initialise context variables: %v", err)
+	}
+	return &cabacEncoder{ctx: ctx, codIRange: 510, firstBitFlag: true}
+}
+
+// encodeBin encodes binVal using the context variable with index ctxIdx, or
+// using encodeTerminate if ctxIdx is 276, as by decodeBin.
+func (e *cabacEncoder) encodeBin(ctxIdx, binVal int) {
+	if ctxIdx == ctxIdxEndOfSlice {
+		e.encodeTerminate(binVal)
+		return
+	}
+	e.encodeDecision(ctxIdx, binVal)
+}
+
+func (e *cabacEncoder) encodeDecision(ctxIdx, binVal int) {
+	c := &e.ctx[ctxIdx]
+	codIRangeLPS := rangeTabLPS[c.PStateIdx][(e.codIRange>>6)&3]
+	e.codIRange -= codIRangeLPS
+	if binVal != c.ValMPS {
+		e.codILow += e.codIRange
+		e.codIRange = codIRangeLPS
+		if c.PStateIdx == 0 {
+			c.ValMPS = 1 - c.ValMPS
+		}
+		c.PStateIdx = stateTransxTab[c.PStateIdx].TransIdxLPS
+	} else {
+		c.PStateIdx = stateTransxTab[c.PStateIdx].TransIdxMPS
+	}
+	e.renormE()
+}
+
+func (e *cabacEncoder) encodeBypass(binVal int) {
+	e.codILow <<= 1
+	if binVal != 0 {
+		e.codILow += e.codIRange
+	}
+	switch {
+	case e.codILow >= 1024:
+		e.putBit(1)
+		e.codILow -= 1024
+	case e.codILow < 512:
+		e.putBit(0)
+	default:
+		e.codILow -= 512
+		e.bitsOutstanding++
+	}
+}
+
+// encodeTerminate encodes a bin before termination, flushing the encoder if
+// binVal is 1.
+func (e *cabacEncoder) encodeTerminate(binVal int) {
+	e.codIRange -= 2
+	if binVal == 0 {
+		e.renormE()
+		return
+	}
+	e.codILow += e.codIRange
+	e.codIRange = 2
+	e.renormE()
+	e.putBit((e.codILow >> 9) & 1)
+	e.bits = append(e.bits, (e.codILow>>8)&1, 1) // The last being rbsp_stop_one_bit.
+}
+
+// encodeUEGk encodes v using the UEGk binarization with the given
+// signedValFlag, uCoff and k, the bins of the truncated unary prefix using the
+// context variables given by ctxIdx and the others in bypass mode.
+func (e *cabacEncoder) encodeUEGk(v int, signed bool, uCoff, k int, ctxIdx func(binIdx int) int) {
+	prefixLen := abs(v) + 1
+	if prefixLen > uCoff {
+		prefixLen = uCoff
+	}
+	for binIdx, bin := range uegkBins(v, signed, uCoff, k) {
+		if binIdx < prefixLen {
+			e.encodeDecision(ctxIdx(binIdx), bin)
+		} else {
+			e.encodeBypass(bin)
+		}
+	}
+}
+
+func (e *cabacEncoder) renormE() {
+	for e.codIRange < 256 {
+		switch {
+		case e.codILow < 256:
+			e.putBit(0)
+		case e.codILow >= 512:
+			e.codILow -= 512
+			e.putBit(1)
+		default:
+			e.codILow -= 256
+			e.bitsOutstanding++
+		}
+		e.codIRange <<= 1
+		e.codILow <<= 1
+	}
+}
+
+func (e *cabacEncoder) putBit(b int) {
+	if e.firstBitFlag {
+		e.firstBitFlag = false
+	} else {
+		e.bits = append(e.bits, b)
+	}
+	for ; e.bitsOutstanding > 0; e.bitsOutstanding-- {
+		e.bits = append(e.bits, 1-b)
+	}
+}
+
+// bytes returns the encoded bits, padded with zero bits to a whole number of
+// bytes.
+func (e *cabacEncoder) bytes() []byte {
+	buf := make([]byte, (len(e.bits)+7)/8)
+	for i, b := range e.bits {
+		buf[i/8] |= byte(b) << uint(7-i%8)
+	}
+	return buf
+}
+
+// reader returns a BitReader reading the encoded bits, see bytes.
+func (e *cabacEncoder) reader() *bits.BitReader {
+	return bits.NewBitReader(bytes.NewReader(e.bytes()))
+}
+
+// TestCABACDecoder checks that bins encoded using context variables, bypass
+// encoding and termination decode to the same values, and that the decoding
+// engine finishes having read the rbsp_stop_one_bit.
+func TestCABACDecoder(t *testing.T) {
+	const n = 5000
+	rng := rand.New(rand.NewSource(1))
+	type bin struct{ ctxIdx, val int } // ctxIdx is -1 for bypass bins.
+	bins := make([]bin, n)
+	for i := range bins {
+		bins[i].ctxIdx = rng.Intn(numCtxIdx+1) - 1
+		if bins[i].ctxIdx == ctxIdxEndOfSlice {
+			bins[i].ctxIdx = -1
+		}
+		// Skew values, so that context variables adapt.
+		if rng.Intn(4) == 0 {
+			bins[i].val = 1
+		}
+	}
+
+	e := newCABACEncoder(t, "P", 1, 30)
+	for _, b := range bins {
+		if b.ctxIdx < 0 {
+			e.encodeBypass(b.val)
+			continue
+		}
+		e.encodeBin(b.ctxIdx, b.val)
+		e.encodeTerminate(0)
+	}
+	e.encodeTerminate(1)
+
+	br := e.reader()
+	d, err := newCABACDecoder(br, "P", 1, 30)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from newCABACDecoder", err)
+	}
+	for i, b := range bins {
+		var got int
+		if b.ctxIdx < 0 {
+			got, err = d.decodeBypass()
+		} else {
+			got, err = d.decodeBin(b.ctxIdx)
+			if err == nil {
+				var term int
+				term, err = d.decodeTerminate()
+				if term != 0 {
+					t.Fatalf("did not expect termination after bin: %d", i)
+				}
+			}
+		}
+		if err != nil {
+			t.Fatalf("did not expect error: %v for bin: %d", err, i)
+		}
+		if got != b.val {
+			t.Fatalf("did not get expected value for bin: %d\nGot: %d\nWant: %d", i, got, b.val)
+		}
+	}
+	term, err := d.decodeTerminate()
+	if err != nil || term != 1 {
+		t.Fatalf("did not get expected termination\nGot: %d, %v\nWant: 1", term, err)
+	}
+	// The last bit read on termination is the rbsp_stop_one_bit.
+	if got, want := br.Off(), len(e.bits); got != want {
+		t.Errorf("did not end at expected bit\nGot: %d\nWant: %d", got, want)
+	}
+}
+
+func TestCABACDecoderInit(t *testing.T) {
+	_, err := newCABACDecoder(bits.NewBitReader(bytes.NewReader([]byte{0xff, 0x80})), "I", 0, 26)
+	if err == nil {
+		t.Errorf("expected error for codIOffset of 511")
+	}
+	_, err = newCABACDecoder(bits.NewBitReader(bytes.NewReader([]byte{0x00})), "I", 0, 26)
+	if err == nil {
+		t.Errorf("expected error for missing codIOffset")
+	}
+}
+
+func TestCabacZeroWords(t *testing.T) {
+	tests := []struct {
+		rbsp    []byte
+		stopBit int
+		want    int
+		err     error
+	}{
+		{rbsp: []byte{0xaa, 0x80}, stopBit: 8},
+		{rbsp: []byte{0xab}, stopBit: 7},
+		{rbsp: []byte{0xaa, 0x80, 0x00, 0x00}, stopBit: 8, want: 1},
+		{rbsp: []byte{0xa8, 0x00, 0x00, 0x00, 0x00}, stopBit: 4, want: 2},
+		{rbsp: []byte{0xaa, 0x80, 0x00}, stopBit: 8, err: errBadCabacZeroWords},
+	}
+
+	for i, test := range tests {
+		got, err := cabacZeroWords(test.rbsp, test.stopBit)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestCABACSaveRestore(t *testing.T) {
+	e := newCABACEncoder(t, "I", 0, 26)
+	for i := 0; i < 200; i++ {
+		e.encodeDecision(60, i%3/2)
+	}
+	e.encodeTerminate(1)
+
+	// The source is not seekable, so the decoder may only be restored to a
+	// state saved at its current position.
+	d, err := newCABACDecoder(bits.NewBitReader(bytes.NewBuffer(e.bytes())), "I", 0, 26)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from newCABACDecoder", err)
+	}
+	s := d.Save()
+
+	// The saved state is a copy, unaffected by changes to d.
+	d.ctx[60] = ContextVariable{PStateIdx: 62, ValMPS: 1}
+	d.codIRange = 300
+	if s.ctx[60] == d.ctx[60] {
+		t.Fatalf("saved context variable changed with decoder")
+	}
+	if err := d.Restore(s); err != nil {
+		t.Fatalf("did not expect error: %v from Restore", err)
+	}
+	if d.Save() != s {
+		t.Errorf("did not get expected state after Restore")
+	}
+
+	// Bins decode as if the changes had not been made.
+	for i := 0; i < 200; i++ {
+		b, err := d.decodeDecision(60)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for bin: %d", err, i)
+		}
+		if b != i%3/2 {
+			t.Fatalf("did not get expected value for bin: %d\nGot: %d\nWant: %d", i, b, i%3/2)
+		}
+	}
+
+	if err := d.Restore(s); !errors.Is(err, errCABACRewind) {
+		t.Errorf("did not get expected error\nGot: %v\nWant: %v", err, errCABACRewind)
+	}
+}
+
+// TestCABACRestoreSeek checks that a decoder reading from a seekableBitSource,
+// a byteBits or a BitReader of a seekable source, may be restored to a state
+// saved before bins were decoded, the bins then decoding again.
+func TestCABACRestoreSeek(t *testing.T) {
+	e := newCABACEncoder(t, "I", 0, 26)
+	want := []int{1, 0, 0, 1, 1, 1, 0, 1, 0, 0, 0, 0, 1, 1, 0, 1}
+	for _, b := range want {
+		e.encodeDecision(60, b)
+	}
+	for _, b := range want {
+		e.encodeBypass(b)
+	}
+	e.encodeTerminate(1)
+
+	for src, br := range []bitSource{newByteBits(e.bytes()), e.reader()} {
+		d, err := newCABACDecoder(br, "I", 0, 26)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from newCABACDecoder for source: %d", err, src)
+		}
+		restoreAndDecode(t, d, want, len(e.bits))
+	}
+}
+
+// restoreAndDecode restores d twice to its current state, each time decoding
+// the bins want with context index 60 and then bypass decoding them and
+// decoding a terminating bin of 1, checking that n bits are then read.
+func restoreAndDecode(t *testing.T, d *cabacDecoder, want []int, n int) {
+	s := d.Save()
+	for pass := 0; pass < 2; pass++ {
+		if err := d.Restore(s); err != nil {
+			t.Fatalf("did not expect error: %v from Restore for pass: %d", err, pass)
+		}
+		for i := range want {
+			b, err := d.decodeDecision(60)
+			if err != nil {
+				t.Fatalf("did not expect error: %v for pass: %d, bin: %d", err, pass, i)
+			}
+			if b != want[i] {
+				t.Fatalf("did not get expected value for pass: %d, bin: %d\nGot: %d\nWant: %d", pass, i, b, want[i])
+			}
+		}
+		for i := range want {
+			b, err := d.decodeBypass()
+			if err != nil {
+				t.Fatalf("did not expect error: %v for pass: %d, bypass bin: %d", err, pass, i)
+			}
+			if b != want[i] {
+				t.Fatalf("did not get expected value for pass: %d, bypass bin: %d\nGot: %d\nWant: %d", pass, i, b, want[i])
+			}
+		}
+		b, err := d.decodeTerminate()
+		if err != nil || b != 1 {
+			t.Fatalf("did not get expected terminate bin for pass: %d\nGot: %d, %v\nWant: 1", pass, b, err)
+		}
+		if d.br.Off() != n {
+			t.Errorf("did not get expected bits read for pass: %d\nGot: %d\nWant: %d", pass, d.br.Off(), n)
+		}
+	}
+}
+
+func TestByteBits(t *testing.T) {
+	b := newByteBits([]byte{0xa5, 0x3c, 0xff, 0x01})
+	tests := []struct {
+		n    int
+		want uint64
+		off  int
+		err  error
+	}{
+		{n: 0, want: 0, off: 0},
+		{n: 1, want: 1, off: 1},
+		{n: 3, want: 0x2, off: 4},
+		{n: 8, want: 0x53, off: 12},
+		{n: 12, want: 0xcff, off: 24},
+		{n: 9, off: 24, err: io.ErrUnexpectedEOF},
+		{n: 8, want: 0x01, off: 32},
+		{n: 1, off: 32, err: io.EOF},
+		{n: 65, off: 32, err: bits.ErrBadBitCount},
+	}
+	for i, test := range tests {
+		got, err := b.ReadBits(test.n)
+		if err != test.err {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+		}
+		if got != test.want || b.Off() != test.off {
+			t.Errorf("did not get expected result for test: %d\nGot: %#x at %d\nWant: %#x at %d", i, got, b.Off(), test.want, test.off)
+		}
+	}
+
+	if err := b.Seek(4); err != nil {
+		t.Fatalf("did not expect error: %v from Seek", err)
+	}
+	if got, err := b.ReadBits(28); err != nil || got != 0x53cff01 {
+		t.Errorf("did not get expected result after Seek\nGot: %#x, %v\nWant: %#x", got, err, 0x53cff01)
+	}
+	if err := b.Seek(33); !errors.Is(err, errBadBitOffset) {
+		t.Errorf("did not get expected error\nGot: %v\nWant: %v", err, errBadBitOffset)
+	}
+}
+
+// BenchmarkCABACDecodeDecision measures the decoding of bins using context
+// variables, of which renormalization is a large part.
+func BenchmarkCABACDecodeDecision(b *testing.B) {
+	const n = 100000
+	rng := rand.New(rand.NewSource(1))
+	ctxIdxs := make([]int, n)
+	e := &cabacEncoder{codIRange: 510, firstBitFlag: true}
+	for i := range ctxIdxs {
+		ctxIdxs[i] = rng.Intn(ctxIdxEndOfSlice)
+		e.encodeDecision(ctxIdxs[i], flagVal(rng.Intn(4) == 0))
+	}
+	e.encodeTerminate(1)
+	buf := make([]byte, (len(e.bits)+7)/8)
+	for i, bit := range e.bits {
+		buf[i/8] |= byte(bit) << uint(7-i%8)
+	}
+
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := &cabacDecoder{br: bits.NewBitReader(bytes.NewReader(buf))}
+		if err := d.initEngine(); err != nil {
+			b.Fatalf("did not expect error: %v from initEngine", err)
+		}
+		for _, ctxIdx := range ctxIdxs {
+			if _, err := d.decodeDecision(ctxIdx); err != nil {
+				b.Fatalf("did not expect error: %v", err)
+			}
+		}
+	}
+}
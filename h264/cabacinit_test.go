@@ -0,0 +1,97 @@
+/*
+NAME
+  cabacinit_test.go
+
+DESCRIPTION
+  cabacinit_test.go provides testing for functionality provided in
+  cabacinit.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestContextMN(t *testing.T) {
+	tests := []struct {
+		ctxIdx       int
+		sliceType    string
+		cabacInitIdc int
+		want         MN
+		err          error
+	}{
+		{ctxIdx: 0, sliceType: "I", want: MN{20, -15}},
+		{ctxIdx: 3, sliceType: "SI", want: MN{20, -15}},
+		{ctxIdx: 70, sliceType: "I", cabacInitIdc: 2, want: MN{0, 11}},
+		{ctxIdx: 11, sliceType: "P", cabacInitIdc: 0, want: MN{23, 33}},
+		{ctxIdx: 30, sliceType: "B", cabacInitIdc: 0, want: MN{-46, 127}},
+		{ctxIdx: 30, sliceType: "B", cabacInitIdc: 1, want: MN{-45, 127}},
+		{ctxIdx: 30, sliceType: "B", cabacInitIdc: 2, want: MN{-32, 127}},
+		{ctxIdx: 104, sliceType: "SP", cabacInitIdc: 2, want: MN{-13, 98}},
+		{ctxIdx: 399, sliceType: "I", want: MN{31, 21}},
+		{ctxIdx: 459, sliceType: "P", cabacInitIdc: 1, want: MN{47, 57}},
+
+		// Cb and Cr context variables of 4:4:4 video.
+		{ctxIdx: 460, sliceType: "I", want: MN{-17, 123}},
+		{ctxIdx: 483, sliceType: "P", cabacInitIdc: 0, want: MN{-23, 126}},
+		{ctxIdx: 528, sliceType: "I", want: MN{-7, 93}},
+		{ctxIdx: 660, sliceType: "I", want: MN{-17, 120}},
+		{ctxIdx: 766, sliceType: "B", cabacInitIdc: 2, want: MN{-9, 71}},
+		{ctxIdx: 1011, sliceType: "I", want: MN{-17, 110}},
+		{ctxIdx: 1023, sliceType: "B", cabacInitIdc: 1, want: MN{-31, 127}},
+
+		{ctxIdx: -1, sliceType: "I", err: errBadCtxIdx},
+		{ctxIdx: 1024, sliceType: "I", err: errBadCtxIdx},
+		{ctxIdx: 0, sliceType: "P", cabacInitIdc: 3, err: errBadCabacInitIdc},
+		{ctxIdx: 0, sliceType: "X", err: errBadSliceType},
+	}
+
+	for i, test := range tests {
+		got, err := ContextMN(test.ctxIdx, test.sliceType, test.cabacInitIdc)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestInitContextVariables(t *testing.T) {
+	tests := []struct {
+		sliceType    string
+		cabacInitIdc int
+		sliceQPY     int
+		ctxIdx       int
+		want         ContextVariable
+	}{
+		{sliceType: "I", sliceQPY: 26, ctxIdx: 0, want: ContextVariable{PStateIdx: 46, ValMPS: 0}},
+		{sliceType: "I", sliceQPY: 26, ctxIdx: 60, want: ContextVariable{PStateIdx: 22, ValMPS: 0}},
+		{sliceType: "I", sliceQPY: 26, ctxIdx: 64, want: ContextVariable{PStateIdx: 4, ValMPS: 1}},
+		{sliceType: "I", sliceQPY: 0, ctxIdx: 70, want: ContextVariable{PStateIdx: 52, ValMPS: 0}},
+		{sliceType: "P", cabacInitIdc: 0, sliceQPY: 26, ctxIdx: 11, want: ContextVariable{PStateIdx: 6, ValMPS: 1}},
+		{sliceType: "B", cabacInitIdc: 1, sliceQPY: 51, ctxIdx: 6, want: ContextVariable{PStateIdx: 26, ValMPS: 0}},
+		{sliceType: "B", cabacInitIdc: 1, sliceQPY: 51, ctxIdx: ctxIdxEndOfSlice, want: ContextVariable{PStateIdx: 63, ValMPS: 0}},
+	}
+
+	for i, test := range tests {
+		vars, err := InitContextVariables(test.sliceType, test.cabacInitIdc, test.sliceQPY)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for test: %d", err, i)
+		}
+		if got := vars[test.ctxIdx]; got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, got, test.want)
+		}
+	}
+
+	_, err := InitContextVariables("P", 3, 26)
+	if !errors.Is(err, errBadCabacInitIdc) {
+		t.Errorf("did not get expected error\nGot: %v\nWant: %v", err, errBadCabacInitIdc)
+	}
+}
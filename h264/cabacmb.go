@@ -0,0 +1,136 @@
+/*
+NAME
+  cabacmb.go
+
+DESCRIPTION
+  cabacmb.go provides the decoding using CABAC of the syntax elements of the
+  macroblock layer other than mb_type, sub_mb_type, mvd_lX and those of
+  residual blocks, with the binarizations of section 9.3.2 and context index
+  assignment of section 9.3.3.1 of the specifications.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "fmt"
+
+// ctxIdxOffset of the syntax elements decoded here (table 9-34).
+const (
+	mbQpDeltaCtxIdxOffset            = 60
+	intraChromaPredModeCtxIdxOffset  = 64
+	prevIntraPredModeFlagCtxIdx      = 68 // Also prev_intra8x8_pred_mode_flag.
+	remIntraPredModeCtxIdx           = 69 // Also rem_intra8x8_pred_mode.
+	cbpLumaCtxIdxOffset              = 73
+	cbpChromaCtxIdxOffset            = 77
+	refIdxCtxIdxOffset               = 54
+	transformSize8x8FlagCtxIdxOffset = 399
+)
+
+// decodeIntraPredMode decodes prev_intra4x4_pred_mode_flag or
+// prev_intra8x8_pred_mode_flag and, if it is 0, rem_intra4x4_pred_mode or
+// rem_intra8x8_pred_mode, using the fixed-length binarization with cMax 7,
+// as specified by table 9-34. rem is 0 if the flag is 1.
+func (d *cabacDecoder) decodeIntraPredMode() (prevFlag bool, rem int, err error) {
+	b, err := d.decodeDecision(prevIntraPredModeFlagCtxIdx)
+	if err != nil {
+		return false, 0, fmt.Errorf("could not read prev_intra_pred_mode_flag: %w", err)
+	}
+	if b == 1 {
+		return true, 0, nil
+	}
+	rem, err = readFixedLength(func(int) (int, error) {
+		return d.decodeDecision(remIntraPredModeCtxIdx)
+	}, 7)
+	if err != nil {
+		return false, 0, fmt.Errorf("could not read rem_intra_pred_mode: %w", err)
+	}
+	return false, rem, nil
+}
+
+// decodeIntraChromaPredMode decodes intra_chroma_pred_mode of the macroblock
+// with address currMbAddr, using the truncated unary binarization with cMax
+// 3, the first bin having ctxIdxInc derived by intraChromaPredModeCtxIdxInc
+// and the others 3.
+func (d *cabacDecoder) decodeIntraChromaPredMode(mbs *mbState, currMbAddr int) (int, error) {
+	return readTruncatedUnary(func(binIdx int) (int, error) {
+		inc := 3
+		if binIdx == 0 {
+			inc = intraChromaPredModeCtxIdxInc(mbs, currMbAddr)
+		}
+		return d.decodeDecision(intraChromaPredModeCtxIdxOffset + inc)
+	}, 3)
+}
+
+// decodeTransformSize8x8Flag decodes transform_size_8x8_flag of the
+// macroblock with address currMbAddr.
+func (d *cabacDecoder) decodeTransformSize8x8Flag(mbs *mbState, currMbAddr int) (bool, error) {
+	b, err := d.decodeDecision(transformSize8x8FlagCtxIdxOffset + transformSize8x8FlagCtxIdxInc(mbs, currMbAddr))
+	return b == 1, err
+}
+
+// decodeCodedBlockPattern decodes coded_block_pattern of the macroblock with
+// address currMbAddr, for the given ChromaArrayType, each bin of the prefix
+// having ctxIdxInc derived by cbpLumaCtxIdxInc and each of the suffix by
+// cbpChromaCtxIdxInc.
+func (d *cabacDecoder) decodeCodedBlockPattern(mbs *mbState, currMbAddr, chromaArrayType int) (int, error) {
+	var bins [4]int
+	prefix := func(binIdx int) (int, error) {
+		b, err := d.decodeDecision(cbpLumaCtxIdxOffset + cbpLumaCtxIdxInc(mbs, currMbAddr, binIdx, bins[:]))
+		bins[binIdx] = b
+		return b, err
+	}
+	suffix := func(binIdx int) (int, error) {
+		return d.decodeDecision(cbpChromaCtxIdxOffset + cbpChromaCtxIdxInc(mbs, currMbAddr, binIdx))
+	}
+	return readCodedBlockPattern(prefix, suffix, chromaArrayType)
+}
+
+// decodeMbQpDelta decodes mb_qp_delta of the macroblock with address
+// currMbAddr, prevMbAddr being that of the macroblock preceding it in
+// decoding order in the slice, or -1 if it is the first, the first bin
+// having ctxIdxInc derived by mbQpDeltaCtxIdxInc, the second 2 and the
+// others 3.
+func (d *cabacDecoder) decodeMbQpDelta(mbs *mbState, prevMbAddr, currMbAddr int) (int, error) {
+	return readMbQpDelta(func(binIdx int) (int, error) {
+		var inc int
+		switch binIdx {
+		case 0:
+			inc = mbQpDeltaCtxIdxInc(mbs, prevMbAddr, currMbAddr)
+		case 1:
+			inc = 2
+		default:
+			inc = 3
+		}
+		return d.decodeDecision(mbQpDeltaCtxIdxOffset + inc)
+	})
+}
+
+// decodeRefIdx decodes ref_idx_lX, for list 0 or 1, of the partition of the
+// macroblock with address currMbAddr whose top left luma sample is at (x, y)
+// relative to the macroblock, in a slice of the given type, using the unary
+// binarization, the first bin having ctxIdxInc derived by refIdxCtxIdxInc,
+// the second 4 and the others 5. Decoding stops with an error once the value
+// exceeds maxRefIdx, being the greatest permitted.
+func (d *cabacDecoder) decodeRefIdx(mbs *mbState, currMbAddr int, sliceType string, list, x, y, maxRefIdx int) (int, error) {
+	v, err := readTruncatedUnary(func(binIdx int) (int, error) {
+		var inc int
+		switch binIdx {
+		case 0:
+			inc = refIdxCtxIdxInc(mbs, currMbAddr, sliceType, list, x, y)
+		case 1:
+			inc = 4
+		default:
+			inc = 5
+		}
+		return d.decodeDecision(refIdxCtxIdxOffset + inc)
+	}, maxRefIdx+1)
+	if err != nil {
+		return 0, err
+	}
+	if v > maxRefIdx {
+		return 0, fmt.Errorf("%w: %d greater than %d", errBadRefIdx, v, maxRefIdx)
+	}
+	return v, nil
+}
@@ -0,0 +1,97 @@
+/*
+NAME
+  cabacmb_test.go
+
+DESCRIPTION
+  cabacmb_test.go provides testing for functionality provided in cabacmb.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestDecodeMbElements checks the decoding of a sequence of macroblock layer
+// syntax elements of a macroblock without available neighbours, so that the
+// ctxIdxInc of each first bin is 0.
+func TestDecodeMbElements(t *testing.T) {
+	e := newCABACEncoder(t, "P", 1, 28)
+
+	// prev_intra4x4_pred_mode_flag 0 with rem_intra4x4_pred_mode 6, least
+	// significant bin first, then prev_intra4x4_pred_mode_flag 1.
+	e.encodeDecision(prevIntraPredModeFlagCtxIdx, 0)
+	for _, b := range []int{0, 1, 1} {
+		e.encodeDecision(remIntraPredModeCtxIdx, b)
+	}
+	e.encodeDecision(prevIntraPredModeFlagCtxIdx, 1)
+
+	// intra_chroma_pred_mode 2.
+	for i, b := range []int{1, 1, 0} {
+		e.encodeDecision(intraChromaPredModeCtxIdxOffset+[]int{0, 3, 3}[i], b)
+	}
+
+	// transform_size_8x8_flag 1.
+	e.encodeDecision(transformSize8x8FlagCtxIdxOffset, 1)
+
+	// mb_qp_delta -2, mapped to 4.
+	for i, b := range []int{1, 1, 1, 1, 0} {
+		e.encodeDecision(mbQpDeltaCtxIdxOffset+[]int{0, 2, 3, 3, 3}[i], b)
+	}
+
+	// ref_idx_l0 2 and 3, where the greatest permitted is 3.
+	for i, b := range []int{1, 1, 0} {
+		e.encodeDecision(refIdxCtxIdxOffset+[]int{0, 4, 5}[i], b)
+	}
+	for i, b := range []int{1, 1, 1, 0} {
+		e.encodeDecision(refIdxCtxIdxOffset+[]int{0, 4, 5, 5}[i], b)
+	}
+
+	// ref_idx_l0 2 where the greatest permitted is 1, without the
+	// terminating 0 bin.
+	for i, b := range []int{1, 1} {
+		e.encodeDecision(refIdxCtxIdxOffset+[]int{0, 4}[i], b)
+	}
+	e.encodeTerminate(1)
+
+	d, err := newCABACDecoder(e.reader(), "P", 1, 28)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from newCABACDecoder", err)
+	}
+	mbs := ctxIncState(noFlags)
+
+	prevFlag, rem, err := d.decodeIntraPredMode()
+	if err != nil || prevFlag || rem != 6 {
+		t.Errorf("did not get expected intra pred mode\nGot: %v, %d, %v\nWant: false, 6, <nil>", prevFlag, rem, err)
+	}
+	prevFlag, rem, err = d.decodeIntraPredMode()
+	if err != nil || !prevFlag || rem != 0 {
+		t.Errorf("did not get expected intra pred mode\nGot: %v, %d, %v\nWant: true, 0, <nil>", prevFlag, rem, err)
+	}
+	v, err := d.decodeIntraChromaPredMode(mbs, ctxIncMbCurr)
+	if err != nil || v != 2 {
+		t.Errorf("did not get expected intra_chroma_pred_mode\nGot: %d, %v\nWant: 2, <nil>", v, err)
+	}
+	flag, err := d.decodeTransformSize8x8Flag(mbs, ctxIncMbCurr)
+	if err != nil || !flag {
+		t.Errorf("did not get expected transform_size_8x8_flag\nGot: %v, %v\nWant: true, <nil>", flag, err)
+	}
+	v, err = d.decodeMbQpDelta(mbs, -1, ctxIncMbCurr)
+	if err != nil || v != -2 {
+		t.Errorf("did not get expected mb_qp_delta\nGot: %d, %v\nWant: -2, <nil>", v, err)
+	}
+	for _, want := range []int{2, 3} {
+		v, err = d.decodeRefIdx(mbs, ctxIncMbCurr, "P", 0, 0, 0, 3)
+		if err != nil || v != want {
+			t.Errorf("did not get expected ref_idx_l0\nGot: %d, %v\nWant: %d, <nil>", v, err, want)
+		}
+	}
+	_, err = d.decodeRefIdx(mbs, ctxIncMbCurr, "P", 0, 0, 0, 1)
+	if !errors.Is(err, errBadRefIdx) {
+		t.Errorf("did not get expected error\nGot: %v\nWant: %v", err, errBadRefIdx)
+	}
+}
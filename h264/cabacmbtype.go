@@ -0,0 +1,350 @@
+/*
+NAME
+  cabacmbtype.go
+
+DESCRIPTION
+  cabacmbtype.go provides the binarization of mb_type and sub_mb_type, as
+  given by tables 9-36 to 9-38 of the specifications, and their decoding
+  using CABAC with the context index assignment of section 9.3.3.1.2.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Bin strings of mb_type in I slices, and of the suffix of mb_type in SI, P,
+// SP and B slices, indexed by the mb_type of table 7-11 (table 9-36).
+var iMbTypeBins = [][]int{
+	0:  {0},
+	1:  {1, 0, 0, 0, 0, 0},
+	2:  {1, 0, 0, 0, 0, 1},
+	3:  {1, 0, 0, 0, 1, 0},
+	4:  {1, 0, 0, 0, 1, 1},
+	5:  {1, 0, 0, 1, 0, 0, 0},
+	6:  {1, 0, 0, 1, 0, 0, 1},
+	7:  {1, 0, 0, 1, 0, 1, 0},
+	8:  {1, 0, 0, 1, 0, 1, 1},
+	9:  {1, 0, 0, 1, 1, 0, 0},
+	10: {1, 0, 0, 1, 1, 0, 1},
+	11: {1, 0, 0, 1, 1, 1, 0},
+	12: {1, 0, 0, 1, 1, 1, 1},
+	13: {1, 0, 1, 0, 0, 0},
+	14: {1, 0, 1, 0, 0, 1},
+	15: {1, 0, 1, 0, 1, 0},
+	16: {1, 0, 1, 0, 1, 1},
+	17: {1, 0, 1, 1, 0, 0, 0},
+	18: {1, 0, 1, 1, 0, 0, 1},
+	19: {1, 0, 1, 1, 0, 1, 0},
+	20: {1, 0, 1, 1, 0, 1, 1},
+	21: {1, 0, 1, 1, 1, 0, 0},
+	22: {1, 0, 1, 1, 1, 0, 1},
+	23: {1, 0, 1, 1, 1, 1, 0},
+	24: {1, 0, 1, 1, 1, 1, 1},
+	25: {1, 1},
+}
+
+// Bin strings of the prefix of mb_type in P and SP slices, indexed by the
+// mb_type of table 7-13, followed by the prefix of intra macroblock types
+// (table 9-37). P_8x8ref0 is not permitted with CABAC so has no bin string.
+var pMbTypeBins = [][]int{
+	0: {0, 0, 0},
+	1: {0, 1, 1},
+	2: {0, 1, 0},
+	3: {0, 0, 1},
+	4: nil,
+	5: {1},
+}
+
+// Bin strings of the prefix of mb_type in B slices, indexed by the mb_type of
+// table 7-14, followed by the prefix of intra macroblock types (table 9-37).
+var bMbTypeBins = [][]int{
+	0:  {0},
+	1:  {1, 0, 0},
+	2:  {1, 0, 1},
+	3:  {1, 1, 0, 0, 0, 0},
+	4:  {1, 1, 0, 0, 0, 1},
+	5:  {1, 1, 0, 0, 1, 0},
+	6:  {1, 1, 0, 0, 1, 1},
+	7:  {1, 1, 0, 1, 0, 0},
+	8:  {1, 1, 0, 1, 0, 1},
+	9:  {1, 1, 0, 1, 1, 0},
+	10: {1, 1, 0, 1, 1, 1},
+	11: {1, 1, 1, 1, 1, 0},
+	12: {1, 1, 1, 0, 0, 0, 0},
+	13: {1, 1, 1, 0, 0, 0, 1},
+	14: {1, 1, 1, 0, 0, 1, 0},
+	15: {1, 1, 1, 0, 0, 1, 1},
+	16: {1, 1, 1, 0, 1, 0, 0},
+	17: {1, 1, 1, 0, 1, 0, 1},
+	18: {1, 1, 1, 0, 1, 1, 0},
+	19: {1, 1, 1, 0, 1, 1, 1},
+	20: {1, 1, 1, 1, 0, 0, 0},
+	21: {1, 1, 1, 1, 0, 0, 1},
+	22: {1, 1, 1, 1, 1, 1},
+	23: {1, 1, 1, 1, 0, 1},
+}
+
+// Bin strings of sub_mb_type in P and SP slices, indexed by the sub_mb_type
+// of table 7-17, and in B slices, indexed by that of table 7-18 (table 9-38).
+var (
+	pSubMbTypeBins = [][]int{
+		0: {1},
+		1: {0, 0},
+		2: {0, 1, 1},
+		3: {0, 1, 0},
+	}
+	bSubMbTypeBins = [][]int{
+		0:  {0},
+		1:  {1, 0, 0},
+		2:  {1, 0, 1},
+		3:  {1, 1, 0, 0, 0},
+		4:  {1, 1, 0, 0, 1},
+		5:  {1, 1, 0, 1, 0},
+		6:  {1, 1, 0, 1, 1},
+		7:  {1, 1, 1, 0, 0, 0},
+		8:  {1, 1, 1, 0, 0, 1},
+		9:  {1, 1, 1, 0, 1, 0},
+		10: {1, 1, 1, 0, 1, 1},
+		11: {1, 1, 1, 1, 0},
+		12: {1, 1, 1, 1, 1},
+	}
+)
+
+// Numbers of inter macroblock types of P and B slices, by which the intra
+// macroblock types of table 7-11 are offset in those slices.
+const (
+	numPInterMbTypes = 5
+	numBInterMbTypes = 23
+)
+
+// mbTypeBinString returns the bin string of mb_type in a slice of the given
+// type, including the suffix of intra macroblock types in SI, P, SP and B
+// slices (9.3.2.5).
+func mbTypeBinString(sliceType string, mbType int) ([]int, error) {
+	var prefix [][]int
+	var numInter int
+	switch sliceType {
+	case "I":
+	case "SI":
+		prefix, numInter = [][]int{{0}, {1}}, 1
+	case "P", "SP":
+		prefix, numInter = pMbTypeBins, numPInterMbTypes
+	case "B":
+		prefix, numInter = bMbTypeBins, numBInterMbTypes
+	default:
+		return nil, fmt.Errorf("%w: %s", errBadSliceType, sliceType)
+	}
+	if mbType < 0 || mbType >= numInter+len(iMbTypeBins) {
+		return nil, fmt.Errorf("%w: %d in %s slice", errBadMbType, mbType, sliceType)
+	}
+	if mbType < numInter {
+		if prefix[mbType] == nil {
+			return nil, fmt.Errorf("%w: %d in %s slice", errBadMbType, mbType, sliceType)
+		}
+		return prefix[mbType], nil
+	}
+	var bins []int
+	if prefix != nil {
+		bins = append(bins, prefix[numInter]...)
+	}
+	return append(bins, iMbTypeBins[mbType-numInter]...), nil
+}
+
+// readBinString decodes a value using a binarization given by a table of the
+// bin strings of each value, which must be prefix free, reading bins until
+// they form one of the bin strings. Values with no bin string are not
+// permitted.
+func readBinString(r binReader, table [][]int) (int, error) {
+	var bins []int
+	for {
+		b, err := r(len(bins))
+		if err != nil {
+			return 0, fmt.Errorf("could not read bin %d: %w", len(bins), err)
+		}
+		bins = append(bins, b)
+
+		var prefixOf bool
+		for v, s := range table {
+			if len(s) < len(bins) || !isPrefix(bins, s) {
+				continue
+			}
+			if len(s) == len(bins) {
+				return v, nil
+			}
+			prefixOf = true
+		}
+		if !prefixOf {
+			return 0, fmt.Errorf("%w: %v", errBadBinString, bins)
+		}
+	}
+}
+
+// isPrefix returns true if bins is a prefix of s.
+func isPrefix(bins, s []int) bool {
+	for i, b := range bins {
+		if s[i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+// mbTypeBinReader returns a binReader decoding the bins of a bin string of
+// mb_type, or of sub_mb_type, using d, selecting the context of each bin by
+// ctxIdx, given the bin index and the bins already decoded.
+func (d *cabacDecoder) mbTypeBinReader(ctxIdx func(binIdx int, bins []int) int) binReader {
+	var bins []int
+	return func(binIdx int) (int, error) {
+		b, err := d.decodeBin(ctxIdx(binIdx, bins))
+		bins = append(bins, b)
+		return b, err
+	}
+}
+
+// decodeMbType decodes mb_type in a slice of the given type, returning its
+// value as in table 7-11, 7-12, 7-13 or 7-14 for the slice type, with intra
+// macroblock types of SI, P, SP and B slices following the others. The
+// ctxIdxInc of the first bin of the prefix, and of the first bin of the
+// suffix of SI slices, is given by ctxIdxInc with the ctxIdxOffset of the
+// bin, as derived from neighbouring macroblocks by 9.3.3.1.1.3.
+func (d *cabacDecoder) decodeMbType(sliceType string, ctxIdxInc func(ctxIdxOffset int) int) (int, error) {
+	var numInter int
+	var suffixOffset int // ctxIdxOffset of the suffix, or 3 for I slice bins.
+	switch sliceType {
+	case "I":
+		suffixOffset = 3
+	case "SI":
+		b, err := d.decodeDecision(ctxIdxInc(0))
+		if err != nil {
+			return 0, fmt.Errorf("could not read prefix: %w", err)
+		}
+		if b == 0 {
+			return 0, nil
+		}
+		numInter, suffixOffset = 1, 3
+	case "P", "SP":
+		v, err := readBinString(d.mbTypeBinReader(pMbTypePrefixCtxIdx), pMbTypeBins)
+		if err != nil || v < numPInterMbTypes {
+			return v, err
+		}
+		numInter, suffixOffset = numPInterMbTypes, 17
+	case "B":
+		inc := ctxIdxInc(27)
+		v, err := readBinString(d.mbTypeBinReader(func(binIdx int, bins []int) int {
+			return bMbTypePrefixCtxIdx(binIdx, bins, inc)
+		}), bMbTypeBins)
+		if err != nil || v < numBInterMbTypes {
+			return v, err
+		}
+		numInter, suffixOffset = numBInterMbTypes, 32
+	default:
+		return 0, fmt.Errorf("%w: %s", errBadSliceType, sliceType)
+	}
+
+	var inc int
+	if suffixOffset == 3 {
+		inc = ctxIdxInc(3)
+	}
+	v, err := readBinString(d.mbTypeBinReader(func(binIdx int, bins []int) int {
+		return iMbTypeCtxIdx(binIdx, bins, suffixOffset, inc)
+	}), iMbTypeBins)
+	if err != nil {
+		return 0, fmt.Errorf("could not read intra macroblock type: %w", err)
+	}
+	return numInter + v, nil
+}
+
+// decodeSubMbType decodes sub_mb_type in a slice of the given type, which
+// must be P, SP or B, returning its value as in table 7-17 or 7-18.
+func (d *cabacDecoder) decodeSubMbType(sliceType string) (int, error) {
+	switch sliceType {
+	case "P", "SP":
+		return readBinString(d.mbTypeBinReader(func(binIdx int, _ []int) int {
+			return 21 + binIdx
+		}), pSubMbTypeBins)
+	case "B":
+		return readBinString(d.mbTypeBinReader(bSubMbTypeCtxIdx), bSubMbTypeBins)
+	}
+	return 0, fmt.Errorf("%w: %s", errBadSliceType, sliceType)
+}
+
+// iMbTypeCtxIdx returns the ctxIdx of bin binIdx of the bin string of an I
+// slice mb_type, with ctxIdxOffset 3 and the given ctxIdxInc of its first
+// bin, or of the suffix of a P, SP or B slice mb_type, with ctxIdxOffset 17
+// or 32 respectively (table 9-39, 9.3.3.1.2). Bin 1 is decoded using
+// decodeTerminate.
+func iMbTypeCtxIdx(binIdx int, bins []int, ctxIdxOffset, inc int) int {
+	if binIdx == 1 {
+		return ctxIdxEndOfSlice
+	}
+	if ctxIdxOffset == 3 {
+		switch binIdx {
+		case 0:
+			return 3 + inc
+		case 2, 3:
+			return 3 + binIdx + 1
+		case 4:
+			return 3 + 6 - flagVal(bins[3] != 0)
+		case 5:
+			return 3 + 7 - flagVal(bins[3] != 0)
+		}
+		return 3 + 7
+	}
+	switch binIdx {
+	case 0:
+		return ctxIdxOffset
+	case 2, 3:
+		return ctxIdxOffset + binIdx - 1
+	case 4:
+		return ctxIdxOffset + 3 - flagVal(bins[3] != 0)
+	}
+	return ctxIdxOffset + 3
+}
+
+// pMbTypePrefixCtxIdx returns the ctxIdx of bin binIdx of the prefix of a P
+// or SP slice mb_type, with ctxIdxOffset 14 (table 9-39).
+func pMbTypePrefixCtxIdx(binIdx int, bins []int) int {
+	if binIdx < 2 {
+		return 14 + binIdx
+	}
+	return 14 + 3 - flagVal(bins[1] != 1)
+}
+
+// bMbTypePrefixCtxIdx returns the ctxIdx of bin binIdx of the prefix of a B
+// slice mb_type, with ctxIdxOffset 27 and the given ctxIdxInc of its first
+// bin (table 9-39).
+func bMbTypePrefixCtxIdx(binIdx int, bins []int, inc int) int {
+	switch binIdx {
+	case 0:
+		return 27 + inc
+	case 1:
+		return 27 + 3
+	case 2:
+		return 27 + 4 + flagVal(bins[1] != 0)
+	}
+	return 27 + 5
+}
+
+// bSubMbTypeCtxIdx returns the ctxIdx of bin binIdx of a B slice
+// sub_mb_type, with ctxIdxOffset 36 (table 9-39).
+func bSubMbTypeCtxIdx(binIdx int, bins []int) int {
+	switch binIdx {
+	case 0, 1:
+		return 36 + binIdx
+	case 2:
+		return 36 + 3 - flagVal(bins[1] != 0)
+	}
+	return 36 + 3
+}
+
+// Errors used in the decoding of mb_type and sub_mb_type.
+var (
+	errBadMbType    = errors.New("invalid mb_type")
+	errBadBinString = errors.New("bins do not form a bin string of the binarization")
+)
@@ -0,0 +1,183 @@
+/*
+NAME
+  cabacmbtype_test.go
+
+DESCRIPTION
+  cabacmbtype_test.go provides testing for functionality provided in
+  cabacmbtype.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMbTypeBinString(t *testing.T) {
+	tests := []struct {
+		sliceType string
+		mbType    int
+		want      []int
+		err       error
+	}{
+		{sliceType: "I", mbType: 0, want: []int{0}},
+		{sliceType: "I", mbType: 25, want: []int{1, 1}},
+		{sliceType: "I", mbType: 12, want: []int{1, 0, 0, 1, 1, 1, 1}},
+		{sliceType: "SI", mbType: 0, want: []int{0}},
+		{sliceType: "SI", mbType: 1, want: []int{1, 0}},
+		{sliceType: "P", mbType: 2, want: []int{0, 1, 0}},
+		{sliceType: "P", mbType: 4, err: errBadMbType},
+		{sliceType: "P", mbType: 5, want: []int{1, 0}},
+		{sliceType: "SP", mbType: 30, want: []int{1, 1, 1}},
+		{sliceType: "B", mbType: 22, want: []int{1, 1, 1, 1, 1, 1}},
+		{sliceType: "B", mbType: 23, want: []int{1, 1, 1, 1, 0, 1, 0}},
+		{sliceType: "B", mbType: 49, err: errBadMbType},
+		{sliceType: "I", mbType: -1, err: errBadMbType},
+		{sliceType: "X", mbType: 0, err: errBadSliceType},
+	}
+
+	for i, test := range tests {
+		got, err := mbTypeBinString(test.sliceType, test.mbType)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// mbTypeTestCtxIdx gives the ctxIdx of bin binIdx of the bins of mb_type or
+// sub_mb_type with the given ctxIdxOffset, written out from table 9-39 for
+// encoding, inc being the ctxIdxInc of bin 0 where derived from neighbours.
+func mbTypeTestCtxIdx(ctxIdxOffset, binIdx int, bins []int, inc int) int {
+	b := func(i int) bool { return i < len(bins) && bins[i] != 0 }
+	pick := func(c bool, x, y int) int {
+		if c {
+			return x
+		}
+		return y
+	}
+	// at gives the ctxIdxInc of the bin from those of each binIdx, the last
+	// applying to any greater binIdx.
+	at := func(incs ...int) int {
+		if binIdx >= len(incs) {
+			return incs[len(incs)-1]
+		}
+		return incs[binIdx]
+	}
+	var ctxIdxInc int
+	switch ctxIdxOffset {
+	case 0, 3:
+		ctxIdxInc = at(inc, 276-3, 3, 4, pick(b(3), 5, 6), pick(b(3), 6, 7), 7)
+	case 14:
+		ctxIdxInc = at(0, 1, pick(!b(1), 2, 3))
+	case 17, 32:
+		ctxIdxInc = at(0, 276-ctxIdxOffset, 1, 2, pick(b(3), 2, 3), 3)
+	case 21:
+		ctxIdxInc = binIdx
+	case 27:
+		ctxIdxInc = at(inc, 3, pick(b(1), 5, 4), 5)
+	case 36:
+		ctxIdxInc = at(0, 1, pick(b(1), 2, 3), 3)
+	}
+	return ctxIdxOffset + ctxIdxInc
+}
+
+func TestDecodeMbType(t *testing.T) {
+	tests := []struct {
+		sliceType    string
+		n            int // Number of macroblock types.
+		prefixOffset int
+		suffixOffset int
+		numInter     int
+	}{
+		{sliceType: "I", n: 26, prefixOffset: 3},
+		{sliceType: "SI", n: 27, prefixOffset: 0, suffixOffset: 3, numInter: 1},
+		{sliceType: "P", n: 31, prefixOffset: 14, suffixOffset: 17, numInter: 5},
+		{sliceType: "B", n: 49, prefixOffset: 27, suffixOffset: 32, numInter: 23},
+	}
+
+	const inc = 1
+	for i, test := range tests {
+		for mbType := 0; mbType < test.n; mbType++ {
+			bins, err := mbTypeBinString(test.sliceType, mbType)
+			if err != nil {
+				continue // P mb_type 4, P_8x8ref0, is not permitted.
+			}
+			prefixLen := len(bins)
+			if mbType >= test.numInter && test.numInter > 0 {
+				prefixLen = len(bins) - len(iMbTypeBins[mbType-test.numInter])
+			}
+
+			e := newCABACEncoder(t, test.sliceType, 2, 28)
+			for binIdx, bin := range bins {
+				ctxIdx := mbTypeTestCtxIdx(test.prefixOffset, binIdx, bins, inc)
+				if binIdx >= prefixLen {
+					suffixInc := 0
+					if test.suffixOffset == 3 {
+						suffixInc = inc
+					}
+					ctxIdx = mbTypeTestCtxIdx(test.suffixOffset, binIdx-prefixLen, bins[prefixLen:], suffixInc)
+				}
+				e.encodeBin(ctxIdx, bin)
+			}
+			if !reflect.DeepEqual(bins[prefixLen:], []int{1, 1}) { // Not I_PCM, whose bins terminate.
+				e.encodeTerminate(1)
+			}
+
+			d, err := newCABACDecoder(e.reader(), test.sliceType, 2, 28)
+			if err != nil {
+				t.Fatalf("did not expect error: %v from newCABACDecoder for test: %d", err, i)
+			}
+			got, err := d.decodeMbType(test.sliceType, func(int) int { return inc })
+			if err != nil {
+				t.Errorf("did not expect error: %v for test: %d, mb_type: %d", err, i, mbType)
+				continue
+			}
+			if got != mbType {
+				t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, mbType)
+			}
+		}
+	}
+}
+
+func TestDecodeSubMbType(t *testing.T) {
+	tests := []struct {
+		sliceType string
+		table     [][]int
+		offset    int
+	}{
+		{sliceType: "P", table: pSubMbTypeBins, offset: 21},
+		{sliceType: "B", table: bSubMbTypeBins, offset: 36},
+	}
+
+	for i, test := range tests {
+		for subMbType, bins := range test.table {
+			e := newCABACEncoder(t, test.sliceType, 0, 33)
+			for binIdx, bin := range bins {
+				e.encodeBin(mbTypeTestCtxIdx(test.offset, binIdx, bins, 0), bin)
+			}
+			e.encodeTerminate(1)
+
+			d, err := newCABACDecoder(e.reader(), test.sliceType, 0, 33)
+			if err != nil {
+				t.Fatalf("did not expect error: %v from newCABACDecoder for test: %d", err, i)
+			}
+			got, err := d.decodeSubMbType(test.sliceType)
+			if err != nil {
+				t.Errorf("did not expect error: %v for test: %d, sub_mb_type: %d", err, i, subMbType)
+				continue
+			}
+			if got != subMbType {
+				t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, subMbType)
+			}
+		}
+	}
+}
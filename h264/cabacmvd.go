@@ -0,0 +1,85 @@
+/*
+NAME
+  cabacmvd.go
+
+DESCRIPTION
+  cabacmvd.go provides the decoding of the motion vector differences mvd_l0
+  and mvd_l1 under CABAC, using the UEG3 binarization of section 9.3.2.3 of
+  the specifications with the context indices of section 9.3.3.1, and their
+  storage for motion vector prediction.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Parameters of the UEG3 binarization of mvd_lX (table 9-34).
+const (
+	mvdUCoff = 9
+	mvdK     = 3
+)
+
+// mvdCtxIdxOffset gives the ctxIdxOffset of the horizontal and vertical
+// components of mvd_lX (table 9-34).
+var mvdCtxIdxOffset = [2]int{40, 47}
+
+// mvdCtxIdx returns the ctxIdx of bin binIdx of the prefix of component
+// compIdx of mvd_lX, ctxIdxInc being that of its first bin, as derived from
+// neighbouring partitions by mvdCtxIdxInc (table 9-39).
+func mvdCtxIdx(compIdx, binIdx, ctxIdxInc int) int {
+	switch {
+	case binIdx == 0:
+	case binIdx < 4:
+		ctxIdxInc = binIdx + 2
+	default:
+		ctxIdxInc = 6
+	}
+	return mvdCtxIdxOffset[compIdx] + ctxIdxInc
+}
+
+// decodeMvdComp decodes component compIdx, 0 for horizontal and 1 for
+// vertical, of mvd_lX, with ctxIdxInc of its first bin as given, using the
+// UEG3 binarization with signedValFlag 1 and uCoff 9, the suffix and sign
+// bins being decoded in bypass mode.
+func (d *cabacDecoder) decodeMvdComp(compIdx, ctxIdxInc int) (int, error) {
+	prefix := func(binIdx int) (int, error) {
+		return d.decodeDecision(mvdCtxIdx(compIdx, binIdx, ctxIdxInc))
+	}
+	suffix := func(int) (int, error) { return d.decodeBypass() }
+	v, err := readUEGk(prefix, suffix, true, mvdUCoff, mvdK)
+	if err != nil {
+		return 0, err
+	}
+	if v < math.MinInt16 || v > math.MaxInt16 {
+		return 0, fmt.Errorf("%w: %d", errBadMvd, v)
+	}
+	return v, nil
+}
+
+// decodeMvd decodes mvd_lX, for list 0 or 1, of the partition or
+// sub-macroblock partition of the macroblock with address currMbAddr whose
+// top left luma sample is at (x, y) relative to the macroblock, with the
+// given width and height, storing it in mbs for each 4x4 block of the
+// partition for the derivation of the ctxIdxInc of later mvd_lX.
+func (d *cabacDecoder) decodeMvd(mbs *mbState, currMbAddr, list, x, y, w, h int) (motionVector, error) {
+	var comp [2]int
+	for compIdx := range comp {
+		var err error
+		comp[compIdx], err = d.decodeMvdComp(compIdx, mvdCtxIdxInc(mbs, currMbAddr, list, compIdx, x, y))
+		if err != nil {
+			return motionVector{}, fmt.Errorf("could not read mvd_l%d[%d]: %w", list, compIdx, err)
+		}
+	}
+	mvd := motionVector{X: int16(comp[0]), Y: int16(comp[1])}
+	mbs.setMvd(currMbAddr, list, x, y, w, h, mvd)
+	return mvd, nil
+}
+
+var errBadMvd = errors.New("mvd_lX outside permitted range")
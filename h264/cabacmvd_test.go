@@ -0,0 +1,107 @@
+/*
+NAME
+  cabacmvd_test.go
+
+DESCRIPTION
+  cabacmvd_test.go provides testing for functionality provided in
+  cabacmvd.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMvdCtxIdx(t *testing.T) {
+	tests := []struct {
+		compIdx, binIdx, ctxIdxInc int
+		want                       int
+	}{
+		{compIdx: 0, binIdx: 0, ctxIdxInc: 2, want: 42},
+		{compIdx: 0, binIdx: 1, ctxIdxInc: 2, want: 43},
+		{compIdx: 1, binIdx: 3, ctxIdxInc: 0, want: 52},
+		{compIdx: 1, binIdx: 4, ctxIdxInc: 1, want: 53},
+		{compIdx: 1, binIdx: 8, ctxIdxInc: 1, want: 53},
+	}
+
+	for i, test := range tests {
+		if got := mvdCtxIdx(test.compIdx, test.binIdx, test.ctxIdxInc); got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestDecodeMvd checks decoding of the mvd_l1 of a sequence of partitions of
+// a macroblock, whose ctxIdxInc depends on those decoded before, and their
+// storage for each 4x4 block of the partitions.
+func TestDecodeMvd(t *testing.T) {
+	parts := []struct {
+		x, y, w, h int
+		mvd        motionVector
+	}{
+		{x: 0, y: 0, w: 8, h: 8, mvd: motionVector{X: -3, Y: 40}},
+		{x: 8, y: 0, w: 8, h: 4, mvd: motionVector{X: 0, Y: -1}},
+		{x: 8, y: 4, w: 4, h: 4, mvd: motionVector{X: 1000, Y: 8}},
+		{x: 12, y: 4, w: 4, h: 4, mvd: motionVector{X: -32768, Y: 32767}},
+		{x: 0, y: 8, w: 16, h: 8, mvd: motionVector{X: 9, Y: -9}},
+	}
+
+	// Encode, recording mvd in an mbState for the ctxIdxInc of later
+	// partitions as the decoder does.
+	enc := ctxIncState(noFlags, ctxIncMbA, ctxIncMbB)
+	enc.mvd[1][ctxIncMbA*blocksPerMb+5] = motionVector{X: 30, Y: 1}
+	e := newCABACEncoder(t, "B", 0, 30)
+	for _, p := range parts {
+		for compIdx, v := range []int16{p.mvd.X, p.mvd.Y} {
+			inc := mvdCtxIdxInc(enc, ctxIncMbCurr, 1, compIdx, p.x, p.y)
+			e.encodeUEGk(int(v), true, mvdUCoff, mvdK, func(binIdx int) int {
+				return mvdCtxIdx(compIdx, binIdx, inc)
+			})
+		}
+		enc.setMvd(ctxIncMbCurr, 1, p.x, p.y, p.w, p.h, p.mvd)
+	}
+	e.encodeTerminate(1)
+
+	dec := ctxIncState(noFlags, ctxIncMbA, ctxIncMbB)
+	dec.mvd[1][ctxIncMbA*blocksPerMb+5] = motionVector{X: 30, Y: 1}
+	d, err := newCABACDecoder(e.reader(), "B", 0, 30)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from newCABACDecoder", err)
+	}
+	for i, p := range parts {
+		got, err := d.decodeMvd(dec, ctxIncMbCurr, 1, p.x, p.y, p.w, p.h)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for partition: %d", err, i)
+		}
+		if got != p.mvd {
+			t.Errorf("did not get expected result for partition: %d\nGot: %v\nWant: %v", i, got, p.mvd)
+		}
+	}
+	for blk := 0; blk < blocksPerMb; blk++ {
+		i := ctxIncMbCurr*blocksPerMb + blk
+		if dec.mvd[1][i] != enc.mvd[1][i] {
+			t.Errorf("did not get expected mvd for block: %d\nGot: %v\nWant: %v", blk, dec.mvd[1][i], enc.mvd[1][i])
+		}
+	}
+}
+
+func TestDecodeMvdRange(t *testing.T) {
+	e := newCABACEncoder(t, "P", 0, 30)
+	e.encodeUEGk(32768, true, mvdUCoff, mvdK, func(binIdx int) int {
+		return mvdCtxIdx(0, binIdx, 0)
+	})
+	e.encodeTerminate(1)
+
+	d, err := newCABACDecoder(e.reader(), "P", 0, 30)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from newCABACDecoder", err)
+	}
+	if _, err := d.decodeMvdComp(0, 0); !errors.Is(err, errBadMvd) {
+		t.Errorf("did not get expected error\nGot: %v\nWant: %v", err, errBadMvd)
+	}
+}
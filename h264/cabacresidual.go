@@ -0,0 +1,251 @@
+/*
+NAME
+  cabacresidual.go
+
+DESCRIPTION
+  cabacresidual.go provides the decoding of residual blocks under CABAC, as
+  specified by the residual_block_cabac syntax of section 7.3.5.3.3 of the
+  specifications, with the assignment of context indices to its syntax
+  elements of section 9.3.3.1.3.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "fmt"
+
+// Values of ctxBlockCat, giving the kind of residual block, as specified by
+// table 9-42.
+const (
+	ctxBlockCatLumaDC   = 0  // Intra16x16DCLevel.
+	ctxBlockCatLumaAC   = 1  // Intra16x16ACLevel.
+	ctxBlockCatLuma4x4  = 2  // LumaLevel4x4.
+	ctxBlockCatChromaDC = 3  // ChromaDCLevel.
+	ctxBlockCatChromaAC = 4  // ChromaACLevel.
+	ctxBlockCatLuma8x8  = 5  // LumaLevel8x8.
+	ctxBlockCatCbDC     = 6  // CbIntra16x16DCLevel.
+	ctxBlockCatCbAC     = 7  // CbIntra16x16ACLevel.
+	ctxBlockCatCb4x4    = 8  // CbLevel4x4.
+	ctxBlockCatCb8x8    = 9  // CbLevel8x8.
+	ctxBlockCatCrDC     = 10 // CrIntra16x16DCLevel.
+	ctxBlockCatCrAC     = 11 // CrIntra16x16ACLevel.
+	ctxBlockCatCr4x4    = 12 // CrLevel4x4.
+	ctxBlockCatCr8x8    = 13 // CrLevel8x8.
+	numCtxBlockCat      = 14
+)
+
+// maxNumCoeff8x8 is the number of coefficients of an 8x8 residual block.
+const maxNumCoeff8x8 = 64
+
+// sigCtxIdxOffset gives the ctxIdxOffset of significant_coeff_flag in frame
+// and field coded blocks, then of last_significant_coeff_flag in frame and
+// field coded blocks, for each ctxBlockCat (table 9-34). Blocks of 4:4:4
+// Cb and Cr have their own context variables, as do 8x8 blocks.
+var sigCtxIdxOffset = [numCtxBlockCat][4]int{
+	0: {105, 277, 166, 338}, 1: {105, 277, 166, 338}, 2: {105, 277, 166, 338},
+	3: {105, 277, 166, 338}, 4: {105, 277, 166, 338},
+	5: {402, 436, 417, 451},
+	6: {484, 776, 572, 864}, 7: {484, 776, 572, 864}, 8: {484, 776, 572, 864},
+	9:  {660, 675, 690, 699},
+	10: {528, 820, 616, 908}, 11: {528, 820, 616, 908}, 12: {528, 820, 616, 908},
+	13: {718, 733, 748, 757},
+}
+
+// sigCtxBlockCatOffset gives ctxBlockCatOffset of significant_coeff_flag and
+// last_significant_coeff_flag for each ctxBlockCat (table 9-40).
+var sigCtxBlockCatOffset = [numCtxBlockCat]int{0, 15, 29, 44, 47, 0, 0, 15, 29, 0, 0, 15, 29, 0}
+
+// Table 9-43, giving ctxIdxInc of significant_coeff_flag in frame and field
+// coded 8x8 blocks and of last_significant_coeff_flag in 8x8 blocks, indexed
+// by levelListIdx.
+var (
+	sigCtxIdxInc8x8 = [2][maxNumCoeff8x8 - 1]int{
+		{
+			0, 1, 2, 3, 4, 5, 5, 4, 4, 3, 3, 4, 4, 4, 5, 5,
+			4, 4, 4, 4, 3, 3, 6, 7, 7, 7, 8, 9, 10, 9, 8, 7,
+			7, 6, 11, 12, 13, 11, 6, 7, 8, 9, 14, 10, 9, 8, 6, 11,
+			12, 13, 11, 6, 9, 14, 10, 9, 11, 12, 13, 11, 14, 10, 12,
+		},
+		{
+			0, 1, 1, 2, 2, 3, 3, 4, 5, 6, 7, 7, 7, 8, 4, 5,
+			6, 9, 10, 10, 8, 11, 12, 11, 9, 9, 10, 10, 8, 11, 12, 11,
+			9, 9, 10, 10, 8, 11, 12, 11, 9, 9, 10, 10, 8, 13, 13, 9,
+			9, 10, 10, 8, 13, 13, 9, 9, 10, 10, 14, 14, 14, 14, 14,
+		},
+	}
+	lastCtxIdxInc8x8 = [maxNumCoeff8x8 - 1]int{
+		0, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2,
+		3, 3, 3, 3, 3, 3, 3, 3, 4, 4, 4, 4, 4, 4, 4, 4,
+		5, 5, 5, 5, 6, 6, 6, 6, 7, 7, 7, 7, 8, 8, 8,
+	}
+)
+
+// sigCoeffCtxIdx returns the ctxIdx of significant_coeff_flag, or of
+// last_significant_coeff_flag if last, with index levelListIdx in a residual
+// block of the given ctxBlockCat, field coded if field, as specified by
+// 9.3.3.1.3. numC8x8, being 4 / (SubWidthC * SubHeightC), is used only for
+// chroma DC blocks.
+func sigCoeffCtxIdx(ctxBlockCat int, field, last bool, levelListIdx, numC8x8 int) int {
+	var ctxIdxInc int
+	switch ctxBlockCat {
+	case ctxBlockCatChromaDC:
+		ctxIdxInc = levelListIdx / numC8x8
+		if ctxIdxInc > 2 {
+			ctxIdxInc = 2
+		}
+	case ctxBlockCatLuma8x8, ctxBlockCatCb8x8, ctxBlockCatCr8x8:
+		if last {
+			ctxIdxInc = lastCtxIdxInc8x8[levelListIdx]
+		} else {
+			ctxIdxInc = sigCtxIdxInc8x8[flagVal(field)][levelListIdx]
+		}
+	default:
+		ctxIdxInc = levelListIdx
+	}
+	return sigCtxIdxOffset[ctxBlockCat][2*flagVal(last)+flagVal(field)] + sigCtxBlockCatOffset[ctxBlockCat] + ctxIdxInc
+}
+
+// decodeSignificanceMap decodes the significant_coeff_flag and
+// last_significant_coeff_flag of a residual block of the given ctxBlockCat,
+// field coded if field, whose coded_block_flag is 1, for coefficients of
+// indices startIdx to endIdx of its list of coefficient levels, as in
+// residual_block_cabac. sig, of length at least endIdx + 1, is set to give
+// the significant coefficients. The number of coefficients of the list
+// decoded, being one more than the index of the last significant coefficient,
+// is returned.
+func (d *cabacDecoder) decodeSignificanceMap(ctxBlockCat int, field bool, numC8x8, startIdx, endIdx int, sig []bool) (int, error) {
+	numCoeff := endIdx + 1
+	for i := range sig[:numCoeff] {
+		sig[i] = false
+	}
+	for i := startIdx; i < numCoeff-1; i++ {
+		b, err := d.decodeDecision(sigCoeffCtxIdx(ctxBlockCat, field, false, i, numC8x8))
+		if err != nil {
+			return 0, fmt.Errorf("could not read significant_coeff_flag %d: %w", i, err)
+		}
+		if b == 0 {
+			continue
+		}
+		sig[i] = true
+		b, err = d.decodeDecision(sigCoeffCtxIdx(ctxBlockCat, field, true, i, numC8x8))
+		if err != nil {
+			return 0, fmt.Errorf("could not read last_significant_coeff_flag %d: %w", i, err)
+		}
+		if b == 1 {
+			return i + 1, nil
+		}
+	}
+	// The last coefficient is inferred to be significant.
+	sig[numCoeff-1] = true
+	return numCoeff, nil
+}
+
+// coded_block_flag and coeff_abs_level_minus1 ctxIdxOffset and
+// ctxBlockCatOffset for each ctxBlockCat (tables 9-34 and 9-40).
+var (
+	cbfCtxIdxOffset           = [numCtxBlockCat]int{85, 85, 85, 85, 85, 1012, 460, 460, 460, 1012, 472, 472, 472, 1012}
+	cbfCtxBlockCatOffset      = [numCtxBlockCat]int{0, 4, 8, 12, 16, 0, 0, 4, 8, 4, 0, 4, 8, 8}
+	coeffAbsCtxIdxOffset      = [numCtxBlockCat]int{227, 227, 227, 227, 227, 426, 952, 952, 952, 708, 982, 982, 982, 766}
+	coeffAbsCtxBlockCatOffset = [numCtxBlockCat]int{0, 10, 20, 30, 39, 0, 0, 10, 20, 0, 0, 10, 20, 0}
+)
+
+// coeffAbsLevelUCoff is uCoff of the UEG0 binarization of
+// coeff_abs_level_minus1 (table 9-34).
+const coeffAbsLevelUCoff = 14
+
+// codedBlockFlagCtxIdx returns the ctxIdx of coded_block_flag of a residual
+// block of the given ctxBlockCat with the given ctxIdxInc, as derived from
+// neighbouring blocks by 9.3.3.1.1.9.
+func codedBlockFlagCtxIdx(ctxBlockCat, ctxIdxInc int) int {
+	return cbfCtxIdxOffset[ctxBlockCat] + cbfCtxBlockCatOffset[ctxBlockCat] + ctxIdxInc
+}
+
+// coeffAbsLevelCtxIdx returns the ctxIdx of bin binIdx of the prefix of
+// coeff_abs_level_minus1 in a residual block of the given ctxBlockCat, given
+// the numbers of coefficients of the block already decoded with absolute
+// value equal to 1 and greater than 1, as specified by 9.3.3.1.3.
+func coeffAbsLevelCtxIdx(ctxBlockCat, binIdx, numDecodAbsLevelEq1, numDecodAbsLevelGt1 int) int {
+	var ctxIdxInc int
+	if binIdx == 0 {
+		if numDecodAbsLevelGt1 == 0 {
+			ctxIdxInc = 1 + numDecodAbsLevelEq1
+			if ctxIdxInc > 4 {
+				ctxIdxInc = 4
+			}
+		}
+	} else {
+		maxInc := 4
+		if ctxBlockCat == ctxBlockCatChromaDC {
+			maxInc = 3
+		}
+		ctxIdxInc = numDecodAbsLevelGt1
+		if ctxIdxInc > maxInc {
+			ctxIdxInc = maxInc
+		}
+		ctxIdxInc += 5
+	}
+	return coeffAbsCtxIdxOffset[ctxBlockCat] + coeffAbsCtxBlockCatOffset[ctxBlockCat] + ctxIdxInc
+}
+
+// decodeCodedBlockFlag decodes coded_block_flag of a residual block of the
+// given ctxBlockCat, using the given ctxIdxInc.
+func (d *cabacDecoder) decodeCodedBlockFlag(ctxBlockCat, ctxIdxInc int) (bool, error) {
+	b, err := d.decodeDecision(codedBlockFlagCtxIdx(ctxBlockCat, ctxIdxInc))
+	return b == 1, err
+}
+
+// decodeCoeffAbsLevelMinus1 decodes coeff_abs_level_minus1, using the UEG0
+// binarization with uCoff 14, the prefix bins being decoded using context
+// variables and the suffix bins in bypass mode.
+func (d *cabacDecoder) decodeCoeffAbsLevelMinus1(ctxBlockCat, numDecodAbsLevelEq1, numDecodAbsLevelGt1 int) (int, error) {
+	prefix := func(binIdx int) (int, error) {
+		return d.decodeDecision(coeffAbsLevelCtxIdx(ctxBlockCat, binIdx, numDecodAbsLevelEq1, numDecodAbsLevelGt1))
+	}
+	suffix := func(int) (int, error) { return d.decodeBypass() }
+	return readUEGk(prefix, suffix, false, coeffAbsLevelUCoff, 0)
+}
+
+// decodeResidualBlock decodes the coefficient levels of a residual block of
+// the given ctxBlockCat, field coded if field, whose coded_block_flag is 1,
+// as by residual_block_cabac. The levels of coefficients startIdx to endIdx
+// are set in coeffLevel, those others being set to 0. numC8x8 is as for
+// sigCoeffCtxIdx. The number of non-zero coefficients is returned.
+func (d *cabacDecoder) decodeResidualBlock(ctxBlockCat int, field bool, numC8x8, startIdx, endIdx int, coeffLevel []int) (int, error) {
+	for i := range coeffLevel {
+		coeffLevel[i] = 0
+	}
+	var sig [maxNumCoeff8x8]bool
+	numCoeff, err := d.decodeSignificanceMap(ctxBlockCat, field, numC8x8, startIdx, endIdx, sig[:])
+	if err != nil {
+		return 0, err
+	}
+
+	var numDecodAbsLevelEq1, numDecodAbsLevelGt1, total int
+	for i := numCoeff - 1; i >= startIdx; i-- {
+		if !sig[i] {
+			continue
+		}
+		v, err := d.decodeCoeffAbsLevelMinus1(ctxBlockCat, numDecodAbsLevelEq1, numDecodAbsLevelGt1)
+		if err != nil {
+			return 0, fmt.Errorf("could not read coeff_abs_level_minus1 %d: %w", i, err)
+		}
+		sign, err := d.decodeBypass()
+		if err != nil {
+			return 0, fmt.Errorf("could not read coeff_sign_flag %d: %w", i, err)
+		}
+		if v == 0 {
+			numDecodAbsLevelEq1++
+		} else {
+			numDecodAbsLevelGt1++
+		}
+		coeffLevel[i] = v + 1
+		if sign == 1 {
+			coeffLevel[i] = -coeffLevel[i]
+		}
+		total++
+	}
+	return total, nil
+}
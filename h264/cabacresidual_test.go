@@ -0,0 +1,274 @@
+/*
+NAME
+  cabacresidual_test.go
+
+DESCRIPTION
+  cabacresidual_test.go provides testing for functionality provided in
+  cabacresidual.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSigCoeffCtxIdx(t *testing.T) {
+	tests := []struct {
+		ctxBlockCat  int
+		field, last  bool
+		levelListIdx int
+		numC8x8      int
+		want         int
+	}{
+		{ctxBlockCat: ctxBlockCatLuma4x4, levelListIdx: 3, want: 105 + 29 + 3},
+		{ctxBlockCat: ctxBlockCatLumaAC, field: true, last: true, levelListIdx: 14, want: 338 + 15 + 14},
+		{ctxBlockCat: ctxBlockCatChromaDC, levelListIdx: 3, numC8x8: 1, want: 105 + 44 + 2},
+		{ctxBlockCat: ctxBlockCatChromaDC, levelListIdx: 3, numC8x8: 2, want: 105 + 44 + 1},
+		{ctxBlockCat: ctxBlockCatChromaAC, last: true, levelListIdx: 13, want: 166 + 47 + 13},
+		{ctxBlockCat: ctxBlockCatLuma8x8, levelListIdx: 28, want: 402 + 10},
+		{ctxBlockCat: ctxBlockCatLuma8x8, field: true, levelListIdx: 28, want: 436 + 8},
+		{ctxBlockCat: ctxBlockCatLuma8x8, field: true, last: true, levelListIdx: 62, want: 451 + 8},
+		{ctxBlockCat: ctxBlockCatCb4x4, field: true, levelListIdx: 0, want: 776 + 29},
+		{ctxBlockCat: ctxBlockCatCr8x8, last: true, levelListIdx: 16, want: 748 + 2},
+		{ctxBlockCat: ctxBlockCatCrAC, field: true, last: true, levelListIdx: 14, want: 908 + 15 + 14},
+	}
+
+	for i, test := range tests {
+		got := sigCoeffCtxIdx(test.ctxBlockCat, test.field, test.last, test.levelListIdx, test.numC8x8)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestSigCoeffCtxIdxRanges checks that the context variables of each
+// ctxBlockCat lie within the ranges of table 9-34 for significant_coeff_flag
+// and last_significant_coeff_flag, in frame and field coded blocks, and that
+// those of the 8x8 blocks are all used.
+func TestSigCoeffCtxIdxRanges(t *testing.T) {
+	// Ranges for frame sig, field sig, frame last and field last.
+	ranges := map[int][4][2]int{
+		0:  {{105, 165}, {277, 337}, {166, 226}, {338, 398}},
+		5:  {{402, 416}, {436, 450}, {417, 425}, {451, 459}},
+		6:  {{484, 527}, {776, 819}, {572, 615}, {864, 907}},
+		9:  {{660, 674}, {675, 689}, {690, 698}, {699, 707}},
+		10: {{528, 571}, {820, 863}, {616, 659}, {908, 951}},
+		13: {{718, 732}, {733, 747}, {748, 756}, {757, 765}},
+	}
+	maxNumCoeff := [numCtxBlockCat]int{16, 15, 16, 8, 15, 64, 16, 15, 16, 64, 16, 15, 16, 64}
+	first := [numCtxBlockCat]int{0, 0, 0, 0, 0, 5, 6, 6, 6, 9, 10, 10, 10, 13}
+
+	for cat := 0; cat < numCtxBlockCat; cat++ {
+		for kind := 0; kind < 4; kind++ {
+			r := ranges[first[cat]][kind]
+			used := map[int]bool{}
+			for i := 0; i < maxNumCoeff[cat]-1; i++ {
+				ctxIdx := sigCoeffCtxIdx(cat, kind%2 == 1, kind >= 2, i, 1)
+				if ctxIdx < r[0] || ctxIdx > r[1] {
+					t.Errorf("ctxIdx %d outside range %v for ctxBlockCat: %d, kind: %d, levelListIdx: %d", ctxIdx, r, cat, kind, i)
+				}
+				used[ctxIdx] = true
+			}
+			if maxNumCoeff[cat] == maxNumCoeff8x8 && len(used) != r[1]-r[0]+1 {
+				t.Errorf("did not use all context variables for ctxBlockCat: %d, kind: %d\nGot: %d\nWant: %d", cat, kind, len(used), r[1]-r[0]+1)
+			}
+		}
+	}
+}
+
+// TestDecodeSignificanceMap checks that random significance maps encoded
+// using the context variables given by sigCoeffCtxIdx are decoded.
+func TestDecodeSignificanceMap(t *testing.T) {
+	tests := []struct {
+		ctxBlockCat      int
+		field            bool
+		startIdx, endIdx int
+	}{
+		{ctxBlockCat: ctxBlockCatLuma4x4, endIdx: 15},
+		{ctxBlockCat: ctxBlockCatLumaAC, field: true, endIdx: 14},
+		{ctxBlockCat: ctxBlockCatChromaDC, endIdx: 3},
+		{ctxBlockCat: ctxBlockCatLuma8x8, endIdx: 63},
+		{ctxBlockCat: ctxBlockCatCb8x8, field: true, endIdx: 63},
+		{ctxBlockCat: ctxBlockCatLuma4x4, startIdx: 2, endIdx: 9},
+	}
+
+	const numC8x8 = 1
+	rng := rand.New(rand.NewSource(1))
+	for i, test := range tests {
+		for n := 0; n < 50; n++ {
+			// A map with a random last significant coefficient and
+			// significant coefficients before it.
+			want := make([]bool, test.endIdx+1)
+			last := test.startIdx + rng.Intn(test.endIdx-test.startIdx+1)
+			want[last] = true
+			for j := test.startIdx; j < last; j++ {
+				want[j] = rng.Intn(3) == 0
+			}
+
+			e := newCABACEncoder(t, "I", 0, 26)
+			for j := test.startIdx; j < test.endIdx; j++ {
+				e.encodeDecision(sigCoeffCtxIdx(test.ctxBlockCat, test.field, false, j, numC8x8), flagVal(want[j]))
+				if !want[j] {
+					continue
+				}
+				e.encodeDecision(sigCoeffCtxIdx(test.ctxBlockCat, test.field, true, j, numC8x8), flagVal(j == last))
+				if j == last {
+					break
+				}
+			}
+			e.encodeTerminate(1)
+
+			d, err := newCABACDecoder(e.reader(), "I", 0, 26)
+			if err != nil {
+				t.Fatalf("did not expect error: %v from newCABACDecoder for test: %d", err, i)
+			}
+			got := make([]bool, test.endIdx+1)
+			for j := range got {
+				got[j] = true // To be cleared.
+			}
+			numCoeff, err := d.decodeSignificanceMap(test.ctxBlockCat, test.field, numC8x8, test.startIdx, test.endIdx, got)
+			if err != nil {
+				t.Fatalf("did not expect error: %v for test: %d", err, i)
+			}
+			if numCoeff != last+1 {
+				t.Errorf("did not get expected number of coefficients for test: %d\nGot: %v\nWant: %v", i, numCoeff, last+1)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, want)
+			}
+		}
+	}
+}
+
+func TestCoeffAbsLevelCtxIdx(t *testing.T) {
+	tests := []struct {
+		ctxBlockCat, binIdx int
+		eq1, gt1            int
+		want                int
+	}{
+		{ctxBlockCat: ctxBlockCatLuma4x4, binIdx: 0, want: 227 + 20 + 1},
+		{ctxBlockCat: ctxBlockCatLuma4x4, binIdx: 0, eq1: 2, want: 227 + 20 + 3},
+		{ctxBlockCat: ctxBlockCatLuma4x4, binIdx: 0, eq1: 7, want: 227 + 20 + 4},
+		{ctxBlockCat: ctxBlockCatLuma4x4, binIdx: 0, eq1: 2, gt1: 1, want: 227 + 20},
+		{ctxBlockCat: ctxBlockCatLuma4x4, binIdx: 3, gt1: 2, want: 227 + 20 + 7},
+		{ctxBlockCat: ctxBlockCatChromaAC, binIdx: 13, gt1: 9, want: 227 + 39 + 9},
+		{ctxBlockCat: ctxBlockCatChromaDC, binIdx: 1, gt1: 9, want: 227 + 30 + 8},
+		{ctxBlockCat: ctxBlockCatLuma8x8, binIdx: 1, want: 426 + 5},
+		{ctxBlockCat: ctxBlockCatCb4x4, binIdx: 1, gt1: 4, want: 952 + 20 + 9},
+		{ctxBlockCat: ctxBlockCatCr8x8, binIdx: 0, want: 766 + 1},
+	}
+
+	for i, test := range tests {
+		got := coeffAbsLevelCtxIdx(test.ctxBlockCat, test.binIdx, test.eq1, test.gt1)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestCodedBlockFlagCtxIdx(t *testing.T) {
+	tests := []struct {
+		ctxBlockCat, inc, want int
+	}{
+		{ctxBlockCat: ctxBlockCatLumaDC, inc: 0, want: 85},
+		{ctxBlockCat: ctxBlockCatChromaAC, inc: 3, want: 85 + 16 + 3},
+		{ctxBlockCat: ctxBlockCatLuma8x8, inc: 3, want: 1015},
+		{ctxBlockCat: ctxBlockCatCb8x8, inc: 0, want: 1016},
+		{ctxBlockCat: ctxBlockCatCr4x4, inc: 3, want: 472 + 8 + 3},
+	}
+
+	for i, test := range tests {
+		if got := codedBlockFlagCtxIdx(test.ctxBlockCat, test.inc); got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestDecodeResidualBlock checks that random residual blocks encoded as by
+// residual_block_cabac, following coded_block_flag, are decoded.
+func TestDecodeResidualBlock(t *testing.T) {
+	tests := []struct {
+		ctxBlockCat      int
+		startIdx, endIdx int
+	}{
+		{ctxBlockCat: ctxBlockCatLuma4x4, endIdx: 15},
+		{ctxBlockCat: ctxBlockCatChromaDC, endIdx: 3},
+		{ctxBlockCat: ctxBlockCatLuma8x8, endIdx: 63},
+		{ctxBlockCat: ctxBlockCatCrAC, startIdx: 1, endIdx: 14},
+	}
+
+	rng := rand.New(rand.NewSource(2))
+	for i, test := range tests {
+		for n := 0; n < 50; n++ {
+			want := make([]int, test.endIdx+1)
+			last := test.startIdx + rng.Intn(test.endIdx-test.startIdx+1)
+			var total int
+			for j := test.startIdx; j <= last; j++ {
+				if j != last && rng.Intn(2) == 0 {
+					continue
+				}
+				// Mostly small levels, with some beyond the prefix of the
+				// binarization.
+				want[j] = 1 + rng.Intn(3)
+				if rng.Intn(8) == 0 {
+					want[j] = 1 + rng.Intn(2000)
+				}
+				if rng.Intn(2) == 0 {
+					want[j] = -want[j]
+				}
+				total++
+			}
+
+			e := newCABACEncoder(t, "P", 1, 35)
+			for j := test.startIdx; j < test.endIdx; j++ {
+				e.encodeDecision(sigCoeffCtxIdx(test.ctxBlockCat, false, false, j, 1), flagVal(want[j] != 0))
+				if want[j] == 0 {
+					continue
+				}
+				e.encodeDecision(sigCoeffCtxIdx(test.ctxBlockCat, false, true, j, 1), flagVal(j == last))
+				if j == last {
+					break
+				}
+			}
+			var eq1, gt1 int
+			for j := last; j >= test.startIdx; j-- {
+				if want[j] == 0 {
+					continue
+				}
+				v := abs(want[j]) - 1
+				e.encodeUEGk(v, false, coeffAbsLevelUCoff, 0, func(binIdx int) int {
+					return coeffAbsLevelCtxIdx(test.ctxBlockCat, binIdx, eq1, gt1)
+				})
+				e.encodeBypass(flagVal(want[j] < 0))
+				if v == 0 {
+					eq1++
+				} else {
+					gt1++
+				}
+			}
+			e.encodeTerminate(1)
+
+			d, err := newCABACDecoder(e.reader(), "P", 1, 35)
+			if err != nil {
+				t.Fatalf("did not expect error: %v from newCABACDecoder for test: %d", err, i)
+			}
+			got := make([]int, test.endIdx+1)
+			numNonZero, err := d.decodeResidualBlock(test.ctxBlockCat, false, 1, test.startIdx, test.endIdx, got)
+			if err != nil {
+				t.Fatalf("did not expect error: %v for test: %d", err, i)
+			}
+			if numNonZero != total {
+				t.Errorf("did not get expected number of coefficients for test: %d\nGot: %v\nWant: %v", i, numNonZero, total)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,249 @@
+/*
+NAME
+  cabacvectors_test.go
+
+DESCRIPTION
+  cabacvectors_test.go provides a harness running the CABAC decoding engine
+  against vectors of input bits and the bins and engine state they decode to,
+  as derived by hand from the processes and tables of section 9.3 of the
+  specifications, so that errors in the transcription of the state tables are
+  caught by a single wrong bin rather than a corrupt slice.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// ctxBypass is used in place of a ctxIdx in a cabacVector for bins decoded in
+// bypass mode.
+const ctxBypass = -1
+
+// cabacVector is a CABAC decoding engine test vector. Context variables are
+// initialised for the given slice type, cabac_init_idc and SliceQPY, and then
+// those of ctx set, before decoding a bin using each ctxIdx of ctxIdxs, or
+// ctxBypass, from input.
+type cabacVector struct {
+	name         string
+	sliceType    string
+	cabacInitIdc int
+	sliceQPY     int
+	ctx          map[int]ContextVariable
+	input        []byte
+	ctxIdxs      []int
+
+	// Expected bins, state following the last, and number of bits read.
+	bins       []int
+	codIRange  int
+	codIOffset int
+	wantCtx    map[int]ContextVariable
+	off        int
+}
+
+var cabacVectors = []cabacVector{
+	{
+		// codIOffset is initially 0b100101100 = 300 and all following bits
+		// are 0.
+		//
+		// Bin 0, from (pStateIdx 0, valMPS 0): qCodIRangeIdx = (510 >> 6) & 3 = 3,
+		// codIRangeLPS = 240, codIRange = 270. 300 >= 270 so the LPS, 1, with
+		// codIOffset = 30, codIRange = 240 and, pStateIdx being 0, valMPS
+		// becoming 1 with pStateIdx transIdxLPS[0] = 0. RenormD reads one
+		// bit, giving codIRange 480, codIOffset 60.
+		//
+		// Bin 1, from (0, 1): qCodIRangeIdx = 3, codIRangeLPS = 240,
+		// codIRange = 240. 60 < 240 so the MPS, 1, with pStateIdx
+		// transIdxMPS[0] = 1. RenormD reads one bit, giving 480, 120.
+		//
+		// Bin 2, from (1, 1): codIRangeLPS = rangeTabLPS[1][3] = 227,
+		// codIRange = 253. 120 < 253 so the MPS, 1, with pStateIdx 2.
+		// RenormD reads one bit, giving 506, 240.
+		//
+		// Bin 3, bypass: codIOffset = 480 < 506 so 0.
+		//
+		// Bin 4, terminate: codIRange = 504. 480 < 504 so 0, with no
+		// renormalization.
+		//
+		// Bin 5, bypass: codIOffset = 960 >= 504 so 1, codIOffset 456.
+		name:       "LPS then MPS from state 0",
+		sliceType:  "P",
+		sliceQPY:   26,
+		ctx:        map[int]ContextVariable{11: {PStateIdx: 0, ValMPS: 0}},
+		input:      []byte{0x96, 0x00},
+		ctxIdxs:    []int{11, 11, 11, ctxBypass, ctxIdxEndOfSlice, ctxBypass},
+		bins:       []int{1, 1, 1, 0, 0, 1},
+		codIRange:  504,
+		codIOffset: 456,
+		wantCtx:    map[int]ContextVariable{11: {PStateIdx: 2, ValMPS: 1}},
+		off:        14,
+	},
+	{
+		// With codIOffset 0 every bin is the MPS of its context variable,
+		// whose initial state is given by 9.3.1.1 with SliceQPY 26.
+		//
+		// Bin 0, ctxIdx 3, (m, n) = (20, -15): preCtxState = ((20 * 26) >> 4)
+		// - 15 = 17, so pStateIdx 46, valMPS 0. codIRangeLPS =
+		// rangeTabLPS[46][3] = 22, codIRange = 488, and the bin is 0.
+		//
+		// Bin 1, ctxIdx 4, (2, 54): preCtxState = 3 + 54 = 57, so 6, 0.
+		// codIRangeLPS = rangeTabLPS[6][3] = 175, codIRange = 313. 0.
+		//
+		// Bin 2, ctxIdx 6, (-28, 127): preCtxState = -46 + 127 = 81, so 17, 1.
+		// qCodIRangeIdx = (313 >> 6) & 3 = 0, codIRangeLPS = 59, codIRange =
+		// 254. 1, and RenormD reads one bit, giving codIRange 508.
+		//
+		// Bin 3, ctxIdx 7, (-23, 104): preCtxState = -38 + 104 = 66, so 2, 1.
+		// codIRangeLPS = rangeTabLPS[2][3] = 216, codIRange = 292. 1.
+		//
+		// Bin 4, terminate: codIRange = 290, and the bin is 0.
+		//
+		// Each context variable transitions to pStateIdx transIdxMPS, one
+		// greater.
+		name:      "MPS of initialised contexts",
+		sliceType: "I",
+		sliceQPY:  26,
+		input:     []byte{0x00, 0x00, 0x00, 0x00},
+		ctxIdxs:   []int{3, 4, 6, 7, ctxIdxEndOfSlice},
+		bins:      []int{0, 0, 1, 1, 0},
+		codIRange: 290,
+		wantCtx: map[int]ContextVariable{
+			3: {PStateIdx: 47, ValMPS: 0},
+			4: {PStateIdx: 7, ValMPS: 0},
+			6: {PStateIdx: 18, ValMPS: 1},
+			7: {PStateIdx: 3, ValMPS: 1},
+		},
+		off: 10,
+	},
+	{
+		// codIOffset is initially 0b111111100 = 508.
+		//
+		// Bin 0, terminate: codIRange = 508. 508 >= 508 so 1, ending
+		// decoding with no renormalization, the last bit read being the
+		// rbsp_stop_one_bit.
+		name:       "termination",
+		sliceType:  "I",
+		sliceQPY:   26,
+		input:      []byte{0xfe, 0x00},
+		ctxIdxs:    []int{ctxIdxEndOfSlice},
+		bins:       []int{1},
+		codIRange:  508,
+		codIOffset: 508,
+		off:        9,
+	},
+	{
+		// codIOffset is initially 0b011111111 = 255, followed by bits 1 0.
+		//
+		// Bin 0, from (pStateIdx 62, valMPS 1): codIRangeLPS =
+		// rangeTabLPS[62][3] = 9, codIRange = 501. 255 < 501 so the MPS, 1,
+		// with pStateIdx transIdxMPS[62] = 62, it being the greatest
+		// adaptive state, and no renormalization.
+		//
+		// Bins 1 to 3, from (62, 1): qCodIRangeIdx remains 3, and codIRange
+		// becomes 492, 483 and 474. 1.
+		//
+		// Bin 4, from (pStateIdx 62, valMPS 0): codIRange = 465. 255 < 465 so
+		// the MPS, 0.
+		//
+		// Bin 5, bypass: codIOffset = 511 >= 465 so 1, codIOffset 46.
+		//
+		// Bin 6, bypass: codIOffset = 92 < 465 so 0.
+		name:       "most probable state",
+		sliceType:  "I",
+		sliceQPY:   26,
+		ctx:        map[int]ContextVariable{60: {PStateIdx: 62, ValMPS: 1}, 61: {PStateIdx: 62, ValMPS: 0}},
+		input:      []byte{0x7f, 0xc0},
+		ctxIdxs:    []int{60, 60, 60, 60, 61, ctxBypass, ctxBypass},
+		bins:       []int{1, 1, 1, 1, 0, 1, 0},
+		codIRange:  465,
+		codIOffset: 92,
+		wantCtx:    map[int]ContextVariable{60: {PStateIdx: 62, ValMPS: 1}, 61: {PStateIdx: 62, ValMPS: 0}},
+		off:        11,
+	},
+	{
+		// codIOffset is initially 0b111010110 = 470, and all following bits
+		// are 0. This uses the row of rangeTabLPS for pStateIdx 33, once
+		// mis-transcribed.
+		//
+		// Bin 0, from (pStateIdx 33, valMPS 0): codIRangeLPS =
+		// rangeTabLPS[33][3] = 43, codIRange = 467. 470 >= 467 so the LPS, 1,
+		// with codIOffset 3, codIRange 43 and pStateIdx transIdxLPS[33] = 25.
+		// RenormD reads three bits, giving codIRange 344, codIOffset 24.
+		//
+		// Bin 1, from (33, 1): qCodIRangeIdx = (344 >> 6) & 3 = 1,
+		// codIRangeLPS = rangeTabLPS[33][1] = 31, codIRange = 313. 24 < 313 so
+		// the MPS, 1, with pStateIdx 34.
+		//
+		// Bin 2, from (34, 1): qCodIRangeIdx = (313 >> 6) & 3 = 0,
+		// codIRangeLPS = rangeTabLPS[34][0] = 24, codIRange = 289. 1, with
+		// pStateIdx 35.
+		//
+		// Bin 3, terminate: codIRange = 287, and the bin is 0.
+		name:       "LPS with renormalization",
+		sliceType:  "B",
+		sliceQPY:   30,
+		ctx:        map[int]ContextVariable{11: {PStateIdx: 33, ValMPS: 0}, 12: {PStateIdx: 33, ValMPS: 1}},
+		input:      []byte{0xeb, 0x00, 0x00},
+		ctxIdxs:    []int{11, 12, 12, ctxIdxEndOfSlice},
+		bins:       []int{1, 1, 1, 0},
+		codIRange:  287,
+		codIOffset: 24,
+		wantCtx:    map[int]ContextVariable{11: {PStateIdx: 25, ValMPS: 0}, 12: {PStateIdx: 35, ValMPS: 1}},
+		off:        12,
+	},
+}
+
+// TestCABACVectors runs the vectors with the decoder reading from both a
+// BitReader and a byteBits.
+func TestCABACVectors(t *testing.T) {
+	for _, v := range cabacVectors {
+		testCABACVector(t, v, bits.NewBitReader(bytes.NewReader(v.input)))
+		testCABACVector(t, v, newByteBits(v.input))
+	}
+}
+
+func testCABACVector(t *testing.T, v cabacVector, br bitSource) {
+	d, err := newCABACDecoder(br, v.sliceType, v.cabacInitIdc, v.sliceQPY)
+	if err != nil {
+		t.Errorf("did not expect error: %v from newCABACDecoder for vector: %s", err, v.name)
+		return
+	}
+	for ctxIdx, c := range v.ctx {
+		d.ctx[ctxIdx] = c
+	}
+
+	for i, ctxIdx := range v.ctxIdxs {
+		var bin int
+		if ctxIdx == ctxBypass {
+			bin, err = d.decodeBypass()
+		} else {
+			bin, err = d.decodeBin(ctxIdx)
+		}
+		if err != nil {
+			t.Errorf("did not expect error: %v for vector: %s, bin: %d", err, v.name, i)
+			break
+		}
+		if bin != v.bins[i] {
+			t.Errorf("did not get expected bin for vector: %s, bin: %d\nGot: %d\nWant: %d", v.name, i, bin, v.bins[i])
+			break
+		}
+	}
+
+	if d.codIRange != v.codIRange || d.codIOffset != v.codIOffset {
+		t.Errorf("did not get expected engine state for vector: %s\nGot: codIRange %d, codIOffset %d\nWant: codIRange %d, codIOffset %d", v.name, d.codIRange, d.codIOffset, v.codIRange, v.codIOffset)
+	}
+	for ctxIdx, want := range v.wantCtx {
+		if got := d.ctx[ctxIdx]; got != want {
+			t.Errorf("did not get expected context variable %d for vector: %s\nGot: %+v\nWant: %+v", ctxIdx, v.name, got, want)
+		}
+	}
+	if br.Off() != v.off {
+		t.Errorf("did not get expected bits read for vector: %s\nGot: %d\nWant: %d", v.name, br.Off(), v.off)
+	}
+}
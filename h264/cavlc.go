@@ -0,0 +1,644 @@
+/*
+NAME
+  cavlc.go
+
+DESCRIPTION
+  cavlc.go provides the parsing of the syntax elements of residual blocks
+  coded using CAVLC, as specified in section 9.2 of the specifications, the
+  variable length codes of each being given by tables 9-5 to 9-10.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// vlcCode is a variable length code, being its length in bits and the value
+// of those bits.
+type vlcCode struct {
+	len  int
+	code int
+}
+
+// vlcTable maps the codes of a syntax element to their values.
+type vlcTable struct {
+	codes  map[vlcCode]int
+	maxLen int // Length of the longest code.
+}
+
+// newVLCTable returns a vlcTable of the codes whose lengths and values are
+// given by lens and codes, the value of each code being its index. Codes of
+// length 0 are not used.
+func newVLCTable(lens, codes []uint8) *vlcTable {
+	tab := &vlcTable{codes: make(map[vlcCode]int)}
+	for i, l := range lens {
+		if l != 0 {
+			tab.add(vlcCode{int(l), int(codes[i])}, i)
+		}
+	}
+	return tab
+}
+
+// add adds code c with value v to tab.
+func (tab *vlcTable) add(c vlcCode, v int) {
+	tab.codes[c] = v
+	if c.len > tab.maxLen {
+		tab.maxLen = c.len
+	}
+}
+
+// readVLC reads a code of tab from br, returning its value. An error wrapping
+// errBadVLC is returned if the bits read are not a code of tab.
+func readVLC(br *bits.BitReader, tab *vlcTable) (int, error) {
+	var c vlcCode
+	for c.len < tab.maxLen {
+		b, err := br.ReadBits(1)
+		if err != nil {
+			return 0, fmt.Errorf("could not read bit: %w", err)
+		}
+		c.code = c.code<<1 | int(b)
+		c.len++
+		if v, ok := tab.codes[c]; ok {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %0*b", errBadVLC, c.len, c.code)
+}
+
+// Tables of coeff_token selected by nC, see coeffTokenTable.
+const (
+	coeffTokenNC0      = iota // 0 <= nC < 2.
+	coeffTokenNC2             // 2 <= nC < 4.
+	coeffTokenNC4             // 4 <= nC < 8.
+	coeffTokenNC8             // 8 <= nC, codes of 6 bits.
+	coeffTokenNCMinus1        // nC == -1, chroma DC of 4:2:0.
+	coeffTokenNCMinus2        // nC == -2, chroma DC of 4:2:2.
+	numCoeffTokenTables
+)
+
+// coeffTokenLens and coeffTokenCodes give the length and value of the codes
+// of coeff_token for the tables with nC of at least 0 and less than 8,
+// indexed by TrailingOnes and TotalCoeff, from table 9-5.
+var (
+	coeffTokenLens = [3][4][17]uint8{
+		coeffTokenNC0: {
+			{1, 6, 8, 9, 10, 11, 13, 13, 13, 14, 14, 15, 15, 16, 16, 16, 16},
+			{0, 2, 6, 8, 9, 10, 11, 13, 13, 14, 14, 15, 15, 15, 16, 16, 16},
+			{0, 0, 3, 7, 8, 9, 10, 11, 13, 13, 14, 14, 15, 15, 16, 16, 16},
+			{0, 0, 0, 5, 6, 7, 8, 9, 10, 11, 13, 14, 14, 15, 15, 16, 16},
+		},
+		coeffTokenNC2: {
+			{2, 6, 6, 7, 8, 8, 9, 11, 11, 12, 12, 12, 13, 13, 13, 14, 14},
+			{0, 2, 5, 6, 6, 7, 8, 9, 11, 11, 12, 12, 13, 13, 14, 14, 14},
+			{0, 0, 3, 6, 6, 7, 8, 9, 11, 11, 12, 12, 13, 13, 13, 14, 14},
+			{0, 0, 0, 4, 4, 5, 6, 6, 7, 9, 11, 11, 12, 13, 13, 13, 14},
+		},
+		coeffTokenNC4: {
+			{4, 6, 6, 6, 7, 7, 7, 7, 8, 8, 9, 9, 9, 10, 10, 10, 10},
+			{0, 4, 5, 5, 5, 5, 6, 6, 7, 8, 8, 9, 9, 9, 10, 10, 10},
+			{0, 0, 4, 5, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 10},
+			{0, 0, 0, 4, 4, 4, 4, 4, 5, 6, 7, 8, 8, 9, 10, 10, 10},
+		},
+	}
+	coeffTokenCodes = [3][4][17]uint8{
+		coeffTokenNC0: {
+			{1, 5, 7, 7, 7, 7, 15, 11, 8, 15, 11, 15, 11, 15, 11, 7, 4},
+			{0, 1, 4, 6, 6, 6, 6, 14, 10, 14, 10, 14, 10, 1, 14, 10, 6},
+			{0, 0, 1, 5, 5, 5, 5, 5, 13, 9, 13, 9, 13, 9, 13, 9, 5},
+			{0, 0, 0, 3, 3, 4, 4, 4, 4, 4, 12, 12, 8, 12, 8, 12, 8},
+		},
+		coeffTokenNC2: {
+			{3, 11, 7, 7, 7, 4, 7, 15, 11, 15, 11, 8, 15, 11, 7, 9, 7},
+			{0, 2, 7, 10, 6, 6, 6, 6, 14, 10, 14, 10, 14, 10, 11, 8, 6},
+			{0, 0, 3, 9, 5, 5, 5, 5, 13, 9, 13, 9, 13, 9, 6, 10, 5},
+			{0, 0, 0, 5, 4, 6, 8, 4, 4, 4, 12, 8, 12, 12, 8, 1, 4},
+		},
+		coeffTokenNC4: {
+			{15, 15, 11, 8, 15, 11, 9, 8, 15, 11, 15, 11, 8, 13, 9, 5, 1},
+			{0, 14, 15, 12, 10, 8, 14, 10, 14, 14, 10, 14, 10, 7, 12, 8, 4},
+			{0, 0, 13, 14, 11, 9, 13, 9, 13, 10, 13, 9, 13, 9, 11, 7, 3},
+			{0, 0, 0, 12, 11, 10, 9, 8, 13, 12, 12, 12, 8, 12, 10, 6, 2},
+		},
+	}
+)
+
+// coeffTokenChromaDCLens and coeffTokenChromaDCCodes give the codes of
+// coeff_token for chroma DC of 4:2:0, where nC is -1, and
+// coeffTokenChromaDC422Lens and coeffTokenChromaDC422Codes those of 4:2:2,
+// where nC is -2, indexed by TrailingOnes and TotalCoeff, from table 9-5.
+var (
+	coeffTokenChromaDCLens = [4][5]uint8{
+		{2, 6, 6, 6, 6},
+		{0, 1, 6, 7, 8},
+		{0, 0, 3, 7, 8},
+		{0, 0, 0, 6, 7},
+	}
+	coeffTokenChromaDCCodes = [4][5]uint8{
+		{1, 7, 4, 3, 2},
+		{0, 1, 6, 3, 3},
+		{0, 0, 1, 2, 2},
+		{0, 0, 0, 5, 0},
+	}
+	coeffTokenChromaDC422Lens = [4][9]uint8{
+		{1, 7, 7, 9, 9, 10, 11, 12, 13},
+		{0, 2, 7, 7, 9, 10, 11, 12, 12},
+		{0, 0, 3, 7, 7, 9, 10, 11, 12},
+		{0, 0, 0, 5, 6, 7, 7, 10, 11},
+	}
+	coeffTokenChromaDC422Codes = [4][9]uint8{
+		{1, 15, 14, 7, 6, 7, 7, 7, 7},
+		{0, 1, 13, 12, 5, 6, 6, 6, 5},
+		{0, 0, 1, 11, 10, 4, 5, 5, 4},
+		{0, 0, 0, 1, 1, 9, 8, 4, 4},
+	}
+)
+
+// coeffTokenTables holds the vlcTable of each table of coeff_token, the value
+// of each code being TotalCoeff<<2 | TrailingOnes.
+var coeffTokenTables = func() [numCoeffTokenTables]*vlcTable {
+	var tabs [numCoeffTokenTables]*vlcTable
+	for n := range tabs {
+		tabs[n] = &vlcTable{codes: make(map[vlcCode]int)}
+	}
+	for n := range coeffTokenLens {
+		for t1 := range coeffTokenLens[n] {
+			addCoeffTokens(tabs[n], coeffTokenLens[n][t1][:], coeffTokenCodes[n][t1][:], t1)
+		}
+	}
+
+	// For 8 <= nC the code is 6 bits, being TotalCoeff-1 in the 4 most
+	// significant bits and TrailingOnes in the 2 least, except that
+	// TotalCoeff of 0 is coded as 000011.
+	tabs[coeffTokenNC8].add(vlcCode{6, 3}, 0)
+	for tc := 1; tc <= 16; tc++ {
+		for t1 := 0; t1 <= 3 && t1 <= tc; t1++ {
+			tabs[coeffTokenNC8].add(vlcCode{6, (tc-1)<<2 | t1}, tc<<2|t1)
+		}
+	}
+
+	for t1, lens := range coeffTokenChromaDCLens {
+		addCoeffTokens(tabs[coeffTokenNCMinus1], lens[:], coeffTokenChromaDCCodes[t1][:], t1)
+	}
+	for t1, lens := range coeffTokenChromaDC422Lens {
+		addCoeffTokens(tabs[coeffTokenNCMinus2], lens[:], coeffTokenChromaDC422Codes[t1][:], t1)
+	}
+	return tabs
+}()
+
+// addCoeffTokens adds to tab the codes of coeff_token with the given
+// TrailingOnes, whose lengths and values are given by lens and codes indexed
+// by TotalCoeff. Codes of length 0 are not used.
+func addCoeffTokens(tab *vlcTable, lens, codes []uint8, trailingOnes int) {
+	for tc, l := range lens {
+		if l != 0 {
+			tab.add(vlcCode{int(l), int(codes[tc])}, tc<<2|trailingOnes)
+		}
+	}
+}
+
+// coeffTokenTable returns the table of coeff_token used for the given nC,
+// derived as specified by 9.2.1.
+func coeffTokenTable(nC int) (int, error) {
+	switch {
+	case nC == -2:
+		return coeffTokenNCMinus2, nil
+	case nC == -1:
+		return coeffTokenNCMinus1, nil
+	case nC < 0:
+		return 0, fmt.Errorf("%w: %d", errBadNC, nC)
+	case nC < 2:
+		return coeffTokenNC0, nil
+	case nC < 4:
+		return coeffTokenNC2, nil
+	case nC < 8:
+		return coeffTokenNC4, nil
+	default:
+		return coeffTokenNC8, nil
+	}
+}
+
+// readCoeffToken parses coeff_token using the table selected by nC, as
+// specified by 9.2.1, returning TotalCoeff and TrailingOnes.
+func readCoeffToken(br *bits.BitReader, nC int) (totalCoeff, trailingOnes int, err error) {
+	n, err := coeffTokenTable(nC)
+	if err != nil {
+		return 0, 0, err
+	}
+	v, err := readVLC(br, coeffTokenTables[n])
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not read coeff_token: %w", err)
+	}
+	return v >> 2, v & 3, nil
+}
+
+// totalZerosLens and totalZerosCodes give the length and value of the codes
+// of total_zeros for 4x4 blocks, indexed by tzVlcIndex-1 and total_zeros, from
+// tables 9-7 and 9-8.
+var (
+	totalZerosLens = [15][]uint8{
+		{1, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 9},
+		{3, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 6, 6, 6, 6},
+		{4, 3, 3, 3, 4, 4, 3, 3, 4, 5, 5, 6, 5, 6},
+		{5, 3, 4, 4, 3, 3, 3, 4, 3, 4, 5, 5, 5},
+		{4, 4, 4, 3, 3, 3, 3, 3, 4, 5, 4, 5},
+		{6, 5, 3, 3, 3, 3, 3, 3, 4, 3, 6},
+		{6, 5, 3, 3, 3, 2, 3, 4, 3, 6},
+		{6, 4, 5, 3, 2, 2, 3, 3, 6},
+		{6, 6, 4, 2, 2, 3, 2, 5},
+		{5, 5, 3, 2, 2, 2, 4},
+		{4, 4, 3, 3, 1, 3},
+		{4, 4, 2, 1, 3},
+		{3, 3, 1, 2},
+		{2, 2, 1},
+		{1, 1},
+	}
+	totalZerosCodes = [15][]uint8{
+		{1, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 1},
+		{7, 6, 5, 4, 3, 5, 4, 3, 2, 3, 2, 3, 2, 1, 0},
+		{5, 7, 6, 5, 4, 3, 4, 3, 2, 3, 2, 1, 1, 0},
+		{3, 7, 5, 4, 6, 5, 4, 3, 3, 2, 2, 1, 0},
+		{5, 4, 3, 7, 6, 5, 4, 3, 2, 1, 1, 0},
+		{1, 1, 7, 6, 5, 4, 3, 2, 1, 1, 0},
+		{1, 1, 5, 4, 3, 3, 2, 1, 1, 0},
+		{1, 1, 1, 3, 3, 2, 2, 1, 0},
+		{1, 0, 1, 3, 2, 1, 1, 1},
+		{1, 0, 1, 3, 2, 1, 1},
+		{0, 1, 1, 2, 1, 3},
+		{0, 1, 1, 1, 1},
+		{0, 1, 1, 1},
+		{0, 1, 1},
+		{0, 1},
+	}
+)
+
+// totalZerosChromaDCLens and totalZerosChromaDCCodes give the codes of
+// total_zeros for chroma DC of 4:2:0, from table 9-9a, and
+// totalZerosChromaDC422Lens and totalZerosChromaDC422Codes those of 4:2:2,
+// from table 9-9b, indexed by tzVlcIndex-1 and total_zeros.
+var (
+	totalZerosChromaDCLens = [3][]uint8{
+		{1, 2, 3, 3},
+		{1, 2, 2},
+		{1, 1},
+	}
+	totalZerosChromaDCCodes = [3][]uint8{
+		{1, 1, 1, 0},
+		{1, 1, 0},
+		{1, 0},
+	}
+	totalZerosChromaDC422Lens = [7][]uint8{
+		{1, 3, 3, 4, 4, 4, 5, 5},
+		{3, 2, 3, 3, 3, 3, 3},
+		{3, 3, 2, 2, 3, 3},
+		{3, 2, 2, 2, 3},
+		{2, 2, 2, 2},
+		{2, 2, 1},
+		{1, 1},
+	}
+	totalZerosChromaDC422Codes = [7][]uint8{
+		{1, 2, 3, 2, 3, 1, 1, 0},
+		{0, 1, 1, 4, 5, 6, 7},
+		{0, 1, 1, 2, 6, 7},
+		{6, 0, 1, 2, 7},
+		{0, 1, 2, 3},
+		{0, 1, 1},
+		{0, 1},
+	}
+)
+
+// totalZerosTables, totalZerosChromaDCTables and totalZerosChromaDC422Tables
+// hold the vlcTable of total_zeros for each tzVlcIndex, from 1, for 4x4
+// blocks and chroma DC of 4:2:0 and 4:2:2 respectively.
+var (
+	totalZerosTables            = newVLCTables(totalZerosLens[:], totalZerosCodes[:])
+	totalZerosChromaDCTables    = newVLCTables(totalZerosChromaDCLens[:], totalZerosChromaDCCodes[:])
+	totalZerosChromaDC422Tables = newVLCTables(totalZerosChromaDC422Lens[:], totalZerosChromaDC422Codes[:])
+)
+
+// newVLCTables returns a vlcTable for each of the rows of lens and codes, see
+// newVLCTable.
+func newVLCTables(lens, codes [][]uint8) []*vlcTable {
+	tabs := make([]*vlcTable, len(lens))
+	for i := range lens {
+		tabs[i] = newVLCTable(lens[i], codes[i])
+	}
+	return tabs
+}
+
+// readTotalZeros parses total_zeros of a block with the given TotalCoeff and
+// maxNumCoeff, as specified by 9.2.3. The table used is selected by
+// maxNumCoeff, being 4 for chroma DC of 4:2:0, 8 for chroma DC of 4:2:2, and
+// otherwise that of 4x4 blocks, and tzVlcIndex, being TotalCoeff, which must
+// be at least 1 and less than maxNumCoeff.
+func readTotalZeros(br *bits.BitReader, totalCoeff, maxNumCoeff int) (int, error) {
+	tabs := totalZerosTables
+	switch maxNumCoeff {
+	case 4:
+		tabs = totalZerosChromaDCTables
+	case 8:
+		tabs = totalZerosChromaDC422Tables
+	}
+	if totalCoeff < 1 || totalCoeff >= maxNumCoeff || totalCoeff > len(tabs) {
+		return 0, fmt.Errorf("%w: %d with maxNumCoeff %d", errBadTotalCoeff, totalCoeff, maxNumCoeff)
+	}
+	v, err := readVLC(br, tabs[totalCoeff-1])
+	if err != nil {
+		return 0, fmt.Errorf("could not read total_zeros: %w", err)
+	}
+	if v > maxNumCoeff-totalCoeff {
+		return 0, fmt.Errorf("%w: %d with TotalCoeff %d and maxNumCoeff %d", errBadTotalZeros, v, totalCoeff, maxNumCoeff)
+	}
+	return v, nil
+}
+
+// runBeforeLens and runBeforeCodes give the length and value of the codes of
+// run_before, indexed by zerosLeft-1, zerosLeft greater than 6 using the last
+// row, and run_before, from table 9-10.
+var (
+	runBeforeLens = [7][]uint8{
+		{1, 1},
+		{1, 2, 2},
+		{2, 2, 2, 2},
+		{2, 2, 2, 3, 3},
+		{2, 2, 3, 3, 3, 3},
+		{2, 3, 3, 3, 3, 3, 3},
+		{3, 3, 3, 3, 3, 3, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	}
+	runBeforeCodes = [7][]uint8{
+		{1, 0},
+		{1, 1, 0},
+		{3, 2, 1, 0},
+		{3, 2, 1, 1, 0},
+		{3, 2, 3, 2, 1, 0},
+		{3, 0, 1, 3, 2, 5, 4},
+		{7, 6, 5, 4, 3, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+	}
+)
+
+// runBeforeTables holds the vlcTable of run_before for each zerosLeft from 1,
+// the last being used for zerosLeft greater than 6.
+var runBeforeTables = newVLCTables(runBeforeLens[:], runBeforeCodes[:])
+
+// readRunBefore parses run_before of a block with zerosLeft, being at least
+// 1, zeros remaining before the coefficient, as specified by 9.2.3.
+func readRunBefore(br *bits.BitReader, zerosLeft int) (int, error) {
+	if zerosLeft < 1 {
+		return 0, fmt.Errorf("%w: %d", errBadZerosLeft, zerosLeft)
+	}
+	n := zerosLeft
+	if n > len(runBeforeTables) {
+		n = len(runBeforeTables)
+	}
+	v, err := readVLC(br, runBeforeTables[n-1])
+	if err != nil {
+		return 0, fmt.Errorf("could not read run_before: %w", err)
+	}
+	if v > zerosLeft {
+		return 0, fmt.Errorf("%w: %d with zerosLeft %d", errBadRunBefore, v, zerosLeft)
+	}
+	return v, nil
+}
+
+// readRuns parses the run_before of a block with the given TotalCoeff and
+// total_zeros, setting runVal[i], for i less than TotalCoeff, to the number
+// of zeros preceding the coefficient with index i in reverse scanning order,
+// as specified by 7.3.5.3.2 and 7.4.5.3.2. No run_before is parsed once no
+// zeros remain, nor for the last coefficient, which is preceded by the zeros
+// remaining.
+func readRuns(br *bits.BitReader, totalCoeff, totalZeros int, runVal []int) error {
+	zerosLeft := totalZeros
+	for i := 0; i < totalCoeff-1; i++ {
+		runVal[i] = 0
+		if zerosLeft > 0 {
+			v, err := readRunBefore(br, zerosLeft)
+			if err != nil {
+				return fmt.Errorf("could not read run_before of coefficient %d: %w", i, err)
+			}
+			runVal[i] = v
+		}
+		zerosLeft -= runVal[i]
+	}
+	if totalCoeff > 0 {
+		runVal[totalCoeff-1] = zerosLeft
+	}
+	return nil
+}
+
+// placeCoeffLevels sets the elements of coeffLevel, from startIdx, to the
+// levels levelVal of a block's coefficients, each preceded by the number of
+// zeros given by runVal, levelVal and runVal being in reverse scanning order
+// and of length TotalCoeff, as specified by 7.3.5.3.2. Elements not set are
+// those of zero coefficients, and coeffLevel is expected to be zeroed.
+func placeCoeffLevels(coeffLevel []int, startIdx int, levelVal, runVal []int) {
+	coeffNum := -1
+	for i := len(levelVal) - 1; i >= 0; i-- {
+		coeffNum += runVal[i] + 1
+		coeffLevel[startIdx+coeffNum] = levelVal[i]
+	}
+}
+
+// maxLevelPrefix is the greatest level_prefix permitted, being 11 plus the
+// greatest bit depth of 14, beyond which the bits are taken to be corrupt.
+const maxLevelPrefix = 25
+
+// readLevelPrefix parses level_prefix, being the number of leading zero bits
+// before a bit equal to 1, as specified by 9.2.2.1.
+func readLevelPrefix(br *bits.BitReader) (int, error) {
+	for n := 0; n <= maxLevelPrefix; n++ {
+		b, err := br.ReadBits(1)
+		if err != nil {
+			return 0, fmt.Errorf("could not read bit: %w", err)
+		}
+		if b == 1 {
+			return n, nil
+		}
+	}
+	return 0, errBadLevelPrefix
+}
+
+// readLevels parses the levels of the coefficients of a block with the given
+// TotalCoeff and TrailingOnes, setting levelVal[i], for i less than
+// TotalCoeff, to the level of the coefficient with index i in reverse
+// scanning order, as specified by 7.3.5.3.2 and 9.2.2. The first TrailingOnes
+// levels are given by trailing_ones_sign_flag, and the remainder by
+// level_prefix and level_suffix, whose length, suffixLength, adapts to the
+// magnitude of the levels parsed.
+func readLevels(br *bits.BitReader, totalCoeff, trailingOnes int, levelVal []int) error {
+	var suffixLength int
+	if totalCoeff > 10 && trailingOnes < 3 {
+		suffixLength = 1
+	}
+	for i := 0; i < totalCoeff; i++ {
+		if i < trailingOnes {
+			b, err := br.ReadBits(1)
+			if err != nil {
+				return fmt.Errorf("could not read trailing_ones_sign_flag of coefficient %d: %w", i, err)
+			}
+			levelVal[i] = 1 - 2*int(b)
+			continue
+		}
+
+		levelPrefix, err := readLevelPrefix(br)
+		if err != nil {
+			return fmt.Errorf("could not read level_prefix of coefficient %d: %w", i, err)
+		}
+
+		// Derive levelCode from level_prefix and level_suffix (9.2.2.1).
+		levelSuffixSize := suffixLength
+		switch {
+		case levelPrefix == 14 && suffixLength == 0:
+			levelSuffixSize = 4
+		case levelPrefix >= 15:
+			levelSuffixSize = levelPrefix - 3
+		}
+		levelCode := levelPrefix
+		if levelCode > 15 {
+			levelCode = 15
+		}
+		levelCode <<= uint(suffixLength)
+		if levelSuffixSize > 0 {
+			levelSuffix, err := br.ReadBits(levelSuffixSize)
+			if err != nil {
+				return fmt.Errorf("could not read level_suffix of coefficient %d: %w", i, err)
+			}
+			levelCode += int(levelSuffix)
+		}
+		if levelPrefix >= 15 && suffixLength == 0 {
+			levelCode += 15
+		}
+		if levelPrefix >= 16 {
+			levelCode += 1<<uint(levelPrefix-3) - 4096
+		}
+
+		// The first level after fewer than 3 trailing ones has magnitude
+		// greater than 1, so its levelCode is offset.
+		if i == trailingOnes && trailingOnes < 3 {
+			levelCode += 2
+		}
+
+		if levelCode%2 == 0 {
+			levelVal[i] = (levelCode + 2) >> 1
+		} else {
+			levelVal[i] = (-levelCode - 1) >> 1
+		}
+
+		if suffixLength == 0 {
+			suffixLength = 1
+		}
+		if abs(levelVal[i]) > 3<<uint(suffixLength-1) && suffixLength < 6 {
+			suffixLength++
+		}
+	}
+	return nil
+}
+
+// residualBlockCAVLC parses a residual block coded using CAVLC, as specified
+// by residual_block_cavlc of 7.3.5.3.2, setting the elements of coeffLevel,
+// of length at least maxNumCoeff, to the levels of the coefficients from
+// startIdx to endIdx, and the others to 0. The coeff_token table is selected
+// by nC, see coeffTokenNC. TotalCoeff is returned, to be stored in the
+// mbState for the derivation of nC of later blocks.
+//
+// For Intra16x16 macroblocks the DC block is parsed with startIdx and endIdx
+// and maxNumCoeff 16, and the AC blocks with Max(0, startIdx-1), endIdx-1 and
+// maxNumCoeff 15, as by residual_luma of 7.3.5.3.1. Chroma DC blocks have
+// maxNumCoeff 4*NumC8x8 and nC -1 or -2, selecting the tables for chroma DC.
+func residualBlockCAVLC(br *bits.BitReader, coeffLevel []int, startIdx, endIdx, maxNumCoeff, nC int) (int, error) {
+	if startIdx < 0 || startIdx > endIdx || endIdx >= maxNumCoeff || len(coeffLevel) < maxNumCoeff {
+		return 0, fmt.Errorf("%w: %d to %d of %d", errBadCoeffRange, startIdx, endIdx, maxNumCoeff)
+	}
+	for i := range coeffLevel[:maxNumCoeff] {
+		coeffLevel[i] = 0
+	}
+
+	totalCoeff, trailingOnes, err := readCoeffToken(br, nC)
+	if err != nil {
+		return 0, err
+	}
+	numCoeff := endIdx - startIdx + 1
+	if totalCoeff > numCoeff {
+		return 0, fmt.Errorf("%w: %d for %d coefficients", errBadTotalCoeff, totalCoeff, numCoeff)
+	}
+	if totalCoeff == 0 {
+		return 0, nil
+	}
+
+	var levelVal, runVal [16]int
+	err = readLevels(br, totalCoeff, trailingOnes, levelVal[:])
+	if err != nil {
+		return 0, err
+	}
+
+	var totalZeros int
+	if totalCoeff < numCoeff {
+		totalZeros, err = readTotalZeros(br, totalCoeff, maxNumCoeff)
+		if err != nil {
+			return 0, err
+		}
+		if totalZeros > numCoeff-totalCoeff {
+			return 0, fmt.Errorf("%w: %d with TotalCoeff %d for %d coefficients", errBadTotalZeros, totalZeros, totalCoeff, numCoeff)
+		}
+	}
+
+	err = readRuns(br, totalCoeff, totalZeros, runVal[:])
+	if err != nil {
+		return 0, err
+	}
+	placeCoeffLevels(coeffLevel, startIdx, levelVal[:totalCoeff], runVal[:totalCoeff])
+	return totalCoeff, nil
+}
+
+// coeffTokenNC returns nC for the coeff_token of the 4x4 block of colour
+// component comp, 0 to 2 for Y, Cb and Cr, whose top left sample is at (x, y)
+// relative to the macroblock with address currMbAddr, as specified by 9.2.1,
+// from the TotalCoeff of the blocks to its left and above. For luma blocks,
+// and Cb and Cr blocks when ChromaArrayType is 3, maxW and maxH are 16, and
+// for chroma AC blocks otherwise they are MbWidthC and MbHeightC. If
+// constrainedIntra, as when constrained_intra_pred_flag is 1 for slice data
+// partitions, blocks of inter macroblocks are not available to an intra
+// macroblock.
+//
+// The TotalCoeff stored for a block is expected to be nN as given by 9.2.1,
+// being 0 for blocks of skipped macroblocks and those whose coefficients are
+// 0 by the coded_block_pattern, and 16 for blocks of I_PCM macroblocks.
+func (s *mbState) coeffTokenNC(currMbAddr, comp, x, y, maxW, maxH int, constrainedIntra bool) int {
+	nN := func(blkN neighbourBlk) (int, bool) {
+		if !s.intraAvailable(blkN.mbAddr, currMbAddr, constrainedIntra) {
+			return 0, false
+		}
+		return int(s.totalCoeff[comp][blkN.mbAddr*blocksPerMb+blkN.blkIdx]), true
+	}
+
+	blkA, blkB := s.neighbour4x4Blks(currMbAddr, x, y, maxW, maxH)
+	nA, availableA := nN(blkA)
+	nB, availableB := nN(blkB)
+	switch {
+	case availableA && availableB:
+		return (nA + nB + 1) >> 1
+	case availableA:
+		return nA
+	case availableB:
+		return nB
+	default:
+		return 0
+	}
+}
+
+// Errors used in the parsing of CAVLC residual blocks.
+var (
+	errBadVLC         = errors.New("bits not a code of variable length code table")
+	errBadNC          = errors.New("nC not permitted")
+	errBadTotalCoeff  = errors.New("TotalCoeff outside range of total_zeros tables")
+	errBadTotalZeros  = errors.New("total_zeros exceeds uncoded coefficients of block")
+	errBadZerosLeft   = errors.New("run_before parsed with no zeros left")
+	errBadRunBefore   = errors.New("run_before exceeds zeros left")
+	errBadLevelPrefix = errors.New("level_prefix exceeds maximum permitted")
+	errBadCoeffRange  = errors.New("bad range of coefficients of residual block")
+)
@@ -0,0 +1,487 @@
+/*
+NAME
+  cavlc_test.go
+
+DESCRIPTION
+  cavlc_test.go provides testing for functionality provided in cavlc.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// checkVLCTable checks that no code of tab is a prefix of another and that
+// the longest code is of length maxLen, as a check of the transcription of
+// the table, a mis-transcribed code usually conflicting with another.
+func checkVLCTable(t *testing.T, name string, tab *vlcTable, maxLen int) {
+	if tab.maxLen != maxLen {
+		t.Errorf("did not get expected longest code of %s\nGot: %d\nWant: %d", name, tab.maxLen, maxLen)
+	}
+	for c := range tab.codes {
+		for d := range tab.codes {
+			if d.len < c.len && c.code>>uint(c.len-d.len) == d.code {
+				t.Errorf("code %0*b of %s is prefix of code %0*b", d.len, d.code, name, c.len, c.code)
+			}
+		}
+	}
+}
+
+func TestCoeffTokenTables(t *testing.T) {
+	// Number of codes in each table, being those of each TotalCoeff from 0
+	// to the greatest, with TrailingOnes up to 3 and at most TotalCoeff.
+	want := [numCoeffTokenTables]int{62, 62, 62, 62, 14, 30}
+	maxLen := [numCoeffTokenTables]int{16, 14, 10, 6, 8, 13}
+	for n, tab := range coeffTokenTables {
+		checkVLCTable(t, "coeff_token", tab, maxLen[n])
+		if len(tab.codes) != want[n] {
+			t.Errorf("did not get expected number of codes for table: %d\nGot: %d\nWant: %d", n, len(tab.codes), want[n])
+		}
+	}
+}
+
+func TestReadCoeffToken(t *testing.T) {
+	tests := []struct {
+		in           string
+		nC           int
+		totalCoeff   int
+		trailingOnes int
+		err          error
+	}{
+		{in: "1", nC: 0, totalCoeff: 0, trailingOnes: 0},
+		{in: "01", nC: 1, totalCoeff: 1, trailingOnes: 1},
+		{in: "001", nC: 0, totalCoeff: 2, trailingOnes: 2},
+		{in: "00011", nC: 0, totalCoeff: 3, trailingOnes: 3},
+		{in: "000101", nC: 0, totalCoeff: 1, trailingOnes: 0},
+		{in: "0000 0000 0000 0100", nC: 0, totalCoeff: 16, trailingOnes: 0},
+		{in: "0000 0000 0000 0000", nC: 0, err: errBadVLC},
+		{in: "11", nC: 2, totalCoeff: 0, trailingOnes: 0},
+		{in: "0000 0000 0000 1", nC: 3, totalCoeff: 15, trailingOnes: 3},
+		{in: "1111", nC: 4, totalCoeff: 0, trailingOnes: 0},
+		{in: "0000 0000 01", nC: 7, totalCoeff: 16, trailingOnes: 0},
+		{in: "0000 11", nC: 8, totalCoeff: 0, trailingOnes: 0},
+		{in: "0000 00", nC: 8, totalCoeff: 1, trailingOnes: 0},
+		{in: "1111 11", nC: 16, totalCoeff: 16, trailingOnes: 3},
+		{in: "0000 10", nC: 8, err: errBadVLC},
+		{in: "01", nC: -1, totalCoeff: 0, trailingOnes: 0},
+		{in: "1", nC: -1, totalCoeff: 1, trailingOnes: 1},
+		{in: "0000 000", nC: -1, totalCoeff: 4, trailingOnes: 3},
+		{in: "1", nC: -2, totalCoeff: 0, trailingOnes: 0},
+		{in: "0000 0000 0011 1", nC: -2, totalCoeff: 8, trailingOnes: 0},
+		{in: "1", nC: -3, err: errBadNC},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.in)))
+		totalCoeff, trailingOnes, err := readCoeffToken(br, test.nC)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if totalCoeff != test.totalCoeff || trailingOnes != test.trailingOnes {
+			t.Errorf("did not get expected result for test: %d\nGot: %d, %d\nWant: %d, %d", i, totalCoeff, trailingOnes, test.totalCoeff, test.trailingOnes)
+		}
+	}
+}
+
+func TestTotalZerosTables(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		tabs        []*vlcTable
+		maxNumCoeff int
+		maxLen      []int
+	}{
+		{"total_zeros", totalZerosTables, 16, []int{9, 6, 6, 5, 5, 6, 6, 6, 6, 5, 4, 4, 3, 2, 1}},
+		{"total_zeros chroma DC", totalZerosChromaDCTables, 4, []int{3, 2, 1}},
+		{"total_zeros chroma DC 4:2:2", totalZerosChromaDC422Tables, 8, []int{5, 3, 3, 3, 2, 2, 1}},
+	} {
+		if len(test.tabs) != test.maxNumCoeff-1 {
+			t.Errorf("did not get expected number of tables of %s\nGot: %d\nWant: %d", test.name, len(test.tabs), test.maxNumCoeff-1)
+			continue
+		}
+		for i, tab := range test.tabs {
+			checkVLCTable(t, test.name, tab, test.maxLen[i])
+
+			// There is a code for each total_zeros from 0 to the number of
+			// coefficients not coded.
+			totalCoeff := i + 1
+			if len(tab.codes) != test.maxNumCoeff-totalCoeff+1 {
+				t.Errorf("did not get expected number of codes of %s for tzVlcIndex: %d\nGot: %d\nWant: %d", test.name, totalCoeff, len(tab.codes), test.maxNumCoeff-totalCoeff+1)
+			}
+		}
+	}
+}
+
+func TestReadTotalZeros(t *testing.T) {
+	tests := []struct {
+		in          string
+		totalCoeff  int
+		maxNumCoeff int
+		want        int
+		err         error
+	}{
+		{in: "1", totalCoeff: 1, maxNumCoeff: 16, want: 0},
+		{in: "0000 0000 1", totalCoeff: 1, maxNumCoeff: 16, want: 15},
+		{in: "111", totalCoeff: 2, maxNumCoeff: 16, want: 0},
+		{in: "0000 01", totalCoeff: 6, maxNumCoeff: 16, want: 0},
+		{in: "1", totalCoeff: 15, maxNumCoeff: 16, want: 1},
+		{in: "01", totalCoeff: 14, maxNumCoeff: 15, want: 1},
+		{in: "1", totalCoeff: 14, maxNumCoeff: 15, err: errBadTotalZeros},
+		{in: "1", totalCoeff: 15, maxNumCoeff: 15, err: errBadTotalCoeff},
+		{in: "1", totalCoeff: 0, maxNumCoeff: 16, err: errBadTotalCoeff},
+		{in: "000", totalCoeff: 1, maxNumCoeff: 4, want: 3},
+		{in: "0", totalCoeff: 3, maxNumCoeff: 4, want: 1},
+		{in: "0000 0", totalCoeff: 1, maxNumCoeff: 8, want: 7},
+		{in: "110", totalCoeff: 4, maxNumCoeff: 8, want: 0},
+		{in: "1", totalCoeff: 8, maxNumCoeff: 8, err: errBadTotalCoeff},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.in)))
+		got, err := readTotalZeros(br, test.totalCoeff, test.maxNumCoeff)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if err == nil && got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestRunBeforeTables(t *testing.T) {
+	maxLen := []int{1, 2, 2, 3, 3, 3, 11}
+	for i, tab := range runBeforeTables {
+		checkVLCTable(t, "run_before", tab, maxLen[i])
+
+		// There is a code for each run_before up to zerosLeft, the last table
+		// having codes up to 14, the most zeros preceding a coefficient.
+		want := i + 2
+		if i == len(runBeforeTables)-1 {
+			want = 15
+		}
+		if len(tab.codes) != want {
+			t.Errorf("did not get expected number of codes of run_before for table: %d\nGot: %d\nWant: %d", i, len(tab.codes), want)
+		}
+	}
+}
+
+func TestReadRunBefore(t *testing.T) {
+	tests := []struct {
+		in        string
+		zerosLeft int
+		want      int
+		err       error
+	}{
+		{in: "1", zerosLeft: 1, want: 0},
+		{in: "0", zerosLeft: 1, want: 1},
+		{in: "00", zerosLeft: 2, want: 2},
+		{in: "011", zerosLeft: 5, want: 2},
+		{in: "000", zerosLeft: 6, want: 1},
+		{in: "101", zerosLeft: 6, want: 5},
+		{in: "111", zerosLeft: 7, want: 0},
+		{in: "0000 0000 001", zerosLeft: 14, want: 14},
+		{in: "0001", zerosLeft: 7, want: 7},
+		{in: "0000 1", zerosLeft: 7, err: errBadRunBefore},
+		{in: "1", zerosLeft: 0, err: errBadZerosLeft},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.in)))
+		got, err := readRunBefore(br, test.zerosLeft)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if err == nil && got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestReadRunsPlaceCoeffLevels(t *testing.T) {
+	tests := []struct {
+		in         string
+		totalZeros int
+		levelVal   []int // In reverse scanning order.
+		startIdx   int
+		want       []int
+	}{
+		// The block 0 3 -1 0, 0 -1 1 0, 1 0 0 0, 0 0 0 0, being
+		// 0 3 0 1 -1 -1 0 1 in zig-zag order.
+		{
+			in:         "10 1 1 01",
+			totalZeros: 3,
+			levelVal:   []int{1, -1, -1, 1, 3},
+			want:       []int{0, 3, 0, 1, -1, -1, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+
+		// No zeros remaining after the first run_before, so no more are parsed.
+		{
+			in:         "00",
+			totalZeros: 2,
+			levelVal:   []int{5, -2, 1},
+			want:       []int{1, -2, 0, 0, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+
+		// An AC block, from startIdx 1, of a single coefficient.
+		{
+			in:         "",
+			totalZeros: 4,
+			levelVal:   []int{-7},
+			startIdx:   1,
+			want:       []int{0, 0, 0, 0, 0, -7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.in)))
+		runVal := make([]int, len(test.levelVal))
+		err := readRuns(br, len(test.levelVal), test.totalZeros, runVal)
+		if err != nil {
+			t.Errorf("did not expect error: %v from readRuns for test: %d", err, i)
+			continue
+		}
+		got := make([]int, 16)
+		placeCoeffLevels(got, test.startIdx, test.levelVal, runVal)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestReadLevels(t *testing.T) {
+	tests := []struct {
+		in           string
+		trailingOnes int
+		want         []int // In reverse scanning order.
+		err          error
+	}{
+		// The levels of the block 0 3 -1 0, 0 -1 1 0, 1 0 0 0, 0 0 0 0,
+		// with suffixLength incremented after the first level not a
+		// trailing one.
+		{in: "011 1 001 0", trailingOnes: 3, want: []int{1, -1, -1, 1, 3}},
+
+		// The first level after fewer than 3 trailing ones is offset, and
+		// suffixLength incremented when a level exceeds 3.
+		{in: "01 00001 0 01 11", trailingOnes: 0, want: []int{-2, 5, -4}},
+
+		// Escapes for level_prefix of 14 and 15 with suffixLength 0.
+		{in: "0000 0000 0000 001 0010", trailingOnes: 0, want: []int{10}},
+		{in: "0000 0000 0000 0001 0000 0000 0110", trailingOnes: 0, want: []int{20}},
+
+		// level_prefix of 16, as permitted by High profiles.
+		{in: "0000 0000 0000 0000 1 0000 0010 0011 0", trailingOnes: 0, want: []int{2100}},
+
+		// suffixLength starts at 1 for more than 10 coefficients and fewer
+		// than 3 trailing ones.
+		{in: "00 10 10 10 10 10 10 10 10 10", trailingOnes: 2, want: []int{1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 1}},
+
+		{in: "0000 0000 0000 0000 0000 0000 0000 0001", trailingOnes: 0, want: []int{0}, err: errBadLevelPrefix},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.in)))
+		got := make([]int, len(test.want))
+		err := readLevels(br, len(test.want), test.trailingOnes, got)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestResidualBlockCAVLC(t *testing.T) {
+	tests := []struct {
+		in          string
+		startIdx    int
+		endIdx      int
+		maxNumCoeff int
+		nC          int
+		want        []int
+		totalCoeff  int
+		err         error
+	}{
+		// The block 0 3 -1 0, 0 -1 1 0, 1 0 0 0, 0 0 0 0 as coded by
+		// coeff_token, trailing_ones_sign_flag, two levels, total_zeros and
+		// run_before.
+		{
+			in:          "0000 100 011 1 0010 111 10 1 1 01",
+			endIdx:      15,
+			maxNumCoeff: 16,
+			want:        []int{0, 3, 0, 1, -1, -1, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0},
+			totalCoeff:  5,
+		},
+
+		// No coefficients.
+		{
+			in:          "1",
+			endIdx:      15,
+			maxNumCoeff: 16,
+			want:        make([]int, 16),
+		},
+
+		// An Intra16x16 AC block of a single coefficient at its end.
+		{
+			in:          "01 1 0000 0001 0",
+			endIdx:      14,
+			maxNumCoeff: 15,
+			want:        []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, -1},
+			totalCoeff:  1,
+		},
+
+		// All coefficients from startIdx to endIdx non-zero, so there is no
+		// total_zeros.
+		{
+			in:          "0000 11 000 1",
+			startIdx:    2,
+			endIdx:      5,
+			maxNumCoeff: 16,
+			want:        []int{0, 0, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			totalCoeff:  4,
+		},
+
+		// A chroma DC block of 4:2:0, with 2 coefficients and 1 zero.
+		{
+			in:          "001 00 01 0",
+			endIdx:      3,
+			maxNumCoeff: 4,
+			nC:          -1,
+			want:        []int{1, 0, 1, 0},
+			totalCoeff:  2,
+		},
+
+		// A chroma DC block of 4:2:2, with 1 coefficient of -1 at index 7.
+		{
+			in:          "01 1 0000 0",
+			endIdx:      7,
+			maxNumCoeff: 8,
+			nC:          -2,
+			want:        []int{0, 0, 0, 0, 0, 0, 0, -1},
+			totalCoeff:  1,
+		},
+
+		// total_zeros exceeding the coefficients from startIdx to endIdx.
+		{
+			in:          "01 1 0000 0001 0",
+			startIdx:    1,
+			endIdx:      14,
+			maxNumCoeff: 15,
+			err:         errBadTotalZeros,
+		},
+
+		// TotalCoeff exceeding the coefficients from startIdx to endIdx.
+		{
+			in:          "0000 11 000 1",
+			startIdx:    0,
+			endIdx:      2,
+			maxNumCoeff: 16,
+			err:         errBadTotalCoeff,
+		},
+		{
+			in:          "1",
+			startIdx:    4,
+			endIdx:      3,
+			maxNumCoeff: 16,
+			err:         errBadCoeffRange,
+		},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.in)))
+
+		// Levels are set to 0 where not coded.
+		got := make([]int, test.maxNumCoeff)
+		for j := range got {
+			got[j] = 99
+		}
+		totalCoeff, err := residualBlockCAVLC(br, got, test.startIdx, test.endIdx, test.maxNumCoeff, test.nC)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if totalCoeff != test.totalCoeff {
+			t.Errorf("did not get expected TotalCoeff for test: %d\nGot: %d\nWant: %d", i, totalCoeff, test.totalCoeff)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestCoeffTokenNC(t *testing.T) {
+	tests := []struct {
+		mbAddrs          []int
+		flags            func(mbAddr int) mbFlags
+		comp, x, y       int
+		maxW, maxH       int
+		constrainedIntra bool
+		want             int
+	}{
+		// Both neighbours in other macroblocks, luma blocks A 5 and B 10 of
+		// TotalCoeff 3 and 6.
+		{mbAddrs: []int{ctxIncMbB, ctxIncMbA}, flags: noFlags, maxW: 16, maxH: 16, want: 5},
+
+		// No neighbours available.
+		{flags: noFlags, maxW: 16, maxH: 16, want: 0},
+
+		// Only B available.
+		{mbAddrs: []int{ctxIncMbB}, flags: noFlags, maxW: 16, maxH: 16, want: 6},
+
+		// Both neighbours within the current macroblock, of block 3.
+		{flags: noFlags, x: 4, y: 4, maxW: 16, maxH: 16, want: 2},
+
+		// Chroma AC block 2 of 4:2:0, A being block 3 of macroblock A and B
+		// block 0 of the current macroblock.
+		{mbAddrs: []int{ctxIncMbA}, flags: noFlags, comp: 1, y: 4, maxW: 8, maxH: 8, want: 4},
+
+		// Inter neighbours not available to an intra macroblock when
+		// constrainedIntra.
+		{
+			mbAddrs: []int{ctxIncMbB, ctxIncMbA},
+			flags: func(mbAddr int) mbFlags {
+				if mbAddr == ctxIncMbCurr || mbAddr == ctxIncMbA {
+					return mbIntraCoded
+				}
+				return 0
+			},
+			maxW:             16,
+			maxH:             16,
+			constrainedIntra: true,
+			want:             3,
+		},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(test.flags, test.mbAddrs...)
+		mbs.totalCoeff[0][ctxIncMbA*blocksPerMb+5] = 3
+		mbs.totalCoeff[0][ctxIncMbB*blocksPerMb+10] = 6
+		mbs.totalCoeff[0][ctxIncMbCurr*blocksPerMb+1] = 1
+		mbs.totalCoeff[0][ctxIncMbCurr*blocksPerMb+2] = 2
+		mbs.totalCoeff[1][ctxIncMbA*blocksPerMb+3] = 7
+		mbs.totalCoeff[1][ctxIncMbCurr*blocksPerMb+0] = 1
+
+		got := mbs.coeffTokenNC(ctxIncMbCurr, test.comp, test.x, test.y, test.maxW, test.maxH, test.constrainedIntra)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+/*
+NAME
+  cavlcvectors_test.go
+
+DESCRIPTION
+  cavlcvectors_test.go provides a harness running the parsing of CAVLC
+  residual blocks against vectors of input bits and the coefficient levels
+  they give, as assembled by hand from the tables of section 9.2 of the
+  specifications, and against blocks coded by an encoder implementing the
+  inverse of its processes, so that errors in the transcription of the
+  tables are caught by a single wrong level rather than a corrupt slice.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// cavlcVector is a CAVLC residual block test vector, being the bits of a
+// block, given as binary digits, parsed with the given startIdx, endIdx,
+// maxNumCoeff and nC, and the coefficient levels they give.
+type cavlcVector struct {
+	name        string
+	in          string
+	startIdx    int
+	endIdx      int
+	maxNumCoeff int
+	nC          int
+	want        []int
+}
+
+var cavlcVectors = []cavlcVector{
+	{
+		// The 4x4 block 0 3 -1 0, 0 -1 1 0, 1 0 0 0, 0 0 0 0, in zig-zag order
+		// 0 3 0 1 -1 -1 0 1, of TotalCoeff 5 and TrailingOnes 3.
+		//
+		// coeff_token 0000100 (table 9-5, 0 <= nC < 2). Signs of the trailing
+		// ones 1, -1 and -1 in reverse order: 0, 1, 1. Level 1, the first
+		// after 3 trailing ones so not offset, levelCode 0 with suffixLength
+		// 0: level_prefix 0, 1. suffixLength becomes 1. Level 3, levelCode 4:
+		// level_prefix 2 and level_suffix 0, 001 0. total_zeros 3 with
+		// TotalCoeff 5 (table 9-7): 111. run_before with zerosLeft 3, 2, 2 and
+		// 2 (table 9-10): 10, 1, 1, 01, leaving 1 zero before the last
+		// coefficient.
+		name:        "zig-zag block with 3 trailing ones",
+		in:          "0000100 011 1 0010 111 10 1 1 01",
+		endIdx:      15,
+		maxNumCoeff: 16,
+		want:        []int{0, 3, 0, 1, -1, -1, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0},
+	},
+	{
+		// The 4x4 block -2 4 0 -1, 3 0 0 0, -3 0 0 0, 0 0 0 0, in zig-zag
+		// order -2 4 3 -3 0 0 -1, of TotalCoeff 5 and TrailingOnes 1.
+		//
+		// coeff_token 0000000110. Sign of the trailing one -1: 1. Level -3,
+		// levelCode 5 less 2 as the first after fewer than 3 trailing ones,
+		// with suffixLength 0: level_prefix 3, 0001. suffixLength becomes 1.
+		// Level 3, levelCode 4: 001 0. Level 4, levelCode 6: 0001 0, and as 4
+		// exceeds 3 suffixLength becomes 2. Level -2, levelCode 3: level_prefix
+		// 0 and level_suffix 3, 1 11. total_zeros 2 with TotalCoeff 5: 0011.
+		// run_before 2 with zerosLeft 2: 00, leaving no zeros.
+		name:        "zig-zag block with suffixLength increments",
+		in:          "0000000110 1 0001 0010 00010 111 0011 00",
+		endIdx:      15,
+		maxNumCoeff: 16,
+		want:        []int{-2, 4, 3, -3, 0, 0, -1, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	},
+	{
+		// The 4x4 block 0 0 1 0, 0 0 0 0, 1 0 0 0, -1 0 0 0, in zig-zag order
+		// 0 0 0 1 0 1 0 0 0 -1, of TotalCoeff 3 and TrailingOnes 3.
+		//
+		// coeff_token 00011. Signs -1, 1 and 1: 1, 0, 0. total_zeros 7 with
+		// TotalCoeff 3 (table 9-7): 011. run_before 3 with zerosLeft 7 (table
+		// 9-10, zerosLeft > 6): 100, and 1 with zerosLeft 4: 10, leaving 3
+		// zeros before the last coefficient.
+		name:        "zig-zag block of trailing ones only",
+		in:          "00011 100 011 100 10",
+		endIdx:      15,
+		maxNumCoeff: 16,
+		want:        []int{0, 0, 0, 1, 0, 1, 0, 0, 0, -1, 0, 0, 0, 0, 0, 0},
+	},
+	{
+		// An Intra16x16 AC block of coefficients 0 to 14, with -20 at index 0
+		// and 1 at index 2, TotalCoeff 2 and TrailingOnes 1, with nC 3.
+		//
+		// coeff_token 00111 (table 9-5, 2 <= nC < 4). Sign of the trailing
+		// one: 0. Level -20, levelCode 39 less 2, 37, with suffixLength 0:
+		// escaped with level_prefix 15 and a 12 bit level_suffix of
+		// 37 - 15 - 15 = 7, 0000 0000 0000 0001 0000 0000 0111. total_zeros 1
+		// with TotalCoeff 2 (table 9-7): 110. run_before 1 with zerosLeft 1: 0.
+		name:        "escaped level in AC block",
+		in:          "00111 0 0000 0000 0000 0001 0000 0000 0111 110 0",
+		endIdx:      14,
+		maxNumCoeff: 15,
+		nC:          3,
+		want:        []int{-20, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	},
+	{
+		// The chroma DC block of 4:2:0 of 3 0, 0 -1, TotalCoeff 2 and
+		// TrailingOnes 1, with nC -1.
+		//
+		// coeff_token 000110 (table 9-5, nC -1). Sign of the trailing one -1:
+		// 1. Level 3, levelCode 4 less 2 with suffixLength 0: level_prefix 2,
+		// 001. total_zeros 2 with TotalCoeff 2 (table 9-9a): 00. run_before 2
+		// with zerosLeft 2: 00, leaving no zeros.
+		name:        "chroma DC block of 4:2:0",
+		in:          "000110 1 001 00 00",
+		endIdx:      3,
+		maxNumCoeff: 4,
+		nC:          -1,
+		want:        []int{3, 0, 0, -1},
+	},
+}
+
+func TestCAVLCVectors(t *testing.T) {
+	for _, v := range cavlcVectors {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(v.in)))
+		got := make([]int, v.maxNumCoeff)
+		_, err := residualBlockCAVLC(br, got, v.startIdx, v.endIdx, v.maxNumCoeff, v.nC)
+		if err != nil {
+			t.Errorf("did not expect error: %v for vector: %s", err, v.name)
+			continue
+		}
+		if !reflect.DeepEqual(got, v.want) {
+			t.Errorf("did not get expected levels for vector: %s\nGot: %v\nWant: %v", v.name, got, v.want)
+		}
+		if want := len(strings.Replace(v.in, " ", "", -1)); br.Off() != want {
+			t.Errorf("did not get expected bits read for vector: %s\nGot: %d\nWant: %d", v.name, br.Off(), want)
+		}
+	}
+}
+
+// TestCAVLCRoundTrip checks the parsing of random blocks coded by
+// encodeCAVLC, with levels requiring each form of level_prefix and
+// level_suffix, for each table of coeff_token.
+func TestCAVLCRoundTrip(t *testing.T) {
+	tests := []struct {
+		startIdx, endIdx, maxNumCoeff int
+		nCs                           []int
+	}{
+		{0, 15, 16, []int{0, 1, 2, 3, 4, 7, 8, 16}},
+		{0, 14, 15, []int{0, 2, 5, 9}},
+		{3, 9, 16, []int{0, 4}},
+		{0, 3, 4, []int{-1}},
+		{0, 7, 8, []int{-2}},
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	for i, test := range tests {
+		for _, nC := range test.nCs {
+			for n := 0; n < 200; n++ {
+				want := make([]int, test.maxNumCoeff)
+				density := 1 + rng.Intn(4)
+				for j := test.startIdx; j <= test.endIdx; j++ {
+					if rng.Intn(density) != 0 {
+						continue
+					}
+					// Mostly trailing ones and small levels.
+					switch rng.Intn(8) {
+					case 0:
+						want[j] = 1 + rng.Intn(5000)
+					case 1, 2:
+						want[j] = 1 + rng.Intn(20)
+					default:
+						want[j] = 1
+					}
+					if rng.Intn(2) == 0 {
+						want[j] = -want[j]
+					}
+				}
+
+				in := encodeCAVLC(t, want, test.startIdx, test.endIdx, test.maxNumCoeff, nC)
+				br := bits.NewBitReader(bytes.NewReader(binToSlice(in)))
+				got := make([]int, test.maxNumCoeff)
+				_, err := residualBlockCAVLC(br, got, test.startIdx, test.endIdx, test.maxNumCoeff, nC)
+				if err != nil {
+					t.Fatalf("did not expect error: %v for test: %d, nC: %d, block: %v", err, i, nC, want)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("did not get expected levels for test: %d, nC: %d\nGot: %v\nWant: %v", i, nC, got, want)
+				}
+				if br.Off() != len(in) {
+					t.Fatalf("did not get expected bits read for test: %d, nC: %d\nGot: %d\nWant: %d", i, nC, br.Off(), len(in))
+				}
+			}
+		}
+	}
+}
+
+// encodeCAVLC returns the bits, as binary digits, of the residual block with
+// levels coeffLevel from startIdx to endIdx coded using CAVLC, as by the
+// inverse of the processes of section 9.2.
+func encodeCAVLC(t *testing.T, coeffLevel []int, startIdx, endIdx, maxNumCoeff, nC int) string {
+	// Levels and the zeros preceding each, in reverse scanning order.
+	var levelVal, runVal []int
+	run := 0
+	for i := startIdx; i <= endIdx; i++ {
+		if coeffLevel[i] == 0 {
+			run++
+			continue
+		}
+		levelVal = append([]int{coeffLevel[i]}, levelVal...)
+		runVal = append([]int{run}, runVal...)
+		run = 0
+	}
+	totalCoeff := len(levelVal)
+	trailingOnes := 0
+	for trailingOnes < totalCoeff && trailingOnes < 3 && abs(levelVal[trailingOnes]) == 1 {
+		trailingOnes++
+	}
+
+	var b strings.Builder
+	n, err := coeffTokenTable(nC)
+	if err != nil {
+		t.Fatalf("could not get coeff_token table: %v", err)
+	}
+	b.WriteString(vlcBits(t, coeffTokenTables[n], totalCoeff<<2|trailingOnes))
+	if totalCoeff == 0 {
+		return b.String()
+	}
+
+	suffixLength := 0
+	if totalCoeff > 10 && trailingOnes < 3 {
+		suffixLength = 1
+	}
+	for i, level := range levelVal {
+		if i < trailingOnes {
+			b.WriteString(fmt.Sprint(flagVal(level < 0)))
+			continue
+		}
+		levelCode := 2*level - 2
+		if level < 0 {
+			levelCode = -2*level - 1
+		}
+		if i == trailingOnes && trailingOnes < 3 {
+			levelCode -= 2
+		}
+		b.WriteString(levelBits(levelCode, suffixLength))
+		if suffixLength == 0 {
+			suffixLength = 1
+		}
+		if abs(level) > 3<<uint(suffixLength-1) && suffixLength < 6 {
+			suffixLength++
+		}
+	}
+
+	totalZeros := 0
+	for _, r := range runVal {
+		totalZeros += r
+	}
+	if totalCoeff < endIdx-startIdx+1 {
+		tabs := totalZerosTables
+		switch maxNumCoeff {
+		case 4:
+			tabs = totalZerosChromaDCTables
+		case 8:
+			tabs = totalZerosChromaDC422Tables
+		}
+		b.WriteString(vlcBits(t, tabs[totalCoeff-1], totalZeros))
+	}
+	zerosLeft := totalZeros
+	for _, r := range runVal[:totalCoeff-1] {
+		if zerosLeft == 0 {
+			break
+		}
+		tab := runBeforeTables[len(runBeforeTables)-1]
+		if zerosLeft <= len(runBeforeTables) {
+			tab = runBeforeTables[zerosLeft-1]
+		}
+		b.WriteString(vlcBits(t, tab, r))
+		zerosLeft -= r
+	}
+	return b.String()
+}
+
+// levelBits returns the level_prefix and level_suffix, as binary digits,
+// coding levelCode with the given suffixLength, as the inverse of 9.2.2.1.
+func levelBits(levelCode, suffixLength int) string {
+	prefix := func(n int) string { return strings.Repeat("0", n) + "1" }
+	suffix := func(v, n int) string {
+		if n == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%0*b", n, v)
+	}
+
+	switch {
+	case suffixLength == 0 && levelCode < 14:
+		return prefix(levelCode)
+	case suffixLength == 0 && levelCode < 30:
+		return prefix(14) + suffix(levelCode-14, 4)
+	case suffixLength > 0 && levelCode>>uint(suffixLength) < 15:
+		return prefix(levelCode>>uint(suffixLength)) + suffix(levelCode&(1<<uint(suffixLength)-1), suffixLength)
+	}
+
+	// Escaped with level_prefix of 15 or more, whose level_suffix is of
+	// level_prefix - 3 bits.
+	base := 15 << uint(suffixLength)
+	if suffixLength == 0 {
+		base += 15
+	}
+	for levelPrefix := 15; ; levelPrefix++ {
+		off := 0
+		if levelPrefix >= 16 {
+			off = 1<<uint(levelPrefix-3) - 4096
+		}
+		if levelCode-base-off < 1<<uint(levelPrefix-3) {
+			return prefix(levelPrefix) + suffix(levelCode-base-off, levelPrefix-3)
+		}
+	}
+}
+
+// vlcBits returns the code of tab with value v, as binary digits.
+func vlcBits(t *testing.T, tab *vlcTable, v int) string {
+	for c, cv := range tab.codes {
+		if cv == v {
+			return fmt.Sprintf("%0*b", c.len, c.code)
+		}
+	}
+	t.Fatalf("no code for value: %d", v)
+	return ""
+}
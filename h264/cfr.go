@@ -0,0 +1,168 @@
+/*
+NAME
+  cfr.go
+
+DESCRIPTION
+  cfr.go provides an output adaptor producing frames at a constant frame
+  rate, for sinks such as RTMP uplinks that require one, by duplicating
+  frames when the stream falls behind and dropping frames when it runs ahead.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"image"
+	"time"
+)
+
+// defaultCFRMaxGap is the default difference between the presentation time of
+// a frame and the next output time beyond which the stream is taken to be
+// discontinuous, see CFRAdaptor.
+const defaultCFRMaxGap = 5 * time.Second
+
+// TimedFrame is a decoded frame with its presentation time.
+type TimedFrame struct {
+	Image *image.YCbCr
+	PTS   time.Duration // Presentation time, from an arbitrary origin.
+	Flags FrameFlags
+}
+
+// CFRAdaptor passes frames to a sink at a constant frame rate. Output times
+// are spaced at the frame interval from the presentation time of the first
+// frame, and each frame is output at the output time nearest its presentation
+// time. If frames fall behind, i.e. there are output times with no frame
+// nearest, the last frame is output again with FrameDuplicate set, and if
+// frames run ahead, i.e. more than one frame is nearest an output time, all
+// but the first are dropped. Frames passed to the sink have PTS set to their
+// output time, and duplicates share the Image of the frame they repeat, so
+// sinks must not modify images.
+//
+// A frame with a presentation time differing from the next output time by
+// more than the maximum gap, 5 seconds unless set using SetMaxGap, is taken to
+// be a discontinuity, such as a restart of the source, and output times
+// continue from it without duplicating or dropping frames.
+type CFRAdaptor struct {
+	num, den uint32 // Frame rate in frames per second, as the fraction num/den.
+	sink     func(TimedFrame) error
+	maxGap   time.Duration
+
+	started bool
+	start   time.Duration // Output time of the first frame since any discontinuity.
+	next    int64         // Index of the next output time from start.
+	last    TimedFrame    // Last frame output, repeated when frames fall behind.
+
+	dropped    int
+	duplicated int
+}
+
+// NewCFRAdaptor returns a CFRAdaptor passing frames to sink at the frame rate
+// num/den frames per second.
+func NewCFRAdaptor(num, den uint32, sink func(TimedFrame) error) (*CFRAdaptor, error) {
+	if num == 0 || den == 0 {
+		return nil, errBadFrameRate
+	}
+	if sink == nil {
+		return nil, errNoSink
+	}
+	return &CFRAdaptor{num: num, den: den, sink: sink, maxGap: defaultCFRMaxGap}, nil
+}
+
+// NewCFRAdaptorForSPS returns a CFRAdaptor passing frames to sink at the frame
+// rate of the coded video sequence using sps, as given by the VUI timing
+// information, see SPS.FrameRate. An error is returned if the SPS does not
+// give a fixed frame rate.
+func NewCFRAdaptorForSPS(sps *SPS, sink func(TimedFrame) error) (*CFRAdaptor, error) {
+	num, den, ok := sps.FrameRate()
+	if !ok {
+		return nil, errNoFrameRate
+	}
+	return NewCFRAdaptor(num, den, sink)
+}
+
+// SetMaxGap sets the difference between the presentation time of a frame and
+// the next output time beyond which the stream is taken to be discontinuous.
+func (a *CFRAdaptor) SetMaxGap(d time.Duration) {
+	a.maxGap = d
+}
+
+// Write adds the frame, passing it and any duplicates required before it to
+// the sink, or dropping it. Frames must be written in presentation order. An
+// error from the sink is returned, in which case the frame and any remaining
+// duplicates are not output.
+func (a *CFRAdaptor) Write(f TimedFrame) error {
+	if !a.started || f.PTS-a.outputTime(a.next) > a.maxGap || a.outputTime(a.next)-f.PTS > a.maxGap {
+		a.started = true
+		a.start = f.PTS
+		a.next = 0
+		return a.output(f, false)
+	}
+
+	// A frame nearest an output time that has been filled runs ahead.
+	if f.PTS < a.boundary(a.next) {
+		a.dropped++
+		return nil
+	}
+
+	// Repeat the last frame for output times at which it falls behind.
+	for f.PTS >= a.boundary(a.next+1) {
+		err := a.output(a.last, true)
+		if err != nil {
+			return err
+		}
+	}
+	return a.output(f, false)
+}
+
+// Dropped returns the number of frames dropped.
+func (a *CFRAdaptor) Dropped() int {
+	return a.dropped
+}
+
+// Duplicated returns the number of duplicate frames output.
+func (a *CFRAdaptor) Duplicated() int {
+	return a.duplicated
+}
+
+// output passes the frame to the sink at the next output time.
+func (a *CFRAdaptor) output(f TimedFrame, dup bool) error {
+	f.PTS = a.outputTime(a.next)
+	if dup {
+		f.Flags |= FrameDuplicate
+	}
+	err := a.sink(f)
+	if err != nil {
+		return err
+	}
+	if dup {
+		a.duplicated++
+	} else {
+		a.last = f
+	}
+	a.next++
+	return nil
+}
+
+// outputTime returns the kth output time from the start, computed exactly
+// as start + k*den/num seconds, so that rounding errors do not accumulate.
+func (a *CFRAdaptor) outputTime(k int64) time.Duration {
+	n := k * int64(a.den)
+	num := int64(a.num)
+	return a.start + time.Duration(n/num*int64(time.Second)+n%num*int64(time.Second)/num)
+}
+
+// boundary returns the time from which frames are nearest the kth output time
+// rather than the one before it.
+func (a *CFRAdaptor) boundary(k int64) time.Duration {
+	prev := a.outputTime(k - 1)
+	return prev + (a.outputTime(k)-prev+1)/2
+}
+
+var (
+	errBadFrameRate = errors.New("frame rate must be positive")
+	errNoSink       = errors.New("no sink for frames")
+	errNoFrameRate  = errors.New("SPS does not give a fixed frame rate")
+)
@@ -0,0 +1,155 @@
+/*
+NAME
+  cfr_test.go
+
+DESCRIPTION
+  cfr_test.go provides testing for functionality provided in cfr.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"image"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// cfrFrame is a frame output by a CFRAdaptor, identified by the index of the
+// frame written.
+type cfrFrame struct {
+	index int
+	pts   time.Duration
+	dup   bool
+}
+
+func TestCFRAdaptor(t *testing.T) {
+	const ms = time.Millisecond
+	tests := []struct {
+		num, den       uint32
+		pts            []time.Duration
+		want           []cfrFrame
+		wantDropped    int
+		wantDuplicated int
+	}{
+		// Steady 25 fps with jitter.
+		{
+			num: 25, den: 1,
+			pts: []time.Duration{1000 * ms, 1041 * ms, 1078 * ms, 1121 * ms},
+			want: []cfrFrame{
+				{0, 1000 * ms, false}, {1, 1040 * ms, false}, {2, 1080 * ms, false}, {3, 1120 * ms, false},
+			},
+		},
+		// Underrun, with a frame missing and one late.
+		{
+			num: 25, den: 1,
+			pts: []time.Duration{0, 80 * ms, 170 * ms},
+			want: []cfrFrame{
+				{0, 0, false}, {0, 40 * ms, true}, {1, 80 * ms, false}, {1, 120 * ms, true}, {2, 160 * ms, false},
+			},
+			wantDuplicated: 2,
+		},
+		// Overrun, with frames at 50 fps.
+		{
+			num: 25, den: 1,
+			pts: []time.Duration{0, 19 * ms, 39 * ms, 59 * ms, 79 * ms},
+			want: []cfrFrame{
+				{0, 0, false}, {2, 40 * ms, false}, {4, 80 * ms, false},
+			},
+			wantDropped: 2,
+		},
+		// Discontinuity restarts output times.
+		{
+			num: 25, den: 1,
+			pts: []time.Duration{0, 40 * ms, 10 * time.Second, 10*time.Second + 40*ms},
+			want: []cfrFrame{
+				{0, 0, false}, {1, 40 * ms, false}, {2, 10 * time.Second, false}, {3, 10*time.Second + 40*ms, false},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		var got []cfrFrame
+		imgs := map[*image.YCbCr]int{}
+		a, err := NewCFRAdaptor(test.num, test.den, func(f TimedFrame) error {
+			got = append(got, cfrFrame{imgs[f.Image], f.PTS, f.Flags.Has(FrameDuplicate)})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewCFRAdaptor for test: %d", err, i)
+		}
+		a.SetMaxGap(time.Second)
+		for j, pts := range test.pts {
+			img := &image.YCbCr{}
+			imgs[img] = j
+			err = a.Write(TimedFrame{Image: img, PTS: pts})
+			if err != nil {
+				t.Fatalf("did not expect error: %v from Write for test: %d", err, i)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+		if a.Dropped() != test.wantDropped || a.Duplicated() != test.wantDuplicated {
+			t.Errorf("did not get expected counts for test: %d\nGot: %d dropped, %d duplicated\nWant: %d dropped, %d duplicated",
+				i, a.Dropped(), a.Duplicated(), test.wantDropped, test.wantDuplicated)
+		}
+	}
+}
+
+// TestCFRAdaptorNTSC checks that output times at 30000/1001 fps, given frames
+// with presentation times rounded to milliseconds, do not accumulate rounding
+// errors.
+func TestCFRAdaptorNTSC(t *testing.T) {
+	var last time.Duration
+	a, err := NewCFRAdaptor(30000, 1001, func(f TimedFrame) error {
+		last = f.PTS
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewCFRAdaptor", err)
+	}
+	for k := 0; k <= 3000; k++ {
+		pts := time.Duration(k) * 1001 * time.Second / 30000
+		err = a.Write(TimedFrame{PTS: pts.Round(time.Millisecond)})
+		if err != nil {
+			t.Fatalf("did not expect error: %v from Write", err)
+		}
+	}
+	const want = 100100 * time.Millisecond
+	if last != want || a.Dropped() != 0 || a.Duplicated() != 0 {
+		t.Errorf("did not get expected result\nGot: %v, %d dropped, %d duplicated\nWant: %v, none dropped or duplicated", last, a.Dropped(), a.Duplicated(), want)
+	}
+}
+
+func TestNewCFRAdaptorForSPS(t *testing.T) {
+	sink := func(TimedFrame) error { return nil }
+	sps := &SPS{VUI: &VUIParameters{TimingInfoPresent: true, FixedFrameRate: true, NumUnitsInTick: 1001, TimeScale: 60000}}
+	a, err := NewCFRAdaptorForSPS(sps, sink)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewCFRAdaptorForSPS", err)
+	}
+	if a.num != 30000 || a.den != 1001 {
+		t.Errorf("did not get expected frame rate\nGot: %d/%d\nWant: 30000/1001", a.num, a.den)
+	}
+
+	_, err = NewCFRAdaptorForSPS(&SPS{}, sink)
+	if err != errNoFrameRate {
+		t.Errorf("did not get expected error\nGot: %v\nWant: %v", err, errNoFrameRate)
+	}
+	_, err = NewCFRAdaptor(0, 1, sink)
+	if err != errBadFrameRate {
+		t.Errorf("did not get expected error\nGot: %v\nWant: %v", err, errBadFrameRate)
+	}
+
+	errSink := errors.New("sink error")
+	a, _ = NewCFRAdaptor(25, 1, func(TimedFrame) error { return errSink })
+	err = a.Write(TimedFrame{})
+	if err != errSink {
+		t.Errorf("did not get expected error\nGot: %v\nWant: %v", err, errSink)
+	}
+}
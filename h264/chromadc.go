@@ -0,0 +1,157 @@
+/*
+NAME
+  chromadc.go
+
+DESCRIPTION
+  chromadc.go provides the parsing of the chroma DC residual blocks of
+  macroblocks of 4:2:0 and 4:2:2 video, being of 2x2 and 2x4 coefficients,
+  using either CAVLC or CABAC, their arrangement for the chroma DC transform
+  and the 4x4 chroma blocks, and the chroma DC transform and scaling, as
+  specified in sections 7.3.5.3 and 8.5.11 of the specifications.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// maxChromaDCCoeff is the greatest number of coefficients of a chroma DC
+// block, being those of 4:2:2.
+const maxChromaDCCoeff = 8
+
+// numC8x8 returns NumC8x8, the number of 8x8 chroma blocks of each chroma
+// component of a macroblock, being 4 / (SubWidthC * SubHeightC), for the given
+// ChromaArrayType, which must be 1 or 2, chroma DC blocks having 4*NumC8x8
+// coefficients.
+func numC8x8(chromaArrayType int) (int, error) {
+	switch chromaArrayType {
+	case chroma420:
+		return 1, nil
+	case chroma422:
+		return 2, nil
+	default:
+		return 0, fmt.Errorf("%w: %d", errNoChromaDC, chromaArrayType)
+	}
+}
+
+// readChromaDCCAVLC parses the chroma DC block of a chroma component of a
+// macroblock coded using CAVLC, for the given ChromaArrayType, setting the
+// first 4*NumC8x8 elements of coeffLevel to its levels in the order of
+// chromaDCMatrix, as by residual_chroma of 7.3.5.3. nC is -1 for 4:2:0 and
+// -2 for 4:2:2, selecting the tables of coeff_token and total_zeros for
+// chroma DC. TotalCoeff is returned.
+func readChromaDCCAVLC(br *bits.BitReader, chromaArrayType int, coeffLevel []int) (int, error) {
+	n, err := numC8x8(chromaArrayType)
+	if err != nil {
+		return 0, err
+	}
+	return residualBlockCAVLC(br, coeffLevel, 0, 4*n-1, 4*n, -n)
+}
+
+// decodeChromaDC decodes the coded_block_flag and, if it is 1, the levels of
+// the chroma DC block of the Cb, for iCbCr 0, or Cr component of the
+// macroblock with address currMbAddr, coded using CABAC, for the given
+// ChromaArrayType, setting the first 4*NumC8x8 elements of coeffLevel to its
+// levels in the order of chromaDCMatrix, as by residual_chroma of 7.3.5.3.
+// The coded_block_flag is recorded in mbs for the derivation of ctxIdxInc of
+// later macroblocks, see chromaDCCodedBlockFlagCtxIdxInc. field is as for
+// decodeResidualBlock, and constrainedIntra as for coeffTokenNC. The number of
+// non-zero coefficients is returned.
+func (d *cabacDecoder) decodeChromaDC(mbs *mbState, currMbAddr, iCbCr, chromaArrayType int, field, constrainedIntra bool, coeffLevel []int) (int, error) {
+	n, err := numC8x8(chromaArrayType)
+	if err != nil {
+		return 0, err
+	}
+	coeffLevel = coeffLevel[:4*n]
+	inc := chromaDCCodedBlockFlagCtxIdxInc(mbs, currMbAddr, iCbCr, constrainedIntra)
+	cbf, err := d.decodeCodedBlockFlag(ctxBlockCatChromaDC, inc)
+	if err != nil {
+		return 0, fmt.Errorf("could not decode coded_block_flag: %w", err)
+	}
+	if !cbf {
+		for i := range coeffLevel {
+			coeffLevel[i] = 0
+		}
+		return 0, nil
+	}
+	mbs.flags[currMbAddr] |= mbCbfCbDC << uint(iCbCr)
+	return d.decodeResidualBlock(ctxBlockCatChromaDC, field, n, 0, 4*n-1, coeffLevel)
+}
+
+// chromaDCMatrix returns the matrix c of the levels of a chroma DC block, as
+// parsed in coeffLevel, for input to the chroma DC transform, as specified
+// by 8.5.11.1. For 4:2:0 c is 2x2, filling the first 2 rows, the levels being
+// in raster scan order, and for 4:2:2 c is 4x2, the levels being in the order
+// of equation 8-330.
+func chromaDCMatrix(chromaArrayType int, coeffLevel []int) [4][2]int {
+	if chromaArrayType == chroma422 {
+		return [4][2]int{
+			{coeffLevel[0], coeffLevel[2]},
+			{coeffLevel[1], coeffLevel[5]},
+			{coeffLevel[3], coeffLevel[6]},
+			{coeffLevel[4], coeffLevel[7]},
+		}
+	}
+	return [4][2]int{
+		{coeffLevel[0], coeffLevel[1]},
+		{coeffLevel[2], coeffLevel[3]},
+	}
+}
+
+// chromaDCTransform transforms and scales in place the chroma DC block c, as
+// given by chromaDCMatrix, for the given ChromaArrayType, to the DC
+// coefficients dcC of the 4x4 chroma blocks, as specified by section
+// 8.5.11.2. qP is QP'C for 4:2:0, and QP'C + 3, i.e. QP'C,DC, for 4:2:2, and
+// ls00 is LevelScale4x4(qP % 6, 0, 0).
+func chromaDCTransform(c *[4][2]int, chromaArrayType, ls00, qP int) {
+	rows := 2
+	if chromaArrayType == chroma422 {
+		rows = 4
+	}
+	for i := 0; i < rows; i++ {
+		c[i][0], c[i][1] = c[i][0]+c[i][1], c[i][0]-c[i][1]
+	}
+	for j := 0; j < 2; j++ {
+		if rows == 2 {
+			c[0][j], c[1][j] = c[0][j]+c[1][j], c[0][j]-c[1][j]
+			continue
+		}
+		col := hadamard4([4]int{c[0][j], c[1][j], c[2][j], c[3][j]})
+		for i, v := range col {
+			c[i][j] = v
+		}
+	}
+
+	for i := 0; i < rows; i++ {
+		for j := range c[i] {
+			switch {
+			case chromaArrayType == chroma420:
+				c[i][j] = ((c[i][j] * ls00) << uint(qP/6)) >> 5
+			case qP >= 36:
+				c[i][j] = (c[i][j] * ls00) << uint(qP/6-6)
+			default:
+				c[i][j] = (c[i][j]*ls00 + 1<<uint(5-qP/6)) >> uint(6-qP/6)
+			}
+		}
+	}
+}
+
+// chromaList sets list to the coefficients of the 4x4 chroma block with index
+// chroma4x4BlkIdx, in scanning order, being the DC coefficient dcC of the
+// block, as given by the chroma DC transform, followed by the 15 levels of
+// its AC block, ac, as specified by 8.5.11.1. dcC is indexed as
+// chromaDCMatrix, blocks being in raster scan order.
+func chromaList(list *[16]int, dcC *[4][2]int, chroma4x4BlkIdx int, ac []int) {
+	list[0] = dcC[chroma4x4BlkIdx/2][chroma4x4BlkIdx%2]
+	copy(list[1:], ac[:15])
+}
+
+// Errors used in the handling of chroma DC.
+var errNoChromaDC = errors.New("no chroma DC blocks for ChromaArrayType")
@@ -0,0 +1,219 @@
+/*
+NAME
+  chromadc_test.go
+
+DESCRIPTION
+  chromadc_test.go provides testing for functionality provided in chromadc.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+func TestReadChromaDCCAVLC(t *testing.T) {
+	tests := []struct {
+		in              string
+		chromaArrayType int
+		want            []int
+		totalCoeff      int
+		err             error
+	}{
+		{in: "001 00 01 0", chromaArrayType: chroma420, want: []int{1, 0, 1, 0}, totalCoeff: 2},
+		{in: "01 1 0000 0", chromaArrayType: chroma422, want: []int{0, 0, 0, 0, 0, 0, 0, -1}, totalCoeff: 1},
+		{in: "1", chromaArrayType: chroma422, want: []int{0, 0, 0, 0, 0, 0, 0, 0}},
+		{in: "1", chromaArrayType: chroma444, err: errNoChromaDC},
+		{in: "1", chromaArrayType: chromaMonochrome, err: errNoChromaDC},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.in)))
+		got := make([]int, maxChromaDCCoeff)
+		totalCoeff, err := readChromaDCCAVLC(br, test.chromaArrayType, got)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if totalCoeff != test.totalCoeff {
+			t.Errorf("did not get expected TotalCoeff for test: %d\nGot: %d\nWant: %d", i, totalCoeff, test.totalCoeff)
+		}
+		if got := got[:len(test.want)]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestDecodeChromaDC checks the decoding of the Cb and Cr DC blocks of a 4:2:2
+// macroblock, the first having coded_block_flag 1 and the second 0.
+func TestDecodeChromaDC(t *testing.T) {
+	const numC8x8 = 2
+	want := []int{0, 2, 0, 0, 0, -1, 0, 0}
+	mbs := ctxIncState(noFlags)
+
+	e := newCABACEncoder(t, "P", 0, 30)
+	e.encodeDecision(codedBlockFlagCtxIdx(ctxBlockCatChromaDC, 0), 1)
+	const last = 5
+	for j := 0; j <= last; j++ {
+		e.encodeDecision(sigCoeffCtxIdx(ctxBlockCatChromaDC, false, false, j, numC8x8), flagVal(want[j] != 0))
+		if want[j] != 0 {
+			e.encodeDecision(sigCoeffCtxIdx(ctxBlockCatChromaDC, false, true, j, numC8x8), flagVal(j == last))
+		}
+	}
+	e.encodeUEGk(0, false, coeffAbsLevelUCoff, 0, func(binIdx int) int {
+		return coeffAbsLevelCtxIdx(ctxBlockCatChromaDC, binIdx, 0, 0)
+	})
+	e.encodeBypass(1)
+	e.encodeUEGk(1, false, coeffAbsLevelUCoff, 0, func(binIdx int) int {
+		return coeffAbsLevelCtxIdx(ctxBlockCatChromaDC, binIdx, 1, 0)
+	})
+	e.encodeBypass(0)
+	e.encodeDecision(codedBlockFlagCtxIdx(ctxBlockCatChromaDC, 0), 0)
+	e.encodeTerminate(1)
+
+	d, err := newCABACDecoder(e.reader(), "P", 0, 30)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from newCABACDecoder", err)
+	}
+	for iCbCr, want := range [][]int{want, make([]int, 4*numC8x8)} {
+		got := make([]int, maxChromaDCCoeff)
+		for i := range got {
+			got[i] = 99
+		}
+		_, err := d.decodeChromaDC(mbs, ctxIncMbCurr, iCbCr, chroma422, false, false, got)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for iCbCr: %d", err, iCbCr)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("did not get expected result for iCbCr: %d\nGot: %v\nWant: %v", iCbCr, got, want)
+		}
+	}
+	if !mbs.has(ctxIncMbCurr, mbCbfCbDC) || mbs.has(ctxIncMbCurr, mbCbfCrDC) {
+		t.Errorf("did not get expected coded_block_flags recorded\nGot: %08b", mbs.flags[ctxIncMbCurr])
+	}
+}
+
+func TestChromaDCCodedBlockFlagCtxIdxInc(t *testing.T) {
+	tests := []struct {
+		mbAddrs          []int
+		flags            func(mbAddr int) mbFlags
+		iCbCr            int
+		constrainedIntra bool
+		want             int
+	}{
+		// Neighbours not available to an inter and an intra macroblock.
+		{flags: noFlags, want: 0},
+		{flags: func(int) mbFlags { return mbIntraCoded }, want: 3},
+
+		// coded_block_flag of A and B for the component.
+		{
+			mbAddrs: []int{ctxIncMbB, ctxIncMbA},
+			flags: func(mbAddr int) mbFlags {
+				return map[int]mbFlags{ctxIncMbA: mbCbfCbDC, ctxIncMbB: mbCbfCrDC}[mbAddr]
+			},
+			want: 1,
+		},
+		{
+			mbAddrs: []int{ctxIncMbB, ctxIncMbA},
+			flags: func(mbAddr int) mbFlags {
+				return map[int]mbFlags{ctxIncMbA: mbCbfCbDC, ctxIncMbB: mbCbfCrDC}[mbAddr]
+			},
+			iCbCr: 1,
+			want:  2,
+		},
+
+		// I_PCM neighbours.
+		{
+			mbAddrs: []int{ctxIncMbB},
+			flags: func(mbAddr int) mbFlags {
+				return map[int]mbFlags{ctxIncMbB: mbIntraCoded | mbPCM}[mbAddr]
+			},
+			want: 2,
+		},
+
+		// Inter neighbours with constrainedIntra.
+		{
+			mbAddrs: []int{ctxIncMbB, ctxIncMbA},
+			flags: func(mbAddr int) mbFlags {
+				return map[int]mbFlags{ctxIncMbA: mbCbfCbDC, ctxIncMbB: mbIntraCoded | mbCbfCbDC, ctxIncMbCurr: mbIntraCoded}[mbAddr]
+			},
+			constrainedIntra: true,
+			want:             2,
+		},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(test.flags, test.mbAddrs...)
+		got := chromaDCCodedBlockFlagCtxIdxInc(mbs, ctxIncMbCurr, test.iCbCr, test.constrainedIntra)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestChromaDCMatrix(t *testing.T) {
+	tests := []struct {
+		chromaArrayType int
+		coeffLevel      []int
+		want            [4][2]int
+	}{
+		{chroma420, []int{1, 2, 3, 4}, [4][2]int{{1, 2}, {3, 4}}},
+		{chroma422, []int{0, 1, 2, 3, 4, 5, 6, 7}, [4][2]int{{0, 2}, {1, 5}, {3, 6}, {4, 7}}},
+	}
+
+	for i, test := range tests {
+		got := chromaDCMatrix(test.chromaArrayType, test.coeffLevel)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestChromaDCTransform(t *testing.T) {
+	tests := []struct {
+		chromaArrayType int
+		c               [4][2]int
+		ls00            int
+		qP              int
+		want            [4][2]int
+	}{
+		{chroma420, [4][2]int{{1, 0}, {0, 0}}, 256, 28, [4][2]int{{128, 128}, {128, 128}}},
+		{chroma420, [4][2]int{{1, 2}, {3, 4}}, 160, 0, [4][2]int{{50, -10}, {-20, 0}}},
+
+		// 4:2:2, with qP being QP'C,DC, scaled with a shift left and by
+		// rounding and shifting right.
+		{chroma422, [4][2]int{{1, 0}, {0, 0}, {0, 0}, {0, 0}}, 224, 39, [4][2]int{{224, 224}, {224, 224}, {224, 224}, {224, 224}}},
+		{chroma422, [4][2]int{{1, 0}, {0, 0}, {0, 0}, {0, 0}}, 224, 27, [4][2]int{{56, 56}, {56, 56}, {56, 56}, {56, 56}}},
+		{chroma422, [4][2]int{{0, 0}, {1, 0}, {0, 0}, {0, 0}}, 64, 36, [4][2]int{{64, 64}, {64, 64}, {-64, -64}, {-64, -64}}},
+	}
+
+	for i, test := range tests {
+		got := test.c
+		chromaDCTransform(&got, test.chromaArrayType, test.ls00, test.qP)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestChromaList(t *testing.T) {
+	dcC := [4][2]int{{10, 11}, {12, 13}, {14, 15}, {16, 17}}
+	ac := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	var got [16]int
+	chromaList(&got, &dcC, 5, ac)
+	want := [16]int{15, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	if got != want {
+		t.Errorf("did not get expected result\nGot: %v\nWant: %v", got, want)
+	}
+}
@@ -0,0 +1,53 @@
+/*
+NAME
+  conceal.go
+
+DESCRIPTION
+  conceal.go provides the concealment of the macroblocks of a picture that
+  were not decoded, such as those of slices lost or discarded due to errors.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "image"
+
+// concealMbs replaces the samples of the macroblocks of the picture held in
+// pic, using sps and whose first slice has header h, that were not decoded
+// according to mbs. Each column of a concealed macroblock is given the sample
+// of the bottom row of the macroblock above, whether decoded or concealed, or
+// the mid value of 8 bit samples if there is none, as for the top row of the
+// picture, or in MBAFF frames, whose macroblocks above may be of either field.
+func concealMbs(pic *image.YCbCr, sps *SPS, h *SliceHeader, mbs *mbState) {
+	for mbAddr := 0; mbAddr < mbs.n; mbAddr++ {
+		if mbs.sliceNum[mbAddr] >= 0 {
+			continue
+		}
+		s := newMbSamples(pic, sps, h, mbAddr, false)
+		var above mbSamples
+		hasAbove := !mbs.mbaff && mbAddr >= mbs.widthMbs
+		if hasAbove {
+			above = newMbSamples(pic, sps, h, mbAddr-mbs.widthMbs, false)
+		}
+		for comp := range s.plane {
+			if s.plane[comp] == nil {
+				continue
+			}
+			w, ht := 16, 16
+			if comp != 0 {
+				w, ht = MbWidthC(sps), MbHeightC(sps)
+			}
+			for x := 0; x < w; x++ {
+				v := 128
+				if hasAbove {
+					v = above.get(comp, x, ht-1)
+				}
+				for y := 0; y < ht; y++ {
+					s.set(comp, x, y, v)
+				}
+			}
+		}
+	}
+}
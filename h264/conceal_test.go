@@ -0,0 +1,53 @@
+/*
+NAME
+  conceal_test.go
+
+DESCRIPTION
+  conceal_test.go provides testing for functionality provided in conceal.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"image"
+	"testing"
+)
+
+// TestConcealMbs checks that of a 1x3 macroblock 4:2:0 picture whose first
+// macroblock alone is decoded, the others are given the bottom row of the
+// first, and that the top macroblock of a picture is given the mid value.
+func TestConcealMbs(t *testing.T) {
+	sps := &SPS{ChromaFormat: chroma420, PicHeightInMapUnitsMinus1: 2, FrameMbsOnly: true}
+	h := &SliceHeader{ChromaArrayType: chroma420}
+	pic := image.NewYCbCr(image.Rect(0, 0, 16, 48), image.YCbCrSubsampleRatio420)
+	for x := 0; x < 16; x++ {
+		pic.Y[pic.YOffset(x, 15)] = byte(x)
+	}
+	for x := 0; x < 8; x++ {
+		pic.Cb[7*pic.CStride+x] = byte(100 + x)
+	}
+
+	mbs := newMbState(1, 3)
+	mbs.beginMb(0, mbs.startSlice(), mbIntraCoded)
+	concealMbs(pic, sps, h, mbs)
+	for y := 16; y < 48; y++ {
+		for x := 0; x < 16; x++ {
+			if got := pic.Y[pic.YOffset(x, y)]; got != byte(x) {
+				t.Fatalf("did not get expected luma sample at (%d, %d)\nGot: %d\nWant: %d", x, y, got, x)
+			}
+			if got, want := pic.Cb[pic.COffset(x, y)], byte(100+x/2); got != want {
+				t.Fatalf("did not get expected Cb sample at (%d, %d)\nGot: %d\nWant: %d", x, y, got, want)
+			}
+		}
+	}
+
+	mbs.reset()
+	mbs.beginMb(1, mbs.startSlice(), mbIntraCoded)
+	concealMbs(pic, sps, h, mbs)
+	if got := pic.Y[pic.YOffset(5, 5)]; got != 128 {
+		t.Errorf("did not get expected luma sample of top macroblock\nGot: %d\nWant: 128", got)
+	}
+}
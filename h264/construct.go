@@ -0,0 +1,458 @@
+/*
+NAME
+  construct.go
+
+DESCRIPTION
+  construct.go provides the construction of the samples of macroblocks in the
+  picture being decoded, from their intra prediction, or from the samples of
+  I_PCM macroblocks.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"fmt"
+	"image"
+)
+
+// mbConstruction holds the state used in constructing the samples of a
+// macroblock, and in referring to those of its neighbours.
+type mbConstruction struct {
+	pic              *image.YCbCr
+	sps              *SPS
+	h                *SliceHeader
+	mbs              *mbState
+	arena            *arena // From which temporaries are allocated.
+	mbAddr           int
+	samples          mbSamples // Those of the macroblock.
+	constrainedIntra bool      // constrained_intra_pred_flag.
+	bitDepth         [3]int    // Of each colour component.
+
+	// The residual of the macroblock, being its transform coefficient
+	// levels, quantisation parameters and coded_block_pattern, with the
+	// scaling matrix of the picture and TransformBypassModeFlag.
+	residual *mbResidual
+	qp       mbQP
+	cbp      int
+	scaling  *ScalingMatrix
+	bypass   bool
+}
+
+// constructMb constructs the samples of the macroblock with address
+// currMbAddr, last parsed into d, in the picture: placing the samples of
+// I_PCM macroblocks, and predicting those of macroblocks coded in Intra_4x4,
+// Intra_8x8 or Intra_16x16 prediction mode and adding their residuals. When
+// ChromaArrayType is 3, the Cb and Cr samples are constructed as are the
+// luma samples (8.3.4.5). Temporaries are allocated from the arena of ctx,
+// and released once the macroblock is constructed.
+func (d *SliceData) constructMb(ctx *SliceContext, mbs *mbState, currMbAddr int) error {
+	bitDepthY, bitDepthC := 8+ctx.SPS.BitDepthLumaMinus8, 8+ctx.SPS.BitDepthChromaMinus8
+	a := ctx.arena
+	defer a.release(a.mark())
+	c := &a.mb
+	*c = mbConstruction{
+		pic:              d.pic,
+		sps:              ctx.SPS,
+		h:                ctx.Slice.Header,
+		mbs:              mbs,
+		arena:            a,
+		mbAddr:           currMbAddr,
+		samples:          newMbSamples(d.pic, ctx.SPS, ctx.Slice.Header, currMbAddr, mbs.has(currMbAddr, mbFieldDecoded)),
+		constrainedIntra: ctx.PPS.ConstrainedIntraPred,
+		bitDepth:         [3]int{bitDepthY, bitDepthC, bitDepthC},
+		residual:         &d.residual,
+		qp:               d.qp,
+		cbp:              d.CodedBlockPattern,
+		scaling:          &ctx.PPS.ScalingMatrix,
+		bypass:           ctx.SPS.QPrimeYZeroTransformBypass && d.qp.y == 0,
+	}
+	if mbs.has(currMbAddr, mbPCM) {
+		d.writePCMSamples(c)
+		return nil
+	}
+
+	numComps := 1
+	if c.h.ChromaArrayType == chroma444 {
+		numComps = 3
+	}
+	for comp := 0; comp < numComps; comp++ {
+		var err error
+		switch d.predMode {
+		case intra4x4:
+			err = c.intra4x4(comp, d.PrevIntra4x4PredModeFlag, d.RemIntra4x4PredMode)
+		case intra8x8:
+			err = c.intra8x8(comp, d.PrevIntra8x8PredModeFlag, d.RemIntra8x8PredMode)
+		case intra16x16:
+			err = c.intra16x16(comp, d.intra16x16PredMode)
+		default:
+			if !featureInter {
+				// Inter prediction is not yet implemented, see FeatureSet.
+				return nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("could not predict colour component %d: %w", comp, err)
+		}
+	}
+
+	if c.h.ChromaArrayType != chroma420 && c.h.ChromaArrayType != chroma422 {
+		return nil
+	}
+	for comp := 1; comp < 3; comp++ {
+		err := c.intraChroma(comp, d.IntraChromaPredMode)
+		if err != nil {
+			return fmt.Errorf("could not predict colour component %d: %w", comp, err)
+		}
+	}
+	return nil
+}
+
+// neighbour returns the constructed sample of colour component c at the
+// location (xN, yN) relative to the macroblock, outside of it or within it,
+// and whether it is available for Intra prediction, maxW and maxH being as
+// for neighbourLocation. Samples of macroblocks not available, and with
+// constrained intra prediction of inter macroblocks, are not available.
+func (c *mbConstruction) neighbour(comp, xN, yN, maxW, maxH int) (int, bool) {
+	mbAddrN, xW, yW := c.mbs.neighbourLocation(c.mbAddr, xN, yN, maxW, maxH)
+	switch {
+	case !c.mbs.intraAvailable(mbAddrN, c.mbAddr, c.constrainedIntra):
+		return 0, false
+	case mbAddrN == c.mbAddr:
+		return c.samples.get(comp, xW, yW), true
+	}
+	s := newMbSamples(c.pic, c.sps, c.h, mbAddrN, c.mbs.has(mbAddrN, mbFieldDecoded))
+	return s.get(comp, xW, yW), true
+}
+
+// refs sets top and left to the samples of colour component comp above and
+// to the left of the block whose top left sample is at (x, y) relative to
+// the macroblock, of their lengths, returning whether each are all available
+// for Intra prediction. Those to the left of a macroblock in an MBAFF frame
+// may belong to both of a pair, so only some may be available.
+func (c *mbConstruction) refs(comp int, top, left []int, x, y, maxW, maxH int) (hasTop, hasLeft bool) {
+	hasTop, hasLeft = true, true
+	for i := range top {
+		v, ok := c.neighbour(comp, x+i, y-1, maxW, maxH)
+		top[i], hasTop = v, hasTop && ok
+	}
+	for i := range left {
+		v, ok := c.neighbour(comp, x-1, y+i, maxW, maxH)
+		left[i], hasLeft = v, hasLeft && ok
+	}
+	return hasTop, hasLeft
+}
+
+// intra4x4 constructs the samples of colour component comp, being luma or,
+// when ChromaArrayType is 3, Cb or Cr, of a macroblock coded in Intra_4x4
+// prediction mode, predicting each 4x4 block from the blocks constructed
+// before it (8.3.1). The Intra4x4PredMode of each block is derived from its
+// prev_intra4x4_pred_mode_flag and rem_intra4x4_pred_mode for luma, and is
+// that recorded for luma for Cb and Cr.
+func (c *mbConstruction) intra4x4(comp int, prevFlags, rems []int) error {
+	for blkIdx := 0; blkIdx < blocksPerMb; blkIdx++ {
+		mode := int(c.mbs.intraPredModes[c.mbAddr*blocksPerMb+blkIdx])
+		if comp == 0 {
+			mode = c.mbs.intra4x4PredMode(c.mbAddr, blkIdx, prevFlags[blkIdx] == 1, rems[blkIdx], c.constrainedIntra)
+		}
+		x, y := luma4x4BlkPos(blkIdx)
+
+		var r intra4x4Refs
+		r.top[0], r.hasCorner = c.neighbour(comp, x-1, y-1, 16, 16)
+		for i := 0; i < 8; i++ {
+			v, ok := c.neighbour(comp, x+i, y-1, 16, 16)
+			r.top[i+1] = v
+			switch i {
+			case 0:
+				r.hasTop = ok
+			case 4:
+				// Blocks 3 and 11 are decoded before those above and to
+				// their right.
+				r.hasTopRight = ok && blkIdx != 3 && blkIdx != 11
+			}
+		}
+		r.hasLeft = true
+		for i := 0; i < 4; i++ {
+			v, ok := c.neighbour(comp, x-1, y+i, 16, 16)
+			r.left[i], r.hasLeft = v, r.hasLeft && ok
+		}
+
+		var pred [16]int
+		err := predIntra4x4(&pred, &r, mode, c.bitDepth[comp])
+		if err != nil {
+			return fmt.Errorf("could not predict 4x4 block %d: %w", blkIdx, err)
+		}
+		var res [16]int
+		if c.residual4x4(&res, comp, blkIdx) {
+			if c.bypass && (mode == intraPredVertical || mode == intraPredHorizontal) {
+				bypassIntraResidual(res[:], 4, mode == intraPredHorizontal)
+			}
+			for i := range pred {
+				pred[i] = Clip1y(pred[i]+res[i], c.bitDepth[comp])
+			}
+		}
+		for i, v := range pred {
+			c.samples.set(comp, x+i%4, y+i/4, v)
+		}
+	}
+	return nil
+}
+
+// qP returns the quantisation parameter used in scaling the transform
+// coefficients of colour component comp, being QP'Y for luma and QP'C for
+// chroma.
+func (c *mbConstruction) qP(comp int) int {
+	if comp == 0 {
+		return c.qp.y
+	}
+	return c.qp.c[comp-1]
+}
+
+// list4x4 returns the 4x4 scaling list of colour component comp for the
+// macroblock, being that of intra or inter macroblocks.
+func (c *mbConstruction) list4x4(comp int) *[16]int {
+	if !c.mbs.has(c.mbAddr, mbIntraCoded) {
+		comp += 3
+	}
+	return &c.scaling.List4x4[comp]
+}
+
+// residual4x4 sets r to the residual samples, in raster order, of the 4x4
+// block with index blkIdx of colour component comp, being luma or, when
+// ChromaArrayType is 3, Cb or Cr, from its transform coefficient levels, as
+// specified by section 8.5.1. False is returned if the block has no
+// residual, coded_block_pattern giving its 8x8 block as not coded.
+func (c *mbConstruction) residual4x4(r *[16]int, comp, blkIdx int) bool {
+	if c.cbp&(1<<uint(blkIdx/4)) == 0 {
+		return false
+	}
+	inverseScan4x4(r, c.residual.level4x4[comp][blkIdx][:], c.residual.field)
+	if c.bypass {
+		return true
+	}
+
+	qP := c.qP(comp)
+	ls := levelScale4x4(c.list4x4(comp), qP%6)
+	scale4x4(r, &ls, qP, false)
+	idct4x4(r)
+	return true
+}
+
+// residual8x8 sets r to the residual samples, in raster order, of the 8x8
+// block with index blkIdx of colour component comp of a macroblock with
+// transform_size_8x8_flag 1, as residual4x4 does for 4x4 blocks (8.5.2).
+func (c *mbConstruction) residual8x8(r *[maxNumCoeff8x8]int, comp, blkIdx int) bool {
+	if c.cbp&(1<<uint(blkIdx)) == 0 {
+		return false
+	}
+	inverseScan8x8(r, c.residual.level8x8[comp][blkIdx][:], c.residual.field)
+	if c.bypass {
+		return true
+	}
+
+	qP := c.qP(comp)
+	list := 2 * comp
+	if !c.mbs.has(c.mbAddr, mbIntraCoded) {
+		list++
+	}
+	ls := levelScale8x8(&c.scaling.List8x8[list], qP%6)
+	scale8x8(r, &ls, qP)
+	idct8x8(r)
+	return true
+}
+
+// residual16x16 sets r to the residual samples, in raster order, of colour
+// component comp, being luma or, when ChromaArrayType is 3, Cb or Cr, of an
+// Intra16x16 macroblock, from its DC and AC transform coefficient levels,
+// as specified by section 8.5.2. The AC levels are zero unless
+// coded_block_pattern gives the luma blocks as coded.
+func (c *mbConstruction) residual16x16(r *[256]int, comp int) {
+	field := c.residual.field
+	var dcY [16]int
+	inverseScan4x4(&dcY, c.residual.i16x16DC[comp][:], field)
+	qP := c.qP(comp)
+	ls := levelScale4x4(c.list4x4(comp), qP%6)
+	if !c.bypass {
+		lumaDCTransform(&dcY, ls[0], qP)
+	}
+
+	for blkIdx := 0; blkIdx < blocksPerMb; blkIdx++ {
+		x, y := luma4x4BlkPos(blkIdx)
+		list := [16]int{dcY[4*(y/4)+x/4]}
+		if c.cbp&15 != 0 {
+			copy(list[1:], c.residual.level4x4[comp][blkIdx][:15])
+		}
+		var blk [16]int
+		inverseScan4x4(&blk, list[:], field)
+		if !c.bypass {
+			scale4x4(&blk, &ls, qP, true)
+			idct4x4(&blk)
+		}
+		for i, v := range blk {
+			r[16*(y+i/4)+x+i%4] = v
+		}
+	}
+}
+
+// residualChroma sets r to the residual samples, in raster order, of chroma
+// component comp, 1 for Cb or 2 for Cr, when ChromaArrayType is 1 or 2,
+// from its DC and AC transform coefficient levels, as specified by section
+// 8.5.11. False is returned if it has none, coded_block_pattern giving the
+// chroma blocks as not coded.
+func (c *mbConstruction) residualChroma(r []int, comp int) bool {
+	if c.cbp>>4 == 0 {
+		return false
+	}
+	cat, w := c.h.ChromaArrayType, MbWidthC(c.sps)
+	iCbCr := comp - 1
+	dcC := chromaDCMatrix(cat, c.residual.chromaDC[iCbCr][:])
+	qP := c.qP(comp)
+	ls := levelScale4x4(c.list4x4(comp), qP%6)
+	if !c.bypass {
+		qPDC := qP
+		if cat == chroma422 {
+			qPDC += 3
+		}
+		lsDC := levelScale4x4(c.list4x4(comp), qPDC%6)
+		chromaDCTransform(&dcC, cat, lsDC[0], qPDC)
+	}
+
+	for blkIdx := 0; blkIdx < len(r)/16; blkIdx++ {
+		var list, blk [16]int
+		chromaList(&list, &dcC, blkIdx, c.residual.chromaAC[iCbCr][blkIdx][:])
+		inverseScan4x4(&blk, list[:], c.residual.field)
+		if !c.bypass {
+			scale4x4(&blk, &ls, qP, true)
+			idct4x4(&blk)
+		}
+		x, y := partitionPos(blkIdx, 4, 4, w)
+		for i, v := range blk {
+			r[w*(y+i/4)+x+i%4] = v
+		}
+	}
+	return true
+}
+
+// intra8x8 constructs the samples of colour component comp of a macroblock
+// coded in Intra_8x8 prediction mode, as intra4x4 does for Intra_4x4, with
+// each 8x8 block's Intra8x8PredMode derived from its
+// prev_intra8x8_pred_mode_flag and rem_intra8x8_pred_mode (8.3.2).
+func (c *mbConstruction) intra8x8(comp int, prevFlags, rems []int) error {
+	for blkIdx := 0; blkIdx < 4; blkIdx++ {
+		mode := int(c.mbs.intraPredModes[c.mbAddr*blocksPerMb+4*blkIdx])
+		if comp == 0 {
+			mode = c.mbs.intra8x8PredMode(c.mbAddr, blkIdx, prevFlags[blkIdx] == 1, rems[blkIdx], c.constrainedIntra)
+		}
+		x, y := 8*(blkIdx%2), 8*(blkIdx/2)
+
+		var r intra8x8Refs
+		r.top[0], r.hasCorner = c.neighbour(comp, x-1, y-1, 16, 16)
+		for i := 0; i < 16; i++ {
+			v, ok := c.neighbour(comp, x+i, y-1, 16, 16)
+			r.top[i+1] = v
+			switch i {
+			case 0:
+				r.hasTop = ok
+			case 8:
+				r.hasTopRight = ok
+			}
+		}
+		r.hasLeft = true
+		for i := 0; i < 8; i++ {
+			v, ok := c.neighbour(comp, x-1, y+i, 16, 16)
+			r.left[i], r.hasLeft = v, r.hasLeft && ok
+		}
+
+		var pred [64]int
+		err := predIntra8x8(&pred, &r, mode, c.bitDepth[comp])
+		if err != nil {
+			return fmt.Errorf("could not predict 8x8 block %d: %w", blkIdx, err)
+		}
+		var res [maxNumCoeff8x8]int
+		if c.residual8x8(&res, comp, blkIdx) {
+			if c.bypass && (mode == intraPredVertical || mode == intraPredHorizontal) {
+				bypassIntraResidual(res[:], 8, mode == intraPredHorizontal)
+			}
+			for i := range pred {
+				pred[i] = Clip1y(pred[i]+res[i], c.bitDepth[comp])
+			}
+		}
+		for i, v := range pred {
+			c.samples.set(comp, x+i%8, y+i/8, v)
+		}
+	}
+	return nil
+}
+
+// intra16x16 constructs the samples of colour component comp, being luma
+// or, when ChromaArrayType is 3, Cb or Cr, of a macroblock coded in
+// Intra_16x16 prediction mode with Intra16x16PredMode mode (8.3.3).
+func (c *mbConstruction) intra16x16(comp, mode int) error {
+	r := intraRefs{top: c.arena.allocInts(16), left: c.arena.allocInts(16)}
+	r.corner, r.hasCorner = c.neighbour(comp, -1, -1, 16, 16)
+	r.hasTop, r.hasLeft = c.refs(comp, r.top, r.left, 0, 0, 16, 16)
+
+	var pred [256]int
+	err := predIntra16x16(&pred, &r, mode, c.bitDepth[comp])
+	if err != nil {
+		return err
+	}
+	var res [256]int
+	c.residual16x16(&res, comp)
+	if c.bypass && (mode == intraPredVertical || mode == intraPredHorizontal) {
+		bypassIntraResidual(res[:], 16, mode == intraPredHorizontal)
+	}
+	for i := range pred {
+		pred[i] = Clip1y(pred[i]+res[i], c.bitDepth[comp])
+	}
+	for i, v := range pred {
+		c.samples.set(comp, i%16, i/16, v)
+	}
+	return nil
+}
+
+// intraChroma constructs the samples of chroma component comp, 1 for Cb or
+// 2 for Cr, of an intra macroblock, when ChromaArrayType is 1 or 2, using
+// intra_chroma_pred_mode mode (8.3.4).
+func (c *mbConstruction) intraChroma(comp, mode int) error {
+	w, h := MbWidthC(c.sps), MbHeightC(c.sps)
+	r := intraRefs{top: c.arena.allocInts(w), left: c.arena.allocInts(h)}
+	r.corner, r.hasCorner = c.neighbour(comp, -1, -1, w, h)
+	r.hasTop, r.hasLeft = c.refs(comp, r.top, r.left, 0, 0, w, h)
+
+	pred := c.arena.allocInts(w * h)
+	err := predIntraChroma(pred, &r, mode, c.bitDepth[comp])
+	if err != nil {
+		return err
+	}
+	res := c.arena.allocInts(w * h)
+	if c.residualChroma(res, comp) {
+		if c.bypass && (mode == intraChromaPredHorizontal || mode == intraChromaPredVertical) {
+			bypassIntraResidual(res, w, mode == intraChromaPredHorizontal)
+		}
+		for i := range pred {
+			pred[i] = Clip1y(pred[i]+res[i], c.bitDepth[comp])
+		}
+	}
+	for i, v := range pred {
+		c.samples.set(comp, i%w, i/w, v)
+	}
+	return nil
+}
+
+// writePCMSamples places the samples of the I_PCM macroblock being constructed
+// by c in the picture, as specified by section 8.3.5.
+func (d *SliceData) writePCMSamples(c *mbConstruction) {
+	for i, v := range d.PcmSampleLuma {
+		c.samples.set(0, i%16, i/16, v)
+	}
+	if len(d.PcmSampleChroma) == 0 {
+		return
+	}
+	w, n := MbWidthC(c.sps), len(d.PcmSampleChroma)/2
+	for i, v := range d.PcmSampleChroma {
+		c.samples.set(1+i/n, i%n%w, i%n/w, v)
+	}
+}
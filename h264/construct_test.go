@@ -0,0 +1,358 @@
+/*
+NAME
+  construct_test.go
+
+DESCRIPTION
+  construct_test.go provides testing for functionality provided in construct.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"image"
+	"testing"
+)
+
+// TestConstructMbIntra4x4 checks the Intra_4x4 prediction of the second
+// macroblock of a 2x1 macroblock frame, each of whose 4x4 blocks is predicted
+// in Intra_4x4_Horizontal mode, from the right column of the first
+// macroblock, and so from the 4x4 blocks to its left within the macroblock.
+func TestConstructMbIntra4x4(t *testing.T) {
+	sps := &SPS{ChromaFormat: chroma420, PicWidthInMbsMinus1: 1, FrameMbsOnly: true}
+	h := &SliceHeader{ChromaArrayType: chroma420}
+	ctx := &SliceContext{SPS: sps, PPS: &PPS{}, Slice: &Slice{Header: h}, arena: &arena{}}
+	pic := image.NewYCbCr(image.Rect(0, 0, 32, 16), image.YCbCrSubsampleRatio420)
+	for y := 0; y < 16; y++ {
+		pic.Y[pic.YOffset(15, y)] = byte(10 * y)
+	}
+
+	mbs := newMbState(2, 1)
+	sliceNum := mbs.startSlice()
+	mbs.beginMb(0, sliceNum, mbIntraCoded)
+	mbs.setIntraPredModes(0, intraPredDC)
+	mbs.beginMb(1, sliceNum, mbIntraCoded)
+
+	// Those of the top row have predIntra4x4PredMode DC, and rem 1, and the
+	// others that of the block above, Horizontal.
+	d := &SliceData{
+		pic:                      pic,
+		predMode:                 intra4x4,
+		PrevIntra4x4PredModeFlag: []int{0, 0, 1, 1, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+		RemIntra4x4PredMode:      []int{1, 1, 0, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	}
+	err := d.constructMb(ctx, mbs, 1)
+	if err != nil {
+		t.Fatalf("did not expect error: %v", err)
+	}
+
+	for y := 0; y < 16; y++ {
+		for x := 16; x < 32; x++ {
+			if got, want := pic.Y[pic.YOffset(x, y)], byte(10*y); got != want {
+				t.Fatalf("did not get expected luma sample at (%d, %d)\nGot: %d\nWant: %d", x, y, got, want)
+			}
+		}
+	}
+	for blkIdx, mode := range mbs.intraPredModes[16:] {
+		if mode != intraPredHorizontal {
+			t.Errorf("did not get expected Intra4x4PredMode for block: %d\nGot: %d\nWant: %d", blkIdx, mode, intraPredHorizontal)
+		}
+	}
+}
+
+// TestConstructMbIntra16x16 checks the Intra_16x16 prediction of the second
+// macroblock of a 2x1 macroblock frame in Intra_16x16_Horizontal mode, and
+// the error for Intra_16x16_Vertical, the macroblock having none above.
+func TestConstructMbIntra16x16(t *testing.T) {
+	for _, mode := range []int{intraPredHorizontal, intraPredVertical} {
+		sps := &SPS{ChromaFormat: chroma420, PicWidthInMbsMinus1: 1, FrameMbsOnly: true}
+		h := &SliceHeader{ChromaArrayType: chroma420}
+		ctx := &SliceContext{SPS: sps, PPS: &PPS{}, Slice: &Slice{Header: h}, arena: &arena{}}
+		pic := image.NewYCbCr(image.Rect(0, 0, 32, 16), image.YCbCrSubsampleRatio420)
+		for y := 0; y < 16; y++ {
+			pic.Y[pic.YOffset(15, y)] = byte(10 * y)
+		}
+
+		mbs := newMbState(2, 1)
+		sliceNum := mbs.startSlice()
+		mbs.beginMb(0, sliceNum, mbIntraCoded)
+		mbs.beginMb(1, sliceNum, mbIntraCoded)
+
+		d := &SliceData{pic: pic, predMode: intra16x16, intra16x16PredMode: mode}
+		err := d.constructMb(ctx, mbs, 1)
+		if mode == intraPredVertical {
+			if !errors.Is(err, errIntraPredUnavailable) {
+				t.Errorf("did not get expected error for Intra_16x16_Vertical\nGot: %v\nWant: %v", err, errIntraPredUnavailable)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("did not expect error: %v", err)
+		}
+		for y := 0; y < 16; y++ {
+			for x := 16; x < 32; x++ {
+				if got, want := pic.Y[pic.YOffset(x, y)], byte(10*y); got != want {
+					t.Fatalf("did not get expected luma sample at (%d, %d)\nGot: %d\nWant: %d", x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestConstructMbIntraChroma checks the prediction of the chroma samples of
+// the second macroblock of a 2x1 macroblock frame in Intra_Chroma_Horizontal
+// mode for 4:2:0 and 4:2:2, and, for 4:4:4, in the Intra_16x16_Horizontal
+// mode of the luma samples.
+func TestConstructMbIntraChroma(t *testing.T) {
+	tests := []struct {
+		chromaArrayType int
+		ratio           image.YCbCrSubsampleRatio
+		w, h            int // Of the chroma blocks.
+	}{
+		{chroma420, image.YCbCrSubsampleRatio420, 8, 8},
+		{chroma422, image.YCbCrSubsampleRatio422, 8, 16},
+		{chroma444, image.YCbCrSubsampleRatio444, 16, 16},
+	}
+
+	for i, test := range tests {
+		sps := &SPS{ChromaFormat: test.chromaArrayType, PicWidthInMbsMinus1: 1, FrameMbsOnly: true}
+		h := &SliceHeader{ChromaArrayType: test.chromaArrayType}
+		ctx := &SliceContext{SPS: sps, PPS: &PPS{}, Slice: &Slice{Header: h}, arena: &arena{}}
+		pic := image.NewYCbCr(image.Rect(0, 0, 32, 16), test.ratio)
+		for y := 0; y < test.h; y++ {
+			pic.Cb[y*pic.CStride+test.w-1] = byte(10 * y)
+			pic.Cr[y*pic.CStride+test.w-1] = byte(10*y + 5)
+		}
+
+		mbs := newMbState(2, 1)
+		sliceNum := mbs.startSlice()
+		mbs.beginMb(0, sliceNum, mbIntraCoded)
+		mbs.beginMb(1, sliceNum, mbIntraCoded)
+
+		d := &SliceData{
+			pic:                 pic,
+			predMode:            intra16x16,
+			intra16x16PredMode:  intraPredHorizontal,
+			IntraChromaPredMode: intraChromaPredHorizontal,
+		}
+		err := d.constructMb(ctx, mbs, 1)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for test: %d", err, i)
+		}
+		for y := 0; y < test.h; y++ {
+			for x := test.w; x < 2*test.w; x++ {
+				off := y*pic.CStride + x
+				if pic.Cb[off] != byte(10*y) || pic.Cr[off] != byte(10*y+5) {
+					t.Fatalf("did not get expected chroma samples at (%d, %d) for test: %d\nGot: %d, %d\nWant: %d, %d",
+						x, y, i, pic.Cb[off], pic.Cr[off], 10*y, 10*y+5)
+				}
+			}
+		}
+	}
+}
+
+// TestConstructMbIntra8x8 checks the Intra_8x8 prediction of the second
+// macroblock of a 2x1 macroblock frame, each of whose 8x8 blocks is predicted
+// in Intra_8x8_Horizontal mode, from the filtered samples of the right column
+// of the first macroblock for blocks 0 and 2.
+func TestConstructMbIntra8x8(t *testing.T) {
+	sps := &SPS{ChromaFormat: chroma420, PicWidthInMbsMinus1: 1, FrameMbsOnly: true}
+	h := &SliceHeader{ChromaArrayType: chroma420}
+	ctx := &SliceContext{SPS: sps, PPS: &PPS{}, Slice: &Slice{Header: h}, arena: &arena{}}
+	pic := image.NewYCbCr(image.Rect(0, 0, 32, 16), image.YCbCrSubsampleRatio420)
+	for y := 0; y < 16; y++ {
+		pic.Y[pic.YOffset(15, y)] = byte(10 * y)
+	}
+
+	mbs := newMbState(2, 1)
+	sliceNum := mbs.startSlice()
+	mbs.beginMb(0, sliceNum, mbIntraCoded)
+	mbs.setIntraPredModes(0, intraPredDC)
+	mbs.beginMb(1, sliceNum, mbIntraCoded|mbTransform8x8)
+
+	// Blocks 0 and 1 have predIntra8x8PredMode DC, and rem 1, and blocks 2
+	// and 3 that of the block above, Horizontal.
+	d := &SliceData{
+		pic:                      pic,
+		predMode:                 intra8x8,
+		PrevIntra8x8PredModeFlag: []int{0, 0, 1, 1},
+		RemIntra8x8PredMode:      []int{1, 1, 0, 0},
+	}
+	err := d.constructMb(ctx, mbs, 1)
+	if err != nil {
+		t.Fatalf("did not expect error: %v", err)
+	}
+
+	// Block 0 has p[-1, -1] not available, and block 2 has it available.
+	want := []int{3, 10, 20, 30, 40, 50, 60, 68, 80, 90, 100, 110, 120, 130, 140, 148}
+	for y := 0; y < 16; y++ {
+		for x := 16; x < 24; x++ {
+			if got := pic.Y[pic.YOffset(x, y)]; int(got) != want[y] {
+				t.Fatalf("did not get expected luma sample at (%d, %d)\nGot: %d\nWant: %d", x, y, got, want[y])
+			}
+		}
+	}
+	for blkIdx, mode := range mbs.intraPredModes[16:] {
+		if mode != intraPredHorizontal {
+			t.Errorf("did not get expected Intra8x8PredMode for 4x4 block: %d\nGot: %d\nWant: %d", blkIdx, mode, intraPredHorizontal)
+		}
+	}
+}
+
+// TestConstructMbResidual4x4 checks the addition of the residuals of 4x4
+// blocks to their Intra_4x4_DC predictions, for the single macroblock of a
+// 1x1 macroblock frame, with the residual scaled and transformed, and with
+// TransformBypassModeFlag 1.
+func TestConstructMbResidual4x4(t *testing.T) {
+	tests := []struct {
+		bypass bool
+		qpY    int
+		want   map[image.Point]byte // Luma samples by location.
+	}{
+		// Block 0 has DC 1 and so residual 3, and block 3, predicted from
+		// blocks 1 and 2, has the coefficient at (1, 0) 1, giving residuals
+		// of 3, 2, -2 and -3 along each row.
+		{
+			qpY: 24,
+			want: map[image.Point]byte{
+				{0, 0}: 131, {3, 3}: 131, {4, 0}: 131, {0, 4}: 131,
+				{4, 4}: 134, {5, 4}: 133, {6, 5}: 129, {7, 7}: 128,
+				{15, 15}: 131,
+			},
+		},
+
+		// The levels as the residual.
+		{
+			bypass: true,
+			want: map[image.Point]byte{
+				{0, 0}: 129, {1, 0}: 128, {3, 3}: 128, {4, 0}: 128,
+				{4, 4}: 128, {5, 4}: 129, {6, 4}: 128,
+			},
+		},
+	}
+
+	for i, test := range tests {
+		sps := &SPS{ChromaFormat: chroma420, FrameMbsOnly: true, QPrimeYZeroTransformBypass: test.bypass}
+		h := &SliceHeader{ChromaArrayType: chroma420}
+		ctx := &SliceContext{SPS: sps, PPS: &PPS{ScalingMatrix: FlatScalingMatrix()}, Slice: &Slice{Header: h}, arena: &arena{}}
+		pic := image.NewYCbCr(image.Rect(0, 0, 16, 16), image.YCbCrSubsampleRatio420)
+
+		mbs := newMbState(1, 1)
+		mbs.beginMb(0, mbs.startSlice(), mbIntraCoded)
+
+		d := &SliceData{
+			pic:                      pic,
+			predMode:                 intra4x4,
+			PrevIntra4x4PredModeFlag: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+			RemIntra4x4PredMode:      make([]int, 16),
+			CodedBlockPattern:        1,
+			qp:                       mbQP{y: test.qpY},
+		}
+		d.residual.level4x4[0][0][0] = 1
+		d.residual.level4x4[0][3][1] = 1
+		err := d.constructMb(ctx, mbs, 0)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for test: %d", err, i)
+		}
+		for p, want := range test.want {
+			if got := pic.Y[pic.YOffset(p.X, p.Y)]; got != want {
+				t.Errorf("did not get expected luma sample at %v for test: %d\nGot: %d\nWant: %d", p, i, got, want)
+			}
+		}
+	}
+}
+
+// TestConstructMbResidual8x8 checks the addition of the residuals of 8x8
+// blocks to their Intra_8x8_DC predictions, for the single macroblock of a
+// 1x1 macroblock frame. Block 0 has DC 1 and so residual 5, and block 3,
+// predicted from the constructed blocks 1 and 2, the coefficient at (1, 0) 1.
+func TestConstructMbResidual8x8(t *testing.T) {
+	sps := &SPS{ChromaFormat: chroma420, FrameMbsOnly: true}
+	h := &SliceHeader{ChromaArrayType: chroma420}
+	ctx := &SliceContext{SPS: sps, PPS: &PPS{ScalingMatrix: FlatScalingMatrix()}, Slice: &Slice{Header: h}, arena: &arena{}}
+	pic := image.NewYCbCr(image.Rect(0, 0, 16, 16), image.YCbCrSubsampleRatio420)
+
+	mbs := newMbState(1, 1)
+	mbs.beginMb(0, mbs.startSlice(), mbIntraCoded|mbTransform8x8)
+
+	d := &SliceData{
+		pic:                      pic,
+		predMode:                 intra8x8,
+		PrevIntra8x8PredModeFlag: []int{1, 1, 1, 1},
+		RemIntra8x8PredMode:      make([]int, 4),
+		CodedBlockPattern:        9,
+		qp:                       mbQP{y: 36},
+	}
+	d.residual.level8x8[0][0][0] = 1
+	d.residual.level8x8[0][3][1] = 1
+	err := d.constructMb(ctx, mbs, 0)
+	if err != nil {
+		t.Fatalf("did not expect error: %v", err)
+	}
+
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			want := 133
+			if x >= 8 && y >= 8 {
+				want = []int{140, 139, 137, 135, 131, 129, 127, 126}[x-8]
+			}
+			if got := pic.Y[pic.YOffset(x, y)]; int(got) != want {
+				t.Fatalf("did not get expected luma sample at (%d, %d)\nGot: %d\nWant: %d", x, y, got, want)
+			}
+		}
+	}
+}
+
+// TestConstructMbResidual16x16 checks the addition of the residuals of an
+// Intra16x16 macroblock and of its chroma to their DC predictions, for the
+// single macroblock of a 1x1 macroblock frame. The luma DC block of DC 1
+// gives each 4x4 block residual 1, and block 0 also has AC level 1 at (1, 0).
+// The Cb DC block of DC 1 gives each 4x4 Cb block residual 1.
+func TestConstructMbResidual16x16(t *testing.T) {
+	sps := &SPS{ChromaFormat: chroma420, FrameMbsOnly: true}
+	h := &SliceHeader{ChromaArrayType: chroma420}
+	ctx := &SliceContext{SPS: sps, PPS: &PPS{ScalingMatrix: FlatScalingMatrix()}, Slice: &Slice{Header: h}, arena: &arena{}}
+	pic := image.NewYCbCr(image.Rect(0, 0, 16, 16), image.YCbCrSubsampleRatio420)
+
+	mbs := newMbState(1, 1)
+	mbs.beginMb(0, mbs.startSlice(), mbIntraCoded)
+
+	d := &SliceData{
+		pic:                 pic,
+		predMode:            intra16x16,
+		intra16x16PredMode:  intraPredDC,
+		IntraChromaPredMode: intraChromaPredDC,
+		CodedBlockPattern:   1<<4 | 15,
+		qp:                  mbQP{y: 24, c: [2]int{24, 24}},
+	}
+	d.residual.i16x16DC[0][0] = 1
+	d.residual.level4x4[0][0][0] = 1
+	d.residual.chromaDC[0][0] = 1
+	err := d.constructMb(ctx, mbs, 0)
+	if err != nil {
+		t.Fatalf("did not expect error: %v", err)
+	}
+
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			want := 129
+			if x < 4 && y < 4 {
+				want = []int{132, 130, 127, 125}[x]
+			}
+			if got := pic.Y[pic.YOffset(x, y)]; int(got) != want {
+				t.Fatalf("did not get expected luma sample at (%d, %d)\nGot: %d\nWant: %d", x, y, got, want)
+			}
+		}
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			off := pic.COffset(x, y)
+			if pic.Cb[off] != 129 || pic.Cr[off] != 128 {
+				t.Fatalf("did not get expected chroma samples at (%d, %d)\nGot: %d, %d\nWant: 129, 128", x, y, pic.Cb[off], pic.Cr[off])
+			}
+		}
+	}
+}
@@ -0,0 +1,756 @@
+/*
+NAME
+  deblock.go
+
+DESCRIPTION
+  deblock.go provides the deblocking filter process of section 8.7, applied
+  to each decoded picture: the derivation of boundary filtering strengths and
+  the filtering of samples across block edges, for luma and for the chroma of
+  each chroma format.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "image"
+
+// Values of alpha' and beta' indexed by indexA and indexB respectively, from
+// table 8-16. Both are 0 for indices below 16.
+var (
+	alphaTable = [52]int{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		4, 4, 5, 6, 7, 8, 9, 10, 12, 13, 15, 17, 20, 22, 25, 28,
+		32, 36, 40, 45, 50, 56, 63, 71, 80, 90, 101, 113, 127, 144, 162, 182,
+		203, 226, 255, 255,
+	}
+	betaTable = [52]int{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 6, 6, 7, 7, 8, 8,
+		9, 9, 10, 10, 11, 11, 12, 12, 13, 13, 14, 14, 15, 15, 16, 16,
+		17, 17, 18, 18,
+	}
+)
+
+// tc0Table holds the values of tC0' indexed by indexA and bS-1 for bS of 1 to
+// 3, from table 8-17. All are 0 for indices below 17.
+var tc0Table = [52][3]int{
+	{0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0},
+	{0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0},
+	{0, 0, 0}, {0, 0, 1}, {0, 0, 1}, {0, 0, 1}, {0, 0, 1}, {0, 1, 1}, {0, 1, 1}, {1, 1, 1},
+	{1, 1, 1}, {1, 1, 1}, {1, 1, 1}, {1, 1, 2}, {1, 1, 2}, {1, 1, 2}, {1, 1, 2}, {1, 2, 3},
+	{1, 2, 3}, {2, 2, 3}, {2, 2, 4}, {2, 3, 4}, {2, 3, 4}, {3, 3, 5}, {3, 4, 6}, {3, 4, 6},
+	{4, 5, 7}, {4, 5, 8}, {4, 6, 9}, {5, 7, 10}, {6, 8, 11}, {6, 8, 13}, {7, 10, 14}, {8, 11, 16},
+	{9, 12, 18}, {10, 13, 20}, {11, 15, 23}, {13, 17, 25},
+}
+
+// qpcTable holds the values of QPc for qPI of 30 to 51, from table 8-15. For
+// qPI below 30, QPc is equal to qPI.
+var qpcTable = [22]int{
+	29, 30, 31, 32, 32, 33, 34, 34, 35, 35, 36, 36, 37, 37, 37, 38, 38, 38, 39, 39, 39, 39,
+}
+
+// chromaQP returns QPc, the chroma quantisation parameter of a chroma
+// component for the luma quantisation parameter qpY, given the component's
+// offset, being chroma_qp_index_offset for Cb or second_chroma_qp_index_offset
+// for Cr, as derived by section 8.5.8. For the deblocking filter, qpY is QPY
+// rather than QP'Y (8.7.2.2), so qpBdOffsetC is then 0.
+func chromaQP(qpY, offset, qpBdOffsetC int) int {
+	qpI := Clip3(-qpBdOffsetC, 51, qpY+offset)
+	if qpI < 30 {
+		return qpI
+	}
+	return qpcTable[qpI-30]
+}
+
+// chromaStyleFiltering returns chromaStyleFilteringFlag (8.7.2), which is set
+// when filtering chroma edges of other than 4:4:4 video. The chroma of 4:4:4
+// video is filtered in the same way as luma.
+func chromaStyleFiltering(chromaEdge bool, chromaArrayType int) bool {
+	return chromaEdge && chromaArrayType != chroma444
+}
+
+// edgeFilter holds the values, derived by section 8.7.2.2, controlling the
+// filtering of an edge between the blocks of two macroblocks, or of one.
+type edgeFilter struct {
+	indexA      int
+	alpha, beta int  // Thresholds, scaled for the bit depth.
+	bitDepth    int  // Bit depth of the samples filtered.
+	chromaStyle bool // chromaStyleFilteringFlag, see chromaStyleFiltering.
+}
+
+// newEdgeFilter returns the edgeFilter for an edge between blocks of the
+// macroblocks p and q, with quantisation parameters qPp and qPq, given the
+// FilterOffsetA and FilterOffsetB of the slice containing macroblock q. For
+// luma edges qPp and qPq are QPY, and for chroma edges the QPc of the chroma
+// component, see chromaQP; either is 0 for an I_PCM macroblock or for a
+// macroblock coded losslessly with QP'Y of 0, as the caller must establish.
+func newEdgeFilter(qPp, qPq, filterOffsetA, filterOffsetB, bitDepth int, chromaStyle bool) edgeFilter {
+	qPav := (qPp + qPq + 1) >> 1
+	indexA := Clip3(0, 51, qPav+filterOffsetA)
+	indexB := Clip3(0, 51, qPav+filterOffsetB)
+	return edgeFilter{
+		indexA:      indexA,
+		alpha:       alphaTable[indexA] << uint(bitDepth-8),
+		beta:        betaTable[indexB] << uint(bitDepth-8),
+		bitDepth:    bitDepth,
+		chromaStyle: chromaStyle,
+	}
+}
+
+// filterSamples filters a line of samples across an edge with boundary
+// filtering strength bS (8.7.2.3 and 8.7.2.4). p holds the samples p0 to p3
+// on one side of the edge, nearest first, and q the samples q0 to q3 on the
+// other. Filtered samples are replaced in place. p3 and q3 are never
+// modified, nor are p2, q2, p1 and q1 for chroma style filtering.
+func (f *edgeFilter) filterSamples(p, q *[4]int, bS int) {
+	if bS == 0 || abs(p[0]-q[0]) >= f.alpha || abs(p[1]-p[0]) >= f.beta || abs(q[1]-q[0]) >= f.beta {
+		return
+	}
+	ap := abs(p[2] - p[0])
+	aq := abs(q[2] - q[0])
+
+	if bS < 4 {
+		tc0 := tc0Table[f.indexA][bS-1] << uint(f.bitDepth-8)
+		tc := tc0 + 1
+		if !f.chromaStyle {
+			tc = tc0 + flagVal(ap < f.beta) + flagVal(aq < f.beta)
+		}
+		delta := Clip3(-tc, tc, (((q[0]-p[0])<<2)+(p[1]-q[1])+4)>>3)
+		p0, q0 := p[0], q[0]
+		p[0] = Clip1y(p0+delta, f.bitDepth)
+		q[0] = Clip1y(q0-delta, f.bitDepth)
+		if !f.chromaStyle && ap < f.beta {
+			p[1] += Clip3(-tc0, tc0, (p[2]+((p0+q0+1)>>1)-(p[1]<<1))>>1)
+		}
+		if !f.chromaStyle && aq < f.beta {
+			q[1] += Clip3(-tc0, tc0, (q[2]+((p0+q0+1)>>1)-(q[1]<<1))>>1)
+		}
+		return
+	}
+
+	strong := !f.chromaStyle && abs(p[0]-q[0]) < (f.alpha>>2)+2
+	p0, p1, p2 := p[0], p[1], p[2]
+	q0, q1, q2 := q[0], q[1], q[2]
+	if strong && ap < f.beta {
+		p[0] = (p2 + 2*p1 + 2*p0 + 2*q0 + q1 + 4) >> 3
+		p[1] = (p2 + p1 + p0 + q0 + 2) >> 2
+		p[2] = (2*p[3] + 3*p2 + p1 + p0 + q0 + 4) >> 3
+	} else {
+		p[0] = (2*p1 + p0 + q1 + 2) >> 2
+	}
+	if strong && aq < f.beta {
+		q[0] = (p1 + 2*p0 + 2*q0 + 2*q1 + q2 + 4) >> 3
+		q[1] = (p0 + q0 + q1 + q2 + 2) >> 2
+		q[2] = (2*q[3] + 3*q2 + q1 + q0 + p0 + 4) >> 3
+	} else {
+		q[0] = (2*q1 + q0 + p1 + 2) >> 2
+	}
+}
+
+// filterEdge filters the samples of an edge of a macroblock in plane, a plane
+// of 8 bit samples with the given stride such as that of an image.YCbCr. The
+// edge begins with sample q0 at (x, y) and is a vertical edge, i.e. between
+// columns x-1 and x, if vertical, otherwise a horizontal edge between rows y-1
+// and y. It is n samples long, being 16 for luma and, for chroma, MbHeightC
+// for vertical edges or MbWidthC for horizontal edges.
+//
+// bS holds the boundary filtering strengths of the corresponding luma edge,
+// one for each 4 luma samples along it. The strength used for a sample is that
+// of the corresponding luma sample (8.7.2), being that at sub times its index
+// along the edge, where sub is 1 for luma and, for chroma, SubHeightC for
+// vertical edges or SubWidthC for horizontal edges.
+func filterEdge(plane []byte, stride, x, y int, vertical bool, n, sub int, bS [4]int, f *edgeFilter) {
+	// Step between samples across and along the edge.
+	across, along := stride, 1
+	if vertical {
+		across, along = 1, stride
+	}
+	var p, q [4]int
+	for i := 0; i < n; i++ {
+		q0 := y*stride + x + i*along
+		for k := 0; k < 4; k++ {
+			p[k] = int(plane[q0-(k+1)*across])
+			q[k] = int(plane[q0+k*across])
+		}
+		f.filterSamples(&p, &q, bS[i*sub/4])
+		for k := 0; k < 3; k++ {
+			plane[q0-(k+1)*across] = byte(p[k])
+			plane[q0+k*across] = byte(q[k])
+		}
+	}
+}
+
+// chromaEdge is an edge of the chroma samples of a macroblock.
+type chromaEdge struct {
+	pos      int // Position in chroma samples from the left or top of the macroblock.
+	lumaEdge int // Index of the corresponding luma edge, from 0 to 3, for bS.
+}
+
+// chromaEdges returns the vertical or horizontal chroma edges of a
+// macroblock, including the macroblock edge at 0, for the given chroma array
+// type (8.7). For 4:2:0 and 4:2:2 video, chroma is transformed in 4x4 blocks
+// regardless of transform_size_8x8_flag, so the edges are every 4 chroma
+// samples, corresponding to the luma edges at the same position once scaled
+// by SubWidthC or SubHeightC; as chroma of 4:2:2 video is full height, it has
+// four horizontal edges, using the bS of each luma edge. For 4:4:4 video
+// chroma edges are those of luma, being every 8 samples if transform8x8 is
+// set. There are none for monochrome video.
+func chromaEdges(chromaArrayType int, vertical, transform8x8 bool) []chromaEdge {
+	switch chromaArrayType {
+	case chroma420:
+		return []chromaEdge{{0, 0}, {4, 2}}
+	case chroma422:
+		if vertical {
+			return []chromaEdge{{0, 0}, {4, 2}}
+		}
+		return []chromaEdge{{0, 0}, {4, 1}, {8, 2}, {12, 3}}
+	case chroma444:
+		if transform8x8 {
+			return []chromaEdge{{0, 0}, {8, 2}}
+		}
+		return []chromaEdge{{0, 0}, {4, 1}, {8, 2}, {12, 3}}
+	default:
+		return nil
+	}
+}
+
+// sliceDeblocking holds the parameters of a slice used in filtering the edges
+// of its macroblocks.
+type sliceDeblocking struct {
+	disable        int    // disable_deblocking_filter_idc.
+	filterOffsetA  int    // FilterOffsetA, of alpha.
+	filterOffsetB  int    // FilterOffsetB, of beta.
+	chromaQPOffset [2]int // chroma_qp_index_offset and second_chroma_qp_index_offset.
+	switching      bool   // SP or SI slice, whose macroblocks are filtered as intra.
+}
+
+// newSliceDeblocking returns the deblocking parameters of the slice of ctx.
+func newSliceDeblocking(ctx *SliceContext) sliceDeblocking {
+	h := ctx.Slice.Header
+	sliceType := sliceTypeMap[h.SliceType]
+	return sliceDeblocking{
+		disable:        h.DisableDeblockingFilter,
+		filterOffsetA:  h.SliceAlphaC0OffsetDiv2 << 1,
+		filterOffsetB:  h.SliceBetaOffsetDiv2 << 1,
+		chromaQPOffset: [2]int{ctx.PPS.ChromaQpIndexOffset, ctx.PPS.SecondChromaQpIndexOffset},
+		switching:      sliceType == "SP" || sliceType == "SI",
+	}
+}
+
+// deblocker applies the deblocking filter process to the macroblocks of a
+// decoded picture.
+type deblocker struct {
+	pic    *image.YCbCr
+	sps    *SPS
+	mbs    *mbState
+	slices []sliceDeblocking // Indexed by slice number, see mbState.startSlice.
+	lists  []refPicLists     // Reference picture lists, by slice number.
+
+	field, bottom   bool // field_pic_flag and bottom_field_flag.
+	chromaArrayType int
+}
+
+// deblockPicture applies the deblocking filter process (8.7) to the
+// macroblocks of the picture, using sps, whose first slice has header h, and
+// whose samples are held in pic, of 8 bit samples. Macroblocks are filtered in
+// order of address, their vertical edges before their horizontal edges,
+// subject to the disable_deblocking_filter_idc and filter offsets of their
+// slices, slices being those of mbs, with reference picture lists lists.
+// Macroblocks not decoded are neither filtered nor filtered against. Pictures
+// with separate colour planes are not filtered, being flagged as degraded by
+// arena.finish.
+func deblockPicture(pic *image.YCbCr, sps *SPS, h *SliceHeader, mbs *mbState, slices []sliceDeblocking, lists []refPicLists) {
+	if sps.UseSeparateColorPlane {
+		return
+	}
+	d := &deblocker{
+		pic:             pic,
+		sps:             sps,
+		mbs:             mbs,
+		slices:          slices,
+		lists:           lists,
+		field:           h.FieldPic,
+		bottom:          h.BottomField,
+		chromaArrayType: h.ChromaArrayType,
+	}
+	for mbAddr := 0; mbAddr < mbs.n; mbAddr++ {
+		if mbs.sliceNum[mbAddr] >= 0 && int(mbs.sliceNum[mbAddr]) < len(slices) {
+			d.filterMb(mbAddr)
+		}
+	}
+}
+
+// filterMb filters the edges of the macroblock with address mbAddr, which
+// must have been decoded. In MBAFF frames, macroblock edges between a field
+// and a frame macroblock (mixedModeEdgeFlag) are filtered by filterMixedEdge
+// if vertical, and by filterFieldPairEdge if the top edge of a frame
+// macroblock.
+func (d *deblocker) filterMb(mbAddr int) {
+	s := d.slices[d.mbs.sliceNum[mbAddr]]
+	if s.disable == 1 {
+		return
+	}
+	mbAddrA, mbAddrB := d.neighbours(mbAddr, s)
+	transform8x8 := d.mbs.has(mbAddr, mbTransform8x8)
+	for _, vertical := range [2]bool{true, false} {
+		mbAddrN := mbAddrB
+		if vertical {
+			mbAddrN = mbAddrA
+		}
+		mixed := mbAddrN != MbAddrNotAvailable && d.fieldMb(mbAddrN) != d.fieldMb(mbAddr)
+		switch {
+		case mixed && vertical:
+			d.filterMixedEdge(mbAddr, mbAddrN&^1, s)
+			mbAddrN = MbAddrNotAvailable
+		case mixed && !d.fieldMb(mbAddr):
+			d.filterFieldPairEdge(mbAddr, mbAddrN, s)
+			mbAddrN = MbAddrNotAvailable
+		}
+
+		var bS [4][4]int
+		for edge := range bS {
+			mbAddrP := mbAddr
+			if edge == 0 {
+				mbAddrP = mbAddrN
+			}
+			if mbAddrP != MbAddrNotAvailable {
+				bS[edge] = d.boundaryStrengths(mbAddrP, mbAddr, vertical, edge, edge == 0 && mixed)
+			}
+		}
+
+		for edge := range bS {
+			if edge == 0 && mbAddrN == MbAddrNotAvailable || transform8x8 && edge%2 == 1 {
+				continue
+			}
+			d.filterLumaEdge(mbAddr, mbAddrN, vertical, edge, bS[edge], s)
+		}
+		for _, e := range chromaEdges(d.chromaArrayType, vertical, transform8x8) {
+			if e.pos == 0 && mbAddrN == MbAddrNotAvailable {
+				continue
+			}
+			for comp := 1; comp < 3; comp++ {
+				d.filterChromaEdge(mbAddr, mbAddrN, comp, vertical, e, bS[e.lumaEdge], s)
+			}
+		}
+	}
+}
+
+// neighbours returns the addresses of the macroblocks across the left and top
+// macroblock edges of the macroblock with address mbAddr, of a slice with
+// deblocking parameters s, or MbAddrNotAvailable for edges not filtered, see
+// filterNeighbour. In MBAFF frames mbAddrA is the macroblock at the same
+// position in the pair to the left, and mbAddrB that holding the samples
+// above the first line of mbAddr, being the top macroblock of its own pair
+// for the bottom frame macroblock of a pair, and the bottom macroblock of the
+// pair above for a top frame macroblock, whatever that pair (6.4.12.2). Field
+// macroblocks of the top row of pairs have no top edge.
+func (d *deblocker) neighbours(mbAddr int, s sliceDeblocking) (mbAddrA, mbAddrB int) {
+	w := d.mbs.widthMbs
+	mbAddrA, mbAddrB = MbAddrNotAvailable, MbAddrNotAvailable
+	if !d.mbs.mbaff {
+		if mbAddr%w != 0 {
+			mbAddrA = d.filterNeighbour(mbAddr-1, mbAddr, s)
+		}
+		if mbAddr >= w {
+			mbAddrB = d.filterNeighbour(mbAddr-w, mbAddr, s)
+		}
+		return mbAddrA, mbAddrB
+	}
+
+	pair, bottom := mbAddr/2, mbAddr%2
+	if pair%w != 0 {
+		mbAddrA = d.filterNeighbour(2*(pair-1)+bottom, mbAddr, s)
+	}
+	switch {
+	case bottom == 1 && !d.fieldMb(mbAddr):
+		mbAddrB = d.filterNeighbour(mbAddr-1, mbAddr, s)
+	case pair >= w:
+		mbAddrB = 2*(pair-w) + 1
+		if d.fieldMb(mbAddr) && d.fieldMb(mbAddrB) {
+			mbAddrB -= 1 - bottom
+		}
+		mbAddrB = d.filterNeighbour(mbAddrB, mbAddr, s)
+	}
+	return mbAddrA, mbAddrB
+}
+
+// filterNeighbour returns mbAddrN if the edge between it and the macroblock
+// with address currMbAddr, of a slice with deblocking parameters s, is
+// filtered, being decoded and, when disable_deblocking_filter_idc is 2, in
+// the same slice, and otherwise MbAddrNotAvailable.
+func (d *deblocker) filterNeighbour(mbAddrN, currMbAddr int, s sliceDeblocking) int {
+	sliceNum := d.mbs.sliceNum[mbAddrN]
+	if sliceNum < 0 || s.disable == 2 && sliceNum != d.mbs.sliceNum[currMbAddr] {
+		return MbAddrNotAvailable
+	}
+	return mbAddrN
+}
+
+// filterLumaEdge filters the luma edge with index edge, from 0 to 3, of the
+// macroblock with address mbAddr, mbAddrN being the macroblock across edge 0.
+func (d *deblocker) filterLumaEdge(mbAddr, mbAddrN int, vertical bool, edge int, bS [4]int, s sliceDeblocking) {
+	mbAddrP := mbAddr
+	if edge == 0 {
+		mbAddrP = mbAddrN
+	}
+	f := d.lumaFilter(mbAddrP, mbAddr, s)
+	plane, stride, x, y := d.mbSamples(0, mbAddr, 16, 16)
+	x, y = edgePosition(x, y, vertical, 4*edge)
+	filterEdge(plane, stride, x, y, vertical, 16, 1, bS, &f)
+}
+
+// filterChromaEdge filters the chroma edge e of component comp, 1 for Cb or 2
+// for Cr, of the macroblock with address mbAddr, mbAddrN being the macroblock
+// across the edge at 0. bS are those of the corresponding luma edge.
+func (d *deblocker) filterChromaEdge(mbAddr, mbAddrN, comp int, vertical bool, e chromaEdge, bS [4]int, s sliceDeblocking) {
+	mbAddrP := mbAddr
+	if e.pos == 0 {
+		mbAddrP = mbAddrN
+	}
+	f := d.chromaFilter(mbAddrP, mbAddr, comp, s)
+	w, h := MbWidthC(d.sps), MbHeightC(d.sps)
+	n, sub := w, SubWidthC(d.sps)
+	if vertical {
+		n, sub = h, SubHeightC(d.sps)
+	}
+	plane, stride, x, y := d.mbSamples(comp, mbAddr, w, h)
+	x, y = edgePosition(x, y, vertical, e.pos)
+	filterEdge(plane, stride, x, y, vertical, n, sub, bS, &f)
+}
+
+// filterMixedEdge filters the left macroblock edge of the macroblock with
+// address mbAddr of an MBAFF frame, where the pair to its left, with top
+// macroblock mbAddrX, is a field pair and mbAddr a frame macroblock, or the
+// reverse. The macroblock holding p0 then alternates along the edge, so it is
+// filtered a line at a time, in effect in 8 parts of alternating
+// macroblocks, with the bS and qPp of each line derived from the macroblock
+// it meets. The bS of each chroma line are those of the corresponding luma
+// line (8.7.2).
+func (d *deblocker) filterMixedEdge(mbAddr, mbAddrX int, s sliceDeblocking) {
+	var bS [16]int
+	plane, stride, x, y := d.mbSamples(0, mbAddr, 16, 16)
+	for k := range bS {
+		mbAddrP, yP := d.leftLine(mbAddr, mbAddrX, k, 16)
+		bS[k] = d.strength(mbAddrP, 15, yP, mbAddr, 0, k, true, true, true)
+		f := d.lumaFilter(mbAddrP, mbAddr, s)
+		filterEdge(plane, stride, x, y+k, true, 1, 1, [4]int{bS[k]}, &f)
+	}
+	if d.chromaArrayType == chromaMonochrome {
+		return
+	}
+
+	w, h, sub := MbWidthC(d.sps), MbHeightC(d.sps), SubHeightC(d.sps)
+	for comp := 1; comp < 3; comp++ {
+		plane, stride, x, y := d.mbSamples(comp, mbAddr, w, h)
+		for k := 0; k < h; k++ {
+			mbAddrP, _ := d.leftLine(mbAddr, mbAddrX, k, h)
+			f := d.chromaFilter(mbAddrP, mbAddr, comp, s)
+			filterEdge(plane, stride, x, y+k, true, 1, 1, [4]int{bS[k*sub]}, &f)
+		}
+	}
+}
+
+// leftLine returns the address of the macroblock of the pair to the left,
+// with top macroblock mbAddrX, holding the samples left of line k of the
+// macroblock with address mbAddr in an MBAFF frame, for macroblocks of h
+// lines, and the line of those samples in that macroblock.
+func (d *deblocker) leftLine(mbAddr, mbAddrX, k, h int) (mbAddrP, yP int) {
+	r := mbAddr%2*h + k // Line of the pair.
+	if d.fieldMb(mbAddr) {
+		r = 2*k + mbAddr%2
+	}
+	if d.fieldMb(mbAddrX) {
+		return mbAddrX + r%2, r / 2
+	}
+	return mbAddrX + r/h, r % h
+}
+
+// filterFieldPairEdge filters the top macroblock edge of the top frame
+// macroblock with address mbAddr of an MBAFF frame over a pair of field
+// macroblocks, the bottom of which has address mbAddrB. The edge is filtered
+// twice, as an edge of each field: the lines of mbAddr of each parity against
+// the last lines of the field macroblock of that parity (8.7).
+func (d *deblocker) filterFieldPairEdge(mbAddr, mbAddrB int, s sliceDeblocking) {
+	w, h := MbWidthC(d.sps), MbHeightC(d.sps)
+	for parity := 0; parity < 2; parity++ {
+		mbAddrP := mbAddrB - 1 + parity
+		bS := d.boundaryStrengths(mbAddrP, mbAddr, false, 0, true)
+		f := d.lumaFilter(mbAddrP, mbAddr, s)
+		plane, stride := d.plane(0, true, parity)
+		x, y := d.position(mbAddr, 16, 16, true)
+		filterEdge(plane, stride, x, y, false, 16, 1, bS, &f)
+		if d.chromaArrayType == chromaMonochrome {
+			continue
+		}
+		for comp := 1; comp < 3; comp++ {
+			f := d.chromaFilter(mbAddrP, mbAddr, comp, s)
+			plane, stride := d.plane(comp, true, parity)
+			x, y := d.position(mbAddr, w, h, true)
+			filterEdge(plane, stride, x, y, false, w, SubWidthC(d.sps), bS, &f)
+		}
+	}
+}
+
+// lumaFilter returns the edgeFilter for luma edges between the macroblocks
+// with addresses mbAddrP and mbAddrQ, of a slice with deblocking parameters s.
+func (d *deblocker) lumaFilter(mbAddrP, mbAddrQ int, s sliceDeblocking) edgeFilter {
+	return newEdgeFilter(d.qp(mbAddrP), d.qp(mbAddrQ), s.filterOffsetA, s.filterOffsetB, 8, false)
+}
+
+// chromaFilter returns the edgeFilter for edges of chroma component comp, 1
+// for Cb or 2 for Cr, between the macroblocks with addresses mbAddrP and
+// mbAddrQ, of a slice with deblocking parameters s.
+func (d *deblocker) chromaFilter(mbAddrP, mbAddrQ, comp int, s sliceDeblocking) edgeFilter {
+	offset := s.chromaQPOffset[comp-1]
+	qPp, qPq := chromaQP(d.qp(mbAddrP), offset, 0), chromaQP(d.qp(mbAddrQ), offset, 0)
+	return newEdgeFilter(qPp, qPq, s.filterOffsetA, s.filterOffsetB, 8, chromaStyleFiltering(true, d.chromaArrayType))
+}
+
+// qp returns the QPY of the macroblock with address mbAddr as used in
+// filtering, being 0 for I_PCM macroblocks and for macroblocks coded
+// losslessly, when qpprime_y_zero_transform_bypass_flag is set and QP'Y is 0
+// (8.7.2.2).
+func (d *deblocker) qp(mbAddr int) int {
+	qpY := int(d.mbs.qpY[mbAddr])
+	if d.mbs.has(mbAddr, mbPCM) || d.sps.QPrimeYZeroTransformBypass && qpY+qpBdOffsetY(d.sps) == 0 {
+		return 0
+	}
+	return qpY
+}
+
+// fieldMb returns true if the macroblock with address mbAddr is a field
+// macroblock of an MBAFF frame.
+func (d *deblocker) fieldMb(mbAddr int) bool {
+	return d.mbs.has(mbAddr, mbFieldDecoded)
+}
+
+// mbSamples returns the samples of colour component comp holding the
+// macroblock with address mbAddr, of macroblocks w by h samples, with the
+// stride between their rows and the location of its top left sample in them.
+// These are the samples of a field for field pictures and for field
+// macroblocks of MBAFF frames.
+func (d *deblocker) mbSamples(comp, mbAddr, w, h int) (plane []byte, stride, x, y int) {
+	field, parity := d.field, flagVal(d.bottom)
+	if d.mbs.mbaff {
+		field, parity = d.fieldMb(mbAddr), mbAddr%2
+	}
+	plane, stride = d.plane(comp, field, parity)
+	x, y = d.position(mbAddr, w, h, field)
+	return plane, stride, x, y
+}
+
+// plane returns the samples of colour component comp of the picture, or if
+// field is set those of its field of the given parity, 0 for top or 1 for
+// bottom, and the stride between their rows.
+func (d *deblocker) plane(comp int, field bool, parity int) ([]byte, int) {
+	plane, stride := d.pic.Y, d.pic.YStride
+	if comp != 0 {
+		plane, stride = [2][]byte{d.pic.Cb, d.pic.Cr}[comp-1], d.pic.CStride
+	}
+	if field {
+		return plane[parity*stride:], 2 * stride
+	}
+	return plane, stride
+}
+
+// position returns the location of the top left sample of the macroblock
+// with address mbAddr, of macroblocks w by h samples, in the samples of the
+// picture, or if field is set in those of a field, see plane. In MBAFF frames
+// the location in a field is that of the first line of the pair of that
+// field.
+func (d *deblocker) position(mbAddr, w, h int, field bool) (x, y int) {
+	widthMbs := d.mbs.widthMbs
+	if !d.mbs.mbaff {
+		return mbAddr % widthMbs * w, mbAddr / widthMbs * h
+	}
+	pair := mbAddr / 2
+	x, y = pair%widthMbs*w, pair/widthMbs*2*h
+	if field {
+		return x, y / 2
+	}
+	return x, y + mbAddr%2*h
+}
+
+// edgePosition returns the location of sample q0 of the first line of the
+// vertical or horizontal edge at pos samples from the left or top of a
+// macroblock whose top left sample is at (x, y).
+func edgePosition(x, y int, vertical bool, pos int) (int, int) {
+	if vertical {
+		return x + pos, y
+	}
+	return x, y + pos
+}
+
+// boundaryStrengths returns the bS of each 4 luma samples along the luma
+// edge with index edge of the macroblock q with address mbAddrQ, the samples
+// p being those of the macroblock with address mbAddrP, mixed being set for
+// macroblock edges between a field and a frame macroblock of an MBAFF frame
+// (mixedModeEdgeFlag). Samples p0 are taken to be in the last line or column
+// of mbAddrP for macroblock edges, which for the top edges of field
+// macroblocks over frame macroblocks holds for the block containing them.
+func (d *deblocker) boundaryStrengths(mbAddrP, mbAddrQ int, vertical bool, edge int, mixed bool) [4]int {
+	var bS [4]int
+	for i := range bS {
+		xQ, yQ := 4*i, 4*edge
+		if vertical {
+			xQ, yQ = 4*edge, 4*i
+		}
+		xP, yP := xQ, yQ-1
+		if vertical {
+			xP, yP = xQ-1, yQ
+		}
+		xP, yP = (xP+16)%16, (yP+16)%16
+		bS[i] = d.strength(mbAddrP, xP, yP, mbAddrQ, xQ, yQ, vertical, edge == 0, mixed)
+	}
+	return bS
+}
+
+// strength returns the bS of the edge between the luma samples p0, at (xP,
+// yP) of the macroblock with address mbAddrP, and q0, at (xQ, yQ) of that with
+// address mbAddrQ, as derived by 8.7.2.1, mbEdge being set for macroblock
+// edges and mixed as for boundaryStrengths. Horizontal macroblock edges of
+// field macroblocks, and of field pictures, have a bS of 3 rather than 4 for
+// intra macroblocks.
+func (d *deblocker) strength(mbAddrP, xP, yP, mbAddrQ, xQ, yQ int, vertical, mbEdge, mixed bool) int {
+	intra := d.intra(mbAddrP) || d.intra(mbAddrQ)
+	frame := !d.field && !d.fieldMb(mbAddrP) && !d.fieldMb(mbAddrQ)
+	switch {
+	case intra && mbEdge && (frame || vertical):
+		return 4
+	case intra:
+		return 3
+	case d.nonZeroCoeffs(mbAddrP, xP, yP) || d.nonZeroCoeffs(mbAddrQ, xQ, yQ):
+		return 2
+	case mixed || d.differentMotion(mbAddrP, xP, yP, mbAddrQ, xQ, yQ):
+		return 1
+	}
+	return 0
+}
+
+// intra returns true if the macroblock with address mbAddr is filtered as an
+// intra macroblock, being intra coded or in an SP or SI slice.
+func (d *deblocker) intra(mbAddr int) bool {
+	return d.mbs.has(mbAddr, mbIntraCoded) || d.slices[d.mbs.sliceNum[mbAddr]].switching
+}
+
+// nonZeroCoeffs returns true if the transform block containing the luma
+// sample (x, y) of the macroblock with address mbAddr has non-zero transform
+// coefficient levels, being an 8x8 block if transform_size_8x8_flag is set.
+// When ChromaArrayType is 3, the Cb and Cr blocks at the same location are
+// also considered.
+func (d *deblocker) nonZeroCoeffs(mbAddr, x, y int) bool {
+	comps := 1
+	if d.chromaArrayType == chroma444 {
+		comps = 3
+	}
+	blkIdx, n := luma4x4BlkIdx(x, y), 1
+	if d.mbs.has(mbAddr, mbTransform8x8) {
+		blkIdx, n = 4*luma8x8BlkIdx(x, y), 4
+	}
+	for comp := 0; comp < comps; comp++ {
+		for _, total := range d.mbs.totalCoeff[comp][mbAddr*blocksPerMb+blkIdx:][:n] {
+			if total != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// differentMotion returns true if the partitions containing the luma samples
+// (xP, yP) of the macroblock with address mbAddrP and (xQ, yQ) of that with
+// address mbAddrQ use different reference pictures or numbers of motion
+// vectors, or corresponding motion vectors differing by 4 or more in units of
+// quarter luma frame samples, being 2 vertically in units of quarter field
+// samples. Reference pictures are compared regardless of the list or
+// reference index referring to them, see refPic. Where each partition uses
+// two different pictures, the motion vectors for the same picture correspond,
+// and where each uses the same picture twice, they differ if they do when
+// corresponding in either order.
+func (d *deblocker) differentMotion(mbAddrP, xP, yP, mbAddrQ, xQ, yQ int) bool {
+	limitY := 4
+	if d.field || d.fieldMb(mbAddrQ) {
+		limitY = 2
+	}
+	differs := func(a, b motionVector) bool {
+		return abs(int(a.X)-int(b.X)) >= 4 || abs(int(a.Y)-int(b.Y)) >= limitY
+	}
+	picP, mvP, n := d.motion(mbAddrP, xP, yP)
+	picQ, mvQ, nQ := d.motion(mbAddrQ, xQ, yQ)
+	switch {
+	case n != nQ:
+		return true
+	case n == 0:
+		return false
+	case n == 1:
+		return picP[0] != picQ[0] || differs(mvP[0], mvQ[0])
+	case picP[0] != picP[1]:
+		switch {
+		case picP[0] == picQ[0] && picP[1] == picQ[1]:
+			return differs(mvP[0], mvQ[0]) || differs(mvP[1], mvQ[1])
+		case picP[0] == picQ[1] && picP[1] == picQ[0]:
+			return differs(mvP[0], mvQ[1]) || differs(mvP[1], mvQ[0])
+		}
+		return true
+	case picQ[0] != picQ[1] || picQ[0] != picP[0]:
+		return true
+	}
+	return (differs(mvP[0], mvQ[0]) || differs(mvP[1], mvQ[1])) &&
+		(differs(mvP[0], mvQ[1]) || differs(mvP[1], mvQ[0]))
+}
+
+// motion returns the reference pictures and motion vectors of the partition
+// containing the luma sample (x, y) of the macroblock with address mbAddr,
+// for each list it is predicted from in order, with their number.
+func (d *deblocker) motion(mbAddr, x, y int) (pics [2]refPic, mvs [2]motionVector, n int) {
+	for list := range d.mbs.refIdx {
+		refIdx := int(d.mbs.refIdx[list][mbAddr*partitionsPerMb+luma8x8BlkIdx(x, y)])
+		if refIdx < 0 {
+			continue
+		}
+		pics[n] = d.refPic(mbAddr, list, refIdx)
+		mvs[n] = d.mbs.mv[list][mbAddr*blocksPerMb+luma4x4BlkIdx(x, y)]
+		n++
+	}
+	return pics, mvs, n
+}
+
+// refPic identifies the reference picture of a partition: the frame and, for
+// field macroblocks of MBAFF frames, the parity of its field, 1 for the top
+// field and 2 for the bottom. Where the reference picture lists of the slice
+// of the partition are not known, see refPicLists, the picture is identified
+// only by the slice, list and reference index, so partitions of different
+// slices or lists are taken to use different pictures.
+type refPic struct {
+	frame  *refFrame
+	parity int8
+	slice  int32
+	list   int8
+	refIdx int8
+}
+
+// refPic returns the reference picture with reference index refIdx in the
+// given list of the slice of the macroblock with address mbAddr. Field
+// macroblocks refer to the field of frame refIdx/2 of the same parity as the
+// macroblock for even refIdx and of the opposite parity for odd (8.2.4.2.5).
+func (d *deblocker) refPic(mbAddr, list, refIdx int) refPic {
+	sliceNum := d.mbs.sliceNum[mbAddr]
+	field := d.fieldMb(mbAddr)
+	i := refIdx
+	if field {
+		i = refIdx / 2
+	}
+	if int(sliceNum) < len(d.lists) {
+		l := &d.lists[sliceNum]
+		if l.err == nil && i < len(l.list[list]) {
+			p := refPic{frame: l.list[list][i]}
+			if field {
+				p.parity = int8(1 + (mbAddr%2 ^ refIdx%2))
+			}
+			return p
+		}
+	}
+	return refPic{slice: sliceNum, list: int8(list), refIdx: int8(refIdx)}
+}
@@ -0,0 +1,570 @@
+/*
+NAME
+  deblock_test.go
+
+DESCRIPTION
+  deblock_test.go provides testing for functionality provided in deblock.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"image"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestChromaQP(t *testing.T) {
+	tests := []struct {
+		qpY, offset, qpBdOffsetC int
+		want                     int
+	}{
+		{qpY: 20, want: 20},
+		{qpY: 30, want: 29},
+		{qpY: 34, offset: 2, want: 34},
+		{qpY: 51, want: 39},
+		{qpY: 40, offset: 12, want: 39},
+		{qpY: 0, offset: -12, want: 0},
+		{qpY: 0, offset: -12, qpBdOffsetC: 12, want: -12},
+	}
+
+	for i, test := range tests {
+		got := chromaQP(test.qpY, test.offset, test.qpBdOffsetC)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestFilterSamples(t *testing.T) {
+	p := [4]int{60, 62, 64, 64}
+	q := [4]int{70, 70, 70, 70}
+
+	// With QP 36, alpha is 50, beta is 11 and tC0 for bS 2 is 3.
+	tests := []struct {
+		qp          int
+		bS          int
+		chromaStyle bool
+		p, q        [4]int
+		wantP       [4]int
+		wantQ       [4]int
+	}{
+		{qp: 36, bS: 2, p: p, q: q, wantP: [4]int{64, 64, 64, 64}, wantQ: [4]int{66, 67, 70, 70}},
+		{qp: 36, bS: 2, chromaStyle: true, p: p, q: q, wantP: [4]int{64, 62, 64, 64}, wantQ: [4]int{66, 70, 70, 70}},
+		{qp: 36, bS: 4, p: p, q: q, wantP: [4]int{65, 64, 64, 64}, wantQ: [4]int{67, 68, 69, 70}},
+		{qp: 36, bS: 4, chromaStyle: true, p: p, q: q, wantP: [4]int{64, 62, 64, 64}, wantQ: [4]int{68, 70, 70, 70}},
+		{qp: 36, bS: 0, p: p, q: q, wantP: p, wantQ: q},
+		{qp: 36, bS: 2, p: p, q: [4]int{120, 120, 120, 120}, wantP: p, wantQ: [4]int{120, 120, 120, 120}},
+		{qp: 10, bS: 4, p: p, q: q, wantP: p, wantQ: q},
+	}
+
+	for i, test := range tests {
+		f := newEdgeFilter(test.qp, test.qp, 0, 0, 8, test.chromaStyle)
+		gotP, gotQ := test.p, test.q
+		f.filterSamples(&gotP, &gotQ, test.bS)
+		if gotP != test.wantP || gotQ != test.wantQ {
+			t.Errorf("did not get expected result for test: %d\nGot: %v, %v\nWant: %v, %v", i, gotP, gotQ, test.wantP, test.wantQ)
+		}
+	}
+}
+
+func TestChromaEdges(t *testing.T) {
+	tests := []struct {
+		chromaArrayType int
+		vertical        bool
+		transform8x8    bool
+		want            []chromaEdge
+	}{
+		{chromaArrayType: chromaMonochrome, vertical: true},
+		{chromaArrayType: chroma420, vertical: true, want: []chromaEdge{{0, 0}, {4, 2}}},
+		{chromaArrayType: chroma420, want: []chromaEdge{{0, 0}, {4, 2}}},
+		{chromaArrayType: chroma422, vertical: true, want: []chromaEdge{{0, 0}, {4, 2}}},
+		{chromaArrayType: chroma422, want: []chromaEdge{{0, 0}, {4, 1}, {8, 2}, {12, 3}}},
+		{chromaArrayType: chroma422, transform8x8: true, want: []chromaEdge{{0, 0}, {4, 1}, {8, 2}, {12, 3}}},
+		{chromaArrayType: chroma444, vertical: true, want: []chromaEdge{{0, 0}, {4, 1}, {8, 2}, {12, 3}}},
+		{chromaArrayType: chroma444, transform8x8: true, want: []chromaEdge{{0, 0}, {8, 2}}},
+	}
+
+	for i, test := range tests {
+		got := chromaEdges(test.chromaArrayType, test.vertical, test.transform8x8)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestFilterEdge checks the filtering of a chroma macroblock edge for each
+// chroma format, across a step from 60 to 70 with QP 36, including the use of
+// the bS of the corresponding luma samples and the luma style filtering of
+// 4:4:4 chroma.
+func TestFilterEdge(t *testing.T) {
+	type sample struct {
+		x, y int
+		want byte
+	}
+	tests := []struct {
+		name            string
+		chromaArrayType int
+		w, h            int // Size of the plane, holding two macroblocks.
+		vertical        bool
+		n, sub          int
+		bS              [4]int
+		samples         []sample
+	}{
+		{
+			name:            "4:2:0 vertical",
+			chromaArrayType: chroma420,
+			w:               16, h: 8, vertical: true, n: 8, sub: 2,
+			bS: [4]int{0, 0, 2, 2},
+			samples: []sample{
+				{6, 3, 60}, {7, 3, 60}, {8, 3, 70}, {9, 3, 70},
+				{6, 4, 60}, {7, 4, 64}, {8, 4, 66}, {9, 4, 70},
+				{7, 7, 64}, {8, 7, 66},
+			},
+		},
+		{
+			name:            "4:2:2 vertical",
+			chromaArrayType: chroma422,
+			w:               16, h: 16, vertical: true, n: 16, sub: 1,
+			bS: [4]int{0, 2, 0, 2},
+			samples: []sample{
+				{7, 3, 60}, {8, 3, 70},
+				{7, 4, 64}, {8, 4, 66},
+				{7, 8, 60}, {8, 11, 70},
+				{6, 15, 60}, {7, 15, 64}, {8, 15, 66}, {9, 15, 70},
+			},
+		},
+		{
+			name:            "4:2:2 horizontal",
+			chromaArrayType: chroma422,
+			w:               8, h: 32, n: 8, sub: 2,
+			bS: [4]int{2, 0, 0, 0},
+			samples: []sample{
+				{0, 15, 64}, {0, 16, 66}, {1, 15, 64}, {1, 16, 66},
+				{0, 14, 60}, {0, 17, 70},
+				{2, 15, 60}, {2, 16, 70},
+			},
+		},
+		{
+			name:            "4:4:4 vertical",
+			chromaArrayType: chroma444,
+			w:               32, h: 16, vertical: true, n: 16, sub: 1,
+			bS: [4]int{2, 2, 2, 2},
+			samples: []sample{
+				{13, 0, 60}, {14, 0, 62}, {15, 0, 64}, {16, 0, 66}, {17, 0, 67}, {18, 0, 70},
+				{14, 15, 62}, {17, 15, 67},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		plane := make([]byte, test.w*test.h)
+		x, y := test.w/2, 0
+		if !test.vertical {
+			x, y = 0, test.h/2
+		}
+		for i := range plane {
+			plane[i] = 60
+			if test.vertical && i%test.w >= x || !test.vertical && i/test.w >= y {
+				plane[i] = 70
+			}
+		}
+
+		f := newEdgeFilter(36, 36, 0, 0, 8, chromaStyleFiltering(true, test.chromaArrayType))
+		filterEdge(plane, test.w, x, y, test.vertical, test.n, test.sub, test.bS, &f)
+		for _, s := range test.samples {
+			if got := plane[s.y*test.w+s.x]; got != s.want {
+				t.Errorf("did not get expected sample at (%d, %d) for test: %s\nGot: %v\nWant: %v", s.x, s.y, test.name, got, s.want)
+			}
+		}
+	}
+}
+
+// TestDeblockTables checks the transcription of the threshold tables of the
+// deblocking filter. alpha' follows 0.8*(2^(indexA/6)-1), limited to 255, to
+// within 1 or 2%, and the thresholds do not decrease with their indices or,
+// for tC0', with bS.
+func TestDeblockTables(t *testing.T) {
+	for indexA := 16; indexA < len(alphaTable); indexA++ {
+		want := math.Min(0.8*(math.Pow(2, float64(indexA)/6)-1), 255)
+		if math.Abs(float64(alphaTable[indexA])-want) > math.Max(1, 0.02*want) {
+			t.Errorf("did not get expected alpha' for indexA: %d\nGot: %d\nWant: %.2f", indexA, alphaTable[indexA], want)
+		}
+	}
+	for i := 1; i < 52; i++ {
+		if alphaTable[i] < alphaTable[i-1] || betaTable[i] < betaTable[i-1] {
+			t.Errorf("alpha' or beta' decreases at index: %d", i)
+		}
+		for bS := 0; bS < 3; bS++ {
+			if tc0Table[i][bS] < tc0Table[i-1][bS] || bS > 0 && tc0Table[i][bS] < tc0Table[i][bS-1] {
+				t.Errorf("tC0' decreases at indexA: %d bS: %d\nGot: %v", i, bS+1, tc0Table[i])
+			}
+		}
+	}
+	for i := 1; i < len(qpcTable); i++ {
+		if d := qpcTable[i] - qpcTable[i-1]; d < 0 || d > 1 {
+			t.Errorf("QPc does not increase by 0 or 1 at qPI: %d\nGot: %d\nPrevious: %d", 30+i, qpcTable[i], qpcTable[i-1])
+		}
+	}
+}
+
+// TestDeblockPicture checks the filtering of the macroblock edge between the
+// two intra macroblocks of a 2x1 macroblock picture, whose luma samples are
+// 10 and 18 respectively, according to disable_deblocking_filter_idc, the
+// filter offsets, and whether the macroblocks are of the same slice.
+func TestDeblockPicture(t *testing.T) {
+	tests := []struct {
+		slices    []sliceDeblocking
+		sliceNums [2]int32
+		filtered  bool
+	}{
+		{slices: []sliceDeblocking{{}}, filtered: true},
+		{slices: []sliceDeblocking{{disable: 1}}},
+		{slices: []sliceDeblocking{{filterOffsetA: -12}}},
+		{slices: []sliceDeblocking{{}, {}}, sliceNums: [2]int32{0, 1}, filtered: true},
+		{slices: []sliceDeblocking{{}, {disable: 2}}, sliceNums: [2]int32{0, 1}},
+		{slices: []sliceDeblocking{{disable: 2}, {disable: 2}}, filtered: true},
+	}
+
+	sps := &SPS{ChromaFormat: chroma420, PicWidthInMbsMinus1: 1, FrameMbsOnly: true}
+	h := &SliceHeader{ChromaArrayType: chroma420}
+	for i, test := range tests {
+		a := &arena{first: h, slices: test.slices}
+		pic := a.picture(sps)
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 32; x++ {
+				pic.Y[pic.YOffset(x, y)] = byte(10 + 8*(x/16))
+			}
+		}
+		mbs := a.mbState(2, 1)
+		for mbAddr, sliceNum := range test.sliceNums {
+			mbs.beginMb(mbAddr, sliceNum, mbIntraCoded)
+			mbs.qpY[mbAddr] = 30
+		}
+
+		a.finish()
+		p0, q0 := pic.Y[pic.YOffset(15, 8)], pic.Y[pic.YOffset(16, 8)]
+		if got := p0 != 10 || q0 != 18; got != test.filtered {
+			t.Errorf("did not get expected filtering for test: %d\nGot: %v\nWant: %v", i, got, test.filtered)
+		}
+		for y := 0; y < 16; y++ {
+			if pic.Y[pic.YOffset(0, y)] != 10 || pic.Y[pic.YOffset(8, y)] != 10 {
+				t.Errorf("did not expect filtering of picture edge or internal edges for test: %d", i)
+				break
+			}
+		}
+		a.finish()
+		if pic.Y[pic.YOffset(15, 8)] != p0 || pic.Y[pic.YOffset(16, 8)] != q0 {
+			t.Errorf("did not expect picture to be filtered twice for test: %d", i)
+		}
+	}
+}
+
+// TestDeblockMBAFF checks the filtering of macroblock edges between field and
+// frame macroblocks of an MBAFF frame of 2x2 macroblock pairs of intra
+// macroblocks, the samples changed showing the macroblock each line is
+// filtered against by its QPY: lines meeting a macroblock with QPY 51 are
+// filtered, and those meeting one with QPY 0 are not.
+func TestDeblockMBAFF(t *testing.T) {
+	type sample struct {
+		x, y    int
+		changed bool
+	}
+	var evenLines, topLines []sample
+	for y := 0; y < 32; y++ {
+		evenLines = append(evenLines, sample{16, y, y%2 == 0})
+		topLines = append(topLines, sample{16, y, y < 16})
+	}
+	tests := []struct {
+		fieldPairs [4]bool
+		qp         [8]int8
+		vertical   bool // Whether the edge tested is vertical, at x of 16, or horizontal, at y of 32.
+		want       []sample
+	}{
+		// Frame macroblocks left of a field pair, their lines alternating
+		// between the field macroblocks.
+		{
+			fieldPairs: [4]bool{true, false},
+			qp:         [8]int8{51, 0, 10, 10},
+			vertical:   true,
+			want:       evenLines,
+		},
+
+		// Field macroblocks left of a frame pair, the lines of each meeting
+		// the top and then the bottom frame macroblock.
+		{
+			fieldPairs: [4]bool{false, true},
+			qp:         [8]int8{51, 0, 10, 10},
+			vertical:   true,
+			want:       topLines,
+		},
+
+		// A frame macroblock below a field pair, its lines of each parity
+		// filtered against the field macroblock of that parity, with bS of 3
+		// so that p1 of each field is changed.
+		{
+			fieldPairs: [4]bool{true, false, false},
+			qp:         [8]int8{51, 0, 0, 0, 10},
+			want: []sample{
+				{0, 27, false}, {0, 28, true}, {0, 29, false}, {0, 30, true},
+				{0, 31, false}, {0, 32, true}, {0, 33, false}, {0, 34, true},
+			},
+		},
+
+		// Field macroblocks below a frame pair, both filtered against the
+		// bottom frame macroblock, a line of each field at a time.
+		{
+			fieldPairs: [4]bool{false, false, true},
+			qp:         [8]int8{0, 51, 0, 0, 10, 10},
+			want: []sample{
+				{0, 26, false}, {0, 27, false}, {0, 28, true}, {0, 29, true},
+				{0, 30, true}, {0, 31, true}, {0, 32, true}, {0, 33, true},
+			},
+		},
+	}
+
+	sps := &SPS{ChromaFormat: chroma420, PicWidthInMbsMinus1: 1, MBAdaptiveFrameField: true}
+	h := &SliceHeader{ChromaArrayType: chroma420}
+	for i, test := range tests {
+		pic := image.NewYCbCr(image.Rect(0, 0, 32, 64), image.YCbCrSubsampleRatio420)
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 32; x++ {
+				v := byte(10 + 20*(y/32))
+				if test.vertical {
+					v = byte(10 + 20*(x/16))
+				}
+				pic.Y[pic.YOffset(x, y)] = v
+			}
+		}
+		mbs := newMbState(2, 4)
+		mbs.mbaff = true
+		sliceNum := mbs.startSlice()
+		for mbAddr := 0; mbAddr < mbs.n; mbAddr++ {
+			mbs.beginMb(mbAddr, sliceNum, mbIntraCoded)
+			mbs.setFieldDecoding(mbAddr, test.fieldPairs[mbAddr/2])
+			mbs.qpY[mbAddr] = test.qp[mbAddr]
+		}
+		want := append([]byte(nil), pic.Y...)
+
+		deblockPicture(pic, sps, h, mbs, []sliceDeblocking{{}}, nil)
+		for _, s := range test.want {
+			off := pic.YOffset(s.x, s.y)
+			if got := pic.Y[off] != want[off]; got != s.changed {
+				t.Errorf("did not get expected change of sample (%d, %d) for test: %d\nGot: %v\nWant: %v", s.x, s.y, i, got, s.changed)
+			}
+		}
+	}
+}
+
+// TestBoundaryStrengths checks bS of the macroblock edge and an internal edge
+// of a macroblock in frame and field pictures, and of field macroblocks of
+// MBAFF frames, for intra macroblocks, coefficients and motion.
+func TestBoundaryStrengths(t *testing.T) {
+	tests := []struct {
+		field    bool
+		fieldMbs [2]bool // Of macroblocks p and q, in an MBAFF frame.
+		mixed    bool
+		vertical bool
+		edge     int
+		flags    [2]mbFlags // Of macroblocks p and q.
+		coeffs   bool       // Non-zero coefficients in the first block of q.
+		mvQ      motionVector
+		want     [4]int
+	}{
+		{vertical: true, flags: [2]mbFlags{mbIntraCoded, 0}, want: [4]int{4, 4, 4, 4}},
+		{field: true, flags: [2]mbFlags{0, mbIntraCoded}, want: [4]int{3, 3, 3, 3}},
+		{field: true, vertical: true, flags: [2]mbFlags{0, mbIntraCoded}, want: [4]int{4, 4, 4, 4}},
+		{edge: 1, flags: [2]mbFlags{0, mbIntraCoded}, want: [4]int{3, 3, 3, 3}},
+		{coeffs: true, want: [4]int{2, 0, 0, 0}},
+		{mvQ: motionVector{X: 4}, want: [4]int{1, 1, 1, 1}},
+		{mvQ: motionVector{Y: 3}, want: [4]int{}},
+		{field: true, mvQ: motionVector{Y: 2}, want: [4]int{1, 1, 1, 1}},
+
+		// Field macroblocks of MBAFF frames.
+		{fieldMbs: [2]bool{true, true}, vertical: true, flags: [2]mbFlags{mbIntraCoded, 0}, want: [4]int{4, 4, 4, 4}},
+		{fieldMbs: [2]bool{true, true}, flags: [2]mbFlags{0, mbIntraCoded}, want: [4]int{3, 3, 3, 3}},
+		{fieldMbs: [2]bool{true, false}, mixed: true, flags: [2]mbFlags{0, mbIntraCoded}, want: [4]int{3, 3, 3, 3}},
+		{fieldMbs: [2]bool{false, true}, mixed: true, vertical: true, flags: [2]mbFlags{0, mbIntraCoded}, want: [4]int{4, 4, 4, 4}},
+		{fieldMbs: [2]bool{true, false}, mixed: true, want: [4]int{1, 1, 1, 1}},
+		{fieldMbs: [2]bool{true, true}, mvQ: motionVector{Y: 2}, want: [4]int{1, 1, 1, 1}},
+	}
+
+	for i, test := range tests {
+		// Macroblock p is above q, or to its left for vertical edges.
+		mbs := newMbState(1, 2)
+		mbAddrQ := 1
+		if test.vertical {
+			mbs = newMbState(2, 1)
+		}
+		sliceNum := mbs.startSlice()
+		for mbAddr, f := range test.flags {
+			mbs.beginMb(mbAddr, sliceNum, f)
+			if test.fieldMbs[mbAddr] {
+				mbs.flags[mbAddr] |= mbFieldDecoded
+			}
+			if f&mbIntraCoded == 0 {
+				mbs.setRefIdx(mbAddr, 0, 0, 0, 16, 16, 0)
+			}
+		}
+		mbs.setMv(mbAddrQ, 0, 0, 0, 16, 16, test.mvQ)
+		if test.coeffs {
+			mbs.totalCoeff[0][mbAddrQ*blocksPerMb] = 1
+		}
+		d := &deblocker{mbs: mbs, slices: []sliceDeblocking{{}}, field: test.field}
+		mbAddrP := mbAddrQ
+		if test.edge == 0 {
+			mbAddrP = 0
+		}
+		got := d.boundaryStrengths(mbAddrP, mbAddrQ, test.vertical, test.edge, test.mixed)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestDifferentMotion checks the comparison of the reference pictures and
+// motion vectors of partitions either side of an edge, by the picture
+// referred to rather than the list or reference index referring to it.
+func TestDifferentMotion(t *testing.T) {
+	a, b := &refFrame{poc: 0}, &refFrame{poc: 8}
+	type part struct {
+		slice  int
+		refIdx [2]int // -1 for a list not used.
+		mv     [2]motionVector
+	}
+	far := motionVector{X: 4}
+	tests := []struct {
+		lists [2]refPicLists // Of slices 0 and 1.
+		p, q  part
+		want  bool
+	}{
+		// The same picture in different slices.
+		{
+			lists: [2]refPicLists{{list: [2][]*refFrame{{a, b}}}, {list: [2][]*refFrame{{a, b}}}},
+			p:     part{slice: 0, refIdx: [2]int{0, -1}},
+			q:     part{slice: 1, refIdx: [2]int{0, -1}},
+		},
+
+		// Different slices whose lists are not known.
+		{
+			lists: [2]refPicLists{{err: errNoRefFrames}, {err: errNoRefFrames}},
+			p:     part{slice: 0, refIdx: [2]int{0, -1}},
+			q:     part{slice: 1, refIdx: [2]int{0, -1}},
+			want:  true,
+		},
+
+		// The same picture by different reference indices.
+		{
+			lists: [2]refPicLists{{list: [2][]*refFrame{{a, b}}}, {list: [2][]*refFrame{{b, a}}}},
+			p:     part{slice: 0, refIdx: [2]int{0, -1}},
+			q:     part{slice: 1, refIdx: [2]int{1, -1}},
+		},
+
+		// Different pictures by the same reference index.
+		{
+			lists: [2]refPicLists{{list: [2][]*refFrame{{a, b}}}, {list: [2][]*refFrame{{b, a}}}},
+			p:     part{slice: 0, refIdx: [2]int{0, -1}},
+			q:     part{slice: 1, refIdx: [2]int{0, -1}},
+			want:  true,
+		},
+
+		// The same picture by different lists.
+		{
+			lists: [2]refPicLists{{list: [2][]*refFrame{{a}, {b, a}}}},
+			p:     part{refIdx: [2]int{0, -1}},
+			q:     part{refIdx: [2]int{-1, 1}},
+		},
+
+		// Different numbers of motion vectors.
+		{
+			lists: [2]refPicLists{{list: [2][]*refFrame{{a}, {b, a}}}},
+			p:     part{refIdx: [2]int{0, -1}},
+			q:     part{refIdx: [2]int{0, 1}},
+			want:  true,
+		},
+
+		// Two pictures by swapped lists, with motion vectors corresponding by
+		// picture.
+		{
+			lists: [2]refPicLists{{list: [2][]*refFrame{{a, b}, {b, a}}}},
+			p:     part{refIdx: [2]int{0, 0}, mv: [2]motionVector{{}, far}},
+			q:     part{refIdx: [2]int{1, 1}, mv: [2]motionVector{far, {}}},
+		},
+		{
+			lists: [2]refPicLists{{list: [2][]*refFrame{{a, b}, {b, a}}}},
+			p:     part{refIdx: [2]int{0, 0}, mv: [2]motionVector{{}, far}},
+			q:     part{refIdx: [2]int{1, 1}, mv: [2]motionVector{{}, far}},
+			want:  true,
+		},
+
+		// The same picture twice, with motion vectors corresponding in either
+		// order.
+		{
+			lists: [2]refPicLists{{list: [2][]*refFrame{{a}, {a}}}},
+			p:     part{refIdx: [2]int{0, 0}, mv: [2]motionVector{{}, far}},
+			q:     part{refIdx: [2]int{0, 0}, mv: [2]motionVector{far, {}}},
+		},
+		{
+			lists: [2]refPicLists{{list: [2][]*refFrame{{a}, {a}}}},
+			p:     part{refIdx: [2]int{0, 0}, mv: [2]motionVector{{}, far}},
+			q:     part{refIdx: [2]int{0, 0}, mv: [2]motionVector{{}, {X: 8}}},
+			want:  true,
+		},
+	}
+
+	for i, test := range tests {
+		// Macroblock p to the left of q.
+		mbs := newMbState(2, 1)
+		slices := []int32{mbs.startSlice(), mbs.startSlice()}
+		for mbAddr, pt := range []part{test.p, test.q} {
+			mbs.beginMb(mbAddr, slices[pt.slice], 0)
+			for list, refIdx := range pt.refIdx {
+				if refIdx >= 0 {
+					mbs.setRefIdx(mbAddr, list, 0, 0, 16, 16, refIdx)
+					mbs.setMv(mbAddr, list, 0, 0, 16, 16, pt.mv[list])
+				}
+			}
+		}
+		d := &deblocker{mbs: mbs, lists: test.lists[:]}
+		got := d.differentMotion(0, 15, 0, 1, 0, 0)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestDeblockerQP checks the QPY used in filtering, being 0 for I_PCM and
+// lossless macroblocks.
+func TestDeblockerQP(t *testing.T) {
+	tests := []struct {
+		bypass         bool // qpprime_y_zero_transform_bypass_flag.
+		bitDepthMinus8 int
+		flags          mbFlags
+		qpY            int8
+		want           int
+	}{
+		{qpY: 30, want: 30},
+		{flags: mbPCM, qpY: 30, want: 0},
+		{bitDepthMinus8: 2, qpY: -12, want: -12},
+		{bypass: true, bitDepthMinus8: 2, qpY: -12, want: 0},
+		{bypass: true, bitDepthMinus8: 2, qpY: -11, want: -11},
+		{bypass: true, qpY: 1, want: 1},
+	}
+
+	for i, test := range tests {
+		mbs := newMbState(1, 1)
+		mbs.beginMb(0, mbs.startSlice(), test.flags)
+		mbs.qpY[0] = test.qpY
+		d := &deblocker{
+			sps: &SPS{QPrimeYZeroTransformBypass: test.bypass, BitDepthLumaMinus8: test.bitDepthMinus8},
+			mbs: mbs,
+		}
+		if got := d.qp(0); got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+/*
+NAME
+  diverge.go
+
+DESCRIPTION
+  diverge.go provides a harness that decodes a stream with two decoder
+  configurations and reports the first point at which their outputs differ,
+  for finding regressions in alternative decoding paths.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Divergence describes the first point at which two decodes of a stream
+// differ.
+type Divergence struct {
+	AccessUnit int    // Index of the access unit, counting from 0.
+	Offset     int64  // Stream byte offset of the NAL unit of the slice.
+	MbAddr     int    // Address of the first macroblock of the slice.
+	Field      string // Path of the first differing field, e.g. "Header.SliceQpDelta".
+	A, B       string // Values of the field from each decode.
+}
+
+// String returns a single line description of the divergence.
+func (d *Divergence) String() string {
+	return fmt.Sprintf("access unit %d (offset %d, macroblock %d): %s differs: %s != %s", d.AccessUnit, d.Offset, d.MbAddr, d.Field, d.A, d.B)
+}
+
+// FindDivergence decodes stream twice, with the options given in a and in b,
+// and compares the decoded slices in decoding order, returning the first
+// difference found, or nil if the decodes agree. This allows, for example, a
+// path using WithIntraParallelism to be checked against sequential decoding.
+// Slices are compared by their header and data syntax elements; since
+// macroblock state is not retained after decoding, the divergence is located
+// to the first macroblock of the slice in which it is found. An error is
+// returned if either decode fails.
+func FindDivergence(stream []byte, a, b []Option) (*Divergence, error) {
+	sa, err := decodeSlices(stream, a)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode with first configuration: %w", err)
+	}
+	sb, err := decodeSlices(stream, b)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode with second configuration: %w", err)
+	}
+	return compareSlices(sa, sb), nil
+}
+
+// decodeSlices decodes stream using the given options, returning the decoded
+// slices of all video streams in decoding order.
+func decodeSlices(stream []byte, options []Option) ([]*SliceContext, error) {
+	h, err := NewH264Reader(bytes.NewReader(stream), options...)
+	if err != nil {
+		return nil, err
+	}
+	err = h.Start()
+	if err != nil {
+		return nil, err
+	}
+	var slices []*SliceContext
+	for _, vs := range h.VideoStreams {
+		slices = append(slices, vs.Slices...)
+	}
+	return slices, nil
+}
+
+// compareSlices returns the first difference between the slices of two
+// decodes, or nil if there is none.
+func compareSlices(a, b []*SliceContext) *Divergence {
+	au := -1
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i >= len(a) || i >= len(b) {
+			// One decode has extra slices; locate using the one that has it.
+			s := a
+			d := &Divergence{Field: "slice", A: "present", B: "missing"}
+			if i >= len(a) {
+				s = b
+				d.A, d.B = d.B, d.A
+			}
+			if s[i].Slice.Header.FirstMbInSlice == 0 {
+				au++
+			}
+			d.AccessUnit, d.Offset, d.MbAddr = au, s[i].NalUnit.Offset, s[i].Slice.Header.FirstMbInSlice
+			return d
+		}
+
+		sa, sb := a[i], b[i]
+		if sa.Slice.Header.FirstMbInSlice == 0 {
+			au++
+		}
+		d := &Divergence{AccessUnit: au, Offset: sa.NalUnit.Offset, MbAddr: sa.Slice.Header.FirstMbInSlice}
+		if sa.NalUnit.Offset != sb.NalUnit.Offset {
+			d.Field = "Offset"
+			d.A, d.B = strconv.FormatInt(sa.NalUnit.Offset, 10), strconv.FormatInt(sb.NalUnit.Offset, 10)
+			return d
+		}
+		if firstDiff(d, "Header", reflect.ValueOf(sa.Slice.Header), reflect.ValueOf(sb.Slice.Header)) ||
+			firstDiff(d, "Data", reflect.ValueOf(sa.Slice.Data), reflect.ValueOf(sb.Slice.Data)) ||
+			firstDiff(d, "Flags", reflect.ValueOf(sa.Flags), reflect.ValueOf(sb.Flags)) {
+			return d
+		}
+	}
+	return nil
+}
+
+// firstDiff compares a and b, of the same type, recording the path and
+// values of the first difference in d and returning true if one is found.
+// Structs, pointers and slices are compared element by element. Unexported
+// struct fields and BitReader fields, which hold decoder state rather than
+// output, are not compared.
+func firstDiff(d *Divergence, path string, a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() != b.IsNil() {
+				d.Field, d.A, d.B = path, describeNil(a), describeNil(b)
+				return true
+			}
+			return false
+		}
+		return firstDiff(d, path, a.Elem(), b.Elem())
+	case reflect.Struct:
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || f.Name == "BitReader" {
+				continue
+			}
+			if firstDiff(d, path+"."+f.Name, a.Field(i), b.Field(i)) {
+				return true
+			}
+		}
+		return false
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			d.Field = path + ".len"
+			d.A, d.B = strconv.Itoa(a.Len()), strconv.Itoa(b.Len())
+			return true
+		}
+		for i := 0; i < a.Len(); i++ {
+			if firstDiff(d, path+"["+strconv.Itoa(i)+"]", a.Index(i), b.Index(i)) {
+				return true
+			}
+		}
+		return false
+	default:
+		if a.Interface() != b.Interface() {
+			d.Field = path
+			d.A, d.B = fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface())
+			return true
+		}
+		return false
+	}
+}
+
+// describeNil returns "nil" if the pointer v is nil, and otherwise "present".
+func describeNil(v reflect.Value) string {
+	if v.IsNil() {
+		return "nil"
+	}
+	return "present"
+}
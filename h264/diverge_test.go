@@ -0,0 +1,102 @@
+/*
+NAME
+  diverge_test.go
+
+DESCRIPTION
+  diverge_test.go provides testing for functionality provided in diverge.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+// TestCompareSlices checks that the first difference between two decodes is
+// located to the expected access unit, slice and field.
+func TestCompareSlices(t *testing.T) {
+	// slice returns a decoded slice at the given offset, starting at the given
+	// macroblock, with the given slice_qp_delta and mb_type of its last
+	// macroblock.
+	slice := func(off int64, firstMb, qpDelta, mbType int) *SliceContext {
+		return &SliceContext{
+			NalUnit: &NalUnit{Offset: off},
+			Slice: &Slice{
+				Header: &SliceHeader{FirstMbInSlice: firstMb, SliceQpDelta: qpDelta},
+				Data:   &SliceData{MbType: mbType, PcmSampleLuma: []int{1, 2, 3}},
+			},
+		}
+	}
+	stream := func(mod func(i int, s *SliceContext)) []*SliceContext {
+		var slices []*SliceContext
+		for i, firstMb := range []int{0, 40, 0, 40, 0} {
+			s := slice(int64(10*i), firstMb, 0, 1)
+			if mod != nil {
+				mod(i, s)
+			}
+			slices = append(slices, s)
+		}
+		return slices
+	}
+
+	base := stream(nil)
+	tests := []struct {
+		b    []*SliceContext
+		want *Divergence
+	}{
+		{b: stream(nil), want: nil},
+		{
+			b: stream(func(i int, s *SliceContext) {
+				if i == 3 {
+					s.Slice.Header.SliceQpDelta = 2
+				}
+			}),
+			want: &Divergence{AccessUnit: 1, Offset: 30, MbAddr: 40, Field: "Header.SliceQpDelta", A: "0", B: "2"},
+		},
+		{
+			b: stream(func(i int, s *SliceContext) {
+				if i == 2 {
+					s.Slice.Data.PcmSampleLuma[1] = 7
+				}
+			}),
+			want: &Divergence{AccessUnit: 1, Offset: 20, MbAddr: 0, Field: "Data.PcmSampleLuma[1]", A: "2", B: "7"},
+		},
+		{
+			b: stream(func(i int, s *SliceContext) {
+				if i == 1 {
+					s.Flags = FrameCorrupt
+				}
+			}),
+			want: &Divergence{AccessUnit: 0, Offset: 10, MbAddr: 40, Field: "Flags", A: "none", B: "corrupt"},
+		},
+		{
+			b:    base[:4],
+			want: &Divergence{AccessUnit: 2, Offset: 40, MbAddr: 0, Field: "slice", A: "present", B: "missing"},
+		},
+		{
+			b:    append(base[:1:1], base[2:]...),
+			want: &Divergence{AccessUnit: 0, Offset: 10, MbAddr: 40, Field: "Offset", A: "10", B: "20"},
+		},
+	}
+
+	for i, test := range tests {
+		got := compareSlices(base, test.b)
+		if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestFindDivergence checks that decoding with and without concurrent intra
+// decoding does not diverge.
+func TestFindDivergence(t *testing.T) {
+	stream := annexB(testSPS, testPPS, testIDR, testI, testNonIDR, testI)
+	d, err := FindDivergence(stream, []Option{WithRecovery()}, []Option{WithRecovery(), WithIntraParallelism(2)})
+	if err != nil {
+		t.Fatalf("did not expect error: %v from FindDivergence", err)
+	}
+	if d != nil {
+		t.Errorf("did not expect divergence, got: %v", d)
+	}
+}
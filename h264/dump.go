@@ -0,0 +1,153 @@
+/*
+NAME
+  dump.go
+
+DESCRIPTION
+  dump.go provides JSON and human-readable string representations of NAL
+  units, parameter sets and slice headers, for use by analysers and in logs.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MarshalJSON implements json.Marshaler, giving all header fields of the NAL
+// unit along with the name of its type and the size of its RBSP.
+func (n NalUnit) MarshalJSON() ([]byte, error) {
+	type nalUnit NalUnit // Prevents recursion into MarshalJSON.
+	return json.Marshal(struct {
+		nalUnit
+		TypeName  string
+		RBSPBytes int
+	}{nalUnit(n), n.Type.String(), len(n.rbsp)})
+}
+
+// String returns a single line description of the NAL unit header, omitting
+// fields with zero values.
+func (n NalUnit) String() string {
+	return describe("NalUnit", n)
+}
+
+// MarshalJSON implements json.Marshaler, giving all syntax elements of the SPS
+// along with the names of its profile and level and the cropped picture
+// dimensions.
+func (s SPS) MarshalJSON() ([]byte, error) {
+	type sps SPS
+	w, h := s.PictureSize()
+	return json.Marshal(struct {
+		sps
+		Profile string
+		Level   string
+		Width   int
+		Height  int
+	}{sps(s), s.Profile().String(), s.Level().String(), w, h})
+}
+
+// String returns a single line description of the SPS, omitting syntax
+// elements with zero values, and the scaling matrix if none is present.
+func (s SPS) String() string {
+	if !s.SeqScalingMatrixPresent {
+		return describe("SPS", s, "ScalingMatrix")
+	}
+	return describe("SPS", s)
+}
+
+// MarshalJSON implements json.Marshaler, giving all syntax elements of the PPS
+// along with the name of its entropy coding mode.
+func (p PPS) MarshalJSON() ([]byte, error) {
+	type pps PPS
+	return json.Marshal(struct {
+		pps
+		EntropyCodingModeName string
+	}{pps(p), entropyCodingModeName(p.EntropyCodingMode)})
+}
+
+// String returns a single line description of the PPS, omitting syntax
+// elements with zero values, and the scaling matrix if none is present.
+func (p PPS) String() string {
+	if !p.PicScalingMatrixPresent {
+		return describe("PPS", p, "ScalingMatrix")
+	}
+	return describe("PPS", p)
+}
+
+// MarshalJSON implements json.Marshaler, giving all syntax elements of the
+// slice header along with the name of its slice type.
+func (h SliceHeader) MarshalJSON() ([]byte, error) {
+	type sliceHeader SliceHeader
+	return json.Marshal(struct {
+		sliceHeader
+		SliceTypeName string
+	}{sliceHeader(h), sliceTypeMap[h.SliceType]})
+}
+
+// String returns a single line description of the slice header, omitting
+// syntax elements with zero values.
+func (h SliceHeader) String() string {
+	return describe("SliceHeader", h)
+}
+
+// entropyCodingModeName returns the name of the entropy coding method given by
+// entropy_coding_mode_flag.
+func entropyCodingModeName(mode int) string {
+	if mode == 1 {
+		return "CABAC"
+	}
+	return "CAVLC"
+}
+
+// describe returns v, a struct, formatted as name{Field:value ...} in the
+// manner of the %+v verb, omitting unexported fields, fields with zero values
+// and the fields named in skip. Nested structs are formatted in the same way
+// and pointers are followed.
+func describe(name string, v interface{}, skip ...string) string {
+	return name + describeValue(reflect.ValueOf(v), skip)
+}
+
+func describeValue(v reflect.Value, skip []string) string {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "nil"
+		}
+		return describeValue(v.Elem(), nil)
+	case reflect.Struct:
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+
+	var fields []string
+	t := v.Type()
+outer:
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || isZero(v.Field(i)) {
+			continue
+		}
+		for _, s := range skip {
+			if f.Name == s {
+				continue outer
+			}
+		}
+		fields = append(fields, f.Name+":"+describeValue(v.Field(i), nil))
+	}
+	return "{" + strings.Join(fields, " ") + "}"
+}
+
+// isZero returns true if v is the zero value of its type, or an empty slice.
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+	}
+}
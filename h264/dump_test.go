@@ -0,0 +1,113 @@
+/*
+NAME
+  dump_test.go
+
+DESCRIPTION
+  dump_test.go provides testing for functionality in dump.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMarshalJSON checks that the JSON representations of a NAL unit and its
+// SPS carry both syntax elements and derived values, for values and pointers.
+func TestMarshalJSON(t *testing.T) {
+	nalUnit, err := NewNalUnit(testSPS, len(testSPS))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewNalUnit", err)
+	}
+	sps, err := NewSPS(nalUnit.RBSP(), false)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewSPS", err)
+	}
+
+	tests := []struct {
+		in   interface{}
+		want map[string]interface{}
+	}{
+		{
+			in:   nalUnit,
+			want: map[string]interface{}{"Type": 7.0, "RefIdc": 3.0, "TypeName": NALTypeSPS.String(), "RBSPBytes": 5.0},
+		},
+		{
+			in:   sps,
+			want: map[string]interface{}{"ProfileIDC": 66.0, "LevelIDC": 30.0, "Profile": "Baseline", "Level": "3", "Width": 16.0, "Height": 16.0, "VUI": nil},
+		},
+		{
+			in:   *sps,
+			want: map[string]interface{}{"ProfileIDC": 66.0, "Width": 16.0},
+		},
+		{
+			in:   &PPS{EntropyCodingMode: 1},
+			want: map[string]interface{}{"EntropyCodingMode": 1.0, "EntropyCodingModeName": "CABAC"},
+		},
+		{
+			in:   &SliceHeader{SliceType: 7, FrameNum: 3},
+			want: map[string]interface{}{"SliceType": 7.0, "FrameNum": 3.0, "SliceTypeName": "I"},
+		},
+	}
+
+	for i, test := range tests {
+		b, err := json.Marshal(test.in)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from json.Marshal for test: %d", err, i)
+		}
+		var got map[string]interface{}
+		err = json.Unmarshal(b, &got)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from json.Unmarshal for test: %d", err, i)
+		}
+		for k, want := range test.want {
+			v, ok := got[k]
+			if !ok || !reflect.DeepEqual(v, want) {
+				t.Errorf("did not get expected result for %s for test: %d\nGot: %v\nWant: %v", k, i, v, want)
+			}
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	hrd := &HRDParameters{CpbCntMinus1: 0, BitRateValueMinus1: []int{9}}
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{
+			in:   SliceHeader{SliceType: 2, FrameNum: 3, IdrPic: true},
+			want: "SliceHeader{IdrPic:true SliceType:2 FrameNum:3}",
+		},
+		{
+			in:   &NalUnit{RefIdc: 3, Type: NALTypeSPS, HeaderBytes: 1},
+			want: "NalUnit{RefIdc:3 Type:" + NALTypeSPS.String() + " HeaderBytes:1}",
+		},
+		{
+			in:   &PPS{ID: 1, PicInitQpMinus26: -2, ScalingMatrix: FlatScalingMatrix()},
+			want: "PPS{ID:1 PicInitQpMinus26:-2}",
+		},
+		{
+			in:   SPS{LevelIDC: 30, VuiParametersPresent: true, VUI: &VUIParameters{NalHRD: hrd, VideoFormat: VideoFormatPAL}},
+			want: "SPS{LevelIDC:30 VuiParametersPresent:true VUI:{VideoFormat:PAL NalHRD:{BitRateValueMinus1:[9]}}}",
+		},
+	}
+
+	for i, test := range tests {
+		got := test.in.(interface{ String() string }).String()
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+
+	sps := SPS{SeqScalingMatrixPresent: true, ScalingMatrix: FlatScalingMatrix()}
+	if !strings.Contains(sps.String(), "ScalingMatrix:{List4x4:[[16 16") {
+		t.Errorf("did not get present scaling matrix in result\nGot: %v", sps.String())
+	}
+}
@@ -0,0 +1,71 @@
+/*
+NAME
+  events.go
+
+DESCRIPTION
+  events.go provides events that may be emitted by an H264Reader while
+  decoding, to notify callers of conditions in the stream.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "strconv"
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+// Event types.
+const (
+	// EventStreamStalled is emitted when no data has been received from the
+	// stream within the read timeout given by WithReadTimeout.
+	EventStreamStalled EventType = iota
+
+	// EventAUSizeExceeded is emitted when the coded size of an access unit
+	// exceeds the multiple of its expected size given by WithAUSizeLimit.
+	EventAUSizeExceeded
+
+	// EventRefreshComplete is emitted when, with WithIntraRefresh, decoding
+	// reaches the first picture from which output is correct.
+	EventRefreshComplete
+
+	// EventReconnected is emitted when the stream, being a ReconnectReader,
+	// has reconnected to its source. Decoding resumes at the next IDR
+	// picture.
+	EventReconnected
+)
+
+// String returns a readable name for the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventStreamStalled:
+		return "StreamStalled"
+	case EventAUSizeExceeded:
+		return "AUSizeExceeded"
+	case EventRefreshComplete:
+		return "RefreshComplete"
+	case EventReconnected:
+		return "Reconnected"
+	default:
+		return "EventType(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+// Event describes a condition encountered by an H264Reader.
+type Event struct {
+	Type   EventType
+	Offset int        // Stream byte offset at which the event occurred.
+	Flags  FrameFlags // Flags of the frame concerned, for frame events.
+	Detail string     // Optional human readable detail.
+}
+
+// emit passes the event to the handler provided using WithEventHandler, if
+// any.
+func (h *H264Reader) emit(e Event) {
+	logger.Printf("info: event %v at offset %d: %s\n", e.Type, e.Offset, e.Detail)
+	if h.eventHandler != nil {
+		h.eventHandler(e)
+	}
+}
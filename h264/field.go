@@ -0,0 +1,104 @@
+/*
+NAME
+  field.go
+
+DESCRIPTION
+  field.go provides support for interlaced coding in which the two fields of
+  a frame, its even and odd rows, are coded as separate pictures, as used by
+  interlaced cameras and broadcast sources.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "strconv"
+
+// PictureStructure identifies whether a picture is a frame or a field, and
+// which field.
+type PictureStructure int
+
+// Picture structures.
+const (
+	StructureFrame       PictureStructure = iota // A frame, i.e. field_pic_flag is 0.
+	StructureTopField                            // The top (even row) field of a frame.
+	StructureBottomField                         // The bottom (odd row) field of a frame.
+)
+
+// String returns a readable name for the picture structure.
+func (s PictureStructure) String() string {
+	switch s {
+	case StructureFrame:
+		return "frame"
+	case StructureTopField:
+		return "top field"
+	case StructureBottomField:
+		return "bottom field"
+	default:
+		return "PictureStructure(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// IsField returns true if the structure is that of a field.
+func (s PictureStructure) IsField() bool {
+	return s == StructureTopField || s == StructureBottomField
+}
+
+// flags returns the frame flags indicating the structure.
+func (s PictureStructure) flags() FrameFlags {
+	switch s {
+	case StructureTopField:
+		return FrameTopField
+	case StructureBottomField:
+		return FrameBottomField
+	default:
+		return 0
+	}
+}
+
+// Structure returns the structure of the picture the slice belongs to, as
+// given by field_pic_flag and bottom_field_flag.
+func (h *SliceHeader) Structure() PictureStructure {
+	switch {
+	case !h.FieldPic:
+		return StructureFrame
+	case h.BottomField:
+		return StructureBottomField
+	default:
+		return StructureTopField
+	}
+}
+
+// FrameRow returns the row of the frame holding row y of the picture the
+// slice belongs to, for rows of luma samples or, equally, chroma samples. A
+// field holds alternate rows of its frame, beginning with the first row for
+// the top field and the second for the bottom field.
+func (h *SliceHeader) FrameRow(y int) int {
+	if !h.FieldPic {
+		return y
+	}
+	return 2*y + flagVal(h.BottomField)
+}
+
+// MbFrameLocation returns the location, in luma samples of the frame, of the
+// top left sample of the macroblock with the given address in the picture the
+// slice belongs to, using the inverse macroblock scanning process of section
+// 6.4.1 for pictures other than MBAFF frames. The rows of a macroblock of a
+// field are alternate rows of the frame, see FrameRow.
+func (h *SliceHeader) MbFrameLocation(sps *SPS, mbAddr int) (x, y int) {
+	w := PicWidthInMbs(sps)
+	return (mbAddr % w) * 16, h.FrameRow((mbAddr / w) * 16)
+}
+
+// secondField returns true if the slice with header cur, beginning a picture,
+// is of the second field of a complementary field pair of which the picture
+// with first slice header prev is the first field. That is, they are fields of
+// opposite parity with the same frame_num, and cur is not an IDR picture, which
+// would end the pair; see section 3.30.
+func secondField(prev, cur *SliceHeader) bool {
+	return prev.FieldPic && cur.FieldPic &&
+		prev.BottomField != cur.BottomField &&
+		prev.FrameNum == cur.FrameNum &&
+		!cur.IdrPic
+}
@@ -0,0 +1,86 @@
+/*
+NAME
+  field_test.go
+
+DESCRIPTION
+  field_test.go provides testing for functionality provided in field.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+func TestStructure(t *testing.T) {
+	tests := []struct {
+		header    SliceHeader
+		want      PictureStructure
+		wantFlags FrameFlags
+		wantName  string
+	}{
+		{header: SliceHeader{}, want: StructureFrame, wantName: "frame"},
+		{header: SliceHeader{FieldPic: true}, want: StructureTopField, wantFlags: FrameTopField, wantName: "top field"},
+		{header: SliceHeader{FieldPic: true, BottomField: true}, want: StructureBottomField, wantFlags: FrameBottomField, wantName: "bottom field"},
+	}
+
+	for i, test := range tests {
+		got := test.header.Structure()
+		if got != test.want || got.flags() != test.wantFlags || got.String() != test.wantName {
+			t.Errorf("did not get expected result for test: %d\nGot: %v, %v\nWant: %v, %v", i, got, got.flags(), test.want, test.wantFlags)
+		}
+		if got.IsField() != test.header.FieldPic {
+			t.Errorf("did not get expected IsField for test: %d\nGot: %v\nWant: %v", i, got.IsField(), test.header.FieldPic)
+		}
+	}
+}
+
+func TestMbFrameLocation(t *testing.T) {
+	// 3 macroblocks wide.
+	sps := &SPS{PicWidthInMbsMinus1: 2}
+
+	tests := []struct {
+		header SliceHeader
+		mbAddr int
+		wantX  int
+		wantY  int
+	}{
+		{header: SliceHeader{}, mbAddr: 0, wantX: 0, wantY: 0},
+		{header: SliceHeader{}, mbAddr: 4, wantX: 16, wantY: 16},
+		{header: SliceHeader{FieldPic: true}, mbAddr: 2, wantX: 32, wantY: 0},
+		{header: SliceHeader{FieldPic: true}, mbAddr: 4, wantX: 16, wantY: 32},
+		{header: SliceHeader{FieldPic: true, BottomField: true}, mbAddr: 0, wantX: 0, wantY: 1},
+		{header: SliceHeader{FieldPic: true, BottomField: true}, mbAddr: 7, wantX: 16, wantY: 65},
+	}
+
+	for i, test := range tests {
+		x, y := test.header.MbFrameLocation(sps, test.mbAddr)
+		if x != test.wantX || y != test.wantY {
+			t.Errorf("did not get expected result for test: %d\nGot: (%d, %d)\nWant: (%d, %d)", i, x, y, test.wantX, test.wantY)
+		}
+	}
+}
+
+func TestSecondField(t *testing.T) {
+	top := &SliceHeader{FieldPic: true, FrameNum: 3}
+	tests := []struct {
+		prev *SliceHeader
+		cur  *SliceHeader
+		want bool
+	}{
+		{prev: top, cur: &SliceHeader{FieldPic: true, BottomField: true, FrameNum: 3}, want: true},
+		{prev: top, cur: &SliceHeader{FieldPic: true, FrameNum: 3}, want: false},
+		{prev: top, cur: &SliceHeader{FieldPic: true, BottomField: true, FrameNum: 4}, want: false},
+		{prev: top, cur: &SliceHeader{FrameNum: 3}, want: false},
+		{prev: top, cur: &SliceHeader{FieldPic: true, BottomField: true, FrameNum: 3, IdrPic: true}, want: false},
+		{prev: &SliceHeader{FrameNum: 3}, cur: &SliceHeader{FieldPic: true, BottomField: true, FrameNum: 3}, want: false},
+	}
+
+	for i, test := range tests {
+		got := secondField(test.prev, test.cur)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
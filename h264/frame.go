@@ -1,26 +1,161 @@
 package h264
 
-// NALU types, as defined in table 7-1 in specifications.
+import "strconv"
+
+// NALType is the type of a NAL unit i.e. nal_unit_type, as defined in table
+// 7-1 in specifications.
+type NALType int
+
+// NAL unit types, as defined in table 7-1 in specifications.
+const (
+	NALTypeUnspecified NALType = iota
+	NALTypeSliceNonIDRPicture
+	NALTypeSlicePartA
+	NALTypeSlicePartB
+	NALTypeSlicePartC
+	NALTypeSliceIDRPicture
+	NALTypeSEI
+	NALTypeSPS
+	NALTypePPS
+	NALTypeAccessUnitDelimiter
+	NALTypeEndOfSequence
+	NALTypeEndOfStream
+	NALTypeFillerData
+	NALTypeSPSExtension
+	NALTypePrefixNALU
+	NALTypeSubsetSPS
+	NALTypeDepthParamSet
+	NALTypeReserved17
+	NALTypeReserved18
+	NALTypeSliceAux
+	NALTypeSliceExtension
+	NALTypeSliceExtensionDepth
+	NALTypeReserved22
+	NALTypeReserved23
+)
+
+// String returns the name of the NAL unit type as given in table 7-1.
+func (t NALType) String() string {
+	if s, ok := NALUnitType[int(t)]; ok {
+		return s
+	}
+	if t >= 24 && t <= 31 {
+		return "unspecified"
+	}
+	return "NALType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// IsVCL returns true if the NAL unit type is of the VCL class i.e. it contains
+// coded slice data. Types 20 and 21 are VCL NAL units for the SVC, MVC and
+// 3D-AVC extensions (Annexes G, H and J).
+func (t NALType) IsVCL() bool {
+	switch t {
+	case NALTypeSliceNonIDRPicture, NALTypeSlicePartA, NALTypeSlicePartB,
+		NALTypeSlicePartC, NALTypeSliceIDRPicture, NALTypeSliceExtension,
+		NALTypeSliceExtensionDepth:
+		return true
+	}
+	return false
+}
+
+// IsIDR returns true if the NAL unit type is a coded slice of an IDR picture.
+func (t NALType) IsIDR() bool {
+	return t == NALTypeSliceIDRPicture
+}
+
+// IsParameterSet returns true if the NAL unit type carries a sequence or
+// picture parameter set, or an extension of one.
+func (t NALType) IsParameterSet() bool {
+	switch t {
+	case NALTypeSPS, NALTypePPS, NALTypeSPSExtension, NALTypeSubsetSPS, NALTypeDepthParamSet:
+		return true
+	}
+	return false
+}
+
+// FrameFlags is a set of conditions applying to a decoded frame. All per-frame
+// boolean conditions are represented here so that they are reported uniformly,
+// e.g. on slices, in events and in statistics.
+type FrameFlags uint
+
+// Frame flags.
 const (
-	naluTypeUnspecified = iota
-	naluTypeSliceNonIDRPicture
-	naluTypeSlicePartA
-	naluTypeSlicePartB
-	naluTypeSlicePartC
-	naluTypeSliceIDRPicture
-	naluTypeSEI
-	naluTypeSPS
-	naluTypePPS
-	naluTypeAccessUnitDelimiter
-	naluTypeEndOfSequence
-	naluTypeEndOfStream
-	naluTypeFillerData
-	naluTypeSPSExtension
-	naluTypePrefixNALU
-	naluTypeSubsetSPS
-	naluTypeDepthParamSet
+	// FrameKeyframe indicates an IDR picture, from which decoding may start.
+	FrameKeyframe FrameFlags = 1 << iota
+
+	// FrameCorrupt indicates that errors were found in the frame's data,
+	// such that macroblocks of the picture were not decoded, e.g. of slices
+	// that were lost or discarded using WithRecovery.
+	FrameCorrupt
+
+	// FrameConcealed indicates that missing or corrupt parts of the frame have
+	// been replaced using error concealment.
+	FrameConcealed
+
+	// FrameDegraded indicates that the frame was decoded with reduced quality,
+	// e.g. with the samples of inter macroblocks, which are not yet
+	// predicted, left as those of an earlier picture.
+	FrameDegraded
+
+	// FrameDuplicate indicates that the frame is a repeat of a previous frame,
+	// e.g. inserted to maintain a constant frame rate.
+	FrameDuplicate
+
+	// FrameRefreshing indicates that the frame was decoded after resuming at
+	// a point other than an IDR picture, and before a full intra refresh, so
+	// that it may refer to pictures that were not decoded. See
+	// WithIntraRefresh.
+	FrameRefreshing
+
+	// FrameTopField indicates that the picture is the top field of a frame,
+	// holding its even rows, rather than a frame.
+	FrameTopField
+
+	// FrameBottomField indicates that the picture is the bottom field of a
+	// frame, holding its odd rows, rather than a frame.
+	FrameBottomField
+
+	// FrameReference indicates a reference picture, i.e. one with non-zero
+	// nal_ref_idc, which is marked as used for reference by the decoded
+	// reference picture marking process and so may be referred to by later
+	// pictures. Other pictures may be discarded once output.
+	FrameReference
 )
 
+// frameFlagNames holds the names of frame flags, in bit order.
+var frameFlagNames = []string{"keyframe", "corrupt", "concealed", "degraded", "duplicate", "refreshing", "top-field", "bottom-field", "reference"}
+
+// Has returns true if all flags in g are set in f.
+func (f FrameFlags) Has(g FrameFlags) bool {
+	return f&g == g
+}
+
+// String returns the names of the flags set in f, separated by '|', or "none"
+// if no flags are set.
+func (f FrameFlags) String() string {
+	if f == 0 {
+		return "none"
+	}
+	var s string
+	for i, name := range frameFlagNames {
+		if f&(1<<uint(i)) == 0 {
+			continue
+		}
+		if s != "" {
+			s += "|"
+		}
+		s += name
+		f &^= 1 << uint(i)
+	}
+	if f != 0 {
+		if s != "" {
+			s += "|"
+		}
+		s += "0x" + strconv.FormatUint(uint64(f), 16)
+	}
+	return s
+}
+
 var (
 	// Refer to ITU-T H.264 4/10/2017
 	// Specifieds the RBSP structure in the NAL unit
@@ -0,0 +1,78 @@
+/*
+NAME
+  frame_test.go
+
+DESCRIPTION
+  frame_test.go provides testing for functionality provided in frame.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+// TestNALType checks the String and classification methods of NALType.
+func TestNALType(t *testing.T) {
+	tests := []struct {
+		in       NALType
+		str      string
+		vcl      bool
+		idr      bool
+		paramSet bool
+	}{
+		{NALTypeUnspecified, "unspecified", false, false, false},
+		{NALTypeSliceNonIDRPicture, "coded slice of non-IDR picture", true, false, false},
+		{NALTypeSlicePartB, "coded slice data partition b", true, false, false},
+		{NALTypeSliceIDRPicture, "coded IDR slice of picture", true, true, false},
+		{NALTypeSEI, "sei suppl. enhancem. info", false, false, false},
+		{NALTypeSPS, "sequence parameter set", false, false, true},
+		{NALTypePPS, "picture parameter set", false, false, true},
+		{NALTypeSubsetSPS, "subset SPS", false, false, true},
+		{NALTypeSliceExtension, "coded slice extension", true, false, false},
+		{28, "unspecified", false, false, false},
+		{32, "NALType(32)", false, false, false},
+	}
+
+	for i, test := range tests {
+		if got := test.in.String(); got != test.str {
+			t.Errorf("did not get expected string for test: %d\nGot: %v\nWant: %v", i, got, test.str)
+		}
+		if got := test.in.IsVCL(); got != test.vcl {
+			t.Errorf("did not get expected IsVCL for test: %d\nGot: %v\nWant: %v", i, got, test.vcl)
+		}
+		if got := test.in.IsIDR(); got != test.idr {
+			t.Errorf("did not get expected IsIDR for test: %d\nGot: %v\nWant: %v", i, got, test.idr)
+		}
+		if got := test.in.IsParameterSet(); got != test.paramSet {
+			t.Errorf("did not get expected IsParameterSet for test: %d\nGot: %v\nWant: %v", i, got, test.paramSet)
+		}
+	}
+}
+
+// TestFrameFlags checks the String and Has methods of FrameFlags.
+func TestFrameFlags(t *testing.T) {
+	tests := []struct {
+		in  FrameFlags
+		str string
+	}{
+		{0, "none"},
+		{FrameKeyframe, "keyframe"},
+		{FrameCorrupt | FrameConcealed, "corrupt|concealed"},
+		{FrameKeyframe | FrameDuplicate, "keyframe|duplicate"},
+		{FrameKeyframe | FrameReference, "keyframe|reference"},
+		{FrameDegraded | 1<<9, "degraded|0x200"},
+	}
+
+	for i, test := range tests {
+		if got := test.in.String(); got != test.str {
+			t.Errorf("did not get expected string for test: %d\nGot: %v\nWant: %v", i, got, test.str)
+		}
+	}
+
+	f := FrameKeyframe | FrameCorrupt
+	if !f.Has(FrameKeyframe) || !f.Has(FrameKeyframe|FrameCorrupt) || f.Has(FrameDuplicate) {
+		t.Errorf("did not get expected results from Has for flags: %v", f)
+	}
+}
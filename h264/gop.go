@@ -0,0 +1,211 @@
+/*
+NAME
+  gop.go
+
+DESCRIPTION
+  gop.go provides batch decoding of a stream held in memory by splitting it
+  into chunks beginning at IDR access units, which depend on no earlier
+  pictures, and decoding the chunks concurrently, each with its own
+  H264Reader, for fast offline analysis of long recordings.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// gopChunk is a part of a stream beginning with an IDR access unit, or the
+// start of the stream, up to the next chunk.
+type gopChunk struct {
+	data   []byte // Stream bytes of the chunk, beginning with a start code prefix.
+	offset int    // Stream byte offset of data.
+
+	// Parameter sets received before the chunk, by which it may be decoded
+	// independently of the rest of the stream.
+	sps, pps [][]byte
+}
+
+// DecodeFile reads the Annex B byte stream in the file at path and decodes it
+// using DecodeGOPs.
+func DecodeFile(path string, workers int, options ...Option) ([]*VideoStream, error) {
+	stream, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file: %w", err)
+	}
+	return DecodeGOPs(stream, workers, options...)
+}
+
+// DecodeGOPs decodes stream, an Annex B byte stream, by splitting it into
+// chunks beginning at IDR access units and decoding up to workers chunks
+// concurrently, each with its own H264Reader configured using options. The
+// SPS and PPS received before each chunk are given to its reader out of band,
+// see WithSPS and WithPPS. The video streams of the chunks are merged in
+// stream order, those of consecutive chunks being joined where the later
+// chunk does not begin with a new SPS, so that the result is as from a single
+// H264Reader. Any event handler given by options must be safe for concurrent
+// use. The first error of a chunk, in stream order, is returned.
+func DecodeGOPs(stream []byte, workers int, options ...Option) ([]*VideoStream, error) {
+	if workers < 1 {
+		return nil, errBadWorkers
+	}
+	chunks, err := splitGOPs(stream)
+	if err != nil {
+		return nil, fmt.Errorf("could not split stream: %w", err)
+	}
+
+	results := make([][]*VideoStream, len(chunks))
+	carried := make([]map[*SPS]bool, len(chunks))
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range chunks {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			results[i], carried[i], errs[i] = decodeGOP(chunks[i], options)
+		}(i)
+	}
+	wg.Wait()
+
+	var merged []*VideoStream
+	for i, vs := range results {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("could not decode chunk at offset %d: %w", chunks[i].offset, errs[i])
+		}
+		if len(vs) == 0 {
+			continue
+		}
+		if n := len(merged); n != 0 && carried[i][vs[0].SPS] && vs[0].SPS.ID == merged[n-1].SPS.ID {
+			last := merged[n-1]
+			last.PPS = vs[0].PPS
+			for _, ctx := range vs[0].Slices {
+				last.addSlice(ctx)
+			}
+			vs = vs[1:]
+		}
+		merged = append(merged, vs...)
+	}
+	return merged, nil
+}
+
+// decodeGOP decodes the chunk using a new H264Reader configured with options,
+// returning its video streams and the set of SPS given to it out of band.
+func decodeGOP(c gopChunk, options []Option) ([]*VideoStream, map[*SPS]bool, error) {
+	opts := append([]Option(nil), options...)
+	for _, raw := range c.sps {
+		opts = append(opts, WithSPS(raw))
+	}
+	for _, raw := range c.pps {
+		opts = append(opts, WithPPS(raw))
+	}
+	h, err := NewH264Reader(bytes.NewReader(c.data), opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	carried := make(map[*SPS]bool)
+	for _, sps := range h.ParameterSets.SPS {
+		carried[sps] = true
+	}
+	h.byteOffset = c.offset
+	err = h.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.VideoStreams, carried, nil
+}
+
+// splitGOPs splits stream into chunks, a new chunk beginning with each IDR
+// access unit but the first. Access unit boundaries are found as by Skip, so
+// no slice data is decoded.
+func splitGOPs(stream []byte) ([]gopChunk, error) {
+	h := &H264Reader{Stream: bytes.NewReader(stream)}
+	sps := make(map[int][]byte)
+	pps := make(map[int][]byte)
+
+	var chunks []gopChunk
+	var cur gopChunk
+	auStart := 0
+	carried := [2][][]byte{} // Parameter sets received before auStart.
+	var sawVCL bool
+	for {
+		nalUnit, err := h.nextNalUnit()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read NAL unit: %w", err)
+		}
+
+		if sawVCL && startsAccessUnit(nalUnit) {
+			sawVCL = false
+			auStart = startCodeOffset(stream, int(nalUnit.Offset))
+			carried = [2][][]byte{sortedRaw(sps), sortedRaw(pps)}
+		}
+
+		switch nalUnit.Type {
+		case NALTypeSPS, NALTypePPS:
+			err = h.handleParameterSet(nalUnit)
+			if err != nil {
+				return nil, newParseError(nalUnit, err)
+			}
+			raw := append([]byte(nil), nalUnit.raw...)
+			if nalUnit.Type == NALTypeSPS {
+				sps[h.ParameterSets.last.ID] = raw
+				break
+			}
+			id, err := readUe(bits.NewBitReader(bytes.NewReader(nalUnit.RBSP())))
+			if err != nil {
+				return nil, newParseError(nalUnit, fmt.Errorf("could not parse pic_parameter_set_id: %w", err))
+			}
+			pps[id] = raw
+		case NALTypeSliceIDRPicture:
+			if !sawVCL && auStart > cur.offset {
+				cur.data = stream[cur.offset:auStart]
+				chunks = append(chunks, cur)
+				cur = gopChunk{offset: auStart, sps: carried[0], pps: carried[1]}
+			}
+		}
+		if nalUnit.Type.IsVCL() {
+			sawVCL = true
+		}
+	}
+	cur.data = stream[cur.offset:]
+	return append(chunks, cur), nil
+}
+
+// startCodeOffset returns the offset in stream of the start code prefix,
+// including any leading zero bytes, of the NAL unit whose header is at off.
+func startCodeOffset(stream []byte, off int) int {
+	i := off - len(Initial3BNALU)
+	for i > 0 && stream[i-1] == 0 {
+		i--
+	}
+	return i
+}
+
+// sortedRaw returns the parameter set NAL units of m in order of their IDs.
+func sortedRaw(m map[int][]byte) [][]byte {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	raw := make([][]byte, len(ids))
+	for i, id := range ids {
+		raw[i] = m[id]
+	}
+	return raw
+}
@@ -0,0 +1,143 @@
+/*
+NAME
+  gop_test.go
+
+DESCRIPTION
+  gop_test.go provides testing for functionality provided in gop.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSplitGOPs(t *testing.T) {
+	aud := []byte{0x09, 0x10}
+	tests := []struct {
+		name     string
+		nalUnits [][]byte
+		want     []int // Index of the first NAL unit of each chunk.
+		sps      []int // Number of SPS carried to each chunk.
+	}{
+		{
+			name:     "no IDR",
+			nalUnits: [][]byte{testSPS, testPPS, skipSlice(2, 1), skipSlice(2, 2)},
+			want:     []int{0},
+			sps:      []int{0},
+		},
+		{
+			name:     "parameter sets once",
+			nalUnits: [][]byte{testSPS, testPPS, testIDR, skipSlice(2, 1), testIDR, skipSlice(2, 1)},
+			want:     []int{0, 4},
+			sps:      []int{0, 1},
+		},
+		{
+			name:     "parameter sets and delimiters before each IDR",
+			nalUnits: [][]byte{aud, testSPS, testPPS, testIDR, aud, skipSlice(2, 1), aud, testSPS, testPPS, testIDR, aud, testIDR},
+			want:     []int{0, 6, 10},
+			sps:      []int{0, 1, 1},
+		},
+	}
+
+	for _, test := range tests {
+		stream := annexB(test.nalUnits...)
+		chunks, err := splitGOPs(stream)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for test: %s", err, test.name)
+		}
+		var got, sps []int
+		var joined []byte
+		for _, c := range chunks {
+			got = append(got, nalIndex(test.nalUnits, c.offset))
+			sps = append(sps, len(c.sps))
+			joined = append(joined, c.data...)
+		}
+		if !reflect.DeepEqual(got, test.want) || !reflect.DeepEqual(sps, test.sps) {
+			t.Errorf("did not get expected chunks for test: %s\nGot: %v, %v\nWant: %v, %v", test.name, got, sps, test.want, test.sps)
+		}
+		if !bytes.Equal(joined, stream) {
+			t.Errorf("chunks do not make up stream for test: %s", test.name)
+		}
+	}
+}
+
+// nalIndex returns the index of the NAL unit of the stream given by
+// annexB(nalUnits...) whose start code is at offset off, or -1 if there is
+// none.
+func nalIndex(nalUnits [][]byte, off int) int {
+	var o int
+	for i, n := range nalUnits {
+		if o == off {
+			return i
+		}
+		o += len(InitialNALU) + len(n)
+	}
+	return -1
+}
+
+// TestDecodeGOPs checks that decoding chunks concurrently gives the same
+// result as decoding the stream with a single H264Reader.
+func TestDecodeGOPs(t *testing.T) {
+	tests := []struct {
+		name     string
+		nalUnits [][]byte
+	}{
+		{
+			name:     "parameter sets once",
+			nalUnits: [][]byte{testSPS, testPPS, skipSlice(2, 1), testIDR, skipSlice(2, 1), skipSlice(2, 2), testIDR, skipSlice(0, 1), skipSlice(2, 1)},
+		},
+		{
+			name:     "parameter sets before each IDR",
+			nalUnits: [][]byte{testSPS, testPPS, testIDR, skipSlice(2, 1), testSPS, testPPS, testIDR, skipSlice(2, 1), skipSlice(2, 2)},
+		},
+	}
+
+	for _, test := range tests {
+		stream := annexB(test.nalUnits...)
+		h, err := NewH264Reader(bytes.NewReader(stream), WithRecovery())
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader for test: %s", err, test.name)
+		}
+		err = h.Start()
+		if err != nil {
+			t.Fatalf("did not expect error: %v from Start for test: %s", err, test.name)
+		}
+
+		for _, workers := range []int{1, 3} {
+			got, err := DecodeGOPs(stream, workers, WithRecovery())
+			if err != nil {
+				t.Fatalf("did not expect error: %v from DecodeGOPs for test: %s", err, test.name)
+			}
+			if len(got) != len(h.VideoStreams) {
+				t.Fatalf("did not get expected number of video streams for test: %s\nGot: %d\nWant: %d", test.name, len(got), len(h.VideoStreams))
+			}
+			for i, vs := range got {
+				want := h.VideoStreams[i]
+				if d := compareSlices(vs.Slices, want.Slices); d != nil {
+					t.Errorf("did not get expected slices for test: %s video stream: %d\n%v", test.name, i, d)
+				}
+				if len(vs.Pictures) != len(want.Pictures) {
+					t.Errorf("did not get expected number of pictures for test: %s video stream: %d\nGot: %d\nWant: %d", test.name, i, len(vs.Pictures), len(want.Pictures))
+				}
+				for j := range vs.Slices {
+					if j < len(want.Slices) && vs.Slices[j].Offset != want.Slices[j].Offset {
+						t.Errorf("did not get expected offset for test: %s slice: %d\nGot: %d\nWant: %d", test.name, j, vs.Slices[j].Offset, want.Slices[j].Offset)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeGOPsWorkers(t *testing.T) {
+	_, err := DecodeGOPs(annexB(testSPS, testPPS, skipSlice(2, 1)), 0)
+	if err != errBadWorkers {
+		t.Errorf("did not get expected error\nGot: %v\nWant: %v", err, errBadWorkers)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+NAME
+  hexdump.go
+
+DESCRIPTION
+  hexdump.go provides a hexdump of NAL units annotated with the position and
+  value of each syntax element, for bit-level debugging against the
+  specifications.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// hexdumpRow is the number of bytes of each hexdump row.
+const hexdumpRow = 16
+
+// hexdumpMaxBits is the maximum number of bits of a syntax element shown.
+const hexdumpMaxBits = 32
+
+// Hexdump writes a hexdump of the NAL unit, which must have been given to
+// NewNalUnit with its header, to w. Beneath each row of the hexdump are the
+// syntax elements beginning in that row, giving their byte and bit offsets in
+// the NAL unit, descriptors, bits and values, and names where known. The
+// syntax elements of the NAL unit header and of SPS, subset SPS, PPS and the
+// slice headers of slice NAL units are given; the parameter sets needed for
+// PPS and slice headers are looked up in ps, which may be nil. If the NAL unit
+// fails to parse, the elements up to the failure are given, followed by the
+// error.
+func Hexdump(w io.Writer, nalUnit *NalUnit, ps *ParameterSets) error {
+	elems := []TraceElement{
+		{Name: "ForbiddenZeroBit", Descriptor: "f(1)", Bit: 0, Len: 1, Value: int64(nalUnit.ForbiddenZeroBit)},
+		{Name: "NalRefIdc", Descriptor: "u(2)", Bit: 1, Len: 2, Value: int64(nalUnit.RefIdc)},
+		{Name: "NalUnitType", Descriptor: "u(5)", Bit: 3, Len: 5, Value: int64(nalUnit.Type)},
+	}
+
+	t := &traceRecorder{}
+	perr := traceRBSP(t, nalUnit, ps)
+	for _, e := range t.elems {
+		e.Bit = nalUnit.nalByte(e.Bit/8)*8 + e.Bit%8
+		elems = append(elems, e)
+	}
+
+	raw := nalUnit.raw
+	var j int
+	for off := 0; off < len(raw); off += hexdumpRow {
+		row := raw[off:]
+		if len(row) > hexdumpRow {
+			row = row[:hexdumpRow]
+		}
+		_, err := fmt.Fprintf(w, "%08x  %-*s |%s|\n", off, hexdumpRow*3-1, hexBytes(row), printable(row))
+		if err != nil {
+			return err
+		}
+		for ; j < len(elems) && elems[j].Bit < (off+len(row))*8; j++ {
+			e := elems[j]
+			_, err = fmt.Fprintf(w, "          %4d.%d  %-6s %-*s  %s = %d\n",
+				e.Bit/8, e.Bit%8, e.Descriptor, hexdumpMaxBits+3, elementBits(nalUnit, e), e.Name, e.Value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if perr != nil {
+		_, err := fmt.Fprintf(w, "error: %v\n", perr)
+		return err
+	}
+	return nil
+}
+
+// traceRBSP parses the RBSP of the NAL unit, according to its type, recording
+// the syntax elements read with t.
+func traceRBSP(t *traceRecorder, nalUnit *NalUnit, ps *ParameterSets) error {
+	rbsp := nalUnit.RBSP()
+	br := bits.NewBitReader(bytes.NewReader(rbsp))
+	br.SetTracer(t)
+
+	var err error
+	switch nalUnit.Type {
+	case NALTypeSPS:
+		_, err = readSPSData(br)
+	case NALTypeSubsetSPS:
+		_, err = readSubsetSPS(br)
+	case NALTypePPS:
+		if ps == nil {
+			return errNoParameterSets
+		}
+		var sps *SPS
+		sps, err = ps.ppsSPS(rbsp)
+		if err == nil {
+			_, err = readPPS(br, sps, rbsp)
+		}
+	case NALTypeSliceNonIDRPicture, NALTypeSlicePartA, NALTypeSliceIDRPicture:
+		if ps == nil {
+			return errNoParameterSets
+		}
+		var ppsID int
+		ppsID, err = slicePPSID(rbsp)
+		if err != nil {
+			break
+		}
+		var sps *SPS
+		var pps *PPS
+		sps, pps, err = ps.Active(ppsID)
+		if err == nil {
+			_, err = readSliceHeader(br, nalUnit, sps, pps)
+		}
+	}
+	return err
+}
+
+var errNoParameterSets = errors.New("no parameter sets given")
+
+// HexdumpStream writes the annotated hexdump of each NAL unit of the Annex B
+// byte stream read from r to w, see Hexdump, each preceded by a line giving
+// its index, type, stream offset and size. Parameter sets are retained for the
+// parsing of those that follow.
+func HexdumpStream(w io.Writer, r io.Reader) error {
+	h, err := NewH264Reader(r)
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		nalUnit, err := h.nextNalUnit()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read NAL unit: %w", err)
+		}
+		_, err = fmt.Fprintf(w, "NAL unit %d: %s at offset %d, %d bytes\n", i, nalUnit.Type, nalUnit.Offset, nalUnit.NumBytes)
+		if err != nil {
+			return err
+		}
+		err = Hexdump(w, nalUnit, &h.ParameterSets)
+		if err != nil {
+			return err
+		}
+		if nalUnit.Type.IsParameterSet() {
+			err = h.handleParameterSet(nalUnit)
+			if err != nil {
+				logger.Printf("warning: could not parse %s: %v\n", nalUnit.Type, err)
+			}
+		}
+	}
+}
+
+// hexBytes returns the bytes of b in hexadecimal, separated by spaces.
+func hexBytes(b []byte) string {
+	s := make([]string, len(b))
+	for i, c := range b {
+		s[i] = fmt.Sprintf("%02x", c)
+	}
+	return strings.Join(s, " ")
+}
+
+// printable returns b with bytes that are not printable ASCII replaced by '.'.
+func printable(b []byte) string {
+	p := make([]byte, len(b))
+	for i, c := range b {
+		if c < 0x20 || c > 0x7e {
+			c = '.'
+		}
+		p[i] = c
+	}
+	return string(p)
+}
+
+// elementBits returns the bits of the syntax element e of the NAL unit, whose
+// Bit is a NAL unit bit offset, as binary digits. Elements longer than
+// hexdumpMaxBits are truncated, ending in "...".
+func elementBits(nalUnit *NalUnit, e TraceElement) string {
+	n := e.Len
+	if n > hexdumpMaxBits {
+		n = hexdumpMaxBits
+	}
+	var b strings.Builder
+	for i, bit := 0, e.Bit; i < n; bit++ {
+		// Emulation prevention bytes are not part of the element.
+		if bit%8 == 0 && nalUnit.isEPB(bit/8) {
+			bit += 7
+			continue
+		}
+		b.WriteByte('0' + nalUnit.raw[bit/8]>>(7-uint(bit%8))&1)
+		i++
+	}
+	if n < e.Len {
+		b.WriteString("...")
+	}
+	return b.String()
+}
+
+// isEPB returns true if byte i of the NAL unit is an emulation prevention
+// byte.
+func (n *NalUnit) isEPB(i int) bool {
+	for j, p := range n.epb {
+		// The jth byte removed lies before RBSP byte p, so was at NAL unit
+		// byte HeaderBytes+p+j.
+		if n.HeaderBytes+p+j == i {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+/*
+NAME
+  hexdump_test.go
+
+DESCRIPTION
+  hexdump_test.go provides testing for functionality provided in hexdump.go
+  and trace.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// TestTraceElement checks that the elements recorded while parsing are named
+// and combined by the parsing functions.
+func TestTraceElement(t *testing.T) {
+	br := bits.NewBitReader(bytes.NewReader(binToSlice("0110 00101 1 001 1 0001001")))
+	var rec traceRecorder
+	br.SetTracer(&rec)
+
+	var x int
+	var f bool
+	readFields(br, []field{{&x, "X", 4}})
+	readSe(br)
+	readFlags(br, []flag{{&f, "F"}})
+	br.ReadBits(3)
+	readUe(br)
+	readUe(br)
+
+	want := []TraceElement{
+		{Name: "X", Descriptor: "u(4)", Bit: 0, Len: 4, Value: 6},
+		{Descriptor: "se(v)", Bit: 4, Len: 5, Value: -2},
+		{Name: "F", Descriptor: "u(1)", Bit: 9, Len: 1, Value: 1},
+		{Descriptor: "u(3)", Bit: 10, Len: 3, Value: 1},
+		{Descriptor: "ue(v)", Bit: 13, Len: 1, Value: 0},
+		{Descriptor: "ue(v)", Bit: 14, Len: 7, Value: 8},
+	}
+	if !reflect.DeepEqual(rec.elems, want) {
+		t.Errorf("did not get expected elements\nGot: %+v\nWant: %+v", rec.elems, want)
+	}
+}
+
+// TestHexdump checks the annotations of the hexdump of a slice with an
+// emulation prevention byte that fails to parse.
+func TestHexdump(t *testing.T) {
+	stream := annexB(testSPS, testPPS, []byte{0x65, 0x88, 0x84, 0x00, 0x00, 0x03, 0x01, 0x20})
+	var buf bytes.Buffer
+	err := HexdumpStream(&buf, bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from HexdumpStream", err)
+	}
+	got := buf.String()
+
+	for _, want := range []string{
+		"NAL unit 2: coded IDR slice of picture at offset 22, 8 bytes\n",
+		"00000000  65 88 84 00 00 03 01 20",
+		"|e...... |\n",
+		"   1.0  u(8)   01000010",
+		"ProfileIDC = 66\n",
+		"   2.5  u(1)   1",
+		"DeblockingFilterControlPresent = 1\n",
+		"   2.1  u(4)   0000",
+		"FrameNum = 0\n",
+		"   3.0  ue(v)  000000000000000000000001",
+		"(incomplete) = 0\n",
+		"error: could not parse SliceQpDelta",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("did not find expected text: %q in hexdump:\n%s", want, got)
+		}
+	}
+
+	nalUnit, err := NewNalUnit(testIDR, len(testIDR))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewNalUnit", err)
+	}
+	buf.Reset()
+	err = Hexdump(&buf, nalUnit, nil)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Hexdump", err)
+	}
+	if !strings.Contains(buf.String(), "error: "+errNoParameterSets.Error()) {
+		t.Errorf("did not get expected error without parameter sets\nGot: %s", buf.String())
+	}
+}
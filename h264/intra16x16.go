@@ -0,0 +1,132 @@
+/*
+NAME
+  intra16x16.go
+
+DESCRIPTION
+  intra16x16.go provides the Intra_16x16 prediction of luma samples, being
+  the four prediction modes of section 8.3.3.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "fmt"
+
+// intraPredPlane is the Intra16x16PredMode Intra_16x16_Plane (table 8-4),
+// the others being those of intraPredVertical, intraPredHorizontal and
+// intraPredDC.
+const intraPredPlane = 3
+
+// intra16x16PredMode returns Intra16x16PredMode given by the mb_type of
+// table 7-11, which must be of an Intra16x16 macroblock.
+func intra16x16PredMode(iMbType int) int {
+	return (iMbType - 1) % 4
+}
+
+// intraRefs holds the neighbouring samples p[x, y] of a block predicted as a
+// whole, being a 16x16 luma block or a chroma block, and whether each is
+// available for Intra prediction.
+type intraRefs struct {
+	corner int   // p[-1, -1].
+	top    []int // p[x, -1] for x from 0 to the width less 1.
+	left   []int // p[-1, y] for y from 0 to the height less 1.
+
+	hasCorner, hasTop, hasLeft bool
+}
+
+// p returns the sample p[x, y], where x or y is -1.
+func (r *intraRefs) p(x, y int) int {
+	switch {
+	case x < 0 && y < 0:
+		return r.corner
+	case y < 0:
+		return r.top[x]
+	}
+	return r.left[y]
+}
+
+// predIntra16x16 sets pred, the predicted samples of a 16x16 luma block in
+// raster order, using Intra16x16PredMode mode and the neighbouring samples r,
+// of the given bit depth, as specified by section 8.3.3. An error is
+// returned if the mode uses samples not available.
+func predIntra16x16(pred *[256]int, r *intraRefs, mode, bitDepth int) error {
+	var ok bool
+	switch mode {
+	case intraPredVertical:
+		ok = r.hasTop
+	case intraPredHorizontal:
+		ok = r.hasLeft
+	case intraPredDC:
+		ok = true
+	case intraPredPlane:
+		ok = r.hasTop && r.hasLeft && r.hasCorner
+	default:
+		return fmt.Errorf("invalid Intra16x16PredMode: %d", mode)
+	}
+	if !ok {
+		return fmt.Errorf("%w: Intra16x16PredMode %d", errIntraPredUnavailable, mode)
+	}
+
+	switch mode {
+	case intraPredVertical:
+		for i := range pred {
+			pred[i] = r.top[i%16]
+		}
+	case intraPredHorizontal:
+		for i := range pred {
+			pred[i] = r.left[i/16]
+		}
+	case intraPredDC:
+		var sumTop, sumLeft int
+		for i := 0; i < 16; i++ {
+			sumTop += r.top[i]
+			sumLeft += r.left[i]
+		}
+		dc := 1 << uint(bitDepth-1)
+		switch {
+		case r.hasTop && r.hasLeft:
+			dc = (sumTop + sumLeft + 16) >> 5
+		case r.hasTop:
+			dc = (sumTop + 8) >> 4
+		case r.hasLeft:
+			dc = (sumLeft + 8) >> 4
+		}
+		for i := range pred {
+			pred[i] = dc
+		}
+	case intraPredPlane:
+		r.plane(pred[:], bitDepth)
+	}
+	return nil
+}
+
+// plane sets pred, the samples of the block predicted in plane mode, in
+// raster order, for a block of the width and height of r's top and left
+// samples, being 16x16 luma or 8x8 or 8x16 chroma, of the given bit
+// depth. This is Intra_16x16_Plane of 8.3.3.4, or equally Intra_Chroma_Plane
+// of 8.3.4.4 with yCF 4 for a height of 16.
+func (r *intraRefs) plane(pred []int, bitDepth int) {
+	w, h := len(r.top), len(r.left)
+	xCF, yCF := w/2-4, h/2-4
+
+	var sumH, sumV int
+	for x := 0; x <= 3+xCF; x++ {
+		sumH += (x + 1) * (r.p(4+xCF+x, -1) - r.p(2+xCF-x, -1))
+	}
+	for y := 0; y <= 3+yCF; y++ {
+		sumV += (y + 1) * (r.p(-1, 4+yCF+y) - r.p(-1, 2+yCF-y))
+	}
+
+	// Coefficients of H and V, being 5 for a dimension of 16 and 34 for 8.
+	cH, cV := 34-29*(xCF/4), 34-29*(yCF/4)
+	a := 16 * (r.left[h-1] + r.top[w-1])
+	b := (cH*sumH + 32) >> 6
+	c := (cV*sumV + 32) >> 6
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pred[y*w+x] = Clip1y((a+b*(x-3-xCF)+c*(y-3-yCF)+16)>>5, bitDepth)
+		}
+	}
+}
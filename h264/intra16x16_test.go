@@ -0,0 +1,127 @@
+/*
+NAME
+  intra16x16_test.go
+
+DESCRIPTION
+  intra16x16_test.go provides testing for functionality provided in
+  intra16x16.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIntra16x16PredMode(t *testing.T) {
+	tests := []struct {
+		iMbType int
+		want    int
+	}{
+		{iMbType: 1, want: intraPredVertical},
+		{iMbType: 6, want: intraPredHorizontal},
+		{iMbType: 11, want: intraPredDC},
+		{iMbType: 24, want: intraPredPlane},
+	}
+
+	for i, test := range tests {
+		got := intra16x16PredMode(test.iMbType)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// intraTestRefs returns intraRefs for a block of size w x h, whose samples
+// are those given by f for p[x, y] and which are all available.
+func intraTestRefs(w, h int, f func(x, y int) int) intraRefs {
+	r := intraRefs{corner: f(-1, -1), top: make([]int, w), left: make([]int, h), hasCorner: true, hasTop: true, hasLeft: true}
+	for x := range r.top {
+		r.top[x] = f(x, -1)
+	}
+	for y := range r.left {
+		r.left[y] = f(-1, y)
+	}
+	return r
+}
+
+func TestPredIntra16x16(t *testing.T) {
+	// Samples on a plane, which plane prediction reproduces, and on one high
+	// enough that those predicted are clipped.
+	linear := func(x, y int) int { return 50 + 3*x + 2*y }
+	high := func(x, y int) int { return 200 + 3*x + 2*y }
+
+	tests := []struct {
+		refs intraRefs
+		mode int
+		want func(x, y int) int
+		err  error
+	}{
+		{refs: intraTestRefs(16, 16, linear), mode: intraPredVertical, want: func(x, y int) int { return linear(x, -1) }},
+		{refs: intraTestRefs(16, 16, linear), mode: intraPredHorizontal, want: func(x, y int) int { return linear(-1, y) }},
+		{refs: intraTestRefs(16, 16, linear), mode: intraPredDC, want: func(x, y int) int { return 66 }},
+		{refs: intraTestRefs(16, 16, linear), mode: intraPredPlane, want: linear},
+		{
+			refs: intraTestRefs(16, 16, high),
+			mode: intraPredPlane,
+			want: func(x, y int) int { return Clip1y(high(x, y), 8) },
+		},
+
+		// DC with only samples above, only to the left, and with none.
+		{refs: intraRefs{top: make([]int, 16), left: []int{80, 81, 82, 83, 84, 85, 86, 87, 88, 89, 90, 91, 92, 93, 94, 95}, hasLeft: true}, mode: intraPredDC, want: func(x, y int) int { return 88 }},
+		{refs: intraRefs{top: []int{80, 81, 82, 83, 84, 85, 86, 87, 88, 89, 90, 91, 92, 93, 94, 95}, left: make([]int, 16), hasTop: true}, mode: intraPredDC, want: func(x, y int) int { return 88 }},
+		{refs: intraRefs{top: make([]int, 16), left: make([]int, 16)}, mode: intraPredDC, want: func(x, y int) int { return 128 }},
+
+		// Modes using samples not available.
+		{refs: intraRefs{top: make([]int, 16), left: make([]int, 16), hasTop: true}, mode: intraPredHorizontal, err: errIntraPredUnavailable},
+		{refs: intraRefs{top: make([]int, 16), left: make([]int, 16), hasLeft: true}, mode: intraPredVertical, err: errIntraPredUnavailable},
+		{refs: intraRefs{top: make([]int, 16), left: make([]int, 16), hasTop: true, hasLeft: true}, mode: intraPredPlane, err: errIntraPredUnavailable},
+	}
+
+	for i, test := range tests {
+		var got [256]int
+		err := predIntra16x16(&got, &test.refs, test.mode, 8)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for j, v := range got {
+			if want := test.want(j%16, j/16); v != want {
+				t.Errorf("did not get expected sample at (%d, %d) for test: %d\nGot: %d\nWant: %d", j%16, j/16, i, v, want)
+				break
+			}
+		}
+	}
+
+	var pred [256]int
+	if err := predIntra16x16(&pred, &intraRefs{top: make([]int, 16), left: make([]int, 16)}, 4, 8); err == nil {
+		t.Errorf("did not get expected error for Intra16x16PredMode 4")
+	}
+}
+
+// TestIntraRefsPlane checks plane prediction from samples not on a plane
+// against values calculated by hand.
+func TestIntraRefsPlane(t *testing.T) {
+	r := intraRefs{
+		corner: 75,
+		top:    []int{60, 61, 64, 69, 76, 85, 96, 72, 87, 67, 86, 70, 93, 81, 71, 63},
+		left:   []int{90, 97, 118, 112, 120, 101, 96, 105, 128, 124, 93, 117, 114, 125, 109, 107},
+	}
+	pred := make([]int, 256)
+	r.plane(pred, 8)
+
+	// H 235 and V 554, giving a 2720, b 18 and c 43.
+	want := map[int]int{0: 72, 15: 80, 8*16 + 8: 87, 240: 92, 255: 100}
+	for i, w := range want {
+		if pred[i] != w {
+			t.Errorf("did not get expected sample at (%d, %d)\nGot: %d\nWant: %d", i%16, i/16, pred[i], w)
+		}
+	}
+}
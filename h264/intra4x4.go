@@ -0,0 +1,218 @@
+/*
+NAME
+  intra4x4.go
+
+DESCRIPTION
+  intra4x4.go provides the Intra_4x4 prediction of luma samples, being the
+  derivation of the prediction mode of each 4x4 luma block and the nine
+  prediction modes, as specified by section 8.3.1.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Intra4x4PredMode and Intra8x8PredMode values (tables 8-2 and 8-3).
+const (
+	intraPredVertical = iota
+	intraPredHorizontal
+	intraPredDC
+	intraPredDiagonalDownLeft
+	intraPredDiagonalDownRight
+	intraPredVerticalRight
+	intraPredHorizontalDown
+	intraPredVerticalLeft
+	intraPredHorizontalUp
+)
+
+// intra4x4PredMode derives Intra4x4PredMode of the 4x4 luma block with index
+// luma4x4BlkIdx of the macroblock with address currMbAddr from its
+// prev_intra4x4_pred_mode_flag and rem_intra4x4_pred_mode, as specified by
+// section 8.3.1.1, recording it in s.
+func (s *mbState) intra4x4PredMode(currMbAddr, luma4x4BlkIdx int, prevFlag bool, rem int, constrainedIntra bool) int {
+	mode := s.predIntra4x4PredMode(currMbAddr, luma4x4BlkIdx, constrainedIntra)
+	if !prevFlag {
+		if rem < mode {
+			mode = rem
+		} else {
+			mode = rem + 1
+		}
+	}
+	s.intraPredModes[currMbAddr*blocksPerMb+luma4x4BlkIdx] = int8(mode)
+	return mode
+}
+
+// predIntra4x4PredMode returns predIntra4x4PredMode of the 4x4 luma block
+// with index luma4x4BlkIdx of the macroblock with address currMbAddr, being
+// the lesser of the modes of the blocks to its left and above, or DC if
+// either is not available or, with constrained intra prediction, is of an
+// inter macroblock. The modes of macroblocks not coded in Intra_4x4 or
+// Intra_8x8 prediction mode are DC, see setIntraPredModes.
+func (s *mbState) predIntra4x4PredMode(currMbAddr, luma4x4BlkIdx int, constrainedIntra bool) int {
+	x, y := luma4x4BlkPos(luma4x4BlkIdx)
+	blkA, blkB := s.neighbour4x4Blks(currMbAddr, x, y, 16, 16)
+	if !s.intraAvailable(blkA.mbAddr, currMbAddr, constrainedIntra) || !s.intraAvailable(blkB.mbAddr, currMbAddr, constrainedIntra) {
+		return intraPredDC
+	}
+	modeA := s.intraPredModes[blkA.mbAddr*blocksPerMb+blkA.blkIdx]
+	modeB := s.intraPredModes[blkB.mbAddr*blocksPerMb+blkB.blkIdx]
+	if modeA < modeB {
+		return int(modeA)
+	}
+	return int(modeB)
+}
+
+// intra4x4Refs holds the neighbouring samples p[x, y] of a 4x4 block used in
+// its Intra_4x4 prediction, and whether each is available for Intra
+// prediction.
+type intra4x4Refs struct {
+	top  [9]int // p[x, -1] for x from -1 to 7, the first being p[-1, -1].
+	left [4]int // p[-1, y] for y from 0 to 3.
+
+	// Availability of p[-1, -1], p[x, -1] for x from 0 to 3 and from 4 to 7,
+	// and p[-1, y].
+	hasCorner, hasTop, hasTopRight, hasLeft bool
+}
+
+// p returns the sample p[x, y], where x or y is -1.
+func (r *intra4x4Refs) p(x, y int) int {
+	if y < 0 {
+		return r.top[x+1]
+	}
+	return r.left[y]
+}
+
+// predIntra4x4 sets pred, the predicted samples of a 4x4 luma block in raster
+// order, using Intra4x4PredMode mode and the neighbouring samples r, of the
+// given bit depth, as specified by section 8.3.1.2. Unavailable samples
+// p[x, -1] for x from 4 to 7 are first substituted by p[3, -1], if
+// available. An error is returned if the mode uses samples not available.
+func predIntra4x4(pred *[16]int, r *intra4x4Refs, mode, bitDepth int) error {
+	if !r.hasTopRight && r.hasTop {
+		for x := 4; x < 8; x++ {
+			r.top[x+1] = r.top[4]
+		}
+		r.hasTopRight = true
+	}
+
+	var ok bool
+	switch mode {
+	case intraPredVertical, intraPredDiagonalDownLeft, intraPredVerticalLeft:
+		ok = r.hasTop
+	case intraPredHorizontal, intraPredHorizontalUp:
+		ok = r.hasLeft
+	case intraPredDC:
+		ok = true
+	case intraPredDiagonalDownRight, intraPredVerticalRight, intraPredHorizontalDown:
+		ok = r.hasTop && r.hasLeft && r.hasCorner
+	default:
+		return fmt.Errorf("invalid Intra4x4PredMode: %d", mode)
+	}
+	if !ok {
+		return fmt.Errorf("%w: Intra4x4PredMode %d", errIntraPredUnavailable, mode)
+	}
+
+	if mode == intraPredDC {
+		dc := r.dc(bitDepth)
+		for i := range pred {
+			pred[i] = dc
+		}
+		return nil
+	}
+	p := r.p
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			var v int
+			switch mode {
+			case intraPredVertical:
+				v = p(x, -1)
+			case intraPredHorizontal:
+				v = p(-1, y)
+			case intraPredDiagonalDownLeft:
+				if x == 3 && y == 3 {
+					v = (p(6, -1) + 3*p(7, -1) + 2) >> 2
+				} else {
+					v = (p(x+y, -1) + 2*p(x+y+1, -1) + p(x+y+2, -1) + 2) >> 2
+				}
+			case intraPredDiagonalDownRight:
+				switch {
+				case x > y:
+					v = (p(x-y-2, -1) + 2*p(x-y-1, -1) + p(x-y, -1) + 2) >> 2
+				case x < y:
+					v = (p(-1, y-x-2) + 2*p(-1, y-x-1) + p(-1, y-x) + 2) >> 2
+				default:
+					v = (p(0, -1) + 2*p(-1, -1) + p(-1, 0) + 2) >> 2
+				}
+			case intraPredVerticalRight:
+				switch zVR := 2*x - y; {
+				case zVR >= 0 && zVR%2 == 0:
+					v = (p(x-(y>>1)-1, -1) + p(x-(y>>1), -1) + 1) >> 1
+				case zVR > 0:
+					v = (p(x-(y>>1)-2, -1) + 2*p(x-(y>>1)-1, -1) + p(x-(y>>1), -1) + 2) >> 2
+				case zVR == -1:
+					v = (p(-1, 0) + 2*p(-1, -1) + p(0, -1) + 2) >> 2
+				default:
+					v = (p(-1, y-1) + 2*p(-1, y-2) + p(-1, y-3) + 2) >> 2
+				}
+			case intraPredHorizontalDown:
+				switch zHD := 2*y - x; {
+				case zHD >= 0 && zHD%2 == 0:
+					v = (p(-1, y-(x>>1)-1) + p(-1, y-(x>>1)) + 1) >> 1
+				case zHD > 0:
+					v = (p(-1, y-(x>>1)-2) + 2*p(-1, y-(x>>1)-1) + p(-1, y-(x>>1)) + 2) >> 2
+				case zHD == -1:
+					v = (p(-1, 0) + 2*p(-1, -1) + p(0, -1) + 2) >> 2
+				default:
+					v = (p(x-1, -1) + 2*p(x-2, -1) + p(x-3, -1) + 2) >> 2
+				}
+			case intraPredVerticalLeft:
+				if y%2 == 0 {
+					v = (p(x+(y>>1), -1) + p(x+(y>>1)+1, -1) + 1) >> 1
+				} else {
+					v = (p(x+(y>>1), -1) + 2*p(x+(y>>1)+1, -1) + p(x+(y>>1)+2, -1) + 2) >> 2
+				}
+			case intraPredHorizontalUp:
+				switch zHU := x + 2*y; {
+				case zHU > 5:
+					v = p(-1, 3)
+				case zHU == 5:
+					v = (p(-1, 2) + 3*p(-1, 3) + 2) >> 2
+				case zHU%2 == 0:
+					v = (p(-1, y+(x>>1)) + p(-1, y+(x>>1)+1) + 1) >> 1
+				default:
+					v = (p(-1, y+(x>>1)) + 2*p(-1, y+(x>>1)+1) + p(-1, y+(x>>1)+2) + 2) >> 2
+				}
+			}
+			pred[4*y+x] = v
+		}
+	}
+	return nil
+}
+
+// dc returns the Intra_4x4_DC prediction of a block, being the mean of those
+// of the samples above and to the left that are available, or the mid value
+// for the bit depth if neither are (8.3.1.2.3).
+func (r *intra4x4Refs) dc(bitDepth int) int {
+	var sumTop, sumLeft int
+	for i := 0; i < 4; i++ {
+		sumTop += r.top[i+1]
+		sumLeft += r.left[i]
+	}
+	switch {
+	case r.hasTop && r.hasLeft:
+		return (sumTop + sumLeft + 4) >> 3
+	case r.hasTop:
+		return (sumTop + 2) >> 2
+	case r.hasLeft:
+		return (sumLeft + 2) >> 2
+	}
+	return 1 << uint(bitDepth-1)
+}
+
+var errIntraPredUnavailable = errors.New("intra prediction uses samples not available")
@@ -0,0 +1,156 @@
+/*
+NAME
+  intra4x4_test.go
+
+DESCRIPTION
+  intra4x4_test.go provides testing for functionality provided in intra4x4.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIntra4x4PredMode(t *testing.T) {
+	tests := []struct {
+		mbAddrs          []int
+		flags            func(mbAddr int) mbFlags
+		modes            map[int]int8 // Intra4x4PredMode by index in intraPredModes.
+		blkIdx           int
+		prevFlag         bool
+		rem              int
+		constrainedIntra bool
+		want             int
+	}{
+		// Neighbours not available, giving predIntra4x4PredMode DC.
+		{flags: noFlags, prevFlag: true, want: intraPredDC},
+		{flags: noFlags, rem: 1, want: intraPredHorizontal},
+		{flags: noFlags, rem: 2, want: intraPredDiagonalDownLeft},
+
+		// The lesser of the modes of block 5 of A and block 10 of B.
+		{
+			mbAddrs:  []int{ctxIncMbA, ctxIncMbB},
+			flags:    noFlags,
+			modes:    map[int]int8{ctxIncMbA*16 + 5: 7, ctxIncMbB*16 + 10: 4},
+			prevFlag: true,
+			want:     intraPredDiagonalDownRight,
+		},
+		{
+			mbAddrs: []int{ctxIncMbA, ctxIncMbB},
+			flags:   noFlags,
+			modes:   map[int]int8{ctxIncMbA*16 + 5: 7, ctxIncMbB*16 + 10: 4},
+			rem:     4,
+			want:    intraPredVerticalRight,
+		},
+		{
+			mbAddrs: []int{ctxIncMbA, ctxIncMbB},
+			flags:   noFlags,
+			modes:   map[int]int8{ctxIncMbA*16 + 5: 7, ctxIncMbB*16 + 10: 4},
+			rem:     3,
+			want:    intraPredDiagonalDownLeft,
+		},
+
+		// Block 3, whose neighbours are blocks 2 and 1 of the macroblock.
+		{
+			flags:    noFlags,
+			modes:    map[int]int8{ctxIncMbCurr*16 + 2: 8, ctxIncMbCurr*16 + 1: 6},
+			blkIdx:   3,
+			prevFlag: true,
+			want:     intraPredHorizontalDown,
+		},
+
+		// An inter neighbour with constrained intra prediction.
+		{
+			mbAddrs: []int{ctxIncMbA, ctxIncMbB},
+			flags: func(mbAddr int) mbFlags {
+				return map[int]mbFlags{ctxIncMbB: mbIntraCoded, ctxIncMbCurr: mbIntraCoded}[mbAddr]
+			},
+			modes:            map[int]int8{ctxIncMbA*16 + 5: 0, ctxIncMbB*16 + 10: 0},
+			prevFlag:         true,
+			constrainedIntra: true,
+			want:             intraPredDC,
+		},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(test.flags, test.mbAddrs...)
+		for idx, mode := range test.modes {
+			mbs.intraPredModes[idx] = mode
+		}
+		got := mbs.intra4x4PredMode(ctxIncMbCurr, test.blkIdx, test.prevFlag, test.rem, test.constrainedIntra)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+		if got := mbs.intraPredModes[ctxIncMbCurr*16+test.blkIdx]; int(got) != test.want {
+			t.Errorf("did not get expected mode recorded for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestPredIntra4x4(t *testing.T) {
+	// p[-1, -1] 10, p[x, -1] 20 to 90 and p[-1, y] 100 to 130.
+	all := intra4x4Refs{
+		top:         [9]int{10, 20, 30, 40, 50, 60, 70, 80, 90},
+		left:        [4]int{100, 110, 120, 130},
+		hasCorner:   true,
+		hasTop:      true,
+		hasTopRight: true,
+		hasLeft:     true,
+	}
+	noTopRight := all
+	noTopRight.hasTopRight = false
+	topOnly := all
+	topOnly.hasLeft, topOnly.hasCorner = false, false
+	none := intra4x4Refs{}
+
+	tests := []struct {
+		refs intra4x4Refs
+		mode int
+		want [16]int
+		err  error
+	}{
+		{refs: all, mode: intraPredVertical, want: [16]int{20, 30, 40, 50, 20, 30, 40, 50, 20, 30, 40, 50, 20, 30, 40, 50}},
+		{refs: all, mode: intraPredHorizontal, want: [16]int{100, 100, 100, 100, 110, 110, 110, 110, 120, 120, 120, 120, 130, 130, 130, 130}},
+		{refs: all, mode: intraPredDC, want: [16]int{75, 75, 75, 75, 75, 75, 75, 75, 75, 75, 75, 75, 75, 75, 75, 75}},
+		{refs: all, mode: intraPredDiagonalDownLeft, want: [16]int{30, 40, 50, 60, 40, 50, 60, 70, 50, 60, 70, 80, 60, 70, 80, 88}},
+		{refs: all, mode: intraPredDiagonalDownRight, want: [16]int{35, 20, 30, 40, 80, 35, 20, 30, 110, 80, 35, 20, 120, 110, 80, 35}},
+		{refs: all, mode: intraPredVerticalRight, want: [16]int{15, 25, 35, 45, 35, 20, 30, 40, 80, 15, 25, 35, 110, 35, 20, 30}},
+		{refs: all, mode: intraPredHorizontalDown, want: [16]int{55, 35, 20, 30, 105, 80, 55, 35, 115, 110, 105, 80, 125, 120, 115, 110}},
+		{refs: all, mode: intraPredVerticalLeft, want: [16]int{25, 35, 45, 55, 30, 40, 50, 60, 35, 45, 55, 65, 40, 50, 60, 70}},
+		{refs: all, mode: intraPredHorizontalUp, want: [16]int{105, 110, 115, 120, 115, 120, 125, 128, 125, 128, 130, 130, 130, 130, 130, 130}},
+
+		// p[x, -1] for x from 4 to 7 substituted by p[3, -1].
+		{refs: noTopRight, mode: intraPredDiagonalDownLeft, want: [16]int{30, 40, 48, 50, 40, 48, 50, 50, 48, 50, 50, 50, 50, 50, 50, 50}},
+
+		// DC with only samples above, and with none.
+		{refs: topOnly, mode: intraPredDC, want: [16]int{35, 35, 35, 35, 35, 35, 35, 35, 35, 35, 35, 35, 35, 35, 35, 35}},
+		{refs: none, mode: intraPredDC, want: [16]int{128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128, 128}},
+
+		// Modes using samples not available.
+		{refs: topOnly, mode: intraPredHorizontal, err: errIntraPredUnavailable},
+		{refs: topOnly, mode: intraPredVerticalRight, err: errIntraPredUnavailable},
+		{refs: none, mode: intraPredVerticalLeft, err: errIntraPredUnavailable},
+	}
+
+	for i, test := range tests {
+		var got [16]int
+		err := predIntra4x4(&got, &test.refs, test.mode, 8)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if err == nil && got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+
+	var pred [16]int
+	if err := predIntra4x4(&pred, &all, 9, 8); err == nil {
+		t.Errorf("did not get expected error for Intra4x4PredMode 9")
+	}
+}
@@ -0,0 +1,254 @@
+/*
+NAME
+  intra8x8.go
+
+DESCRIPTION
+  intra8x8.go provides the Intra_8x8 prediction of luma samples, being the
+  derivation of the prediction mode of each 8x8 luma block, the filtering of
+  the reference samples and the nine prediction modes, as specified by
+  section 8.3.2.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "fmt"
+
+// intra8x8PredMode derives Intra8x8PredMode of the 8x8 luma block with index
+// blkIdx of the macroblock with address currMbAddr from its
+// prev_intra8x8_pred_mode_flag and rem_intra8x8_pred_mode, as specified by
+// section 8.3.2.1, recording it in s for each 4x4 block of the 8x8 block.
+func (s *mbState) intra8x8PredMode(currMbAddr, blkIdx int, prevFlag bool, rem int, constrainedIntra bool) int {
+	mode := s.predIntra8x8PredMode(currMbAddr, blkIdx, constrainedIntra)
+	if !prevFlag {
+		if rem < mode {
+			mode = rem
+		} else {
+			mode = rem + 1
+		}
+	}
+	m := s.intraPredModes[currMbAddr*blocksPerMb+4*blkIdx:]
+	for i := 0; i < 4; i++ {
+		m[i] = int8(mode)
+	}
+	return mode
+}
+
+// predIntra8x8PredMode returns predIntra8x8PredMode of the 8x8 luma block
+// with index blkIdx of the macroblock with address currMbAddr, as for
+// predIntra4x4PredMode. The mode of an 8x8 block of an Intra_4x4 macroblock is
+// that of its 4x4 block with index 1 for the block to the left, or 3 for a
+// frame macroblock's field neighbour in an MBAFF frame to the left of block
+// 2, and 2 for the block above (8.3.2.1). Modes are recorded for each 4x4 block of
+// Intra_8x8 macroblocks, so the same holds for them.
+func (s *mbState) predIntra8x8PredMode(currMbAddr, blkIdx int, constrainedIntra bool) int {
+	blkA, blkB := s.neighbour8x8Blks(currMbAddr, blkIdx)
+	if !s.intraAvailable(blkA.mbAddr, currMbAddr, constrainedIntra) || !s.intraAvailable(blkB.mbAddr, currMbAddr, constrainedIntra) {
+		return intraPredDC
+	}
+
+	nA := 1
+	if s.mbaff && !s.has(currMbAddr, mbFieldDecoded) && s.has(blkA.mbAddr, mbFieldDecoded) && blkIdx == 2 {
+		nA = 3
+	}
+	modeA := s.intraPredModes[blkA.mbAddr*blocksPerMb+4*blkA.blkIdx+nA]
+	modeB := s.intraPredModes[blkB.mbAddr*blocksPerMb+4*blkB.blkIdx+2]
+	if modeA < modeB {
+		return int(modeA)
+	}
+	return int(modeB)
+}
+
+// intra8x8Refs holds the neighbouring samples p[x, y] of an 8x8 block used in
+// its Intra_8x8 prediction, and whether each is available for Intra
+// prediction.
+type intra8x8Refs struct {
+	top  [17]int // p[x, -1] for x from -1 to 15, the first being p[-1, -1].
+	left [8]int  // p[-1, y] for y from 0 to 7.
+
+	// Availability of p[-1, -1], p[x, -1] for x from 0 to 7 and from 8 to 15,
+	// and p[-1, y].
+	hasCorner, hasTop, hasTopRight, hasLeft bool
+}
+
+// p returns the sample p[x, y], where x or y is -1.
+func (r *intra8x8Refs) p(x, y int) int {
+	if y < 0 {
+		return r.top[x+1]
+	}
+	return r.left[y]
+}
+
+// filter returns the reference samples p'[x, y] resulting from filtering
+// those available of r, as specified by section 8.3.2.2.1. p[x, -1] for x
+// from 8 to 15 must have been substituted if not available.
+func (r *intra8x8Refs) filter() intra8x8Refs {
+	f := *r
+	p := r.p
+	if r.hasTop {
+		if r.hasCorner {
+			f.top[1] = (p(-1, -1) + 2*p(0, -1) + p(1, -1) + 2) >> 2
+		} else {
+			f.top[1] = (3*p(0, -1) + p(1, -1) + 2) >> 2
+		}
+		for x := 1; x < 15; x++ {
+			f.top[x+1] = (p(x-1, -1) + 2*p(x, -1) + p(x+1, -1) + 2) >> 2
+		}
+		f.top[16] = (p(14, -1) + 3*p(15, -1) + 2) >> 2
+	}
+
+	if r.hasCorner {
+		switch {
+		case r.hasTop && r.hasLeft:
+			f.top[0] = (p(0, -1) + 2*p(-1, -1) + p(-1, 0) + 2) >> 2
+		case r.hasTop:
+			f.top[0] = (3*p(-1, -1) + p(0, -1) + 2) >> 2
+		case r.hasLeft:
+			f.top[0] = (3*p(-1, -1) + p(-1, 0) + 2) >> 2
+		}
+	}
+
+	if r.hasLeft {
+		if r.hasCorner {
+			f.left[0] = (p(-1, -1) + 2*p(-1, 0) + p(-1, 1) + 2) >> 2
+		} else {
+			f.left[0] = (3*p(-1, 0) + p(-1, 1) + 2) >> 2
+		}
+		for y := 1; y < 7; y++ {
+			f.left[y] = (p(-1, y-1) + 2*p(-1, y) + p(-1, y+1) + 2) >> 2
+		}
+		f.left[7] = (p(-1, 6) + 3*p(-1, 7) + 2) >> 2
+	}
+	return f
+}
+
+// predIntra8x8 sets pred, the predicted samples of an 8x8 luma block in raster
+// order, using Intra8x8PredMode mode and the neighbouring samples r, of the
+// given bit depth, as specified by section 8.3.2.2. Unavailable samples
+// p[x, -1] for x from 8 to 15 are first substituted by p[7, -1], if
+// available, and the samples are then filtered. An error is returned if the
+// mode uses samples not available.
+func predIntra8x8(pred *[64]int, r *intra8x8Refs, mode, bitDepth int) error {
+	if !r.hasTopRight && r.hasTop {
+		for x := 8; x < 16; x++ {
+			r.top[x+1] = r.top[8]
+		}
+		r.hasTopRight = true
+	}
+
+	var ok bool
+	switch mode {
+	case intraPredVertical, intraPredDiagonalDownLeft, intraPredVerticalLeft:
+		ok = r.hasTop
+	case intraPredHorizontal, intraPredHorizontalUp:
+		ok = r.hasLeft
+	case intraPredDC:
+		ok = true
+	case intraPredDiagonalDownRight, intraPredVerticalRight, intraPredHorizontalDown:
+		ok = r.hasTop && r.hasLeft && r.hasCorner
+	default:
+		return fmt.Errorf("invalid Intra8x8PredMode: %d", mode)
+	}
+	if !ok {
+		return fmt.Errorf("%w: Intra8x8PredMode %d", errIntraPredUnavailable, mode)
+	}
+
+	f := r.filter()
+	if mode == intraPredDC {
+		dc := f.dc(bitDepth)
+		for i := range pred {
+			pred[i] = dc
+		}
+		return nil
+	}
+	p := f.p
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			var v int
+			switch mode {
+			case intraPredVertical:
+				v = p(x, -1)
+			case intraPredHorizontal:
+				v = p(-1, y)
+			case intraPredDiagonalDownLeft:
+				if x == 7 && y == 7 {
+					v = (p(14, -1) + 3*p(15, -1) + 2) >> 2
+				} else {
+					v = (p(x+y, -1) + 2*p(x+y+1, -1) + p(x+y+2, -1) + 2) >> 2
+				}
+			case intraPredDiagonalDownRight:
+				switch {
+				case x > y:
+					v = (p(x-y-2, -1) + 2*p(x-y-1, -1) + p(x-y, -1) + 2) >> 2
+				case x < y:
+					v = (p(-1, y-x-2) + 2*p(-1, y-x-1) + p(-1, y-x) + 2) >> 2
+				default:
+					v = (p(0, -1) + 2*p(-1, -1) + p(-1, 0) + 2) >> 2
+				}
+			case intraPredVerticalRight:
+				switch zVR := 2*x - y; {
+				case zVR >= 0 && zVR%2 == 0:
+					v = (p(x-(y>>1)-1, -1) + p(x-(y>>1), -1) + 1) >> 1
+				case zVR > 0:
+					v = (p(x-(y>>1)-2, -1) + 2*p(x-(y>>1)-1, -1) + p(x-(y>>1), -1) + 2) >> 2
+				case zVR == -1:
+					v = (p(-1, 0) + 2*p(-1, -1) + p(0, -1) + 2) >> 2
+				default:
+					v = (p(-1, y-2*x-1) + 2*p(-1, y-2*x-2) + p(-1, y-2*x-3) + 2) >> 2
+				}
+			case intraPredHorizontalDown:
+				switch zHD := 2*y - x; {
+				case zHD >= 0 && zHD%2 == 0:
+					v = (p(-1, y-(x>>1)-1) + p(-1, y-(x>>1)) + 1) >> 1
+				case zHD > 0:
+					v = (p(-1, y-(x>>1)-2) + 2*p(-1, y-(x>>1)-1) + p(-1, y-(x>>1)) + 2) >> 2
+				case zHD == -1:
+					v = (p(-1, 0) + 2*p(-1, -1) + p(0, -1) + 2) >> 2
+				default:
+					v = (p(x-2*y-1, -1) + 2*p(x-2*y-2, -1) + p(x-2*y-3, -1) + 2) >> 2
+				}
+			case intraPredVerticalLeft:
+				if y%2 == 0 {
+					v = (p(x+(y>>1), -1) + p(x+(y>>1)+1, -1) + 1) >> 1
+				} else {
+					v = (p(x+(y>>1), -1) + 2*p(x+(y>>1)+1, -1) + p(x+(y>>1)+2, -1) + 2) >> 2
+				}
+			case intraPredHorizontalUp:
+				switch zHU := x + 2*y; {
+				case zHU > 13:
+					v = p(-1, 7)
+				case zHU == 13:
+					v = (p(-1, 6) + 3*p(-1, 7) + 2) >> 2
+				case zHU%2 == 0:
+					v = (p(-1, y+(x>>1)) + p(-1, y+(x>>1)+1) + 1) >> 1
+				default:
+					v = (p(-1, y+(x>>1)) + 2*p(-1, y+(x>>1)+1) + p(-1, y+(x>>1)+2) + 2) >> 2
+				}
+			}
+			pred[8*y+x] = v
+		}
+	}
+	return nil
+}
+
+// dc returns the Intra_8x8_DC prediction of a block from the filtered
+// samples, being the mean of those above and to the left that are
+// available, or the mid value for the bit depth if neither are (8.3.2.2.4).
+func (r *intra8x8Refs) dc(bitDepth int) int {
+	var sumTop, sumLeft int
+	for i := 0; i < 8; i++ {
+		sumTop += r.top[i+1]
+		sumLeft += r.left[i]
+	}
+	switch {
+	case r.hasTop && r.hasLeft:
+		return (sumTop + sumLeft + 8) >> 4
+	case r.hasTop:
+		return (sumTop + 4) >> 3
+	case r.hasLeft:
+		return (sumLeft + 4) >> 3
+	}
+	return 1 << uint(bitDepth-1)
+}
@@ -0,0 +1,187 @@
+/*
+NAME
+  intra8x8_test.go
+
+DESCRIPTION
+  intra8x8_test.go provides testing for functionality provided in intra8x8.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIntra8x8PredMode(t *testing.T) {
+	tests := []struct {
+		mbAddrs          []int
+		flags            func(mbAddr int) mbFlags
+		modes            map[int]int8 // Intra4x4PredMode by index in intraPredModes.
+		blkIdx           int
+		prevFlag         bool
+		rem              int
+		constrainedIntra bool
+		want             int
+	}{
+		// Neighbours not available, giving predIntra8x8PredMode DC.
+		{flags: noFlags, prevFlag: true, want: intraPredDC},
+		{flags: noFlags, rem: 2, want: intraPredDiagonalDownLeft},
+
+		// Intra_4x4 neighbours A and B, whose 8x8 blocks 1 and 2 give the
+		// modes of their 4x4 blocks 5 and 10.
+		{
+			mbAddrs:  []int{ctxIncMbA, ctxIncMbB},
+			flags:    noFlags,
+			modes:    map[int]int8{ctxIncMbA*16 + 4: 0, ctxIncMbA*16 + 5: 6, ctxIncMbB*16 + 8: 0, ctxIncMbB*16 + 10: 7},
+			prevFlag: true,
+			want:     intraPredHorizontalDown,
+		},
+		{
+			mbAddrs: []int{ctxIncMbA, ctxIncMbB},
+			flags:   noFlags,
+			modes:   map[int]int8{ctxIncMbA*16 + 4: 0, ctxIncMbA*16 + 5: 6, ctxIncMbB*16 + 8: 0, ctxIncMbB*16 + 10: 7},
+			rem:     6,
+			want:    intraPredVerticalLeft,
+		},
+
+		// Block 3, whose neighbours are blocks 2 and 1 of the macroblock.
+		{
+			flags: noFlags,
+			modes: map[int]int8{
+				ctxIncMbCurr*16 + 8: 8, ctxIncMbCurr*16 + 9: 8, ctxIncMbCurr*16 + 10: 8, ctxIncMbCurr*16 + 11: 8,
+				ctxIncMbCurr*16 + 4: 5, ctxIncMbCurr*16 + 5: 5, ctxIncMbCurr*16 + 6: 5, ctxIncMbCurr*16 + 7: 5,
+			},
+			blkIdx:   3,
+			prevFlag: true,
+			want:     intraPredVerticalRight,
+		},
+
+		// An inter neighbour with constrained intra prediction.
+		{
+			mbAddrs: []int{ctxIncMbA, ctxIncMbB},
+			flags: func(mbAddr int) mbFlags {
+				return map[int]mbFlags{ctxIncMbA: mbIntraCoded, ctxIncMbCurr: mbIntraCoded}[mbAddr]
+			},
+			modes:            map[int]int8{ctxIncMbA*16 + 5: 0, ctxIncMbB*16 + 10: 0},
+			prevFlag:         true,
+			constrainedIntra: true,
+			want:             intraPredDC,
+		},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(test.flags, test.mbAddrs...)
+		for idx, mode := range test.modes {
+			mbs.intraPredModes[idx] = mode
+		}
+		got := mbs.intra8x8PredMode(ctxIncMbCurr, test.blkIdx, test.prevFlag, test.rem, test.constrainedIntra)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+		for j := 0; j < 4; j++ {
+			if got := mbs.intraPredModes[ctxIncMbCurr*16+4*test.blkIdx+j]; int(got) != test.want {
+				t.Errorf("did not get expected mode recorded for 4x4 block: %d for test: %d\nGot: %v\nWant: %v", j, i, got, test.want)
+			}
+		}
+	}
+}
+
+func TestIntra8x8RefsFilter(t *testing.T) {
+	// p[-1, -1] 40, p[x, -1] 50 to 110 in steps of 4 and p[-1, y] 60 to 102
+	// in steps of 6, filtered with and without p[-1, -1] available.
+	r := intra8x8Refs{left: [8]int{60, 66, 72, 78, 84, 90, 96, 102}, hasCorner: true, hasTop: true, hasTopRight: true, hasLeft: true}
+	r.top[0] = 40
+	for x := 0; x < 16; x++ {
+		r.top[x+1] = 50 + 4*x
+	}
+
+	tests := []struct {
+		hasCorner bool
+		wantTop   [17]int
+		wantLeft  [8]int
+	}{
+		{
+			hasCorner: true,
+			wantTop:   [17]int{48, 49, 54, 58, 62, 66, 70, 74, 78, 82, 86, 90, 94, 98, 102, 106, 109},
+			wantLeft:  [8]int{57, 66, 72, 78, 84, 90, 96, 101},
+		},
+		{
+			wantTop:  [17]int{40, 51, 54, 58, 62, 66, 70, 74, 78, 82, 86, 90, 94, 98, 102, 106, 109},
+			wantLeft: [8]int{62, 66, 72, 78, 84, 90, 96, 101},
+		},
+	}
+
+	for i, test := range tests {
+		r := r
+		r.hasCorner = test.hasCorner
+		got := r.filter()
+		if got.top != test.wantTop || got.left != test.wantLeft {
+			t.Errorf("did not get expected result for test: %d\nGot: %v, %v\nWant: %v, %v", i, got.top, got.left, test.wantTop, test.wantLeft)
+		}
+	}
+}
+
+func TestPredIntra8x8(t *testing.T) {
+	// The samples of TestIntra8x8RefsFilter.
+	all := intra8x8Refs{left: [8]int{60, 66, 72, 78, 84, 90, 96, 102}, hasCorner: true, hasTop: true, hasTopRight: true, hasLeft: true}
+	all.top[0] = 40
+	for x := 0; x < 16; x++ {
+		all.top[x+1] = 50 + 4*x
+	}
+	noTopRight := all
+	noTopRight.hasTopRight = false
+	leftOnly := all
+	leftOnly.hasTop, leftOnly.hasTopRight, leftOnly.hasCorner = false, false, false
+
+	tests := []struct {
+		refs intra8x8Refs
+		mode int
+		want map[int]int // Predicted samples by index in raster order.
+		err  error
+	}{
+		{refs: all, mode: intraPredVertical, want: map[int]int{0: 49, 7: 78, 63: 78}},
+		{refs: all, mode: intraPredHorizontal, want: map[int]int{0: 57, 7: 57, 56: 101}},
+		{refs: all, mode: intraPredDC, want: map[int]int{0: 72, 63: 72}},
+		{refs: all, mode: intraPredDiagonalDownLeft, want: map[int]int{0: 54, 7: 82, 9: 62, 56: 82, 63: 108}},
+		{refs: all, mode: intraPredDiagonalDownRight, want: map[int]int{0: 51, 7: 74, 9: 51, 16: 65, 56: 96}},
+		{refs: all, mode: intraPredVerticalRight, want: map[int]int{0: 49, 7: 76, 9: 50, 16: 57, 56: 90, 63: 62}},
+		{refs: all, mode: intraPredHorizontalDown, want: map[int]int{0: 53, 7: 70, 9: 57, 16: 69, 56: 99, 63: 78}},
+		{refs: all, mode: intraPredVerticalLeft, want: map[int]int{0: 52, 7: 80, 9: 58, 16: 56, 56: 66, 63: 94}},
+		{refs: all, mode: intraPredHorizontalUp, want: map[int]int{0: 62, 7: 84, 9: 72, 16: 75, 56: 101, 63: 101}},
+
+		// p[x, -1] for x from 8 to 15 substituted by p[7, -1] of 78, which
+		// filtering leaves as 78.
+		{refs: noTopRight, mode: intraPredDiagonalDownLeft, want: map[int]int{7: 78, 63: 78}},
+
+		// DC from the filtered samples to the left, p'[-1, 0] being 62.
+		{refs: leftOnly, mode: intraPredDC, want: map[int]int{0: 81, 63: 81}},
+
+		// Modes using samples not available.
+		{refs: leftOnly, mode: intraPredVertical, err: errIntraPredUnavailable},
+		{refs: leftOnly, mode: intraPredDiagonalDownRight, err: errIntraPredUnavailable},
+	}
+
+	for i, test := range tests {
+		var got [64]int
+		refs := test.refs
+		err := predIntra8x8(&got, &refs, test.mode, 8)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		for idx, want := range test.want {
+			if got[idx] != want {
+				t.Errorf("did not get expected sample at (%d, %d) for test: %d\nGot: %d\nWant: %d", idx%8, idx/8, i, got[idx], want)
+			}
+		}
+	}
+
+	var pred [64]int
+	if err := predIntra8x8(&pred, &all, 9, 8); err == nil {
+		t.Errorf("did not get expected error for Intra8x8PredMode 9")
+	}
+}
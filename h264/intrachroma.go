@@ -0,0 +1,115 @@
+/*
+NAME
+  intrachroma.go
+
+DESCRIPTION
+  intrachroma.go provides the Intra prediction of chroma samples when
+  ChromaArrayType is 1 or 2, being the four prediction modes of section
+  8.3.4.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "fmt"
+
+// intra_chroma_pred_mode values (table 8-5).
+const (
+	intraChromaPredDC = iota
+	intraChromaPredHorizontal
+	intraChromaPredVertical
+	intraChromaPredPlane
+)
+
+// predIntraChroma sets pred, the predicted samples of a chroma block of the
+// width and height of r's top and left samples, 8x8 or 8x16, in raster
+// order, using intra_chroma_pred_mode mode and the neighbouring samples r,
+// of the given bit depth, as specified by section 8.3.4. An error is
+// returned if the mode uses samples not available.
+func predIntraChroma(pred []int, r *intraRefs, mode, bitDepth int) error {
+	var ok bool
+	switch mode {
+	case intraChromaPredDC:
+		ok = true
+	case intraChromaPredHorizontal:
+		ok = r.hasLeft
+	case intraChromaPredVertical:
+		ok = r.hasTop
+	case intraChromaPredPlane:
+		ok = r.hasTop && r.hasLeft && r.hasCorner
+	default:
+		return fmt.Errorf("invalid intra_chroma_pred_mode: %d", mode)
+	}
+	if !ok {
+		return fmt.Errorf("%w: intra_chroma_pred_mode %d", errIntraPredUnavailable, mode)
+	}
+
+	w, h := len(r.top), len(r.left)
+	switch mode {
+	case intraChromaPredDC:
+		for yO := 0; yO < h; yO += 4 {
+			for xO := 0; xO < w; xO += 4 {
+				dc := r.chromaDC(xO, yO, bitDepth)
+				for y := yO; y < yO+4; y++ {
+					for x := xO; x < xO+4; x++ {
+						pred[y*w+x] = dc
+					}
+				}
+			}
+		}
+	case intraChromaPredHorizontal:
+		for i := range pred {
+			pred[i] = r.left[i/w]
+		}
+	case intraChromaPredVertical:
+		for i := range pred {
+			pred[i] = r.top[i%w]
+		}
+	case intraChromaPredPlane:
+		r.plane(pred, bitDepth)
+	}
+	return nil
+}
+
+// chromaDC returns the DC prediction of the 4x4 chroma block whose top left
+// sample is at (xO, yO) in the chroma block, as specified by section
+// 8.3.4.3. Blocks on the top row, other than the first, are predicted
+// preferably from the samples above them and those on the left column,
+// other than the first, from those to their left; the others from both.
+func (r *intraRefs) chromaDC(xO, yO, bitDepth int) int {
+	var sumTop, sumLeft int
+	for i := 0; i < 4; i++ {
+		sumTop += r.top[xO+i]
+		sumLeft += r.left[yO+i]
+	}
+	top, left := (sumTop+2)>>2, (sumLeft+2)>>2
+
+	switch {
+	case xO > 0 && yO == 0:
+		switch {
+		case r.hasTop:
+			return top
+		case r.hasLeft:
+			return left
+		}
+	case xO == 0 && yO > 0:
+		switch {
+		case r.hasLeft:
+			return left
+		case r.hasTop:
+			return top
+		}
+	default:
+		switch {
+		case r.hasTop && r.hasLeft:
+			return (sumTop + sumLeft + 4) >> 3
+		case r.hasLeft:
+			return left
+		case r.hasTop:
+			return top
+		}
+	}
+	return 1 << uint(bitDepth-1)
+}
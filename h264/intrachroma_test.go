@@ -0,0 +1,87 @@
+/*
+NAME
+  intrachroma_test.go
+
+DESCRIPTION
+  intrachroma_test.go provides testing for functionality provided in
+  intrachroma.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPredIntraChroma(t *testing.T) {
+	linear := func(x, y int) int { return 50 + 3*x + 2*y }
+
+	// 8x8 blocks whose 4x4 blocks have different samples above and to the
+	// left, with both, either and neither available.
+	refs := func(hasTop, hasLeft bool) intraRefs {
+		return intraRefs{
+			top:     []int{10, 10, 10, 10, 50, 50, 50, 50},
+			left:    []int{20, 20, 20, 20, 60, 60, 60, 60},
+			hasTop:  hasTop,
+			hasLeft: hasLeft,
+		}
+	}
+	// dc returns the samples of an 8x8 block with its 4x4 blocks of the given
+	// DC predictions, in raster order.
+	dc := func(v ...int) func(x, y int) int {
+		return func(x, y int) int { return v[2*(y/4)+x/4] }
+	}
+
+	tests := []struct {
+		refs intraRefs
+		mode int
+		want func(x, y int) int
+		err  error
+	}{
+		{refs: refs(true, true), mode: intraChromaPredDC, want: dc(15, 50, 60, 55)},
+		{refs: refs(true, false), mode: intraChromaPredDC, want: dc(10, 50, 10, 50)},
+		{refs: refs(false, true), mode: intraChromaPredDC, want: dc(20, 20, 60, 60)},
+		{refs: refs(false, false), mode: intraChromaPredDC, want: dc(128, 128, 128, 128)},
+
+		// 8x16 blocks, of 4:2:2, from samples on a plane.
+		{refs: intraTestRefs(8, 16, linear), mode: intraChromaPredHorizontal, want: func(x, y int) int { return linear(-1, y) }},
+		{refs: intraTestRefs(8, 16, linear), mode: intraChromaPredVertical, want: func(x, y int) int { return linear(x, -1) }},
+		{refs: intraTestRefs(8, 16, linear), mode: intraChromaPredPlane, want: linear},
+		{refs: intraTestRefs(8, 8, linear), mode: intraChromaPredPlane, want: linear},
+
+		// Modes using samples not available.
+		{refs: refs(true, false), mode: intraChromaPredHorizontal, err: errIntraPredUnavailable},
+		{refs: refs(false, true), mode: intraChromaPredVertical, err: errIntraPredUnavailable},
+		{refs: refs(true, true), mode: intraChromaPredPlane, err: errIntraPredUnavailable},
+	}
+
+	for i, test := range tests {
+		w, h := len(test.refs.top), len(test.refs.left)
+		got := make([]int, w*h)
+		err := predIntraChroma(got, &test.refs, test.mode, 8)
+		if !errors.Is(err, test.err) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		want := make([]int, w*h)
+		for j := range want {
+			want[j] = test.want(j%w, j/w)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, want)
+		}
+	}
+
+	r := refs(true, true)
+	if err := predIntraChroma(make([]int, 64), &r, 4, 8); err == nil {
+		t.Errorf("did not get expected error for intra_chroma_pred_mode 4")
+	}
+}
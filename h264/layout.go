@@ -0,0 +1,144 @@
+/*
+NAME
+  layout.go
+
+DESCRIPTION
+  layout.go provides the memory layout of output frames, allowing plane rows
+  to be aligned and planes to be given borders, so that frames may be passed
+  directly to DMA based encoders and displays without copying.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// Limits of the alignment and padding of output frames.
+const (
+	maxAlign   = 4096
+	maxPadding = 1024
+)
+
+// FrameLayout is the memory layout of the planes of an output frame held in a
+// single buffer, being the Y plane, followed by the Cb and Cr planes. Each
+// plane is surrounded by a border of padding samples, and its rows, and the
+// first sample of the picture in each row, begin at offsets that are multiples
+// of the alignment. Offsets and strides are in bytes.
+type FrameLayout struct {
+	Bounds         image.Rectangle // Bounds of the picture.
+	SubsampleRatio image.YCbCrSubsampleRatio
+	BytesPerSample int // 1 for 8 bit samples, otherwise 2.
+
+	YStride, CStride int // Bytes from the start of one row to the next.
+
+	// Offsets of the first sample of the picture in each plane, i.e. that at
+	// Bounds.Min.
+	YOffset, CbOffset, CrOffset int
+
+	Size int // Size of the frame buffer.
+}
+
+// NewFrameLayout returns the layout of frames with the given bounds, for
+// pictures using sps, with rows aligned to multiples of align bytes and a
+// border of padding luma samples around the picture. Chroma planes have a
+// border of padding chroma samples scaled by the chroma subsampling, rounded
+// up. Monochrome pictures are given 4:2:0 chroma planes.
+func NewFrameLayout(sps *SPS, bounds image.Rectangle, align, padding int) FrameLayout {
+	subW, subH, ratio := 2, 2, image.YCbCrSubsampleRatio420
+	switch sps.ChromaFormat {
+	case chroma422:
+		subW, subH, ratio = 2, 1, image.YCbCrSubsampleRatio422
+	case chroma444:
+		subW, subH, ratio = 1, 1, image.YCbCrSubsampleRatio444
+	}
+
+	l := FrameLayout{Bounds: bounds, SubsampleRatio: ratio, BytesPerSample: 1}
+	if sps.BitDepthLumaMinus8 > 0 || sps.BitDepthChromaMinus8 > 0 {
+		l.BytesPerSample = 2
+	}
+
+	// plane returns the stride, offset of the first picture sample and size
+	// of a plane of w by h samples with the given borders.
+	plane := func(w, h, padX, padY int) (stride, offset, size int) {
+		left := alignUp(padX*l.BytesPerSample, align)
+		stride = alignUp(left+(w+padX)*l.BytesPerSample, align)
+		return stride, padY*stride + left, (h + 2*padY) * stride
+	}
+
+	w, h := bounds.Dx(), bounds.Dy()
+	var ySize, cSize int
+	l.YStride, l.YOffset, ySize = plane(w, h, padding, padding)
+	l.CStride, l.CbOffset, cSize = plane(ceilDiv(w, subW), ceilDiv(h, subH), ceilDiv(padding, subW), ceilDiv(padding, subH))
+	l.CbOffset += ySize
+	l.CrOffset = l.CbOffset + cSize
+	l.Size = ySize + 2*cSize
+	return l
+}
+
+// Frame returns a frame using buf, which must be at least Size bytes, as its
+// storage, e.g. a buffer mapped for DMA. Rows of the frame are aligned in
+// memory if buf is. Frames are only available for 8 bit samples, as
+// image.YCbCr holds 8 bit samples.
+func (l FrameLayout) Frame(buf []byte) (*image.YCbCr, error) {
+	if l.BytesPerSample != 1 {
+		return nil, errHighBitDepth
+	}
+	if len(buf) < l.Size {
+		return nil, fmt.Errorf("frame buffer of %d bytes smaller than %d", len(buf), l.Size)
+	}
+	return &image.YCbCr{
+		Y:              buf[l.YOffset:l.CbOffset:l.CbOffset],
+		Cb:             buf[l.CbOffset:l.CrOffset:l.CrOffset],
+		Cr:             buf[l.CrOffset:l.Size:l.Size],
+		YStride:        l.YStride,
+		CStride:        l.CStride,
+		SubsampleRatio: l.SubsampleRatio,
+		Rect:           l.Bounds,
+	}, nil
+}
+
+// NewFrame returns a frame with newly allocated storage. See Frame.
+func (l FrameLayout) NewFrame() (*image.YCbCr, error) {
+	return l.Frame(make([]byte, l.Size))
+}
+
+// OutputLayout returns the layout of the frames into which pictures using the
+// most recent SPS are decoded, see decodeLayout, the region of which that is
+// output being given by OutputBounds. The zero FrameLayout is returned if no
+// SPS has been decoded.
+func (h *H264Reader) OutputLayout() FrameLayout {
+	sps := h.ParameterSets.last
+	if sps == nil {
+		return FrameLayout{}
+	}
+	return decodeLayout(sps, h.outputAlign, h.outputPadding)
+}
+
+// decodeLayout returns the layout of the frames into which pictures using sps
+// are decoded, holding the full picture, with the alignment and padding given
+// by WithOutputLayout, or no alignment or padding if align is 0.
+func decodeLayout(sps *SPS, align, padding int) FrameLayout {
+	if align == 0 {
+		align = 1
+	}
+	return NewFrameLayout(sps, sps.FullPicture(), align, padding)
+}
+
+// alignUp returns n rounded up to a multiple of align, which must be a power
+// of two.
+func alignUp(n, align int) int {
+	return (n + align - 1) &^ (align - 1)
+}
+
+// ceilDiv returns a divided by b, rounded up.
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+var errHighBitDepth = errors.New("frames with bit depth greater than 8 are not supported")
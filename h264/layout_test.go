@@ -0,0 +1,175 @@
+/*
+NAME
+  layout_test.go
+
+DESCRIPTION
+  layout_test.go provides testing for functionality provided in layout.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// TestNewFrameLayout checks plane strides, offsets and sizes for combinations
+// of alignment, padding, chroma format and bit depth.
+func TestNewFrameLayout(t *testing.T) {
+	r := image.Rect(0, 0, 16, 16)
+	tests := []struct {
+		sps     *SPS
+		align   int
+		padding int
+		want    FrameLayout
+	}{
+		{
+			sps:   &SPS{ChromaFormat: chroma420},
+			align: 1,
+			want: FrameLayout{
+				Bounds: r, SubsampleRatio: image.YCbCrSubsampleRatio420, BytesPerSample: 1,
+				YStride: 16, CStride: 8, YOffset: 0, CbOffset: 256, CrOffset: 320, Size: 384,
+			},
+		},
+		{
+			sps:     &SPS{ChromaFormat: chroma420},
+			align:   64,
+			padding: 16,
+			want: FrameLayout{
+				Bounds: r, SubsampleRatio: image.YCbCrSubsampleRatio420, BytesPerSample: 1,
+				YStride: 128, CStride: 128, YOffset: 2112, CbOffset: 7232, CrOffset: 10304, Size: 12288,
+			},
+		},
+		{
+			sps:   &SPS{ChromaFormat: chroma422, BitDepthLumaMinus8: 2, BitDepthChromaMinus8: 2},
+			align: 1,
+			want: FrameLayout{
+				Bounds: r, SubsampleRatio: image.YCbCrSubsampleRatio422, BytesPerSample: 2,
+				YStride: 32, CStride: 16, YOffset: 0, CbOffset: 512, CrOffset: 768, Size: 1024,
+			},
+		},
+		{
+			sps:     &SPS{ChromaFormat: chromaMonochrome},
+			align:   16,
+			padding: 3,
+			want: FrameLayout{
+				Bounds: r, SubsampleRatio: image.YCbCrSubsampleRatio420, BytesPerSample: 1,
+				YStride: 48, CStride: 32, YOffset: 160, CbOffset: 1136, CrOffset: 1520, Size: 1824,
+			},
+		},
+	}
+
+	for i, test := range tests {
+		got := NewFrameLayout(test.sps, r, test.align, test.padding)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, got, test.want)
+		}
+	}
+}
+
+// TestFrame checks that frames use the planes of the frame buffer given by
+// their layout.
+func TestFrame(t *testing.T) {
+	l := NewFrameLayout(&SPS{ChromaFormat: chroma420}, image.Rect(0, 0, 16, 16), 64, 16)
+	buf := make([]byte, l.Size)
+	f, err := l.Frame(buf)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Frame", err)
+	}
+	if f.Bounds() != l.Bounds {
+		t.Errorf("did not get expected bounds\nGot: %v\nWant: %v", f.Bounds(), l.Bounds)
+	}
+
+	f.Y[f.YOffset(3, 2)] = 1
+	f.Cb[f.COffset(3, 2)] = 2
+	f.Cr[f.COffset(15, 15)] = 3
+	for _, test := range []struct {
+		off  int
+		want byte
+	}{
+		{off: l.YOffset + 2*l.YStride + 3, want: 1},
+		{off: l.CbOffset + 1*l.CStride + 1, want: 2},
+		{off: l.CrOffset + 7*l.CStride + 7, want: 3},
+	} {
+		if buf[test.off] != test.want {
+			t.Errorf("did not get expected sample at offset %d\nGot: %d\nWant: %d", test.off, buf[test.off], test.want)
+		}
+	}
+
+	_, err = l.Frame(buf[:l.Size-1])
+	if err == nil {
+		t.Errorf("did not get expected error for short frame buffer")
+	}
+	_, err = NewFrameLayout(&SPS{BitDepthLumaMinus8: 2}, l.Bounds, 1, 0).NewFrame()
+	if err != errHighBitDepth {
+		t.Errorf("did not get expected error for high bit depth\nGot: %v\nWant: %v", err, errHighBitDepth)
+	}
+}
+
+// TestWithOutputLayout checks option validation and the layout of output
+// frames for the SPS of a stream.
+func TestWithOutputLayout(t *testing.T) {
+	for _, test := range []struct{ align, padding int }{{0, 0}, {48, 0}, {8192, 0}, {64, -1}, {64, 2048}} {
+		_, err := NewH264Reader(nil, WithOutputLayout(test.align, test.padding))
+		if err == nil {
+			t.Errorf("did not get expected error for alignment %d and padding %d", test.align, test.padding)
+		}
+	}
+
+	h, err := NewH264Reader(bytes.NewReader(annexB(testSPS)), WithOutputLayout(64, 16))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	if (h.OutputLayout() != FrameLayout{}) {
+		t.Errorf("did not get zero layout before SPS decoded")
+	}
+	err = h.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+	want := NewFrameLayout(h.ParameterSets.last, image.Rect(0, 0, 16, 16), 64, 16)
+	if got := h.OutputLayout(); got != want {
+		t.Errorf("did not get expected layout\nGot: %+v\nWant: %+v", got, want)
+	}
+	h.arena.picture(h.ParameterSets.last)
+	if got := h.arena.layout; got != want {
+		t.Errorf("did not get expected layout of decoded frame\nGot: %+v\nWant: %+v", got, want)
+	}
+}
+
+// TestDecodeFrameLayout checks that the frames into which pictures are
+// decoded have the planes, strides and offsets of their layout.
+func TestDecodeFrameLayout(t *testing.T) {
+	sps := &SPS{ChromaFormat: chroma420, PicWidthInMbsMinus1: 1, FrameMbsOnly: true}
+	a := &arena{align: 64, padding: 16}
+	pic := a.picture(sps)
+	l := NewFrameLayout(sps, image.Rect(0, 0, 32, 16), 64, 16)
+	if a.layout != l {
+		t.Fatalf("did not get expected layout\nGot: %+v\nWant: %+v", a.layout, l)
+	}
+	if pic.YStride != l.YStride || pic.CStride != l.CStride {
+		t.Errorf("did not get expected strides\nGot: %d, %d\nWant: %d, %d", pic.YStride, pic.CStride, l.YStride, l.CStride)
+	}
+	if l.YStride%64 != 0 || l.YOffset%64 != 0 || l.CStride%64 != 0 {
+		t.Errorf("did not get aligned layout: %+v", l)
+	}
+	for i, p := range []struct {
+		plane  []byte
+		offset int
+	}{{pic.Y, l.YOffset}, {pic.Cb, l.CbOffset}, {pic.Cr, l.CrOffset}} {
+		if &p.plane[0] != &a.buf[p.offset] {
+			t.Errorf("did not get plane %d at expected offset: %d", i, p.offset)
+		}
+	}
+	if a.picture(sps) != pic {
+		t.Error("expected frame to be reused for same layout")
+	}
+	a.padding = 0
+	if a.picture(sps) == pic {
+		t.Error("expected new frame for new layout")
+	}
+}
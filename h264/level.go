@@ -0,0 +1,182 @@
+/*
+NAME
+  level.go
+
+DESCRIPTION
+  level.go provides validation of an SPS against the level limits of table
+  A-1, for verifying encoder configurations.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"fmt"
+	"math"
+)
+
+// levelLimits holds the limits of a level, as given in table A-1.
+type levelLimits struct {
+	maxMBPS   int64 // Macroblocks per second.
+	maxFS     int64 // Frame size in macroblocks.
+	maxDpbMbs int64 // Decoded picture buffer size in macroblocks.
+	maxBR     int64 // Bit rate in units of cpbBrVclFactor or cpbBrNalFactor bits/s.
+}
+
+// levelTable holds the limits of each level, as given in table A-1.
+var levelTable = map[Level]levelLimits{
+	Level1:  {1485, 99, 396, 64},
+	Level1b: {1485, 99, 396, 128},
+	Level11: {3000, 396, 900, 192},
+	Level12: {6000, 396, 2376, 384},
+	Level13: {11880, 396, 2376, 768},
+	Level2:  {11880, 396, 2376, 2000},
+	Level21: {19800, 792, 4752, 4000},
+	Level22: {20250, 1620, 8100, 4000},
+	Level3:  {40500, 1620, 8100, 10000},
+	Level31: {108000, 3600, 18000, 14000},
+	Level32: {216000, 5120, 20480, 20000},
+	Level4:  {245760, 8192, 32768, 20000},
+	Level41: {245760, 8192, 32768, 50000},
+	Level42: {522240, 8704, 34816, 50000},
+	Level5:  {589824, 22080, 110400, 135000},
+	Level51: {983040, 36864, 184320, 240000},
+	Level52: {2073600, 36864, 184320, 240000},
+	Level6:  {4177920, 139264, 696320, 240000},
+	Level61: {8355840, 139264, 696320, 480000},
+	Level62: {16711680, 139264, 696320, 800000},
+}
+
+// LevelViolation describes an SPS exceeding a limit of its level.
+type LevelViolation struct {
+	Limit  string // Name of the limit in table A-1, e.g. "MaxFS".
+	Detail string // What is limited, e.g. "frame size in macroblocks".
+	Value  int64  // Value given by the SPS.
+	Max    int64  // Maximum allowed by the level.
+}
+
+// String returns a single line description of the violation.
+func (v LevelViolation) String() string {
+	return fmt.Sprintf("%s: %s %d exceeds %d", v.Limit, v.Detail, v.Value, v.Max)
+}
+
+// CheckLevel checks the SPS against the limits of table A-1 for its level, as
+// applied by section A.3, returning the limits that are exceeded, or none if
+// the SPS conforms. MaxFS is checked against the frame size and its width and
+// height, MaxDpbMbs against max_num_ref_frames and max_dec_frame_buffering,
+// MaxMBPS against the macroblock rate if the VUI gives a fixed frame rate, and
+// MaxBR against each bit rate of the HRD parameters. An error is returned if
+// the level is not one of table A-1.
+func (s *SPS) CheckLevel() ([]LevelViolation, error) {
+	limits, ok := levelTable[s.Level()]
+	if !ok {
+		return nil, fmt.Errorf("unknown level_idc %d", s.LevelIDC)
+	}
+
+	var v []LevelViolation
+	check := func(limit, detail string, value, max int64) {
+		if value > max {
+			v = append(v, LevelViolation{Limit: limit, Detail: detail, Value: value, Max: max})
+		}
+	}
+
+	width := int64(s.PicWidthInMbsMinus1 + 1)
+	height := int64(s.PicHeightInMapUnitsMinus1+1) * int64(2-flagVal(s.FrameMbsOnly))
+	frameSize := s.frameSizeInMbs()
+	check("MaxFS", "frame size in macroblocks", frameSize, limits.maxFS)
+	maxDim := int64(math.Sqrt(float64(limits.maxFS * 8)))
+	check("MaxFS", "frame width in macroblocks", width, maxDim)
+	check("MaxFS", "frame height in macroblocks", height, maxDim)
+
+	maxDpbFrames := int64(levelMaxDpbFrames(limits, frameSize))
+	check("MaxDpbMbs", "max_num_ref_frames", int64(s.MaxNumRefFrames), maxDpbFrames)
+	if s.VUI != nil && s.VUI.BitstreamRestriction {
+		check("MaxDpbMbs", "max_dec_frame_buffering", int64(s.VUI.MaxDecFrameBuffering), maxDpbFrames)
+	}
+
+	if num, den, ok := s.FrameRate(); ok {
+		mbps := (frameSize*int64(num) + int64(den) - 1) / int64(den)
+		check("MaxMBPS", "macroblocks per second", mbps, limits.maxMBPS)
+	}
+
+	if s.VUI != nil {
+		vclFactor, nalFactor := cpbBrFactors(s.ProfileIDC)
+		for _, hrd := range []struct {
+			params *HRDParameters
+			name   string
+			factor int64
+		}{
+			{s.VUI.VclHRD, "VCL HRD bit rate", vclFactor},
+			{s.VUI.NalHRD, "NAL HRD bit rate", nalFactor},
+		} {
+			if hrd.params == nil {
+				continue
+			}
+			for i := range hrd.params.BitRateValueMinus1 {
+				check("MaxBR", hrd.name, int64(hrd.params.BitRate(i)), hrd.factor*limits.maxBR)
+			}
+		}
+	}
+	return v, nil
+}
+
+// MaxDPBFrames returns the size of the decoded picture buffer in frames, for
+// sizing the DPB and output reordering. This is max_dec_frame_buffering if
+// given by the VUI, and otherwise MaxDpbFrames as derived from MaxDpbMbs of
+// the level by section A.3.1 item h, i.e. Min(MaxDpbMbs / (PicWidthInMbs *
+// FrameHeightInMbs), 16), or 0 for the intra profiles as inferred by section
+// E.2.1. 16 is used for MaxDpbFrames if the level is not one of table A-1. As
+// streams may exceed the limits of their level, the result is never less than
+// max_num_ref_frames.
+func (s *SPS) MaxDPBFrames() int {
+	var n int
+	switch {
+	case s.VUI != nil && s.VUI.BitstreamRestriction:
+		n = s.VUI.MaxDecFrameBuffering
+	case s.Profile().Intra():
+		n = 0
+	default:
+		n = 16
+		if limits, ok := levelTable[s.Level()]; ok {
+			n = levelMaxDpbFrames(limits, s.frameSizeInMbs())
+		}
+	}
+	if n < s.MaxNumRefFrames {
+		n = s.MaxNumRefFrames
+	}
+	return n
+}
+
+// levelMaxDpbFrames returns MaxDpbFrames, as given by section A.3.1 item h,
+// for a level with the given limits and frames of the given size in
+// macroblocks.
+func levelMaxDpbFrames(limits levelLimits, frameSizeInMbs int64) int {
+	n := limits.maxDpbMbs / frameSizeInMbs
+	if n > 16 {
+		n = 16
+	}
+	return int(n)
+}
+
+// frameSizeInMbs returns PicWidthInMbs * FrameHeightInMbs.
+func (s *SPS) frameSizeInMbs() int64 {
+	height := int64(s.PicHeightInMapUnitsMinus1+1) * int64(2-flagVal(s.FrameMbsOnly))
+	return int64(s.PicWidthInMbsMinus1+1) * height
+}
+
+// cpbBrFactors returns cpbBrVclFactor and cpbBrNalFactor for the given
+// profile_idc, as given in table A-2 for the High profiles, and otherwise
+// those of the Baseline, Main and Extended profiles given in section A.3.1.
+func cpbBrFactors(profileIDC int) (vcl, nal int64) {
+	switch profileIDC {
+	case 100:
+		return 1250, 1500
+	case 110:
+		return 3000, 3600
+	case 122, 244, 44:
+		return 4000, 4800
+	}
+	return 1000, 1200
+}
@@ -0,0 +1,114 @@
+/*
+NAME
+  level_test.go
+
+DESCRIPTION
+  level_test.go provides testing for functionality provided in level.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestCheckLevel checks that level limit violations are found for SPS of
+// 1080p 30fps streams.
+func TestCheckLevel(t *testing.T) {
+	hd := func(level, refs int, hrd *HRDParameters) *SPS {
+		return &SPS{
+			ProfileIDC:                66,
+			LevelIDC:                  level,
+			MaxNumRefFrames:           refs,
+			PicWidthInMbsMinus1:       119,
+			PicHeightInMapUnitsMinus1: 67,
+			FrameMbsOnly:              true,
+			VUI: &VUIParameters{
+				TimingInfoPresent: true,
+				NumUnitsInTick:    1,
+				TimeScale:         60,
+				FixedFrameRate:    true,
+				NalHRD:            hrd,
+			},
+		}
+	}
+
+	tests := []struct {
+		sps     *SPS
+		want    []LevelViolation
+		wantErr bool
+	}{
+		{sps: hd(40, 4, nil)},
+		{
+			sps: hd(31, 4, nil),
+			want: []LevelViolation{
+				{Limit: "MaxFS", Detail: "frame size in macroblocks", Value: 8160, Max: 3600},
+				{Limit: "MaxDpbMbs", Detail: "max_num_ref_frames", Value: 4, Max: 2},
+				{Limit: "MaxMBPS", Detail: "macroblocks per second", Value: 244800, Max: 108000},
+			},
+		},
+		{
+			sps: hd(40, 5, &HRDParameters{BitRateValueMinus1: []int{312499, 375000}}),
+			want: []LevelViolation{
+				{Limit: "MaxDpbMbs", Detail: "max_num_ref_frames", Value: 5, Max: 4},
+				{Limit: "MaxBR", Detail: "NAL HRD bit rate", Value: 24000064, Max: 24000000},
+			},
+		},
+		{sps: hd(99, 1, nil), wantErr: true},
+	}
+
+	for i, test := range tests {
+		got, err := test.sps.CheckLevel()
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestMaxDPBFrames checks the DPB size derived from the level, and given by
+// max_dec_frame_buffering.
+func TestMaxDPBFrames(t *testing.T) {
+	sps := func(profile, level, refs, widthMbs, heightMbs int, vui *VUIParameters) *SPS {
+		return &SPS{
+			ProfileIDC:                profile,
+			LevelIDC:                  level,
+			MaxNumRefFrames:           refs,
+			PicWidthInMbsMinus1:       widthMbs - 1,
+			PicHeightInMapUnitsMinus1: heightMbs - 1,
+			FrameMbsOnly:              true,
+			VUI:                       vui,
+		}
+	}
+	restricted := func(n int) *VUIParameters {
+		return &VUIParameters{BitstreamRestriction: true, MaxDecFrameBuffering: n}
+	}
+
+	tests := []struct {
+		sps  *SPS
+		want int
+	}{
+		{sps: sps(66, 40, 1, 120, 68, nil), want: 4},
+		{sps: sps(66, 31, 1, 80, 45, nil), want: 5},
+		{sps: sps(66, 51, 1, 20, 15, nil), want: 16},
+		{sps: sps(66, 31, 7, 80, 45, nil), want: 7},
+		{sps: sps(66, 40, 1, 120, 68, restricted(2)), want: 2},
+		{sps: sps(66, 40, 3, 120, 68, restricted(2)), want: 3},
+		{sps: sps(66, 99, 1, 120, 68, nil), want: 16},
+		{sps: &SPS{ProfileIDC: 110, Constraint3: 1, LevelIDC: 40}, want: 0},
+	}
+
+	for i, test := range tests {
+		got := test.sps.MaxDPBFrames()
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
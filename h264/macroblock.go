@@ -0,0 +1,978 @@
+/*
+NAME
+  macroblock.go
+
+DESCRIPTION
+  macroblock.go provides parsing of the macroblock layer of slice data, as
+  specified by the macroblock_layer, mb_pred, sub_mb_pred and residual syntax
+  of sections 7.3.5 to 7.3.5.3 of the specifications, using either CAVLC or
+  CABAC, with the macroblock and sub-macroblock types of tables 7-11 to 7-18.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// Values of mb_type of table 7-11, and of P_8x8ref0 of P and SP slices
+// (table 7-13).
+const (
+	iNxN     = 0
+	iPCM     = 25
+	mbTypeSI = -1 // The SI macroblock of SI slices, see intraMbType.
+	p8x8ref0 = 4
+)
+
+// numMbTypes gives the number of values of mb_type in each type of slice,
+// being those of table 7-12, 7-13 or 7-14 followed by those of table 7-11.
+var numMbTypes = map[string]int{
+	"I":  26,
+	"SI": 27,
+	"P":  numPInterMbTypes + 26,
+	"SP": numPInterMbTypes + 26,
+	"B":  numBInterMbTypes + 26,
+}
+
+// mbPartInfo gives the number, width and height of the partitions of an inter
+// macroblock type, and the prediction mode of each partition, being predL0,
+// predL1 or biPred, as given by tables 7-13 and 7-14. Types with
+// sub-macroblock partitions have 4 partitions with no prediction mode, and
+// B_Direct_16x16 no partitions.
+type mbPartInfo struct {
+	num  int // NumMbPart.
+	w, h int // MbPartWidth and MbPartHeight.
+	pred [2]mbPartPredMode
+}
+
+// Partitions of the inter macroblock types of P and SP slices (table 7-13) and
+// B slices (table 7-14), indexed by mb_type.
+var (
+	pMbParts = [numPInterMbTypes]mbPartInfo{
+		{1, 16, 16, [2]mbPartPredMode{predL0}},
+		{2, 16, 8, [2]mbPartPredMode{predL0, predL0}},
+		{2, 8, 16, [2]mbPartPredMode{predL0, predL0}},
+		{4, 8, 8, [2]mbPartPredMode{naMbPartPredMode, naMbPartPredMode}},
+		{4, 8, 8, [2]mbPartPredMode{naMbPartPredMode, naMbPartPredMode}},
+	}
+	bMbParts = [numBInterMbTypes]mbPartInfo{
+		{0, 8, 8, [2]mbPartPredMode{direct}},
+		{1, 16, 16, [2]mbPartPredMode{predL0}},
+		{1, 16, 16, [2]mbPartPredMode{predL1}},
+		{1, 16, 16, [2]mbPartPredMode{biPred}},
+		{2, 16, 8, [2]mbPartPredMode{predL0, predL0}},
+		{2, 8, 16, [2]mbPartPredMode{predL0, predL0}},
+		{2, 16, 8, [2]mbPartPredMode{predL1, predL1}},
+		{2, 8, 16, [2]mbPartPredMode{predL1, predL1}},
+		{2, 16, 8, [2]mbPartPredMode{predL0, predL1}},
+		{2, 8, 16, [2]mbPartPredMode{predL0, predL1}},
+		{2, 16, 8, [2]mbPartPredMode{predL1, predL0}},
+		{2, 8, 16, [2]mbPartPredMode{predL1, predL0}},
+		{2, 16, 8, [2]mbPartPredMode{predL0, biPred}},
+		{2, 8, 16, [2]mbPartPredMode{predL0, biPred}},
+		{2, 16, 8, [2]mbPartPredMode{predL1, biPred}},
+		{2, 8, 16, [2]mbPartPredMode{predL1, biPred}},
+		{2, 16, 8, [2]mbPartPredMode{biPred, predL0}},
+		{2, 8, 16, [2]mbPartPredMode{biPred, predL0}},
+		{2, 16, 8, [2]mbPartPredMode{biPred, predL1}},
+		{2, 8, 16, [2]mbPartPredMode{biPred, predL1}},
+		{2, 16, 8, [2]mbPartPredMode{biPred, biPred}},
+		{2, 8, 16, [2]mbPartPredMode{biPred, biPred}},
+		{4, 8, 8, [2]mbPartPredMode{naMbPartPredMode, naMbPartPredMode}},
+	}
+)
+
+// subMbPartInfo gives the number, width and height of the sub-macroblock
+// partitions of a sub-macroblock type, and their prediction mode, as given by
+// tables 7-17 and 7-18.
+type subMbPartInfo struct {
+	num  int // NumSubMbPart.
+	w, h int // SubMbPartWidth and SubMbPartHeight.
+	pred mbPartPredMode
+}
+
+// Sub-macroblock partitions of the sub-macroblock types of P and SP slices
+// (table 7-17) and B slices (table 7-18), indexed by sub_mb_type.
+var (
+	pSubMbParts = [...]subMbPartInfo{
+		{1, 8, 8, predL0},
+		{2, 8, 4, predL0},
+		{2, 4, 8, predL0},
+		{4, 4, 4, predL0},
+	}
+	bSubMbParts = [...]subMbPartInfo{
+		{4, 4, 4, direct},
+		{1, 8, 8, predL0},
+		{1, 8, 8, predL1},
+		{1, 8, 8, biPred},
+		{2, 8, 4, predL0},
+		{2, 4, 8, predL0},
+		{2, 8, 4, predL1},
+		{2, 4, 8, predL1},
+		{2, 8, 4, biPred},
+		{2, 4, 8, biPred},
+		{4, 4, 4, predL0},
+		{4, 4, 4, predL1},
+		{4, 4, 4, biPred},
+	}
+)
+
+// predFlag returns true if a partition with prediction mode m is predicted
+// from reference picture list 0 or 1, i.e. predFlagLX is 1.
+func predFlag(m mbPartPredMode, list int) bool {
+	return m == biPred || (m == predL0 && list == 0) || (m == predL1 && list == 1)
+}
+
+// intraMbType returns the mb_type of table 7-11 of a macroblock with the
+// given mb_type in a slice of the given type, and true, if it is intra coded,
+// and otherwise false. The SI macroblock of SI slices gives mbTypeSI.
+func intraMbType(sliceType string, mbType int) (int, bool) {
+	switch sliceType {
+	case "I":
+		return mbType, true
+	case "SI":
+		return mbType - 1, true
+	case "P", "SP":
+		return mbType - numPInterMbTypes, mbType >= numPInterMbTypes
+	case "B":
+		return mbType - numBInterMbTypes, mbType >= numBInterMbTypes
+	}
+	return 0, false
+}
+
+// intra16x16CodedBlockPattern returns the coded_block_pattern implied by the
+// mb_type of table 7-11, which must be of an Intra16x16 macroblock, being
+// CodedBlockPatternLuma of 0 or 15 and CodedBlockPatternChroma of 0 to 2.
+func intra16x16CodedBlockPattern(iMbType int) int {
+	cbp := ((iMbType - 1) / 4 % 3) << 4
+	if iMbType >= 13 {
+		cbp |= 15
+	}
+	return cbp
+}
+
+// mbResidual holds the transform coefficient levels of the residual of a
+// macroblock, as parsed by residual of 7.3.5.3.
+type mbResidual struct {
+	// Per colour component, Cb and Cr being coded as luma only when
+	// ChromaArrayType is 3.
+	i16x16DC [3][16]int                // Intra16x16DCLevel.
+	level4x4 [3][16][16]int            // LumaLevel4x4, or Intra16x16ACLevel in the first 15 elements, by luma4x4BlkIdx.
+	level8x8 [3][4][maxNumCoeff8x8]int // LumaLevel8x8, by luma8x8BlkIdx, if transform_size_8x8_flag is 1.
+
+	// Of Cb and Cr when ChromaArrayType is 1 or 2.
+	chromaDC [2][maxChromaDCCoeff]int // ChromaDCLevel, of 4*NumC8x8 elements.
+	chromaAC [2][8][15]int            // ChromaACLevel, by chroma4x4BlkIdx.
+
+	// field is true if the blocks are of a field macroblock, whose
+	// coefficients are in field scan order, see inverseScan8x8.
+	field bool
+}
+
+// Values of ctxBlockCat of the blocks of each colour component, Cb and Cr
+// being those of 4:4:4 (table 9-42).
+var (
+	dcCtxBlockCat  = [3]int{ctxBlockCatLumaDC, ctxBlockCatCbDC, ctxBlockCatCrDC}
+	acCtxBlockCat  = [3]int{ctxBlockCatLumaAC, ctxBlockCatCbAC, ctxBlockCatCrAC}
+	blkCtxBlockCat = [3]int{ctxBlockCatLuma4x4, ctxBlockCatCb4x4, ctxBlockCatCr4x4}
+	b8CtxBlockCat  = [3]int{ctxBlockCatLuma8x8, ctxBlockCatCb8x8, ctxBlockCatCr8x8}
+)
+
+// readMacroblockLayer parses the macroblock_layer of the macroblock with
+// address currMbAddr, which must have been begun in mbs, as specified by
+// 7.3.5, setting the fields of d for the macroblock and recording in mbs the
+// state referred to by the decoding of later macroblocks. prevMbAddr is the
+// address of the macroblock preceding it in decoding order in the slice, or
+// -1 if it is the first.
+func (d *SliceData) readMacroblockLayer(ctx *SliceContext, mbs *mbState, currMbAddr, prevMbAddr int) error {
+	var err error
+	sliceType := d.SliceTypeName
+	if d.cabac != nil {
+		d.MbType, err = d.cabac.decodeMbType(sliceType, func(ctxIdxOffset int) int {
+			return mbTypeCtxIdxInc(mbs, currMbAddr, sliceType, ctxIdxOffset)
+		})
+	} else {
+		d.MbType, err = readUe(d.BitReader)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse MbType: %w", err)
+	}
+	if d.MbType >= numMbTypes[sliceType] {
+		return fmt.Errorf("%w: %d in %s slice", errBadMbType, d.MbType, sliceType)
+	}
+	d.resetMb()
+	mbs.mbType[currMbAddr] = uint8(d.MbType)
+	qpY := qpYPred(mbs, prevMbAddr, SliceQPy(ctx.PPS, ctx.Slice.Header))
+	mbs.qpY[currMbAddr] = int8(qpY)
+	mbs.setIntraPredModes(currMbAddr, intraPredDC)
+
+	iMbType, intra := intraMbType(sliceType, d.MbType)
+	var parts mbPartInfo
+	switch {
+	case intra && iMbType == mbTypeSI:
+		d.MbTypeName = SISliceMbType[0]
+	case intra:
+		d.MbTypeName = ISliceMbType[iMbType]
+	case sliceType == "B":
+		d.MbTypeName = BSliceMbType[d.MbType]
+		parts = bMbParts[d.MbType]
+	default:
+		d.MbTypeName = PSliceMbType[d.MbType]
+		parts = pMbParts[d.MbType]
+	}
+	if intra {
+		mbs.flags[currMbAddr] |= mbIntraCoded
+		mbs.intraChromaPredMode[currMbAddr] = 0
+	}
+	if intra && iMbType == iPCM {
+		mbs.flags[currMbAddr] |= mbPCM
+		mbs.setTotalCoeff(currMbAddr, 16)
+		err = d.readPCMSamples(ctx.SPS)
+		if err != nil {
+			return err
+		}
+		if d.cabac != nil {
+			// The decoding engine resumes after the samples (9.3.1.2).
+			err = d.cabac.initEngine()
+			if err != nil {
+				return fmt.Errorf("could not initialise decoding engine after PCM samples: %w", err)
+			}
+		}
+		return nil
+	}
+
+	noSubMbPartSizeLessThan8x8 := true
+	predMode := naMbPartPredMode
+	if !intra && parts.num == 4 {
+		noSubMbPartSizeLessThan8x8, err = d.readSubMbPred(ctx, mbs, currMbAddr)
+		if err != nil {
+			return err
+		}
+	} else {
+		if ctx.PPS.Transform8x8Mode == 1 && intra && iMbType == iNxN {
+			err = d.readTransformSize8x8Flag(mbs, currMbAddr)
+			if err != nil {
+				return err
+			}
+		}
+		switch {
+		case !intra:
+			predMode = parts.pred[0]
+		case iMbType == iNxN && d.TransformSize8x8Flag:
+			predMode = intra8x8
+		case iMbType == iNxN, iMbType == mbTypeSI:
+			predMode = intra4x4
+		default:
+			predMode = intra16x16
+		}
+		err = d.readMbPred(ctx, mbs, currMbAddr, predMode, parts)
+		if err != nil {
+			return err
+		}
+	}
+	d.predMode = predMode
+
+	if predMode == intra16x16 {
+		d.CodedBlockPattern = intra16x16CodedBlockPattern(iMbType)
+		d.intra16x16PredMode = intra16x16PredMode(iMbType)
+	} else {
+		err = d.readCodedBlockPattern(ctx, mbs, currMbAddr, predMode)
+		if err != nil {
+			return err
+		}
+		if CodedBlockPatternLuma(d) > 0 && ctx.PPS.Transform8x8Mode == 1 && !(intra && iMbType == iNxN) && noSubMbPartSizeLessThan8x8 &&
+			(sliceType != "B" || d.MbType != bDirect16x16 || ctx.SPS.Direct8x8Inference) {
+			err = d.readTransformSize8x8Flag(mbs, currMbAddr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	mbs.codedBlockPattern[currMbAddr] = uint8(d.CodedBlockPattern)
+
+	if CodedBlockPatternLuma(d) == 0 && CodedBlockPatternChroma(d) == 0 && predMode != intra16x16 {
+		mbs.setTotalCoeff(currMbAddr, 0)
+		return nil
+	}
+	err = d.readMbQpDelta(ctx.SPS, mbs, prevMbAddr, currMbAddr)
+	if err != nil {
+		return err
+	}
+	qpY = nextQPY(qpY, d.MbQpDelta, qpBdOffsetY(ctx.SPS))
+	mbs.qpY[currMbAddr] = int8(qpY)
+	d.qp = newMbQP(ctx.SPS, ctx.PPS, qpY)
+	return d.readResidual(ctx, mbs, currMbAddr, predMode == intra16x16)
+}
+
+// resetMb resets the fields of d holding the syntax elements of a macroblock
+// for parsing of the next, reusing their storage. The per partition fields
+// are sized for the largest number of partitions, those of partitions not
+// present being left 0, or -1 for the ref_idx_lX of partitions not
+// predicted from list X.
+func (d *SliceData) resetMb() {
+	d.predMode = naMbPartPredMode
+	d.TransformSize8x8Flag = false
+	d.CodedBlockPattern = 0
+	d.MbQpDelta = 0
+	d.IntraChromaPredMode = 0
+	d.PrevIntra4x4PredModeFlag = zeroInts(d.PrevIntra4x4PredModeFlag, 16)
+	d.RemIntra4x4PredMode = zeroInts(d.RemIntra4x4PredMode, 16)
+	d.PrevIntra8x8PredModeFlag = zeroInts(d.PrevIntra8x8PredModeFlag, 4)
+	d.RemIntra8x8PredMode = zeroInts(d.RemIntra8x8PredMode, 4)
+	d.SubMbType = zeroInts(d.SubMbType, 4)
+	d.RefIdxL0 = zeroInts(d.RefIdxL0, 4)
+	d.RefIdxL1 = zeroInts(d.RefIdxL1, 4)
+	for i := range d.RefIdxL0 {
+		d.RefIdxL0[i], d.RefIdxL1[i] = -1, -1
+	}
+	if d.MvdL0 == nil {
+		d.MvdL0, d.MvdL1 = make([][][]int, 4), make([][][]int, 4)
+		for i := range d.MvdL0 {
+			d.MvdL0[i], d.MvdL1[i] = make([][]int, 4), make([][]int, 4)
+			for j := range d.MvdL0[i] {
+				d.MvdL0[i][j], d.MvdL1[i][j] = make([]int, 2), make([]int, 2)
+			}
+		}
+	}
+	for _, mvd := range [][][][]int{d.MvdL0, d.MvdL1} {
+		for i := range mvd {
+			for j := range mvd[i] {
+				mvd[i][j][0], mvd[i][j][1] = 0, 0
+			}
+		}
+	}
+}
+
+// zeroInts returns s resized to n elements, each 0, reusing its storage if
+// large enough.
+func zeroInts(s []int, n int) []int {
+	if cap(s) < n {
+		return make([]int, n)
+	}
+	s = s[:n]
+	for i := range s {
+		s[i] = 0
+	}
+	return s
+}
+
+// readPCMSamples parses the pcm_alignment_zero_bits and the pcm_sample_luma
+// and pcm_sample_chroma of an I_PCM macroblock, as specified by 7.3.5.
+func (d *SliceData) readPCMSamples(sps *SPS) error {
+	br := d.BitReader
+	for !br.ByteAligned() {
+		b, err := br.ReadBits(1)
+		if err != nil {
+			return fmt.Errorf("could not read PCMAlignmentZeroBit: %w", err)
+		}
+		if b != 0 {
+			return fmt.Errorf("pcm_alignment_zero_bit at bit %d not equal to 0", br.Off()-1)
+		}
+	}
+	// 7-3 p95
+	bitDepthY := 8 + sps.BitDepthLumaMinus8
+	d.PcmSampleLuma = zeroInts(d.PcmSampleLuma, 256)
+	for i := range d.PcmSampleLuma {
+		s, err := br.ReadBits(bitDepthY)
+		if err != nil {
+			return fmt.Errorf("could not read PcmSampleLuma[%d]: %w", i, err)
+		}
+		d.PcmSampleLuma[i] = int(s)
+	}
+	// 6-1 p 47
+	var n int
+	if sps.ChromaArrayType() != chromaMonochrome {
+		n = 2 * MbWidthC(sps) * MbHeightC(sps)
+	}
+	bitDepthC := 8 + sps.BitDepthChromaMinus8
+	d.PcmSampleChroma = zeroInts(d.PcmSampleChroma, n)
+	for i := range d.PcmSampleChroma {
+		s, err := br.ReadBits(bitDepthC)
+		if err != nil {
+			return fmt.Errorf("could not read PcmSampleChroma[%d]: %w", i, err)
+		}
+		d.PcmSampleChroma[i] = int(s)
+	}
+	return nil
+}
+
+// readTransformSize8x8Flag parses transform_size_8x8_flag, recording it in
+// mbs.
+func (d *SliceData) readTransformSize8x8Flag(mbs *mbState, currMbAddr int) error {
+	if d.cabac != nil {
+		var err error
+		d.TransformSize8x8Flag, err = d.cabac.decodeTransformSize8x8Flag(mbs, currMbAddr)
+		if err != nil {
+			return fmt.Errorf("could not read TransformSize8x8Flag: %w", err)
+		}
+	} else {
+		b, err := d.BitReader.ReadBits(1)
+		if err != nil {
+			return fmt.Errorf("could not read TransformSize8x8Flag: %w", err)
+		}
+		d.TransformSize8x8Flag = b == 1
+	}
+	if d.TransformSize8x8Flag {
+		mbs.flags[currMbAddr] |= mbTransform8x8
+	}
+	return nil
+}
+
+// readMbPred parses the mb_pred of a macroblock whose first partition has
+// prediction mode predMode and, for inter macroblocks, with the given
+// partitions, as specified by 7.3.5.1.
+func (d *SliceData) readMbPred(ctx *SliceContext, mbs *mbState, currMbAddr int, predMode mbPartPredMode, parts mbPartInfo) error {
+	switch predMode {
+	case intra4x4, intra8x8, intra16x16:
+		return d.readIntraPred(ctx, mbs, currMbAddr, predMode)
+	case direct:
+		return mbs.setDirectMotion(currMbAddr, 0, 0, 16, &d.direct)
+	}
+
+	for list, refIdx := range [2][]int{d.RefIdxL0, d.RefIdxL1} {
+		for mbPartIdx := 0; mbPartIdx < parts.num; mbPartIdx++ {
+			if !predFlag(parts.pred[mbPartIdx], list) {
+				continue
+			}
+			x, y := partitionPos(mbPartIdx, parts.w, parts.h, 16)
+			var err error
+			if d.refIdxPresent(ctx.Slice.Header, list) {
+				refIdx[mbPartIdx], err = d.readRefIdx(ctx, mbs, currMbAddr, list, x, y)
+				if err != nil {
+					return fmt.Errorf("could not read RefIdxL%d[%d]: %w", list, mbPartIdx, err)
+				}
+			} else {
+				refIdx[mbPartIdx] = 0
+			}
+			mbs.setRefIdx(currMbAddr, list, x, y, parts.w, parts.h, refIdx[mbPartIdx])
+		}
+	}
+	for list, mvd := range [2][][][]int{d.MvdL0, d.MvdL1} {
+		for mbPartIdx := 0; mbPartIdx < parts.num; mbPartIdx++ {
+			if !predFlag(parts.pred[mbPartIdx], list) {
+				continue
+			}
+			x, y := partitionPos(mbPartIdx, parts.w, parts.h, 16)
+			v, err := d.readMvd(mbs, currMbAddr, list, x, y, parts.w, parts.h)
+			if err != nil {
+				return fmt.Errorf("could not read MvdL%d[%d]: %w", list, mbPartIdx, err)
+			}
+			mbs.setPredictedMv(currMbAddr, list, x, y, parts.w, parts.h, v)
+			mvd[mbPartIdx][0][0], mvd[mbPartIdx][0][1] = int(v.X), int(v.Y)
+		}
+	}
+	return nil
+}
+
+// readIntraPred parses the syntax elements of mb_pred of an intra macroblock,
+// being the intra 4x4 or 8x8 prediction modes of its blocks for predMode
+// intra4x4 or intra8x8, and intra_chroma_pred_mode when ChromaArrayType is 1
+// or 2, which is recorded in mbs.
+func (d *SliceData) readIntraPred(ctx *SliceContext, mbs *mbState, currMbAddr int, predMode mbPartPredMode) error {
+	prevFlags, rems := d.PrevIntra4x4PredModeFlag, d.RemIntra4x4PredMode
+	if predMode == intra8x8 {
+		prevFlags, rems = d.PrevIntra8x8PredModeFlag, d.RemIntra8x8PredMode
+	}
+	if predMode != intra16x16 {
+		for i := range prevFlags {
+			var (
+				prev bool
+				rem  int
+				err  error
+			)
+			if d.cabac != nil {
+				prev, rem, err = d.cabac.decodeIntraPredMode()
+			} else {
+				prev, rem, err = readIntraPredMode(d.BitReader)
+			}
+			if err != nil {
+				return fmt.Errorf("could not read intra prediction mode of block %d: %w", i, err)
+			}
+			prevFlags[i], rems[i] = flagVal(prev), rem
+		}
+	}
+
+	if cat := ctx.Slice.Header.ChromaArrayType; cat != chroma420 && cat != chroma422 {
+		return nil
+	}
+	var err error
+	if d.cabac != nil {
+		d.IntraChromaPredMode, err = d.cabac.decodeIntraChromaPredMode(mbs, currMbAddr)
+	} else {
+		d.IntraChromaPredMode, err = readUe(d.BitReader)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse IntraChromaPredMode: %w", err)
+	}
+	if d.IntraChromaPredMode > 3 {
+		return fmt.Errorf("%w: %d", errBadIntraChromaPredMode, d.IntraChromaPredMode)
+	}
+	mbs.intraChromaPredMode[currMbAddr] = uint8(d.IntraChromaPredMode)
+	return nil
+}
+
+// readIntraPredMode parses prev_intra4x4_pred_mode_flag or
+// prev_intra8x8_pred_mode_flag and, if it is 0, rem_intra4x4_pred_mode or
+// rem_intra8x8_pred_mode, coded using CAVLC. rem is 0 if the flag is 1.
+func readIntraPredMode(br *bits.BitReader) (prevFlag bool, rem int, err error) {
+	b, err := br.ReadBits(1)
+	if err != nil {
+		return false, 0, fmt.Errorf("could not read prev_intra_pred_mode_flag: %w", err)
+	}
+	if b == 1 {
+		return true, 0, nil
+	}
+	b, err = br.ReadBits(3)
+	if err != nil {
+		return false, 0, fmt.Errorf("could not read rem_intra_pred_mode: %w", err)
+	}
+	return false, int(b), nil
+}
+
+// readSubMbPred parses the sub_mb_pred of a macroblock with sub-macroblock
+// partitions, as specified by 7.3.5.2, returning
+// noSubMbPartSizeLessThan8x8Flag.
+func (d *SliceData) readSubMbPred(ctx *SliceContext, mbs *mbState, currMbAddr int) (bool, error) {
+	sliceType := d.SliceTypeName
+	subParts := pSubMbParts[:]
+	if sliceType == "B" {
+		subParts = bSubMbParts[:]
+	}
+
+	var (
+		info                       [4]subMbPartInfo
+		noSubMbPartSizeLessThan8x8 = true
+	)
+	for mbPartIdx := range info {
+		var (
+			v   int
+			err error
+		)
+		if d.cabac != nil {
+			v, err = d.cabac.decodeSubMbType(sliceType)
+		} else {
+			v, err = readUe(d.BitReader)
+		}
+		if err != nil {
+			return false, fmt.Errorf("could not read SubMbType[%d]: %w", mbPartIdx, err)
+		}
+		if v >= len(subParts) {
+			return false, fmt.Errorf("%w: %d in %s slice", errBadSubMbType, v, sliceType)
+		}
+		d.SubMbType[mbPartIdx] = v
+		mbs.subMbType[currMbAddr*partitionsPerMb+mbPartIdx] = uint8(v)
+		info[mbPartIdx] = subParts[v]
+		switch {
+		case info[mbPartIdx].pred != direct:
+			if info[mbPartIdx].num > 1 {
+				noSubMbPartSizeLessThan8x8 = false
+			}
+		case !ctx.SPS.Direct8x8Inference:
+			noSubMbPartSizeLessThan8x8 = false
+		}
+	}
+
+	for mbPartIdx, sub := range info {
+		if sub.pred == direct {
+			x, y := partitionPos(mbPartIdx, 8, 8, 16)
+			err := mbs.setDirectMotion(currMbAddr, x, y, 8, &d.direct)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	for list, refIdx := range [2][]int{d.RefIdxL0, d.RefIdxL1} {
+		for mbPartIdx := range info {
+			if !predFlag(info[mbPartIdx].pred, list) {
+				continue
+			}
+			x, y := partitionPos(mbPartIdx, 8, 8, 16)
+			var err error
+			if d.MbType != p8x8ref0 && d.refIdxPresent(ctx.Slice.Header, list) {
+				refIdx[mbPartIdx], err = d.readRefIdx(ctx, mbs, currMbAddr, list, x, y)
+				if err != nil {
+					return false, fmt.Errorf("could not read RefIdxL%d[%d]: %w", list, mbPartIdx, err)
+				}
+			} else {
+				refIdx[mbPartIdx] = 0
+			}
+			mbs.setRefIdx(currMbAddr, list, x, y, 8, 8, refIdx[mbPartIdx])
+		}
+	}
+	for list, mvd := range [2][][][]int{d.MvdL0, d.MvdL1} {
+		for mbPartIdx, sub := range info {
+			if !predFlag(sub.pred, list) {
+				continue
+			}
+			x8, y8 := partitionPos(mbPartIdx, 8, 8, 16)
+			for subMbPartIdx := 0; subMbPartIdx < sub.num; subMbPartIdx++ {
+				x, y := partitionPos(subMbPartIdx, sub.w, sub.h, 8)
+				v, err := d.readMvd(mbs, currMbAddr, list, x8+x, y8+y, sub.w, sub.h)
+				if err != nil {
+					return false, fmt.Errorf("could not read MvdL%d[%d][%d]: %w", list, mbPartIdx, subMbPartIdx, err)
+				}
+				mbs.setPredictedMv(currMbAddr, list, x8+x, y8+y, sub.w, sub.h, v)
+				mvd[mbPartIdx][subMbPartIdx][0], mvd[mbPartIdx][subMbPartIdx][1] = int(v.X), int(v.Y)
+			}
+		}
+	}
+	return noSubMbPartSizeLessThan8x8, nil
+}
+
+// partitionPos returns the location of the top left sample of the partition
+// with index partIdx, of width w and height h, within a block of width
+// blkW, partitions being in raster scan order, as by the inverse
+// macroblock and sub-macroblock partition scanning processes of 6.4.2.
+func partitionPos(partIdx, w, h, blkW int) (x, y int) {
+	return (partIdx % (blkW / w)) * w, (partIdx / (blkW / w)) * h
+}
+
+// refIdxPresent returns true if ref_idx_lX is present, for list 0 or 1, for
+// partitions predicted from the list, being so if the list has more than
+// one reference index, or the macroblock is a field macroblock of a frame.
+func (d *SliceData) refIdxPresent(h *SliceHeader, list int) bool {
+	numRefIdxActiveMinus1 := h.NumRefIdxL0ActiveMinus1
+	if list == 1 {
+		numRefIdxActiveMinus1 = h.NumRefIdxL1ActiveMinus1
+	}
+	return numRefIdxActiveMinus1 > 0 || (d.MbFieldDecodingFlag && !h.FieldPic)
+}
+
+// readRefIdx parses ref_idx_lX, for list 0 or 1, of the partition of the
+// macroblock with address currMbAddr whose top left luma sample is at (x, y)
+// relative to the macroblock, checking it is within the range of 7.4.5.1.
+func (d *SliceData) readRefIdx(ctx *SliceContext, mbs *mbState, currMbAddr, list, x, y int) (int, error) {
+	h := ctx.Slice.Header
+	maxRefIdx := h.NumRefIdxL0ActiveMinus1
+	if list == 1 {
+		maxRefIdx = h.NumRefIdxL1ActiveMinus1
+	}
+	if d.MbFieldDecodingFlag && !h.FieldPic {
+		// Field macroblocks of frames refer to the fields of reference frames.
+		maxRefIdx = 2*maxRefIdx + 1
+	}
+	if d.cabac != nil {
+		return d.cabac.decodeRefIdx(mbs, currMbAddr, d.SliceTypeName, list, x, y, maxRefIdx)
+	}
+	v, err := readTe(d.BitReader, uint(maxRefIdx))
+	if err != nil {
+		return 0, err
+	}
+	if v > maxRefIdx {
+		return 0, fmt.Errorf("%w: %d greater than %d", errBadRefIdx, v, maxRefIdx)
+	}
+	return v, nil
+}
+
+// readMvd parses mvd_lX, for list 0 or 1, of the partition or sub-macroblock
+// partition of the macroblock with address currMbAddr whose top left luma
+// sample is at (x, y) relative to the macroblock, with the given width and
+// height, recording it in mbs.
+func (d *SliceData) readMvd(mbs *mbState, currMbAddr, list, x, y, w, h int) (motionVector, error) {
+	if d.cabac != nil {
+		return d.cabac.decodeMvd(mbs, currMbAddr, list, x, y, w, h)
+	}
+	var comp [2]int
+	for compIdx := range comp {
+		v, err := readSe(d.BitReader)
+		if err != nil {
+			return motionVector{}, err
+		}
+		if v < math.MinInt16 || v > math.MaxInt16 {
+			return motionVector{}, fmt.Errorf("%w: %d", errBadMvd, v)
+		}
+		comp[compIdx] = v
+	}
+	mvd := motionVector{X: int16(comp[0]), Y: int16(comp[1])}
+	mbs.setMvd(currMbAddr, list, x, y, w, h, mvd)
+	return mvd, nil
+}
+
+// readCodedBlockPattern parses coded_block_pattern of a macroblock whose
+// first partition has prediction mode predMode, which must not be
+// intra16x16.
+func (d *SliceData) readCodedBlockPattern(ctx *SliceContext, mbs *mbState, currMbAddr int, predMode mbPartPredMode) error {
+	cat := ctx.Slice.Header.ChromaArrayType
+	var err error
+	if d.cabac != nil {
+		d.CodedBlockPattern, err = d.cabac.decodeCodedBlockPattern(mbs, currMbAddr, cat)
+	} else {
+		if predMode != intra4x4 && predMode != intra8x8 {
+			predMode = inter
+		}
+		var v uint
+		v, err = readMe(d.BitReader, uint(cat), predMode)
+		d.CodedBlockPattern = int(v)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse CodedBlockPattern: %w", err)
+	}
+	return nil
+}
+
+// readMbQpDelta parses mb_qp_delta, checking it is within the range of
+// 7.4.5 for the bit depth of luma, and recording in mbs whether it is not 0.
+func (d *SliceData) readMbQpDelta(sps *SPS, mbs *mbState, prevMbAddr, currMbAddr int) error {
+	var err error
+	if d.cabac != nil {
+		d.MbQpDelta, err = d.cabac.decodeMbQpDelta(mbs, prevMbAddr, currMbAddr)
+	} else {
+		d.MbQpDelta, err = readSe(d.BitReader)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse MbQpDelta: %w", err)
+	}
+	offset := qpBdOffsetY(sps)
+	if d.MbQpDelta < -(26+offset/2) || d.MbQpDelta > 25+offset/2 {
+		return fmt.Errorf("%w: %d", errBadMbQpDelta, d.MbQpDelta)
+	}
+	if d.MbQpDelta != 0 {
+		mbs.flags[currMbAddr] |= mbQpDelta
+	}
+	return nil
+}
+
+// readResidual parses the residual of the macroblock with address
+// currMbAddr, as specified by 7.3.5.3 with startIdx 0 and endIdx 15, setting
+// the levels of its blocks in the residual of d and recording the TotalCoeff
+// of each block in mbs, see coeffTokenNC and blockCodedBlockFlagCtxIdxInc.
+func (d *SliceData) readResidual(ctx *SliceContext, mbs *mbState, currMbAddr int, intra16x16 bool) error {
+	br, err := d.residualReader(mbs.has(currMbAddr, mbIntraCoded))
+	if err != nil {
+		return err
+	}
+	field := ctx.Slice.Header.FieldPic || d.MbFieldDecodingFlag
+	d.residual.field = field
+	constrainedIntra := d.partitioned && ctx.PPS.ConstrainedIntraPred
+	cat := ctx.Slice.Header.ChromaArrayType
+
+	err = d.readResidualLuma(br, mbs, currMbAddr, 0, cat, intra16x16, field, constrainedIntra)
+	if err != nil {
+		return err
+	}
+
+	switch cat {
+	case chroma420, chroma422:
+	case chroma444:
+		for comp := 1; comp < 3; comp++ {
+			err = d.readResidualLuma(br, mbs, currMbAddr, comp, cat, intra16x16, field, constrainedIntra)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+
+	n, err := numC8x8(cat)
+	if err != nil {
+		return err
+	}
+	cbpChroma := CodedBlockPatternChroma(d)
+	for iCbCr := range d.residual.chromaDC {
+		dc := d.residual.chromaDC[iCbCr][:]
+		switch {
+		case cbpChroma&3 == 0:
+			for i := range dc {
+				dc[i] = 0
+			}
+		case d.cabac != nil:
+			_, err = d.cabac.decodeChromaDC(mbs, currMbAddr, iCbCr, cat, field, constrainedIntra, dc)
+		default:
+			_, err = readChromaDCCAVLC(br, cat, dc)
+		}
+		if err != nil {
+			return fmt.Errorf("could not parse ChromaDCLevel[%d]: %w", iCbCr, err)
+		}
+	}
+	mbWidthC, mbHeightC := MbWidthC(ctx.SPS), MbHeightC(ctx.SPS)
+	for iCbCr := range d.residual.chromaAC {
+		for blkIdx := 0; blkIdx < 4*n; blkIdx++ {
+			ac := d.residual.chromaAC[iCbCr][blkIdx][:]
+			var total int
+			if cbpChroma&2 != 0 {
+				x, y := partitionPos(blkIdx, 4, 4, 8)
+				total, err = d.readResidualBlock(br, mbs, currMbAddr, 1+iCbCr, ctxBlockCatChromaAC, x, y, mbWidthC, mbHeightC, ac, field, constrainedIntra)
+				if err != nil {
+					return fmt.Errorf("could not parse ChromaACLevel[%d][%d]: %w", iCbCr, blkIdx, err)
+				}
+			} else {
+				for i := range ac {
+					ac[i] = 0
+				}
+			}
+			mbs.totalCoeff[1+iCbCr][currMbAddr*blocksPerMb+blkIdx] = uint8(total)
+		}
+	}
+	return nil
+}
+
+// readResidualLuma parses the residual blocks of colour component comp coded
+// as luma, as specified by residual_luma of 7.3.5.3.
+func (d *SliceData) readResidualLuma(br *bits.BitReader, mbs *mbState, currMbAddr, comp, chromaArrayType int, intra16x16, field, constrainedIntra bool) error {
+	if intra16x16 {
+		err := d.readIntra16x16DC(br, mbs, currMbAddr, comp, field, constrainedIntra)
+		if err != nil {
+			return fmt.Errorf("could not parse Intra16x16DCLevel of component %d: %w", comp, err)
+		}
+	}
+
+	cbpLuma := CodedBlockPatternLuma(d)
+	if d.TransformSize8x8Flag {
+		return d.readResidualLuma8x8(br, mbs, currMbAddr, comp, chromaArrayType, field, constrainedIntra)
+	}
+	for blkIdx := 0; blkIdx < blocksPerMb; blkIdx++ {
+		level := d.residual.level4x4[comp][blkIdx][:]
+		var (
+			total int
+			err   error
+		)
+		switch x, y := luma4x4BlkPos(blkIdx); {
+		case (cbpLuma>>uint(blkIdx/4))&1 == 0:
+			for i := range level {
+				level[i] = 0
+			}
+		case intra16x16:
+			total, err = d.readResidualBlock(br, mbs, currMbAddr, comp, acCtxBlockCat[comp], x, y, 16, 16, level[:15], field, constrainedIntra)
+		default:
+			total, err = d.readResidualBlock(br, mbs, currMbAddr, comp, blkCtxBlockCat[comp], x, y, 16, 16, level, field, constrainedIntra)
+		}
+		if err != nil {
+			return fmt.Errorf("could not parse level of 4x4 block %d of component %d: %w", blkIdx, comp, err)
+		}
+		mbs.totalCoeff[comp][currMbAddr*blocksPerMb+blkIdx] = uint8(total)
+	}
+	return nil
+}
+
+// readResidualLuma8x8 parses the 8x8 residual blocks of colour component comp
+// coded as luma of a macroblock with transform_size_8x8_flag 1. Under CAVLC
+// each 8x8 block is coded as 4 4x4 blocks whose coefficients are interleaved,
+// each having its own TotalCoeff. Under CABAC each is coded as one block, its
+// coded_block_flag being present only when ChromaArrayType is 3, and its
+// number of non-zero coefficients is recorded for each of its 4x4 blocks, as
+// referred to as the coded_block_flag of the 8x8 block by
+// blockCodedBlockFlagCtxIdxInc.
+func (d *SliceData) readResidualLuma8x8(br *bits.BitReader, mbs *mbState, currMbAddr, comp, chromaArrayType int, field, constrainedIntra bool) error {
+	cbpLuma := CodedBlockPatternLuma(d)
+	totalCoeff := mbs.totalCoeff[comp][currMbAddr*blocksPerMb:]
+	for i8x8 := range d.residual.level8x8[comp] {
+		level := d.residual.level8x8[comp][i8x8][:]
+		if (cbpLuma>>uint(i8x8))&1 == 0 {
+			for i := range level {
+				level[i] = 0
+			}
+			for i4x4 := 0; i4x4 < 4; i4x4++ {
+				totalCoeff[4*i8x8+i4x4] = 0
+			}
+			continue
+		}
+
+		if d.cabac == nil {
+			var level4x4 [16]int
+			for i4x4 := 0; i4x4 < 4; i4x4++ {
+				x, y := luma4x4BlkPos(4*i8x8 + i4x4)
+				total, err := d.readResidualBlock(br, mbs, currMbAddr, comp, blkCtxBlockCat[comp], x, y, 16, 16, level4x4[:], field, constrainedIntra)
+				if err != nil {
+					return fmt.Errorf("could not parse level of 4x4 block %d of 8x8 block %d of component %d: %w", i4x4, i8x8, comp, err)
+				}
+				for i, v := range level4x4 {
+					level[4*i+i4x4] = v
+				}
+				totalCoeff[4*i8x8+i4x4] = uint8(total)
+			}
+			continue
+		}
+
+		cbf := true
+		if chromaArrayType == chroma444 {
+			x, y := partitionPos(i8x8, 8, 8, 16)
+			var err error
+			cbf, err = d.cabac.decodeCodedBlockFlag(b8CtxBlockCat[comp], block8x8CodedBlockFlagCtxIdxInc(mbs, currMbAddr, comp, x, y, constrainedIntra))
+			if err != nil {
+				return fmt.Errorf("could not decode coded_block_flag of 8x8 block %d of component %d: %w", i8x8, comp, err)
+			}
+		}
+		var total int
+		if cbf {
+			var err error
+			total, err = d.cabac.decodeResidualBlock(b8CtxBlockCat[comp], field, 0, 0, maxNumCoeff8x8-1, level)
+			if err != nil {
+				return fmt.Errorf("could not parse level of 8x8 block %d of component %d: %w", i8x8, comp, err)
+			}
+		} else {
+			for i := range level {
+				level[i] = 0
+			}
+		}
+		for i4x4 := 0; i4x4 < 4; i4x4++ {
+			totalCoeff[4*i8x8+i4x4] = uint8(total)
+		}
+	}
+	return nil
+}
+
+// readIntra16x16DC parses the Intra16x16 DC block of colour component comp,
+// recording its coded_block_flag in mbs when coded using CABAC.
+func (d *SliceData) readIntra16x16DC(br *bits.BitReader, mbs *mbState, currMbAddr, comp int, field, constrainedIntra bool) error {
+	dc := d.residual.i16x16DC[comp][:]
+	if d.cabac == nil {
+		nC := mbs.coeffTokenNC(currMbAddr, comp, 0, 0, 16, 16, constrainedIntra)
+		_, err := residualBlockCAVLC(br, dc, 0, len(dc)-1, len(dc), nC)
+		return err
+	}
+	cbf, err := d.cabac.decodeCodedBlockFlag(dcCtxBlockCat[comp], dcCodedBlockFlagCtxIdxInc(mbs, currMbAddr, comp, constrainedIntra))
+	if err != nil {
+		return fmt.Errorf("could not decode coded_block_flag: %w", err)
+	}
+	if !cbf {
+		for i := range dc {
+			dc[i] = 0
+		}
+		return nil
+	}
+	mbs.flags[currMbAddr] |= mbCbfYDC << uint(comp)
+	_, err = d.cabac.decodeResidualBlock(dcCtxBlockCat[comp], field, 0, 0, len(dc)-1, dc)
+	return err
+}
+
+// readResidualBlock parses the levels of a 4x4 residual block, other than a
+// DC block, of colour component comp and the given ctxBlockCat, whose top
+// left sample is at (x, y) relative to the macroblock with address
+// currMbAddr, coeffLevel being of 15 elements for AC blocks and otherwise 16.
+// maxW, maxH and constrainedIntra are as for coeffTokenNC. TotalCoeff, or
+// the number of non-zero coefficients under CABAC, is returned.
+func (d *SliceData) readResidualBlock(br *bits.BitReader, mbs *mbState, currMbAddr, comp, ctxBlockCat, x, y, maxW, maxH int, coeffLevel []int, field, constrainedIntra bool) (int, error) {
+	maxNumCoeff := len(coeffLevel)
+	if d.cabac == nil {
+		nC := mbs.coeffTokenNC(currMbAddr, comp, x, y, maxW, maxH, constrainedIntra)
+		return residualBlockCAVLC(br, coeffLevel, 0, maxNumCoeff-1, maxNumCoeff, nC)
+	}
+	cbf, err := d.cabac.decodeCodedBlockFlag(ctxBlockCat, blockCodedBlockFlagCtxIdxInc(mbs, currMbAddr, comp, x, y, maxW, maxH, constrainedIntra))
+	if err != nil {
+		return 0, fmt.Errorf("could not decode coded_block_flag: %w", err)
+	}
+	if !cbf {
+		for i := range coeffLevel {
+			coeffLevel[i] = 0
+		}
+		return 0, nil
+	}
+	return d.cabac.decodeResidualBlock(ctxBlockCat, field, 0, 0, maxNumCoeff-1, coeffLevel)
+}
+
+// Errors used in the parsing of the macroblock layer.
+var (
+	errBadSubMbType           = errors.New("sub_mb_type outside range for slice type")
+	errBadRefIdx              = errors.New("ref_idx_lX exceeds number of reference indices")
+	errBadIntraChromaPredMode = errors.New("intra_chroma_pred_mode outside range")
+	errBadMbQpDelta           = errors.New("mb_qp_delta outside range")
+)
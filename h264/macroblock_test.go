@@ -0,0 +1,529 @@
+/*
+NAME
+  macroblock_test.go
+
+DESCRIPTION
+  macroblock_test.go provides testing for functionality provided in
+  macroblock.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// cbpCodeNum returns the codeNum of the me(v) coding of coded_block_pattern
+// cbp for ChromaArrayType 1 or 2, for intra 4x4 or 8x8 macroblocks if intra
+// and otherwise inter macroblocks (table 9-4).
+func cbpCodeNum(t *testing.T, cbp uint, intra bool) int {
+	for codeNum, v := range codedBlockPattern[0] {
+		if (intra && v[0] == cbp) || (!intra && v[1] == cbp) {
+			return codeNum
+		}
+	}
+	t.Fatalf("no codeNum for coded_block_pattern: %d", cbp)
+	return 0
+}
+
+// mbTestSliceData returns SliceData for parsing the single macroblock of a
+// 1x1 macroblock 4:2:0 picture from the given bits, in a slice of the given
+// type with the given header and PPS, and the begun macroblock's mbState.
+func mbTestSliceData(in, sliceType string, h *SliceHeader, pps *PPS) (*SliceContext, *SliceData, *mbState) {
+	h.ChromaArrayType = chroma420
+	ctx := &SliceContext{
+		SPS:   &SPS{ChromaFormat: chroma420, Direct8x8Inference: true},
+		PPS:   pps,
+		Slice: &Slice{Header: h},
+		arena: &arena{},
+	}
+	d := &SliceData{
+		BitReader:     bits.NewBitReader(bytes.NewReader(binToSlice(in))),
+		SliceTypeName: sliceType,
+	}
+	if sliceType == "B" {
+		// A co-located picture of intra macroblocks, for direct mode.
+		col := colFrame(1, 0, colMotion{refIdx: -1})
+		d.direct = newDirectPred(ctx, &refPicLists{list: [2][]*refFrame{{col}, {col}}})
+	}
+	mbs := newMbState(1, 1)
+	mbs.beginMb(0, mbs.startSlice(), 0)
+	return ctx, d, mbs
+}
+
+func TestReadMacroblockLayerIntra(t *testing.T) {
+	// I_16x16_1_0_1, with a DC block and AC blocks of which only the last has
+	// coefficients, so that each has nC 0.
+	dc := []int{3, -1, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	ac := make([]int, 16)
+	ac[0], ac[2] = -2, 1
+	in := ueBits(14) + ueBits(2) + seBits(3) + encodeCAVLC(t, dc, 0, 15, 16, 0) +
+		strings.Repeat("1", 15) + encodeCAVLC(t, ac[:15], 0, 14, 15, 0)
+
+	ctx, d, mbs := mbTestSliceData(in, "I", &SliceHeader{}, &PPS{})
+	err := d.readMacroblockLayer(ctx, mbs, 0, -1)
+	if err != nil {
+		t.Fatalf("did not expect error: %v", err)
+	}
+	if d.MbTypeName != "I_16x16_1_0_1" || d.CodedBlockPattern != 15 || d.IntraChromaPredMode != 2 || d.MbQpDelta != 3 {
+		t.Errorf("did not get expected syntax elements\nGot: %s, %d, %d, %d\nWant: I_16x16_1_0_1, 15, 2, 3",
+			d.MbTypeName, d.CodedBlockPattern, d.IntraChromaPredMode, d.MbQpDelta)
+	}
+	if d.predMode != intra16x16 || d.intra16x16PredMode != intraPredHorizontal {
+		t.Errorf("did not get expected prediction mode\nGot: %v, %d\nWant: %v, %d", d.predMode, d.intra16x16PredMode, intra16x16, intraPredHorizontal)
+	}
+	if got := d.residual.i16x16DC[0][:]; !reflect.DeepEqual(got, dc) {
+		t.Errorf("did not get expected DC levels\nGot: %v\nWant: %v", got, dc)
+	}
+	if got := d.residual.level4x4[0][15][:]; !reflect.DeepEqual(got, ac) {
+		t.Errorf("did not get expected AC levels\nGot: %v\nWant: %v", got, ac)
+	}
+	if got := mbs.totalCoeff[0][15]; got != 2 {
+		t.Errorf("did not get expected TotalCoeff\nGot: %d\nWant: 2", got)
+	}
+	if mbs.qpY[0] != 29 || d.qp != (mbQP{y: 29, c: [2]int{29, 29}}) {
+		t.Errorf("did not get expected quantisation parameters\nGot: %d, %+v\nWant: 29, {y:29 c:[29 29]}", mbs.qpY[0], d.qp)
+	}
+	if !mbs.has(0, mbIntraCoded|mbQpDelta) || mbs.intraChromaPredMode[0] != 2 {
+		t.Errorf("did not get expected state recorded\nGot: %08b, %d", mbs.flags[0], mbs.intraChromaPredMode[0])
+	}
+	if d.BitReader.Off() != len(in) {
+		t.Errorf("did not get expected bits read\nGot: %d\nWant: %d", d.BitReader.Off(), len(in))
+	}
+}
+
+func TestReadMacroblockLayerINxN(t *testing.T) {
+	// I_NxN with a remaining mode for the first block and chroma coefficients
+	// only, the first Cb AC block having 2 coefficients, giving nC 2 for the
+	// blocks to its right and below.
+	cbDC := []int{0, 2, 0, -1}
+	cbAC := make([]int, 15)
+	cbAC[1], cbAC[4] = 1, 5
+	zeros := make([]int, 16)
+	in := ueBits(0) + "0101" + strings.Repeat("1", 15) + ueBits(1) + ueBits(cbpCodeNum(t, 32, true)) + seBits(-4) +
+		encodeCAVLC(t, cbDC, 0, 3, 4, -1) + encodeCAVLC(t, zeros, 0, 3, 4, -1) +
+		encodeCAVLC(t, cbAC, 0, 14, 15, 0) + encodeCAVLC(t, zeros, 0, 14, 15, 2) + encodeCAVLC(t, zeros, 0, 14, 15, 2) +
+		encodeCAVLC(t, zeros, 0, 14, 15, 0) + strings.Repeat(encodeCAVLC(t, zeros, 0, 14, 15, 0), 4)
+
+	ctx, d, mbs := mbTestSliceData(in, "I", &SliceHeader{}, &PPS{})
+	err := d.readMacroblockLayer(ctx, mbs, 0, -1)
+	if err != nil {
+		t.Fatalf("did not expect error: %v", err)
+	}
+	wantPrev := []int{0, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	wantRem := make([]int, 16)
+	wantRem[0] = 5
+	if !reflect.DeepEqual(d.PrevIntra4x4PredModeFlag, wantPrev) || !reflect.DeepEqual(d.RemIntra4x4PredMode, wantRem) {
+		t.Errorf("did not get expected intra prediction modes\nGot: %v, %v\nWant: %v, %v",
+			d.PrevIntra4x4PredModeFlag, d.RemIntra4x4PredMode, wantPrev, wantRem)
+	}
+	if d.CodedBlockPattern != 32 || d.MbQpDelta != -4 {
+		t.Errorf("did not get expected syntax elements\nGot: %d, %d\nWant: 32, -4", d.CodedBlockPattern, d.MbQpDelta)
+	}
+	if got := d.residual.chromaDC[0][:4]; !reflect.DeepEqual(got, cbDC) {
+		t.Errorf("did not get expected Cb DC levels\nGot: %v\nWant: %v", got, cbDC)
+	}
+	if got := d.residual.chromaAC[0][0][:]; !reflect.DeepEqual(got, cbAC) {
+		t.Errorf("did not get expected Cb AC levels\nGot: %v\nWant: %v", got, cbAC)
+	}
+	if got := mbs.totalCoeff[1][0]; got != 2 {
+		t.Errorf("did not get expected TotalCoeff\nGot: %d\nWant: 2", got)
+	}
+	if d.BitReader.Off() != len(in) {
+		t.Errorf("did not get expected bits read\nGot: %d\nWant: %d", d.BitReader.Off(), len(in))
+	}
+}
+
+func TestReadMacroblockLayerInter(t *testing.T) {
+	tests := []struct {
+		in           string
+		sliceType    string
+		header       SliceHeader
+		wantRefIdx   [2][]int
+		wantMvd      [2][][2]int // Of the first sub-macroblock partition of each partition.
+		wantSubMb    []int
+		wantMbRefIdx [2][4]int8
+	}{
+		{
+			// P_L0_L0_16x8 with 3 reference indices.
+			in:           ueBits(1) + ueBits(2) + ueBits(0) + seBits(-3) + seBits(4) + seBits(0) + seBits(1) + ueBits(cbpCodeNum(t, 0, false)),
+			sliceType:    "P",
+			header:       SliceHeader{NumRefIdxL0ActiveMinus1: 2},
+			wantRefIdx:   [2][]int{{2, 0, -1, -1}, {-1, -1, -1, -1}},
+			wantMvd:      [2][][2]int{{{-3, 4}, {0, 1}, {}, {}}, {{}, {}, {}, {}}},
+			wantSubMb:    []int{0, 0, 0, 0},
+			wantMbRefIdx: [2][4]int8{{2, 2, 0, 0}, {-1, -1, -1, -1}},
+		},
+		{
+			// B_8x8 with sub-macroblocks B_Direct_8x8, B_Bi_8x8, B_L0_4x4 and
+			// B_L1_8x8, one reference index in list 0 and 2 in list 1.
+			in: ueBits(22) + ueBits(0) + ueBits(3) + ueBits(10) + ueBits(2) + "0" + "1" +
+				seBits(1) + seBits(2) + seBits(3) + seBits(4) + strings.Repeat(seBits(0), 6) +
+				seBits(-5) + seBits(-6) + seBits(7) + seBits(8) + ueBits(cbpCodeNum(t, 0, false)),
+			sliceType:    "B",
+			header:       SliceHeader{NumRefIdxL1ActiveMinus1: 1},
+			wantRefIdx:   [2][]int{{-1, 0, 0, -1}, {-1, 1, -1, 0}},
+			wantMvd:      [2][][2]int{{{}, {1, 2}, {3, 4}, {}}, {{}, {-5, -6}, {}, {7, 8}}},
+			wantSubMb:    []int{0, 3, 10, 2},
+			wantMbRefIdx: [2][4]int8{{0, 0, 0, -1}, {0, 1, -1, 0}},
+		},
+	}
+
+	for i, test := range tests {
+		ctx, d, mbs := mbTestSliceData(test.in, test.sliceType, &test.header, &PPS{})
+		for list := range mbs.refIdx {
+			for j := range mbs.refIdx[list] {
+				mbs.refIdx[list][j] = -1
+			}
+		}
+		err := d.readMacroblockLayer(ctx, mbs, 0, -1)
+		if err != nil {
+			t.Errorf("did not expect error: %v for test: %d", err, i)
+			continue
+		}
+		gotRefIdx := [2][]int{d.RefIdxL0, d.RefIdxL1}
+		if !reflect.DeepEqual(gotRefIdx, test.wantRefIdx) {
+			t.Errorf("did not get expected ref_idx for test: %d\nGot: %v\nWant: %v", i, gotRefIdx, test.wantRefIdx)
+		}
+		for list, mvd := range [2][][][]int{d.MvdL0, d.MvdL1} {
+			for mbPartIdx, want := range test.wantMvd[list] {
+				if got := [2]int{mvd[mbPartIdx][0][0], mvd[mbPartIdx][0][1]}; got != want {
+					t.Errorf("did not get expected mvd_l%d[%d] for test: %d\nGot: %v\nWant: %v", list, mbPartIdx, i, got, want)
+				}
+			}
+		}
+		if !reflect.DeepEqual(d.SubMbType, test.wantSubMb) {
+			t.Errorf("did not get expected sub_mb_type for test: %d\nGot: %v\nWant: %v", i, d.SubMbType, test.wantSubMb)
+		}
+		for list, want := range test.wantMbRefIdx {
+			if got := mbs.refIdx[list]; !reflect.DeepEqual(got, want[:]) {
+				t.Errorf("did not get expected refIdx recorded for list: %d, test: %d\nGot: %v\nWant: %v", list, i, got, want)
+			}
+		}
+		if mbs.has(0, mbIntraCoded) {
+			t.Errorf("did not expect intra macroblock for test: %d", i)
+		}
+		if d.BitReader.Off() != len(test.in) {
+			t.Errorf("did not get expected bits read for test: %d\nGot: %d\nWant: %d", i, d.BitReader.Off(), len(test.in))
+		}
+	}
+}
+
+// TestReadMacroblockLayerPCM checks the parsing of I_PCM macroblocks and the
+// placement of their samples, for CAVLC and for CABAC, for which decoding of
+// end_of_slice_flag following the samples requires the decoding engine to
+// have been initialised after them.
+func TestReadMacroblockLayerPCM(t *testing.T) {
+	// Luma samples 0 to 255 in raster order, and chroma samples 255 down to
+	// 192 for Cb and 191 down to 128 for Cr.
+	var samples string
+	for i := 0; i < 256; i++ {
+		samples += fmt.Sprintf("%08b", i)
+	}
+	for i := 0; i < 128; i++ {
+		samples += fmt.Sprintf("%08b", 255-i)
+	}
+
+	for _, cabac := range []bool{false, true} {
+		var in string
+		var e *cabacEncoder
+		if cabac {
+			// mb_type I_PCM, its second bin being decoded using DecodeTerminate.
+			e = newCABACEncoder(t, "I", 0, 26)
+			e.encodeDecision(3, 1)
+			e.encodeTerminate(1)
+			for _, b := range e.bits {
+				in += fmt.Sprint(b)
+			}
+		} else {
+			in = ueBits(25)
+		}
+		in += strings.Repeat("0", 7-(len(in)+7)%8) + samples
+		if cabac {
+			// end_of_slice_flag, coded by the engine initialised after the
+			// samples, with the context variables as before them.
+			e.codILow, e.codIRange, e.firstBitFlag, e.bitsOutstanding, e.bits = 0, 510, true, 0, nil
+			e.encodeTerminate(1)
+			for _, b := range e.bits {
+				in += fmt.Sprint(b)
+			}
+		}
+
+		ctx, d, mbs := mbTestSliceData(in, "I", &SliceHeader{}, &PPS{})
+		d.pic = image.NewYCbCr(image.Rect(0, 0, 16, 16), image.YCbCrSubsampleRatio420)
+		if cabac {
+			var err error
+			d.cabac, err = newCABACDecoder(d.BitReader, "I", 0, 26)
+			if err != nil {
+				t.Fatalf("did not expect error: %v from newCABACDecoder", err)
+			}
+		}
+		err := d.readMacroblockLayer(ctx, mbs, 0, -1)
+		if err != nil {
+			t.Fatalf("did not expect error: %v with CABAC: %v", err, cabac)
+		}
+		if cabac {
+			end, err := d.readEndOfSliceFlag()
+			if err != nil || !end {
+				t.Errorf("did not get expected end_of_slice_flag\nGot: %v, %v\nWant: true, <nil>", end, err)
+			}
+		}
+		err = d.constructMb(ctx, mbs, 0)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from constructMb with CABAC: %v", err, cabac)
+		}
+
+		if len(d.PcmSampleLuma) != 256 || len(d.PcmSampleChroma) != 128 {
+			t.Errorf("did not get expected number of PCM samples with CABAC: %v\nGot: %d, %d\nWant: 256, 128", cabac, len(d.PcmSampleLuma), len(d.PcmSampleChroma))
+		}
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				if got, want := d.pic.Y[y*d.pic.YStride+x], byte(16*y+x); got != want {
+					t.Fatalf("did not get expected luma sample at (%d, %d) with CABAC: %v\nGot: %d\nWant: %d", x, y, cabac, got, want)
+				}
+			}
+		}
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				off := y*d.pic.CStride + x
+				if d.pic.Cb[off] != byte(255-(8*y+x)) || d.pic.Cr[off] != byte(191-(8*y+x)) {
+					t.Fatalf("did not get expected chroma samples at (%d, %d) with CABAC: %v\nGot: %d, %d", x, y, cabac, d.pic.Cb[off], d.pic.Cr[off])
+				}
+			}
+		}
+		if !mbs.has(0, mbIntraCoded|mbPCM) || mbs.totalCoeff[0][0] != 16 || mbs.totalCoeff[2][3] != 16 {
+			t.Errorf("did not get expected state recorded with CABAC: %v\nGot: %08b, %d", cabac, mbs.flags[0], mbs.totalCoeff[0][0])
+		}
+	}
+}
+
+// TestReadMacroblockLayer8x8 checks the parsing under CAVLC of the 4
+// interleaved 4x4 blocks of an 8x8 luma block of an I_NxN macroblock with
+// transform_size_8x8_flag 1, each using the nC given by the TotalCoeff of
+// the blocks before it.
+func TestReadMacroblockLayer8x8(t *testing.T) {
+	blocks := [4][]int{
+		{1, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0, -1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		make([]int, 16),
+		{3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	}
+	in := ueBits(0) + "1" + "1111" + ueBits(0) + ueBits(cbpCodeNum(t, 1, true)) + seBits(0) +
+		encodeCAVLC(t, blocks[0], 0, 15, 16, 0) + encodeCAVLC(t, blocks[1], 0, 15, 16, 2) +
+		encodeCAVLC(t, blocks[2], 0, 15, 16, 2) + encodeCAVLC(t, blocks[3], 0, 15, 16, 1)
+
+	ctx, d, mbs := mbTestSliceData(in, "I", &SliceHeader{}, &PPS{Transform8x8Mode: 1})
+	err := d.readMacroblockLayer(ctx, mbs, 0, -1)
+	if err != nil {
+		t.Fatalf("did not expect error: %v", err)
+	}
+	var want [maxNumCoeff8x8]int
+	want[0], want[8], want[5], want[3] = 1, 2, -1, 3
+	if d.residual.level8x8[0][0] != want {
+		t.Errorf("did not get expected levels\nGot: %v\nWant: %v", d.residual.level8x8[0][0], want)
+	}
+	wantTotal := []uint8{2, 1, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if got := mbs.totalCoeff[0][:blocksPerMb]; !reflect.DeepEqual(got, wantTotal) {
+		t.Errorf("did not get expected TotalCoeff\nGot: %v\nWant: %v", got, wantTotal)
+	}
+	if !d.TransformSize8x8Flag || !mbs.has(0, mbTransform8x8) {
+		t.Errorf("did not get expected transform_size_8x8_flag")
+	}
+	if d.BitReader.Off() != len(in) {
+		t.Errorf("did not get expected bits read\nGot: %d\nWant: %d", d.BitReader.Off(), len(in))
+	}
+}
+
+// encodeResidualBlock encodes the significance map and levels of a residual
+// block of the given ctxBlockCat, field coded if field, with coefficients
+// from index 0, whose coded_block_flag is 1.
+func (e *cabacEncoder) encodeResidualBlock(ctxBlockCat int, field bool, coeffLevel []int) {
+	last := len(coeffLevel) - 1
+	for last > 0 && coeffLevel[last] == 0 {
+		last--
+	}
+	for j := 0; j < last; j++ {
+		e.encodeDecision(sigCoeffCtxIdx(ctxBlockCat, field, false, j, 1), flagVal(coeffLevel[j] != 0))
+		if coeffLevel[j] != 0 {
+			e.encodeDecision(sigCoeffCtxIdx(ctxBlockCat, field, true, j, 1), 0)
+		}
+	}
+	if last < len(coeffLevel)-1 {
+		e.encodeDecision(sigCoeffCtxIdx(ctxBlockCat, field, false, last, 1), 1)
+		e.encodeDecision(sigCoeffCtxIdx(ctxBlockCat, field, true, last, 1), 1)
+	}
+	var eq1, gt1 int
+	for j := last; j >= 0; j-- {
+		if coeffLevel[j] == 0 {
+			continue
+		}
+		v := abs(coeffLevel[j]) - 1
+		e.encodeUEGk(v, false, coeffAbsLevelUCoff, 0, func(binIdx int) int {
+			return coeffAbsLevelCtxIdx(ctxBlockCat, binIdx, eq1, gt1)
+		})
+		e.encodeBypass(flagVal(coeffLevel[j] < 0))
+		if v == 0 {
+			eq1++
+		} else {
+			gt1++
+		}
+	}
+}
+
+// TestReadResidualLuma8x8CABAC checks the parsing under CABAC of the 8x8
+// luma blocks of a field macroblock with CodedBlockPatternLuma 5, whose
+// coded_block_flags are present only for 4:4:4, the first being 1 and the
+// second 0, with ctxIdxInc 2 given by the first.
+func TestReadResidualLuma8x8CABAC(t *testing.T) {
+	var want [maxNumCoeff8x8]int
+	want[0], want[1], want[9], want[40] = 7, -1, 1, -2
+
+	for _, cat := range []int{chroma420, chroma444} {
+		e := newCABACEncoder(t, "P", 0, 32)
+		if cat == chroma444 {
+			e.encodeDecision(codedBlockFlagCtxIdx(ctxBlockCatLuma8x8, 0), 1)
+		}
+		e.encodeResidualBlock(ctxBlockCatLuma8x8, true, want[:])
+		if cat == chroma444 {
+			e.encodeDecision(codedBlockFlagCtxIdx(ctxBlockCatLuma8x8, 2), 0)
+		} else {
+			e.encodeResidualBlock(ctxBlockCatLuma8x8, true, want[:])
+		}
+		e.encodeTerminate(1)
+
+		dec, err := newCABACDecoder(e.reader(), "P", 0, 32)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from newCABACDecoder", err)
+		}
+		d := &SliceData{cabac: dec, CodedBlockPattern: 5, TransformSize8x8Flag: true}
+		mbs := ctxIncState(func(int) mbFlags { return mbTransform8x8 })
+		mbs.codedBlockPattern[ctxIncMbCurr] = 5
+		err = d.readResidualLuma(nil, mbs, ctxIncMbCurr, 0, cat, false, true, false)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for ChromaArrayType: %d", err, cat)
+		}
+		end, err := d.readEndOfSliceFlag()
+		if err != nil || !end {
+			t.Errorf("did not get expected end of slice for ChromaArrayType: %d\nGot: %v, %v", cat, end, err)
+		}
+
+		want2 := want
+		if cat == chroma444 {
+			want2 = [maxNumCoeff8x8]int{}
+		}
+		if d.residual.level8x8[0][0] != want || d.residual.level8x8[0][2] != want2 {
+			t.Errorf("did not get expected levels for ChromaArrayType: %d\nGot: %v, %v", cat, d.residual.level8x8[0][0], d.residual.level8x8[0][2])
+		}
+		wantTotal := []uint8{4, 4, 4, 4, 0, 0, 0, 0, 4, 4, 4, 4, 0, 0, 0, 0}
+		if cat == chroma444 {
+			wantTotal = []uint8{4, 4, 4, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		}
+		if got := mbs.totalCoeff[0][ctxIncMbCurr*blocksPerMb : (ctxIncMbCurr+1)*blocksPerMb]; !reflect.DeepEqual(got, wantTotal) {
+			t.Errorf("did not get expected coefficient counts for ChromaArrayType: %d\nGot: %v\nWant: %v", cat, got, wantTotal)
+		}
+	}
+}
+
+func TestReadMacroblockLayerErrors(t *testing.T) {
+	tests := []struct {
+		in        string
+		sliceType string
+		header    SliceHeader
+		pps       PPS
+		want      error
+	}{
+		{in: ueBits(26), sliceType: "I", want: errBadMbType},
+		{in: ueBits(23 + 26), sliceType: "B", want: errBadMbType},
+		{in: ueBits(0) + ueBits(3), sliceType: "P", header: SliceHeader{NumRefIdxL0ActiveMinus1: 2}, want: errBadRefIdx},
+		{in: ueBits(3) + ueBits(4), sliceType: "P", want: errBadSubMbType},
+		{in: ueBits(1) + ueBits(4), sliceType: "I", want: errBadIntraChromaPredMode},
+		{in: ueBits(1) + ueBits(0) + seBits(26), sliceType: "I", want: errBadMbQpDelta},
+	}
+
+	for i, test := range tests {
+		ctx, d, mbs := mbTestSliceData(test.in, test.sliceType, &test.header, &test.pps)
+		err := d.readMacroblockLayer(ctx, mbs, 0, -1)
+		if !errors.Is(err, test.want) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.want)
+		}
+	}
+}
+
+func TestIntra16x16CodedBlockPattern(t *testing.T) {
+	tests := []struct {
+		mbType int
+		want   int
+	}{
+		{1, 0x00},
+		{4, 0x00},
+		{5, 0x10},
+		{12, 0x20},
+		{13, 0x0f},
+		{18, 0x1f},
+		{24, 0x2f},
+	}
+
+	for i, test := range tests {
+		got := intra16x16CodedBlockPattern(test.mbType)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestIntraMbType(t *testing.T) {
+	tests := []struct {
+		sliceType string
+		mbType    int
+		want      int
+		wantIntra bool
+	}{
+		{"I", 25, iPCM, true},
+		{"SI", 0, mbTypeSI, true},
+		{"SI", 1, iNxN, true},
+		{"P", 4, 0, false},
+		{"SP", 5, iNxN, true},
+		{"B", 22, 0, false},
+		{"B", 48, iPCM, true},
+	}
+
+	for i, test := range tests {
+		got, intra := intraMbType(test.sliceType, test.mbType)
+		if intra != test.wantIntra || (intra && got != test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v, %v\nWant: %v, %v", i, got, intra, test.want, test.wantIntra)
+		}
+	}
+}
+
+func TestPartitionPos(t *testing.T) {
+	tests := []struct {
+		partIdx, w, h, blkW int
+		wantX, wantY        int
+	}{
+		{1, 16, 8, 16, 0, 8},
+		{1, 8, 16, 16, 8, 0},
+		{3, 8, 8, 16, 8, 8},
+		{1, 8, 4, 8, 0, 4},
+		{1, 4, 8, 8, 4, 0},
+		{2, 4, 4, 8, 0, 4},
+		{5, 4, 4, 8, 4, 8},
+	}
+
+	for i, test := range tests {
+		x, y := partitionPos(test.partIdx, test.w, test.h, test.blkW)
+		if x != test.wantX || y != test.wantY {
+			t.Errorf("did not get expected result for test: %d\nGot: %d, %d\nWant: %d, %d", i, x, y, test.wantX, test.wantY)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+/*
+NAME
+  mbstate.go
+
+DESCRIPTION
+  mbstate.go provides storage of the per-macroblock decoding state of a
+  picture, such as macroblock types, intra prediction modes, motion vectors
+  and non-zero coefficient counts, as referred to by the decoding of
+  neighbouring macroblocks.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+// mbFlags is a set of boolean properties of a decoded macroblock.
+type mbFlags uint16
+
+// Macroblock properties.
+const (
+	mbSkipped      mbFlags = 1 << iota // mb_skip_flag or in mb_skip_run.
+	mbFieldDecoded                     // mb_field_decoding_flag.
+	mbIntraCoded                       // Intra or PCM macroblock type.
+	mbTransform8x8                     // transform_size_8x8_flag.
+	mbPCM                              // I_PCM macroblock type.
+	mbQpDelta                          // mb_qp_delta present and not equal to 0.
+	mbCbfYDC                           // coded_block_flag of the Intra16x16 luma DC block, coded using CABAC.
+	mbCbfCbDC                          // coded_block_flag of the Cb DC block, coded using CABAC.
+	mbCbfCrDC                          // coded_block_flag of the Cr DC block, coded using CABAC.
+)
+
+// Numbers of blocks per macroblock for which state is stored.
+const (
+	blocksPerMb     = 16 // 4x4 luma blocks.
+	partitionsPerMb = 4  // 8x8 partitions.
+)
+
+// motionVector is a luma motion vector or motion vector difference in units of
+// quarter samples.
+type motionVector struct {
+	X, Y int16
+}
+
+// mbState holds the decoding state of each macroblock of a picture that is
+// referred to when decoding later macroblocks, e.g. in the derivation of CABAC
+// context indices, CAVLC nC, intra prediction modes and motion vector
+// predictors. State is stored as a struct of arrays indexed by macroblock
+// address, or by macroblock address times the number of blocks per macroblock
+// plus block index, rather than as a struct per macroblock, so that the
+// neighbour lookups made for each macroblock touch only the few arrays needed
+// and the storage holds no pointers for the garbage collector to scan.
+type mbState struct {
+	widthMbs int  // PicWidthInMbs.
+	n        int  // PicSizeInMbs.
+	mbaff    bool // MbaffFrameFlag, with macroblocks addressed in pairs.
+
+	// sliceNum gives the slice to which each macroblock belongs, counting
+	// slices of the picture from 0, or -1 if it has not yet been decoded.
+	sliceNum []int32
+	slices   int32 // Number of slices of the picture begun.
+
+	lastMbAddr int // Address of the macroblock last begun, see beginMb.
+	sliceMbs   int // Number of macroblocks begun in the current slice.
+
+	flags               []mbFlags
+	mbType              []uint8 // mb_type, as given in the tables for the slice type.
+	codedBlockPattern   []uint8
+	qpY                 []int8
+	intraChromaPredMode []uint8
+
+	// Per 4x4 luma block, with blocks in the order of luma4x4BlkIdx.
+	intraPredModes []int8     // Intra4x4PredMode, or Intra8x8PredMode of the containing 8x8 block, or 2 (DC) if neither.
+	totalCoeff     [3][]uint8 // TotalCoeff of residual blocks, for Y, Cb and Cr.
+	mv             [2][]motionVector
+	mvd            [2][]motionVector
+
+	// Per 8x8 partition, in the order of mbPartIdx. refIdx is -1 for
+	// partitions not predicted from the list, i.e. with predFlagLX of 0.
+	refIdx    [2][]int8
+	subMbType []uint8 // sub_mb_type, as given in the tables for the slice type.
+}
+
+// newMbState returns an mbState for pictures of the given dimensions in
+// macroblocks, with no macroblocks decoded.
+func newMbState(widthMbs, heightMbs int) *mbState {
+	n := widthMbs * heightMbs
+	s := &mbState{
+		widthMbs:            widthMbs,
+		n:                   n,
+		sliceNum:            make([]int32, n),
+		flags:               make([]mbFlags, n),
+		mbType:              make([]uint8, n),
+		codedBlockPattern:   make([]uint8, n),
+		qpY:                 make([]int8, n),
+		intraChromaPredMode: make([]uint8, n),
+		intraPredModes:      make([]int8, n*blocksPerMb),
+		subMbType:           make([]uint8, n*partitionsPerMb),
+	}
+	for i := range s.totalCoeff {
+		s.totalCoeff[i] = make([]uint8, n*blocksPerMb)
+	}
+	for i := range s.mv {
+		s.mv[i] = make([]motionVector, n*blocksPerMb)
+		s.mvd[i] = make([]motionVector, n*blocksPerMb)
+		s.refIdx[i] = make([]int8, n*partitionsPerMb)
+	}
+	s.reset()
+	return s
+}
+
+// reset marks all macroblocks as not yet decoded, for reuse of the storage for
+// a new picture. State of macroblocks that have not been decoded is never
+// read, so only sliceNum is cleared.
+func (s *mbState) reset() {
+	for i := range s.sliceNum {
+		s.sliceNum[i] = -1
+	}
+	s.slices = 0
+}
+
+// startSlice returns the number of a new slice of the picture.
+func (s *mbState) startSlice() int32 {
+	s.sliceMbs = 0
+	s.slices++
+	return s.slices - 1
+}
+
+// beginMb assigns the macroblock with address mbAddr to the given slice, with
+// the given flags. In an MBAFF frame the macroblock is also given the
+// mb_field_decoding_flag of its pair, which is returned: for a bottom
+// macroblock this is that of the top macroblock, and for a top macroblock it
+// is inferred as specified in section 7.4.4, to be replaced using
+// setFieldDecoding if present in the slice data for either macroblock of the
+// pair.
+func (s *mbState) beginMb(mbAddr int, sliceNum int32, f mbFlags) bool {
+	s.sliceNum[mbAddr] = sliceNum
+	s.flags[mbAddr] = f &^ mbFieldDecoded
+	s.clearMotion(mbAddr)
+	s.lastMbAddr = mbAddr
+	s.sliceMbs++
+	if !s.mbaff {
+		return false
+	}
+	var field bool
+	if mbAddr%2 == 1 {
+		field = s.has(mbAddr-1, mbFieldDecoded)
+	} else {
+		field = s.inferFieldDecoding(mbAddr)
+	}
+	if field {
+		s.flags[mbAddr] |= mbFieldDecoded
+	}
+	return field
+}
+
+// setFieldDecoding sets mb_field_decoding_flag, as present in the slice data
+// for the macroblock with address mbAddr of an MBAFF frame, for that
+// macroblock and, if it is the bottom macroblock of its pair, for the top
+// macroblock, which must then have been skipped.
+func (s *mbState) setFieldDecoding(mbAddr int, field bool) {
+	for i := mbAddr &^ 1; i <= mbAddr; i++ {
+		s.flags[i] &^= mbFieldDecoded
+		if field {
+			s.flags[i] |= mbFieldDecoded
+		}
+	}
+}
+
+// inferFieldDecoding returns the value inferred for mb_field_decoding_flag of
+// the macroblock pair containing currMbAddr when it is present for neither of
+// its macroblocks, being that of the pair to the left or, if that is not
+// available, the pair above, or otherwise false, as specified in section
+// 7.4.4.
+func (s *mbState) inferFieldDecoding(currMbAddr int) bool {
+	if mbAddrA := s.mbaffAddrA(currMbAddr); mbAddrA != MbAddrNotAvailable {
+		return s.has(mbAddrA, mbFieldDecoded)
+	}
+	if mbAddrB := s.mbaffAddrB(currMbAddr); mbAddrB != MbAddrNotAvailable {
+		return s.has(mbAddrB, mbFieldDecoded)
+	}
+	return false
+}
+
+// available returns true if the macroblock with address mbAddr is available
+// for reference when decoding the macroblock with address currMbAddr, as
+// specified in section 6.4.1, i.e. it has been decoded and belongs to the same
+// slice. The macroblock with address currMbAddr must have been assigned to a
+// slice, so macroblocks not yet decoded never compare equal.
+func (s *mbState) available(mbAddr, currMbAddr int) bool {
+	return uint(mbAddr) <= uint(currMbAddr) && s.sliceNum[mbAddr] == s.sliceNum[currMbAddr]
+}
+
+// mbAddrA, mbAddrB, mbAddrC and mbAddrD return the addresses of the
+// macroblocks to the left, above, above right and above left of the macroblock
+// with address currMbAddr in a non-MBAFF picture, or MbAddrNotAvailable if they
+// are not available, as specified in section 6.4.9.
+func (s *mbState) mbAddrA(currMbAddr int) int {
+	if currMbAddr%s.widthMbs == 0 {
+		return MbAddrNotAvailable
+	}
+	return s.neighbour(currMbAddr-1, currMbAddr)
+}
+
+func (s *mbState) mbAddrB(currMbAddr int) int {
+	return s.neighbour(currMbAddr-s.widthMbs, currMbAddr)
+}
+
+func (s *mbState) mbAddrC(currMbAddr int) int {
+	if (currMbAddr+1)%s.widthMbs == 0 {
+		return MbAddrNotAvailable
+	}
+	return s.neighbour(currMbAddr-s.widthMbs+1, currMbAddr)
+}
+
+func (s *mbState) mbAddrD(currMbAddr int) int {
+	if currMbAddr%s.widthMbs == 0 {
+		return MbAddrNotAvailable
+	}
+	return s.neighbour(currMbAddr-s.widthMbs-1, currMbAddr)
+}
+
+// neighbour returns mbAddr if it is available for reference when decoding
+// currMbAddr, otherwise MbAddrNotAvailable.
+func (s *mbState) neighbour(mbAddr, currMbAddr int) int {
+	if !s.available(mbAddr, currMbAddr) {
+		return MbAddrNotAvailable
+	}
+	return mbAddr
+}
+
+// has returns true if the macroblock with address mbAddr has all of the
+// given flags set.
+func (s *mbState) has(mbAddr int, f mbFlags) bool {
+	return s.flags[mbAddr]&f == f
+}
+
+// mbaffAddrA, mbaffAddrB, mbaffAddrC and mbaffAddrD return the addresses of
+// the top macroblocks of the macroblock pairs to the left, above, above right
+// and above left of the pair containing the macroblock with address
+// currMbAddr in an MBAFF frame, or MbAddrNotAvailable if they are not
+// available, as specified in section 6.4.10.
+func (s *mbState) mbaffAddrA(currMbAddr int) int {
+	if (currMbAddr/2)%s.widthMbs == 0 {
+		return MbAddrNotAvailable
+	}
+	return s.neighbour(2*(currMbAddr/2-1), currMbAddr)
+}
+
+func (s *mbState) mbaffAddrB(currMbAddr int) int {
+	return s.neighbour(2*(currMbAddr/2-s.widthMbs), currMbAddr)
+}
+
+func (s *mbState) mbaffAddrC(currMbAddr int) int {
+	if (currMbAddr/2+1)%s.widthMbs == 0 {
+		return MbAddrNotAvailable
+	}
+	return s.neighbour(2*(currMbAddr/2-s.widthMbs+1), currMbAddr)
+}
+
+func (s *mbState) mbaffAddrD(currMbAddr int) int {
+	if (currMbAddr/2)%s.widthMbs == 0 {
+		return MbAddrNotAvailable
+	}
+	return s.neighbour(2*(currMbAddr/2-s.widthMbs-1), currMbAddr)
+}
+
+// neighbourLocation returns the address of the macroblock covering the
+// location (xN, yN), relative to the top left sample of the macroblock with
+// address currMbAddr, and the location (xW, yW) relative to the top left of
+// that macroblock, as specified in section 6.4.12, for luma locations with
+// maxW and maxH of 16 or chroma locations with maxW and maxH of MbWidthC and
+// MbHeightC. If the macroblock is not available mbAddrN is
+// MbAddrNotAvailable. In an MBAFF frame the derivation depends on whether the
+// current and neighbouring macroblock pairs are field or frame pairs, as given
+// by table 6-4, so the mbFieldDecoded flag of the current macroblock must be
+// set.
+func (s *mbState) neighbourLocation(currMbAddr, xN, yN, maxW, maxH int) (mbAddrN, xW, yW int) {
+	if s.mbaff {
+		return s.mbaffNeighbourLocation(currMbAddr, xN, yN, maxW, maxH)
+	}
+
+	// Table 6-3.
+	switch {
+	case yN > maxH-1:
+		mbAddrN = MbAddrNotAvailable
+	case xN < 0 && yN < 0:
+		mbAddrN = s.mbAddrD(currMbAddr)
+	case xN < 0:
+		mbAddrN = s.mbAddrA(currMbAddr)
+	case xN < maxW && yN < 0:
+		mbAddrN = s.mbAddrB(currMbAddr)
+	case xN < maxW:
+		mbAddrN = currMbAddr
+	case yN < 0:
+		mbAddrN = s.mbAddrC(currMbAddr)
+	default:
+		mbAddrN = MbAddrNotAvailable
+	}
+	if mbAddrN == MbAddrNotAvailable {
+		return MbAddrNotAvailable, 0, 0
+	}
+	return mbAddrN, (xN + maxW) % maxW, (yN + maxH) % maxH
+}
+
+// mbaffNeighbourLocation is neighbourLocation for MBAFF frames, as specified
+// in section 6.4.12.2.
+func (s *mbState) mbaffNeighbourLocation(currMbAddr, xN, yN, maxW, maxH int) (mbAddrN, xW, yW int) {
+	currMbFrame := !s.has(currMbAddr, mbFieldDecoded)
+	top := currMbAddr%2 == 0
+	yM := yN
+
+	// Table 6-4. Each case sets mbAddrX, the top macroblock of the
+	// neighbouring pair, and then mbAddrN, the macroblock of the pair holding
+	// the location, and yM.
+	var mbAddrX int
+	switch {
+	case yN > maxH-1 || (xN > maxW-1 && yN >= 0):
+		return MbAddrNotAvailable, 0, 0
+
+	case xN < 0 && yN < 0:
+		switch {
+		case currMbFrame && top:
+			mbAddrX = s.mbaffAddrD(currMbAddr)
+			mbAddrN = mbAddrX + 1
+		case currMbFrame:
+			mbAddrX = s.mbaffAddrA(currMbAddr)
+			mbAddrN = mbAddrX
+			if mbAddrX != MbAddrNotAvailable && s.has(mbAddrX, mbFieldDecoded) {
+				// The row above is the last of the bottom field of the pair.
+				mbAddrN = mbAddrX + 1
+				yM = (yN + maxH) >> 1
+			}
+		case top:
+			mbAddrX = s.mbaffAddrD(currMbAddr)
+			mbAddrN = mbAddrX
+			if mbAddrX != MbAddrNotAvailable && !s.has(mbAddrX, mbFieldDecoded) {
+				mbAddrN = mbAddrX + 1
+				yM = 2 * yN
+			}
+		default:
+			mbAddrX = s.mbaffAddrD(currMbAddr)
+			mbAddrN = mbAddrX + 1
+		}
+
+	case xN < 0:
+		mbAddrX = s.mbaffAddrA(currMbAddr)
+		if mbAddrX == MbAddrNotAvailable {
+			break
+		}
+		xFrame := !s.has(mbAddrX, mbFieldDecoded)
+		mbAddrN = mbAddrX
+		switch {
+		case currMbFrame && xFrame:
+			if !top {
+				mbAddrN = mbAddrX + 1
+			}
+		case currMbFrame:
+			mbAddrN = mbAddrX + yN%2
+			yM = yN >> 1
+			if !top {
+				yM = (yN + maxH) >> 1
+			}
+		case xFrame:
+			yM = yN << 1
+			if !top {
+				yM++
+			}
+			if yN >= maxH/2 {
+				mbAddrN = mbAddrX + 1
+				yM -= maxH
+			}
+		default:
+			if !top {
+				mbAddrN = mbAddrX + 1
+			}
+		}
+
+	case xN < maxW && yN < 0:
+		switch {
+		case currMbFrame && top:
+			mbAddrX = s.mbaffAddrB(currMbAddr)
+			mbAddrN = mbAddrX + 1
+		case currMbFrame:
+			// The bottom frame macroblock of a pair lies below the top.
+			mbAddrX = currMbAddr
+			mbAddrN = currMbAddr - 1
+		case top:
+			mbAddrX = s.mbaffAddrB(currMbAddr)
+			mbAddrN = mbAddrX
+			if mbAddrX != MbAddrNotAvailable && !s.has(mbAddrX, mbFieldDecoded) {
+				mbAddrN = mbAddrX + 1
+				yM = 2 * yN
+			}
+		default:
+			mbAddrX = s.mbaffAddrB(currMbAddr)
+			mbAddrN = mbAddrX + 1
+		}
+
+	case xN < maxW:
+		mbAddrX = currMbAddr
+		mbAddrN = currMbAddr
+
+	default:
+		switch {
+		case currMbFrame && top:
+			mbAddrX = s.mbaffAddrC(currMbAddr)
+			mbAddrN = mbAddrX + 1
+		case currMbFrame:
+			mbAddrX = MbAddrNotAvailable
+		case top:
+			mbAddrX = s.mbaffAddrC(currMbAddr)
+			mbAddrN = mbAddrX
+			if mbAddrX != MbAddrNotAvailable && !s.has(mbAddrX, mbFieldDecoded) {
+				mbAddrN = mbAddrX + 1
+				yM = 2 * yN
+			}
+		default:
+			mbAddrX = s.mbaffAddrC(currMbAddr)
+			mbAddrN = mbAddrX + 1
+		}
+	}
+	if mbAddrX == MbAddrNotAvailable {
+		return MbAddrNotAvailable, 0, 0
+	}
+	return mbAddrN, (xN + maxW) % maxW, (yM + maxH) % maxH
+}
+
+// setMvd sets the mvd_lX, for list 0 or 1, of each 4x4 block of the
+// macroblock with address mbAddr within the partition whose top left luma
+// sample is at (x, y) relative to the macroblock, with the given width and
+// height.
+func (s *mbState) setMvd(mbAddr, list, x, y, w, h int, mvd motionVector) {
+	for yb := y; yb < y+h; yb += 4 {
+		for xb := x; xb < x+w; xb += 4 {
+			s.mvd[list][mbAddr*blocksPerMb+luma4x4BlkIdx(xb, yb)] = mvd
+		}
+	}
+}
+
+// setMv sets the motion vector mvLX, for list 0 or 1, of each 4x4 block of
+// the macroblock with address mbAddr within the partition whose top left luma
+// sample is at (x, y) relative to the macroblock, with the given width and
+// height.
+func (s *mbState) setMv(mbAddr, list, x, y, w, h int, mv motionVector) {
+	for yb := y; yb < y+h; yb += 4 {
+		for xb := x; xb < x+w; xb += 4 {
+			s.mv[list][mbAddr*blocksPerMb+luma4x4BlkIdx(xb, yb)] = mv
+		}
+	}
+}
+
+// clearMotion sets the refIdx of each partition of the macroblock with address
+// mbAddr to -1, and the mv and mvd of each 4x4 block to 0, for both lists, as
+// for intra macroblocks and partitions not predicted from a list, until set
+// for partitions that are.
+func (s *mbState) clearMotion(mbAddr int) {
+	for list := range s.refIdx {
+		refIdx := s.refIdx[list][mbAddr*partitionsPerMb : (mbAddr+1)*partitionsPerMb]
+		for i := range refIdx {
+			refIdx[i] = -1
+		}
+		mv := s.mv[list][mbAddr*blocksPerMb : (mbAddr+1)*blocksPerMb]
+		mvd := s.mvd[list][mbAddr*blocksPerMb : (mbAddr+1)*blocksPerMb]
+		for i := range mv {
+			mv[i], mvd[i] = motionVector{}, motionVector{}
+		}
+	}
+}
+
+// setRefIdx sets the refIdxLX, for list 0 or 1, of each 8x8 partition of the
+// macroblock with address mbAddr within the partition whose top left luma
+// sample is at (x, y) relative to the macroblock, with the given width and
+// height, which for sub-macroblock partitions is that of the 8x8 partition.
+func (s *mbState) setRefIdx(mbAddr, list, x, y, w, h, refIdx int) {
+	for yb := y; yb < y+h; yb += 8 {
+		for xb := x; xb < x+w; xb += 8 {
+			s.refIdx[list][mbAddr*partitionsPerMb+luma8x8BlkIdx(xb, yb)] = int8(refIdx)
+		}
+	}
+}
+
+// setIntraPredModes sets the intra prediction mode of all 4x4 luma blocks of
+// the macroblock with address mbAddr to mode, being 2 (DC) for macroblocks not
+// coded in Intra_4x4 or Intra_8x8 prediction mode as taken by the derivation
+// of predicted modes, see intra4x4PredMode.
+func (s *mbState) setIntraPredModes(mbAddr int, mode int8) {
+	m := s.intraPredModes[mbAddr*blocksPerMb : (mbAddr+1)*blocksPerMb]
+	for i := range m {
+		m[i] = mode
+	}
+}
+
+// setTotalCoeff sets the TotalCoeff of all blocks of all colour components of
+// the macroblock with address mbAddr to n, being 0 for skipped macroblocks and
+// 16 for I_PCM macroblocks, see coeffTokenNC.
+func (s *mbState) setTotalCoeff(mbAddr int, n uint8) {
+	for _, tc := range s.totalCoeff {
+		tc := tc[mbAddr*blocksPerMb : (mbAddr+1)*blocksPerMb]
+		for i := range tc {
+			tc[i] = n
+		}
+	}
+}
@@ -0,0 +1,324 @@
+/*
+NAME
+  mbstate_test.go
+
+DESCRIPTION
+  mbstate_test.go provides testing and benchmarking for functionality provided
+  in mbstate.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+// TestMbNeighbours checks neighbouring macroblock addresses and their
+// availability at picture edges and slice boundaries.
+func TestMbNeighbours(t *testing.T) {
+	const na = MbAddrNotAvailable
+
+	// 3x3 macroblock picture with slice 0 being macroblocks 0 to 3 and slice 1
+	// being macroblocks 4 to 7, with 8 not yet decoded.
+	s := newMbState(3, 3)
+	for i := 0; i < 8; i++ {
+		s.sliceNum[i] = int32(i / 4)
+	}
+
+	tests := []struct {
+		curr       int
+		a, b, c, d int
+	}{
+		{curr: 0, a: na, b: na, c: na, d: na},
+		{curr: 1, a: 0, b: na, c: na, d: na},
+		{curr: 3, a: na, b: 0, c: 1, d: na},
+		{curr: 4, a: na, b: na, c: na, d: na},
+		{curr: 5, a: 4, b: na, c: na, d: na},
+		{curr: 7, a: 6, b: 4, c: 5, d: na},
+	}
+
+	for i, test := range tests {
+		got := [4]int{s.mbAddrA(test.curr), s.mbAddrB(test.curr), s.mbAddrC(test.curr), s.mbAddrD(test.curr)}
+		want := [4]int{test.a, test.b, test.c, test.d}
+		if got != want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, want)
+		}
+	}
+
+	s.reset()
+	s.sliceNum[1] = 0
+	if s.available(0, 1) {
+		t.Error("expected macroblock to be unavailable after reset")
+	}
+}
+
+// TestNeighbourLocation checks neighbouring locations in a non-MBAFF picture
+// for luma and chroma locations.
+func TestNeighbourLocation(t *testing.T) {
+	const na = MbAddrNotAvailable
+
+	// 3x3 macroblock picture with all macroblocks in slice 0.
+	s := newMbState(3, 3)
+	for i := range s.sliceNum {
+		s.sliceNum[i] = 0
+	}
+
+	tests := []struct {
+		curr, xN, yN, maxW, maxH int
+		want                     [3]int
+	}{
+		{curr: 4, xN: -1, yN: 3, maxW: 16, maxH: 16, want: [3]int{3, 15, 3}},
+		{curr: 4, xN: 2, yN: -1, maxW: 16, maxH: 16, want: [3]int{1, 2, 15}},
+		{curr: 4, xN: 16, yN: -1, maxW: 16, maxH: 16, want: [3]int{2, 0, 15}},
+		{curr: 4, xN: -1, yN: -1, maxW: 16, maxH: 16, want: [3]int{0, 15, 15}},
+		{curr: 4, xN: 5, yN: 6, maxW: 16, maxH: 16, want: [3]int{4, 5, 6}},
+		{curr: 4, xN: 8, yN: 0, maxW: 8, maxH: 8, want: [3]int{na, 0, 0}},
+		{curr: 4, xN: 0, yN: 16, maxW: 16, maxH: 16, want: [3]int{na, 0, 0}},
+		{curr: 3, xN: -1, yN: 0, maxW: 8, maxH: 8, want: [3]int{na, 0, 0}},
+		{curr: 4, xN: -1, yN: -1, maxW: 8, maxH: 8, want: [3]int{0, 7, 7}},
+	}
+
+	for i, test := range tests {
+		mbAddrN, xW, yW := s.neighbourLocation(test.curr, test.xN, test.yN, test.maxW, test.maxH)
+		got := [3]int{mbAddrN, xW, yW}
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestMbaffNeighbourLocation checks neighbouring locations in an MBAFF frame
+// for each combination of frame and field macroblock pairs in table 6-4.
+func TestMbaffNeighbourLocation(t *testing.T) {
+	const na = MbAddrNotAvailable
+
+	// 2x2 macroblock pair frame, i.e. macroblocks 0 to 7, in slice 0.
+	s := newMbState(2, 4)
+	s.mbaff = true
+	for i := range s.sliceNum {
+		s.sliceNum[i] = 0
+	}
+	setPairs := func(field [4]bool) {
+		for pair, f := range field {
+			for i := 2 * pair; i < 2*pair+2; i++ {
+				s.flags[i] &^= mbFieldDecoded
+				if f {
+					s.flags[i] |= mbFieldDecoded
+				}
+			}
+		}
+	}
+
+	tests := []struct {
+		field                    [4]bool // Field macroblock pairs.
+		curr, xN, yN, maxW, maxH int
+		want                     [3]int
+	}{
+		// Left, frame macroblock and field pair.
+		{field: [4]bool{2: true}, curr: 6, xN: -1, yN: 5, maxW: 16, maxH: 16, want: [3]int{5, 15, 2}},
+		{field: [4]bool{2: true}, curr: 7, xN: -1, yN: 4, maxW: 16, maxH: 16, want: [3]int{4, 15, 10}},
+
+		// Left, field macroblock and frame pair.
+		{field: [4]bool{3: true}, curr: 6, xN: -1, yN: 9, maxW: 16, maxH: 16, want: [3]int{5, 15, 2}},
+		{field: [4]bool{3: true}, curr: 7, xN: -1, yN: 3, maxW: 16, maxH: 16, want: [3]int{4, 15, 7}},
+		{field: [4]bool{3: true}, curr: 6, xN: -1, yN: 4, maxW: 8, maxH: 8, want: [3]int{5, 7, 0}},
+
+		// Left, same kinds.
+		{curr: 7, xN: -1, yN: 3, maxW: 16, maxH: 16, want: [3]int{5, 15, 3}},
+		{field: [4]bool{true, true, true, true}, curr: 7, xN: -1, yN: 3, maxW: 16, maxH: 16, want: [3]int{5, 15, 3}},
+
+		// Above.
+		{curr: 7, xN: 0, yN: -1, maxW: 16, maxH: 16, want: [3]int{6, 0, 15}},
+		{curr: 6, xN: 0, yN: -1, maxW: 16, maxH: 16, want: [3]int{3, 0, 15}},
+		{field: [4]bool{3: true}, curr: 6, xN: 0, yN: -1, maxW: 16, maxH: 16, want: [3]int{3, 0, 14}},
+		{field: [4]bool{1: true, 3: true}, curr: 6, xN: 0, yN: -1, maxW: 16, maxH: 16, want: [3]int{2, 0, 15}},
+		{field: [4]bool{3: true}, curr: 7, xN: 0, yN: -1, maxW: 16, maxH: 16, want: [3]int{3, 0, 15}},
+
+		// Above left.
+		{curr: 7, xN: -1, yN: -1, maxW: 16, maxH: 16, want: [3]int{4, 15, 15}},
+		{field: [4]bool{2: true}, curr: 7, xN: -1, yN: -1, maxW: 16, maxH: 16, want: [3]int{5, 15, 7}},
+		{curr: 6, xN: -1, yN: -1, maxW: 16, maxH: 16, want: [3]int{1, 15, 15}},
+		{field: [4]bool{3: true}, curr: 6, xN: -1, yN: -1, maxW: 16, maxH: 16, want: [3]int{1, 15, 14}},
+		{field: [4]bool{true, 3: true}, curr: 6, xN: -1, yN: -1, maxW: 16, maxH: 16, want: [3]int{0, 15, 15}},
+
+		// Above right.
+		{curr: 7, xN: 16, yN: -1, maxW: 16, maxH: 16, want: [3]int{na, 0, 0}},
+		{field: [4]bool{2: true}, curr: 4, xN: 16, yN: -1, maxW: 16, maxH: 16, want: [3]int{3, 0, 14}},
+		{curr: 4, xN: 16, yN: -1, maxW: 16, maxH: 16, want: [3]int{3, 0, 15}},
+
+		// Within and outside.
+		{curr: 6, xN: 3, yN: 4, maxW: 16, maxH: 16, want: [3]int{6, 3, 4}},
+		{curr: 6, xN: 16, yN: 0, maxW: 16, maxH: 16, want: [3]int{na, 0, 0}},
+		{curr: 4, xN: -1, yN: 0, maxW: 16, maxH: 16, want: [3]int{na, 0, 0}},
+		{curr: 1, xN: 0, yN: -1, maxW: 16, maxH: 16, want: [3]int{0, 0, 15}},
+		{curr: 0, xN: 0, yN: -1, maxW: 16, maxH: 16, want: [3]int{na, 0, 0}},
+	}
+
+	for i, test := range tests {
+		setPairs(test.field)
+		mbAddrN, xW, yW := s.neighbourLocation(test.curr, test.xN, test.yN, test.maxW, test.maxH)
+		got := [3]int{mbAddrN, xW, yW}
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestMbaffFieldDecoding checks that mb_field_decoding_flag is inferred for
+// macroblock pairs for which it is not present, and that a value present for
+// a bottom macroblock applies to its skipped top macroblock.
+func TestMbaffFieldDecoding(t *testing.T) {
+	// 2x2 macroblock pair frame.
+	s := newMbState(2, 4)
+	s.mbaff = true
+	slice := s.startSlice()
+
+	// Pair 0, with the flag present for the top macroblock.
+	got := []bool{s.beginMb(0, slice, 0)}
+	s.setFieldDecoding(0, true)
+	got = append(got, s.beginMb(1, slice, 0))
+
+	// Pair 1, skipped, so inferred from the pair to the left.
+	got = append(got, s.beginMb(2, slice, mbSkipped), s.beginMb(3, slice, mbSkipped))
+
+	// Pair 2, inferred from the pair above for the skipped top macroblock,
+	// with the flag then present for the bottom macroblock.
+	got = append(got, s.beginMb(4, slice, mbSkipped), s.beginMb(5, slice, 0))
+	s.setFieldDecoding(5, false)
+
+	// Pair 3, in a new slice so without available neighbours.
+	slice = s.startSlice()
+	got = append(got, s.beginMb(6, slice, mbSkipped), s.beginMb(7, slice, mbSkipped))
+
+	want := []bool{false, true, true, true, true, true, false, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("did not get expected result from beginMb for macroblock: %d\nGot: %v\nWant: %v", i, got[i], want[i])
+		}
+	}
+
+	wantFlags := []bool{true, true, true, true, false, false, false, false}
+	for i, w := range wantFlags {
+		if s.has(i, mbFieldDecoded) != w {
+			t.Errorf("did not get expected flag for macroblock: %d\nGot: %v\nWant: %v", i, !w, w)
+		}
+	}
+	if s.lastMbAddr != 7 || slice != 1 {
+		t.Errorf("did not get expected last macroblock and slice\nGot: %d, %d\nWant: 7, 1", s.lastMbAddr, slice)
+	}
+}
+
+// Dimensions of a 1080p picture in macroblocks.
+const (
+	benchWidthMbs  = 120
+	benchHeightMbs = 68
+)
+
+// BenchmarkMbStateNeighbours measures a pass over a 1080p picture of a single
+// slice deriving, with coeffTokenNC, the nC of each luma 4x4 block of each
+// macroblock in decoding order, and recording its TotalCoeff, as in the
+// decoding of CAVLC residual.
+func BenchmarkMbStateNeighbours(b *testing.B) {
+	s := newMbState(benchWidthMbs, benchHeightMbs)
+	b.ReportAllocs()
+	b.ResetTimer()
+	var sum int
+	for i := 0; i < b.N; i++ {
+		s.reset()
+		sliceNum := s.startSlice()
+		tc := s.totalCoeff[0]
+		for mb := 0; mb < s.n; mb++ {
+			s.beginMb(mb, sliceNum, mbIntraCoded)
+			for blkIdx := 0; blkIdx < blocksPerMb; blkIdx++ {
+				x, y := luma4x4BlkPos(blkIdx)
+				nC := s.coeffTokenNC(mb, 0, x, y, 16, 16, false)
+				sum += nC
+				tc[mb*blocksPerMb+blkIdx] = uint8(blkIdx)
+			}
+		}
+	}
+	_ = sum
+}
+
+// mbStruct is per-macroblock decoding state held as a struct with pointers to
+// its neighbours, as a baseline for BenchmarkMbStateNeighbours.
+type mbStruct struct {
+	sliceNum            int
+	skipped, field      bool
+	intra, transform8x8 bool
+	mbType              int
+	codedBlockPattern   int
+	qpY                 int
+	intraChromaPredMode int
+	intraPredModes      [16]int
+	totalCoeff          [3][16]int
+	mv, mvd             [2][16][2]int
+	refIdx              [2][4]int
+	left, top           *mbStruct
+}
+
+// BenchmarkMbStructNeighbours performs the same derivation as
+// BenchmarkMbStateNeighbours using a struct per macroblock, with blocks held
+// in raster order.
+func BenchmarkMbStructNeighbours(b *testing.B) {
+	n := benchWidthMbs * benchHeightMbs
+	mbs := make([]*mbStruct, n)
+	for i := range mbs {
+		mbs[i] = &mbStruct{}
+	}
+	for i, m := range mbs {
+		if i%benchWidthMbs != 0 {
+			m.left = mbs[i-1]
+		}
+		if i >= benchWidthMbs {
+			m.top = mbs[i-benchWidthMbs]
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	var sum int
+	for i := 0; i < b.N; i++ {
+		for _, m := range mbs {
+			m.sliceNum = -1
+		}
+		for _, m := range mbs {
+			m.sliceNum = 0
+			m.intra = true
+			for blkIdx := 0; blkIdx < 16; blkIdx++ {
+				x, y := luma4x4BlkPos(blkIdx)
+				blk := y + x/4
+				nA, nB := 0, 0
+				availableA, availableB := true, true
+				switch {
+				case x > 0:
+					nA = m.totalCoeff[0][blk-1]
+				case m.left != nil && m.left.sliceNum == m.sliceNum:
+					nA = m.left.totalCoeff[0][blk+3]
+				default:
+					availableA = false
+				}
+				switch {
+				case y > 0:
+					nB = m.totalCoeff[0][blk-4]
+				case m.top != nil && m.top.sliceNum == m.sliceNum:
+					nB = m.top.totalCoeff[0][blk+12]
+				default:
+					availableB = false
+				}
+				var nC int
+				switch {
+				case availableA && availableB:
+					nC = (nA + nB + 1) >> 1
+				case availableA:
+					nC = nA
+				case availableB:
+					nC = nB
+				}
+				sum += nC
+				m.totalCoeff[0][blk] = blkIdx
+			}
+		}
+	}
+	_ = sum
+}
@@ -8,6 +8,10 @@ const NoCabacInitIdc = -1
 
 // tables 9-12 to 9-13
 var (
+	// MNVars holds m and n for context variables 0 to 39 only.
+	//
+	// Deprecated: use ContextMN, which covers all context variables.
+	//
 	// 0-39 : MB_Type
 	// Maybe mapping all values in the range -128 to 128 to
 	// a list of tuples for input vars would be less verbose
@@ -181,6 +185,8 @@ func MNSecond(ctxIdx, cabacInitIdc int) {}
 // Table 9-18
 // Coded block pattern (luma y chroma)
 // map[ctxIdx][cabacInitIdc]MN
+//
+// Deprecated: use ContextMN.
 func CodedblockPatternMN(ctxIdx, cabacInitIdc int, sliceType string) MN {
 	var mn MN
 	if sliceType != "I" && sliceType != "SI" {
@@ -0,0 +1,368 @@
+/*
+NAME
+  mvpred.go
+
+DESCRIPTION
+  mvpred.go provides the derivation of luma motion vectors from decoded motion
+  vector differences and the motion vectors of neighbouring partitions, and of
+  the motion of P_Skip macroblocks and of partitions predicted in spatial or
+  temporal direct mode, as specified in section 8.4.1 of the specifications.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// mvNeighbour holds the motion vector mvLXN and reference index refIdxLXN of
+// a partition neighbouring the current partition, as derived by 8.4.1.3.2,
+// and whether the partition is available. The motion vector is 0 and the
+// reference index -1 for partitions not available, of intra macroblocks, or
+// not predicted from the list.
+type mvNeighbour struct {
+	mv        motionVector
+	refIdx    int
+	available bool
+}
+
+// mvNeighbour returns the motion data, for list 0 or 1, of the partition
+// covering the luma location (xN, yN) relative to the macroblock with address
+// currMbAddr, as specified by 8.4.1.3.2. In an MBAFF frame the motion vector
+// and reference index of a frame macroblock neighbouring a field macroblock,
+// or the reverse, are scaled to the units of the current macroblock.
+func (s *mbState) mvNeighbour(currMbAddr, list, xN, yN int) mvNeighbour {
+	mbAddrN, xW, yW := s.neighbourLocation(currMbAddr, xN, yN, 16, 16)
+	if mbAddrN == MbAddrNotAvailable {
+		return mvNeighbour{refIdx: -1}
+	}
+	n := mvNeighbour{refIdx: -1, available: true}
+	refIdx := s.refIdx[list][mbAddrN*partitionsPerMb+luma8x8BlkIdx(xW, yW)]
+	if s.has(mbAddrN, mbIntraCoded) || refIdx < 0 {
+		return n
+	}
+	n.mv = s.mv[list][mbAddrN*blocksPerMb+luma4x4BlkIdx(xW, yW)]
+	n.refIdx = int(refIdx)
+	if s.mbaff {
+		switch currField, nField := s.has(currMbAddr, mbFieldDecoded), s.has(mbAddrN, mbFieldDecoded); {
+		case currField && !nField:
+			n.mv.Y /= 2
+			n.refIdx *= 2
+		case !currField && nField:
+			n.mv.Y *= 2
+			n.refIdx /= 2
+		}
+	}
+	return n
+}
+
+// mvNeighbours returns the motion data, for list 0 or 1, of the partitions A,
+// B and C neighbouring the partition or sub-macroblock partition of the
+// macroblock with address currMbAddr whose top left luma sample is at (x, y)
+// relative to the macroblock, w being predPartWidth, as specified by 6.4.11.7
+// and 8.4.1.3.2. Partition C is that above right of the partition or, if it
+// is not available or not yet decoded, partition D above left. Partitions of
+// the current macroblock are decoded in the order of mbPartIdx, so C is not
+// yet decoded if it lies in a later 8x8 partition.
+func (s *mbState) mvNeighbours(currMbAddr, list, x, y, w int) (a, b, c mvNeighbour) {
+	a = s.mvNeighbour(currMbAddr, list, x-1, y)
+	b = s.mvNeighbour(currMbAddr, list, x, y-1)
+	if y > 0 && x+w < 16 && luma8x8BlkIdx(x+w, y-1) > luma8x8BlkIdx(x, y) {
+		return a, b, s.mvNeighbour(currMbAddr, list, x-1, y-1)
+	}
+	c = s.mvNeighbour(currMbAddr, list, x+w, y-1)
+	if !c.available {
+		c = s.mvNeighbour(currMbAddr, list, x-1, y-1)
+	}
+	return a, b, c
+}
+
+// mvp returns the luma motion vector prediction mvpLX, for list 0 or 1, of
+// the partition or sub-macroblock partition of the macroblock with address
+// currMbAddr whose top left luma sample is at (x, y) relative to the
+// macroblock, with width w and height h and reference index refIdx, as
+// specified by 8.4.1.3. 16x8 and 8x16 macroblock partitions are predicted
+// directionally from a single neighbour with the same reference index, and
+// otherwise the prediction is the median of those of A, B and C (8.4.1.3.1).
+func (s *mbState) mvp(currMbAddr, list, x, y, w, h, refIdx int) motionVector {
+	a, b, c := s.mvNeighbours(currMbAddr, list, x, y, w)
+	switch {
+	case w == 16 && h == 8 && y == 0 && b.refIdx == refIdx:
+		return b.mv
+	case w == 16 && h == 8 && y == 8 && a.refIdx == refIdx:
+		return a.mv
+	case w == 8 && h == 16 && x == 0 && a.refIdx == refIdx:
+		return a.mv
+	case w == 8 && h == 16 && x == 8 && c.refIdx == refIdx:
+		return c.mv
+	}
+
+	if !b.available && !c.available && a.available {
+		b, c = a, a
+	}
+	switch {
+	case a.refIdx == refIdx && b.refIdx != refIdx && c.refIdx != refIdx:
+		return a.mv
+	case a.refIdx != refIdx && b.refIdx == refIdx && c.refIdx != refIdx:
+		return b.mv
+	case a.refIdx != refIdx && b.refIdx != refIdx && c.refIdx == refIdx:
+		return c.mv
+	}
+	return motionVector{
+		X: int16(median(int(a.mv.X), int(b.mv.X), int(c.mv.X))),
+		Y: int16(median(int(a.mv.Y), int(b.mv.Y), int(c.mv.Y))),
+	}
+}
+
+// setPredictedMv derives the motion vector mvLX, for list 0 or 1, of the
+// partition or sub-macroblock partition of the macroblock with address
+// currMbAddr whose top left luma sample is at (x, y) relative to the
+// macroblock, with the given width and height, as the sum of mvpLX and its
+// decoded mvd_lX (8.4.1), recording it in mbs for each 4x4 block of the
+// partition. The refIdxLX of the partition must be recorded in mbs.
+func (s *mbState) setPredictedMv(currMbAddr, list, x, y, w, h int, mvd motionVector) {
+	refIdx := int(s.refIdx[list][currMbAddr*partitionsPerMb+luma8x8BlkIdx(x, y)])
+	mvp := s.mvp(currMbAddr, list, x, y, w, h, refIdx)
+	s.setMv(currMbAddr, list, x, y, w, h, motionVector{X: mvp.X + mvd.X, Y: mvp.Y + mvd.Y})
+}
+
+// setSkipMotion derives the reference indices and motion vectors of the
+// skipped macroblock with address currMbAddr of a slice of the given type,
+// recording them in mbs. A P_Skip macroblock is predicted from reference
+// index 0 of list 0 with a motion vector of 0 if either neighbour A or B is
+// not available or has reference index 0 and a motion vector of 0, and
+// otherwise mvpL0 (8.4.1.1). A B_Skip macroblock is predicted in direct
+// mode, see setDirectMotion.
+func (s *mbState) setSkipMotion(currMbAddr int, sliceType string, d *directPred) error {
+	if sliceType == "B" {
+		return s.setDirectMotion(currMbAddr, 0, 0, 16, d)
+	}
+	s.setRefIdx(currMbAddr, 0, 0, 0, 16, 16, 0)
+	a := s.mvNeighbour(currMbAddr, 0, -1, 0)
+	b := s.mvNeighbour(currMbAddr, 0, 0, -1)
+	var mv motionVector
+	switch {
+	case !a.available || !b.available:
+	case a.refIdx == 0 && a.mv == motionVector{}:
+	case b.refIdx == 0 && b.mv == motionVector{}:
+	default:
+		mv = s.mvp(currMbAddr, 0, 0, 0, 16, 16, 0)
+	}
+	s.setMv(currMbAddr, 0, 0, 0, 16, 16, mv)
+	return nil
+}
+
+// directPred holds what the derivation of the motion of partitions predicted
+// in direct mode needs beyond that of their neighbours (8.4.1.2): whether
+// the mode is spatial or temporal, direct_8x8_inference_flag, the
+// PicOrderCnt of the current picture and the reference picture lists of its
+// slice, RefPicList1[0] of which is the co-located picture. err is why the
+// motion cannot be derived, if it cannot.
+type directPred struct {
+	spatial   bool
+	inference bool
+	poc       int
+	lists     *refPicLists
+	err       error
+}
+
+// newDirectPred returns the directPred of the slice of ctx, with reference
+// picture lists l. Only frame macroblocks of frames that are not MBAFF are
+// supported.
+func newDirectPred(ctx *SliceContext, l *refPicLists) directPred {
+	d := directPred{
+		spatial:   ctx.Slice.Header.DirectSpatialMvPred,
+		inference: ctx.SPS.Direct8x8Inference,
+		poc:       ctx.arena.poc,
+		lists:     l,
+		err:       l.err,
+	}
+	switch {
+	case d.err != nil:
+	case MbaffFrameFlag(ctx.SPS, ctx.Slice.Header) == 1 || ctx.Slice.Header.FieldPic:
+		d.err = errFieldDirect
+	case len(l.list[1]) == 0:
+		d.err = errNoColocated
+	}
+	return d
+}
+
+// setDirectMotion derives the reference indices and motion vectors of the
+// partitions of the macroblock with address currMbAddr predicted in direct
+// mode, being the whole macroblock, or the 8x8 partition, of size w, whose
+// top left luma sample is at (x, y), recording them in mbs. The motion of
+// each 4x4 block, or each 8x8 partition if direct_8x8_inference_flag is set,
+// depends on that of the co-located block of the co-located picture, see
+// colocated, and is derived in spatial (8.4.1.2.2) or temporal (8.4.1.2.3)
+// direct mode as given by d. An error is returned if the motion cannot be
+// derived, see newDirectPred.
+func (s *mbState) setDirectMotion(currMbAddr, x, y, w int, d *directPred) error {
+	if d.err != nil {
+		return fmt.Errorf("could not derive direct mode motion: %w", d.err)
+	}
+	if d.spatial {
+		s.setSpatialDirectMotion(currMbAddr, x, y, w, d)
+		return nil
+	}
+	return s.setTemporalDirectMotion(currMbAddr, x, y, w, d)
+}
+
+// setSpatialDirectMotion derives the motion of partitions predicted in
+// spatial direct mode (8.4.1.2.2), see setDirectMotion. The reference index
+// for each list is the least non-negative of those of the neighbours A, B and
+// C of the macroblock, with motion vector mvpLX, each being 0 if neither list
+// has one. A motion vector is also 0 where its reference index is 0 and
+// colZeroFlag is set, the co-located block having reference index 0 and a
+// motion vector with components within one quarter sample of 0.
+func (s *mbState) setSpatialDirectMotion(currMbAddr, x, y, w int, d *directPred) {
+	var refIdx [2]int
+	for list := range refIdx {
+		a, b, c := s.mvNeighbours(currMbAddr, list, 0, 0, 16)
+		refIdx[list] = minPositive(a.refIdx, minPositive(b.refIdx, c.refIdx))
+	}
+	zero := refIdx[0] < 0 && refIdx[1] < 0 // directZeroPredictionFlag.
+	size := d.blockSize()
+	for list, r := range refIdx {
+		if zero {
+			r = 0
+		}
+		var mvp motionVector
+		if !zero && r >= 0 {
+			mvp = s.mvp(currMbAddr, list, 0, 0, 16, 16, r)
+		}
+		s.setRefIdx(currMbAddr, list, x, y, w, w, r)
+		for yb := y; yb < y+w; yb += size {
+			for xb := x; xb < x+w; xb += size {
+				mv := mvp
+				if r == 0 {
+					mvCol, refIdxCol, _ := d.colocated(currMbAddr, xb, yb)
+					if refIdxCol == 0 && abs(int(mvCol.X)) <= 1 && abs(int(mvCol.Y)) <= 1 {
+						mv = motionVector{}
+					}
+				}
+				s.setMv(currMbAddr, list, xb, yb, size, size, mv)
+			}
+		}
+	}
+}
+
+// setTemporalDirectMotion derives the motion of partitions predicted in
+// temporal direct mode (8.4.1.2.3), see setDirectMotion. refIdxL0 is the
+// least reference index of list 0 referring to the frame referred to by the
+// co-located block, or 0 if it is intra, and refIdxL1 is 0. The motion
+// vectors are those of the co-located block, scaled by the distance in
+// picture order of the current picture from the reference pictures.
+func (s *mbState) setTemporalDirectMotion(currMbAddr, x, y, w int, d *directPred) error {
+	size := d.blockSize()
+	for yb := y; yb < y+w; yb += size {
+		for xb := x; xb < x+w; xb += size {
+			mvCol, refIdxCol, refPOC := d.colocated(currMbAddr, xb, yb)
+			refIdxL0 := 0
+			if refIdxCol >= 0 {
+				refIdxL0 = d.mapColToList0(refPOC)
+			}
+			if refIdxL0 < 0 || refIdxL0 >= len(d.lists.list[0]) {
+				return errColocatedRef
+			}
+			poc0, poc1 := d.lists.list[0][refIdxL0].poc, d.lists.list[1][0].poc
+			mvL0, mvL1 := mvCol, motionVector{}
+			if poc1 != poc0 {
+				tb := Clip3(-128, 127, d.poc-poc0)
+				td := Clip3(-128, 127, poc1-poc0)
+				tx := (16384 + abs(td/2)) / td
+				scale := Clip3(-1024, 1023, (tb*tx+32)>>6)
+				mvL0 = motionVector{
+					X: int16((scale*int(mvCol.X) + 128) >> 8),
+					Y: int16((scale*int(mvCol.Y) + 128) >> 8),
+				}
+				mvL1 = motionVector{X: mvL0.X - mvCol.X, Y: mvL0.Y - mvCol.Y}
+			}
+			s.setRefIdx(currMbAddr, 0, xb, yb, size, size, refIdxL0)
+			s.setRefIdx(currMbAddr, 1, xb, yb, size, size, 0)
+			s.setMv(currMbAddr, 0, xb, yb, size, size, mvL0)
+			s.setMv(currMbAddr, 1, xb, yb, size, size, mvL1)
+		}
+	}
+	return nil
+}
+
+// blockSize returns the size of the blocks for which the motion of
+// partitions predicted in direct mode is derived, being 8 if
+// direct_8x8_inference_flag is set, and otherwise 4.
+func (d *directPred) blockSize() int {
+	if d.inference {
+		return 8
+	}
+	return 4
+}
+
+// colocated returns the motion vector mvCol and reference index refIdxCol of
+// the block co-located with the block of the macroblock with address mbAddr
+// whose top left luma sample is at (x, y) (8.4.1.2.1), with the PicOrderCnt
+// of the frame it refers to. If direct_8x8_inference_flag is set the
+// co-located block is the corner 4x4 block of the 8x8 partition holding
+// (x, y). The motion of list 0 is taken unless the co-located block is not
+// predicted from it, refIdxCol being -1 and mvCol 0 if it is intra.
+func (d *directPred) colocated(mbAddr, x, y int) (mvCol motionVector, refIdxCol, refPOC int) {
+	if d.inference {
+		x, y = x/8*12, y/8*12
+	}
+	col := d.lists.list[1][0]
+	part := mbAddr*partitionsPerMb + luma8x8BlkIdx(x, y)
+	list := 0
+	if col.refIdx[0][part] < 0 {
+		list = 1
+	}
+	return col.mv[list][mbAddr*blocksPerMb+luma4x4BlkIdx(x, y)], int(col.refIdx[list][part]), col.refPOC[list][part]
+}
+
+// mapColToList0 returns the least reference index of list 0 referring to the
+// frame with PicOrderCnt poc, or -1 if there is none.
+func (d *directPred) mapColToList0(poc int) int {
+	for i, f := range d.lists.list[0] {
+		if poc != unknownPOC && f.poc == poc {
+			return i
+		}
+	}
+	return -1
+}
+
+// minPositive returns the lesser of x and y if both are non-negative, and
+// otherwise the greater (8-184).
+func minPositive(x, y int) int {
+	if x >= 0 && y >= 0 {
+		if x < y {
+			return x
+		}
+		return y
+	}
+	if x > y {
+		return x
+	}
+	return y
+}
+
+// median returns the median of x, y and z (5-10).
+func median(x, y, z int) int {
+	lo, hi := x, x
+	for _, v := range [2]int{y, z} {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	return x + y + z - lo - hi
+}
+
+// Errors for motion that cannot be derived in direct mode.
+var (
+	errFieldDirect  = errors.New("direct mode prediction of fields not supported")
+	errNoColocated  = errors.New("no co-located picture, RefPicList1 is empty")
+	errColocatedRef = errors.New("reference frame of co-located block not in RefPicList0")
+)
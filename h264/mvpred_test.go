@@ -0,0 +1,393 @@
+/*
+NAME
+  mvpred_test.go
+
+DESCRIPTION
+  mvpred_test.go provides testing for functionality provided in mvpred.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"testing"
+)
+
+// mbMotion gives the reference index and motion vector, for list 0, of all
+// partitions of a macroblock.
+type mbMotion struct {
+	refIdx int
+	mv     motionVector
+}
+
+// motionState returns an mbState as for ctxIncState with the given
+// macroblocks begun, each having the given motion for list 0, or being intra
+// coded for refIdx -2.
+func motionState(motion map[int]mbMotion) *mbState {
+	var mbAddrs []int
+	for mbAddr := range motion {
+		mbAddrs = append(mbAddrs, mbAddr)
+	}
+	mbs := ctxIncState(func(mbAddr int) mbFlags {
+		if m, ok := motion[mbAddr]; ok && m.refIdx == -2 {
+			return mbIntraCoded
+		}
+		return 0
+	}, mbAddrs...)
+	for mbAddr, m := range motion {
+		if m.refIdx >= 0 {
+			mbs.setRefIdx(mbAddr, 0, 0, 0, 16, 16, m.refIdx)
+			mbs.setMv(mbAddr, 0, 0, 0, 16, 16, m.mv)
+		}
+	}
+	return mbs
+}
+
+func TestMvp(t *testing.T) {
+	tests := []struct {
+		motion     map[int]mbMotion
+		x, y, w, h int
+		refIdx     int
+		want       motionVector
+	}{
+		// Median of A, B and C.
+		{
+			motion: map[int]mbMotion{
+				ctxIncMbA: {0, motionVector{1, 5}},
+				ctxIncMbB: {0, motionVector{3, 2}},
+				ctxIncMbC: {0, motionVector{2, 9}},
+			},
+			w: 16, h: 16, want: motionVector{2, 5},
+		},
+
+		// Only A has the same reference index.
+		{
+			motion: map[int]mbMotion{
+				ctxIncMbA: {0, motionVector{4, 4}},
+				ctxIncMbB: {1, motionVector{3, 2}},
+				ctxIncMbC: {1, motionVector{2, 9}},
+			},
+			w: 16, h: 16, want: motionVector{4, 4},
+		},
+
+		// Only A available, whatever its reference index.
+		{
+			motion: map[int]mbMotion{ctxIncMbA: {0, motionVector{-4, 7}}},
+			w:      16, h: 16, refIdx: 1, want: motionVector{-4, 7},
+		},
+
+		// C not available, replaced by D.
+		{
+			motion: map[int]mbMotion{
+				ctxIncMbA: {0, motionVector{1, 1}},
+				ctxIncMbB: {0, motionVector{10, 10}},
+				ctxIncMbD: {0, motionVector{5, -5}},
+			},
+			w: 16, h: 16, want: motionVector{5, 1},
+		},
+
+		// Intra neighbours have no reference index and a motion vector of 0.
+		{
+			motion: map[int]mbMotion{
+				ctxIncMbA: {-2, motionVector{}},
+				ctxIncMbB: {0, motionVector{3, 3}},
+				ctxIncMbC: {1, motionVector{6, 6}},
+			},
+			w: 16, h: 16, want: motionVector{3, 3},
+		},
+
+		// Directional prediction of 16x8 and 8x16 partitions.
+		{
+			motion: map[int]mbMotion{
+				ctxIncMbA: {0, motionVector{1, 1}},
+				ctxIncMbB: {0, motionVector{8, 8}},
+				ctxIncMbC: {0, motionVector{2, 2}},
+			},
+			w: 16, h: 8, want: motionVector{8, 8},
+		},
+		{
+			motion: map[int]mbMotion{
+				ctxIncMbA: {0, motionVector{1, 1}},
+				ctxIncMbB: {0, motionVector{8, 8}},
+				ctxIncMbC: {0, motionVector{2, 2}},
+			},
+			w: 8, h: 16, want: motionVector{1, 1},
+		},
+		{
+			motion: map[int]mbMotion{
+				ctxIncMbA: {1, motionVector{1, 1}},
+				ctxIncMbB: {1, motionVector{8, 8}},
+				ctxIncMbC: {0, motionVector{2, 2}},
+			},
+			x: 8, w: 8, h: 16, want: motionVector{2, 2},
+		},
+	}
+
+	for i, test := range tests {
+		mbs := motionState(test.motion)
+		got := mbs.mvp(ctxIncMbCurr, 0, test.x, test.y, test.w, test.h, test.refIdx)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestSetPredictedMv checks the prediction of the last 4x4 sub-macroblock
+// partition of 8x8 partition 0, whose partition C lies in partition 1, not
+// yet decoded, so D is used.
+func TestSetPredictedMv(t *testing.T) {
+	mbs := motionState(nil)
+	mbs.setRefIdx(ctxIncMbCurr, 0, 0, 0, 16, 16, 0)
+	for blkIdx, mv := range []motionVector{{1, 1}, {2, 2}, {3, 3}, {}, {9, 9}} {
+		mbs.mv[0][ctxIncMbCurr*blocksPerMb+blkIdx] = mv
+	}
+
+	mbs.setPredictedMv(ctxIncMbCurr, 0, 4, 4, 4, 4, motionVector{X: 1, Y: -1})
+	want := motionVector{3, 1}
+	if got := mbs.mv[0][ctxIncMbCurr*blocksPerMb+3]; got != want {
+		t.Errorf("did not get expected result\nGot: %v\nWant: %v", got, want)
+	}
+}
+
+func TestSetSkipMotion(t *testing.T) {
+	tests := []struct {
+		motion map[int]mbMotion
+		want   motionVector
+	}{
+		// B not available.
+		{motion: map[int]mbMotion{ctxIncMbA: {0, motionVector{4, 4}}}},
+
+		// A with reference index 0 and a motion vector of 0.
+		{
+			motion: map[int]mbMotion{
+				ctxIncMbA: {0, motionVector{}},
+				ctxIncMbB: {0, motionVector{4, 4}},
+				ctxIncMbC: {0, motionVector{4, 4}},
+			},
+		},
+
+		// A zero motion vector with another reference index is predicted.
+		{
+			motion: map[int]mbMotion{
+				ctxIncMbA: {1, motionVector{}},
+				ctxIncMbB: {0, motionVector{4, 4}},
+				ctxIncMbC: {0, motionVector{6, 2}},
+			},
+			want: motionVector{4, 2},
+		},
+	}
+
+	for i, test := range tests {
+		mbs := motionState(test.motion)
+		mbs.setSkipMotion(ctxIncMbCurr, "P", nil)
+		if got := mbs.refIdx[0][ctxIncMbCurr*partitionsPerMb]; got != 0 {
+			t.Errorf("did not get expected refIdxL0 for test: %d\nGot: %v\nWant: 0", i, got)
+		}
+		for blkIdx := 0; blkIdx < blocksPerMb; blkIdx++ {
+			if got := mbs.mv[0][ctxIncMbCurr*blocksPerMb+blkIdx]; got != test.want {
+				t.Errorf("did not get expected result for test: %d, block: %d\nGot: %v\nWant: %v", i, blkIdx, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+// colMotion gives the motion of all blocks of all macroblocks of a
+// co-located picture: the reference index, motion vector and PicOrderCnt of
+// the frame referred to for the given list, the other list having reference
+// index -1.
+type colMotion struct {
+	list   int
+	refIdx int8
+	mv     motionVector
+	refPOC int
+}
+
+// colFrame returns a reference frame of n macroblocks with PicOrderCnt poc
+// and motion m.
+func colFrame(n, poc int, m colMotion) *refFrame {
+	f := &refFrame{poc: poc}
+	for list := range f.mv {
+		f.mv[list] = make([]motionVector, n*blocksPerMb)
+		f.refIdx[list] = make([]int8, n*partitionsPerMb)
+		f.refPOC[list] = make([]int, n*partitionsPerMb)
+		for i := range f.refIdx[list] {
+			f.refIdx[list][i], f.refPOC[list][i] = -1, unknownPOC
+			if list == m.list && m.refIdx >= 0 {
+				f.refIdx[list][i], f.refPOC[list][i] = m.refIdx, m.refPOC
+			}
+		}
+		for i := range f.mv[list] {
+			if list == m.list {
+				f.mv[list][i] = m.mv
+			}
+		}
+	}
+	return f
+}
+
+func TestSetDirectMotion(t *testing.T) {
+	// Spatial direct neighbours giving refIdxL0 0 with mvpL0 (4, 4).
+	neighbours := map[int]mbMotion{
+		ctxIncMbA: {1, motionVector{2, 2}},
+		ctxIncMbB: {0, motionVector{4, 4}},
+	}
+	tests := []struct {
+		spatial    bool
+		motion     map[int]mbMotion
+		col        colMotion
+		err        error // Of the directPred.
+		wantRefIdx [2]int8
+		wantMv     [2]motionVector
+		wantErr    error
+	}{
+		// Spatial: the least reference index of list 0, and none of list 1,
+		// the co-located motion vector being too large for colZeroFlag.
+		{
+			spatial:    true,
+			motion:     neighbours,
+			col:        colMotion{refIdx: 0, mv: motionVector{4, -4}},
+			wantRefIdx: [2]int8{0, -1},
+			wantMv:     [2]motionVector{{4, 4}, {}},
+		},
+
+		// Spatial with colZeroFlag set, giving a motion vector of 0.
+		{
+			spatial:    true,
+			motion:     neighbours,
+			col:        colMotion{refIdx: 0, mv: motionVector{1, -1}},
+			wantRefIdx: [2]int8{0, -1},
+		},
+
+		// Spatial with colZeroFlag set by list 1 of the co-located block.
+		{
+			spatial:    true,
+			motion:     neighbours,
+			col:        colMotion{list: 1, refIdx: 0, mv: motionVector{-1, 0}},
+			wantRefIdx: [2]int8{0, -1},
+		},
+
+		// Spatial with a co-located reference index other than 0.
+		{
+			spatial:    true,
+			motion:     neighbours,
+			col:        colMotion{refIdx: 1, mv: motionVector{1, 1}},
+			wantRefIdx: [2]int8{0, -1},
+			wantMv:     [2]motionVector{{4, 4}, {}},
+		},
+
+		// Spatial with no reference indices of neighbours, giving
+		// directZeroPredictionFlag.
+		{
+			spatial:    true,
+			motion:     map[int]mbMotion{ctxIncMbA: {-2, motionVector{}}},
+			col:        colMotion{refIdx: 0, mv: motionVector{8, 8}},
+			wantRefIdx: [2]int8{0, 0},
+		},
+
+		// Temporal: the co-located motion vector (8, -4), referring to the
+		// frame with PicOrderCnt 0, scaled for the current picture with
+		// PicOrderCnt 4 between it and the co-located picture with 8.
+		{
+			col:        colMotion{refIdx: 0, mv: motionVector{8, -4}, refPOC: 0},
+			wantRefIdx: [2]int8{1, 0},
+			wantMv:     [2]motionVector{{4, -2}, {-4, 2}},
+		},
+
+		// Temporal with an intra co-located block.
+		{
+			col:        colMotion{refIdx: -1},
+			wantRefIdx: [2]int8{0, 0},
+		},
+
+		// Temporal with the co-located block referring to a frame not in
+		// RefPicList0.
+		{
+			col:     colMotion{refIdx: 0, mv: motionVector{8, -4}, refPOC: -8},
+			wantErr: errColocatedRef,
+		},
+
+		// Motion that cannot be derived.
+		{
+			spatial: true,
+			motion:  neighbours,
+			col:     colMotion{refIdx: 0, mv: motionVector{1, -1}},
+			err:     errListModification,
+			wantErr: errListModification,
+		},
+	}
+
+	for i, test := range tests {
+		mbs := motionState(test.motion)
+		lists := &refPicLists{list: [2][]*refFrame{
+			{{poc: 6}, {poc: 0}},
+			{colFrame(mbs.n, 8, test.col)},
+		}}
+		d := &directPred{spatial: test.spatial, poc: 4, lists: lists, err: test.err}
+		err := mbs.setDirectMotion(ctxIncMbCurr, 8, 8, 8, d)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.wantErr)
+		}
+		if test.wantErr != nil {
+			continue
+		}
+		for list := range test.wantRefIdx {
+			if got := mbs.refIdx[list][ctxIncMbCurr*partitionsPerMb+3]; got != test.wantRefIdx[list] {
+				t.Errorf("did not get expected refIdxL%d for test: %d\nGot: %v\nWant: %v", list, i, got, test.wantRefIdx[list])
+			}
+			for _, blkIdx := range []int{12, 15} {
+				if got := mbs.mv[list][ctxIncMbCurr*blocksPerMb+blkIdx]; got != test.wantMv[list] {
+					t.Errorf("did not get expected mvL%d of block %d for test: %d\nGot: %v\nWant: %v", list, blkIdx, i, got, test.wantMv[list])
+				}
+			}
+			if got := mbs.refIdx[list][ctxIncMbCurr*partitionsPerMb]; got != -1 {
+				t.Errorf("did not expect refIdxL%d of partition 0 for test: %d\nGot: %v", list, i, got)
+			}
+		}
+	}
+}
+
+func TestDirectInference(t *testing.T) {
+	// The co-located macroblock has colZeroFlag set only for the corner 4x4
+	// block of 8x8 partition 3, so with direct_8x8_inference_flag the whole
+	// partition has a motion vector of 0, and otherwise only that block.
+	mbs := motionState(map[int]mbMotion{ctxIncMbB: {0, motionVector{4, 4}}})
+	col := colFrame(mbs.n, 8, colMotion{refIdx: 0, mv: motionVector{4, 4}})
+	col.mv[0][ctxIncMbCurr*blocksPerMb+15] = motionVector{}
+	lists := &refPicLists{list: [2][]*refFrame{{{poc: 0}}, {col}}}
+	for _, inference := range []bool{false, true} {
+		d := &directPred{spatial: true, inference: inference, poc: 4, lists: lists}
+		err := mbs.setDirectMotion(ctxIncMbCurr, 8, 8, 8, d)
+		if err != nil {
+			t.Fatalf("did not expect error: %v", err)
+		}
+		for _, blkIdx := range []int{12, 13, 14, 15} {
+			want := motionVector{4, 4}
+			if inference || blkIdx == 15 {
+				want = motionVector{}
+			}
+			if got := mbs.mv[0][ctxIncMbCurr*blocksPerMb+blkIdx]; got != want {
+				t.Errorf("did not get expected mvL0 of block %d with inference %v\nGot: %v\nWant: %v", blkIdx, inference, got, want)
+			}
+		}
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct{ x, y, z, want int }{
+		{1, 2, 3, 2},
+		{3, 1, 2, 2},
+		{-5, 7, -5, -5},
+		{0, 0, 0, 0},
+	}
+
+	for i, test := range tests {
+		got := median(test.x, test.y, test.z)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
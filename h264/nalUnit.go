@@ -1,15 +1,18 @@
 package h264
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+
 	"github.com/ausocean/h264decode/h264/bits"
-	"github.com/pkg/errors"
 )
 
 type NalUnit struct {
 	NumBytes                     int
 	ForbiddenZeroBit             int
 	RefIdc                       int
-	Type                         int
+	Type                         NALType
 	SvcExtensionFlag             int
 	Avc3dExtensionFlag           int
 	IdrFlag                      int
@@ -31,7 +34,14 @@ type NalUnit struct {
 	ViewIdx                      int
 	DepthFlag                    int
 	EmulationPreventionThreeByte byte
+	Offset                       int64  // Stream byte offset of the first header byte, if read by an H264Reader.
+	raw                          []byte // The NAL unit as given to NewNalUnit.
 	rbsp                         []byte
+	epb                          []int // RBSP positions of removed emulation prevention bytes.
+
+	// Whether the NAL unit is the first VCL NAL unit of a primary coded
+	// picture, if detected by the H264Reader that read it, see startsPicture.
+	newPicture, detected bool
 }
 
 func NalUnitHeaderSvcExtension(nalUnit *NalUnit, br *bits.BitReader) error {
@@ -72,39 +82,48 @@ func NalUnitHeaderMvcExtension(nalUnit *NalUnit, br *bits.BitReader) error {
 	})
 }
 
+// RBSP returns the raw byte sequence payload of the NAL unit. Where the NAL
+// unit contained no emulation prevention bytes this shares storage with the
+// frame given to NewNalUnit, so it is only valid for as long as that is.
 func (n *NalUnit) RBSP() []byte {
 	return n.rbsp
 }
 
+// NewNalUnit parses the NAL unit contained in frame, which must not include
+// the start code prefix. The returned NalUnit may refer to frame's storage, see
+// RBSP.
 func NewNalUnit(frame []byte, numBytesInNal int) (*NalUnit, error) {
 	logger.Printf("debug: reading %d byte NAL\n", numBytesInNal)
 	nalUnit := NalUnit{
 		NumBytes:    numBytesInNal,
 		HeaderBytes: 1,
+		raw:         frame,
 	}
-	// TODO: pass in actual io.Reader to NewBitReader
-	br := bits.NewBitReader(nil)
+	br := bits.NewBitReader(bytes.NewReader(frame))
 
+	var nalUnitType int
 	err := readFields(br, []field{
 		{&nalUnit.ForbiddenZeroBit, "ForbiddenZeroBit", 1},
 		{&nalUnit.RefIdc, "NalRefIdc", 2},
-		{&nalUnit.Type, "NalUnitType", 5},
+		{&nalUnitType, "NalUnitType", 5},
 	})
 	if err != nil {
 		return nil, err
 	}
+	nalUnit.Type = NALType(nalUnitType)
 
-	if nalUnit.Type == 14 || nalUnit.Type == 20 || nalUnit.Type == 21 {
-		if nalUnit.Type != 21 {
+	switch nalUnit.Type {
+	case NALTypePrefixNALU, NALTypeSliceExtension, NALTypeSliceExtensionDepth:
+		if nalUnit.Type != NALTypeSliceExtensionDepth {
 			b, err := br.ReadBits(1)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read SvcExtensionFlag")
+				return nil, fmt.Errorf("could not read SvcExtensionFlag: %w", err)
 			}
 			nalUnit.SvcExtensionFlag = int(b)
 		} else {
 			b, err := br.ReadBits(1)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read Avc3dExtensionFlag")
+				return nil, fmt.Errorf("could not read Avc3dExtensionFlag: %w", err)
 			}
 			nalUnit.Avc3dExtensionFlag = int(b)
 		}
@@ -122,40 +141,83 @@ func NewNalUnit(frame []byte, numBytesInNal int) (*NalUnit, error) {
 	}
 
 	logger.Printf("debug: found %d byte header. Reading body\n", nalUnit.HeaderBytes)
-	for i := nalUnit.HeaderBytes; i < nalUnit.NumBytes; i++ {
-		next3Bytes, err := br.PeekBits(24)
-		if err != nil {
-			logger.Printf("error: while reading next 3 NAL bytes: %v\n", err)
-			break
-		}
-		// Little odd, the err above and the i+2 check might be synonyms
-		if i+2 < nalUnit.NumBytes && next3Bytes == 0x000003 {
-			for j := 0; j < 2; j++ {
-				rbspByte, err := br.ReadBits(8)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not read rbspByte")
-				}
-				nalUnit.rbsp = append(nalUnit.rbsp, byte(rbspByte))
-			}
-			i += 2
+	if nalUnit.HeaderBytes > len(frame) {
+		return nil, ErrShortNAL
+	}
+	nalUnit.rbsp, nalUnit.epb = removeEmulationPrevention(frame[nalUnit.HeaderBytes:])
+	if nalUnit.epb != nil {
+		nalUnit.EmulationPreventionThreeByte = emulationPreventionThreeByte
+	}
 
-			// Read Emulation prevention three byte.
-			eptByte, err := br.ReadBits(8)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not read eptByte")
-			}
-			nalUnit.EmulationPreventionThreeByte = byte(eptByte)
+	logger.Printf("info: decoded %s NAL with %d RBSP bytes\n", nalUnit.Type, len(nalUnit.rbsp))
+	return &nalUnit, nil
+}
+
+// Validate checks the NAL unit header against the constraints of section
+// 7.4.1, returning a descriptive error for the first violation found. It
+// checks that forbidden_zero_bit is 0, that nal_ref_idc is non-zero for
+// parameter sets and IDR slices and zero for NAL unit types where it must be,
+// and that nal_unit_type is not reserved. The returned error wraps
+// ErrInvalidNALHeader.
+func (n *NalUnit) Validate() error {
+	v := n.violations()
+	if len(v) == 0 {
+		return nil
+	}
+	return v[0]
+}
+
+// Errors returned for malformed NAL units.
+var (
+	// ErrShortNAL is returned when a NAL unit is shorter than its header.
+	ErrShortNAL = errors.New("NAL unit shorter than its header")
+
+	// ErrInvalidNALHeader is wrapped by the errors returned from Validate.
+	ErrInvalidNALHeader = errors.New("invalid NAL unit header")
+)
+
+// emulationPreventionThreeByte is inserted by encoders after two consecutive
+// zero bytes to prevent start code emulation within a NAL unit.
+const emulationPreventionThreeByte = 0x03
+
+// removeEmulationPrevention returns the RBSP contained in b, the bytes of a NAL
+// unit following its header, by removing any emulation_prevention_three_byte
+// (see section 7.4.1). If none are present the returned slice is b itself, so
+// no copy is made; otherwise a new slice is allocated. The positions in the
+// RBSP before which each emulation prevention byte was removed are also
+// returned, or nil if none were removed.
+func removeEmulationPrevention(b []byte) ([]byte, []int) {
+	if bytes.Index(b, []byte{0x00, 0x00, emulationPreventionThreeByte}) == -1 {
+		return b, nil
+	}
+	rbsp := make([]byte, 0, len(b))
+	var epb []int
+	var zeros int
+	for _, c := range b {
+		if zeros >= 2 && c == emulationPreventionThreeByte {
+			epb = append(epb, len(rbsp))
+			zeros = 0
+			continue
+		}
+		rbsp = append(rbsp, c)
+		if c == 0 {
+			zeros++
 		} else {
-			if b, err := br.ReadBits(8); err == nil {
-				nalUnit.rbsp = append(nalUnit.rbsp, byte(b))
-			} else {
-				logger.Printf("error: while reading byte %d of %d nal bytes: %v\n", i, nalUnit.NumBytes, err)
-				break
-			}
+			zeros = 0
 		}
 	}
+	return rbsp, epb
+}
 
-	// nalUnit.rbsp = frame[nalUnit.HeaderBytes:]
-	logger.Printf("info: decoded %s NAL with %d RBSP bytes\n", NALUnitType[nalUnit.Type], len(nalUnit.rbsp))
-	return &nalUnit, nil
+// nalByte returns the byte offset within the NAL unit, including its header
+// and any emulation prevention bytes, of byte i of the RBSP.
+func (n *NalUnit) nalByte(i int) int {
+	off := n.HeaderBytes + i
+	for _, p := range n.epb {
+		if p > i {
+			break
+		}
+		off++
+	}
+	return off
 }
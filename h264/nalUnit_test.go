@@ -0,0 +1,84 @@
+/*
+NAME
+  nalUnit_test.go
+
+DESCRIPTION
+  nalUnit_test.go provides testing for functionality provided in nalUnit.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestValidate checks that NalUnit.Validate rejects headers violating the
+// constraints of section 7.4.1 and accepts conforming ones.
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		header  byte
+		wantErr bool
+	}{
+		{header: 0x67},                       // SPS, nal_ref_idc 3.
+		{header: 0x65},                       // IDR, nal_ref_idc 3.
+		{header: 0x01},                       // Non-IDR, nal_ref_idc 0.
+		{header: 0x06},                       // SEI, nal_ref_idc 0.
+		{header: 0x09},                       // AUD, nal_ref_idc 0.
+		{header: 0x19},                       // Unspecified type 25.
+		{header: 0xe7, wantErr: true},        // forbidden_zero_bit set.
+		{header: 0x07, wantErr: true},        // SPS, nal_ref_idc 0.
+		{header: 0x08, wantErr: true},        // PPS, nal_ref_idc 0.
+		{header: 0x05, wantErr: true},        // IDR, nal_ref_idc 0.
+		{header: 0x26, wantErr: true},        // SEI, nal_ref_idc 1.
+		{header: 0x0c | 0x40, wantErr: true}, // Filler data, nal_ref_idc 2.
+		{header: 0x11, wantErr: true},        // Reserved type 17.
+		{header: 0x17, wantErr: true},        // Reserved type 23.
+	}
+
+	for i, test := range tests {
+		nalUnit, err := NewNalUnit([]byte{test.header, 0x80}, 2)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewNalUnit for test: %d", err, i)
+		}
+		err = nalUnit.Validate()
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidNALHeader) {
+			t.Errorf("error does not wrap ErrInvalidNALHeader for test: %d\nGot: %v", i, err)
+		}
+	}
+}
+
+// TestStartStrict checks that strict mode causes Start to return an error for
+// an invalid NAL unit that is otherwise tolerated.
+func TestStartStrict(t *testing.T) {
+	badSPS := append([]byte{testSPS[0] &^ 0x60}, testSPS[1:]...)
+	stream := annexB(badSPS, testPPS)
+
+	r, err := NewH264Reader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	if err := r.Start(); err != nil {
+		t.Errorf("did not expect error: %v from Start without strict mode", err)
+	}
+
+	r, err = NewH264Reader(bytes.NewReader(stream), WithStrict())
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = r.Start()
+	if !errors.Is(err, ErrInvalidNALHeader) {
+		t.Errorf("did not get expected error from Start with strict mode\nGot: %v\nWant: %v", err, ErrInvalidNALHeader)
+	}
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Type != NALTypeSPS {
+		t.Errorf("did not get ParseError for SPS from Start with strict mode\nGot: %#v", err)
+	}
+}
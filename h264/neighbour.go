@@ -0,0 +1,103 @@
+/*
+NAME
+  neighbour.go
+
+DESCRIPTION
+  neighbour.go provides the derivation of the macroblocks and blocks
+  neighbouring a macroblock or block, and of their availability, as specified
+  in section 6.4 of the specifications, shared by Intra prediction, the nC of
+  CAVLC coeff_token and the ctxIdxInc of CABAC syntax elements.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+// neighbourBlk identifies a neighbouring block by the address of the
+// macroblock containing it, or MbAddrNotAvailable if that is not available,
+// and its index within that macroblock.
+type neighbourBlk struct {
+	mbAddr, blkIdx int
+}
+
+// mbNeighboursAB returns the addresses of the macroblocks to the left of and
+// above the macroblock with address currMbAddr, or MbAddrNotAvailable for
+// those not available, as specified by 6.4.11.1.
+func (s *mbState) mbNeighboursAB(currMbAddr int) (mbAddrA, mbAddrB int) {
+	mbAddrA, _, _ = s.neighbourLocation(currMbAddr, -1, 0, 16, 16)
+	mbAddrB, _, _ = s.neighbourLocation(currMbAddr, 0, -1, 16, 16)
+	return mbAddrA, mbAddrB
+}
+
+// neighbour8x8Blks returns the 8x8 luma blocks to the left of and above the
+// 8x8 luma block with index blkIdx of the macroblock with address
+// currMbAddr, as specified by 6.4.11.2.
+func (s *mbState) neighbour8x8Blks(currMbAddr, blkIdx int) (a, b neighbourBlk) {
+	x, y := 8*(blkIdx%2), 8*(blkIdx/2)
+	blk := func(xN, yN int) neighbourBlk {
+		mbAddrN, xW, yW := s.neighbourLocation(currMbAddr, xN, yN, 16, 16)
+		return neighbourBlk{mbAddrN, luma8x8BlkIdx(xW, yW)}
+	}
+	return blk(x-1, y), blk(x, y-1)
+}
+
+// neighbour4x4Blks returns the 4x4 blocks to the left of and above the 4x4
+// block whose top left sample is at (x, y) relative to the macroblock with
+// address currMbAddr. For luma blocks, and Cb and Cr blocks when
+// ChromaArrayType is 3, maxW and maxH are 16 and the indices are
+// luma4x4BlkIdx, as specified by 6.4.11.4, and for chroma blocks otherwise
+// they are MbWidthC and MbHeightC and the indices are chroma4x4BlkIdx, as
+// specified by 6.4.11.5.
+func (s *mbState) neighbour4x4Blks(currMbAddr, x, y, maxW, maxH int) (a, b neighbourBlk) {
+	blk := func(xN, yN int) neighbourBlk {
+		mbAddrN, xW, yW := s.neighbourLocation(currMbAddr, xN, yN, maxW, maxH)
+		if maxW != 16 {
+			return neighbourBlk{mbAddrN, chroma4x4BlkIdx(xW, yW)}
+		}
+		return neighbourBlk{mbAddrN, luma4x4BlkIdx(xW, yW)}
+	}
+	return blk(x-1, y), blk(x, y-1)
+}
+
+// intraAvailable returns true if the macroblock mbAddrN, as given by the
+// processes of section 6.4, may be referenced by the macroblock with address
+// currMbAddr, being available and, if constrainedIntra and the current
+// macroblock is intra coded, not an inter macroblock. For Intra prediction
+// constrainedIntra is constrained_intra_pred_flag, while for the nC of
+// coeff_token and coded_block_flag contexts it applies only to slice data
+// partitions (see 9.2.1 and 9.3.3.1.1.9).
+func (s *mbState) intraAvailable(mbAddrN, currMbAddr int, constrainedIntra bool) bool {
+	if mbAddrN == MbAddrNotAvailable {
+		return false
+	}
+	return !constrainedIntra || !s.has(currMbAddr, mbIntraCoded) || s.has(mbAddrN, mbIntraCoded)
+}
+
+// luma4x4BlkIdx returns the index of the 4x4 luma block covering the luma
+// location (x, y) relative to the top left of a macroblock, as specified in
+// section 6.4.13.1.
+func luma4x4BlkIdx(x, y int) int {
+	return 8*(y/8) + 4*(x/8) + 2*((y%8)/4) + (x%8)/4
+}
+
+// luma4x4BlkPos returns the location (x, y), relative to the top left of a
+// macroblock, of the top left sample of the 4x4 luma block with index
+// luma4x4BlkIdx, as specified in section 6.4.3.
+func luma4x4BlkPos(luma4x4BlkIdx int) (x, y int) {
+	return 8*(luma4x4BlkIdx/4%2) + 4*(luma4x4BlkIdx%2), 8*(luma4x4BlkIdx/8) + 4*(luma4x4BlkIdx%4/2)
+}
+
+// luma8x8BlkIdx returns the index of the 8x8 luma block, or macroblock
+// partition of a P_8x8 or B_8x8 macroblock, covering the luma location (x, y)
+// relative to the top left of a macroblock, as specified in section 6.4.13.3.
+func luma8x8BlkIdx(x, y int) int {
+	return 2*(y/8) + x/8
+}
+
+// chroma4x4BlkIdx returns the index of the 4x4 chroma block covering the
+// chroma location (x, y) relative to the top left of a macroblock, as
+// specified in section 6.4.13.2, for ChromaArrayType of 1 or 2.
+func chroma4x4BlkIdx(x, y int) int {
+	return 2*(y/4) + x/4
+}
@@ -0,0 +1,103 @@
+/*
+NAME
+  neighbour_test.go
+
+DESCRIPTION
+  neighbour_test.go provides testing for functionality provided in
+  neighbour.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+func TestNeighbour4x4Blks(t *testing.T) {
+	const na = MbAddrNotAvailable
+	tests := []struct {
+		mbAddrs    []int
+		x, y       int
+		maxW, maxH int
+		wantA      neighbourBlk
+		wantB      neighbourBlk
+	}{
+		// Luma blocks within the macroblock and of A and B.
+		{mbAddrs: []int{ctxIncMbA, ctxIncMbB}, x: 4, y: 4, maxW: 16, maxH: 16, wantA: neighbourBlk{ctxIncMbCurr, 2}, wantB: neighbourBlk{ctxIncMbCurr, 1}},
+		{mbAddrs: []int{ctxIncMbA, ctxIncMbB}, x: 0, y: 8, maxW: 16, maxH: 16, wantA: neighbourBlk{ctxIncMbA, 13}, wantB: neighbourBlk{ctxIncMbCurr, 2}},
+		{mbAddrs: []int{ctxIncMbA, ctxIncMbB}, x: 12, y: 0, maxW: 16, maxH: 16, wantA: neighbourBlk{ctxIncMbCurr, 4}, wantB: neighbourBlk{ctxIncMbB, 15}},
+
+		// Neighbouring macroblocks not decoded.
+		{x: 0, y: 0, maxW: 16, maxH: 16, wantA: neighbourBlk{na, 0}, wantB: neighbourBlk{na, 0}},
+
+		// 4:2:0 and 4:2:2 chroma blocks.
+		{mbAddrs: []int{ctxIncMbA, ctxIncMbB}, x: 0, y: 4, maxW: 8, maxH: 8, wantA: neighbourBlk{ctxIncMbA, 3}, wantB: neighbourBlk{ctxIncMbCurr, 0}},
+		{mbAddrs: []int{ctxIncMbA, ctxIncMbB}, x: 4, y: 0, maxW: 8, maxH: 16, wantA: neighbourBlk{ctxIncMbCurr, 0}, wantB: neighbourBlk{ctxIncMbB, 7}},
+	}
+
+	for i, test := range tests {
+		mbs := ctxIncState(noFlags, test.mbAddrs...)
+		a, b := mbs.neighbour4x4Blks(ctxIncMbCurr, test.x, test.y, test.maxW, test.maxH)
+		if a != test.wantA || b != test.wantB {
+			t.Errorf("did not get expected result for test: %d\nGot: %v, %v\nWant: %v, %v", i, a, b, test.wantA, test.wantB)
+		}
+	}
+}
+
+func TestNeighbour8x8Blks(t *testing.T) {
+	tests := []struct {
+		blkIdx int
+		wantA  neighbourBlk
+		wantB  neighbourBlk
+	}{
+		{0, neighbourBlk{ctxIncMbA, 1}, neighbourBlk{ctxIncMbB, 2}},
+		{1, neighbourBlk{ctxIncMbCurr, 0}, neighbourBlk{ctxIncMbB, 3}},
+		{2, neighbourBlk{ctxIncMbA, 3}, neighbourBlk{ctxIncMbCurr, 0}},
+		{3, neighbourBlk{ctxIncMbCurr, 2}, neighbourBlk{ctxIncMbCurr, 1}},
+	}
+
+	mbs := ctxIncState(noFlags, ctxIncMbA, ctxIncMbB)
+	for i, test := range tests {
+		a, b := mbs.neighbour8x8Blks(ctxIncMbCurr, test.blkIdx)
+		if a != test.wantA || b != test.wantB {
+			t.Errorf("did not get expected result for test: %d\nGot: %v, %v\nWant: %v, %v", i, a, b, test.wantA, test.wantB)
+		}
+	}
+}
+
+func TestIntraAvailable(t *testing.T) {
+	tests := []struct {
+		flagsN           mbFlags
+		flagsCurr        mbFlags
+		otherSlice       bool // Whether the neighbour is of a preceding slice.
+		constrainedIntra bool
+		want             bool
+	}{
+		{want: true},
+		{otherSlice: true, want: false},
+		{flagsCurr: mbIntraCoded, constrainedIntra: true, want: false},
+		{flagsCurr: mbIntraCoded, want: true},
+		{flagsN: mbIntraCoded, flagsCurr: mbIntraCoded, constrainedIntra: true, want: true},
+		{flagsN: mbIntraCoded, flagsCurr: mbIntraCoded, otherSlice: true, constrainedIntra: true, want: false},
+
+		// Inter neighbours remain available to inter macroblocks.
+		{constrainedIntra: true, want: true},
+	}
+
+	for i, test := range tests {
+		mbs := newMbState(3, 3)
+		sliceNum := mbs.startSlice()
+		mbs.beginMb(ctxIncMbB, sliceNum, test.flagsN)
+		if test.otherSlice {
+			sliceNum = mbs.startSlice()
+		}
+		mbs.beginMb(ctxIncMbCurr, sliceNum, test.flagsCurr)
+
+		mbAddrB, _, _ := mbs.neighbourLocation(ctxIncMbCurr, 0, -1, 16, 16)
+		got := mbs.intraAvailable(mbAddrB, ctxIncMbCurr, test.constrainedIntra)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+/*
+NAME
+  options.go
+
+DESCRIPTION
+  options.go provides functional options for configuring an H264Reader.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"time"
+)
+
+// Option is a functional option for configuring an H264Reader, for use with
+// NewH264Reader.
+type Option func(*H264Reader) error
+
+// WithMaxTemporalID is an option that limits decoding to temporal layers with
+// temporal_id less than or equal to id, e.g. to decode 15fps from a temporally
+// layered 30fps stream. See skipTemporalLayer for how temporal layers are
+// identified.
+func WithMaxTemporalID(id int) Option {
+	return func(h *H264Reader) error {
+		if id < 0 || id > maxTemporalID {
+			return errBadTemporalID
+		}
+		h.filterTemporal = true
+		h.maxTemporalID = id
+		return nil
+	}
+}
+
+// WithRecovery is an option that enables error recovery. When a NAL unit cannot
+// be decoded, for example due to bitstream corruption, it is discarded and
+// decoding continues from the next start code instead of stopping.
+func WithRecovery() Option {
+	return func(h *H264Reader) error {
+		h.recover = true
+		return nil
+	}
+}
+
+// WithStrict is an option that enables strict validation of NAL units, for
+// validating encoder output rather than tolerating it. NAL units that do not
+// conform (see NalUnit.Validate) are discarded if recovery is enabled.
+// Otherwise all violations of an access unit, including NAL units that fail to
+// decode and SPS exceeding the limits of their level (see SPS.CheckLevel), are
+// collected, and decoding stops at the end of the access unit with a
+// MultiError holding each as a ParseError. See also WithMaxViolations.
+func WithStrict() Option {
+	return func(h *H264Reader) error {
+		h.strict = true
+		return nil
+	}
+}
+
+// WithMaxViolations is an option that sets the maximum number of violations
+// collected for an access unit in strict mode, at which decoding stops without
+// waiting for the end of the access unit. The default is 32.
+func WithMaxViolations(n int) Option {
+	return func(h *H264Reader) error {
+		if n < 1 {
+			return errBadMaxViolations
+		}
+		h.maxViolations = n
+		return nil
+	}
+}
+
+// WithFullPicture is an option that selects output of the full decoded
+// picture, including padding macroblocks outside the conformance window, rather
+// than the default cropped picture. See OutputBounds.
+func WithFullPicture() Option {
+	return func(h *H264Reader) error {
+		h.fullPicture = true
+		return nil
+	}
+}
+
+// WithOutputLayout is an option that sets the layout of output frames, so
+// that they may be passed to DMA based consumers without repacking. Rows of
+// each plane are aligned to multiples of align bytes, which must be a power of
+// two no greater than 4096, and planes are given a border of padding luma
+// samples, up to 1024, on each side. See OutputLayout.
+func WithOutputLayout(align, padding int) Option {
+	return func(h *H264Reader) error {
+		if align < 1 || align > maxAlign || align&(align-1) != 0 {
+			return errBadAlign
+		}
+		if padding < 0 || padding > maxPadding {
+			return errBadPadding
+		}
+		h.outputAlign = align
+		h.outputPadding = padding
+		return nil
+	}
+}
+
+// WithReadTimeout is an option that sets a timeout for reads from the stream,
+// for use with live sources. If no data is received within the timeout an
+// EventStreamStalled event is emitted and ErrStreamStalled returned, giving
+// control back to the caller rather than blocking forever; reading may then be
+// resumed.
+func WithReadTimeout(d time.Duration) Option {
+	return func(h *H264Reader) error {
+		if d <= 0 {
+			return errBadTimeout
+		}
+		h.readTimeout = d
+		return nil
+	}
+}
+
+// WithEventHandler is an option that provides a function to be called with
+// events emitted while reading and decoding. The function is called
+// synchronously, so it should return promptly.
+func WithEventHandler(f func(Event)) Option {
+	return func(h *H264Reader) error {
+		h.eventHandler = f
+		return nil
+	}
+}
+
+// WithAUSizeLimit is an option that enables tracking of coded access unit
+// sizes, emitting an EventAUSizeExceeded event for each access unit larger
+// than multiple times its expected size. The expected size is derived from the
+// HRD bit rate and VUI frame rate of the SPS where these are present, and is
+// otherwise the running mean size of previous access units, kept separately
+// for IDR and non-IDR access units.
+func WithAUSizeLimit(multiple float64) Option {
+	return func(h *H264Reader) error {
+		if multiple <= 0 {
+			return errBadAUSizeMultiple
+		}
+		h.auSize = &auSizeMonitor{multiple: multiple}
+		return nil
+	}
+}
+
+// WithIntraRefresh is an option that allows decoding to be resumed at an
+// arbitrary point of a stream, e.g. after a seek, rather than only at an IDR
+// picture, as is needed for intra refresh streams, which may have none. Until
+// the parameter sets are received, NAL units that refer to them are skipped.
+// Slices are then flagged FrameRefreshing until a picture is reached from
+// which output is correct, being an IDR picture or the recovery point given by
+// the recovery_frame_cnt of a recovery point SEI message. EventRefreshComplete
+// is emitted once all slices of that picture have been received, i.e. at the
+// start of the next picture or the end of the stream. See Refreshed.
+func WithIntraRefresh() Option {
+	return func(h *H264Reader) error {
+		h.refresh = &refreshTracker{flags: FrameRefreshing}
+		return nil
+	}
+}
+
+// WithIntraParallelism is an option that enables concurrent decoding of intra
+// coded pictures, i.e. those made up of I and SI slices, using up to workers
+// goroutines. Such pictures do not refer to other pictures, so may be decoded
+// in any order; a picture with inter coded slices is decoded only once all
+// earlier pictures have been. Streams using an SPS for which IntraOnly is true,
+// or made up of all-I GOPs, are therefore decoded entirely in parallel. Decoded
+// slices are added to their VideoStream in decoding order regardless.
+func WithIntraParallelism(workers int) Option {
+	return func(h *H264Reader) error {
+		if workers < 1 {
+			return errBadWorkers
+		}
+		h.intra = &intraDecoder{workers: workers}
+		return nil
+	}
+}
+
+// WithSPS is an option that provides an SPS out of band, for sources such as
+// RTP streams described by SDP sprop-parameter-sets, or MP4 files with an avcC
+// box, whose streams may never carry parameter sets, so that they may be
+// decoded from the first slice. raw is the SPS NAL unit, including its header
+// and optionally preceded by a start code prefix. It is parsed once all
+// options have been applied and stored as though received at the start of the
+// stream, and so is replaced by any in-band SPS with the same ID.
+func WithSPS(raw []byte) Option {
+	return func(h *H264Reader) error {
+		h.outOfBand = append(h.outOfBand, outOfBandParameterSet{raw: raw, typ: NALTypeSPS})
+		return nil
+	}
+}
+
+// WithPPS is an option that provides a PPS out of band, as for WithSPS. As a
+// PPS is parsed using the SPS it refers to, that SPS must be given by a
+// preceding WithSPS option.
+func WithPPS(raw []byte) Option {
+	return func(h *H264Reader) error {
+		h.outOfBand = append(h.outOfBand, outOfBandParameterSet{raw: raw, typ: NALTypePPS})
+		return nil
+	}
+}
+
+// outOfBandParameterSet is a parameter set given by WithSPS or WithPPS.
+type outOfBandParameterSet struct {
+	raw []byte
+	typ NALType
+}
+
+// WithTimeline is an option that records the time spent in each stage of
+// decoding to t, for export as Chrome trace events using Timeline.WriteJSON.
+func WithTimeline(t *Timeline) Option {
+	return func(h *H264Reader) error {
+		h.timeline = t
+		return nil
+	}
+}
+
+// WithMaxDimensions is an option that sets the maximum width and height, in
+// luma samples, of the full decoded pictures of a stream. An SPS giving larger
+// pictures is rejected with an error wrapping ErrPictureTooLarge before any
+// picture buffers are allocated, guarding against corrupt or hostile streams.
+// By default the limits of the level of the SPS are used, see
+// ParameterSets.AddSPS.
+func WithMaxDimensions(width, height int) Option {
+	return func(h *H264Reader) error {
+		if width < 1 || height < 1 {
+			return errBadMaxDimensions
+		}
+		h.ParameterSets.maxWidth = width
+		h.ParameterSets.maxHeight = height
+		return nil
+	}
+}
+
+var errBadAlign = errors.New("alignment must be a power of two no greater than 4096")
+
+var errBadPadding = errors.New("padding must be in range 0 to 1024")
+
+var errBadMaxViolations = errors.New("maximum number of violations must be at least 1")
+
+var errBadWorkers = errors.New("number of workers must be at least 1")
+
+var errBadAUSizeMultiple = errors.New("access unit size multiple must be positive")
+
+var errBadTimeout = errors.New("timeout must be positive")
+
+var errBadMaxDimensions = errors.New("maximum dimensions must be positive")
+
+var errBadTemporalID = errors.New("temporal_id must be in range 0 to 7")
@@ -0,0 +1,256 @@
+/*
+NAME
+  parallel.go
+
+DESCRIPTION
+  parallel.go provides concurrent decoding of intra coded pictures, which
+  having no dependencies on other pictures may be decoded independently, so
+  that decoding of intra-only streams scales with the number of cores.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "fmt"
+
+// IntraOnly returns true if the coded video sequence using the SPS may contain
+// only intra coded pictures, either because its profile is an intra profile
+// or because max_num_ref_frames is 0, so that each of its pictures may be
+// decoded independently of the others.
+func (s *SPS) IntraOnly() bool {
+	return s.Profile().Intra() || s.MaxNumRefFrames == 0
+}
+
+// intraDecoder decodes pictures made up of intra coded slices concurrently,
+// see WithIntraParallelism. Results are collected in decoding order, so
+// slices are added to their VideoStream in the same order as when decoding
+// sequentially.
+type intraDecoder struct {
+	workers int           // Maximum number of pictures dispatched but not collected.
+	current *intraPicture // Picture whose slices are being gathered.
+	pending []*intraPicture
+	errs    []sliceError // Errors of collected pictures not yet reported.
+	arenas  []*arena     // Arenas of collected pictures, for reuse.
+
+	// Alignment and padding of the frames of new arenas, see
+	// WithOutputLayout.
+	align, padding int
+
+	// Reference frames, to which collected pictures are added in decoding
+	// order.
+	refs *refFrames
+
+	timeline   *Timeline // Stage timings, see WithTimeline.
+	dispatched int       // Number of pictures dispatched.
+}
+
+// intraPicture holds the intra coded slices of a picture to be decoded by a
+// single goroutine.
+type intraPicture struct {
+	slices   []*intraSlice
+	arena    *arena
+	done     chan struct{}
+	timeline *Timeline
+	lane     int // Timeline thread of the goroutine decoding the picture.
+}
+
+// intraSlice is a slice to be decoded concurrently and the results of doing
+// so.
+type intraSlice struct {
+	nalUnit     *NalUnit
+	videoStream *VideoStream // VideoStream to which the slice is added.
+	params      VideoStream  // SPS and PPS active for the slice.
+	flags       FrameFlags   // Flags to be set on the decoded slice.
+	order       PictureOrder // Order of the picture the slice belongs to.
+	ctx         *SliceContext
+	err         error
+}
+
+// decodeSlice decodes the slice NAL unit, adding it to videoStream with the
+// given flags set and picture order. Intra
+// coded slices are gathered into pictures for concurrent decoding, each using
+// its own arena; any other slice is decoded using a once all earlier pictures
+// have been, as it may refer to them.
+func (d *intraDecoder) decodeSlice(videoStream *VideoStream, nalUnit *NalUnit, a *arena, flags FrameFlags, order PictureOrder) error {
+	if !isIntraSlice(videoStream.SPS, nalUnit) {
+		d.wait()
+		return decodeSlice(videoStream, nalUnit, a, flags, order, nil)
+	}
+	if startsPicture(nalUnit) {
+		// The picture decoded sequentially in a precedes this one, so is
+		// added to the reference frames first.
+		finishPicture(a)
+	}
+	if d.current == nil || startsPicture(nalUnit) {
+		d.dispatch()
+		d.current = &intraPicture{done: make(chan struct{})}
+	}
+	d.current.slices = append(d.current.slices, &intraSlice{
+		nalUnit:     copyNalUnit(nalUnit),
+		videoStream: videoStream,
+		params:      VideoStream{SPS: videoStream.SPS, PPS: videoStream.PPS},
+		flags:       flags,
+		order:       order,
+	})
+	return nil
+}
+
+// dispatch starts decoding of the picture being gathered, first waiting for
+// the earliest pending picture if the limit on pending pictures is reached.
+func (d *intraDecoder) dispatch() {
+	if d.current == nil {
+		return
+	}
+	for len(d.pending) >= d.workers {
+		d.collect(true)
+	}
+	p := d.current
+	d.current = nil
+	if n := len(d.arenas); n > 0 {
+		p.arena = d.arenas[n-1]
+		d.arenas = d.arenas[:n-1]
+	} else {
+		p.arena = &arena{align: d.align, padding: d.padding}
+	}
+	// The picture dispatched workers pictures earlier has been collected, so
+	// its lane of the timeline is free.
+	p.timeline = d.timeline
+	p.lane = 1 + d.dispatched%d.workers
+	d.dispatched++
+	d.pending = append(d.pending, p)
+	go p.decode()
+}
+
+// decode decodes the slices of the picture. A panic while decoding is
+// returned as an error, as it cannot be recovered by the caller of Start.
+func (p *intraPicture) decode() {
+	defer close(p.done)
+	start := p.timeline.now()
+	p.arena.reset()
+	for _, s := range p.slices {
+		s.decode(p.arena)
+	}
+	p.arena.finish()
+	if p.timeline != nil {
+		args := nalArgs(p.slices[0].nalUnit)
+		args["slices"] = len(p.slices)
+		p.timeline.span(StageDecode, p.lane, start, args)
+	}
+}
+
+func (s *intraSlice) decode(a *arena) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.err = fmt.Errorf("panic while decoding: %v", r)
+		}
+	}()
+	a.poc = s.order.PicOrderCnt
+	s.ctx, s.err = newSliceContext(&s.params, s.nalUnit, s.nalUnit.RBSP(), true, a, nil)
+	if s.err != nil {
+		s.err = fmt.Errorf("could not parse slice: %w", s.err)
+		return
+	}
+	s.ctx.Flags |= s.flags
+	s.ctx.POC = s.order
+}
+
+// collect adds the slices of decoded pictures at the head of the pending
+// pictures to their VideoStreams, retaining any errors to be reported. If
+// block is true, collect waits for the earliest pending picture to be decoded.
+func (d *intraDecoder) collect(block bool) {
+	for len(d.pending) > 0 {
+		p := d.pending[0]
+		if block {
+			start := d.timeline.now()
+			<-p.done
+			d.timeline.span(StageWait, 0, start, nil)
+			block = false
+		}
+		select {
+		case <-p.done:
+		default:
+			return
+		}
+		var v *VideoStream
+		for _, s := range p.slices {
+			if s.err != nil {
+				d.errs = append(d.errs, sliceError{s.nalUnit, s.err})
+				continue
+			}
+			v = s.videoStream
+			v.addSlice(s.ctx)
+		}
+		if v != nil {
+			v.Pictures[len(v.Pictures)-1].addFlags(p.arena.flags)
+		}
+		if d.refs != nil {
+			d.refs.add(p.arena)
+		}
+		d.arenas = append(d.arenas, p.arena)
+		d.pending[0] = nil
+		d.pending = d.pending[1:]
+	}
+}
+
+// wait dispatches the picture being gathered and waits for all pending
+// pictures to be decoded and collected.
+func (d *intraDecoder) wait() {
+	d.dispatch()
+	for len(d.pending) > 0 {
+		d.collect(true)
+	}
+}
+
+// isIntraSlice returns true if the slice NAL unit, using the given SPS, is an
+// I or SI slice and so does not refer to other pictures.
+func isIntraSlice(sps *SPS, nalUnit *NalUnit) bool {
+	if sps.IntraOnly() {
+		return true
+	}
+	t, err := sliceType(nalUnit.RBSP())
+	if err != nil {
+		return false
+	}
+	switch sliceTypeMap[t] {
+	case "I", "SI":
+		return true
+	}
+	return false
+}
+
+// copyNalUnit returns a copy of the NAL unit that does not share storage with
+// the reader's buffer, so that it remains valid while decoded concurrently.
+func copyNalUnit(nalUnit *NalUnit) *NalUnit {
+	c := *nalUnit
+	c.raw = append([]byte(nil), nalUnit.raw...)
+	c.rbsp = append([]byte(nil), nalUnit.rbsp...)
+	return &c
+}
+
+// sliceError is an error decoding the slice of a NAL unit.
+type sliceError struct {
+	nalUnit *NalUnit
+	err     error
+}
+
+// intraErrors reports errors of concurrently decoded slices collected so far.
+// If recovery is enabled the NAL units are counted as discarded, otherwise the
+// first error is returned.
+func (h *H264Reader) intraErrors() error {
+	d := h.intra
+	if d == nil {
+		return nil
+	}
+	d.collect(false)
+	for len(d.errs) > 0 {
+		e := d.errs[0]
+		d.errs = d.errs[1:]
+		err := h.discard(e.nalUnit, e.err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
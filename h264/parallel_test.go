@@ -0,0 +1,117 @@
+/*
+NAME
+  parallel_test.go
+
+DESCRIPTION
+  parallel_test.go provides testing for functionality provided in parallel.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testI is a non-IDR I slice with first_mb_in_slice = 0; testNonIDR is a P
+// slice.
+var testI = []byte{0x41, 0x88, 0x80}
+
+// TestIsIntraSlice checks identification of slices that do not refer to other
+// pictures.
+func TestIsIntraSlice(t *testing.T) {
+	inter := &SPS{ProfileIDC: 66, MaxNumRefFrames: 1}
+	tests := []struct {
+		sps  *SPS
+		nal  []byte
+		want bool
+	}{
+		{sps: inter, nal: testIDR, want: true},
+		{sps: inter, nal: testI, want: true},
+		{sps: inter, nal: testNonIDR, want: false},
+		{sps: &SPS{ProfileIDC: 66}, nal: testNonIDR, want: true},
+		{sps: &SPS{ProfileIDC: 110, Constraint3: 1, MaxNumRefFrames: 1}, nal: testNonIDR, want: true},
+		{sps: inter, nal: []byte{0x41}, want: false},
+	}
+
+	for i, test := range tests {
+		nalUnit, err := NewNalUnit(test.nal, len(test.nal))
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewNalUnit for test: %d", err, i)
+		}
+		if got := isIntraSlice(test.sps, nalUnit); got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestIntraParallelism checks that decoding with WithIntraParallelism gives
+// the same results as sequential decoding, including at inter coded slices,
+// which wait for earlier pictures, and that errors are returned in decoding
+// order.
+func TestIntraParallelism(t *testing.T) {
+	nalUnits := [][]byte{testSPS, testPPS}
+	for i := 0; i < 20; i++ {
+		switch {
+		case i%10 == 0:
+			nalUnits = append(nalUnits, testIDR)
+		case i%7 == 0:
+			nalUnits = append(nalUnits, testNonIDR)
+		default:
+			nalUnits = append(nalUnits, testI)
+		}
+	}
+	stream := annexB(nalUnits...)
+
+	intraStream := annexB(testSPS, testPPS, testIDR, testI, testI)
+
+	decode := func(stream []byte, opts ...Option) (*H264Reader, error) {
+		r, err := NewH264Reader(bytes.NewReader(stream), opts...)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader", err)
+		}
+		return r, r.Start()
+	}
+
+	seq, err := decode(stream, WithRecovery())
+	if err != nil {
+		t.Fatalf("did not expect error: %v from sequential Start", err)
+	}
+
+	// Without recovery the first slice, following the parameter sets, should
+	// give the error returned.
+	wantOffset := int64(len(annexB(testSPS, testPPS)) + len(InitialNALU))
+
+	for _, workers := range []int{1, 2, 8} {
+		r, err := decode(stream, WithRecovery(), WithIntraParallelism(workers))
+		if err != nil {
+			t.Errorf("did not expect error: %v from Start with %d workers", err, workers)
+			continue
+		}
+		if r.Discarded() != seq.Discarded() {
+			t.Errorf("did not get expected discarded count with %d workers\nGot: %v\nWant: %v", workers, r.Discarded(), seq.Discarded())
+		}
+		var slices int
+		for _, vs := range r.VideoStreams {
+			slices += len(vs.Slices)
+		}
+		if slices+r.Discarded() != 20 {
+			t.Errorf("did not get expected number of slices with %d workers\nGot: %v\nWant: %v", workers, slices+r.Discarded(), 20)
+		}
+
+		_, err = decode(intraStream, WithIntraParallelism(workers))
+		var got *ParseError
+		if !errors.As(err, &got) || got.NALOffset != wantOffset {
+			t.Errorf("did not get expected error with %d workers\nGot: %v\nWant: error at offset %d", workers, err, wantOffset)
+		}
+	}
+
+	_, err = NewH264Reader(bytes.NewReader(stream), WithIntraParallelism(0))
+	if err == nil {
+		t.Error("expected error for zero workers")
+	}
+}
@@ -0,0 +1,292 @@
+/*
+NAME
+  paramsets.go
+
+DESCRIPTION
+  paramsets.go provides a store of sequence and picture parameter sets keyed
+  by their IDs, from which the parameter sets active for a slice are resolved.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// Maximum values of seq_parameter_set_id and pic_parameter_set_id (see
+// sections 7.4.2.1.1 and 7.4.2.2).
+const (
+	maxSPSID = 31
+	maxPPSID = 255
+)
+
+// Errors returned when storing parameter sets.
+var (
+	// ErrParameterSetID is returned for a parameter set with an out of range ID.
+	ErrParameterSetID = errors.New("parameter set ID out of range")
+
+	// ErrNotParameterSet is returned by Parse for a NAL unit that is not an SPS
+	// or PPS.
+	ErrNotParameterSet = errors.New("NAL unit is not a parameter set")
+
+	// ErrPictureTooLarge is returned for an SPS giving pictures larger than
+	// the maximum dimensions, see AddSPS.
+	ErrPictureTooLarge = errors.New("picture dimensions exceed maximum")
+)
+
+// MissingParameterSetError is returned when a PPS or slice refers to a
+// parameter set that has not been received.
+type MissingParameterSetError struct {
+	Type NALType // NALTypeSPS or NALTypePPS.
+	ID   int     // ID of the missing parameter set.
+}
+
+// Error implements the error interface.
+func (e *MissingParameterSetError) Error() string {
+	name := "PPS"
+	if e.Type == NALTypeSPS {
+		name = "SPS"
+	}
+	return fmt.Sprintf("unknown %s %d", name, e.ID)
+}
+
+// ParameterSets stores the SPS and PPS received in a stream, keyed by
+// seq_parameter_set_id and pic_parameter_set_id respectively. A parameter set
+// received with the ID of an existing one replaces it. Subset SPS are stored
+// separately from SPS, as their IDs are independent. The zero value is ready
+// for use.
+type ParameterSets struct {
+	SPS       map[int]*SPS
+	PPS       map[int]*PPS
+	SubsetSPS map[int]*SubsetSPS
+
+	last *SPS // Most recently received SPS.
+
+	// Maximum picture dimensions in luma samples, if not 0, see
+	// WithMaxDimensions.
+	maxWidth, maxHeight int
+}
+
+// AddSPS stores sps, replacing any SPS with the same ID. An SPS giving
+// pictures larger than the maximum dimensions is rejected with an error
+// wrapping ErrPictureTooLarge, see checkDimensions.
+func (p *ParameterSets) AddSPS(sps *SPS) error {
+	if sps.ID < 0 || sps.ID > maxSPSID {
+		return fmt.Errorf("%w: seq_parameter_set_id %d", ErrParameterSetID, sps.ID)
+	}
+	err := p.checkDimensions(sps)
+	if err != nil {
+		return err
+	}
+	if p.SPS == nil {
+		p.SPS = make(map[int]*SPS)
+	}
+	p.SPS[sps.ID] = sps
+	p.last = sps
+	return nil
+}
+
+// AddSubsetSPS stores sps, replacing any subset SPS with the same ID. As for
+// AddSPS, a subset SPS giving pictures larger than the maximum dimensions is
+// rejected.
+func (p *ParameterSets) AddSubsetSPS(sps *SubsetSPS) error {
+	if sps.SPS.ID < 0 || sps.SPS.ID > maxSPSID {
+		return fmt.Errorf("%w: seq_parameter_set_id %d", ErrParameterSetID, sps.SPS.ID)
+	}
+	err := p.checkDimensions(sps.SPS)
+	if err != nil {
+		return err
+	}
+	if p.SubsetSPS == nil {
+		p.SubsetSPS = make(map[int]*SubsetSPS)
+	}
+	p.SubsetSPS[sps.SPS.ID] = sps
+	return nil
+}
+
+// checkDimensions returns an error wrapping ErrPictureTooLarge if the full
+// decoded pictures given by sps are larger than the maximum dimensions set by
+// WithMaxDimensions. By default the limits of table A-1 for the level of the
+// SPS are used, those being a frame size of MaxFS macroblocks, width and
+// height of Sqrt(MaxFS*8) macroblocks (A.3.1), and max_num_ref_frames frames
+// within MaxDpbMbs, with those of the highest level for a level_idc not of
+// the table.
+func (p *ParameterSets) checkDimensions(sps *SPS) error {
+	width := int64(sps.PicWidthInMbsMinus1 + 1)
+	height := int64(sps.PicHeightInMapUnitsMinus1+1) * int64(2-flagVal(sps.FrameMbsOnly))
+	if p.maxWidth != 0 {
+		if width*16 > int64(p.maxWidth) || height*16 > int64(p.maxHeight) {
+			return fmt.Errorf("%w: %dx%d exceeds %dx%d", ErrPictureTooLarge, width*16, height*16, p.maxWidth, p.maxHeight)
+		}
+		return nil
+	}
+	level := sps.Level()
+	limits, ok := levelTable[level]
+	if !ok {
+		level, limits = Level62, levelTable[Level62]
+	}
+	maxDim := int64(math.Sqrt(float64(limits.maxFS * 8)))
+	if width > maxDim || height > maxDim || width*height > limits.maxFS {
+		return fmt.Errorf("%w: %dx%d macroblocks exceeds limits of level %v", ErrPictureTooLarge, width, height, level)
+	}
+	refFrames := int64(sps.MaxNumRefFrames)
+	if refFrames < 1 {
+		refFrames = 1
+	}
+	if refFrames*width*height > limits.maxDpbMbs {
+		return fmt.Errorf("%w: %d reference frames of %dx%d macroblocks exceeds DPB of level %v", ErrPictureTooLarge, refFrames, width, height, level)
+	}
+	return nil
+}
+
+// AddPPS stores pps, replacing any PPS with the same ID.
+func (p *ParameterSets) AddPPS(pps *PPS) error {
+	if pps.ID < 0 || pps.ID > maxPPSID {
+		return fmt.Errorf("%w: pic_parameter_set_id %d", ErrParameterSetID, pps.ID)
+	}
+	if p.PPS == nil {
+		p.PPS = make(map[int]*PPS)
+	}
+	p.PPS[pps.ID] = pps
+	return nil
+}
+
+// Active returns the PPS with the given pic_parameter_set_id, as referred to
+// by a slice header, and the SPS that it refers to.
+func (p *ParameterSets) Active(ppsID int) (*SPS, *PPS, error) {
+	pps, ok := p.PPS[ppsID]
+	if !ok {
+		return nil, nil, fmt.Errorf("slice refers to %w", &MissingParameterSetError{Type: NALTypePPS, ID: ppsID})
+	}
+	sps, ok := p.SPS[pps.SPSID]
+	if !ok {
+		return nil, nil, fmt.Errorf("PPS %d refers to %w", ppsID, &MissingParameterSetError{Type: NALTypeSPS, ID: pps.SPSID})
+	}
+	return sps, pps, nil
+}
+
+// Parse parses the SPS, subset SPS or PPS carried by nalUnit and stores it. A
+// PPS is parsed using the stored SPS that it refers to or, if there is none, the
+// subset SPS, as is the case for PPS used only by SVC enhancement layers or MVC
+// non-base views.
+func (p *ParameterSets) Parse(nalUnit *NalUnit) error {
+	switch nalUnit.Type {
+	case NALTypeSPS:
+		sps, err := NewSPS(nalUnit.RBSP(), false)
+		if err != nil {
+			return fmt.Errorf("could not parse SPS: %w", err)
+		}
+		return p.AddSPS(sps)
+	case NALTypeSubsetSPS:
+		sps, err := NewSubsetSPS(nalUnit.RBSP())
+		if err != nil {
+			return fmt.Errorf("could not parse subset SPS: %w", err)
+		}
+		return p.AddSubsetSPS(sps)
+	case NALTypePPS:
+		sps, err := p.ppsSPS(nalUnit.RBSP())
+		if err != nil {
+			return err
+		}
+		pps, err := NewPPS(sps, nalUnit.RBSP(), false)
+		if err != nil {
+			return fmt.Errorf("could not parse PPS: %w", err)
+		}
+		return p.AddPPS(pps)
+	default:
+		return fmt.Errorf("%w: %s", ErrNotParameterSet, nalUnit.Type)
+	}
+}
+
+// parseRaw parses and stores the parameter set NAL unit raw, which must be of
+// type typ, as received out of band rather than in the stream, e.g. from the
+// avcC box of an MP4 file or the sprop-parameter-sets of an SDP description.
+// A leading start code prefix is ignored. raw is copied, so may be reused.
+func (p *ParameterSets) parseRaw(raw []byte, typ NALType) error {
+	switch {
+	case bytes.HasPrefix(raw, InitialNALU):
+		raw = raw[len(InitialNALU):]
+	case bytes.HasPrefix(raw, Initial3BNALU):
+		raw = raw[len(Initial3BNALU):]
+	}
+	raw = append([]byte(nil), raw...)
+	if len(raw) == 0 {
+		return errEmptyParameterSet
+	}
+	nalUnit, err := NewNalUnit(raw, len(raw))
+	if err != nil {
+		return fmt.Errorf("could not parse NAL unit header: %w", err)
+	}
+	if nalUnit.Type != typ {
+		return fmt.Errorf("%w: got %s, want %s", errWrongParameterSet, nalUnit.Type, typ)
+	}
+	return p.Parse(nalUnit)
+}
+
+// ppsSPS returns the stored SPS referred to by the PPS with the given RBSP,
+// or if there is none, the subset SPS.
+func (p *ParameterSets) ppsSPS(rbsp []byte) (*SPS, error) {
+	spsID, err := ppsSPSID(rbsp)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse PPS: %w", err)
+	}
+	sps, ok := p.SPS[spsID]
+	if !ok {
+		if subset, ok := p.SubsetSPS[spsID]; ok {
+			sps = subset.SPS
+		}
+	}
+	if sps == nil {
+		return nil, fmt.Errorf("PPS refers to %w", &MissingParameterSetError{Type: NALTypeSPS, ID: spsID})
+	}
+	return sps, nil
+}
+
+// ppsSPSID returns the seq_parameter_set_id of the PPS with the given RBSP,
+// being its second syntax element.
+func ppsSPSID(rbsp []byte) (_ int, err error) {
+	br := bits.NewBitReader(bytes.NewReader(rbsp))
+	defer func() { err = withBitPos(br, err) }()
+
+	_, err = readUe(br)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse pic_parameter_set_id: %w", err)
+	}
+	id, err := readUe(br)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse seq_parameter_set_id: %w", err)
+	}
+	return id, nil
+}
+
+// slicePPSID returns the pic_parameter_set_id of the slice with the given
+// RBSP, being the third syntax element of its header.
+func slicePPSID(rbsp []byte) (_ int, err error) {
+	br := bits.NewBitReader(bytes.NewReader(rbsp))
+	defer func() { err = withBitPos(br, err) }()
+
+	for _, name := range []string{"first_mb_in_slice", "slice_type"} {
+		_, err := readUe(br)
+		if err != nil {
+			return 0, fmt.Errorf("could not parse %s: %w", name, err)
+		}
+	}
+	id, err := readUe(br)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse pic_parameter_set_id: %w", err)
+	}
+	return id, nil
+}
+
+var (
+	errEmptyParameterSet = errors.New("empty parameter set")
+	errWrongParameterSet = errors.New("NAL unit is not of expected parameter set type")
+)
@@ -0,0 +1,202 @@
+/*
+NAME
+  paramsets_test.go
+
+DESCRIPTION
+  paramsets_test.go provides testing for functionality provided in
+  paramsets.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// spsWithID returns a baseline SPS NAL unit for a 16x16 picture with the
+// given seq_parameter_set_id.
+func spsWithID(id int) []byte {
+	return append([]byte{0x67}, binToSlice(
+		"01000010 00000000 00011110"+ // profile_idc 66, constraints, level_idc 30.
+			ueBits(id)+"1 011 010 0 1 1 1 1 0 0"+
+			"1", // rbsp_stop_one_bit.
+	)...)
+}
+
+// ppsWithID returns a CAVLC PPS NAL unit with the given pic_parameter_set_id
+// referring to the SPS with the given seq_parameter_set_id.
+func ppsWithID(id, spsID int) []byte {
+	return append([]byte{0x68}, binToSlice(
+		ueBits(id)+ueBits(spsID)+"0 0 1 1 1 0 00 1 1 1 1 0 0"+
+			"1", // rbsp_stop_one_bit.
+	)...)
+}
+
+// TestParameterSets checks that parameter sets are stored by ID and that the
+// active SPS and PPS are resolved from a pic_parameter_set_id, regardless of
+// the order in which they are received.
+func TestParameterSets(t *testing.T) {
+	stream := annexB(spsWithID(1), spsWithID(0), ppsWithID(5, 1), ppsWithID(2, 0), ppsWithID(5, 0))
+
+	r, err := NewH264Reader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = r.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+
+	tests := []struct {
+		ppsID   int
+		spsID   int
+		wantErr bool
+	}{
+		{ppsID: 2, spsID: 0},
+		{ppsID: 5, spsID: 0}, // Replaced by the second PPS with ID 5.
+		{ppsID: 3, wantErr: true},
+	}
+
+	for i, test := range tests {
+		sps, pps, err := r.ParameterSets.Active(test.ppsID)
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if pps.ID != test.ppsID || sps.ID != test.spsID || pps.SPSID != sps.ID {
+			t.Errorf("did not get expected parameter sets for test: %d\nGot: PPS %d, SPS %d\nWant: PPS %d, SPS %d", i, pps.ID, sps.ID, test.ppsID, test.spsID)
+		}
+	}
+
+	if len(r.ParameterSets.SPS) != 2 {
+		t.Errorf("did not get expected number of SPS\nGot: %v\nWant: %v", len(r.ParameterSets.SPS), 2)
+	}
+}
+
+// TestParameterSetsUnknownSPS checks that a PPS referring to an SPS that has
+// not been received is rejected.
+func TestParameterSetsUnknownSPS(t *testing.T) {
+	r, err := NewH264Reader(bytes.NewReader(annexB(spsWithID(0), ppsWithID(0, 3))))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = r.Start()
+	var missing *MissingParameterSetError
+	if !errors.As(err, &missing) {
+		t.Fatalf("did not get expected error type for PPS referring to unknown SPS\nGot: %v", err)
+	}
+	if missing.Type != NALTypeSPS || missing.ID != 3 {
+		t.Errorf("did not get expected missing parameter set\nGot: %v %d\nWant: %v %d", missing.Type, missing.ID, NALTypeSPS, 3)
+	}
+}
+
+// TestWithParameterSets checks that parameter sets given out of band, with or
+// without start code prefixes, allow a stream without them to be decoded, and
+// that invalid parameter sets are rejected.
+func TestWithParameterSets(t *testing.T) {
+	stream := annexB(skipSlice(2, 1), skipSlice(2, 2))
+
+	tests := []struct {
+		opts         []Option
+		wantErr      bool // Expected error from NewH264Reader.
+		wantStartErr bool // Expected error from Start.
+	}{
+		{wantStartErr: true},
+		{opts: []Option{WithSPS(testSPS), WithPPS(testPPS)}},
+		{opts: []Option{WithSPS(annexB(testSPS)), WithPPS(append([]byte{0, 0, 1}, testPPS...))}},
+		{opts: []Option{WithPPS(testPPS)}, wantErr: true},
+		{opts: []Option{WithSPS(testPPS)}, wantErr: true},
+		{opts: []Option{WithSPS(nil)}, wantErr: true},
+		{opts: []Option{WithSPS(testSPS[:2])}, wantErr: true},
+	}
+
+	for i, test := range tests {
+		r, err := NewH264Reader(bytes.NewReader(stream), test.opts...)
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		err = r.Start()
+		if (err != nil) != test.wantStartErr {
+			t.Errorf("did not get expected error from Start for test: %d\nGot: %v\nWant error: %v", i, err, test.wantStartErr)
+		}
+	}
+}
+
+// spsWithSize returns a baseline SPS NAL unit for frames of the given width
+// and height in macroblocks, with the given level_idc and max_num_ref_frames.
+func spsWithSize(width, height, levelIDC, numRefFrames int) []byte {
+	return append([]byte{0x67}, binToSlice(
+		"01000010 00000000"+ // profile_idc 66, constraints.
+			fmt.Sprintf("%08b", levelIDC)+
+			"1 1 011"+ueBits(numRefFrames)+"0"+ueBits(width-1)+ueBits(height-1)+"1 1 0 0"+
+			"1", // rbsp_stop_one_bit.
+	)...)
+}
+
+// TestMaxDimensions checks that an SPS giving pictures larger than the
+// maximum dimensions, by default those of its level, is rejected.
+func TestMaxDimensions(t *testing.T) {
+	tests := []struct {
+		sps     []byte
+		opts    []Option
+		wantErr bool
+	}{
+		// MaxFS and MaxDpbMbs of level 3.
+		{sps: spsWithSize(1, 1, 30, 1)},
+		{sps: spsWithSize(45, 36, 30, 5)},
+		{sps: spsWithSize(46, 36, 30, 1), wantErr: true},
+		{sps: spsWithSize(114, 1, 30, 1), wantErr: true},
+		{sps: spsWithSize(45, 36, 30, 6), wantErr: true},
+
+		// Level 6.2, also used for an unknown level_idc.
+		{sps: spsWithSize(1055, 132, 62, 1)},
+		{sps: spsWithSize(1056, 1, 62, 1), wantErr: true},
+		{sps: spsWithSize(1, 1056, 62, 1), wantErr: true},
+		{sps: spsWithSize(400, 400, 62, 1), wantErr: true},
+		{sps: spsWithSize(1055, 132, 63, 1)},
+		{sps: spsWithSize(1056, 1, 63, 1), wantErr: true},
+
+		// Configured maximum, replacing the level limits.
+		{sps: spsWithSize(120, 68, 30, 1), opts: []Option{WithMaxDimensions(1920, 1088)}},
+		{sps: spsWithSize(121, 68, 30, 1), opts: []Option{WithMaxDimensions(1920, 1088)}, wantErr: true},
+		{sps: spsWithSize(120, 69, 30, 1), opts: []Option{WithMaxDimensions(1920, 1088)}, wantErr: true},
+	}
+
+	for i, test := range tests {
+		r, err := NewH264Reader(bytes.NewReader(annexB(test.sps)), test.opts...)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader for test: %d", err, i)
+		}
+		err = r.Start()
+		if errors.Is(err, ErrPictureTooLarge) != test.wantErr || (err != nil && !test.wantErr) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && len(r.ParameterSets.SPS) != 1 {
+			t.Errorf("SPS not stored for test: %d", i)
+		}
+	}
+
+	// The maximum applies to SPS given out of band by earlier options.
+	_, err := NewH264Reader(nil, WithSPS(spsWithSize(121, 68, 30, 1)), WithMaxDimensions(1920, 1088))
+	if !errors.Is(err, ErrPictureTooLarge) {
+		t.Errorf("did not get expected error for out of band SPS\nGot: %v\nWant: %v", err, ErrPictureTooLarge)
+	}
+	_, err = NewH264Reader(nil, WithMaxDimensions(0, 1088))
+	if err == nil {
+		t.Error("did not get expected error for bad maximum dimensions")
+	}
+}
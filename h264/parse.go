@@ -14,10 +14,11 @@ AUTHORS
 package h264
 
 import (
-	"math"
+	"errors"
+	"fmt"
+	mathbits "math/bits"
 
 	"github.com/ausocean/h264decode/h264/bits"
-	"github.com/pkg/errors"
 )
 
 // mbPartPredMode represents a macroblock partition prediction mode.
@@ -42,21 +43,67 @@ const (
 // TODO: this should return uint, but rest of code needs to be changed for this
 // to happen.
 func readUe(r *bits.BitReader) (int, error) {
+	if r.Tracer() != nil {
+		start := r.Off()
+		v, err := readUeCode(r)
+		name := ""
+		if err != nil {
+			name = "(incomplete)"
+		}
+		traceElement(r, start, name, "ue(v)", int64(v))
+		return v, err
+	}
+	return readUeCode(r)
+}
+
+// readUeCode parses a ue(v) syntax element for readUe, without tracing.
+func readUeCode(r *bits.BitReader) (int, error) {
+	// Fast path: peek enough bits to hold most codes, count leading zeros in
+	// one operation and read the whole code at once.
+	v, err := r.PeekBits(ueFastBits)
+	if err == nil {
+		nZeros := mathbits.LeadingZeros64(v) - (64 - ueFastBits)
+		if 2*nZeros+1 <= ueFastBits {
+			code, err := r.ReadBits(2*nZeros + 1)
+			if err != nil {
+				return 0, err
+			}
+			return int(code - 1), nil
+		}
+	}
+
+	// Slow path for long codes, or near the end of the data where fewer bits
+	// than ueFastBits remain.
 	nZeros := -1
-	var err error
 	for b := uint64(0); b == 0; nZeros++ {
 		b, err = r.ReadBits(1)
 		if err != nil {
 			return 0, err
 		}
+		if nZeros >= maxUeLeadingZeros {
+			return 0, ErrUeTooLong
+		}
 	}
-	rem, err := r.ReadBits(int(nZeros))
+	rem, err := r.ReadBits(nZeros)
 	if err != nil {
 		return 0, err
 	}
-	return int(math.Pow(float64(2), float64(nZeros)) - 1 + float64(rem)), nil
+	return (1 << uint(nZeros)) - 1 + int(rem), nil
 }
 
+const (
+	// ueFastBits is the number of bits peeked by readUe's fast path.
+	ueFastBits = 32
+
+	// maxUeLeadingZeros is the maximum number of leading zeros in a valid
+	// ue(v) code, giving a code number of at most 2^32-2.
+	maxUeLeadingZeros = 31
+)
+
+// ErrUeTooLong is returned when a ue(v) code has more leading zeros than a
+// valid code, indicating a corrupt or misaligned bitstream.
+var ErrUeTooLong = errors.New("ue(v) code has too many leading zeros")
+
 // readTe parses a syntax element of te(v) descriptor i.e, truncated
 // Exp-Golomb-coded syntax element using method as specified in section 9.1
 // Rec. ITU-T H.264 (04/2017).
@@ -70,7 +117,7 @@ func readTe(r *bits.BitReader, x uint) (int, error) {
 	if x == 1 {
 		b, err := r.ReadBits(1)
 		if err != nil {
-			return 0, errors.Wrap(err, "could not read bit")
+			return 0, fmt.Errorf("could not read bit: %w", err)
 		}
 		if b == 0 {
 			return 1, nil
@@ -87,12 +134,19 @@ var errReadTeBadX = errors.New("x must be more than or equal to 1")
 // Exp-Golomb-coded syntax element, using the method described in sections
 // 9.1 and 9.1.1 in Rec. ITU-T H.264 (04/2017).
 func readSe(r *bits.BitReader) (int, error) {
+	start := r.Off()
 	codeNum, err := readUe(r)
 	if err != nil {
-		return 0, errors.Wrap(err, "error reading ue(v)")
+		return 0, fmt.Errorf("error reading ue(v): %w", err)
 	}
 
-	return int(math.Pow(-1, float64(codeNum+1)) * math.Ceil(float64(codeNum)/2.0)), nil
+	// Table 9-3: odd code numbers map to positive values, even to negative.
+	v := -codeNum / 2
+	if codeNum&1 == 1 {
+		v = (codeNum + 1) / 2
+	}
+	traceElement(r, start, "", "se(v)", int64(v))
+	return v, nil
 }
 
 // readMe parses a syntax element of me(v) descriptor, i.e. mapped
@@ -115,7 +169,7 @@ func readMe(r *bits.BitReader, chromaArrayType uint, mpm mbPartPredMode) (uint,
 	// CodeNum from readUe selects second index.
 	i2, err := readUe(r)
 	if err != nil {
-		return 0, errors.Wrap(err, "error from readUe")
+		return 0, fmt.Errorf("error from readUe: %w", err)
 	}
 
 	// Need to check that we won't go out of bounds with this index.
@@ -151,7 +205,7 @@ var codedBlockPattern = [][][2]uint{
 	{
 		{47, 0}, {31, 16}, {15, 1}, {0, 2}, {23, 4}, {27, 8}, {29, 32}, {30, 3},
 		{7, 5}, {11, 10}, {13, 12}, {14, 15}, {39, 47}, {43, 7}, {45, 11}, {46, 13},
-		{16, 14}, {3, 6}, {31, 9}, {10, 31}, {12, 35}, {19, 37}, {21, 42}, {26, 44},
+		{16, 14}, {3, 6}, {5, 9}, {10, 31}, {12, 35}, {19, 37}, {21, 42}, {26, 44},
 		{28, 33}, {35, 34}, {37, 36}, {42, 40}, {44, 39}, {1, 43}, {2, 45}, {4, 46},
 		{8, 17}, {17, 18}, {18, 20}, {20, 24}, {24, 19}, {6, 21}, {9, 26}, {22, 28},
 		{25, 23}, {32, 27}, {33, 29}, {34, 30}, {36, 22}, {40, 25}, {38, 38}, {41, 41},
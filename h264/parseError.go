@@ -0,0 +1,93 @@
+/*
+NAME
+  parseError.go
+
+DESCRIPTION
+  parseError.go provides the ParseError type, which locates the failure to
+  parse a NAL unit within the stream, and supporting utilities for tracking
+  positions through the scanner and parsers.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// ParseError is returned by an H264Reader when a NAL unit fails to be parsed or
+// decoded. It gives the absolute position in the stream of the NAL unit, and
+// where known, of the point at which the failure was detected, so that the
+// stream may be inspected there.
+type ParseError struct {
+	Type      NALType // Type of the NAL unit.
+	NALOffset int64   // Stream byte offset of the NAL unit's first header byte.
+
+	// Offset and Bit give the stream byte offset, and bit within that byte
+	// (0 being the most significant), at which the failure was detected. If the
+	// position is not known Offset is NALOffset and Bit is -1.
+	Offset int64
+	Bit    int
+
+	Err error // The underlying error.
+}
+
+// Error implements the error interface.
+func (e *ParseError) Error() string {
+	if e.Bit < 0 {
+		return fmt.Sprintf("%s NAL unit at stream offset %d: %v", e.Type, e.NALOffset, e.Err)
+	}
+	return fmt.Sprintf("%s NAL unit at stream offset %d: failed at offset %d bit %d: %v", e.Type, e.NALOffset, e.Offset, e.Bit, e.Err)
+}
+
+// Unwrap returns the underlying error, for use with errors.Is and errors.As.
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// newParseError returns a ParseError for a failure to parse or decode nalUnit
+// with error err, locating the failure using any bit position recorded by
+// withBitPos.
+func newParseError(nalUnit *NalUnit, err error) *ParseError {
+	e := &ParseError{Type: nalUnit.Type, NALOffset: nalUnit.Offset, Offset: nalUnit.Offset, Bit: -1, Err: err}
+	if pos, ok := bitPos(err); ok {
+		e.Offset = nalUnit.Offset + int64(nalUnit.nalByte(pos/8))
+		e.Bit = pos % 8
+	}
+	return e
+}
+
+// bitPosError records the bit position in an RBSP at which an error occurred.
+type bitPosError struct {
+	pos int
+	err error
+}
+
+func (e *bitPosError) Error() string { return e.err.Error() }
+func (e *bitPosError) Unwrap() error { return e.err }
+
+// withBitPos annotates a non-nil err with the current position of br, which is
+// reading an RBSP. It is intended to be deferred by parsers.
+func withBitPos(br *bits.BitReader, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *bitPosError
+	if errors.As(err, &e) {
+		return err
+	}
+	return &bitPosError{pos: br.Off(), err: err}
+}
+
+// bitPos returns the position recorded by withBitPos in err or any error that
+// it wraps.
+func bitPos(err error) (int, bool) {
+	var e *bitPosError
+	if errors.As(err, &e) {
+		return e.pos, true
+	}
+	return 0, false
+}
@@ -0,0 +1,62 @@
+/*
+NAME
+  parseError_test.go
+
+DESCRIPTION
+  parseError_test.go provides testing for functionality provided in
+  parseError.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestParseError checks that parse failures are reported with the absolute
+// stream offset of the NAL unit and of the failure, accounting for emulation
+// prevention bytes.
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		pps       []byte
+		nalOffset int64
+		offset    int64
+		bit       int
+	}{
+		// PPS with no payload fails immediately after the header.
+		{
+			pps:       []byte{0x68},
+			nalOffset: 14,
+			offset:    15,
+			bit:       0,
+		},
+		// PPS ID with too many leading zeros, detected at RBSP bit 33, beyond two
+		// emulation prevention bytes.
+		{
+			pps:       []byte{0x68, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x80},
+			nalOffset: 14,
+			offset:    14 + 7,
+			bit:       1,
+		},
+	}
+
+	for i, test := range tests {
+		r, err := NewH264Reader(bytes.NewReader(annexB(testSPS, test.pps)))
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader", err)
+		}
+		err = r.Start()
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("did not get ParseError for test: %d\nGot: %v", i, err)
+			continue
+		}
+		if perr.Type != NALTypePPS || perr.NALOffset != test.nalOffset || perr.Offset != test.offset || perr.Bit != test.bit {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: NALOffset: %d Offset: %d Bit: %d", i, perr, test.nalOffset, test.offset, test.bit)
+		}
+	}
+}
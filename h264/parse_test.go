@@ -13,6 +13,9 @@ package h264
 
 import (
 	"bytes"
+	"errors"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/ausocean/h264decode/h264/bits"
@@ -52,6 +55,62 @@ func TestReadUe(t *testing.T) {
 	}
 }
 
+// TestReadUeSequence checks that consecutive ue(v) codes are read correctly,
+// covering codes decoded by both the fast and slow paths of readUe, and codes
+// close to the end of the data.
+func TestReadUeSequence(t *testing.T) {
+	want := []int{0, 1, 2, 7, 255, 65534, 65535, 1 << 20, 3, 1<<32 - 2, 0, 5}
+
+	var s string
+	for _, v := range want {
+		s += ueBits(v)
+	}
+	br := bits.NewBitReader(bytes.NewReader(binToSlice(s)))
+
+	for i, w := range want {
+		got, err := readUe(br)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for code: %d", err, i)
+		}
+		if got != w {
+			t.Errorf("did not get expected result for code: %d\nGot: %v\nWant: %v", i, got, w)
+		}
+	}
+
+	// 32 leading zeros is not a valid code.
+	_, err := readUe(bits.NewBitReader(bytes.NewReader(make([]byte, 8))))
+	if !errors.Is(err, ErrUeTooLong) {
+		t.Errorf("did not get expected error for long code\nGot: %v\nWant: %v", err, ErrUeTooLong)
+	}
+}
+
+// ueBits returns the ue(v) code for v as a string of binary digits.
+func ueBits(v int) string {
+	code := strconv.FormatUint(uint64(v)+1, 2)
+	return strings.Repeat("0", len(code)-1) + code
+}
+
+// BenchmarkReadUe measures readUe on a mix of short and long codes, as are
+// typical of slice headers.
+func BenchmarkReadUe(b *testing.B) {
+	var s string
+	for i := 0; i < 1000; i++ {
+		s += ueBits(i % 300)
+	}
+	in := binToSlice(s)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br := bits.NewBitReader(bytes.NewReader(in))
+		for j := 0; j < 1000; j++ {
+			_, err := readUe(br)
+			if err != nil {
+				b.Fatalf("did not expect error: %v", err)
+			}
+		}
+	}
+}
+
 // TestReadTe checks that readTe correctly parses a truncated Exp-Golomb-coded
 // syntax element. Expected results are outlined in section 9.1 pg209 Rec ITU-T
 // H.264 (04/2017)
@@ -152,3 +211,25 @@ func TestReadMe(t *testing.T) {
 		}
 	}
 }
+
+// TestCodedBlockPatternTable checks that each column of table 9-4 maps
+// codeNum onto every coded_block_pattern value exactly once, there being 48
+// values for ChromaArrayType 1 or 2 and 16 otherwise.
+func TestCodedBlockPatternTable(t *testing.T) {
+	for i, table := range codedBlockPattern {
+		n := []int{48, 16}[i]
+		if len(table) != n {
+			t.Errorf("did not get expected number of codeNums for table: %d\nGot: %d\nWant: %d", i, len(table), n)
+		}
+		for col := 0; col < 2; col++ {
+			seen := make(map[uint]int)
+			for codeNum, row := range table {
+				cbp := row[col]
+				if prev, ok := seen[cbp]; ok || int(cbp) >= n {
+					t.Errorf("invalid or repeated coded_block_pattern for table: %d column: %d codeNum: %d\nGot: %d\nRepeats codeNum: %d", i, col, codeNum, cbp, prev)
+				}
+				seen[cbp] = codeNum
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+NAME
+  partition.go
+
+DESCRIPTION
+  partition.go provides support for slices coded as data partitions A, B and
+  C (NAL unit types 2 to 4), as used by the Extended profile to allow the
+  slice header and macroblock headers to be protected separately from
+  residual data.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// DataPartitions holds partitions B and C of a slice coded as data
+// partitions, of which partition A is the slice's NAL unit. Partition B holds
+// the residual data of intra coded macroblocks, and partition C that of inter
+// coded macroblocks (7.3.2.9). Either may be absent, e.g. if lost or if there
+// is no such residual data, in which case it is nil.
+type DataPartitions struct {
+	B, C *NalUnit
+}
+
+// PartitionHeader holds the syntax elements preceding the slice data of data
+// partitions B and C (7.3.2.9.2 and 7.3.2.9.3).
+type PartitionHeader struct {
+	SliceID         int // slice_id of the slice the partition belongs to.
+	ColorPlaneID    int // colour_plane_id, if separate_colour_plane_flag is set.
+	RedundantPicCnt int // redundant_pic_cnt, if present according to the PPS.
+}
+
+// readPartitionHeader parses the syntax elements of partition B or C up to its
+// slice data, using the SPS and PPS of its slice. slice_id is checked against
+// the range given by section 7.4.2.9.1, which depends on the slice's header.
+func readPartitionHeader(br *bits.BitReader, sps *SPS, pps *PPS, header *SliceHeader) (PartitionHeader, error) {
+	var h PartitionHeader
+	var err error
+	h.SliceID, err = readSliceID(br, sps, header)
+	if err != nil {
+		return h, err
+	}
+	if sps.UseSeparateColorPlane {
+		err = readFields(br, []field{{&h.ColorPlaneID, "ColorPlaneID", 2}})
+		if err != nil {
+			return h, err
+		}
+	}
+	if pps.RedundantPicCntPresent {
+		h.RedundantPicCnt, err = readUe(br)
+		if err != nil {
+			return h, fmt.Errorf("could not parse RedundantPicCnt: %w", err)
+		}
+	}
+	return h, nil
+}
+
+// readSliceID parses a slice_id, which ranges from 0 to PicSizeInMbs-1, or
+// PicSizeInMbs/2-1 for MBAFF frames.
+func readSliceID(br *bits.BitReader, sps *SPS, header *SliceHeader) (int, error) {
+	id, err := readUe(br)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse SliceID: %w", err)
+	}
+	max := PicSizeInMbs(sps, header) >> uint(MbaffFrameFlag(sps, header))
+	if id >= max {
+		return 0, fmt.Errorf("slice_id %d not less than %d", id, max)
+	}
+	return id, nil
+}
+
+// partitionReader returns a BitReader for the slice data of partition B or C,
+// positioned after the syntax elements preceding it, having checked that they
+// match those of partition A, with the given slice header.
+func partitionReader(nalUnit *NalUnit, sps *SPS, pps *PPS, header *SliceHeader) (*bits.BitReader, error) {
+	br := bits.NewBitReader(bytes.NewReader(nalUnit.RBSP()))
+	h, err := readPartitionHeader(br, sps, pps, header)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", nalUnit.Type, err)
+	}
+	if h.SliceID != header.SliceID || h.ColorPlaneID != header.ColorPlaneID || h.RedundantPicCnt != header.RedundantPicCnt {
+		return nil, fmt.Errorf("%s does not match partition A: %+v", nalUnit.Type, h)
+	}
+	return br, nil
+}
+
+// residualReader returns the BitReader from which the residual data of a
+// macroblock is read: that of the slice data, unless the slice is coded as
+// data partitions, in which case that of partition B for intra coded
+// macroblocks, or partition C for inter coded macroblocks. An error wrapping
+// errMissingPartition is returned if the partition was not received.
+func (d *SliceData) residualReader(intra bool) (*bits.BitReader, error) {
+	if !d.partitioned {
+		return d.BitReader, nil
+	}
+	br, name := d.partitionC, "C"
+	if intra {
+		br, name = d.partitionB, "B"
+	}
+	if br == nil {
+		return nil, fmt.Errorf("%w: partition %s", errMissingPartition, name)
+	}
+	return br, nil
+}
+
+// partitionedSlice is a slice coded as data partitions, gathered from its
+// partition A until its partitions B and C have been received.
+type partitionedSlice struct {
+	a           *NalUnit     // Partition A, copied from the reader's buffer.
+	videoStream *VideoStream // VideoStream to which the slice is added.
+	flags       FrameFlags   // Flags to be set on the decoded slice.
+	order       PictureOrder // Order of the picture the slice belongs to.
+	header      *SliceHeader // Header of partition A, giving its slice_id.
+	parts       DataPartitions
+}
+
+// gatherPartitionA begins gathering the slice of which nalUnit is partition
+// A, any slice already being gathered having been decoded. The slice is
+// decoded by decodePartitioned once partitions B and C have been received,
+// or a NAL unit of another type is.
+func (h *H264Reader) gatherPartitionA(videoStream *VideoStream, nalUnit *NalUnit, flags FrameFlags, order PictureOrder) error {
+	if videoStream.PPS.EntropyCodingMode == 1 {
+		return errors.New("data partitioning used with CABAC")
+	}
+	br := bits.NewBitReader(bytes.NewReader(nalUnit.RBSP()))
+	header, err := readSliceHeader(br, nalUnit, videoStream.SPS, videoStream.PPS)
+	if err != nil {
+		return fmt.Errorf("could not parse slice: %w", withBitPos(br, err))
+	}
+	header.SliceID, err = readSliceID(br, videoStream.SPS, header)
+	if err != nil {
+		return fmt.Errorf("could not parse slice: %w", withBitPos(br, err))
+	}
+	h.partitioned = &partitionedSlice{
+		a:           copyNalUnit(nalUnit),
+		videoStream: videoStream,
+		flags:       flags,
+		order:       order,
+		header:      header,
+	}
+	return nil
+}
+
+// addPartition adds partition B or C to the slice being gathered. Partition
+// B, if present, precedes partition C, and both follow partition A
+// (7.4.1.2.3); a partition that does not belong to the slice being gathered,
+// including one whose partition A was lost, cannot be decoded, and an error
+// wrapping errOrphanPartition is returned.
+func (h *H264Reader) addPartition(nalUnit *NalUnit) error {
+	p := h.partitioned
+	if p == nil {
+		return fmt.Errorf("%w: %s without partition A", errOrphanPartition, nalUnit.Type)
+	}
+	br := bits.NewBitReader(bytes.NewReader(nalUnit.RBSP()))
+	id, err := readSliceID(br, p.videoStream.SPS, p.header)
+	if err != nil {
+		return fmt.Errorf("could not parse %s: %w", nalUnit.Type, err)
+	}
+	if id != p.header.SliceID {
+		return fmt.Errorf("%w: %s of slice %d, not slice %d", errOrphanPartition, nalUnit.Type, id, p.header.SliceID)
+	}
+	if p.parts.C != nil || nalUnit.Type == NALTypeSlicePartB && p.parts.B != nil {
+		return fmt.Errorf("%w: unexpected %s of slice %d", errOrphanPartition, nalUnit.Type, id)
+	}
+	if nalUnit.Type == NALTypeSlicePartB {
+		p.parts.B = copyNalUnit(nalUnit)
+	} else {
+		p.parts.C = copyNalUnit(nalUnit)
+	}
+	return nil
+}
+
+// decodePartitioned decodes the slice being gathered, if any, now that no
+// more of its partitions are to be received. As this follows the NAL unit of
+// partition A, an error is reported for that NAL unit using discard. If
+// recovery is enabled, a panic during decoding is also reported as an error.
+func (h *H264Reader) decodePartitioned() error {
+	p := h.partitioned
+	if p == nil {
+		return nil
+	}
+	h.partitioned = nil
+	if h.intra != nil {
+		h.intra.wait()
+	}
+	err := func() (err error) {
+		if h.recover {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic while decoding: %v", r)
+				}
+			}()
+		}
+		return decodeSlice(p.videoStream, p.a, &h.arena, p.flags, p.order, &p.parts)
+	}()
+	if err != nil {
+		return h.discard(p.a, err)
+	}
+	return nil
+}
+
+// isPartitionBC returns true if the NAL unit is data partition B or C.
+func isPartitionBC(nalUnit *NalUnit) bool {
+	return nalUnit.Type == NALTypeSlicePartB || nalUnit.Type == NALTypeSlicePartC
+}
+
+var (
+	errMissingPartition = errors.New("data partition not received")
+	errOrphanPartition  = errors.New("data partition does not belong to a slice")
+)
@@ -0,0 +1,168 @@
+/*
+NAME
+  partition_test.go
+
+DESCRIPTION
+  partition_test.go provides testing for functionality provided in
+  partition.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// partitionA returns a data partition A NAL unit of a P slice with the given
+// slice_id, skipping the single macroblock of the picture given by testSPS and
+// testPPS.
+func partitionA(sliceID int) []byte {
+	return append([]byte{0x40 | byte(NALTypeSlicePartA)}, binToSlice(
+		ueBits(0)+"00110 1 0001"+ // first_mb_in_slice, slice_type, pic_parameter_set_id, frame_num.
+			"0 0 0 1 010"+ // Override, modification and marking flags, slice_qp_delta, deblocking.
+			ueBits(sliceID)+
+			"010"+ // mb_skip_run.
+			"1", // rbsp_stop_one_bit.
+	)...)
+}
+
+// partitionBC returns a data partition B or C NAL unit, of the given type,
+// with the given slice_id and no slice data.
+func partitionBC(typ NALType, sliceID int) []byte {
+	return append([]byte{0x40 | byte(typ)}, binToSlice(ueBits(sliceID)+"1")...)
+}
+
+func TestReadPartitionHeader(t *testing.T) {
+	sps := &SPS{PicWidthInMbsMinus1: 1, PicHeightInMapUnitsMinus1: 1, FrameMbsOnly: true}
+	tests := []struct {
+		bits      string
+		separate  bool
+		redundant bool
+		mbaff     bool
+		want      PartitionHeader
+		wantErr   bool
+	}{
+		{bits: ueBits(3), want: PartitionHeader{SliceID: 3}},
+		{bits: ueBits(2) + "10", separate: true, want: PartitionHeader{SliceID: 2, ColorPlaneID: 2}},
+		{bits: ueBits(1) + ueBits(5), redundant: true, want: PartitionHeader{SliceID: 1, RedundantPicCnt: 5}},
+		{bits: ueBits(1) + "01" + ueBits(2), separate: true, redundant: true, want: PartitionHeader{SliceID: 1, ColorPlaneID: 1, RedundantPicCnt: 2}},
+		{bits: ueBits(4), wantErr: true},
+		{bits: ueBits(1), mbaff: true, want: PartitionHeader{SliceID: 1}},
+		{bits: ueBits(2), mbaff: true, wantErr: true},
+	}
+
+	for i, test := range tests {
+		s := *sps
+		s.UseSeparateColorPlane = test.separate
+		s.MBAdaptiveFrameField = test.mbaff
+		s.FrameMbsOnly = !test.mbaff
+		if test.mbaff {
+			s.PicHeightInMapUnitsMinus1 = 0
+		}
+		pps := &PPS{RedundantPicCntPresent: test.redundant}
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.bits)))
+		got, err := readPartitionHeader(br, &s, pps, &SliceHeader{})
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, got, test.want)
+		}
+	}
+}
+
+// TestDataPartitions checks that data partitions B and C are associated with
+// their partition A, and that partitions that cannot be are rejected.
+func TestDataPartitions(t *testing.T) {
+	a := partitionA(0)
+	b := partitionBC(NALTypeSlicePartB, 0)
+	c := partitionBC(NALTypeSlicePartC, 0)
+
+	tests := []struct {
+		nalUnits  [][]byte
+		wantB     []bool // Whether partition B was received, for each slice decoded.
+		wantC     []bool
+		wantErr   error
+		wantErrAt bool // Whether any error is expected, if wantErr is nil.
+	}{
+		{nalUnits: [][]byte{a, b, c}, wantB: []bool{true}, wantC: []bool{true}},
+		{nalUnits: [][]byte{a}, wantB: []bool{false}, wantC: []bool{false}},
+		{nalUnits: [][]byte{a, c}, wantB: []bool{false}, wantC: []bool{true}},
+		{nalUnits: [][]byte{a, b, a, c, testSPS}, wantB: []bool{true, false}, wantC: []bool{false, true}},
+		{nalUnits: [][]byte{b}, wantErr: errOrphanPartition},
+		{nalUnits: [][]byte{a, c, b}, wantB: []bool{false}, wantC: []bool{true}, wantErr: errOrphanPartition},
+		{nalUnits: [][]byte{a, partitionBC(NALTypeSlicePartB, 1)}, wantErrAt: true},
+	}
+
+	for i, test := range tests {
+		r, err := NewH264Reader(bytes.NewReader(annexB(test.nalUnits...)), WithSPS(testSPS), WithPPS(testPPS))
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader", err)
+		}
+		err = r.Start()
+		if test.wantErr != nil && !errors.Is(err, test.wantErr) || test.wantErr == nil && (err != nil) != test.wantErrAt {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.wantErr)
+			continue
+		}
+		if test.wantB == nil {
+			continue
+		}
+		// Decoding stops at an error, so finish with any slice being gathered.
+		if err != nil {
+			err = r.decodePartitioned()
+			if err != nil {
+				t.Fatalf("did not expect error: %v from decodePartitioned for test: %d", err, i)
+			}
+		}
+
+		slices := r.VideoStreams[0].Slices
+		if len(slices) != len(test.wantB) {
+			t.Errorf("did not get expected number of slices for test: %d\nGot: %d\nWant: %d", i, len(slices), len(test.wantB))
+			continue
+		}
+		for j, s := range slices {
+			if s.Partitions == nil || (s.Partitions.B != nil) != test.wantB[j] || (s.Partitions.C != nil) != test.wantC[j] {
+				t.Errorf("did not get expected partitions for test: %d slice: %d\nGot: %+v", i, j, s.Partitions)
+				continue
+			}
+			for _, intra := range []bool{true, false} {
+				want := test.wantB[j]
+				if !intra {
+					want = test.wantC[j]
+				}
+				br, err := s.Data.residualReader(intra)
+				if want && (err != nil || br.Off() != 1) {
+					t.Errorf("did not get expected residual reader for test: %d slice: %d intra: %v\nGot: %v", i, j, intra, err)
+				}
+				if !want && !errors.Is(err, errMissingPartition) {
+					t.Errorf("did not get expected error for test: %d slice: %d intra: %v\nGot: %v\nWant: %v", i, j, intra, err, errMissingPartition)
+				}
+			}
+		}
+	}
+}
+
+// TestOrphanPartitionRecovery checks that a partition whose partition A was
+// lost is discarded when recovery is enabled.
+func TestOrphanPartitionRecovery(t *testing.T) {
+	stream := annexB(partitionBC(NALTypeSlicePartB, 0), partitionA(0), partitionBC(NALTypeSlicePartC, 0))
+	r, err := NewH264Reader(bytes.NewReader(stream), WithSPS(testSPS), WithPPS(testPPS), WithRecovery())
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = r.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+	if r.Discarded() != 1 || len(r.VideoStreams[0].Slices) != 1 {
+		t.Errorf("did not get expected result\nDiscarded: %d\nSlices: %d", r.Discarded(), len(r.VideoStreams[0].Slices))
+	}
+}
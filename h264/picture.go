@@ -0,0 +1,70 @@
+/*
+NAME
+  picture.go
+
+DESCRIPTION
+  picture.go provides the dimensions of decoded pictures, being either the full
+  decoded picture or the conformance (cropping) window given in the SPS.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "image"
+
+// FullPicture returns the bounds of the full decoded picture in luma samples,
+// including any macroblocks outside of the conformance window, as given by
+// equations 7-13 to 7-18.
+func (s *SPS) FullPicture() image.Rectangle {
+	frameHeightInMbs := (s.PicHeightInMapUnitsMinus1 + 1) * (2 - flagVal(s.FrameMbsOnly))
+	return image.Rect(0, 0, (s.PicWidthInMbsMinus1+1)*16, frameHeightInMbs*16)
+}
+
+// ConformanceWindow returns the bounds of the cropped output picture in luma
+// samples, within the full decoded picture, using the frame cropping offsets
+// and equations 7-19 to 7-22. If frame cropping is not used this is the full
+// picture.
+func (s *SPS) ConformanceWindow() image.Rectangle {
+	full := s.FullPicture()
+	if !s.FrameCropping {
+		return full
+	}
+
+	cropUnitX, cropUnitY := 1, 2-flagVal(s.FrameMbsOnly)
+	if s.ChromaArrayType() != chromaMonochrome {
+		cropUnitX = SubWidthC(s)
+		cropUnitY *= SubHeightC(s)
+	}
+	return image.Rect(
+		cropUnitX*s.FrameCropLeftOffset,
+		cropUnitY*s.FrameCropTopOffset,
+		full.Max.X-cropUnitX*s.FrameCropRightOffset,
+		full.Max.Y-cropUnitY*s.FrameCropBottomOffset,
+	).Intersect(full)
+}
+
+// PictureSize returns the width and height in luma samples of the cropped
+// output picture, i.e. the display resolution, taking into account
+// frame_mbs_only_flag, the chroma format and the frame cropping offsets. See
+// ConformanceWindow.
+func (s *SPS) PictureSize() (w, h int) {
+	r := s.ConformanceWindow()
+	return r.Dx(), r.Dy()
+}
+
+// OutputBounds returns the region of decoded pictures that is output for the
+// most recent SPS. This is the conformance window unless WithFullPicture has
+// been used, in which case it is the full decoded picture including padding
+// macroblocks. An empty rectangle is returned if no SPS has been decoded.
+func (h *H264Reader) OutputBounds() image.Rectangle {
+	sps := h.ParameterSets.last
+	if sps == nil {
+		return image.Rectangle{}
+	}
+	if h.fullPicture {
+		return sps.FullPicture()
+	}
+	return sps.ConformanceWindow()
+}
@@ -0,0 +1,194 @@
+/*
+NAME
+  picture_test.go
+
+DESCRIPTION
+  picture_test.go provides testing for functionality provided in picture.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// TestConformanceWindow checks the full picture and conformance window bounds
+// derived from SPS fields.
+func TestConformanceWindow(t *testing.T) {
+	tests := []struct {
+		sps    SPS
+		full   image.Rectangle
+		window image.Rectangle
+	}{
+		// 1920x1080 4:2:0 progressive, cropped from 1920x1088.
+		{
+			sps: SPS{
+				ChromaFormat:              chroma420,
+				PicWidthInMbsMinus1:       119,
+				PicHeightInMapUnitsMinus1: 67,
+				FrameMbsOnly:              true,
+				FrameCropping:             true,
+				FrameCropBottomOffset:     4,
+			},
+			full:   image.Rect(0, 0, 1920, 1088),
+			window: image.Rect(0, 0, 1920, 1080),
+		},
+		// Interlaced 4:2:0 with map units being field macroblock pairs.
+		{
+			sps: SPS{
+				ChromaFormat:              chroma420,
+				PicWidthInMbsMinus1:       44,
+				PicHeightInMapUnitsMinus1: 17,
+				FrameCropping:             true,
+				FrameCropLeftOffset:       1,
+				FrameCropTopOffset:        1,
+			},
+			full:   image.Rect(0, 0, 720, 576),
+			window: image.Rect(2, 4, 720, 576),
+		},
+		// Monochrome, cropping in units of luma samples.
+		{
+			sps: SPS{
+				ChromaFormat:              chromaMonochrome,
+				PicWidthInMbsMinus1:       0,
+				PicHeightInMapUnitsMinus1: 0,
+				FrameMbsOnly:              true,
+				FrameCropping:             true,
+				FrameCropRightOffset:      3,
+			},
+			full:   image.Rect(0, 0, 16, 16),
+			window: image.Rect(0, 0, 13, 16),
+		},
+		// No cropping.
+		{
+			sps:    SPS{ChromaFormat: chroma422, PicWidthInMbsMinus1: 1, PicHeightInMapUnitsMinus1: 1, FrameMbsOnly: true},
+			full:   image.Rect(0, 0, 32, 32),
+			window: image.Rect(0, 0, 32, 32),
+		},
+	}
+
+	for i, test := range tests {
+		if got := test.sps.FullPicture(); got != test.full {
+			t.Errorf("did not get expected full picture for test: %d\nGot: %v\nWant: %v", i, got, test.full)
+		}
+		if got := test.sps.ConformanceWindow(); got != test.window {
+			t.Errorf("did not get expected conformance window for test: %d\nGot: %v\nWant: %v", i, got, test.window)
+		}
+	}
+}
+
+// TestOutputBounds checks that OutputBounds respects WithFullPicture.
+func TestOutputBounds(t *testing.T) {
+	for _, full := range []bool{false, true} {
+		var opts []Option
+		if full {
+			opts = append(opts, WithFullPicture())
+		}
+		r, err := NewH264Reader(bytes.NewReader(annexB(testSPS)), opts...)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader", err)
+		}
+		if got := r.OutputBounds(); !got.Empty() {
+			t.Errorf("expected empty bounds before SPS, got: %v", got)
+		}
+		if err := r.Start(); err != nil {
+			t.Fatalf("did not expect error: %v from Start", err)
+		}
+		sps := r.ParameterSets.SPS[0]
+		want := sps.ConformanceWindow()
+		if full {
+			want = sps.FullPicture()
+		}
+		if got := r.OutputBounds(); got != want {
+			t.Errorf("did not get expected bounds with full picture: %v\nGot: %v\nWant: %v", full, got, want)
+		}
+	}
+}
+
+// TestPictureSize checks the display resolution derived from SPS fields for
+// each chroma format.
+func TestPictureSize(t *testing.T) {
+	tests := []struct {
+		sps  SPS
+		w, h int
+	}{
+		// 1920x1080 4:2:0 progressive.
+		{
+			sps: SPS{
+				ChromaFormat:              chroma420,
+				PicWidthInMbsMinus1:       119,
+				PicHeightInMapUnitsMinus1: 67,
+				FrameMbsOnly:              true,
+				FrameCropping:             true,
+				FrameCropBottomOffset:     4,
+			},
+			w: 1920,
+			h: 1080,
+		},
+		// 1920x1080 4:2:0 interlaced, vertical crop units being 4 lines.
+		{
+			sps: SPS{
+				ChromaFormat:              chroma420,
+				PicWidthInMbsMinus1:       119,
+				PicHeightInMapUnitsMinus1: 33,
+				FrameCropping:             true,
+				FrameCropBottomOffset:     2,
+			},
+			w: 1920,
+			h: 1080,
+		},
+		// 4:2:2, horizontal crop units being 2 samples, vertical 1.
+		{
+			sps: SPS{
+				ChromaFormat:              chroma422,
+				PicWidthInMbsMinus1:       1,
+				PicHeightInMapUnitsMinus1: 1,
+				FrameMbsOnly:              true,
+				FrameCropping:             true,
+				FrameCropRightOffset:      2,
+				FrameCropBottomOffset:     2,
+			},
+			w: 28,
+			h: 30,
+		},
+		// 4:4:4, crop units being 1 sample.
+		{
+			sps: SPS{
+				ChromaFormat:              chroma444,
+				PicWidthInMbsMinus1:       1,
+				PicHeightInMapUnitsMinus1: 1,
+				FrameMbsOnly:              true,
+				FrameCropping:             true,
+				FrameCropLeftOffset:       1,
+				FrameCropTopOffset:        3,
+			},
+			w: 31,
+			h: 29,
+		},
+		// Monochrome.
+		{
+			sps: SPS{
+				ChromaFormat:              chromaMonochrome,
+				PicWidthInMbsMinus1:       0,
+				PicHeightInMapUnitsMinus1: 0,
+				FrameMbsOnly:              true,
+				FrameCropping:             true,
+				FrameCropRightOffset:      3,
+			},
+			w: 13,
+			h: 16,
+		},
+	}
+
+	for i, test := range tests {
+		w, h := test.sps.PictureSize()
+		if w != test.w || h != test.h {
+			t.Errorf("did not get expected picture size for test: %d\nGot: %dx%d\nWant: %dx%d", i, w, h, test.w, test.h)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+/*
+NAME
+  poc.go
+
+DESCRIPTION
+  poc.go provides the decoding process for picture order count of section
+  8.2.1, giving the order in which decoded pictures are output, for each of
+  pic_order_cnt_type 0, 1 and 2.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// PictureOrder holds the picture order counts of a picture, derived by section
+// 8.2.1. For a field only the count of that field is derived, the other being
+// 0.
+type PictureOrder struct {
+	TopFieldOrderCnt    int
+	BottomFieldOrderCnt int
+	PicOrderCnt         int // PicOrderCnt of the picture, see equation 8-1.
+}
+
+// newPictureOrder returns the PictureOrder of a picture with the given
+// structure and field order counts.
+func newPictureOrder(s PictureStructure, top, bottom int) PictureOrder {
+	o := PictureOrder{TopFieldOrderCnt: top, BottomFieldOrderCnt: bottom}
+	switch s {
+	case StructureFrame:
+		o.PicOrderCnt = top
+		if bottom < top {
+			o.PicOrderCnt = bottom
+		}
+	case StructureTopField:
+		o.BottomFieldOrderCnt = 0
+		o.PicOrderCnt = top
+	case StructureBottomField:
+		o.TopFieldOrderCnt = 0
+		o.PicOrderCnt = bottom
+	}
+	return o
+}
+
+// pocDecoder derives the picture order counts of pictures in decoding order,
+// holding the state carried from one picture to the next.
+type pocDecoder struct {
+	// State of the previous reference picture, for pic_order_cnt_type 0.
+	prevPicOrderCntMsb int
+	prevPicOrderCntLsb int
+
+	// State of the previous picture, for pic_order_cnt_type 1 and 2.
+	prevFrameNumOffset int
+	prevFrameNum       int
+
+	current PictureOrder // Order of the picture being decoded.
+}
+
+// decode derives the PictureOrder of the picture with first slice header h,
+// using the active SPS, and updates the state carried to the next picture.
+// When h includes memory_management_control_operation 5, the returned order is
+// that after decoding of the picture, relative to which the following
+// pictures are ordered.
+func (d *pocDecoder) decode(sps *SPS, h *SliceHeader) (PictureOrder, error) {
+	var top, bottom int
+	switch sps.PicOrderCountType {
+	case 0:
+		top, bottom = d.decodeType0(sps, h)
+	case 1:
+		top, bottom = d.decodeType1(sps, h)
+	case 2:
+		top, bottom = d.decodeType2(sps, h)
+	default:
+		return PictureOrder{}, fmt.Errorf("invalid pic_order_cnt_type %d", sps.PicOrderCountType)
+	}
+	o := newPictureOrder(h.Structure(), top, bottom)
+
+	if hasMMCO5(h) {
+		// After decoding, the picture's order counts are made relative to
+		// itself (8.2.1), and frame_num is inferred to be 0 (7.4.3).
+		o.TopFieldOrderCnt -= o.PicOrderCnt
+		o.BottomFieldOrderCnt -= o.PicOrderCnt
+		o = newPictureOrder(h.Structure(), o.TopFieldOrderCnt, o.BottomFieldOrderCnt)
+		d.prevFrameNumOffset, d.prevFrameNum = 0, 0
+		if h.IsReference() {
+			d.prevPicOrderCntMsb, d.prevPicOrderCntLsb = 0, 0
+			if h.Structure() != StructureBottomField {
+				d.prevPicOrderCntLsb = o.TopFieldOrderCnt
+			}
+		}
+	}
+	d.current = o
+	return o, nil
+}
+
+// decodeType0 derives the field order counts of the picture for
+// pic_order_cnt_type 0 (8.2.1.1), in which they are coded by their least
+// significant bits in each slice header.
+func (d *pocDecoder) decodeType0(sps *SPS, h *SliceHeader) (top, bottom int) {
+	if h.IdrPic {
+		d.prevPicOrderCntMsb, d.prevPicOrderCntLsb = 0, 0
+	}
+	maxPicOrderCntLsb := 1 << uint(sps.Log2MaxPicOrderCntLSBMin4+4)
+	lsb := h.PicOrderCntLsb
+	msb := d.prevPicOrderCntMsb
+	switch {
+	case lsb < d.prevPicOrderCntLsb && d.prevPicOrderCntLsb-lsb >= maxPicOrderCntLsb/2:
+		msb += maxPicOrderCntLsb
+	case lsb > d.prevPicOrderCntLsb && lsb-d.prevPicOrderCntLsb > maxPicOrderCntLsb/2:
+		msb -= maxPicOrderCntLsb
+	}
+
+	switch h.Structure() {
+	case StructureFrame:
+		top = msb + lsb
+		bottom = top + h.DeltaPicOrderCntBottom
+	case StructureTopField:
+		top = msb + lsb
+	case StructureBottomField:
+		bottom = msb + lsb
+	}
+	if h.IsReference() {
+		d.prevPicOrderCntMsb, d.prevPicOrderCntLsb = msb, lsb
+	}
+	return top, bottom
+}
+
+// frameNumOffset returns FrameNumOffset of the picture, for
+// pic_order_cnt_type 1 and 2, and updates the state carried to the next
+// picture.
+func (d *pocDecoder) frameNumOffset(sps *SPS, h *SliceHeader) int {
+	offset := d.prevFrameNumOffset
+	switch {
+	case h.IdrPic:
+		offset = 0
+	case d.prevFrameNum > h.FrameNum:
+		offset += 1 << uint(sps.Log2MaxFrameNumMinus4+4)
+	}
+	d.prevFrameNumOffset, d.prevFrameNum = offset, h.FrameNum
+	return offset
+}
+
+// decodeType1 derives the field order counts of the picture for
+// pic_order_cnt_type 1 (8.2.1.2), in which they follow the expected cycle of
+// reference pictures given by the SPS, with deltas coded in slice headers.
+func (d *pocDecoder) decodeType1(sps *SPS, h *SliceHeader) (top, bottom int) {
+	n := sps.NumRefFramesInPicOrderCntCycle
+	var absFrameNum int
+	if n != 0 {
+		absFrameNum = d.frameNumOffset(sps, h) + h.FrameNum
+	} else {
+		d.frameNumOffset(sps, h)
+	}
+	if !h.IsReference() && absFrameNum > 0 {
+		absFrameNum--
+	}
+
+	var expected int
+	if absFrameNum > 0 {
+		var expectedDeltaPerCycle int
+		for _, o := range sps.OffsetForRefFrameList {
+			expectedDeltaPerCycle += o
+		}
+		cycleCnt := (absFrameNum - 1) / n
+		frameNumInCycle := (absFrameNum - 1) % n
+		expected = cycleCnt * expectedDeltaPerCycle
+		for i := 0; i <= frameNumInCycle; i++ {
+			expected += sps.OffsetForRefFrameList[i]
+		}
+	}
+	if !h.IsReference() {
+		expected += sps.OffsetForNonRefPic
+	}
+
+	var delta [2]int
+	copy(delta[:], h.DeltaPicOrderCnt)
+	switch h.Structure() {
+	case StructureFrame:
+		top = expected + delta[0]
+		bottom = top + sps.OffsetForTopToBottomField + delta[1]
+	case StructureTopField:
+		top = expected + delta[0]
+	case StructureBottomField:
+		bottom = expected + sps.OffsetForTopToBottomField + delta[0]
+	}
+	return top, bottom
+}
+
+// decodeType2 derives the field order counts of the picture for
+// pic_order_cnt_type 2 (8.2.1.3), in which output order is decoding order.
+func (d *pocDecoder) decodeType2(sps *SPS, h *SliceHeader) (top, bottom int) {
+	offset := d.frameNumOffset(sps, h)
+	var poc int
+	switch {
+	case h.IdrPic:
+	case !h.IsReference():
+		poc = 2*(offset+h.FrameNum) - 1
+	default:
+		poc = 2 * (offset + h.FrameNum)
+	}
+	return poc, poc
+}
+
+// hasMMCO5 returns true if the slice header includes
+// memory_management_control_operation 5.
+func hasMMCO5(h *SliceHeader) bool {
+	for _, op := range h.MemoryManagementOps {
+		if op.MemoryManagementControlOperation == 5 {
+			return true
+		}
+	}
+	return false
+}
+
+// pictureOrder returns the PictureOrder of the picture to which the slice
+// NAL unit belongs, using the active SPS and PPS. It is derived from the
+// header of the first slice of each picture, and that of the picture
+// already being decoded returned for its other slices.
+func (h *H264Reader) pictureOrder(sps *SPS, pps *PPS, nalUnit *NalUnit) (PictureOrder, error) {
+	if !startsPicture(nalUnit) {
+		return h.poc.current, nil
+	}
+	br := bits.NewBitReader(bytes.NewReader(nalUnit.RBSP()))
+	header, err := readSliceHeader(br, nalUnit, sps, pps)
+	if err != nil {
+		return PictureOrder{}, fmt.Errorf("could not parse slice: %w", withBitPos(br, err))
+	}
+	return h.poc.decode(sps, header)
+}
@@ -0,0 +1,208 @@
+/*
+NAME
+  poc_test.go
+
+DESCRIPTION
+  poc_test.go provides testing for functionality provided in poc.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+// pocHeader returns the header of the first slice of a picture, with the
+// given nal_ref_idc, frame_num and pic_order_cnt_lsb, being an IDR picture if
+// idr is true.
+func pocHeader(idr bool, refIdc, frameNum, lsb int) SliceHeader {
+	return SliceHeader{IdrPic: idr, NalRefIdc: refIdc, FrameNum: frameNum, PicOrderCntLsb: lsb}
+}
+
+// withMMCO5 returns h with memory_management_control_operation 5.
+func withMMCO5(h SliceHeader) SliceHeader {
+	h.AdaptiveRefPicMarkingModeFlag = true
+	h.MemoryManagementOps = []MemoryManagementOp{{MemoryManagementControlOperation: 5}}
+	return h
+}
+
+// withField returns h as a field, the bottom field if bottom is true.
+func withField(h SliceHeader, bottom bool) SliceHeader {
+	h.FieldPic = true
+	h.BottomField = bottom
+	return h
+}
+
+// frameOrder returns the PictureOrder of a frame with equal field order
+// counts.
+func frameOrder(poc int) PictureOrder {
+	return PictureOrder{TopFieldOrderCnt: poc, BottomFieldOrderCnt: poc, PicOrderCnt: poc}
+}
+
+func TestPictureOrder(t *testing.T) {
+	type0 := &SPS{PicOrderCountType: 0} // MaxPicOrderCntLsb is 16.
+	type1 := &SPS{
+		PicOrderCountType:              1,
+		OffsetForNonRefPic:             -1,
+		OffsetForTopToBottomField:      1,
+		NumRefFramesInPicOrderCntCycle: 2,
+		OffsetForRefFrameList:          []int{2, 4},
+	}
+	type2 := &SPS{PicOrderCountType: 2} // MaxFrameNum is 16.
+
+	withBottomDelta := pocHeader(false, 1, 3, 12)
+	withBottomDelta.DeltaPicOrderCntBottom = -1
+	withDeltas := pocHeader(false, 1, 3, 0)
+	withDeltas.DeltaPicOrderCnt = []int{1, -2}
+
+	tests := []struct {
+		name    string
+		sps     *SPS
+		headers []SliceHeader
+		want    []PictureOrder
+	}{
+		{
+			name: "type 0 frames",
+			sps:  type0,
+			headers: []SliceHeader{
+				pocHeader(true, 1, 0, 0),
+				pocHeader(false, 1, 1, 8),
+				pocHeader(false, 0, 2, 4),
+				withBottomDelta,
+				pocHeader(false, 1, 4, 0),  // Wraps forward.
+				pocHeader(false, 0, 5, 14), // Before the previous reference picture.
+				pocHeader(false, 1, 5, 4),
+				pocHeader(true, 1, 0, 6),
+			},
+			want: []PictureOrder{
+				frameOrder(0),
+				frameOrder(8),
+				frameOrder(4),
+				{TopFieldOrderCnt: 12, BottomFieldOrderCnt: 11, PicOrderCnt: 11},
+				frameOrder(16),
+				frameOrder(14),
+				frameOrder(20),
+				frameOrder(6),
+			},
+		},
+		{
+			name: "type 0 fields and memory_management_control_operation 5",
+			sps:  type0,
+			headers: []SliceHeader{
+				withField(pocHeader(true, 1, 0, 0), false),
+				withField(pocHeader(false, 1, 0, 1), true),
+				pocHeader(false, 1, 1, 6),
+				withMMCO5(pocHeader(false, 1, 2, 2)),
+				pocHeader(false, 1, 0, 4),
+				withMMCO5(withField(pocHeader(false, 1, 1, 9), true)),
+				pocHeader(false, 1, 2, 3),
+			},
+			want: []PictureOrder{
+				{TopFieldOrderCnt: 0, PicOrderCnt: 0},
+				{BottomFieldOrderCnt: 1, PicOrderCnt: 1},
+				frameOrder(6),
+				frameOrder(0),
+				frameOrder(4),
+				{BottomFieldOrderCnt: 0, PicOrderCnt: 0},
+				frameOrder(3),
+			},
+		},
+		{
+			name: "type 1",
+			sps:  type1,
+			headers: []SliceHeader{
+				pocHeader(true, 1, 0, 0),
+				pocHeader(false, 1, 1, 0),
+				pocHeader(false, 0, 2, 0),
+				pocHeader(false, 1, 2, 0),
+				withDeltas,
+				withField(pocHeader(false, 1, 4, 0), false),
+				withField(pocHeader(false, 1, 4, 0), true),
+				withMMCO5(pocHeader(false, 1, 5, 0)),
+				pocHeader(false, 1, 1, 0),
+			},
+			want: []PictureOrder{
+				{TopFieldOrderCnt: 0, BottomFieldOrderCnt: 1, PicOrderCnt: 0},
+				{TopFieldOrderCnt: 2, BottomFieldOrderCnt: 3, PicOrderCnt: 2},
+				{TopFieldOrderCnt: 1, BottomFieldOrderCnt: 2, PicOrderCnt: 1},
+				{TopFieldOrderCnt: 6, BottomFieldOrderCnt: 7, PicOrderCnt: 6},
+				{TopFieldOrderCnt: 9, BottomFieldOrderCnt: 8, PicOrderCnt: 8},
+				{TopFieldOrderCnt: 12, PicOrderCnt: 12},
+				{BottomFieldOrderCnt: 13, PicOrderCnt: 13},
+				{TopFieldOrderCnt: 0, BottomFieldOrderCnt: 1, PicOrderCnt: 0},
+				{TopFieldOrderCnt: 2, BottomFieldOrderCnt: 3, PicOrderCnt: 2},
+			},
+		},
+		{
+			name: "type 2",
+			sps:  type2,
+			headers: []SliceHeader{
+				pocHeader(true, 1, 0, 0),
+				pocHeader(false, 1, 1, 0),
+				pocHeader(false, 0, 2, 0),
+				pocHeader(false, 1, 2, 0),
+				pocHeader(false, 1, 15, 0),
+				pocHeader(false, 1, 0, 0), // Wraps.
+				withField(pocHeader(false, 1, 1, 0), true),
+				withMMCO5(pocHeader(false, 1, 2, 0)),
+				pocHeader(false, 1, 1, 0),
+				pocHeader(true, 1, 0, 0),
+			},
+			want: []PictureOrder{
+				frameOrder(0),
+				frameOrder(2),
+				frameOrder(3),
+				frameOrder(4),
+				frameOrder(30),
+				frameOrder(32),
+				{BottomFieldOrderCnt: 34, PicOrderCnt: 34},
+				frameOrder(0),
+				frameOrder(2),
+				frameOrder(0),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var d pocDecoder
+		for i := range test.headers {
+			got, err := d.decode(test.sps, &test.headers[i])
+			if err != nil {
+				t.Fatalf("did not expect error: %v for test: %s picture: %d", err, test.name, i)
+			}
+			if got != test.want[i] {
+				t.Errorf("did not get expected result for test: %s picture: %d\nGot: %+v\nWant: %+v", test.name, i, got, test.want[i])
+			}
+		}
+	}
+}
+
+// TestSlicePictureOrder checks that each decoded slice is given the picture
+// order count of its picture.
+func TestSlicePictureOrder(t *testing.T) {
+	// testSPS uses pic_order_cnt_type 2.
+	stream := annexB(testSPS, testPPS, skipSlice(2, 1), skipSlice(0, 2), skipSlice(2, 2))
+	h, err := NewH264Reader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = h.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+
+	want := []int{2, 3, 4}
+	slices := h.VideoStreams[0].Slices
+	if len(slices) != len(want) {
+		t.Fatalf("did not get expected number of slices\nGot: %d\nWant: %d", len(slices), len(want))
+	}
+	for i, s := range slices {
+		if s.POC != frameOrder(want[i]) {
+			t.Errorf("did not get expected result for slice: %d\nGot: %+v\nWant: %+v", i, s.POC, frameOrder(want[i]))
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package h264
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"math"
 
 	"github.com/ausocean/h264decode/h264/bits"
-	"github.com/pkg/errors"
 )
 
 // import "strings"
@@ -38,132 +41,145 @@ type PPS struct {
 	Transform8x8Mode                  int
 	PicScalingMatrixPresent           bool
 	PicScalingListPresent             []bool
+	ScalingMatrix                     ScalingMatrix // Resolved, from the SPS if not present.
 	SecondChromaQpIndexOffset         int
 }
 
-func NewPPS(sps *SPS, rbsp []byte, showPacket bool) (*PPS, error) {
+func NewPPS(sps *SPS, rbsp []byte, showPacket bool) (_ *PPS, err error) {
 	logger.Printf("debug: PPS RBSP %d bytes %d bits == \n", len(rbsp), len(rbsp)*8)
-	logger.Printf("debug: \t%#v\n", rbsp[0:8])
-	pps := PPS{}
-	// TODO: give this io.Reader
-	br := bits.NewBitReader(nil)
+	logger.Printf("debug: \t%#v\n", rbsp)
+	br := bits.NewBitReader(bytes.NewReader(rbsp))
+	defer func() { err = withBitPos(br, err) }()
 
-	var err error
-	pps.ID, err = readUe(nil)
+	pps, err := readPPS(br, sps, rbsp)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse ID")
+		return nil, err
+	}
+	if showPacket {
+		debugPacket("PPS", pps)
+	}
+	return pps, nil
+}
+
+// readPPS reads the pic_parameter_set_rbsp with the given RBSP from br, using
+// the given SPS.
+func readPPS(br *bits.BitReader, sps *SPS, rbsp []byte) (_ *PPS, err error) {
+	pps := PPS{ScalingMatrix: sps.ScalingMatrix}
+	pps.ID, err = readUe(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse ID: %w", err)
 	}
 
-	pps.SPSID, err = readUe(nil)
+	pps.SPSID, err = readUe(br)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse SPS ID")
+		return nil, fmt.Errorf("could not parse SPS ID: %w", err)
 	}
 
 	b, err := br.ReadBits(1)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read EntropyCodingMode")
+		return nil, fmt.Errorf("could not read EntropyCodingMode: %w", err)
 	}
 	pps.EntropyCodingMode = int(b)
 
 	b, err = br.ReadBits(1)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read BottomFieldPicOrderInFramePresent")
+		return nil, fmt.Errorf("could not read BottomFieldPicOrderInFramePresent: %w", err)
 	}
 	pps.BottomFieldPicOrderInFramePresent = b == 1
 
-	pps.NumSliceGroupsMinus1, err = readUe(nil)
+	pps.NumSliceGroupsMinus1, err = readUe(br)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse NumSliceGroupsMinus1")
+		return nil, fmt.Errorf("could not parse NumSliceGroupsMinus1: %w", err)
 	}
 
 	if pps.NumSliceGroupsMinus1 > 0 {
-		pps.SliceGroupMapType, err = readUe(nil)
+		pps.SliceGroupMapType, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse SliceGroupMapType")
+			return nil, fmt.Errorf("could not parse SliceGroupMapType: %w", err)
 		}
 
 		if pps.SliceGroupMapType == 0 {
 			for iGroup := 0; iGroup <= pps.NumSliceGroupsMinus1; iGroup++ {
-				pps.RunLengthMinus1[iGroup], err = readUe(nil)
+				pps.RunLengthMinus1[iGroup], err = readUe(br)
 				if err != nil {
-					return nil, errors.Wrap(err, "could not parse RunLengthMinus1")
+					return nil, fmt.Errorf("could not parse RunLengthMinus1: %w", err)
 				}
 			}
 		} else if pps.SliceGroupMapType == 2 {
 			for iGroup := 0; iGroup < pps.NumSliceGroupsMinus1; iGroup++ {
-				pps.TopLeft[iGroup], err = readUe(nil)
+				pps.TopLeft[iGroup], err = readUe(br)
 				if err != nil {
-					return nil, errors.Wrap(err, "could not parse TopLeft[iGroup]")
+					return nil, fmt.Errorf("could not parse TopLeft[iGroup]: %w", err)
 				}
 				if err != nil {
-					return nil, errors.Wrap(err, "could not parse TopLeft[iGroup]")
+					return nil, fmt.Errorf("could not parse TopLeft[iGroup]: %w", err)
 				}
 
-				pps.BottomRight[iGroup], err = readUe(nil)
+				pps.BottomRight[iGroup], err = readUe(br)
 				if err != nil {
-					return nil, errors.Wrap(err, "could not parse BottomRight[iGroup]")
+					return nil, fmt.Errorf("could not parse BottomRight[iGroup]: %w", err)
 				}
 			}
 		} else if pps.SliceGroupMapType > 2 && pps.SliceGroupMapType < 6 {
 			b, err = br.ReadBits(1)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read SliceGroupChangeDirection")
+				return nil, fmt.Errorf("could not read SliceGroupChangeDirection: %w", err)
 			}
 			pps.SliceGroupChangeDirection = b == 1
 
-			pps.SliceGroupChangeRateMinus1, err = readUe(nil)
+			pps.SliceGroupChangeRateMinus1, err = readUe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not parse SliceGroupChangeRateMinus1")
+				return nil, fmt.Errorf("could not parse SliceGroupChangeRateMinus1: %w", err)
 			}
 		} else if pps.SliceGroupMapType == 6 {
-			pps.PicSizeInMapUnitsMinus1, err = readUe(nil)
+			pps.PicSizeInMapUnitsMinus1, err = readUe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not parse PicSizeInMapUnitsMinus1")
+				return nil, fmt.Errorf("could not parse PicSizeInMapUnitsMinus1: %w", err)
 			}
 
 			for i := 0; i <= pps.PicSizeInMapUnitsMinus1; i++ {
 				b, err = br.ReadBits(int(math.Ceil(math.Log2(float64(pps.NumSliceGroupsMinus1 + 1)))))
 				if err != nil {
-					return nil, errors.Wrap(err, "coult not read SliceGroupId")
+					return nil, fmt.Errorf("coult not read SliceGroupId: %w", err)
 				}
 				pps.SliceGroupId[i] = int(b)
 			}
 		}
 
 	}
-	pps.NumRefIdxL0DefaultActiveMinus1, err = readUe(nil)
+	pps.NumRefIdxL0DefaultActiveMinus1, err = readUe(br)
 	if err != nil {
 		return nil, errors.New("could not parse NumRefIdxL0DefaultActiveMinus1")
 	}
 
-	pps.NumRefIdxL1DefaultActiveMinus1, err = readUe(nil)
+	pps.NumRefIdxL1DefaultActiveMinus1, err = readUe(br)
 	if err != nil {
 		return nil, errors.New("could not parse NumRefIdxL1DefaultActiveMinus1")
 	}
 
 	b, err = br.ReadBits(1)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read WeightedPred")
+		return nil, fmt.Errorf("could not read WeightedPred: %w", err)
 	}
 	pps.WeightedPred = b == 1
 
 	b, err = br.ReadBits(2)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read WeightedBipred")
+		return nil, fmt.Errorf("could not read WeightedBipred: %w", err)
 	}
 	pps.WeightedBipred = int(b)
 
-	pps.PicInitQpMinus26, err = readSe(nil)
+	pps.PicInitQpMinus26, err = readSe(br)
 	if err != nil {
 		return nil, errors.New("could not parse PicInitQpMinus26")
 	}
 
-	pps.PicInitQsMinus26, err = readSe(nil)
+	pps.PicInitQsMinus26, err = readSe(br)
 	if err != nil {
 		return nil, errors.New("could not parse PicInitQsMinus26")
 	}
 
-	pps.ChromaQpIndexOffset, err = readSe(nil)
+	pps.ChromaQpIndexOffset, err = readSe(br)
 	if err != nil {
 		return nil, errors.New("could not parse ChromaQpIndexOffset")
 	}
@@ -177,19 +193,23 @@ func NewPPS(sps *SPS, rbsp []byte, showPacket bool) (*PPS, error) {
 		return nil, err
 	}
 
+	// When not present, second_chroma_qp_index_offset is inferred to be equal
+	// to chroma_qp_index_offset.
+	pps.SecondChromaQpIndexOffset = pps.ChromaQpIndexOffset
+
 	logger.Printf("debug: \tChecking for more PPS data")
-	if moreRBSPData(br) {
+	if moreRBSPData(br, rbsp) {
 		logger.Printf("debug: \tProcessing additional PPS data")
 
 		b, err = br.ReadBits(1)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read Transform8x8Mode")
+			return nil, fmt.Errorf("could not read Transform8x8Mode: %w", err)
 		}
 		pps.Transform8x8Mode = int(b)
 
 		b, err = br.ReadBits(1)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read PicScalingMatrixPresent")
+			return nil, fmt.Errorf("could not read PicScalingMatrixPresent: %w", err)
 		}
 		pps.PicScalingMatrixPresent = b == 1
 
@@ -198,42 +218,80 @@ func NewPPS(sps *SPS, rbsp []byte, showPacket bool) (*PPS, error) {
 			if sps.ChromaFormat != chroma444 {
 				v = 2
 			}
-			for i := 0; i < 6+(v*pps.Transform8x8Mode); i++ {
-				b, err = br.ReadBits(1)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not read PicScalingListPresent")
-				}
-				pps.PicScalingListPresent[i] = b == 1
-				if pps.PicScalingListPresent[i] {
-					if i < 6 {
-						scalingList(
-							br,
-							ScalingList4x4[i],
-							16,
-							DefaultScalingMatrix4x4[i])
-
-					} else {
-						scalingList(
-							br,
-							ScalingList8x8[i],
-							64,
-							DefaultScalingMatrix8x8[i-6])
-
-					}
-				}
+			var seq *ScalingMatrix
+			if sps.SeqScalingMatrixPresent {
+				seq = &sps.ScalingMatrix
 			}
-			pps.SecondChromaQpIndexOffset, err = readSe(nil)
+			pps.ScalingMatrix, pps.PicScalingListPresent, err = parseScalingMatrix(br, 6+v*pps.Transform8x8Mode, seq)
 			if err != nil {
-				return nil, errors.New("could not parse SecondChromaQpIndexOffset")
+				return nil, fmt.Errorf("could not parse PPS scaling matrix: %w", err)
 			}
 		}
-		moreRBSPData(br)
-		// rbspTrailingBits()
-	}
 
-	if showPacket {
-		debugPacket("PPS", pps)
+		pps.SecondChromaQpIndexOffset, err = readSe(br)
+		if err != nil {
+			return nil, errors.New("could not parse SecondChromaQpIndexOffset")
+		}
 	}
+
 	return &pps, nil
+}
 
+// Write writes the PPS to w as a NAL unit, without start code prefix, with
+// nal_ref_idc of 3. Syntax elements are written according to the presence
+// flags of the PPS. The optional trailing syntax elements, from
+// transform_8x8_mode_flag, are written only if the 8x8 transform is enabled,
+// a picture scaling matrix is present or second_chroma_qp_index_offset differs
+// from chroma_qp_index_offset.
+func (p *PPS) Write(w io.Writer) error {
+	return writeNalUnit(w, NALTypePPS, 3, func(w *rbspWriter) {
+		w.ue(p.ID)
+		w.ue(p.SPSID)
+		w.u(uint64(p.EntropyCodingMode), 1)
+		w.flag(p.BottomFieldPicOrderInFramePresent)
+		w.ue(p.NumSliceGroupsMinus1)
+		if p.NumSliceGroupsMinus1 > 0 {
+			w.ue(p.SliceGroupMapType)
+			switch p.SliceGroupMapType {
+			case 0:
+				for _, r := range p.RunLengthMinus1 {
+					w.ue(r)
+				}
+			case 2:
+				for iGroup := range p.TopLeft {
+					w.ue(p.TopLeft[iGroup])
+					w.ue(p.BottomRight[iGroup])
+				}
+			case 3, 4, 5:
+				w.flag(p.SliceGroupChangeDirection)
+				w.ue(p.SliceGroupChangeRateMinus1)
+			case 6:
+				w.ue(p.PicSizeInMapUnitsMinus1)
+				n := int(math.Ceil(math.Log2(float64(p.NumSliceGroupsMinus1 + 1))))
+				for _, id := range p.SliceGroupId {
+					w.u(uint64(id), n)
+				}
+			}
+		}
+		w.ue(p.NumRefIdxL0DefaultActiveMinus1)
+		w.ue(p.NumRefIdxL1DefaultActiveMinus1)
+		w.flag(p.WeightedPred)
+		w.u(uint64(p.WeightedBipred), 2)
+		w.se(p.PicInitQpMinus26)
+		w.se(p.PicInitQsMinus26)
+		w.se(p.ChromaQpIndexOffset)
+		w.flag(p.DeblockingFilterControlPresent)
+		w.flag(p.ConstrainedIntraPred)
+		w.flag(p.RedundantPicCntPresent)
+
+		if p.Transform8x8Mode == 0 && !p.PicScalingMatrixPresent && p.SecondChromaQpIndexOffset == p.ChromaQpIndexOffset {
+			return
+		}
+		w.u(uint64(p.Transform8x8Mode), 1)
+		w.flag(p.PicScalingMatrixPresent)
+		if p.PicScalingMatrixPresent {
+			writeScalingMatrix(w, &p.ScalingMatrix, p.PicScalingListPresent)
+		}
+		w.se(p.SecondChromaQpIndexOffset)
+	})
 }
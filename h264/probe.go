@@ -0,0 +1,72 @@
+/*
+NAME
+  probe.go
+
+DESCRIPTION
+  probe.go provides a per NAL unit summary of a stream, for listing by
+  analysis tools such as h264probe.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// NALInfo summarises a NAL unit of a stream.
+type NALInfo struct {
+	Index      int    `json:"index"`       // Index in the stream, counting from 0.
+	Offset     int64  `json:"offset"`      // Stream byte offset of the NAL unit header.
+	Size       int    `json:"size"`        // Size in bytes, excluding the start code prefix.
+	Type       string `json:"type"`        // Name of nal_unit_type as given in table 7-1.
+	RefIdc     int    `json:"ref_idc"`     // nal_ref_idc.
+	TemporalID int    `json:"temporal_id"` // Temporal layer of VCL NAL units, see WithMaxTemporalID.
+	SliceType  string `json:"slice_type"`  // "P", "B", "I", "SP" or "SI" for slices of the base layer.
+}
+
+// ReadNALInfo reads the Annex B byte stream from r and returns a summary of
+// each of its NAL units. Only NAL unit headers and the start of slice headers
+// are parsed.
+func ReadNALInfo(r io.Reader) ([]NALInfo, error) {
+	h, err := NewH264Reader(r)
+	if err != nil {
+		return nil, err
+	}
+	var info []NALInfo
+	for {
+		nalUnit, err := h.nextNalUnit()
+		if errors.Is(err, io.EOF) {
+			return info, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read NAL unit: %w", err)
+		}
+
+		n := NALInfo{
+			Index:  len(info),
+			Offset: nalUnit.Offset,
+			Size:   nalUnit.NumBytes,
+			Type:   nalUnit.Type.String(),
+			RefIdc: nalUnit.RefIdc,
+		}
+		if nalUnit.Type.IsVCL() {
+			n.TemporalID = h.temporalID(nalUnit)
+		}
+		h.skipTemporalLayer(nalUnit)
+
+		switch nalUnit.Type {
+		case NALTypeSliceNonIDRPicture, NALTypeSlicePartA, NALTypeSliceIDRPicture:
+			t, err := sliceType(nalUnit.RBSP())
+			if err != nil {
+				return nil, newParseError(nalUnit, err)
+			}
+			n.SliceType = sliceTypeMap[t]
+		}
+		info = append(info, n)
+	}
+}
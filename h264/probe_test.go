@@ -0,0 +1,43 @@
+/*
+NAME
+  probe_test.go
+
+DESCRIPTION
+  probe_test.go provides testing for functionality provided in probe.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestReadNALInfo checks the summary of the NAL units of a short stream.
+func TestReadNALInfo(t *testing.T) {
+	nonRef := []byte{0x01, 0x9a} // P slice with nal_ref_idc 0.
+	stream := annexB(testSPS, testPPS, testIDR, nonRef)
+
+	got, err := ReadNALInfo(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from ReadNALInfo", err)
+	}
+	want := []NALInfo{
+		{Index: 0, Offset: 4, Size: 6, Type: NALTypeSPS.String(), RefIdc: 3},
+		{Index: 1, Offset: 14, Size: 4, Type: NALTypePPS.String(), RefIdc: 3},
+		{Index: 2, Offset: 22, Size: 3, Type: NALTypeSliceIDRPicture.String(), RefIdc: 3, SliceType: "I"},
+		{Index: 3, Offset: 29, Size: 2, Type: NALTypeSliceNonIDRPicture.String(), TemporalID: 1, SliceType: "P"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("did not get expected result\nGot: %+v\nWant: %+v", got, want)
+	}
+
+	_, err = ReadNALInfo(bytes.NewReader(annexB([]byte{0x41})))
+	if err == nil {
+		t.Errorf("did not get expected error for truncated slice header")
+	}
+}
@@ -0,0 +1,247 @@
+/*
+NAME
+  profile.go
+
+DESCRIPTION
+  profile.go provides the Profile and Level types, derived from profile_idc,
+  level_idc and the constraint flags of an SPS as specified in Annex A, and
+  detection of the coding tools each profile permits.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "strconv"
+
+// Profile is a profile to which a coded video sequence conforms, as indicated
+// by profile_idc and the constraint flags of its SPS.
+type Profile int
+
+// Profiles, as defined in Annex A and, for the extension profiles, Annexes G,
+// H and I.
+const (
+	ProfileUnknown Profile = iota
+	ProfileBaseline
+	ProfileConstrainedBaseline
+	ProfileMain
+	ProfileExtended
+	ProfileHigh
+	ProfileProgressiveHigh
+	ProfileConstrainedHigh
+	ProfileHigh10
+	ProfileHigh10Intra
+	ProfileHigh422
+	ProfileHigh422Intra
+	ProfileHigh444Predictive
+	ProfileHigh444Intra
+	ProfileCAVLC444Intra
+	ProfileScalableBaseline
+	ProfileScalableHigh
+	ProfileScalableHighIntra
+	ProfileMultiviewHigh
+	ProfileStereoHigh
+	ProfileMFCHigh
+	ProfileMultiviewDepthHigh
+	ProfileEnhancedMultiviewDepthHigh
+	ProfileMFCDepthHigh
+)
+
+var profileNames = map[Profile]string{
+	ProfileBaseline:                   "Baseline",
+	ProfileConstrainedBaseline:        "Constrained Baseline",
+	ProfileMain:                       "Main",
+	ProfileExtended:                   "Extended",
+	ProfileHigh:                       "High",
+	ProfileProgressiveHigh:            "Progressive High",
+	ProfileConstrainedHigh:            "Constrained High",
+	ProfileHigh10:                     "High 10",
+	ProfileHigh10Intra:                "High 10 Intra",
+	ProfileHigh422:                    "High 4:2:2",
+	ProfileHigh422Intra:               "High 4:2:2 Intra",
+	ProfileHigh444Predictive:          "High 4:4:4 Predictive",
+	ProfileHigh444Intra:               "High 4:4:4 Intra",
+	ProfileCAVLC444Intra:              "CAVLC 4:4:4 Intra",
+	ProfileScalableBaseline:           "Scalable Baseline",
+	ProfileScalableHigh:               "Scalable High",
+	ProfileScalableHighIntra:          "Scalable High Intra",
+	ProfileMultiviewHigh:              "Multiview High",
+	ProfileStereoHigh:                 "Stereo High",
+	ProfileMFCHigh:                    "MFC High",
+	ProfileMultiviewDepthHigh:         "Multiview Depth High",
+	ProfileEnhancedMultiviewDepthHigh: "Enhanced Multiview Depth High",
+	ProfileMFCDepthHigh:               "MFC Depth High",
+}
+
+// String returns the name of the profile.
+func (p Profile) String() string {
+	if s, ok := profileNames[p]; ok {
+		return s
+	}
+	if p == ProfileUnknown {
+		return "unknown"
+	}
+	return "Profile(" + strconv.Itoa(int(p)) + ")"
+}
+
+// Intra returns true if the profile permits only intra coded pictures.
+func (p Profile) Intra() bool {
+	switch p {
+	case ProfileHigh10Intra, ProfileHigh422Intra, ProfileHigh444Intra, ProfileCAVLC444Intra, ProfileScalableHighIntra:
+		return true
+	}
+	return false
+}
+
+// CABAC returns true if the profile permits CABAC entropy coding.
+func (p Profile) CABAC() bool {
+	switch p {
+	case ProfileUnknown, ProfileBaseline, ProfileConstrainedBaseline, ProfileExtended, ProfileCAVLC444Intra, ProfileScalableBaseline:
+		return false
+	}
+	return true
+}
+
+// BSlices returns true if the profile permits B slices.
+func (p Profile) BSlices() bool {
+	switch p {
+	case ProfileUnknown, ProfileBaseline, ProfileConstrainedBaseline, ProfileConstrainedHigh:
+		return false
+	}
+	return !p.Intra()
+}
+
+// Interlaced returns true if the profile permits field and MBAFF coding.
+func (p Profile) Interlaced() bool {
+	switch p {
+	case ProfileUnknown, ProfileBaseline, ProfileConstrainedBaseline, ProfileProgressiveHigh, ProfileConstrainedHigh, ProfileScalableBaseline:
+		return false
+	}
+	return true
+}
+
+// Profile returns the profile indicated by profile_idc and the constraint
+// flags of the SPS, as given in Annex A, or ProfileUnknown.
+func (s *SPS) Profile() Profile {
+	switch s.ProfileIDC {
+	case 66:
+		if s.Constraint1 == 1 {
+			return ProfileConstrainedBaseline
+		}
+		return ProfileBaseline
+	case 77:
+		return ProfileMain
+	case 88:
+		return ProfileExtended
+	case 100:
+		switch {
+		case s.Constraint4 == 1 && s.Constraint5 == 1:
+			return ProfileConstrainedHigh
+		case s.Constraint4 == 1:
+			return ProfileProgressiveHigh
+		}
+		return ProfileHigh
+	case 110:
+		if s.Constraint3 == 1 {
+			return ProfileHigh10Intra
+		}
+		return ProfileHigh10
+	case 122:
+		if s.Constraint3 == 1 {
+			return ProfileHigh422Intra
+		}
+		return ProfileHigh422
+	case 244:
+		if s.Constraint3 == 1 {
+			return ProfileHigh444Intra
+		}
+		return ProfileHigh444Predictive
+	case 44:
+		return ProfileCAVLC444Intra
+	case 83:
+		return ProfileScalableBaseline
+	case 86:
+		if s.Constraint3 == 1 {
+			return ProfileScalableHighIntra
+		}
+		return ProfileScalableHigh
+	case 118:
+		return ProfileMultiviewHigh
+	case 128:
+		return ProfileStereoHigh
+	case 134:
+		return ProfileMFCHigh
+	case 135:
+		return ProfileMFCDepthHigh
+	case 138:
+		return ProfileMultiviewDepthHigh
+	case 139:
+		return ProfileEnhancedMultiviewDepthHigh
+	}
+	return ProfileUnknown
+}
+
+// hasChromaFormat returns true if an SPS with the given profile_idc carries
+// chroma_format_idc and the syntax elements following it in section
+// 7.3.2.1.1.
+func hasChromaFormat(profileIDC int) bool {
+	switch profileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		return true
+	}
+	return false
+}
+
+// Level is a level to which a coded video sequence conforms, as given in table
+// A-1. Its value is level_idc, i.e. ten times the level number, except for
+// Level1b.
+type Level int
+
+// Levels, as given in table A-1.
+const (
+	Level1  Level = 10
+	Level1b Level = 9
+	Level11 Level = 11
+	Level12 Level = 12
+	Level13 Level = 13
+	Level2  Level = 20
+	Level21 Level = 21
+	Level22 Level = 22
+	Level3  Level = 30
+	Level31 Level = 31
+	Level32 Level = 32
+	Level4  Level = 40
+	Level41 Level = 41
+	Level42 Level = 42
+	Level5  Level = 50
+	Level51 Level = 51
+	Level52 Level = 52
+	Level6  Level = 60
+	Level61 Level = 61
+	Level62 Level = 62
+)
+
+// String returns the level number, e.g. "3.1" or "1b".
+func (l Level) String() string {
+	if l == Level1b {
+		return "1b"
+	}
+	if l%10 == 0 {
+		return strconv.Itoa(int(l) / 10)
+	}
+	return strconv.Itoa(int(l)/10) + "." + strconv.Itoa(int(l)%10)
+}
+
+// Level returns the level indicated by level_idc and, for level 1b in the
+// Baseline, Main and Extended profiles, constraint_set3_flag (see section
+// A.3.1).
+func (s *SPS) Level() Level {
+	switch s.ProfileIDC {
+	case 66, 77, 88:
+		if s.LevelIDC == 11 && s.Constraint3 == 1 {
+			return Level1b
+		}
+	}
+	return Level(s.LevelIDC)
+}
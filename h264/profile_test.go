@@ -0,0 +1,127 @@
+/*
+NAME
+  profile_test.go
+
+DESCRIPTION
+  profile_test.go provides testing for functionality in profile.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+func TestProfileLevel(t *testing.T) {
+	tests := []struct {
+		sps         SPS
+		wantProfile Profile
+		wantLevel   Level
+		profileName string
+		levelName   string
+	}{
+		{
+			sps:         SPS{ProfileIDC: 66, LevelIDC: 30},
+			wantProfile: ProfileBaseline,
+			wantLevel:   Level3,
+			profileName: "Baseline",
+			levelName:   "3",
+		},
+		{
+			sps:         SPS{ProfileIDC: 66, Constraint1: 1, LevelIDC: 11, Constraint3: 1},
+			wantProfile: ProfileConstrainedBaseline,
+			wantLevel:   Level1b,
+			profileName: "Constrained Baseline",
+			levelName:   "1b",
+		},
+		{
+			sps:         SPS{ProfileIDC: 77, LevelIDC: 31},
+			wantProfile: ProfileMain,
+			wantLevel:   Level31,
+			profileName: "Main",
+			levelName:   "3.1",
+		},
+		{
+			sps:         SPS{ProfileIDC: 100, LevelIDC: 11, Constraint3: 1},
+			wantProfile: ProfileHigh,
+			wantLevel:   Level11,
+			profileName: "High",
+			levelName:   "1.1",
+		},
+		{
+			sps:         SPS{ProfileIDC: 100, Constraint4: 1, Constraint5: 1, LevelIDC: 9},
+			wantProfile: ProfileConstrainedHigh,
+			wantLevel:   Level1b,
+			profileName: "Constrained High",
+			levelName:   "1b",
+		},
+		{
+			sps:         SPS{ProfileIDC: 100, Constraint4: 1, LevelIDC: 40},
+			wantProfile: ProfileProgressiveHigh,
+			wantLevel:   Level4,
+			profileName: "Progressive High",
+			levelName:   "4",
+		},
+		{
+			sps:         SPS{ProfileIDC: 110, Constraint3: 1, LevelIDC: 52},
+			wantProfile: ProfileHigh10Intra,
+			wantLevel:   Level52,
+			profileName: "High 10 Intra",
+			levelName:   "5.2",
+		},
+		{
+			sps:         SPS{ProfileIDC: 244, LevelIDC: 62},
+			wantProfile: ProfileHigh444Predictive,
+			wantLevel:   Level62,
+			profileName: "High 4:4:4 Predictive",
+			levelName:   "6.2",
+		},
+		{
+			sps:         SPS{ProfileIDC: 1, LevelIDC: 30},
+			wantProfile: ProfileUnknown,
+			wantLevel:   Level3,
+			profileName: "unknown",
+			levelName:   "3",
+		},
+	}
+
+	for i, test := range tests {
+		p, l := test.sps.Profile(), test.sps.Level()
+		if p != test.wantProfile || l != test.wantLevel {
+			t.Errorf("did not get expected result for test: %d\nGot: %v, %v\nWant: %v, %v", i, p, l, test.wantProfile, test.wantLevel)
+		}
+		if p.String() != test.profileName || l.String() != test.levelName {
+			t.Errorf("did not get expected names for test: %d\nGot: %q, %q\nWant: %q, %q", i, p, l, test.profileName, test.levelName)
+		}
+	}
+}
+
+func TestProfileCapabilities(t *testing.T) {
+	tests := []struct {
+		profile                           Profile
+		intra, cabac, bSlices, interlaced bool
+	}{
+		{profile: ProfileConstrainedBaseline},
+		{profile: ProfileBaseline},
+		{profile: ProfileExtended, bSlices: true, interlaced: true},
+		{profile: ProfileMain, cabac: true, bSlices: true, interlaced: true},
+		{profile: ProfileHigh, cabac: true, bSlices: true, interlaced: true},
+		{profile: ProfileProgressiveHigh, cabac: true, bSlices: true},
+		{profile: ProfileConstrainedHigh, cabac: true},
+		{profile: ProfileHigh422Intra, intra: true, cabac: true, interlaced: true},
+		{profile: ProfileCAVLC444Intra, intra: true, interlaced: true},
+	}
+
+	for i, test := range tests {
+		p := test.profile
+		got := []bool{p.Intra(), p.CABAC(), p.BSlices(), p.Interlaced()}
+		want := []bool{test.intra, test.cabac, test.bSlices, test.interlaced}
+		for j := range got {
+			if got[j] != want[j] {
+				t.Errorf("did not get expected result for test: %d (%v)\nGot: %v\nWant: %v", i, p, got, want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/*
+NAME
+  purego_test.go
+
+DESCRIPTION
+  purego_test.go checks that this module, and its non-standard library
+  dependencies, are pure Go, i.e. use neither cgo nor unsafe, so that it may be
+  cross-compiled for embedded targets with CGO_ENABLED=0.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestPureGo uses go list to find every non-standard library package in the
+// dependency graph of this module, and checks that none has cgo files or
+// imports unsafe.
+func TestPureGo(t *testing.T) {
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not available")
+	}
+
+	const format = `{{if not .Standard}}{{.ImportPath}}|{{join .CgoFiles ","}}|{{join .Imports ","}}{{end}}`
+	cmd := exec.Command(goCmd, "list", "-deps", "-f", format, "github.com/ausocean/h264decode/...")
+
+	// Enable cgo so that cgo files are listed rather than ignored.
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=1")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("could not list dependencies: %v", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) != 3 {
+			t.Fatalf("unexpected go list output: %q", line)
+		}
+		pkg, cgoFiles, imports := fields[0], fields[1], fields[2]
+		if cgoFiles != "" {
+			t.Errorf("package %s uses cgo in: %s", pkg, cgoFiles)
+		}
+		for _, imp := range strings.Split(imports, ",") {
+			if imp == "unsafe" || imp == "C" {
+				t.Errorf("package %s imports %s", pkg, imp)
+			}
+		}
+	}
+}
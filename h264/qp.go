@@ -0,0 +1,65 @@
+/*
+NAME
+  qp.go
+
+DESCRIPTION
+  qp.go provides the derivation of the quantisation parameters of
+  macroblocks, QPY from the mb_qp_delta of each macroblock of a slice as
+  specified by section 7.4.5, and QP'Y and QP'C used in the scaling of
+  transform coefficients as specified by section 8.5.8.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+// mbQP holds the quantisation parameters of a macroblock used in the scaling
+// of its transform coefficients.
+type mbQP struct {
+	y int    // QP'Y.
+	c [2]int // QP'C of Cb and Cr.
+}
+
+// newMbQP returns the mbQP of a macroblock with luma quantisation parameter
+// qpY, i.e. QPY, for pictures using sps and pps (7-38, 8-313 to 8-315).
+func newMbQP(sps *SPS, pps *PPS, qpY int) mbQP {
+	offsetC := qpBdOffsetC(sps)
+	return mbQP{
+		y: qpY + qpBdOffsetY(sps),
+		c: [2]int{
+			chromaQP(qpY, pps.ChromaQpIndexOffset, offsetC) + offsetC,
+			chromaQP(qpY, pps.SecondChromaQpIndexOffset, offsetC) + offsetC,
+		},
+	}
+}
+
+// qpBdOffsetY returns QpBdOffsetY, the luma quantisation parameter range
+// offset for the bit depth of luma (7-4).
+func qpBdOffsetY(sps *SPS) int {
+	return 6 * sps.BitDepthLumaMinus8
+}
+
+// qpBdOffsetC returns QpBdOffsetC, the chroma quantisation parameter range
+// offset for the bit depth of chroma (7-6).
+func qpBdOffsetC(sps *SPS) int {
+	return 6 * sps.BitDepthChromaMinus8
+}
+
+// qpYPred returns QPY,PRED for a macroblock, being the QPY of the macroblock
+// with address prevMbAddr preceding it in decoding order in the slice, or
+// sliceQPY, the SliceQPY of the slice, if it is the first and prevMbAddr is
+// -1. Skipped macroblocks and those without mb_qp_delta have the QPY of the
+// macroblock preceding them, mb_qp_delta being inferred to be 0.
+func qpYPred(mbs *mbState, prevMbAddr, sliceQPY int) int {
+	if prevMbAddr < 0 {
+		return sliceQPY
+	}
+	return int(mbs.qpY[prevMbAddr])
+}
+
+// nextQPY returns QPY of a macroblock given QPY,PRED and its mb_qp_delta,
+// wrapping to the range -QpBdOffsetY to 51 (7-37).
+func nextQPY(qpYPred, mbQpDelta, qpBdOffsetY int) int {
+	return (qpYPred+mbQpDelta+52+2*qpBdOffsetY)%(52+qpBdOffsetY) - qpBdOffsetY
+}
@@ -0,0 +1,80 @@
+/*
+NAME
+  qp_test.go
+
+DESCRIPTION
+  qp_test.go provides testing for functionality provided in qp.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+func TestNextQPY(t *testing.T) {
+	tests := []struct {
+		pred, delta, qpBdOffsetY int
+		want                     int
+	}{
+		{pred: 26, delta: 0, want: 26},
+		{pred: 26, delta: -4, want: 22},
+		{pred: 50, delta: 3, want: 1},
+		{pred: 1, delta: -3, want: 50},
+		{pred: 51, delta: 25, want: 24},
+		{pred: 0, delta: -26, want: 26},
+
+		// 10 bit luma, with QPY from -12 to 51.
+		{pred: -10, delta: -4, qpBdOffsetY: 12, want: 50},
+		{pred: 50, delta: 2, qpBdOffsetY: 12, want: -12},
+		{pred: -12, delta: 31, qpBdOffsetY: 12, want: 19},
+	}
+
+	for i, test := range tests {
+		got := nextQPY(test.pred, test.delta, test.qpBdOffsetY)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestNewMbQP(t *testing.T) {
+	tests := []struct {
+		sps  SPS
+		pps  PPS
+		qpY  int
+		want mbQP
+	}{
+		{qpY: 28, want: mbQP{y: 28, c: [2]int{28, 28}}},
+		{qpY: 40, want: mbQP{y: 40, c: [2]int{36, 36}}},
+		{qpY: 51, pps: PPS{ChromaQpIndexOffset: -12, SecondChromaQpIndexOffset: 2}, want: mbQP{y: 51, c: [2]int{35, 39}}},
+		{qpY: 0, pps: PPS{ChromaQpIndexOffset: -5}, want: mbQP{y: 0, c: [2]int{0, 0}}},
+
+		// 10 bit luma and 9 bit chroma, QP'C being offset by 6.
+		{
+			sps:  SPS{BitDepthLumaMinus8: 2, BitDepthChromaMinus8: 1},
+			pps:  PPS{ChromaQpIndexOffset: -4},
+			qpY:  -2,
+			want: mbQP{y: 10, c: [2]int{0, 4}},
+		},
+	}
+
+	for i, test := range tests {
+		got := newMbQP(&test.sps, &test.pps, test.qpY)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, got, test.want)
+		}
+	}
+}
+
+func TestQPYPred(t *testing.T) {
+	mbs := newMbState(2, 1)
+	mbs.qpY[0] = -3
+	if got := qpYPred(mbs, -1, 30); got != 30 {
+		t.Errorf("did not get expected result for first macroblock\nGot: %v\nWant: 30", got)
+	}
+	if got := qpYPred(mbs, 0, 30); got != -3 {
+		t.Errorf("did not get expected result for second macroblock\nGot: %v\nWant: -3", got)
+	}
+}
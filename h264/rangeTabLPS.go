@@ -45,7 +45,7 @@ var rangeTabLPS = [rangeTabLPSRows][rangeTabLPSColumns]int{
 	30: {30, 37, 43, 50},
 	31: {29, 35, 41, 48},
 	32: {27, 33, 39, 45},
-	33: {26, 61, 67, 43},
+	33: {26, 31, 37, 43},
 	34: {24, 30, 35, 41},
 	35: {23, 28, 33, 39},
 	36: {22, 27, 32, 37},
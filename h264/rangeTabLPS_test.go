@@ -0,0 +1,91 @@
+/*
+NAME
+  rangeTabLPS_test.go
+
+DESCRIPTION
+  rangeTabLPS_test.go provides checks of the transcription of rangeTabLPS
+  against values computed independently of the specifications' table.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"math"
+	"testing"
+)
+
+// TestRangeTabLPSModel checks rangeTabLPS against the probability model from
+// which table 9-44 was derived. The LPS probability of state pStateIdx is
+// 0.5*alpha^pStateIdx, alpha being (0.01875/0.5)^(1/63), and codIRangeLPS
+// approximates its product with the midpoint of the range quantised by
+// qCodIRangeIdx, being 288+64*qCodIRangeIdx, limited to 128, the least
+// codIRange of the quantisation interval halved. The final state, used only
+// for termination, is fixed. A failure gives the value computed, by which a
+// mis-transcribed entry may be repaired.
+func TestRangeTabLPSModel(t *testing.T) {
+	alpha := math.Pow(0.01875/0.5, 1.0/63)
+	for pStateIdx := 0; pStateIdx < rangeTabLPSRows-1; pStateIdx++ {
+		p := 0.5 * math.Pow(alpha, float64(pStateIdx))
+		for q := 0; q < rangeTabLPSColumns; q++ {
+			want := p * float64(288+64*q)
+			got := float64(rangeTabLPS[pStateIdx][q])
+			if q == 0 && want > 128 {
+				want = 128
+			}
+			if math.Abs(got-want) >= 1 {
+				t.Errorf("did not get expected codIRangeLPS for pStateIdx: %d qCodIRangeIdx: %d\nGot: %v\nWant: %.2f", pStateIdx, q, got, want)
+			}
+		}
+	}
+	if got := rangeTabLPS[rangeTabLPSRows-1]; got != [rangeTabLPSColumns]int{2, 2, 2, 2} {
+		t.Errorf("did not get expected codIRangeLPS for final state\nGot: %v\nWant: %v", got, []int{2, 2, 2, 2})
+	}
+}
+
+// TestRangeTabLPSMonotonic checks that codIRangeLPS increases with
+// qCodIRangeIdx and does not increase with pStateIdx, as the LPS probability
+// falls.
+func TestRangeTabLPSMonotonic(t *testing.T) {
+	for pStateIdx, row := range rangeTabLPS {
+		for q := 1; q < rangeTabLPSColumns; q++ {
+			if row[q] < row[q-1] || pStateIdx != rangeTabLPSRows-1 && row[q] == row[q-1] {
+				t.Errorf("codIRangeLPS does not increase with qCodIRangeIdx for pStateIdx: %d\nGot: %v", pStateIdx, row)
+			}
+		}
+		if pStateIdx == 0 {
+			continue
+		}
+		for q := range row {
+			if row[q] > rangeTabLPS[pStateIdx-1][q] {
+				t.Errorf("codIRangeLPS increases with pStateIdx: %d for qCodIRangeIdx: %d\nGot: %d\nPrevious: %d", pStateIdx, q, row[q], rangeTabLPS[pStateIdx-1][q])
+			}
+		}
+	}
+}
+
+func TestRetCodIRangeLPS(t *testing.T) {
+	tests := []struct {
+		pStateIdx, qCodIRangeIdx int
+		want                     int
+		err                      error
+	}{
+		{pStateIdx: 0, qCodIRangeIdx: 0, want: 128},
+		{pStateIdx: 33, qCodIRangeIdx: 1, want: 31},
+		{pStateIdx: 63, qCodIRangeIdx: 3, want: 2},
+		{pStateIdx: 64, err: errPStateIdx},
+		{pStateIdx: 0, qCodIRangeIdx: 4, err: errQCodIRangeIdx},
+	}
+
+	for i, test := range tests {
+		got, err := retCodIRangeLPS(test.pStateIdx, test.qCodIRangeIdx)
+		if err != test.err {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+		}
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
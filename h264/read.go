@@ -1,12 +1,15 @@
 package h264
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	mathbits "math/bits"
 	"os"
+	"time"
 
 	"github.com/ausocean/h264decode/h264/bits"
-	"github.com/pkg/errors"
 )
 
 type H264Reader struct {
@@ -14,26 +17,142 @@ type H264Reader struct {
 	Stream       io.Reader
 	NalUnits     []*bits.BitReader
 	VideoStreams []*VideoStream
-	DebugFile    *os.File
-	bytes        []byte
-	byteOffset   int
+
+	// ParameterSets holds the SPS and PPS received, from which those active
+	// for each slice are resolved.
+	ParameterSets ParameterSets
+
+	DebugFile  *os.File
+	byteOffset int
+	pending    *NalUnit
+
+	// buf is a rolling buffer holding stream data. buf[start:end] holds data
+	// not yet returned in a NAL unit, and buf[scan:end] data not yet searched
+	// for a start code.
+	buf   []byte
+	start int
+	scan  int
+	end   int
+
+	// Temporal layer selection, see WithMaxTemporalID.
+	filterTemporal   bool
+	maxTemporalID    int
+	prefixTemporalID int
+	havePrefix       bool
+	layerStats       [maxTemporalID + 1]TemporalLayerStats
+
+	// Error recovery, see WithRecovery.
+	recover   bool
+	discarded int
+
+	strict             bool
+	maxViolations      int                // Cap on violations per access unit, see WithMaxViolations.
+	violations         violationCollector // Violations of the current access unit, if strict.
+	forbiddenBitErrors int                // NAL units with forbidden_zero_bit set, if not strict.
+	fullPicture        bool               // Output full picture rather than conformance window.
+	outputAlign        int                // Output frame row alignment, see WithOutputLayout.
+	outputPadding      int                // Output frame border in luma samples.
+
+	readTimeout  time.Duration
+	eventHandler func(Event)
+	awaitIDR     bool // Skipping slices until an IDR picture, see resync.
+
+	scalability *ScalabilityInfo // Most recent scalability information SEI.
+	refresh     *refreshTracker  // Refresh after resuming, see WithIntraRefresh.
+
+	auSize *auSizeMonitor // Access unit size tracking, see WithAUSizeLimit.
+	intra  *intraDecoder  // Concurrent decoding, see WithIntraParallelism.
+	arena  arena          // Temporaries of the picture being decoded.
+	refs   refFrames      // Reference frames of decoded pictures.
+
+	outOfBand   []outOfBandParameterSet // See WithSPS and WithPPS.
+	partitioned *partitionedSlice       // Slice coded as data partitions being gathered.
+	poc         pocDecoder              // Picture order count state, see pictureOrder.
+	pictures    pictureDetector         // Detection of the first slice of each picture.
+	timeline    *Timeline               // Stage timings, see WithTimeline.
+
 	*bits.BitReader
 }
 
-func (h *H264Reader) BufferToReader(cntBytes int) error {
-	buf := make([]byte, cntBytes)
-	if _, err := h.Stream.Read(buf); err != nil {
-		logger.Printf("error: while reading %d bytes: %v\n", cntBytes, err)
+// NewH264Reader returns a new H264Reader reading an Annex B byte stream from
+// stream, configured using any provided options.
+func NewH264Reader(stream io.Reader, options ...Option) (*H264Reader, error) {
+	h := &H264Reader{Stream: stream}
+	for i, o := range options {
+		err := o(h)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply option %d: %w", i, err)
+		}
+	}
+	for i, ps := range h.outOfBand {
+		err := h.ParameterSets.parseRaw(ps.raw, ps.typ)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse out of band parameter set %d: %w", i, err)
+		}
+	}
+	h.outOfBand = nil
+	h.arena.align, h.arena.padding = h.outputAlign, h.outputPadding
+	h.arena.refs = &h.refs
+	if h.intra != nil {
+		h.intra.timeline = h.timeline
+		h.intra.refs = &h.refs
+		h.intra.align, h.intra.padding = h.outputAlign, h.outputPadding
+	}
+	if h.readTimeout != 0 {
+		h.Stream = newTimeoutReader(h.Stream, h.readTimeout)
+	}
+	return h, nil
+}
+
+// fill reads from the stream into the rolling buffer. Space is made by first
+// moving the bytes of the NAL unit currently being scanned to the front of the
+// buffer, discarding those of previously returned NAL units, and only growing
+// the buffer if the current NAL unit fills it.
+func (h *H264Reader) fill() error {
+	if h.end == len(h.buf) {
+		if h.start > 0 {
+			n := copy(h.buf, h.buf[h.start:h.end])
+			h.scan -= h.start
+			h.start = 0
+			h.end = n
+		}
+		if h.end == len(h.buf) {
+			size := 2 * len(h.buf)
+			if size == 0 {
+				size = minBufSize
+			}
+			buf := make([]byte, size)
+			copy(buf, h.buf[:h.end])
+			h.buf = buf
+		}
+	}
+
+	n, err := h.Stream.Read(h.buf[h.end:])
+	if n > 0 {
+		if h.DebugFile != nil {
+			h.DebugFile.Write(h.buf[h.end : h.end+n])
+		}
+		h.end += n
+		h.byteOffset += n
+		return nil
+	}
+	if errors.Is(err, ErrReconnected) {
+		h.resync()
+		return nil
+	}
+	if errors.Is(err, ErrStreamStalled) {
+		h.emit(Event{Type: EventStreamStalled, Offset: h.byteOffset, Detail: "no data within " + h.readTimeout.String()})
 		return err
 	}
-	h.bytes = append(h.bytes, buf...)
-	if h.DebugFile != nil {
-		h.DebugFile.Write(buf)
+	if err != nil && !errors.Is(err, io.EOF) {
+		logger.Printf("error: while reading stream: %v\n", err)
 	}
-	h.byteOffset += cntBytes
-	return nil
+	return err
 }
 
+// minBufSize is the initial size of the H264Reader rolling buffer.
+const minBufSize = 1 << 16
+
 func (h *H264Reader) Discard(cntBytes int) error {
 	buf := make([]byte, cntBytes)
 	if _, err := h.Stream.Read(buf); err != nil {
@@ -56,86 +175,455 @@ func bitVal(bits []int) int {
 	return t
 }
 
-func (h *H264Reader) Start() {
+// Start reads and decodes NAL units from the stream until the stream ends, in
+// which case nil is returned, or an error occurs. If recovery has been enabled
+// using WithRecovery, NAL units that fail to decode are discarded and decoding
+// resumes from the next start code rather than returning an error.
+func (h *H264Reader) Start() error {
 	for {
-		// TODO: need to handle error from this.
-		nalUnit, _, _ := h.readNalUnit()
-		switch nalUnit.Type {
-		case naluTypeSPS:
-			// TODO: handle this error
-			sps, _ := NewSPS(nalUnit.rbsp, false)
-			h.VideoStreams = append(
-				h.VideoStreams,
-				&VideoStream{SPS: sps},
-			)
-		case naluTypePPS:
-			videoStream := h.VideoStreams[len(h.VideoStreams)-1]
-			// TODO: handle this error
-			videoStream.PPS, _ = NewPPS(videoStream.SPS, nalUnit.RBSP(), false)
-		case naluTypeSliceIDRPicture:
-			fallthrough
-		case naluTypeSliceNonIDRPicture:
-			videoStream := h.VideoStreams[len(h.VideoStreams)-1]
-			logger.Printf("info: frame number %d\n", len(videoStream.Slices))
-			// TODO: handle this error
-			sliceContext, _ := NewSliceContext(videoStream, nalUnit, nalUnit.RBSP(), true)
-			videoStream.Slices = append(videoStream.Slices, sliceContext)
-		}
-	}
-}
-
-func (r *H264Reader) readNalUnit() (*NalUnit, *bits.BitReader, error) {
-	// Read to start of NAL
-	logger.Printf("debug: Seeking NAL %d start\n", len(r.NalUnits))
-
-	// TODO: Fix this.
-	for !isStartSequence(nil) {
-		if err := r.BufferToReader(1); err != nil {
-			// TODO: should this return an error here.
-			return nil, nil, nil
-		}
-	}
-	/*
-		if !r.IsStarted {
-			logger.Printf("debug: skipping initial NAL zero byte spaces\n")
-			r.LogStreamPosition()
-			// Annex B.2 Step 1
-			if err := r.Discard(1); err != nil {
-				logger.Printf("error: while discarding empty byte (Annex B.2:1): %v\n", err)
-				return nil
+		start := h.timeline.now()
+		nalUnit, err := h.nextNalUnit()
+		if err == nil {
+			h.timeline.span(StageRead, 0, start, nalArgs(nalUnit))
+		}
+		// A slice coded as data partitions is complete once anything other
+		// than its partitions B and C follows partition A.
+		if err != nil || !isPartitionBC(nalUnit) {
+			perr := h.decodePartitioned()
+			if perr != nil {
+				// Keep the NAL unit, which follows the slice.
+				h.pending = nalUnit
+				return perr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			h.endAU()
+			if h.intra != nil {
+				h.intra.wait()
+			}
+			finishPicture(&h.arena)
+			h.completeRefresh()
+			err = h.intraErrors()
+			if err != nil {
+				return err
+			}
+			return h.violations.flush()
+		}
+		if err != nil {
+			return fmt.Errorf("could not read NAL unit: %w", err)
+		}
+
+		if h.collecting() && h.violations.startsAU(nalUnit) {
+			err = h.violations.flush()
+			if err != nil {
+				// Keep the NAL unit, which belongs to the next access unit.
+				h.pending = nalUnit
+				return err
+			}
+		}
+
+		h.trackAUSize(nalUnit)
+		start = h.timeline.now()
+		perr := h.processNalUnit(nalUnit)
+		h.timeline.span(StageProcess, 0, start, nalArgs(nalUnit))
+
+		// Errors of concurrently decoded slices are of earlier NAL units.
+		err = h.intraErrors()
+		if err == nil && perr != nil {
+			err = h.discard(nalUnit, perr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// collecting returns true if violations are collected over each access unit,
+// being in strict mode without recovery.
+func (h *H264Reader) collecting() bool {
+	return h.strict && !h.recover
+}
+
+// discard returns perr, an error decoding the NAL unit, as a ParseError,
+// unless recovery is enabled, in which case the NAL unit is counted as
+// discarded and nil returned. In strict mode without recovery, the error is
+// instead collected with the other violations of the access unit, which are
+// returned once the cap given by WithMaxViolations is reached.
+func (h *H264Reader) discard(nalUnit *NalUnit, perr error) error {
+	if h.collecting() {
+		max := h.maxViolations
+		if max == 0 {
+			max = defaultMaxViolations
+		}
+		if h.violations.add(nalUnit, perr, max) {
+			return h.violations.flush()
+		}
+		return nil
+	}
+	perr = newParseError(nalUnit, perr)
+	if !h.recover {
+		return perr
+	}
+	h.discarded++
+	logger.Printf("warning: discarded NAL unit: %v\n", perr)
+	return nil
+}
+
+// processNalUnit validates the NAL unit if strict mode is enabled, or otherwise
+// counts a set forbidden_zero_bit, and decodes it unless it belongs to a
+// temporal layer that is being skipped. When violations are being collected,
+// see collecting, a NAL unit with an invalid header is still decoded, and the
+// limits of the level of an SPS are checked, so that a MultiError holding all
+// violations may be returned.
+func (h *H264Reader) processNalUnit(nalUnit *NalUnit) error {
+	var errs []error
+	if h.strict {
+		errs = nalUnit.violations()
+		if len(errs) != 0 && !h.collecting() {
+			return fmt.Errorf("invalid NAL unit: %w", errs[0])
+		}
+	} else if nalUnit.ForbiddenZeroBit != 0 {
+		// Some links flip this bit on corrupted units; tolerate but count.
+		h.forbiddenBitErrors++
+		logger.Printf("warning: forbidden_zero_bit set in %s NAL unit\n", nalUnit.Type)
+	}
+	if h.skipTemporalLayer(nalUnit) || h.skipUntilIDR(nalUnit) {
+		return multiError(errs)
+	}
+	err := h.decodeNalUnit(nalUnit)
+	switch {
+	case h.awaitingRefresh(err):
+		logger.Printf("info: skipped %s NAL unit awaiting refresh: %v\n", nalUnit.Type, err)
+	case err != nil:
+		errs = append(errs, err)
+	case h.collecting() && nalUnit.Type == NALTypeSPS:
+		errs = append(errs, levelViolations(h.ParameterSets.last)...)
+	}
+	return multiError(errs)
+}
+
+// multiError returns nil if there are no errors, the error if there is one,
+// and otherwise a MultiError holding them.
+func multiError(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return &MultiError{Errs: errs}
+}
+
+// decodeNalUnit decodes the given NAL unit, storing the results. If recovery
+// is enabled, a panic during decoding, likely caused by corrupt data, is
+// returned as an error.
+func (h *H264Reader) decodeNalUnit(nalUnit *NalUnit) (err error) {
+	if h.recover {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic while decoding: %v", r)
+			}
+		}()
+	}
+
+	switch nalUnit.Type {
+	case NALTypeSPS, NALTypeSubsetSPS, NALTypePPS:
+		return h.handleParameterSet(nalUnit)
+	case NALTypeSEI:
+		h.handleSEI(nalUnit)
+	case NALTypeSliceIDRPicture, NALTypeSliceNonIDRPicture:
+		ppsID, err := slicePPSID(nalUnit.RBSP())
+		if err != nil {
+			return fmt.Errorf("could not parse slice: %w", err)
+		}
+		sps, pps, err := h.ParameterSets.Active(ppsID)
+		if err != nil {
+			return fmt.Errorf("could not activate parameter sets: %w", err)
+		}
+		videoStream := h.videoStream(sps, pps)
+		logger.Printf("info: frame number %d\n", len(videoStream.Slices))
+		var flags FrameFlags
+		if h.refresh != nil {
+			flags = h.trackRefresh(sps, nalUnit)
+		}
+		order, err := h.pictureOrder(sps, pps, nalUnit)
+		if err != nil {
+			return err
+		}
+		if h.intra != nil {
+			return h.intra.decodeSlice(videoStream, nalUnit, &h.arena, flags, order)
+		}
+		return decodeSlice(videoStream, nalUnit, &h.arena, flags, order, nil)
+	case NALTypeSlicePartA:
+		ppsID, err := slicePPSID(nalUnit.RBSP())
+		if err != nil {
+			return fmt.Errorf("could not parse slice: %w", err)
+		}
+		sps, pps, err := h.ParameterSets.Active(ppsID)
+		if err != nil {
+			return fmt.Errorf("could not activate parameter sets: %w", err)
+		}
+		var flags FrameFlags
+		if h.refresh != nil {
+			flags = h.trackRefresh(sps, nalUnit)
+		}
+		order, err := h.pictureOrder(sps, pps, nalUnit)
+		if err != nil {
+			return err
+		}
+		return h.gatherPartitionA(h.videoStream(sps, pps), nalUnit, flags, order)
+	case NALTypeSlicePartB, NALTypeSlicePartC:
+		return h.addPartition(nalUnit)
+	}
+	return nil
+}
+
+// decodeSlice decodes the slice NAL unit using the parameter sets of
+// videoStream, adding it to videoStream with the given flags set and the
+// order of the picture it belongs to, see pictureOrder. Temporaries
+// are allocated from a, which, if the slice begins a new picture, is reset
+// once the picture it holds has been finished, see finishPicture. For
+// a slice coded as data partitions, nalUnit is partition A and parts holds
+// partitions B and C.
+func decodeSlice(videoStream *VideoStream, nalUnit *NalUnit, a *arena, flags FrameFlags, order PictureOrder, parts *DataPartitions) error {
+	if startsPicture(nalUnit) {
+		finishPicture(a)
+		a.reset()
+	}
+	a.poc = order.PicOrderCnt
+	sliceContext, err := newSliceContext(videoStream, nalUnit, nalUnit.RBSP(), true, a, parts)
+	if err != nil {
+		return fmt.Errorf("could not parse slice: %w", err)
+	}
+	sliceContext.Flags |= flags
+	sliceContext.POC = order
+	videoStream.addSlice(sliceContext)
+	a.decoded = videoStream.Pictures[len(videoStream.Pictures)-1]
+	return nil
+}
+
+// finishPicture completes the picture held by a, see arena.finish, setting
+// its flags on the Picture to which its slices were added, and adds it to the
+// reference frames of a, see refFrames.add.
+func finishPicture(a *arena) {
+	a.finish()
+	if a.decoded != nil {
+		a.decoded.addFlags(a.flags)
+	}
+	if a.refs != nil {
+		a.refs.add(a)
+	}
+}
+
+// Discarded returns the number of NAL units discarded due to decode errors
+// while recovery is enabled.
+func (h *H264Reader) Discarded() int {
+	return h.discarded
+}
+
+// ForbiddenBitErrors returns the number of NAL units tolerated despite having
+// forbidden_zero_bit set. This is always zero in strict mode, where such NAL
+// units are instead rejected.
+func (h *H264Reader) ForbiddenBitErrors() int {
+	return h.forbiddenBitErrors
+}
+
+// handleParameterSet parses an SPS, subset SPS or PPS NAL unit and stores the
+// result so that following slices may refer to it.
+func (h *H264Reader) handleParameterSet(nalUnit *NalUnit) error {
+	return h.ParameterSets.Parse(nalUnit)
+}
+
+// videoStream returns the VideoStream for slices using the given active
+// parameter sets. A new VideoStream is started when the active SPS changes.
+func (h *H264Reader) videoStream(sps *SPS, pps *PPS) *VideoStream {
+	if len(h.VideoStreams) == 0 || h.VideoStreams[len(h.VideoStreams)-1].SPS != sps {
+		h.VideoStreams = append(h.VideoStreams, &VideoStream{SPS: sps})
+	}
+	videoStream := h.VideoStreams[len(h.VideoStreams)-1]
+	videoStream.PPS = pps
+	return videoStream
+}
+
+// Skip advances the reader past n access units. Access unit boundaries are
+// found by scanning NAL unit headers and the first_mb_in_slice field of slice
+// headers only, so no slice data is entropy decoded; this makes Skip much
+// cheaper than decoding and discarding. Parameter sets encountered are still
+// parsed and stored so that decoding may continue from the new position.
+// io.EOF is returned if the stream ends before n access units are skipped.
+func (h *H264Reader) Skip(n int) error {
+	var skipped int
+	var sawVCL bool
+	for skipped < n {
+		nalUnit, err := h.nextNalUnit()
+		if errors.Is(err, io.EOF) {
+			if sawVCL {
+				skipped++
 			}
-			if err := r.Discard(2); err != nil {
-				logger.Printf("error: while discarding start code prefix one 3bytes (Annex B.2:2): %v\n", err)
+			if skipped < n {
+				return io.EOF
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read NAL unit: %w", err)
+		}
+
+		if sawVCL && startsAccessUnit(nalUnit) {
+			skipped++
+			sawVCL = false
+			if skipped == n {
+				// This NAL unit belongs to the next access unit, so keep it
+				// for the next read.
+				h.pending = nalUnit
 				return nil
 			}
 		}
-	*/
-	startOffset := r.BytesRead()
-	logger.Printf("debug: Seeking next NAL start\n")
-	// Read to start of next NAL
-	so := r.BytesRead()
-	for so == startOffset || !isStartSequence(nil) {
-		so = r.BytesRead()
-		if err := r.BufferToReader(1); err != nil {
-			// TODO: should this return an error here?
-			return nil, nil, nil
-		}
-	}
-	// logger.Printf("debug: PreRewind %#v\n", r.Bytes())
-	// Rewind back the length of the start sequence
-	// r.RewindBytes(4)
-	// logger.Printf("debug: PostRewind %#v\n", r.Bytes())
-	endOffset := r.BytesRead()
-	logger.Printf("debug: found NAL unit with %d bytes from %d to %d\n", endOffset-startOffset, startOffset, endOffset)
-	nalUnitReader := bits.NewBitReader(nil)
-	r.NalUnits = append(r.NalUnits, nalUnitReader)
-	// TODO: this should really take an io.Reader rather than []byte. Need to fix nil
-	// once this is fixed.
-	nalUnit, err := NewNalUnit(nil, 0)
+
+		switch nalUnit.Type {
+		case NALTypeSPS, NALTypeSubsetSPS, NALTypePPS:
+			err = h.handleParameterSet(nalUnit)
+			if err != nil {
+				return newParseError(nalUnit, err)
+			}
+		default:
+			if nalUnit.Type.IsVCL() {
+				sawVCL = true
+			}
+		}
+	}
+	return nil
+}
+
+// startsAccessUnit returns true if the NAL unit is the first of an access
+// unit, assuming a VCL NAL unit has been seen in the current access unit. See
+// section 7.4.1.2.3 of the specifications. The first VCL NAL unit of a primary
+// coded picture is given by startsPicture.
+func startsAccessUnit(nalUnit *NalUnit) bool {
+	switch nalUnit.Type {
+	case NALTypeAccessUnitDelimiter, NALTypeSPS, NALTypePPS, NALTypeSEI:
+		return true
+	case NALTypeSliceNonIDRPicture, NALTypeSlicePartA, NALTypeSliceIDRPicture:
+		return startsPicture(nalUnit)
+	}
+	return nalUnit.Type >= NALTypePrefixNALU && nalUnit.Type <= NALTypeReserved18
+}
+
+// sliceType returns the slice_type of the slice with the given RBSP, being
+// the second syntax element of its header.
+func sliceType(rbsp []byte) (int, error) {
+	br := bits.NewBitReader(bytes.NewReader(rbsp))
+	_, err := readUe(br)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse first_mb_in_slice: %w", err)
+	}
+	t, err := readUe(br)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse slice_type: %w", err)
+	}
+	return t, nil
+}
+
+// nextNalUnit returns a NAL unit held back by a previous read if there is one,
+// otherwise the next NAL unit from the stream.
+func (h *H264Reader) nextNalUnit() (*NalUnit, error) {
+	if h.pending != nil {
+		nalUnit := h.pending
+		h.pending = nil
+		return nalUnit, nil
+	}
+	return h.readNalUnit()
+}
+
+// readNalUnit reads the next NAL unit from the Annex B byte stream, i.e. the
+// bytes following a start code prefix up to the next start code prefix or the
+// end of the stream. Trailing zero bytes are discarded.
+//
+// The returned NalUnit refers to the reader's internal buffer and is only
+// valid until the next call to readNalUnit; callers wishing to retain RBSP
+// data beyond that must copy it.
+func (h *H264Reader) readNalUnit() (*NalUnit, error) {
+	for {
+		i := bytes.Index(h.buf[h.scan:h.end], Initial3BNALU)
+		if i != -1 {
+			sc := h.scan + i
+			h.scan = sc + len(Initial3BNALU)
+			if !h.IsStarted {
+				h.IsStarted = true
+				h.start = h.scan
+				continue
+			}
+
+			off := h.offset(h.start)
+			frame := trimTrailingZeros(h.buf[h.start:sc])
+			h.start = h.scan
+			if len(frame) == 0 {
+				continue
+			}
+			logger.Printf("debug: found NAL unit with %d bytes\n", len(frame))
+			return h.nalUnitAt(frame, off)
+		}
+
+		// Keep the last bytes in case a start code straddles the next read.
+		if h.end-h.scan >= len(Initial3BNALU) {
+			h.scan = h.end - (len(Initial3BNALU) - 1)
+		}
+		if !h.IsStarted {
+			// Nothing before the first start code is needed.
+			h.start = h.scan
+		}
+
+		err := h.fill()
+		if errors.Is(err, io.EOF) && h.IsStarted {
+			h.IsStarted = false
+			off := h.offset(h.start)
+			frame := trimTrailingZeros(h.buf[h.start:h.end])
+			h.start, h.scan = h.end, h.end
+			if len(frame) == 0 {
+				return nil, io.EOF
+			}
+			return h.nalUnitAt(frame, off)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// offset returns the absolute stream byte offset of h.buf[i].
+func (h *H264Reader) offset(i int) int64 {
+	return int64(h.byteOffset - (h.end - i))
+}
+
+// nalUnitAt returns the NAL unit in frame, which begins at stream byte offset
+// off, having detected whether it begins a picture, see pictureDetector.
+func (h *H264Reader) nalUnitAt(frame []byte, off int64) (*NalUnit, error) {
+	nalUnit, err := newNalUnitAt(frame, off)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "cannot create new nal unit")
+		return nil, err
 	}
-	return nalUnit, nalUnitReader, nil
+	h.pictures.detect(&h.ParameterSets, nalUnit)
+	return nalUnit, nil
+}
+
+// newNalUnitAt parses the NAL unit in frame, which begins at stream byte
+// offset off, returning a ParseError on failure.
+func newNalUnitAt(frame []byte, off int64) (*NalUnit, error) {
+	nalUnit, err := NewNalUnit(frame, len(frame))
+	if err != nil {
+		return nil, &ParseError{NALOffset: off, Offset: off, Bit: -1, Err: err}
+	}
+	nalUnit.Offset = off
+	return nalUnit, nil
+}
+
+// trimTrailingZeros removes trailing zero bytes, which may be either
+// trailing_zero_8bits or the zero_byte of a following start code.
+func trimTrailingZeros(buf []byte) []byte {
+	for len(buf) > 0 && buf[len(buf)-1] == 0 {
+		buf = buf[:len(buf)-1]
+	}
+	return buf
 }
 
 func isStartSequence(packet []byte) bool {
@@ -177,23 +665,23 @@ func isEmpty3Byte(buf []byte) bool {
 	return true
 }
 
-// TODO: complete this.
-func moreRBSPData(br *bits.BitReader) bool {
-	// Read until the least significant bit of any remaining bytes
-	// If the least significant bit is 1, that marks the first bit
-	// of the rbspTrailingBits() struct. If the bits read is more
-	// than 0, then there is more RBSP data
-	var bits uint64
-	cnt := 0
-	for bits != 1 {
-		if _, err := br.ReadBits(8); err != nil {
-			logger.Printf("moreRBSPData error: %v\n", err)
-			return false
+// moreRBSPData returns true if there is more data in the RBSP before
+// rbsp_trailing_bits, as defined in section 7.2, where br is reading rbsp.
+// That is, br has not yet reached the rbsp_stop_one_bit, being the last bit
+// equal to 1 in rbsp.
+func moreRBSPData(br *bits.BitReader, rbsp []byte) bool {
+	return br.Off() < rbspStopBit(rbsp)
+}
+
+// rbspStopBit returns the bit offset in rbsp of the rbsp_stop_one_bit, i.e.
+// the last bit equal to 1, or -1 if there is none.
+func rbspStopBit(rbsp []byte) int {
+	for i := len(rbsp) - 1; i >= 0; i-- {
+		if rbsp[i] != 0 {
+			return i*8 + 7 - mathbits.TrailingZeros8(rbsp[i])
 		}
-		cnt++
 	}
-	logger.Printf("moreRBSPData: read %d additional bits\n", cnt)
-	return cnt > 0
+	return -1
 }
 
 type field struct {
@@ -204,10 +692,12 @@ type field struct {
 
 func readFields(br *bits.BitReader, fields []field) error {
 	for _, f := range fields {
+		start := br.Off()
 		b, err := br.ReadBits(f.n)
 		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("could not read %s", f.name))
+			return fmt.Errorf("could not read %s: %w", f.name, err)
 		}
+		traceElement(br, start, f.name, "", int64(b))
 		*f.loc = int(b)
 	}
 	return nil
@@ -220,10 +710,12 @@ type flag struct {
 
 func readFlags(br *bits.BitReader, flags []flag) error {
 	for _, f := range flags {
+		start := br.Off()
 		b, err := br.ReadBits(1)
 		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("could not read %s", f.name))
+			return fmt.Errorf("could not read %s: %w", f.name, err)
 		}
+		traceElement(br, start, f.name, "", int64(b))
 		*f.loc = b == 1
 	}
 	return nil
@@ -0,0 +1,253 @@
+/*
+NAME
+  read_test.go
+
+DESCRIPTION
+  read_test.go provides testing for functionality provided in read.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// Minimal NAL units used to construct test streams.
+var (
+	// Baseline SPS with 16x16 picture, pic_order_cnt_type 2 and no VUI.
+	testSPS = []byte{0x67, 0x42, 0x00, 0x1e, 0xda, 0x79}
+
+	// PPS referring to SPS 0 using CAVLC.
+	testPPS = []byte{0x68, 0xce, 0x3c, 0x80}
+
+	// IDR and non-IDR slices with first_mb_in_slice = 0.
+	testIDR    = []byte{0x65, 0x88, 0x84}
+	testNonIDR = []byte{0x41, 0x9a}
+)
+
+// annexB joins NAL units into an Annex B byte stream.
+func annexB(nalUnits ...[]byte) []byte {
+	var buf []byte
+	for _, n := range nalUnits {
+		buf = append(buf, InitialNALU...)
+		buf = append(buf, n...)
+	}
+	return buf
+}
+
+// TestSkip checks that Skip advances past the expected number of access units
+// while retaining parameter set state.
+func TestSkip(t *testing.T) {
+	stream := annexB(testSPS, testPPS, testIDR, testNonIDR, testNonIDR, testNonIDR)
+
+	tests := []struct {
+		n        int
+		wantType NALType // Type of the next NAL unit after skipping.
+		wantErr  error   // Expected error reading the next NAL unit.
+		err      error   // Expected error from Skip.
+	}{
+		{n: 0, wantType: NALTypeSPS},
+		{n: 1, wantType: NALTypeSliceNonIDRPicture},
+		{n: 3, wantType: NALTypeSliceNonIDRPicture},
+		{n: 4, wantErr: io.EOF},
+		{n: 5, err: io.EOF},
+	}
+
+	for i, test := range tests {
+		r := &H264Reader{Stream: bytes.NewReader(stream)}
+		err := r.Skip(test.n)
+		if err != test.err {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if test.n > 0 && (len(r.ParameterSets.SPS) != 1 || len(r.ParameterSets.PPS) != 1) {
+			t.Errorf("parameter sets not retained for test: %d", i)
+		}
+
+		got, err := r.nextNalUnit()
+		if err != test.wantErr {
+			t.Errorf("did not get expected error from nextNalUnit for test: %d\nGot: %v\nWant: %v", i, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got.Type != test.wantType {
+			t.Errorf("did not get expected NAL type for test: %d\nGot: %v\nWant: %v", i, got.Type, test.wantType)
+		}
+	}
+}
+
+// TestReadNalUnit checks that readNalUnit extracts NAL units delimited by both
+// 3 and 4 byte start codes and removes emulation prevention bytes.
+func TestReadNalUnit(t *testing.T) {
+	in := []byte{
+		0x00, 0x00, 0x01, 0x09, 0xf0,
+		0x00, 0x00, 0x00, 0x01, 0x41, 0x00, 0x00, 0x03, 0x01, 0x80,
+		0x00, 0x00, 0x01, 0x0c, 0xff, 0x00, 0x00,
+	}
+	want := []struct {
+		typ  NALType
+		rbsp []byte
+		off  int64
+	}{
+		{NALTypeAccessUnitDelimiter, []byte{0xf0}, 3},
+		{NALTypeSliceNonIDRPicture, []byte{0x00, 0x00, 0x01, 0x80}, 9},
+		{NALTypeFillerData, []byte{0xff}, 18},
+	}
+
+	r := &H264Reader{Stream: bytes.NewReader(in)}
+	for i, w := range want {
+		got, err := r.readNalUnit()
+		if err != nil {
+			t.Fatalf("did not expect error: %v for NAL unit: %d", err, i)
+		}
+		if got.Type != w.typ || !bytes.Equal(got.RBSP(), w.rbsp) || got.Offset != w.off {
+			t.Errorf("did not get expected NAL unit: %d\nGot: type %v, rbsp %#v, offset %d\nWant: type %v, rbsp %#v, offset %d", i, got.Type, got.RBSP(), got.Offset, w.typ, w.rbsp, w.off)
+		}
+	}
+	if _, err := r.readNalUnit(); err != io.EOF {
+		t.Errorf("did not get expected error at end of stream\nGot: %v\nWant: %v", err, io.EOF)
+	}
+}
+
+// TestReadNalUnitBuffering checks that NAL units are extracted correctly when
+// they straddle reads, require the rolling buffer to be compacted, and are
+// larger than the buffer.
+func TestReadNalUnitBuffering(t *testing.T) {
+	sizes := []int{10, minBufSize / 2, 3, minBufSize - 1, 3 * minBufSize, 100, minBufSize}
+	var nalUnits [][]byte
+	for i, n := range sizes {
+		nalUnit := make([]byte, n)
+		nalUnit[0] = byte(NALTypeFillerData)
+		for j := 1; j < n; j++ {
+			nalUnit[j] = byte(i + j%255 + 1)
+		}
+		nalUnits = append(nalUnits, nalUnit)
+	}
+
+	stream := annexB(nalUnits...)
+	readers := []io.Reader{
+		bytes.NewReader(stream),
+		iotest.HalfReader(bytes.NewReader(stream)),
+		iotest.OneByteReader(bytes.NewReader(stream)),
+	}
+
+	for i, src := range readers {
+		r := &H264Reader{Stream: src}
+		for j, want := range nalUnits {
+			got, err := r.readNalUnit()
+			if err != nil {
+				t.Fatalf("did not expect error: %v for reader: %d, NAL unit: %d", err, i, j)
+			}
+			if !bytes.Equal(got.RBSP(), want[1:]) {
+				t.Errorf("did not get expected RBSP for reader: %d, NAL unit: %d", i, j)
+			}
+		}
+		if _, err := r.readNalUnit(); err != io.EOF {
+			t.Errorf("did not get expected error at end of stream for reader: %d\nGot: %v\nWant: %v", i, err, io.EOF)
+		}
+		if len(r.buf) > 8*minBufSize {
+			t.Errorf("buffer grew unexpectedly for reader: %d, len: %d", i, len(r.buf))
+		}
+	}
+}
+
+// TestStartRecovery checks that with recovery enabled, NAL units that fail to
+// decode are discarded and decoding continues, while without it Start returns
+// an error.
+func TestStartRecovery(t *testing.T) {
+	corruptPPS := []byte{0x68}
+	stream := annexB(testSPS, corruptPPS, testPPS)
+
+	r, err := NewH264Reader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	if err := r.Start(); err == nil {
+		t.Errorf("expected error from Start without recovery")
+	}
+
+	r, err = NewH264Reader(bytes.NewReader(stream), WithRecovery())
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	if err := r.Start(); err != nil {
+		t.Errorf("did not expect error: %v from Start with recovery", err)
+	}
+	if r.Discarded() != 1 {
+		t.Errorf("did not get expected number of discarded NAL units\nGot: %v\nWant: %v", r.Discarded(), 1)
+	}
+	if len(r.ParameterSets.SPS) != 1 || len(r.ParameterSets.PPS) != 1 {
+		t.Errorf("parameter sets not decoded after recovery")
+	}
+}
+
+// TestForbiddenBit checks that NAL units with forbidden_zero_bit set are
+// tolerated and counted in permissive mode, and rejected in strict mode.
+func TestForbiddenBit(t *testing.T) {
+	corruptSPS := append([]byte{testSPS[0] | 0x80}, testSPS[1:]...)
+	stream := annexB(corruptSPS, testPPS)
+
+	r, err := NewH264Reader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	if err := r.Start(); err != nil {
+		t.Errorf("did not expect error: %v from Start in permissive mode", err)
+	}
+	if r.ForbiddenBitErrors() != 1 {
+		t.Errorf("did not get expected forbidden bit count\nGot: %v\nWant: %v", r.ForbiddenBitErrors(), 1)
+	}
+
+	r, err = NewH264Reader(bytes.NewReader(stream), WithStrict())
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	if err := r.Start(); err == nil {
+		t.Errorf("expected error from Start in strict mode")
+	}
+	if r.ForbiddenBitErrors() != 0 {
+		t.Errorf("did not get expected forbidden bit count in strict mode\nGot: %v\nWant: %v", r.ForbiddenBitErrors(), 0)
+	}
+}
+
+// TestMoreRBSPData checks detection of data before rbsp_trailing_bits.
+func TestMoreRBSPData(t *testing.T) {
+	tests := []struct {
+		rbsp []byte
+		skip int // Bits read before the check.
+		want bool
+	}{
+		{rbsp: []byte{0x80}, skip: 0, want: false},
+		{rbsp: []byte{0xc0}, skip: 0, want: true},
+		{rbsp: []byte{0xc0}, skip: 1, want: false},
+		{rbsp: []byte{0x01, 0x80}, skip: 7, want: true},
+		{rbsp: []byte{0x01, 0x80}, skip: 8, want: false},
+		{rbsp: []byte{0x03, 0x00, 0x00}, skip: 6, want: true},
+		{rbsp: []byte{0x03, 0x00, 0x00}, skip: 7, want: false},
+		{rbsp: []byte{0x00}, skip: 0, want: false},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(test.rbsp))
+		if _, err := br.ReadBits(test.skip); err != nil {
+			t.Fatalf("did not expect error: %v from ReadBits for test: %d", err, i)
+		}
+		if got := moreRBSPData(br, test.rbsp); got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+/*
+NAME
+  reconnect.go
+
+DESCRIPTION
+  reconnect.go provides an io.Reader for network sources that reconnects,
+  with exponential backoff, when the connection fails or stalls, so that a
+  live stream may be decoded across transient network errors.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrReconnected is returned by ReconnectReader.Read, with no data, after the
+// source has been reconnected, as the data that follows is not continuous with
+// that before. An H264Reader reading from a ReconnectReader handles this by
+// discarding any partly received NAL unit and resuming decoding at the next
+// IDR picture, see EventReconnected.
+var ErrReconnected = errors.New("source reconnected")
+
+// DialFunc opens a connection to a source of an Annex B byte stream, e.g.
+// using net.Dial. The connection is closed by the ReconnectReader.
+type DialFunc func() (io.ReadCloser, error)
+
+// Default backoff between attempts to connect, see ReconnectReader.SetBackoff.
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+// ReconnectReader is an io.ReadCloser reading from a connection given by a
+// DialFunc. When a read from the connection fails, including when the
+// connection is closed by the source, or no data is received within the read
+// timeout, the connection is closed and a new one dialled. Read deadlines are
+// used for the timeout if the connection supports them, as a net.Conn does.
+//
+// Failed attempts to connect are retried after a backoff, doubling from the
+// minimum to the maximum given by SetBackoff and reset once data is received,
+// until the limit given by SetMaxAttempts, if any, is reached or the reader
+// is closed. Read returns io.EOF once the reader is closed, so that decoding
+// ends normally.
+type ReconnectReader struct {
+	dial    DialFunc
+	timeout time.Duration
+
+	minBackoff, maxBackoff time.Duration
+	maxAttempts            int // Consecutive failed attempts before giving up, or 0 for no limit.
+
+	mu     sync.Mutex
+	conn   io.ReadCloser // Current connection, or nil if not connected.
+	r      io.Reader     // Reads from conn with the read timeout.
+	closed bool
+	done   chan struct{} // Closed by Close, to interrupt backoff.
+
+	backoff    time.Duration // Backoff before the next attempt to connect.
+	connected  bool          // Whether a connection has ever been made.
+	reconnects int
+}
+
+// NewReconnectReader returns a ReconnectReader using dial to connect to the
+// source, reconnecting if no data is received within timeout. The first
+// connection is made by the first call to Read.
+func NewReconnectReader(dial DialFunc, timeout time.Duration) (*ReconnectReader, error) {
+	if dial == nil {
+		return nil, errNoDial
+	}
+	if timeout <= 0 {
+		return nil, errBadTimeout
+	}
+	return &ReconnectReader{
+		dial:       dial,
+		timeout:    timeout,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+		backoff:    defaultMinBackoff,
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// SetBackoff sets the minimum and maximum backoff between attempts to
+// connect. The defaults are 100ms and 10s.
+func (r *ReconnectReader) SetBackoff(min, max time.Duration) error {
+	if min <= 0 || max < min {
+		return errBadBackoff
+	}
+	r.minBackoff, r.maxBackoff, r.backoff = min, max, min
+	return nil
+}
+
+// SetMaxAttempts sets the number of consecutive failed attempts to connect
+// after which Read returns the error of the last attempt. The default, 0,
+// retries until the reader is closed.
+func (r *ReconnectReader) SetMaxAttempts(n int) error {
+	if n < 0 {
+		return errBadMaxAttempts
+	}
+	r.maxAttempts = n
+	return nil
+}
+
+// Reconnects returns the number of times the source has been reconnected,
+// not counting the first connection.
+func (r *ReconnectReader) Reconnects() int {
+	return r.reconnects
+}
+
+// Read implements io.Reader, connecting to the source if not connected.
+// ErrReconnected is returned once after each reconnection, before any data
+// from the new connection.
+func (r *ReconnectReader) Read(p []byte) (int, error) {
+	for {
+		if r.r == nil {
+			reconnect := r.connected
+			err := r.connect()
+			if err != nil {
+				return 0, err
+			}
+			if reconnect {
+				r.reconnects++
+				return 0, ErrReconnected
+			}
+		}
+
+		n, err := r.r.Read(p)
+		if n > 0 {
+			r.backoff = r.minBackoff
+			if err != nil {
+				r.disconnect(err)
+			}
+			return n, nil
+		}
+		if err != nil {
+			r.disconnect(err)
+		}
+		if r.isClosed() {
+			return 0, io.EOF
+		}
+		if err == nil {
+			return 0, nil
+		}
+	}
+}
+
+// connect dials the source until a connection is made, waiting for the
+// backoff after each failed attempt. io.EOF is returned if the reader is
+// closed, and the error of the last attempt if the maximum number of
+// attempts is reached.
+func (r *ReconnectReader) connect() error {
+	for attempts := 1; ; attempts++ {
+		if r.isClosed() {
+			return io.EOF
+		}
+		conn, err := r.dial()
+		if err == nil {
+			r.mu.Lock()
+			if r.closed {
+				r.mu.Unlock()
+				conn.Close()
+				return io.EOF
+			}
+			r.conn = conn
+			r.r = newTimeoutReader(conn, r.timeout)
+			r.connected = true
+			r.mu.Unlock()
+			return nil
+		}
+		logger.Printf("warning: could not connect to source (attempt %d): %v\n", attempts, err)
+		if r.maxAttempts != 0 && attempts >= r.maxAttempts {
+			return fmt.Errorf("could not connect to source after %d attempts: %w", attempts, err)
+		}
+
+		t := time.NewTimer(r.backoff)
+		select {
+		case <-t.C:
+		case <-r.done:
+			t.Stop()
+			return io.EOF
+		}
+		r.backoff = nextBackoff(r.backoff, r.maxBackoff)
+	}
+}
+
+// disconnect closes the current connection following err, the error that
+// ended it, so that the next read reconnects.
+func (r *ReconnectReader) disconnect(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return
+	}
+	if !r.closed {
+		logger.Printf("warning: lost connection to source: %v\n", err)
+	}
+	r.conn.Close()
+	r.conn, r.r = nil, nil
+}
+
+// isClosed returns true if Close has been called.
+func (r *ReconnectReader) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// Close closes the current connection, if any, and stops any further
+// attempts to connect. It may be called while a Read is in progress, which
+// then returns io.EOF.
+func (r *ReconnectReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
+}
+
+// nextBackoff returns the backoff following d, being double d up to max.
+func nextBackoff(d, max time.Duration) time.Duration {
+	if d > max/2 {
+		return max
+	}
+	return 2 * d
+}
+
+// resync discards any partly received NAL unit following a reconnection of
+// the stream, see ErrReconnected, and skips slices until the next IDR
+// picture, as those before it may refer to pictures that were not received.
+// Parameter sets and SEI are still decoded, as the source may repeat them
+// ahead of the IDR picture.
+func (h *H264Reader) resync() {
+	h.IsStarted = false
+	h.start, h.scan = h.end, h.end
+	h.pictures = pictureDetector{}
+	h.awaitIDR = true
+	h.emit(Event{Type: EventReconnected, Offset: h.byteOffset, Detail: "resuming at next IDR picture"})
+}
+
+// skipUntilIDR returns true if the NAL unit is to be skipped, being a VCL
+// NAL unit received after a reconnection but before the next IDR picture.
+func (h *H264Reader) skipUntilIDR(nalUnit *NalUnit) bool {
+	if !h.awaitIDR || !nalUnit.Type.IsVCL() {
+		return false
+	}
+	if !nalUnit.Type.IsIDR() {
+		logger.Printf("info: skipped %s NAL unit awaiting IDR picture\n", nalUnit.Type)
+		return true
+	}
+	h.awaitIDR = false
+	return false
+}
+
+var (
+	errNoDial         = errors.New("no dial function")
+	errBadBackoff     = errors.New("backoff must be positive and minimum no greater than maximum")
+	errBadMaxAttempts = errors.New("maximum number of attempts must not be negative")
+)
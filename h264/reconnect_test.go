@@ -0,0 +1,203 @@
+/*
+NAME
+  reconnect_test.go
+
+DESCRIPTION
+  reconnect_test.go provides testing for functionality provided in
+  reconnect.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// testDialer returns a DialFunc giving a connection reading each of conns in
+// turn, after which dialling fails with errDialFailed.
+func testDialer(conns ...io.Reader) (DialFunc, *int) {
+	var dials int
+	return func() (io.ReadCloser, error) {
+		dials++
+		if len(conns) == 0 {
+			return nil, errDialFailed
+		}
+		c := conns[0]
+		conns = conns[1:]
+		return ioutil.NopCloser(c), nil
+	}, &dials
+}
+
+var errDialFailed = errors.New("dial failed")
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		d, max time.Duration
+		want   time.Duration
+	}{
+		{d: time.Second, max: 10 * time.Second, want: 2 * time.Second},
+		{d: 5 * time.Second, max: 10 * time.Second, want: 10 * time.Second},
+		{d: 6 * time.Second, max: 10 * time.Second, want: 10 * time.Second},
+		{d: 10 * time.Second, max: 10 * time.Second, want: 10 * time.Second},
+	}
+
+	for i, test := range tests {
+		got := nextBackoff(test.d, test.max)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestReconnectReader checks that the data of each connection is read in
+// turn, separated by ErrReconnected, and that reading ends with the error of
+// the last attempt to connect once the maximum number of attempts is reached.
+func TestReconnectReader(t *testing.T) {
+	dial, dials := testDialer(bytes.NewReader([]byte{1, 2}), bytes.NewReader([]byte{3}))
+	r, err := NewReconnectReader(dial, time.Second)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewReconnectReader", err)
+	}
+	err = r.SetBackoff(time.Millisecond, 2*time.Millisecond)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from SetBackoff", err)
+	}
+	err = r.SetMaxAttempts(3)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from SetMaxAttempts", err)
+	}
+
+	var got []byte
+	var errs []error
+	buf := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, err)
+		if !errors.Is(err, ErrReconnected) {
+			break
+		}
+	}
+
+	if !bytes.Equal(got, []byte{1, 2, 3}) {
+		t.Errorf("did not get expected data\nGot: %v\nWant: %v", got, []byte{1, 2, 3})
+	}
+	if len(errs) != 2 || !errors.Is(errs[0], ErrReconnected) || !errors.Is(errs[1], errDialFailed) {
+		t.Errorf("did not get expected errors\nGot: %v", errs)
+	}
+	if r.Reconnects() != 1 || *dials != 5 {
+		t.Errorf("did not get expected number of connections\nReconnects: %d\nDials: %d", r.Reconnects(), *dials)
+	}
+}
+
+// TestReconnectStalled checks that a connection stalled beyond the read
+// timeout is reconnected, and that Close interrupts attempts to connect.
+func TestReconnectStalled(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	var r *ReconnectReader
+	dials := 0
+	dial := func() (io.ReadCloser, error) {
+		dials++
+		switch dials {
+		case 1:
+			return client, nil
+		case 2:
+			return ioutil.NopCloser(bytes.NewReader([]byte{1})), nil
+		default:
+			r.Close()
+			return nil, errDialFailed
+		}
+	}
+	r, err := NewReconnectReader(dial, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewReconnectReader", err)
+	}
+
+	got, err := ioutil.ReadAll(reconnectedReader{r})
+	if err != nil {
+		t.Errorf("did not expect error: %v from ReadAll", err)
+	}
+	if !bytes.Equal(got, []byte{1}) || r.Reconnects() != 1 {
+		t.Errorf("did not get expected result\nGot: %v\nReconnects: %d", got, r.Reconnects())
+	}
+	if _, err := client.Write([]byte{0}); err == nil {
+		t.Error("stalled connection was not closed")
+	}
+}
+
+// reconnectedReader ignores ErrReconnected from a ReconnectReader.
+type reconnectedReader struct {
+	r *ReconnectReader
+}
+
+func (r reconnectedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if errors.Is(err, ErrReconnected) {
+		err = nil
+	}
+	return n, err
+}
+
+// TestResumeAtIDR checks that after the stream is reconnected, a partly
+// received NAL unit is discarded and slices are skipped until an IDR picture.
+func TestResumeAtIDR(t *testing.T) {
+	// The first connection ends part way through a slice, and the second
+	// begins part way through a NAL unit, followed by a slice to be skipped.
+	// Decoding resumes at the IDR slice, which fails to decode, so is
+	// discarded.
+	first := annexB(testSPS, testPPS, skipSlice(2, 1), skipSlice(2, 2))
+	first = first[:len(first)-2]
+	second := append([]byte{0xaa, 0xbb}, annexB(skipSlice(2, 3), testSPS, testPPS, testIDR, skipSlice(2, 1))...)
+
+	dial, _ := testDialer(bytes.NewReader(first), bytes.NewReader(second))
+	rr, err := NewReconnectReader(dial, time.Second)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewReconnectReader", err)
+	}
+	err = rr.SetMaxAttempts(1)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from SetMaxAttempts", err)
+	}
+
+	var events []Event
+	h, err := NewH264Reader(rr, WithRecovery(), WithEventHandler(func(e Event) {
+		events = append(events, e)
+		// Close once the last connection has been made.
+		if e.Type == EventReconnected {
+			rr.Close()
+		}
+	}))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = h.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+
+	if len(events) != 1 || events[0].Type != EventReconnected || events[0].Offset != len(first) {
+		t.Errorf("did not get expected events\nGot: %v", events)
+	}
+	// The repeated SPS begins a new VideoStream.
+	var slices []*SliceContext
+	for _, v := range h.VideoStreams {
+		slices = append(slices, v.Slices...)
+	}
+	if len(slices) != 2 || slices[0].Header.FrameNum != 1 || slices[1].Header.FrameNum != 1 || h.Discarded() != 1 {
+		t.Errorf("did not get expected slices\nGot: %d\nDiscarded: %d", len(slices), h.Discarded())
+	}
+}
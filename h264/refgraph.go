@@ -0,0 +1,233 @@
+/*
+NAME
+  refgraph.go
+
+DESCRIPTION
+  refgraph.go provides derivation of the reference dependency graph of the
+  frames of a stream, grouped by GOP, in a form that may be serialised for
+  visualisation, e.g. to find the frames affected by the loss of another.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// RefGraph is the reference dependency graph of the frames of a stream.
+type RefGraph struct {
+	GOPs []GOP `json:"gops"`
+}
+
+// GOP is a group of pictures, beginning with an IDR or intra coded picture.
+type GOP struct {
+	Frames []RefFrame `json:"frames"`
+}
+
+// RefFrame is a frame, or complementary field pair, of a reference graph.
+type RefFrame struct {
+	Index     int    `json:"index"`     // Index in decoding order, counting from 0.
+	Offset    int64  `json:"offset"`    // Stream byte offset of its first NAL unit.
+	Type      string `json:"type"`      // "I", "P" or "B", for the slices of the picture.
+	IDR       bool   `json:"idr"`       // An IDR picture.
+	Reference bool   `json:"reference"` // A reference picture, i.e. nal_ref_idc is not 0.
+	Field     bool   `json:"field"`     // Coded as fields rather than as a frame.
+	Refs      []int  `json:"refs"`      // Indices of frames that may be referenced, possibly in earlier GOPs.
+}
+
+// ReadRefGraph reads the Annex B byte stream from r and returns the reference
+// graph of its frames. Only NAL unit and slice headers are parsed, so the
+// references of a frame are those reference frames that may be referred to,
+// rather than those that are: decoded reference picture marking is assumed to
+// use the sliding window process of section 8.2.5.3, so a P or B frame may
+// refer to any of the last max_num_ref_frames reference frames since the last
+// IDR frame. The two fields of a complementary field pair are treated as a
+// single frame, while an unpaired field is treated as a frame.
+func ReadRefGraph(r io.Reader) (*RefGraph, error) {
+	h, err := NewH264Reader(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		g       RefGraph
+		dpb     []int        // Indices of reference frames, oldest first.
+		frame   *RefFrame    // Frame being read.
+		first   *SliceHeader // Header of the first field of the frame being read, if unpaired.
+		maxRefs int
+		n       int
+	)
+	// endFrame adds the frame being read to the graph.
+	endFrame := func() {
+		if frame == nil {
+			return
+		}
+		if frame.IDR {
+			dpb = dpb[:0]
+		}
+		if frame.Type != "I" {
+			frame.Refs = append([]int(nil), dpb...)
+		}
+		if frame.IDR || frame.Type == "I" || len(g.GOPs) == 0 {
+			g.GOPs = append(g.GOPs, GOP{})
+		}
+		gop := &g.GOPs[len(g.GOPs)-1]
+		gop.Frames = append(gop.Frames, *frame)
+		if frame.Reference {
+			dpb = append(dpb, frame.Index)
+			if len(dpb) > maxRefs {
+				dpb = dpb[len(dpb)-maxRefs:]
+			}
+		}
+		frame = nil
+	}
+
+	for {
+		nalUnit, err := h.nextNalUnit()
+		if errors.Is(err, io.EOF) {
+			endFrame()
+			return &g, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read NAL unit: %w", err)
+		}
+
+		switch nalUnit.Type {
+		case NALTypeSPS, NALTypeSubsetSPS, NALTypePPS:
+			err = h.handleParameterSet(nalUnit)
+			if err != nil {
+				return nil, newParseError(nalUnit, err)
+			}
+			continue
+		case NALTypeSliceNonIDRPicture, NALTypeSlicePartA, NALTypeSliceIDRPicture:
+		default:
+			continue
+		}
+
+		t, err := sliceType(nalUnit.RBSP())
+		if err != nil {
+			return nil, newParseError(nalUnit, err)
+		}
+		if frame == nil || startsPicture(nalUnit) {
+			ppsID, err := slicePPSID(nalUnit.RBSP())
+			if err != nil {
+				return nil, newParseError(nalUnit, err)
+			}
+			sps, _, err := h.ParameterSets.Active(ppsID)
+			if err != nil {
+				return nil, newParseError(nalUnit, err)
+			}
+
+			// Only streams that may be interlaced have field_pic_flag, so only
+			// then is frame_num needed to pair fields.
+			var header *SliceHeader
+			if !sps.FrameMbsOnly {
+				br := bits.NewBitReader(bytes.NewReader(nalUnit.RBSP()))
+				header, err = readSliceHeaderStart(br, nalUnit, sps)
+				if err != nil {
+					return nil, newParseError(nalUnit, err)
+				}
+			}
+			if frame != nil && first != nil && header != nil && secondField(first, header) {
+				first = nil
+				frame.Reference = frame.Reference || nalUnit.RefIdc != 0
+			} else {
+				endFrame()
+				maxRefs = sps.MaxNumRefFrames
+				if maxRefs < 1 {
+					maxRefs = 1
+				}
+				frame = &RefFrame{
+					Index:     n,
+					Offset:    nalUnit.Offset,
+					Type:      "I",
+					IDR:       nalUnit.Type.IsIDR(),
+					Reference: nalUnit.RefIdc != 0,
+				}
+				first = nil
+				if header != nil && header.FieldPic {
+					frame.Field = true
+					first = header
+				}
+				n++
+			}
+		}
+		frame.Type = pictureType(frame.Type, t)
+	}
+}
+
+// pictureType returns the type of a picture of type cur, being "I", "P" or
+// "B", having a slice of the given slice_type. A picture is of the most
+// general type of its slices, with SP and SI slices being treated as P and I.
+func pictureType(cur string, sliceType int) string {
+	switch sliceTypeMap[sliceType] {
+	case "B":
+		return "B"
+	case "P", "SP":
+		if cur == "I" {
+			return "P"
+		}
+	}
+	return cur
+}
+
+// Dependents returns the indices, in increasing order, of frames that depend
+// directly or indirectly on the frame with the given index, and so may be
+// corrupted by its loss.
+func (g *RefGraph) Dependents(index int) []int {
+	affected := map[int]bool{index: true}
+	var deps []int
+	for _, gop := range g.GOPs {
+		for _, f := range gop.Frames {
+			if f.Index <= index {
+				continue
+			}
+			for _, ref := range f.Refs {
+				if affected[ref] {
+					affected[f.Index] = true
+					deps = append(deps, f.Index)
+					break
+				}
+			}
+		}
+	}
+	sort.Ints(deps)
+	return deps
+}
+
+// WriteDOT writes the graph to w in the Graphviz DOT language, with a cluster
+// for each GOP and an edge from each frame to each frame it may reference.
+func (g *RefGraph) WriteDOT(w io.Writer) error {
+	_, err := fmt.Fprintln(w, "digraph refs {")
+	if err != nil {
+		return err
+	}
+	for i, gop := range g.GOPs {
+		fmt.Fprintf(w, "\tsubgraph cluster_%d {\n\t\tlabel=\"GOP %d\";\n", i, i)
+		for _, f := range gop.Frames {
+			style := "solid"
+			if !f.Reference {
+				style = "dashed"
+			}
+			fmt.Fprintf(w, "\t\t%d [label=\"%d %s\", style=%s];\n", f.Index, f.Index, f.Type, style)
+		}
+		fmt.Fprintln(w, "\t}")
+	}
+	for _, gop := range g.GOPs {
+		for _, f := range gop.Frames {
+			for _, ref := range f.Refs {
+				fmt.Fprintf(w, "\t%d -> %d;\n", f.Index, ref)
+			}
+		}
+	}
+	_, err = fmt.Fprintln(w, "}")
+	return err
+}
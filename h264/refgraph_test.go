@@ -0,0 +1,139 @@
+/*
+NAME
+  refgraph_test.go
+
+DESCRIPTION
+  refgraph_test.go provides testing for functionality provided in refgraph.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestReadRefGraph checks the reference graph of a stream with
+// max_num_ref_frames of 2, a non-reference frame and an open GOP.
+func TestReadRefGraph(t *testing.T) {
+	sps := append([]byte{0x67}, binToSlice(
+		"01000010 00000000 00011110"+ // profile_idc 66, constraints, level_idc 30.
+			"1 1 011 011 0 1 1 1 1 0 0"+ // max_num_ref_frames 2.
+			"1", // rbsp_stop_one_bit.
+	)...)
+	nonRef := []byte{0x01, 0x9a} // P slice with nal_ref_idc 0.
+	stream := annexB(sps, testPPS, testIDR, testNonIDR, testNonIDR, nonRef, testNonIDR, testI, testNonIDR)
+
+	g, err := ReadRefGraph(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from ReadRefGraph", err)
+	}
+
+	want := &RefGraph{GOPs: []GOP{
+		{Frames: []RefFrame{
+			{Index: 0, Type: "I", IDR: true, Reference: true},
+			{Index: 1, Type: "P", Reference: true, Refs: []int{0}},
+			{Index: 2, Type: "P", Reference: true, Refs: []int{0, 1}},
+			{Index: 3, Type: "P", Refs: []int{1, 2}},
+			{Index: 4, Type: "P", Reference: true, Refs: []int{1, 2}},
+		}},
+		{Frames: []RefFrame{
+			{Index: 5, Type: "I", Reference: true},
+			{Index: 6, Type: "P", Reference: true, Refs: []int{4, 5}},
+		}},
+	}}
+	var prev int64 = -1
+	for i := range g.GOPs {
+		for j := range g.GOPs[i].Frames {
+			f := &g.GOPs[i].Frames[j]
+			if f.Offset <= prev {
+				t.Errorf("frame %d offset %d not after previous %d", f.Index, f.Offset, prev)
+			}
+			prev = f.Offset
+			f.Offset = 0
+		}
+	}
+	if !reflect.DeepEqual(g, want) {
+		t.Fatalf("did not get expected graph\nGot: %+v\nWant: %+v", g, want)
+	}
+
+	deps := []struct {
+		frame int
+		want  []int
+	}{
+		{frame: 0, want: []int{1, 2, 3, 4, 6}},
+		{frame: 1, want: []int{2, 3, 4, 6}},
+		{frame: 3, want: nil},
+		{frame: 5, want: []int{6}},
+	}
+	for i, test := range deps {
+		got := g.Dependents(test.frame)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+
+	var dot strings.Builder
+	err = g.WriteDOT(&dot)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from WriteDOT", err)
+	}
+	for _, s := range []string{"subgraph cluster_1", "3 [label=\"3 P\", style=dashed];", "6 -> 4;"} {
+		if !strings.Contains(dot.String(), s) {
+			t.Errorf("DOT output does not contain %q:\n%s", s, dot.String())
+		}
+	}
+}
+
+// TestReadRefGraphFields checks that the fields of complementary field pairs
+// of an interlaced stream are grouped into frames, and that unpaired fields
+// are treated as frames.
+func TestReadRefGraphFields(t *testing.T) {
+	sps := append([]byte{0x67}, binToSlice(
+		"01001101 00000000 00011110"+ // profile_idc 77, constraints, level_idc 30.
+			"1 1 011 011 0 1 1 0 0 1 0 0"+ // max_num_ref_frames 2, frame_mbs_only_flag 0.
+			"1", // rbsp_stop_one_bit.
+	)...)
+
+	// Slices giving first_mb_in_slice, slice_type, pic_parameter_set_id,
+	// frame_num, field_pic_flag and bottom_field_flag.
+	slice := func(nalHeader byte, s string) []byte {
+		return append([]byte{nalHeader}, binToSlice(s+"1")...)
+	}
+	stream := annexB(sps, testPPS,
+		slice(0x65, "1 0001000 1 0000 1 0"), // IDR I top field.
+		slice(0x41, "1 0001000 1 0000 1 1"), // I bottom field, paired.
+		slice(0x41, "1 00110 1 0001 1 1"),   // P bottom field.
+		slice(0x41, "1 00110 1 0001 1 0"),   // P top field, paired.
+		slice(0x41, "1 00110 1 0010 1 0"),   // P top field, unpaired.
+		slice(0x41, "1 00110 1 0011 0"),     // P frame.
+		slice(0x01, "1 00110 1 0100 1 0"),   // P top field, non-reference.
+		slice(0x41, "1 00110 1 0100 1 1"),   // P bottom field, paired and reference.
+	)
+
+	g, err := ReadRefGraph(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from ReadRefGraph", err)
+	}
+	for i := range g.GOPs[0].Frames {
+		g.GOPs[0].Frames[i].Offset = 0
+	}
+
+	want := &RefGraph{GOPs: []GOP{
+		{Frames: []RefFrame{
+			{Index: 0, Type: "I", IDR: true, Reference: true, Field: true},
+			{Index: 1, Type: "P", Reference: true, Field: true, Refs: []int{0}},
+			{Index: 2, Type: "P", Reference: true, Field: true, Refs: []int{0, 1}},
+			{Index: 3, Type: "P", Reference: true, Refs: []int{1, 2}},
+			{Index: 4, Type: "P", Reference: true, Field: true, Refs: []int{2, 3}},
+		}},
+	}}
+	if !reflect.DeepEqual(g, want) {
+		t.Fatalf("did not get expected graph\nGot: %+v\nWant: %+v", g, want)
+	}
+}
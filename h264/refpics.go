@@ -0,0 +1,252 @@
+/*
+NAME
+  refpics.go
+
+DESCRIPTION
+  refpics.go provides the reference frames retained while decoding, from
+  which the reference picture lists of slices are initialised, and the
+  motion of which is taken by macroblocks predicted in direct mode.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"math"
+)
+
+// refFrame is a decoded reference frame, holding what later pictures need of
+// it: its frame_num and PicOrderCnt for the initialisation of reference
+// picture lists, and the motion of its macroblocks for when it is the
+// co-located picture of macroblocks predicted in direct mode (8.4.1.2.1).
+type refFrame struct {
+	frameNum int
+	poc      int
+
+	// For each list, mv is the motion vector of each 4x4 block and refIdx
+	// the reference index of each 8x8 partition, as held by mbState, with
+	// refPOC the PicOrderCnt of the frame referred to, or unknownPOC. Intra
+	// and missing macroblocks have reference indices of -1 and motion
+	// vectors of 0.
+	mv     [2][]motionVector
+	refIdx [2][]int8
+	refPOC [2][]int
+}
+
+// unknownPOC is the refPOC of a partition whose reference picture lists
+// were not known.
+const unknownPOC = math.MinInt32
+
+// capture sets f to the frame decoded in a.
+func (f *refFrame) capture(a *arena) {
+	s := a.mbs
+	f.frameNum, f.poc = a.first.FrameNum, a.poc
+	for list := range f.mv {
+		f.mv[list] = append(f.mv[list][:0], s.mv[list][:s.n*blocksPerMb]...)
+		f.refIdx[list] = append(f.refIdx[list][:0], s.refIdx[list][:s.n*partitionsPerMb]...)
+		if cap(f.refPOC[list]) < len(f.refIdx[list]) {
+			f.refPOC[list] = make([]int, len(f.refIdx[list]))
+		}
+		f.refPOC[list] = f.refPOC[list][:len(f.refIdx[list])]
+	}
+	for mbAddr := 0; mbAddr < s.n; mbAddr++ {
+		sliceNum := s.sliceNum[mbAddr]
+		for list := range f.mv {
+			for i := mbAddr * partitionsPerMb; i < (mbAddr+1)*partitionsPerMb; i++ {
+				if sliceNum < 0 {
+					f.refIdx[list][i] = -1
+				}
+				f.refPOC[list][i] = unknownPOC
+				if r := int(f.refIdx[list][i]); r >= 0 {
+					f.refPOC[list][i] = a.lists[sliceNum].poc(list, r)
+				}
+			}
+			if sliceNum < 0 {
+				mv := f.mv[list][mbAddr*blocksPerMb : (mbAddr+1)*blocksPerMb]
+				for i := range mv {
+					mv[i] = motionVector{}
+				}
+			}
+		}
+	}
+}
+
+// refFrames holds the frames marked as used for short-term reference, oldest
+// first, as marked by the sliding window process (8.2.5.3) and cleared by IDR
+// pictures. Long-term reference frames, adaptive reference picture marking
+// and reference fields are not supported, so when used err records why the
+// frames held may not be those marked, until the next IDR picture.
+type refFrames struct {
+	frames []*refFrame
+	err    error
+	free   []*refFrame // No longer held, for reuse.
+}
+
+// add marks the picture decoded in a as a reference frame if it is a
+// reference picture, once it has been finished, see finishPicture. A picture
+// is only added once.
+func (r *refFrames) add(a *arena) {
+	h := a.first
+	if a.marked || h == nil || a.mbs == nil {
+		return
+	}
+	a.marked = true
+	if h.IdrPic {
+		r.free = append(r.free, r.frames...)
+		r.frames = r.frames[:0]
+		r.err = nil
+	}
+	switch {
+	case !h.IsReference():
+		return
+	case h.LongTermReferenceFlag:
+		r.err = errLongTermRef
+	case h.AdaptiveRefPicMarkingModeFlag:
+		r.err = errAdaptiveMarking
+	case h.FieldPic || a.mbs.mbaff:
+		r.err = errFieldRef
+	}
+	if r.err != nil {
+		return
+	}
+
+	// The frame is captured before any is freed, as it refers to the frames
+	// of the lists of its slices.
+	var f *refFrame
+	if n := len(r.free); n > 0 {
+		f, r.free = r.free[n-1], r.free[:n-1]
+	} else {
+		f = &refFrame{}
+	}
+	f.capture(a)
+	maxRefs := a.sps.MaxNumRefFrames
+	if maxRefs < 1 {
+		maxRefs = 1
+	}
+	if n := len(r.frames) - maxRefs + 1; n > 0 {
+		r.free = append(r.free, r.frames[:n]...)
+		r.frames = append(r.frames[:0], r.frames[n:]...)
+	}
+	r.frames = append(r.frames, f)
+}
+
+// refPicLists are the reference picture lists RefPicList0 and RefPicList1 of
+// a slice, or err if they are not known.
+type refPicLists struct {
+	list [2][]*refFrame
+	err  error
+}
+
+// poc returns the PicOrderCnt of the frame with reference index refIdx in
+// the given list, or unknownPOC if it is not known.
+func (l *refPicLists) poc(list, refIdx int) int {
+	if l.err != nil || refIdx >= len(l.list[list]) {
+		return unknownPOC
+	}
+	return l.list[list][refIdx].poc
+}
+
+// init sets l to the initial reference picture lists (8.2.4.2) of the frame
+// slice with header h, using sps, of the picture with PicOrderCnt poc, from
+// the reference frames r. For P and SP slices RefPicList0 is ordered by
+// descending FrameNumWrap (8.2.4.2.1), and for B slices RefPicList0 and
+// RefPicList1 by PicOrderCnt (8.2.4.2.3). The lists of slices using
+// reference picture list modification are not known.
+func (l *refPicLists) init(r *refFrames, h *SliceHeader, sps *SPS, poc int) {
+	l.list[0], l.list[1], l.err = l.list[0][:0], l.list[1][:0], nil
+	switch sliceTypeMap[h.SliceType] {
+	case "P", "SP":
+		if l.err = r.listErr(h, h.RefPicListModificationFlagL0); l.err != nil {
+			return
+		}
+		maxFrameNum := 1 << uint(sps.Log2MaxFrameNumMinus4+4)
+		wrap := func(f *refFrame) int {
+			if f.frameNum > h.FrameNum {
+				return f.frameNum - maxFrameNum
+			}
+			return f.frameNum
+		}
+		l.list[0] = append(l.list[0], r.frames...)
+		sortFrames(l.list[0], func(a, b *refFrame) bool { return wrap(a) > wrap(b) })
+	case "B":
+		if l.err = r.listErr(h, h.RefPicListModificationFlagL0 || h.RefPicListModificationFlagL1); l.err != nil {
+			return
+		}
+		for list := range l.list {
+			// Those before the current picture, nearest first, then those
+			// after it, nearest first, for list 0, and the reverse for list 1.
+			before := list == 0
+			l.list[list] = append(l.list[list], r.frames...)
+			sortFrames(l.list[list], func(a, b *refFrame) bool {
+				if (a.poc < poc) != (b.poc < poc) {
+					return (a.poc < poc) == before
+				}
+				if a.poc < poc {
+					return a.poc > b.poc
+				}
+				return a.poc < b.poc
+			})
+		}
+		if len(l.list[1]) > 1 && equalFrames(l.list[0], l.list[1]) {
+			l.list[1][0], l.list[1][1] = l.list[1][1], l.list[1][0]
+		}
+	default:
+		return
+	}
+	for list, n := range [2]int{h.NumRefIdxL0ActiveMinus1 + 1, h.NumRefIdxL1ActiveMinus1 + 1} {
+		if len(l.list[list]) > n {
+			l.list[list] = l.list[list][:n]
+		}
+	}
+}
+
+// listErr returns why the reference picture lists of the slice with header
+// h, which uses reference picture list modification if modified, cannot be
+// derived from r, if they cannot.
+func (r *refFrames) listErr(h *SliceHeader, modified bool) error {
+	switch {
+	case r == nil:
+		return errNoRefFrames
+	case r.err != nil:
+		return r.err
+	case h.FieldPic:
+		return errFieldRef
+	case modified:
+		return errListModification
+	}
+	return nil
+}
+
+// sortFrames sorts frames, of which there are few, by less.
+func sortFrames(frames []*refFrame, less func(a, b *refFrame) bool) {
+	for i := 1; i < len(frames); i++ {
+		for j := i; j > 0 && less(frames[j], frames[j-1]); j-- {
+			frames[j], frames[j-1] = frames[j-1], frames[j]
+		}
+	}
+}
+
+// equalFrames returns true if a and b hold the same frames in the same order.
+func equalFrames(a, b []*refFrame) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Errors for reference frames and lists that cannot be derived.
+var (
+	errLongTermRef      = errors.New("long-term reference frames not supported")
+	errAdaptiveMarking  = errors.New("adaptive reference picture marking not supported")
+	errFieldRef         = errors.New("reference fields not supported")
+	errListModification = errors.New("reference picture list modification not supported")
+	errNoRefFrames      = errors.New("reference frames not retained")
+)
@@ -0,0 +1,184 @@
+/*
+NAME
+  refpics_test.go
+
+DESCRIPTION
+  refpics_test.go provides testing for functionality provided in refpics.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRefPicListsInit(t *testing.T) {
+	// Reference frames in decoding order, given by frame_num and
+	// PicOrderCnt, the frame_num of the last having wrapped.
+	frames := []*refFrame{
+		{frameNum: 13, poc: 0},
+		{frameNum: 14, poc: 16},
+		{frameNum: 15, poc: 8},
+		{frameNum: 0, poc: 24},
+	}
+	tests := []struct {
+		sliceType int
+		header    SliceHeader
+		poc       int
+		err       error // Of the reference frames.
+		want      [2][]int
+		wantErr   error
+	}{
+		// P: descending FrameNumWrap.
+		{
+			sliceType: 0,
+			header:    SliceHeader{FrameNum: 1, NumRefIdxL0ActiveMinus1: 3},
+			want:      [2][]int{{24, 8, 16, 0}, {}},
+		},
+
+		// P: truncated to the number of active reference indices.
+		{
+			sliceType: 0,
+			header:    SliceHeader{FrameNum: 1, NumRefIdxL0ActiveMinus1: 1},
+			want:      [2][]int{{24, 8}, {}},
+		},
+
+		// B: those before the current picture then those after, and the
+		// reverse.
+		{
+			sliceType: 1,
+			header:    SliceHeader{NumRefIdxL0ActiveMinus1: 3, NumRefIdxL1ActiveMinus1: 3},
+			poc:       12,
+			want:      [2][]int{{8, 0, 16, 24}, {16, 24, 8, 0}},
+		},
+
+		// B: list 1 equal to list 0 has its first two entries switched.
+		{
+			sliceType: 1,
+			header:    SliceHeader{NumRefIdxL0ActiveMinus1: 3, NumRefIdxL1ActiveMinus1: 0},
+			poc:       30,
+			want:      [2][]int{{24, 16, 8, 0}, {16}},
+		},
+
+		// I: no lists.
+		{sliceType: 2, want: [2][]int{{}, {}}},
+
+		// Lists using modification are not known.
+		{
+			sliceType: 1,
+			header:    SliceHeader{RefPicListModificationFlagL1: true},
+			wantErr:   errListModification,
+		},
+
+		// Nor those of reference frames not known.
+		{sliceType: 0, err: errAdaptiveMarking, wantErr: errAdaptiveMarking},
+	}
+
+	sps := &SPS{Log2MaxFrameNumMinus4: 0}
+	for i, test := range tests {
+		test.header.SliceType = test.sliceType
+		r := &refFrames{frames: frames, err: test.err}
+		var l refPicLists
+		l.init(r, &test.header, sps, test.poc)
+		if !errors.Is(l.err, test.wantErr) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, l.err, test.wantErr)
+		}
+		if test.wantErr != nil {
+			continue
+		}
+		got := [2][]int{{}, {}}
+		for list := range l.list {
+			for _, f := range l.list[list] {
+				got[list] = append(got[list], f.poc)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestRefFramesAdd(t *testing.T) {
+	// Pictures in decoding order, by frame_num, with PicOrderCnt twice it.
+	tests := []struct {
+		header  SliceHeader
+		want    []int // frame_num of the reference frames held after.
+		wantErr error
+	}{
+		{header: SliceHeader{IdrPic: true, NalRefIdc: 3}, want: []int{0}},
+		{header: SliceHeader{FrameNum: 1, NalRefIdc: 2}, want: []int{0, 1}},
+		{header: SliceHeader{FrameNum: 2}, want: []int{0, 1}},
+		{header: SliceHeader{FrameNum: 2, NalRefIdc: 2}, want: []int{1, 2}},
+		{header: SliceHeader{FrameNum: 3, NalRefIdc: 2, AdaptiveRefPicMarkingModeFlag: true}, want: []int{1, 2}, wantErr: errAdaptiveMarking},
+		{header: SliceHeader{FrameNum: 4, NalRefIdc: 2}, want: []int{1, 2}, wantErr: errAdaptiveMarking},
+		{header: SliceHeader{IdrPic: true, NalRefIdc: 3}, want: []int{0}},
+	}
+
+	var r refFrames
+	a := &arena{sps: &SPS{MaxNumRefFrames: 2}}
+	a.mbState(1, 1)
+	for i, test := range tests {
+		a.reset()
+		a.first, a.poc = &test.header, 2*test.header.FrameNum
+		a.sliceLists(&test.header, a.sps)
+		a.mbs.beginMb(0, a.mbs.startSlice(), mbIntraCoded)
+		r.add(a)
+		r.add(a) // Not added again.
+		got := []int{}
+		for _, f := range r.frames {
+			got = append(got, f.frameNum)
+			if f.poc != 2*f.frameNum {
+				t.Errorf("did not get expected PicOrderCnt of frame %d for test: %d\nGot: %v\nWant: %v", f.frameNum, i, f.poc, 2*f.frameNum)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+		if !errors.Is(r.err, test.wantErr) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, r.err, test.wantErr)
+		}
+	}
+}
+
+func TestRefFrameCapture(t *testing.T) {
+	// A P macroblock referring to index 1 of list 0, and a macroblock not
+	// decoded, with stale motion.
+	a := &arena{sps: &SPS{}, poc: 6}
+	mbs := a.mbState(2, 1)
+	a.first = &SliceHeader{FrameNum: 3, SliceType: 0, NumRefIdxL0ActiveMinus1: 1}
+	r := &refFrames{frames: []*refFrame{{frameNum: 1, poc: 2}, {frameNum: 2, poc: 4}}}
+	a.refs = r
+	a.sliceLists(a.first, a.sps)
+	mbs.beginMb(0, mbs.startSlice(), 0)
+	mbs.setRefIdx(0, 0, 0, 0, 16, 16, 1)
+	mbs.setMv(0, 0, 0, 0, 16, 16, motionVector{3, -3})
+	mbs.refIdx[0][partitionsPerMb] = 0
+	mbs.mv[0][blocksPerMb] = motionVector{5, 5}
+
+	var f refFrame
+	f.capture(a)
+	if f.frameNum != 3 || f.poc != 6 {
+		t.Errorf("did not get expected frame_num and PicOrderCnt\nGot: %d, %d\nWant: 3, 6", f.frameNum, f.poc)
+	}
+	for _, test := range []struct {
+		mbAddr int
+		refIdx int8
+		refPOC int
+		mv     motionVector
+	}{
+		{mbAddr: 0, refIdx: 1, refPOC: 2, mv: motionVector{3, -3}},
+		{mbAddr: 1, refIdx: -1, refPOC: unknownPOC},
+	} {
+		part, blk := test.mbAddr*partitionsPerMb+3, test.mbAddr*blocksPerMb
+		got := []interface{}{f.refIdx[0][part], f.refPOC[0][part], f.mv[0][blk]}
+		want := []interface{}{test.refIdx, test.refPOC, test.mv}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("did not get expected motion of macroblock %d\nGot: %v\nWant: %v", test.mbAddr, got, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+NAME
+  refresh.go
+
+DESCRIPTION
+  refresh.go provides tracking of the progress of intra refresh after decoding
+  is resumed at an arbitrary point of a stream, so that the first picture from
+  which output is correct may be found without requiring an IDR picture.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// refreshTracker tracks whether decoding, resumed at an arbitrary point, has
+// reached a picture from which output is correct, see WithIntraRefresh. This
+// is the case at an IDR picture, or at the recovery point given by a recovery
+// point SEI message, as used by intra refresh encoders in place of IDR
+// pictures. A picture is only taken as such once all of its slices have been
+// seen to be of it, being IDR slices or slices with the frame_num of the
+// recovery point.
+type refreshTracker struct {
+	refreshed  bool
+	pending    *RecoveryPoint // Recovery point SEI for the next picture.
+	target     int            // frame_num of the recovery point, if haveTarget.
+	haveTarget bool
+	flags      FrameFlags // Flags of slices of the current picture.
+
+	// detail describes the refresh point of the current picture, if it is
+	// one, its first slice being at offset.
+	detail string
+	offset int
+}
+
+// recoveryPoint records a recovery point SEI message, which is associated
+// with the next picture.
+func (r *refreshTracker) recoveryPoint(rp *RecoveryPoint) {
+	if !r.refreshed {
+		r.pending = rp
+	}
+}
+
+// trackRefresh updates the progress of the refresh with the slice NAL unit,
+// using the given SPS, returning the flags for the slice: FrameRefreshing if
+// the refresh is not yet complete and the slice is not of a refresh point,
+// and otherwise none. The refresh is complete once all slices of a refresh
+// point have been seen, see completeRefresh.
+func (h *H264Reader) trackRefresh(sps *SPS, nalUnit *NalUnit) FrameFlags {
+	r := h.refresh
+	if r.refreshed {
+		return r.flags
+	}
+	first := startsPicture(nalUnit)
+	if first {
+		h.completeRefresh()
+		if r.refreshed {
+			return r.flags
+		}
+	}
+
+	var detail string
+	switch {
+	case nalUnit.Type.IsIDR():
+		detail = "IDR picture"
+	default:
+		frameNum, err := sliceFrameNum(sps, nalUnit.RBSP())
+		if err != nil {
+			logger.Printf("warning: could not track refresh: %v\n", err)
+			break
+		}
+		if first && r.pending != nil {
+			maxFrameNum := 1 << uint(sps.Log2MaxFrameNumMinus4+4)
+			r.target = (frameNum + r.pending.RecoveryFrameCnt) % maxFrameNum
+			r.haveTarget = true
+		}
+		if r.haveTarget && frameNum == r.target {
+			detail = fmt.Sprintf("recovery point at frame_num %d", frameNum)
+		}
+	}
+	if first {
+		r.pending = nil
+		r.detail, r.offset = detail, int(nalUnit.Offset)
+	}
+
+	if detail == "" || detail != r.detail {
+		r.detail = ""
+		return FrameRefreshing
+	}
+	return 0
+}
+
+// completeRefresh completes the refresh if the current picture, all slices
+// of which must have been seen, is a refresh point, emitting
+// EventRefreshComplete.
+func (h *H264Reader) completeRefresh() {
+	r := h.refresh
+	if r == nil || r.refreshed || r.detail == "" {
+		return
+	}
+	r.refreshed = true
+	r.flags = 0
+	h.emit(Event{Type: EventRefreshComplete, Offset: r.offset, Detail: r.detail})
+}
+
+// Refreshed returns true if, with WithIntraRefresh, decoding has reached a
+// picture from which output is correct, or if WithIntraRefresh is not used.
+func (h *H264Reader) Refreshed() bool {
+	return h.refresh == nil || h.refresh.refreshed
+}
+
+// awaitingRefresh returns true if err, from decoding a NAL unit, is due to a
+// missing parameter set before decoding has refreshed with WithIntraRefresh.
+// Such errors are expected when resuming at an arbitrary point, before the
+// parameter sets are repeated.
+func (h *H264Reader) awaitingRefresh(err error) bool {
+	var missing *MissingParameterSetError
+	return err != nil && !h.Refreshed() && errors.As(err, &missing)
+}
+
+// sliceFrameNum returns the frame_num of the slice with the given RBSP, using
+// the given SPS.
+func sliceFrameNum(sps *SPS, rbsp []byte) (_ int, err error) {
+	br := bits.NewBitReader(bytes.NewReader(rbsp))
+	defer func() { err = withBitPos(br, err) }()
+
+	for _, name := range []string{"first_mb_in_slice", "slice_type", "pic_parameter_set_id"} {
+		_, err := readUe(br)
+		if err != nil {
+			return 0, fmt.Errorf("could not parse %s: %w", name, err)
+		}
+	}
+	if sps.UseSeparateColorPlane {
+		_, err = br.ReadBits(2)
+		if err != nil {
+			return 0, fmt.Errorf("could not read colour_plane_id: %w", err)
+		}
+	}
+	n, err := br.ReadBits(sps.Log2MaxFrameNumMinus4 + 4)
+	if err != nil {
+		return 0, fmt.Errorf("could not read frame_num: %w", err)
+	}
+	return int(n), nil
+}
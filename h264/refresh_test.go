@@ -0,0 +1,181 @@
+/*
+NAME
+  refresh_test.go
+
+DESCRIPTION
+  refresh_test.go provides testing for functionality provided in refresh.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// pSlice returns a P slice NAL unit using PPS 0 with the given
+// first_mb_in_slice and 4 bit frame_num.
+func pSlice(firstMb, frameNum int) []byte {
+	return append([]byte{0x41}, binToSlice(ueBits(firstMb)+"00110 1"+fmt.Sprintf("%04b", frameNum)+"1")...)
+}
+
+// TestTrackRefresh checks the flags given to slices while resuming, and that
+// the refresh completes at an IDR picture or the recovery point given by a
+// recovery point SEI, once all of its slices are seen to be of it.
+func TestTrackRefresh(t *testing.T) {
+	sps := &SPS{ProfileIDC: 66, MaxNumRefFrames: 1}
+	type step struct {
+		nal       []byte
+		recovery  int // recovery_frame_cnt of an SEI before the NAL unit, if not -1.
+		wantFlags FrameFlags
+	}
+	tests := []struct {
+		steps         []step
+		wantRefreshed bool
+	}{
+		{
+			steps: []step{
+				{nal: pSlice(0, 5), recovery: -1, wantFlags: FrameRefreshing},
+				{nal: pSlice(0, 6), recovery: 2, wantFlags: FrameRefreshing},
+				{nal: pSlice(1, 6), recovery: -1, wantFlags: FrameRefreshing},
+				{nal: pSlice(0, 7), recovery: -1, wantFlags: FrameRefreshing},
+				{nal: pSlice(0, 8), recovery: -1},
+				{nal: pSlice(1, 8), recovery: -1},
+				{nal: pSlice(0, 9), recovery: -1},
+			},
+			wantRefreshed: true,
+		},
+		{
+			steps: []step{
+				{nal: pSlice(0, 15), recovery: 2, wantFlags: FrameRefreshing},
+				{nal: pSlice(0, 0), recovery: -1, wantFlags: FrameRefreshing},
+				{nal: pSlice(0, 1), recovery: -1},
+			},
+			wantRefreshed: true,
+		},
+		{
+			steps: []step{
+				{nal: pSlice(1, 3), recovery: -1, wantFlags: FrameRefreshing},
+				{nal: testIDR, recovery: -1},
+			},
+			wantRefreshed: true,
+		},
+		{
+			steps:         []step{{nal: pSlice(0, 3), recovery: 0}},
+			wantRefreshed: true,
+		},
+
+		// An intra coded picture other than an IDR picture is not a refresh
+		// point, nor is a picture with a slice not of the refresh point.
+		{
+			steps: []step{{nal: testI, recovery: -1, wantFlags: FrameRefreshing}},
+		},
+		{
+			steps: []step{
+				{nal: testIDR, recovery: -1},
+				{nal: pSlice(1, 0), recovery: -1, wantFlags: FrameRefreshing},
+			},
+		},
+		{
+			steps: []step{
+				{nal: pSlice(0, 4), recovery: 0},
+				{nal: pSlice(1, 5), recovery: -1, wantFlags: FrameRefreshing},
+				{nal: pSlice(2, 4), recovery: -1, wantFlags: FrameRefreshing},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		var events []Event
+		h, err := NewH264Reader(nil, WithIntraRefresh(), WithEventHandler(func(e Event) { events = append(events, e) }))
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader", err)
+		}
+		for j, s := range test.steps {
+			if s.recovery >= 0 {
+				h.refresh.recoveryPoint(&RecoveryPoint{RecoveryFrameCnt: s.recovery})
+			}
+			nalUnit, err := NewNalUnit(s.nal, len(s.nal))
+			if err != nil {
+				t.Fatalf("did not expect error: %v from NewNalUnit", err)
+			}
+			got := h.trackRefresh(sps, nalUnit)
+			if got != s.wantFlags {
+				t.Errorf("did not get expected flags for test: %d, step: %d\nGot: %v\nWant: %v", i, j, got, s.wantFlags)
+			}
+		}
+		h.completeRefresh()
+		if h.Refreshed() != test.wantRefreshed || len(events) != flagVal(test.wantRefreshed) || len(events) == 1 && events[0].Type != EventRefreshComplete {
+			t.Errorf("did not get expected refresh completion for test: %d\nRefreshed: %v\nEvents: %v", i, h.Refreshed(), events)
+		}
+	}
+}
+
+// TestIntraRefreshResume checks that a stream may be decoded from a point
+// before its parameter sets, and that a recovery point SEI is used to find
+// where the refresh completes.
+func TestIntraRefreshResume(t *testing.T) {
+	var sei bytes.Buffer
+	err := WriteSEI(&sei, &RecoveryPoint{RecoveryFrameCnt: 2, ExactMatch: true})
+	if err != nil {
+		t.Fatalf("did not expect error: %v from WriteSEI", err)
+	}
+	stream := annexB(pSlice(0, 5), testSPS, testPPS, sei.Bytes(), pSlice(0, 6), pSlice(0, 7), pSlice(0, 8))
+
+	// Without WithIntraRefresh the missing PPS is an error.
+	h, err := NewH264Reader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	var missing *MissingParameterSetError
+	if err := h.Start(); !errors.As(err, &missing) {
+		t.Errorf("did not get expected error without WithIntraRefresh\nGot: %v", err)
+	}
+
+	var events []Event
+	h, err = NewH264Reader(bytes.NewReader(stream), WithIntraRefresh(), WithRecovery(),
+		WithEventHandler(func(e Event) { events = append(events, e) }))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = h.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+	if !h.Refreshed() || len(events) != 1 || events[0].Type != EventRefreshComplete {
+		t.Fatalf("did not get expected refresh completion\nRefreshed: %v\nEvents: %v", h.Refreshed(), events)
+	}
+	want := bytes.LastIndex(stream, pSlice(0, 8))
+	if events[0].Offset != want {
+		t.Errorf("did not get expected refresh offset\nGot: %d\nWant: %d", events[0].Offset, want)
+	}
+}
+
+// TestRecoveryPointRoundTrip checks that recovery point SEI payloads are
+// parsed back after being written.
+func TestRecoveryPointRoundTrip(t *testing.T) {
+	tests := []RecoveryPoint{
+		{},
+		{RecoveryFrameCnt: 29, ExactMatch: true, ChangingSliceGroupIDC: 2},
+		{RecoveryFrameCnt: 300, BrokenLink: true},
+	}
+	for i, want := range tests {
+		b, err := want.MarshalBinary()
+		if err != nil {
+			t.Fatalf("did not expect error: %v from MarshalBinary for test: %d", err, i)
+		}
+		var got RecoveryPoint
+		err = got.UnmarshalBinary(b)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from UnmarshalBinary for test: %d", err, i)
+		}
+		if got != want {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, got, want)
+		}
+	}
+}
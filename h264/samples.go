@@ -0,0 +1,82 @@
+/*
+NAME
+  samples.go
+
+DESCRIPTION
+  samples.go provides the location of the samples of macroblocks within the
+  frame into which the picture being decoded is constructed, for frames,
+  fields and the frame and field macroblocks of MBAFF frames.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "image"
+
+// mbSamples holds the samples of a macroblock within the planes of the frame
+// holding the picture being decoded, as located by the inverse macroblock
+// scanning process of section 6.4.1. Rows of a field macroblock, whether of a
+// field or of an MBAFF frame, are alternate rows of the frame.
+type mbSamples struct {
+	// Y, Cb and Cr planes, each beginning at the top left sample of the
+	// macroblock, the chroma planes being nil for monochrome pictures. For
+	// pictures with separate colour planes, the luma plane is that of the
+	// slice's colour_plane_id.
+	plane  [3][]byte
+	stride [3]int // Distance in the planes between rows of the macroblock.
+}
+
+// newMbSamples returns the mbSamples of the macroblock with address mbAddr of
+// the picture the slice with header h belongs to, within the frame pic,
+// fieldMb being mb_field_decoding_flag of the macroblock in MBAFF frames.
+func newMbSamples(pic *image.YCbCr, sps *SPS, h *SliceHeader, mbAddr int, fieldMb bool) mbSamples {
+	w := PicWidthInMbs(sps)
+	x, y := (mbAddr%w)*16, (mbAddr/w)*16
+	field, bottom := h.FieldPic, h.BottomField
+	if MbaffFrameFlag(sps, h) == 1 {
+		pair := mbAddr / 2
+		x, y = (pair%w)*16, (pair/w)*32
+		if fieldMb {
+			// The rows of the field of the frame, from the pair's first.
+			y /= 2
+			field, bottom = true, mbAddr%2 == 1
+		} else {
+			y += (mbAddr % 2) * 16
+		}
+	}
+
+	// locate returns the part of plane p with the given stride beginning at
+	// the sample (x, y) of the frame or field.
+	locate := func(p []byte, stride, x, y int) ([]byte, int) {
+		if field {
+			return p[(2*y+flagVal(bottom))*stride+x:], 2 * stride
+		}
+		return p[y*stride+x:], stride
+	}
+
+	var s mbSamples
+	luma := pic.Y
+	if sps.UseSeparateColorPlane {
+		luma = [][]byte{pic.Y, pic.Cb, pic.Cr}[h.ColorPlaneID]
+	}
+	s.plane[0], s.stride[0] = locate(luma, pic.YStride, x, y)
+	if sps.ChromaArrayType() != chromaMonochrome {
+		subW, subH := SubWidthC(sps), SubHeightC(sps)
+		s.plane[1], s.stride[1] = locate(pic.Cb, pic.CStride, x/subW, y/subH)
+		s.plane[2], s.stride[2] = locate(pic.Cr, pic.CStride, x/subW, y/subH)
+	}
+	return s
+}
+
+// set sets the sample at (x, y) relative to the macroblock of component c,
+// being 0 for luma, 1 for Cb and 2 for Cr.
+func (s *mbSamples) set(c, x, y, v int) {
+	s.plane[c][y*s.stride[c]+x] = byte(v)
+}
+
+// get returns the sample at (x, y) relative to the macroblock of component c.
+func (s *mbSamples) get(c, x, y int) int {
+	return int(s.plane[c][y*s.stride[c]+x])
+}
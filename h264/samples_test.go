@@ -0,0 +1,60 @@
+/*
+NAME
+  samples_test.go
+
+DESCRIPTION
+  samples_test.go provides testing for functionality provided in samples.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"image"
+	"testing"
+)
+
+// TestNewMbSamples checks the location in the frame of the sample at (1, 1)
+// of each component of a macroblock of a 2x2 macroblock 4:2:0 frame.
+func TestNewMbSamples(t *testing.T) {
+	tests := []struct {
+		mbaff, fieldPic, bottom, fieldMb bool
+		mbAddr                           int
+		wantY, wantC                     image.Point
+	}{
+		// Frame.
+		{mbAddr: 3, wantY: image.Pt(17, 17), wantC: image.Pt(9, 9)},
+
+		// Bottom field, with macroblocks of 16 alternate rows.
+		{fieldPic: true, bottom: true, mbAddr: 1, wantY: image.Pt(17, 3), wantC: image.Pt(9, 3)},
+
+		// Bottom frame and field macroblocks of the second pair of an MBAFF
+		// frame.
+		{mbaff: true, mbAddr: 3, wantY: image.Pt(17, 17), wantC: image.Pt(9, 9)},
+		{mbaff: true, fieldMb: true, mbAddr: 3, wantY: image.Pt(17, 3), wantC: image.Pt(9, 3)},
+		{mbaff: true, fieldMb: true, mbAddr: 2, wantY: image.Pt(17, 2), wantC: image.Pt(9, 2)},
+	}
+
+	for i, test := range tests {
+		sps := &SPS{ChromaFormat: chroma420, PicWidthInMbsMinus1: 1, FrameMbsOnly: true, MBAdaptiveFrameField: test.mbaff}
+		if test.mbaff || test.fieldPic {
+			sps.FrameMbsOnly = false
+		}
+		pic := image.NewYCbCr(image.Rect(0, 0, 32, 32), image.YCbCrSubsampleRatio420)
+		h := &SliceHeader{FieldPic: test.fieldPic, BottomField: test.bottom}
+		s := newMbSamples(pic, sps, h, test.mbAddr, test.fieldMb)
+		for c := 0; c < 3; c++ {
+			s.set(c, 1, 1, 10+c)
+		}
+
+		if got := pic.Y[pic.YOffset(test.wantY.X, test.wantY.Y)]; got != 10 {
+			t.Errorf("did not get expected luma sample for test: %d\nGot: %d\nWant: 10", i, got)
+		}
+		off := test.wantC.Y*pic.CStride + test.wantC.X
+		if pic.Cb[off] != 11 || pic.Cr[off] != 12 {
+			t.Errorf("did not get expected chroma samples for test: %d\nGot: %d, %d\nWant: 11, 12", i, pic.Cb[off], pic.Cr[off])
+		}
+	}
+}
@@ -0,0 +1,448 @@
+/*
+NAME
+  scalability.go
+
+DESCRIPTION
+  scalability.go provides parsing of the scalability information and scalable
+  nesting SEI messages of SVC streams (Annex G), so that the layer structure
+  of such streams may be reported even though only their base layer is
+  decoded.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// ScalabilityInfo is a scalability_info SEI message, as specified in section
+// G.13.1.1, describing the layers of a scalable stream. Only the layer
+// descriptions are retained; priority layer information and the priority_id
+// setting URI are not parsed.
+type ScalabilityInfo struct {
+	TemporalIDNesting bool
+	Layers            []ScalableLayer
+}
+
+// ScalableLayer describes a layer of a scalable stream, as given in a
+// scalability_info SEI message. Elements whose presence flags are not set
+// have zero values.
+type ScalableLayer struct {
+	LayerID      int
+	PriorityID   int
+	Discardable  bool
+	DependencyID int
+	QualityID    int
+	TemporalID   int
+
+	ProfileLevelIDC int // 24 bit profile_idc, constraint flags and level_idc.
+
+	// Bitrates in units of 1000 bits per second.
+	AvgBitrate int
+	MaxBitrate int // max_bitrate_layer.
+
+	AvgFrameRate int // In units of frames per 256 seconds.
+
+	FrameWidthInMbs  int
+	FrameHeightInMbs int
+
+	// LayerIDs of the layers on which this layer directly depends, if given.
+	DependsOn []int
+}
+
+// PayloadType returns SEIScalabilityInfo.
+func (s *ScalabilityInfo) PayloadType() int { return SEIScalabilityInfo }
+
+// UnmarshalBinary decodes a scalability_info payload.
+func (s *ScalabilityInfo) UnmarshalBinary(b []byte) (err error) {
+	br := bits.NewBitReader(bytes.NewReader(b))
+	defer func() { err = withBitPos(br, err) }()
+
+	var priorityLayerInfo, priorityIDSetting bool
+	err = readFlags(br, []flag{
+		{&s.TemporalIDNesting, "TemporalIDNesting"},
+		{&priorityLayerInfo, "PriorityLayerInfoPresent"},
+		{&priorityIDSetting, "PriorityIDSetting"},
+	})
+	if err != nil {
+		return err
+	}
+	numLayersMinus1, err := readUe(br)
+	if err != nil {
+		return fmt.Errorf("could not parse num_layers_minus1: %w", err)
+	}
+	if numLayersMinus1 > 2047 {
+		return fmt.Errorf("num_layers_minus1 %d out of range", numLayersMinus1)
+	}
+	s.Layers = make([]ScalableLayer, numLayersMinus1+1)
+	for i := range s.Layers {
+		err = readScalableLayer(br, &s.Layers[i])
+		if err != nil {
+			return fmt.Errorf("could not parse layer %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// readScalableLayer reads the description of a layer in a scalability_info
+// SEI message into l.
+func readScalableLayer(br *bits.BitReader, l *ScalableLayer) error {
+	var err error
+	l.LayerID, err = readUe(br)
+	if err != nil {
+		return fmt.Errorf("could not parse layer_id: %w", err)
+	}
+
+	var subPic, subRegion, iroi, profileLevel, bitrate, frameRate, frameSize,
+		dependency, paramSets, restriction, exactInterLayer, conversion, output bool
+	err = readFields(br, []field{
+		{&l.PriorityID, "PriorityID", 6},
+	})
+	if err != nil {
+		return err
+	}
+	err = readFlags(br, []flag{{&l.Discardable, "Discardable"}})
+	if err != nil {
+		return err
+	}
+	err = readFields(br, []field{
+		{&l.DependencyID, "DependencyID", 3},
+		{&l.QualityID, "QualityID", 4},
+		{&l.TemporalID, "TemporalID", 3},
+	})
+	if err != nil {
+		return err
+	}
+	err = readFlags(br, []flag{
+		{&subPic, "SubPicLayer"},
+		{&subRegion, "SubRegionLayer"},
+		{&iroi, "IROIDivisionInfoPresent"},
+		{&profileLevel, "ProfileLevelInfoPresent"},
+		{&bitrate, "BitrateInfoPresent"},
+		{&frameRate, "FrmRateInfoPresent"},
+		{&frameSize, "FrmSizeInfoPresent"},
+		{&dependency, "LayerDependencyInfoPresent"},
+		{&paramSets, "ParameterSetsInfoPresent"},
+		{&restriction, "BitstreamRestrictionInfoPresent"},
+		{&exactInterLayer, "ExactInterLayerPred"},
+	})
+	if err != nil {
+		return err
+	}
+	if subPic || iroi {
+		_, err = br.ReadBits(1) // exact_sample_value_match_flag.
+		if err != nil {
+			return fmt.Errorf("could not read ExactSampleValueMatch: %w", err)
+		}
+	}
+	err = readFlags(br, []flag{{&conversion, "LayerConversion"}, {&output, "LayerOutput"}})
+	if err != nil {
+		return err
+	}
+
+	if profileLevel {
+		err = readFields(br, []field{{&l.ProfileLevelIDC, "LayerProfileLevelIDC", 24}})
+		if err != nil {
+			return err
+		}
+	}
+	if bitrate {
+		var maxRepresentation, window int
+		err = readFields(br, []field{
+			{&l.AvgBitrate, "AvgBitrate", 16},
+			{&l.MaxBitrate, "MaxBitrateLayer", 16},
+			{&maxRepresentation, "MaxBitrateLayerRepresentation", 16},
+			{&window, "MaxBitrateCalcWindow", 16},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if frameRate {
+		var constant int
+		err = readFields(br, []field{
+			{&constant, "ConstantFrmRateIDC", 2},
+			{&l.AvgFrameRate, "AvgFrmRate", 16},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if frameSize || iroi {
+		l.FrameWidthInMbs, err = readUe(br)
+		if err != nil {
+			return fmt.Errorf("could not parse frm_width_in_mbs_minus1: %w", err)
+		}
+		l.FrameHeightInMbs, err = readUe(br)
+		if err != nil {
+			return fmt.Errorf("could not parse frm_height_in_mbs_minus1: %w", err)
+		}
+		l.FrameWidthInMbs++
+		l.FrameHeightInMbs++
+	}
+	if subRegion {
+		_, err = readUe(br) // base_region_layer_id.
+		if err != nil {
+			return fmt.Errorf("could not parse base_region_layer_id: %w", err)
+		}
+		b, err := br.ReadBits(1) // dynamic_rect_flag.
+		if err != nil {
+			return fmt.Errorf("could not read DynamicRect: %w", err)
+		}
+		if b == 0 {
+			// horizontal_offset, vertical_offset, region_width and
+			// region_height.
+			for j := 0; j < 4; j++ {
+				_, err = br.ReadBits(16)
+				if err != nil {
+					return fmt.Errorf("could not read region: %w", err)
+				}
+			}
+		}
+	}
+	if subPic {
+		_, err = readUe(br) // roi_id.
+		if err != nil {
+			return fmt.Errorf("could not parse roi_id: %w", err)
+		}
+	}
+	if iroi {
+		err = skipIROIDivisionInfo(br)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dependency {
+		n, err := readUe(br)
+		if err != nil {
+			return fmt.Errorf("could not parse num_directly_dependent_layers: %w", err)
+		}
+		if n > 2048 {
+			return fmt.Errorf("num_directly_dependent_layers %d out of range", n)
+		}
+		l.DependsOn = make([]int, n)
+		for j := range l.DependsOn {
+			delta, err := readUe(br)
+			if err != nil {
+				return fmt.Errorf("could not parse directly_dependent_layer_id_delta_minus1: %w", err)
+			}
+			l.DependsOn[j] = l.LayerID - delta - 1
+		}
+	} else {
+		_, err = readUe(br) // layer_dependency_info_src_layer_id_delta.
+		if err != nil {
+			return fmt.Errorf("could not parse layer_dependency_info_src_layer_id_delta: %w", err)
+		}
+	}
+
+	if paramSets {
+		for _, name := range []string{"num_seq_parameter_sets", "num_subset_seq_parameter_sets", "num_pic_parameter_sets_minus1"} {
+			n, err := readUe(br)
+			if err != nil {
+				return fmt.Errorf("could not parse %s: %w", name, err)
+			}
+			if name == "num_pic_parameter_sets_minus1" {
+				n++
+			}
+			err = skipUes(br, n)
+			if err != nil {
+				return fmt.Errorf("could not parse parameter set ID deltas: %w", err)
+			}
+		}
+	} else {
+		_, err = readUe(br) // parameter_sets_info_src_layer_id_delta.
+		if err != nil {
+			return fmt.Errorf("could not parse parameter_sets_info_src_layer_id_delta: %w", err)
+		}
+	}
+
+	if restriction {
+		_, err = br.ReadBits(1) // motion_vectors_over_pic_boundaries_flag.
+		if err != nil {
+			return fmt.Errorf("could not read MotionVectorsOverPicBoundaries: %w", err)
+		}
+		// max_bytes_per_pic_denom to max_dec_frame_buffering.
+		err = skipUes(br, 6)
+		if err != nil {
+			return fmt.Errorf("could not parse bitstream restriction info: %w", err)
+		}
+	}
+
+	if conversion {
+		_, err = readUe(br) // conversion_type_idc.
+		if err != nil {
+			return fmt.Errorf("could not parse conversion_type_idc: %w", err)
+		}
+		for j := 0; j < 2; j++ {
+			b, err := br.ReadBits(1) // rewriting_info_flag.
+			if err != nil {
+				return fmt.Errorf("could not read RewritingInfo: %w", err)
+			}
+			if b == 1 {
+				// rewriting_profile_level_idc, avg_bitrate and max_bitrate.
+				_, err = br.ReadBits(56)
+				if err != nil {
+					return fmt.Errorf("could not read rewriting info: %w", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// skipIROIDivisionInfo skips the interactive region of interest division
+// information of a layer in a scalability_info SEI message.
+func skipIROIDivisionInfo(br *bits.BitReader) error {
+	grid, err := br.ReadBits(1)
+	if err != nil {
+		return fmt.Errorf("could not read IROIGrid: %w", err)
+	}
+	if grid == 1 {
+		// grid_width_in_mbs_minus1 and grid_height_in_mbs_minus1.
+		return skipUes(br, 2)
+	}
+	numROIsMinus1, err := readUe(br)
+	if err != nil {
+		return fmt.Errorf("could not parse num_rois_minus1: %w", err)
+	}
+	if numROIsMinus1 > 1<<16 {
+		return fmt.Errorf("num_rois_minus1 %d out of range", numROIsMinus1)
+	}
+	// first_mb_in_roi, roi_width_in_mbs_minus1 and roi_height_in_mbs_minus1.
+	return skipUes(br, 3*(numROIsMinus1+1))
+}
+
+// skipUes reads and discards n ue(v) syntax elements.
+func skipUes(br *bits.BitReader, n int) error {
+	for i := 0; i < n; i++ {
+		_, err := readUe(br)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LayerRepresentation identifies a layer representation of a scalable stream
+// by its dependency_id and quality_id.
+type LayerRepresentation struct {
+	DependencyID int
+	QualityID    int
+}
+
+// ScalableNesting is a scalable_nesting SEI message, as specified in section
+// G.13.1.4, holding SEI messages that apply to the given layer
+// representations, or to all of those in the access unit. It implements
+// SEIPayload.
+type ScalableNesting struct {
+	AllLayerRepresentations bool
+	LayerRepresentations    []LayerRepresentation // If not AllLayerRepresentations.
+	TemporalID              int                   // If not AllLayerRepresentations.
+	Messages                []RawSEI
+}
+
+// PayloadType implements SEIPayload.
+func (n *ScalableNesting) PayloadType() int { return SEIScalableNesting }
+
+// MarshalBinary implements SEIPayload.
+func (n *ScalableNesting) MarshalBinary() ([]byte, error) {
+	if len(n.Messages) == 0 {
+		return nil, fmt.Errorf("no nested SEI messages")
+	}
+	if !n.AllLayerRepresentations && len(n.LayerRepresentations) == 0 {
+		return nil, fmt.Errorf("no layer representations")
+	}
+	var buf bytes.Buffer
+	bw := bits.NewBitWriter(&buf)
+	w := &rbspWriter{bw: bw}
+	w.flag(n.AllLayerRepresentations)
+	if !n.AllLayerRepresentations {
+		w.ue(len(n.LayerRepresentations) - 1)
+		for _, r := range n.LayerRepresentations {
+			w.u(uint64(r.DependencyID), 3)
+			w.u(uint64(r.QualityID), 4)
+		}
+		w.u(uint64(n.TemporalID), 3)
+	}
+	for !bw.ByteAligned() {
+		w.u(0, 1) // sei_nesting_zero_bit.
+	}
+	for _, m := range n.Messages {
+		writeSEIValue(w, m.Type)
+		writeSEIValue(w, len(m.Payload))
+		for _, b := range m.Payload {
+			w.u(uint64(b), 8)
+		}
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a scalable_nesting payload. The nested messages
+// refer to b's storage.
+func (n *ScalableNesting) UnmarshalBinary(b []byte) (err error) {
+	br := bits.NewBitReader(bytes.NewReader(b))
+	defer func() { err = withBitPos(br, err) }()
+
+	err = readFlags(br, []flag{{&n.AllLayerRepresentations, "AllLayerRepresentationsInAU"}})
+	if err != nil {
+		return err
+	}
+	n.LayerRepresentations, n.TemporalID = nil, 0
+	if !n.AllLayerRepresentations {
+		numMinus1, err := readUe(br)
+		if err != nil {
+			return fmt.Errorf("could not parse num_layer_representations_minus1: %w", err)
+		}
+		if numMinus1 > 127 {
+			return fmt.Errorf("num_layer_representations_minus1 %d out of range", numMinus1)
+		}
+		n.LayerRepresentations = make([]LayerRepresentation, numMinus1+1)
+		for i := range n.LayerRepresentations {
+			r := &n.LayerRepresentations[i]
+			err = readFields(br, []field{
+				{&r.DependencyID, "SEIDependencyID", 3},
+				{&r.QualityID, "SEIQualityID", 4},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err = readFields(br, []field{{&n.TemporalID, "SEITemporalID", 3}})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The nested messages follow sei_nesting_zero_bits, and there is at least
+	// one of them.
+	i := (br.Off() + 7) / 8
+	n.Messages = nil
+	for len(n.Messages) == 0 || i < len(b) {
+		typ, m, err := readSEIValue(b[i:])
+		if err != nil {
+			return fmt.Errorf("could not read nested payloadType: %w", err)
+		}
+		i += m
+		size, m, err := readSEIValue(b[i:])
+		if err != nil {
+			return fmt.Errorf("could not read nested payloadSize: %w", err)
+		}
+		i += m
+		if i+size > len(b) {
+			return ErrShortSEI
+		}
+		n.Messages = append(n.Messages, RawSEI{Type: typ, Payload: b[i : i+size]})
+		i += size
+	}
+	return nil
+}
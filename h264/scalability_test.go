@@ -0,0 +1,152 @@
+/*
+NAME
+  scalability_test.go
+
+DESCRIPTION
+  scalability_test.go provides testing for functionality provided in
+  scalability.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// testScalabilityInfo is a scalability_info payload describing a base layer
+// and a spatial enhancement layer that depends on it.
+var testScalabilityInfo = binToSlice(
+	"1 0 0 010" + // temporal_id_nesting_flag, two layers.
+		// Layer 0 with profile, level, bitrate, frame rate and size.
+		"1 000000 0 000 0000 000 0001111 0000 01" +
+		"01000010 00000000 00011110" + // Baseline level 3.
+		"0000000111110100 0000001100100000 0000001100100000 0000001111101000" + // 500, 800, 800, 1000 kbps.
+		"01 0001111000000000" + // 30 fps.
+		"1 1 1 1" + // 1x1 MBs, dependency and parameter sets source layer deltas.
+		// Layer 1 with frame size, dependency, parameter sets and restrictions.
+		"010 000001 1 001 0000 001 0000001 1111 01" +
+		"010 010" + // 2x2 MBs.
+		"010 1" + // Depends on layer 0.
+		"010 1 1 1 1" + // One SPS, no subset SPS, one PPS.
+		"1 111111", // Bitstream restriction info.
+)
+
+// TestScalabilityInfo checks parsing of layer descriptions from a
+// scalability_info SEI payload.
+func TestScalabilityInfo(t *testing.T) {
+	var got ScalabilityInfo
+	err := got.UnmarshalBinary(testScalabilityInfo)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from UnmarshalBinary", err)
+	}
+	want := ScalabilityInfo{
+		TemporalIDNesting: true,
+		Layers: []ScalableLayer{
+			{
+				ProfileLevelIDC:  0x42001e,
+				AvgBitrate:       500,
+				MaxBitrate:       800,
+				AvgFrameRate:     7680,
+				FrameWidthInMbs:  1,
+				FrameHeightInMbs: 1,
+			},
+			{
+				LayerID:          1,
+				PriorityID:       1,
+				Discardable:      true,
+				DependencyID:     1,
+				TemporalID:       1,
+				FrameWidthInMbs:  2,
+				FrameHeightInMbs: 2,
+				DependsOn:        []int{0},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("did not get expected result\nGot: %+v\nWant: %+v", got, want)
+	}
+
+	err = got.UnmarshalBinary(testScalabilityInfo[:10])
+	if err == nil {
+		t.Errorf("did not get expected error for truncated payload")
+	}
+}
+
+// TestScalableNestingRoundTrip checks that scalable nesting SEI messages are
+// parsed back after being written.
+func TestScalableNestingRoundTrip(t *testing.T) {
+	nested := RawSEI{Type: SEIUserDataUnregistered, Payload: append(testUUID[:], 'G', 'P', 'S')}
+	tests := []*ScalableNesting{
+		{AllLayerRepresentations: true, Messages: []RawSEI{nested}},
+		{
+			LayerRepresentations: []LayerRepresentation{{DependencyID: 1}, {DependencyID: 1, QualityID: 1}},
+			TemporalID:           2,
+			Messages:             []RawSEI{nested, {Type: SEIRecoveryPoint, Payload: []byte{0x80}}},
+		},
+	}
+
+	for i, want := range tests {
+		var buf bytes.Buffer
+		err := WriteSEI(&buf, want)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from WriteSEI for test: %d", err, i)
+		}
+		nalUnit, err := NewNalUnit(buf.Bytes(), buf.Len())
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewNalUnit for test: %d", err, i)
+		}
+		msgs, err := ParseSEI(nalUnit.RBSP())
+		if err != nil {
+			t.Fatalf("did not expect error: %v from ParseSEI for test: %d", err, i)
+		}
+		if len(msgs) != 1 || msgs[0].Type != SEIScalableNesting {
+			t.Fatalf("did not get expected SEI messages for test: %d\nGot: %v", i, msgs)
+		}
+
+		got := &ScalableNesting{}
+		err = got.UnmarshalBinary(msgs[0].Payload)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from UnmarshalBinary for test: %d", err, i)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, got, want)
+		}
+	}
+
+	_, err := (&ScalableNesting{}).MarshalBinary()
+	if err == nil {
+		t.Errorf("did not get expected error for nesting without messages")
+	}
+}
+
+// TestReaderScalability checks that the reader retains the scalability
+// information of a stream, ignoring SEI NAL units that cannot be parsed.
+func TestReaderScalability(t *testing.T) {
+	var sei bytes.Buffer
+	err := WriteSEI(&sei, RawSEI{Type: SEIScalabilityInfo, Payload: testScalabilityInfo})
+	if err != nil {
+		t.Fatalf("did not expect error: %v from WriteSEI", err)
+	}
+	badSEI := []byte{0x06, 0x18, 0x10, 0x00, 0x80}
+
+	r, err := NewH264Reader(bytes.NewReader(annexB(testSPS, testPPS, sei.Bytes(), badSEI)))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	if r.Scalability() != nil {
+		t.Errorf("did not expect scalability information before reading")
+	}
+	err = r.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+	info := r.Scalability()
+	if info == nil || len(info.Layers) != 2 || info.Layers[1].DependencyID != 1 {
+		t.Errorf("did not get expected scalability information\nGot: %+v", info)
+	}
+}
@@ -0,0 +1,252 @@
+/*
+NAME
+  scaling.go
+
+DESCRIPTION
+  scaling.go provides parsing of scaling matrices from the SPS and PPS, with
+  resolution of lists that are not present using the fall-back rules of
+  table 7-2.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"fmt"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// Default scaling lists, as given in tables 7-3 and 7-4. Values are in
+// zig-zag scan order i.e. indexed by idx in the tables.
+var (
+	Default4x4IntraList = [16]int{6, 13, 13, 20, 20, 20, 28, 28, 28, 28, 32, 32, 32, 37, 37, 42}
+	Default4x4InterList = [16]int{10, 14, 14, 20, 20, 20, 24, 24, 24, 24, 27, 27, 27, 30, 30, 34}
+	Default8x8IntraList = [64]int{
+		6, 10, 10, 13, 11, 13, 16, 16, 16, 16, 18, 18, 18, 18, 18, 23,
+		23, 23, 23, 23, 23, 25, 25, 25, 25, 25, 25, 25, 27, 27, 27, 27,
+		27, 27, 27, 27, 29, 29, 29, 29, 29, 29, 29, 31, 31, 31, 31, 31,
+		31, 33, 33, 33, 33, 33, 36, 36, 36, 36, 38, 38, 38, 40, 40, 42}
+	Default8x8InterList = [64]int{
+		9, 13, 13, 15, 13, 15, 17, 17, 17, 17, 19, 19, 19, 19, 19, 21,
+		21, 21, 21, 21, 21, 22, 22, 22, 22, 22, 22, 22, 24, 24, 24, 24,
+		24, 24, 24, 24, 25, 25, 25, 25, 25, 25, 25, 27, 27, 27, 27, 27,
+		27, 28, 28, 28, 28, 28, 30, 30, 30, 30, 32, 32, 32, 33, 33, 35}
+)
+
+// ScalingMatrix holds resolved scaling lists for use in dequantisation. Lists
+// are in zig-zag scan order. List4x4 is indexed by i = 0..5 and List8x8 by
+// i-6 for i = 6..11, with i as in table 7-2, i.e. Intra Y, Cb, Cr followed by
+// Inter Y, Cb, Cr for 4x4, and Intra Y, Inter Y, Intra Cb, Inter Cb,
+// Intra Cr, Inter Cr for 8x8.
+type ScalingMatrix struct {
+	List4x4 [6][16]int
+	List8x8 [6][64]int
+}
+
+// FlatScalingMatrix returns the scaling matrix used when no scaling matrix is
+// present, with all entries 16, i.e. Flat_4x4_16 and Flat_8x8_16.
+func FlatScalingMatrix() ScalingMatrix {
+	var m ScalingMatrix
+	for i := range m.List4x4 {
+		for j := range m.List4x4[i] {
+			m.List4x4[i][j] = 16
+		}
+	}
+	for i := range m.List8x8 {
+		for j := range m.List8x8[i] {
+			m.List8x8[i][j] = 16
+		}
+	}
+	return m
+}
+
+// parseScalingMatrix parses n scaling_list_present_flag and corresponding
+// scaling lists, as found in the SPS and PPS, resolving lists that are not
+// present using fall-back rule A of table 7-2 if seq is nil, or fall-back
+// rule B using the sequence-level scaling matrix seq otherwise. The flags
+// giving the presence of each list are also returned.
+func parseScalingMatrix(br *bits.BitReader, n int, seq *ScalingMatrix) (ScalingMatrix, []bool, error) {
+	var m ScalingMatrix
+	present := make([]bool, n)
+	for i := 0; i < 12; i++ {
+		if i < n {
+			b, err := br.ReadBits(1)
+			if err != nil {
+				return m, nil, fmt.Errorf("could not read scaling_list_present_flag: %w", err)
+			}
+			present[i] = b == 1
+		}
+
+		var err error
+		switch {
+		case i < 6 && present[i]:
+			var useDefault bool
+			useDefault, err = scalingList(br, m.List4x4[i][:])
+			if useDefault {
+				m.List4x4[i] = defaultList4x4(i)
+			}
+		case i < 6:
+			m.List4x4[i] = fallBack4x4(&m, i, seq)
+		case i < n && present[i]:
+			var useDefault bool
+			useDefault, err = scalingList(br, m.List8x8[i-6][:])
+			if useDefault {
+				m.List8x8[i-6] = defaultList8x8(i)
+			}
+		default:
+			m.List8x8[i-6] = fallBack8x8(&m, i, seq)
+		}
+		if err != nil {
+			return m, nil, fmt.Errorf("could not parse scaling list %d: %w", i, err)
+		}
+	}
+	return m, present, nil
+}
+
+// defaultList4x4 returns the default 4x4 scaling list for list i.
+func defaultList4x4(i int) [16]int {
+	if i < 3 {
+		return Default4x4IntraList
+	}
+	return Default4x4InterList
+}
+
+// defaultList8x8 returns the default 8x8 scaling list for list i, i >= 6.
+func defaultList8x8(i int) [64]int {
+	if i%2 == 0 {
+		return Default8x8IntraList
+	}
+	return Default8x8InterList
+}
+
+// fallBack4x4 returns the 4x4 list to be used for list i when not present,
+// following table 7-2. The first list of each of the intra and inter groups
+// takes the default list under rule A (seq == nil) or the sequence-level list
+// under rule B, and the others take the previous list of their group.
+func fallBack4x4(m *ScalingMatrix, i int, seq *ScalingMatrix) [16]int {
+	switch {
+	case i != 0 && i != 3:
+		return m.List4x4[i-1]
+	case seq != nil:
+		return seq.List4x4[i]
+	default:
+		return defaultList4x4(i)
+	}
+}
+
+// fallBack8x8 returns the 8x8 list to be used for list i, i >= 6, when not
+// present, following table 7-2. The Y lists take the default list under rule
+// A (seq == nil) or the sequence-level list under rule B, and the Cb and Cr
+// lists take the list two before i.e. that of the previous component.
+func fallBack8x8(m *ScalingMatrix, i int, seq *ScalingMatrix) [64]int {
+	switch {
+	case i >= 8:
+		return m.List8x8[i-8]
+	case seq != nil:
+		return seq.List8x8[i-6]
+	default:
+		return defaultList8x8(i)
+	}
+}
+
+// scalingList parses a scaling_list syntax structure as defined in section
+// 7.3.2.1.1.1, storing values into list. True is returned if
+// useDefaultScalingMatrixFlag is inferred, in which case the default list
+// should be used instead.
+func scalingList(br *bits.BitReader, list []int) (bool, error) {
+	lastScale := 8
+	nextScale := 8
+	for j := range list {
+		if nextScale != 0 {
+			deltaScale, err := readSe(br)
+			if err != nil {
+				return false, fmt.Errorf("could not parse deltaScale: %w", err)
+			}
+			nextScale = (lastScale + deltaScale + 256) % 256
+			if j == 0 && nextScale == 0 {
+				return true, nil
+			}
+		}
+		if nextScale == 0 {
+			list[j] = lastScale
+		} else {
+			list[j] = nextScale
+		}
+		lastScale = list[j]
+	}
+	return false, nil
+}
+
+// writeScalingMatrix writes a scaling_list_present_flag for each of the lists
+// of m given by present, followed by the list itself where present, as parsed
+// by parseScalingMatrix.
+func writeScalingMatrix(w *rbspWriter, m *ScalingMatrix, present []bool) {
+	for i, p := range present {
+		w.flag(p)
+		switch {
+		case !p:
+		case i < 6:
+			def := defaultList4x4(i)
+			writeScalingList(w, m.List4x4[i][:], def[:])
+		default:
+			def := defaultList8x8(i)
+			writeScalingList(w, m.List8x8[i-6][:], def[:])
+		}
+	}
+}
+
+// writeScalingList writes list as a scaling_list syntax structure, signalling
+// useDefaultScalingMatrixFlag if it is equal to the default list def, and
+// ending the list early where its remaining values repeat.
+func writeScalingList(w *rbspWriter, list, def []int) {
+	if intsEqual(list, def) {
+		w.se(-8)
+		return
+	}
+	lastScale := 8
+	for j, v := range list {
+		if j > 0 && repeats(list[j:], lastScale) {
+			// A nextScale of 0 repeats lastScale to the end of the list.
+			w.se(wrapDeltaScale(-lastScale))
+			return
+		}
+		w.se(wrapDeltaScale(v - lastScale))
+		lastScale = v
+	}
+}
+
+// wrapDeltaScale returns the delta_scale in the range -128 to 127 giving the
+// same scale as d, scales being taken modulo 256.
+func wrapDeltaScale(d int) int {
+	d = (d%256 + 256) % 256
+	if d > 127 {
+		d -= 256
+	}
+	return d
+}
+
+// repeats returns true if every value of l is v.
+func repeats(l []int, v int) bool {
+	for _, x := range l {
+		if x != v {
+			return false
+		}
+	}
+	return true
+}
+
+// intsEqual returns true if a and b hold the same values.
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
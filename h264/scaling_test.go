@@ -0,0 +1,91 @@
+/*
+NAME
+  scaling_test.go
+
+DESCRIPTION
+  scaling_test.go provides testing for functionality provided in scaling.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// TestParseScalingMatrix checks parsing of explicit scaling lists, inference of
+// default lists, and resolution of lists not present using fall-back rules A
+// and B of table 7-2.
+func TestParseScalingMatrix(t *testing.T) {
+	// List 0 with deltas +2, +1, -11, giving 10 then 11 repeated.
+	var list0 [16]int
+	list0[0] = 10
+	for i := 1; i < 16; i++ {
+		list0[i] = 11
+	}
+
+	in := "1 00100 010 000010111" + // List 0 explicit.
+		"0" + // List 1 not present.
+		"1 000010001" + // List 2 uses default (delta -8).
+		"0 0 0 0" + // Lists 3 to 6 not present.
+		"1 000010001" // List 7 uses default.
+
+	var ruleA ScalingMatrix
+	ruleA.List4x4 = [6][16]int{list0, list0, Default4x4IntraList, Default4x4InterList, Default4x4InterList, Default4x4InterList}
+	ruleA.List8x8 = [6][64]int{Default8x8IntraList, Default8x8InterList, Default8x8IntraList, Default8x8InterList, Default8x8IntraList, Default8x8InterList}
+
+	got, present, err := parseScalingMatrix(bits.NewBitReader(bytes.NewReader(binToSlice(in))), 8, nil)
+	if err != nil {
+		t.Fatalf("did not expect error: %v for rule A", err)
+	}
+	if !reflect.DeepEqual(got, ruleA) {
+		t.Errorf("did not get expected matrix for rule A\nGot: %v\nWant: %v", got, ruleA)
+	}
+	wantPresent := []bool{true, false, true, false, false, false, false, true}
+	if !reflect.DeepEqual(present, wantPresent) {
+		t.Errorf("did not get expected present flags\nGot: %v\nWant: %v", present, wantPresent)
+	}
+
+	// Under rule B, lists not present take the sequence-level lists.
+	seq := FlatScalingMatrix()
+	seq.List4x4[0] = list0
+	seq.List4x4[3] = Default4x4IntraList
+	seq.List8x8[1] = Default8x8IntraList
+
+	var ruleB ScalingMatrix
+	ruleB.List4x4 = [6][16]int{list0, list0, list0, Default4x4IntraList, Default4x4IntraList, Default4x4IntraList}
+	ruleB.List8x8 = [6][64]int{seq.List8x8[0], Default8x8IntraList, seq.List8x8[0], Default8x8IntraList, seq.List8x8[0], Default8x8IntraList}
+
+	got, _, err = parseScalingMatrix(bits.NewBitReader(bytes.NewReader(binToSlice("000000"))), 6, &seq)
+	if err != nil {
+		t.Fatalf("did not expect error: %v for rule B", err)
+	}
+	if !reflect.DeepEqual(got, ruleB) {
+		t.Errorf("did not get expected matrix for rule B\nGot: %v\nWant: %v", got, ruleB)
+	}
+}
+
+// TestFlatScalingMatrix checks that parameter sets without scaling matrices
+// resolve to flat matrices.
+func TestFlatScalingMatrix(t *testing.T) {
+	sps, err := NewSPS(testSPS[1:], false)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewSPS", err)
+	}
+	if sps.ScalingMatrix != FlatScalingMatrix() {
+		t.Errorf("did not get flat scaling matrix for SPS")
+	}
+	pps, err := NewPPS(sps, testPPS[1:], false)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewPPS", err)
+	}
+	if pps.ScalingMatrix != sps.ScalingMatrix {
+		t.Errorf("PPS did not inherit SPS scaling matrix")
+	}
+}
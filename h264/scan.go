@@ -0,0 +1,68 @@
+/*
+NAME
+  scan.go
+
+DESCRIPTION
+  scan.go provides the inverse scanning of the lists of transform coefficient
+  levels of residual blocks to the arrays of transform coefficients, using
+  the zig-zag scan of frame macroblocks and the field scan of field
+  macroblocks, as specified by sections 8.5.6 and 8.5.7.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+// Locations of the coefficients of a 4x4 block in raster order, i.e. 4*y+x
+// for the coefficient at column x and row y, indexed by their position in
+// the 4x4 zig-zag and field scans (table 8-12).
+var (
+	zigZag4x4    = [16]int{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	fieldScan4x4 = [16]int{0, 4, 1, 8, 12, 5, 9, 13, 2, 6, 10, 14, 3, 7, 11, 15}
+)
+
+// Locations of the coefficients of an 8x8 block in raster order, i.e. 8*y+x
+// for the coefficient at column x and row y, indexed by their position in
+// the 8x8 zig-zag and field scans (table 8-13).
+var (
+	zigZag8x8 = [maxNumCoeff8x8]int{
+		0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+		12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+		35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+		58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+	}
+	fieldScan8x8 = [maxNumCoeff8x8]int{
+		0, 8, 16, 1, 9, 24, 32, 17, 2, 25, 40, 48, 56, 33, 10, 3,
+		18, 41, 49, 57, 26, 11, 4, 19, 34, 42, 50, 58, 27, 12, 5, 20,
+		35, 43, 51, 59, 28, 13, 6, 21, 36, 44, 52, 60, 29, 14, 22, 37,
+		45, 53, 61, 30, 7, 15, 38, 46, 54, 62, 23, 31, 39, 47, 55, 63,
+	}
+)
+
+// inverseScan4x4 sets c, the coefficients of a 4x4 block in raster order,
+// from the list of 16 coefficient levels, using the field scan if field and
+// otherwise the zig-zag scan, as inverseScan8x8 does (8.5.6).
+func inverseScan4x4(c *[16]int, list []int, field bool) {
+	scan := &zigZag4x4
+	if field {
+		scan = &fieldScan4x4
+	}
+	for k, v := range list {
+		c[scan[k]] = v
+	}
+}
+
+// inverseScan8x8 sets c, the coefficients of an 8x8 block in raster order,
+// from the list of 64 coefficient levels, using the field scan if field, as
+// for the field macroblocks of fields and MBAFF frames, and otherwise the
+// zig-zag scan (8.5.7).
+func inverseScan8x8(c *[maxNumCoeff8x8]int, list []int, field bool) {
+	scan := &zigZag8x8
+	if field {
+		scan = &fieldScan8x8
+	}
+	for k, v := range list {
+		c[scan[k]] = v
+	}
+}
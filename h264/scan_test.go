@@ -0,0 +1,84 @@
+/*
+NAME
+  scan_test.go
+
+DESCRIPTION
+  scan_test.go provides testing for functionality provided in scan.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+func TestInverseScan8x8(t *testing.T) {
+	tests := []struct {
+		field bool
+		idx   int // Index in the list of the coefficient.
+		x, y  int // Its location in the block.
+	}{
+		{idx: 0, x: 0, y: 0},
+		{idx: 2, x: 0, y: 1},
+		{idx: 9, x: 0, y: 3},
+		{idx: 35, x: 0, y: 7},
+		{idx: 42, x: 7, y: 1},
+		{idx: 63, x: 7, y: 7},
+		{field: true, idx: 2, x: 0, y: 2},
+		{field: true, idx: 12, x: 0, y: 7},
+		{field: true, idx: 15, x: 3, y: 0},
+		{field: true, idx: 52, x: 7, y: 0},
+		{field: true, idx: 63, x: 7, y: 7},
+	}
+
+	for i, test := range tests {
+		list := make([]int, maxNumCoeff8x8)
+		list[test.idx] = 1
+		var c [maxNumCoeff8x8]int
+		inverseScan8x8(&c, list, test.field)
+		if c[8*test.y+test.x] != 1 {
+			t.Errorf("did not get expected location for test: %d\nGot: %v\nWant: (%d, %d)", i, c, test.x, test.y)
+		}
+	}
+
+	// Each scan visits every location once.
+	for _, scan := range [][maxNumCoeff8x8]int{zigZag8x8, fieldScan8x8} {
+		var seen [maxNumCoeff8x8]bool
+		for _, pos := range scan {
+			seen[pos] = true
+		}
+		for pos, ok := range seen {
+			if !ok {
+				t.Errorf("scan does not visit location %d", pos)
+			}
+		}
+	}
+}
+
+func TestInverseScan4x4(t *testing.T) {
+	tests := []struct {
+		field bool
+		idx   int // Index in the list of the coefficient.
+		x, y  int // Its location in the block.
+	}{
+		{idx: 1, x: 1, y: 0},
+		{idx: 3, x: 0, y: 2},
+		{idx: 6, x: 3, y: 0},
+		{idx: 15, x: 3, y: 3},
+		{field: true, idx: 1, x: 0, y: 1},
+		{field: true, idx: 4, x: 0, y: 3},
+		{field: true, idx: 8, x: 2, y: 0},
+		{field: true, idx: 15, x: 3, y: 3},
+	}
+
+	for i, test := range tests {
+		list := make([]int, 16)
+		list[test.idx] = 1
+		var c [16]int
+		inverseScan4x4(&c, list, test.field)
+		if c[4*test.y+test.x] != 1 {
+			t.Errorf("did not get expected location for test: %d\nGot: %v\nWant: (%d, %d)", i, c, test.x, test.y)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+/*
+NAME
+  sei.go
+
+DESCRIPTION
+  sei.go provides encoding and parsing of supplemental enhancement information
+  (SEI) messages, as specified in section 7.3.2.3 and Annex D, and injection of
+  SEI NAL units into an Annex B byte stream.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// SEI payload types, as given in section D.1.1.
+const (
+	SEIBufferingPeriod      = 0
+	SEIPicTiming            = 1
+	SEIUserDataRegistered   = 4
+	SEIUserDataUnregistered = 5
+	SEIRecoveryPoint        = 6
+	SEIScalabilityInfo      = 24
+	SEIScalableNesting      = 30
+)
+
+// SEIPayload is implemented by SEI message payloads that can be encoded, so
+// that new payload types may be written without changes to this package.
+type SEIPayload interface {
+	// PayloadType returns the payloadType of the SEI message.
+	PayloadType() int
+
+	// MarshalBinary returns the sei_payload bytes of the SEI message.
+	MarshalBinary() ([]byte, error)
+}
+
+// RawSEI is an SEI message held as its payload type and undecoded payload
+// bytes. It implements SEIPayload.
+type RawSEI struct {
+	Type    int
+	Payload []byte
+}
+
+// PayloadType implements SEIPayload.
+func (m RawSEI) PayloadType() int { return m.Type }
+
+// MarshalBinary implements SEIPayload.
+func (m RawSEI) MarshalBinary() ([]byte, error) { return m.Payload, nil }
+
+// UserDataUnregistered is a user_data_unregistered SEI message, as defined in
+// section D.1.7, carrying arbitrary data identified by a UUID. It implements
+// SEIPayload.
+type UserDataUnregistered struct {
+	UUID [16]byte // uuid_iso_iec_11578.
+	Data []byte   // user_data_payload_byte.
+}
+
+// PayloadType implements SEIPayload.
+func (u *UserDataUnregistered) PayloadType() int { return SEIUserDataUnregistered }
+
+// MarshalBinary implements SEIPayload.
+func (u *UserDataUnregistered) MarshalBinary() ([]byte, error) {
+	return append(u.UUID[:len(u.UUID):len(u.UUID)], u.Data...), nil
+}
+
+// UnmarshalBinary decodes a user_data_unregistered payload.
+func (u *UserDataUnregistered) UnmarshalBinary(b []byte) error {
+	if len(b) < len(u.UUID) {
+		return ErrShortSEI
+	}
+	copy(u.UUID[:], b)
+	u.Data = append([]byte(nil), b[len(u.UUID):]...)
+	return nil
+}
+
+// RecoveryPoint is a recovery_point SEI message, as defined in section D.1.8,
+// indicating that decoding started at the picture it is associated with gives
+// correct output after RecoveryFrameCnt further frames. It implements
+// SEIPayload.
+type RecoveryPoint struct {
+	RecoveryFrameCnt      int
+	ExactMatch            bool
+	BrokenLink            bool
+	ChangingSliceGroupIDC int
+}
+
+// PayloadType implements SEIPayload.
+func (r *RecoveryPoint) PayloadType() int { return SEIRecoveryPoint }
+
+// MarshalBinary implements SEIPayload.
+func (r *RecoveryPoint) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	bw := bits.NewBitWriter(&buf)
+	w := &rbspWriter{bw: bw}
+	w.ue(r.RecoveryFrameCnt)
+	w.flag(r.ExactMatch)
+	w.flag(r.BrokenLink)
+	w.u(uint64(r.ChangingSliceGroupIDC), 2)
+	if w.err == nil && !bw.ByteAligned() {
+		// The payload is completed with sei_payload_alignment bits.
+		w.u(1, 1)
+		for !bw.ByteAligned() {
+			w.u(0, 1)
+		}
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a recovery_point payload.
+func (r *RecoveryPoint) UnmarshalBinary(b []byte) (err error) {
+	br := bits.NewBitReader(bytes.NewReader(b))
+	defer func() { err = withBitPos(br, err) }()
+
+	r.RecoveryFrameCnt, err = readUe(br)
+	if err != nil {
+		return fmt.Errorf("could not parse recovery_frame_cnt: %w", err)
+	}
+	err = readFlags(br, []flag{{&r.ExactMatch, "ExactMatch"}, {&r.BrokenLink, "BrokenLink"}})
+	if err != nil {
+		return err
+	}
+	return readFields(br, []field{{&r.ChangingSliceGroupIDC, "ChangingSliceGroupIDC", 2}})
+}
+
+// ErrShortSEI is returned when an SEI message is shorter than its syntax
+// requires.
+var ErrShortSEI = errors.New("SEI message truncated")
+
+// WriteSEI writes an SEI NAL unit, without start code prefix, holding an SEI
+// message for each of payloads.
+func WriteSEI(w io.Writer, payloads ...SEIPayload) error {
+	if len(payloads) == 0 {
+		return errors.New("no SEI messages to write")
+	}
+	msgs := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("could not marshal SEI payload type %d: %w", p.PayloadType(), err)
+		}
+		msgs[i] = b
+	}
+	return writeNalUnit(w, NALTypeSEI, 0, func(w *rbspWriter) {
+		for i, p := range payloads {
+			writeSEIValue(w, p.PayloadType())
+			writeSEIValue(w, len(msgs[i]))
+			for _, b := range msgs[i] {
+				w.u(uint64(b), 8)
+			}
+		}
+	})
+}
+
+// writeSEIValue writes payloadType or payloadSize v as a run of ff_byte
+// followed by the last byte, as in section 7.3.2.3.1.
+func writeSEIValue(w *rbspWriter, v int) {
+	for ; v >= 0xff; v -= 0xff {
+		w.u(0xff, 8)
+	}
+	w.u(uint64(v), 8)
+}
+
+// ParseSEI parses the SEI messages of an SEI RBSP, as defined in section
+// 7.3.2.3, returning them undecoded. The payloads refer to rbsp's storage.
+func ParseSEI(rbsp []byte) ([]RawSEI, error) {
+	var msgs []RawSEI
+	for i := 0; len(msgs) == 0 || moreSEIData(rbsp[i:]); {
+		typ, n, err := readSEIValue(rbsp[i:])
+		if err != nil {
+			return nil, fmt.Errorf("could not read payloadType: %w", err)
+		}
+		i += n
+		size, n, err := readSEIValue(rbsp[i:])
+		if err != nil {
+			return nil, fmt.Errorf("could not read payloadSize: %w", err)
+		}
+		i += n
+		if i+size > len(rbsp) {
+			return nil, ErrShortSEI
+		}
+		msgs = append(msgs, RawSEI{Type: typ, Payload: rbsp[i : i+size]})
+		i += size
+	}
+	return msgs, nil
+}
+
+// readSEIValue reads payloadType or payloadSize, as written by writeSEIValue,
+// from the start of b, returning the value and the number of bytes read. SEI
+// messages are byte aligned, so no bit reader is needed.
+func readSEIValue(b []byte) (v, n int, err error) {
+	for ; n < len(b); n++ {
+		v += int(b[n])
+		if b[n] != 0xff {
+			return v, n + 1, nil
+		}
+	}
+	return 0, 0, ErrShortSEI
+}
+
+// moreSEIData returns true if the remainder b of an SEI RBSP holds another SEI
+// message, i.e. more than rbsp_trailing_bits.
+func moreSEIData(b []byte) bool {
+	return len(b) > 1 || (len(b) == 1 && b[0] != 0x80)
+}
+
+// handleSEI parses the messages of an SEI NAL unit, retaining those that
+// describe the structure of the stream. SEI messages are not needed for
+// decoding, so an SEI NAL unit that cannot be parsed is logged and ignored.
+func (h *H264Reader) handleSEI(nalUnit *NalUnit) {
+	msgs, err := ParseSEI(nalUnit.RBSP())
+	if err != nil {
+		logger.Printf("warning: could not parse SEI: %v\n", err)
+		return
+	}
+	for _, m := range msgs {
+		switch m.Type {
+		case SEIScalabilityInfo:
+			info := &ScalabilityInfo{}
+			err = info.UnmarshalBinary(m.Payload)
+			if err != nil {
+				logger.Printf("warning: could not parse scalability information SEI: %v\n", err)
+				continue
+			}
+			h.scalability = info
+		case SEIRecoveryPoint:
+			if h.refresh == nil {
+				continue
+			}
+			rp := &RecoveryPoint{}
+			err = rp.UnmarshalBinary(m.Payload)
+			if err != nil {
+				logger.Printf("warning: could not parse recovery point SEI: %v\n", err)
+				continue
+			}
+			h.refresh.recoveryPoint(rp)
+		}
+	}
+}
+
+// Scalability returns the layer structure given by the most recent
+// scalability information SEI message of the stream, or nil if there has been
+// none, i.e. the stream is not a scalable stream or does not describe itself.
+func (h *H264Reader) Scalability() *ScalabilityInfo {
+	return h.scalability
+}
+
+// InjectSEI copies the Annex B byte stream read from src to dst, inserting an
+// SEI NAL unit holding the messages returned by payloads before the first VCL
+// NAL unit of each primary coded picture. payloads is called with the index of
+// each picture, counting from 0, and no SEI NAL unit is inserted if it returns
+// none. Other NAL units are passed through unchanged, but each is written with
+// a four byte start code and without trailing zero bytes.
+func InjectSEI(dst io.Writer, src io.Reader, payloads func(picture int) []SEIPayload) error {
+	h, err := NewH264Reader(src)
+	if err != nil {
+		return err
+	}
+	for picture := 0; ; {
+		nalUnit, err := h.nextNalUnit()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read NAL unit: %w", err)
+		}
+
+		switch nalUnit.Type {
+		case NALTypeSliceNonIDRPicture, NALTypeSlicePartA, NALTypeSliceIDRPicture:
+			if !startsPicture(nalUnit) {
+				break
+			}
+			p := payloads(picture)
+			picture++
+			if len(p) == 0 {
+				break
+			}
+			var sei bytes.Buffer
+			sei.Write(InitialNALU)
+			err = WriteSEI(&sei, p...)
+			if err != nil {
+				return fmt.Errorf("could not write SEI for picture %d: %w", picture-1, err)
+			}
+			_, err = dst.Write(sei.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = dst.Write(append(append([]byte(nil), InitialNALU...), nalUnit.raw...))
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+NAME
+  sei_test.go
+
+DESCRIPTION
+  sei_test.go provides testing for functionality in sei.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+var testUUID = [16]byte{0x00, 0x00, 0x00, 0x01, 0x2a, 0x2b, 0x2c, 0x2d, 0x2e, 0x2f, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35}
+
+func TestWriteSEI(t *testing.T) {
+	var got bytes.Buffer
+	err := WriteSEI(&got, &UserDataUnregistered{UUID: testUUID, Data: []byte("GPS")})
+	if err != nil {
+		t.Fatalf("did not expect error: %v from WriteSEI", err)
+	}
+	want := []byte{
+		0x06,       // nal_ref_idc 0, nal_unit_type 6.
+		0x05, 0x13, // payloadType 5, payloadSize 19.
+		0x00, 0x00, 0x03, 0x00, 0x01, // UUID with emulation prevention.
+		0x2a, 0x2b, 0x2c, 0x2d, 0x2e, 0x2f, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35,
+		'G', 'P', 'S',
+		0x80, // rbsp_trailing_bits.
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("did not get expected result\nGot: %#v\nWant: %#v", got.Bytes(), want)
+	}
+}
+
+// TestSEIRoundTrip checks that SEI messages, including those with payload type
+// and size of 255 or more, are parsed back after being written.
+func TestSEIRoundTrip(t *testing.T) {
+	long := bytes.Repeat([]byte{0x00, 0x01, 0x02}, 200)
+	want := []RawSEI{
+		{Type: SEIUserDataUnregistered, Payload: append(testUUID[:], []byte{0x00, 0x00}...)},
+		{Type: 300, Payload: long},
+		{Type: SEIRecoveryPoint, Payload: []byte{0x80}},
+	}
+
+	var buf bytes.Buffer
+	err := WriteSEI(&buf, want[0], want[1], want[2])
+	if err != nil {
+		t.Fatalf("did not expect error: %v from WriteSEI", err)
+	}
+	nalUnit, err := NewNalUnit(buf.Bytes(), buf.Len())
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewNalUnit", err)
+	}
+	if err := nalUnit.Validate(); err != nil {
+		t.Errorf("did not expect error: %v from Validate", err)
+	}
+	got, err := ParseSEI(nalUnit.RBSP())
+	if err != nil {
+		t.Fatalf("did not expect error: %v from ParseSEI", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("did not get expected result\nGot: %v\nWant: %v", got, want)
+	}
+
+	var u UserDataUnregistered
+	err = u.UnmarshalBinary(got[0].Payload)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from UnmarshalBinary", err)
+	}
+	if u.UUID != testUUID || !bytes.Equal(u.Data, []byte{0x00, 0x00}) {
+		t.Errorf("did not get expected user data\nGot: %v\nWant: %v", u, UserDataUnregistered{testUUID, []byte{0x00, 0x00}})
+	}
+
+	_, err = ParseSEI([]byte{0x05, 0x10, 0x00, 0x80})
+	if !errors.Is(err, ErrShortSEI) {
+		t.Errorf("did not get expected error for truncated SEI\nGot: %v\nWant: %v", err, ErrShortSEI)
+	}
+}
+
+// TestInjectSEI checks that SEI NAL units are inserted before the first slice
+// of each picture for which messages are given.
+func TestInjectSEI(t *testing.T) {
+	stream := annexB(testSPS, testPPS, testIDR, testNonIDR, testNonIDR)
+
+	var out bytes.Buffer
+	err := InjectSEI(&out, bytes.NewReader(stream), func(picture int) []SEIPayload {
+		if picture == 1 {
+			return nil
+		}
+		return []SEIPayload{&UserDataUnregistered{UUID: testUUID, Data: []byte{byte(picture)}}}
+	})
+	if err != nil {
+		t.Fatalf("did not expect error: %v from InjectSEI", err)
+	}
+
+	r, err := NewH264Reader(&out)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	var got []NALType
+	var pictures []byte
+	for {
+		nalUnit, err := r.nextNalUnit()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("did not expect error: %v reading output", err)
+		}
+		got = append(got, nalUnit.Type)
+		if nalUnit.Type != NALTypeSEI {
+			continue
+		}
+		msgs, err := ParseSEI(nalUnit.RBSP())
+		if err != nil {
+			t.Fatalf("did not expect error: %v from ParseSEI", err)
+		}
+		pictures = append(pictures, msgs[0].Payload[16])
+	}
+
+	want := []NALType{NALTypeSPS, NALTypePPS, NALTypeSEI, NALTypeSliceIDRPicture, NALTypeSliceNonIDRPicture, NALTypeSEI, NALTypeSliceNonIDRPicture}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("did not get expected NAL units\nGot: %v\nWant: %v", got, want)
+	}
+	if !bytes.Equal(pictures, []byte{0, 2}) {
+		t.Errorf("did not get expected pictures annotated\nGot: %v\nWant: %v", pictures, []byte{0, 2})
+	}
+}
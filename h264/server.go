@@ -66,5 +66,8 @@ func handleConnection(connection io.Reader) {
 			os.Exit(1)
 		}
 	}()
-	streamReader.Start()
+	err = streamReader.Start()
+	if err != nil {
+		logger.Printf("error: %v\n", err)
+	}
 }
@@ -2,11 +2,12 @@ package h264
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"image"
 	"math"
 
 	"github.com/ausocean/h264decode/h264/bits"
-	"github.com/pkg/errors"
 )
 
 // Chroma formats as defined in section 6.2, tab 6-1.
@@ -18,74 +19,146 @@ const (
 )
 
 type VideoStream struct {
-	SPS    *SPS
-	PPS    *PPS
+	SPS *SPS
+
+	// PPS is the PPS referred to by the most recent slice. A stream may use
+	// several PPS referring to its SPS, each slice being decoded using the
+	// PPS it refers to, as held by its SliceContext.
+	PPS *PPS
+
 	Slices []*SliceContext
+
+	// Pictures holds the slices of Slices assembled into the pictures to
+	// which they belong, see addSlice.
+	Pictures []*Picture
 }
 type SliceContext struct {
 	*NalUnit
 	*SPS
 	*PPS
 	*Slice
+	Flags FrameFlags   // Conditions applying to the frame the slice belongs to.
+	POC   PictureOrder // Picture order counts of the picture the slice belongs to.
+
+	// Partitions holds partitions B and C of a slice coded as data
+	// partitions, being nil for other slices.
+	Partitions *DataPartitions
+
+	// arena holds the temporaries of the picture being decoded. It is nil
+	// once the slice has been decoded.
+	arena *arena
 }
 type Slice struct {
 	Header *SliceHeader
 	Data   *SliceData
 }
 type SliceHeader struct {
-	FirstMbInSlice                   int
-	SliceType                        int
-	PPSID                            int
-	ColorPlaneID                     int
-	FrameNum                         int
-	FieldPic                         bool
-	BottomField                      bool
-	IDRPicID                         int
-	PicOrderCntLsb                   int
-	DeltaPicOrderCntBottom           int
-	DeltaPicOrderCnt                 []int
-	RedundantPicCnt                  int
-	DirectSpatialMvPred              bool
-	NumRefIdxActiveOverride          bool
-	NumRefIdxL0ActiveMinus1          int
-	NumRefIdxL1ActiveMinus1          int
-	CabacInit                        int
-	SliceQpDelta                     int
-	SpForSwitch                      bool
-	SliceQsDelta                     int
-	DisableDeblockingFilter          int
-	SliceAlphaC0OffsetDiv2           int
-	SliceBetaOffsetDiv2              int
-	SliceGroupChangeCycle            int
-	RefPicListModificationFlagL0     bool
-	ModificationOfPicNums            int
-	AbsDiffPicNumMinus1              int
-	LongTermPicNum                   int
-	RefPicListModificationFlagL1     bool
-	LumaLog2WeightDenom              int
-	ChromaLog2WeightDenom            int
-	ChromaArrayType                  int
-	LumaWeightL0Flag                 bool
-	LumaWeightL0                     []int
-	LumaOffsetL0                     []int
-	ChromaWeightL0Flag               bool
-	ChromaWeightL0                   [][]int
-	ChromaOffsetL0                   [][]int
-	LumaWeightL1Flag                 bool
-	LumaWeightL1                     []int
-	LumaOffsetL1                     []int
-	ChromaWeightL1Flag               bool
-	ChromaWeightL1                   [][]int
-	ChromaOffsetL1                   [][]int
-	NoOutputOfPriorPicsFlag          bool
-	LongTermReferenceFlag            bool
-	AdaptiveRefPicMarkingModeFlag    bool
+	NalRefIdc                     int  // nal_ref_idc of the slice's NAL unit.
+	IdrPic                        bool // IdrPicFlag i.e. slice of an IDR picture.
+	FirstMbInSlice                int
+	SliceType                     int
+	PPSID                         int
+	ColorPlaneID                  int
+	FrameNum                      int
+	FieldPic                      bool
+	BottomField                   bool
+	IDRPicID                      int
+	PicOrderCntLsb                int
+	DeltaPicOrderCntBottom        int
+	DeltaPicOrderCnt              []int
+	RedundantPicCnt               int
+	DirectSpatialMvPred           bool
+	NumRefIdxActiveOverride       bool
+	NumRefIdxL0ActiveMinus1       int
+	NumRefIdxL1ActiveMinus1       int
+	CabacInit                     int
+	SliceQpDelta                  int
+	SpForSwitch                   bool
+	SliceQsDelta                  int
+	DisableDeblockingFilter       int
+	SliceAlphaC0OffsetDiv2        int
+	SliceBetaOffsetDiv2           int
+	SliceGroupChangeCycle         int
+	RefPicListModificationFlagL0  bool
+	RefPicListModificationL0      []RefPicListModification
+	RefPicListModificationFlagL1  bool
+	RefPicListModificationL1      []RefPicListModification
+	PredWeightTable               *PredWeightTable // Present for explicit weighted prediction.
+	ChromaArrayType               int
+	NoOutputOfPriorPicsFlag       bool
+	LongTermReferenceFlag         bool
+	AdaptiveRefPicMarkingModeFlag bool
+	MemoryManagementOps           []MemoryManagementOp
+	SliceID                       int // slice_id, for slices coded as data partitions.
+}
+
+// RefPicListModification is an operation of a ref_pic_list_modification or
+// ref_pic_list_mvc_modification, see sections 7.3.3.1 and H.7.3.3.1.1, to be
+// applied by the modification process for reference picture lists of section
+// 8.2.4.3. The terminating operation, with modification_of_pic_nums_idc equal
+// to 3, is not included.
+type RefPicListModification struct {
+	// ModificationOfPicNumsIdc gives the operation, with 0 and 1 subtracting
+	// and adding AbsDiffPicNumMinus1+1 to the predicted picture number of a
+	// short term reference picture, 2 giving a long term reference picture by
+	// LongTermPicNum, and, for MVC, 4 and 5 subtracting and adding
+	// AbsDiffViewIdxMinus1+1 to the predicted inter-view reference index.
+	ModificationOfPicNumsIdc int
+	AbsDiffPicNumMinus1      int
+	LongTermPicNum           int
+	AbsDiffViewIdxMinus1     int
+}
+
+// MemoryManagementOp is a memory management control operation of an
+// adaptive dec_ref_pic_marking, see sections 7.3.3.3 and 7.4.3.3, to be
+// performed by the adaptive memory control decoded reference picture marking
+// process of section 8.2.5.4. The terminating operation, with
+// memory_management_control_operation equal to 0, is not included.
+type MemoryManagementOp struct {
+	// MemoryManagementControlOperation gives the operation, with 1 marking a
+	// short term reference picture, given by DifferenceOfPicNumsMinus1, as
+	// unused for reference, 2 marking a long term reference picture, given by
+	// LongTermPicNum, as unused, 3 making a short term reference picture, given
+	// by DifferenceOfPicNumsMinus1, long term with LongTermFrameIdx, 4 setting
+	// the maximum long term frame index to MaxLongTermFrameIdxPlus1-1, 5
+	// marking all reference pictures as unused, and 6 making the current
+	// picture long term with LongTermFrameIdx.
 	MemoryManagementControlOperation int
 	DifferenceOfPicNumsMinus1        int
+	LongTermPicNum                   int
 	LongTermFrameIdx                 int
 	MaxLongTermFrameIdxPlus1         int
 }
 
+// PredWeightTable holds the weights and offsets of a pred_weight_table, see
+// section 7.3.3.2, for explicit weighted prediction.
+type PredWeightTable struct {
+	LumaLog2WeightDenom   int
+	ChromaLog2WeightDenom int
+	L0, L1                []PredWeight // Indexed by reference index; L1 for B slices only.
+}
+
+// PredWeight holds the weights and offsets of a reference index of a
+// pred_weight_table. Where luma_weight_lX_flag or chroma_weight_lX_flag is not
+// set, the weights and offsets are those inferred by section 7.4.3.2, being
+// 2^LumaLog2WeightDenom or 2^ChromaLog2WeightDenom, and 0.
+type PredWeight struct {
+	LumaWeightFlag   bool
+	LumaWeight       int
+	LumaOffset       int
+	ChromaWeightFlag bool
+	ChromaWeight     [2]int // For Cb and Cr.
+	ChromaOffset     [2]int
+}
+
+// IsReference returns true if the slice belongs to a reference picture, i.e.
+// nal_ref_idc is non-zero. Only reference pictures are marked as "used for
+// reference" by the decoded reference picture marking process (8.2.5); a
+// non-reference picture may be discarded once output.
+func (h *SliceHeader) IsReference() bool {
+	return h.NalRefIdc != 0
+}
+
 type SliceData struct {
 	BitReader                *bits.BitReader
 	CabacAlignmentOneBit     int
@@ -107,10 +180,39 @@ type SliceData struct {
 	PrevIntra8x8PredModeFlag []int
 	RemIntra8x8PredMode      []int
 	IntraChromaPredMode      int
+	SubMbType                []int
 	RefIdxL0                 []int
 	RefIdxL1                 []int
 	MvdL0                    [][][]int
 	MvdL1                    [][][]int
+	NumMbs                   int // Number of macroblocks in the slice.
+	CabacZeroWords           int // Number of cabac_zero_word following the slice data.
+
+	// cabac is the arithmetic decoding engine of slices using CABAC.
+	cabac *cabacDecoder
+
+	// residual holds the coefficient levels of the macroblock last parsed,
+	// qp its quantisation parameters if it has a residual, and predMode
+	// MbPartPredMode(mb_type, 0), or naMbPartPredMode if mb_type is I_PCM or
+	// has sub-macroblock partitions, with intra16x16PredMode Intra16x16PredMode
+	// if it is Intra_16x16.
+	residual           mbResidual
+	qp                 mbQP
+	predMode           mbPartPredMode
+	intra16x16PredMode int
+
+	// pic is the frame into which the picture is constructed, or nil if its
+	// samples are not constructed, see arena.picture.
+	pic *image.YCbCr
+
+	// direct is what the derivation of the motion of macroblocks predicted
+	// in direct mode needs, for B slices, see setDirectMotion.
+	direct directPred
+
+	// Readers of the slice data of partitions B and C, for slices coded as
+	// data partitions, see residualReader.
+	partitioned            bool
+	partitionB, partitionC *bits.BitReader
 }
 
 // Table 7-6
@@ -187,6 +289,18 @@ func PicSizeInMbs(sps *SPS, header *SliceHeader) int {
 	return PicWidthInMbs(sps) * PicHeightInMbs(sps, header)
 }
 
+// ChromaArrayType returns the value of ChromaArrayType for the SPS, being
+// chroma_format_idc, or 0 if separate_colour_plane_flag is set in which case
+// each colour plane is coded as a monochrome picture (see section 7.4.2.1.1).
+// Where the specifications condition parsing on ChromaArrayType rather than
+// chroma_format_idc, this must be used.
+func (s *SPS) ChromaArrayType() int {
+	if s.UseSeparateColorPlane {
+		return chromaMonochrome
+	}
+	return s.ChromaFormat
+}
+
 // table 6-1
 func SubWidthC(sps *SPS) int {
 	n := 17
@@ -249,9 +363,9 @@ func NumMbPart(nalUnit *NalUnit, sps *SPS, header *SliceHeader, data *SliceData)
 	sliceType := sliceTypeMap[header.SliceType]
 	numMbPart := 0
 	if MbTypeName(sliceType, CurrMbAddr(sps, header)) == "B_SKIP" || MbTypeName(sliceType, CurrMbAddr(sps, header)) == "B_Direct_16x16" {
-		if DQId(nalUnit) == 0 && nalUnit.Type != 20 {
+		if DQId(nalUnit) == 0 && nalUnit.Type != NALTypeSliceExtension {
 			numMbPart = 4
-		} else if DQId(nalUnit) > 0 && nalUnit.Type == 20 {
+		} else if DQId(nalUnit) > 0 && nalUnit.Type == NALTypeSliceExtension {
 			numMbPart = 1
 		}
 	} else if MbTypeName(sliceType, CurrMbAddr(sps, header)) != "B_SKIP" && MbTypeName(sliceType, CurrMbAddr(sps, header)) != "B_Direct_16x16" {
@@ -261,245 +375,6 @@ func NumMbPart(nalUnit *NalUnit, sps *SPS, header *SliceHeader, data *SliceData)
 	return numMbPart
 }
 
-func MbPred(sliceContext *SliceContext, br *bits.BitReader, rbsp []byte) error {
-	var cabac *CABAC
-	sliceType := sliceTypeMap[sliceContext.Slice.Header.SliceType]
-	mbPartPredMode, err := MbPartPredMode(sliceContext.Slice.Data, sliceType, sliceContext.Slice.Data.MbType, 0)
-	if err != nil {
-		return errors.Wrap(err, "could not get mbPartPredMode")
-	}
-	if mbPartPredMode == intra4x4 || mbPartPredMode == intra8x8 || mbPartPredMode == intra16x16 {
-		if mbPartPredMode == intra4x4 {
-			for luma4x4BlkIdx := 0; luma4x4BlkIdx < 16; luma4x4BlkIdx++ {
-				var v int
-				if sliceContext.PPS.EntropyCodingMode == 1 {
-					// TODO: 1 bit or ae(v)
-					binarization := NewBinarization(
-						"PrevIntra4x4PredModeFlag",
-						sliceContext.Slice.Data)
-					binarization.Decode(sliceContext, br, rbsp)
-
-					cabac = initCabac(binarization, sliceContext)
-					_ = cabac
-					logger.Printf("TODO: ae for PevIntra4x4PredModeFlag[%d]\n", luma4x4BlkIdx)
-				} else {
-					b, err := br.ReadBits(1)
-					if err != nil {
-						return errors.Wrap(err, "could not read PrevIntra4x4PredModeFlag")
-					}
-					v = int(b)
-				}
-				sliceContext.Slice.Data.PrevIntra4x4PredModeFlag = append(
-					sliceContext.Slice.Data.PrevIntra4x4PredModeFlag,
-					v)
-				if sliceContext.Slice.Data.PrevIntra4x4PredModeFlag[luma4x4BlkIdx] == 0 {
-					if sliceContext.PPS.EntropyCodingMode == 1 {
-						// TODO: 3 bits or ae(v)
-						binarization := NewBinarization(
-							"RemIntra4x4PredMode",
-							sliceContext.Slice.Data)
-						binarization.Decode(sliceContext, br, rbsp)
-
-						logger.Printf("TODO: ae for RemIntra4x4PredMode[%d]\n", luma4x4BlkIdx)
-					} else {
-						b, err := br.ReadBits(3)
-						if err != nil {
-							return errors.Wrap(err, "could not read RemIntra4x4PredMode")
-						}
-						v = int(b)
-					}
-					if len(sliceContext.Slice.Data.RemIntra4x4PredMode) < luma4x4BlkIdx {
-						sliceContext.Slice.Data.RemIntra4x4PredMode = append(
-							sliceContext.Slice.Data.RemIntra4x4PredMode,
-							make([]int, luma4x4BlkIdx-len(sliceContext.Slice.Data.RemIntra4x4PredMode)+1)...)
-					}
-					sliceContext.Slice.Data.RemIntra4x4PredMode[luma4x4BlkIdx] = v
-				}
-			}
-		}
-		if mbPartPredMode == intra8x8 {
-			for luma8x8BlkIdx := 0; luma8x8BlkIdx < 4; luma8x8BlkIdx++ {
-				sliceContext.Update(sliceContext.Slice.Header, sliceContext.Slice.Data)
-				var v int
-				if sliceContext.PPS.EntropyCodingMode == 1 {
-					// TODO: 1 bit or ae(v)
-					binarization := NewBinarization("PrevIntra8x8PredModeFlag", sliceContext.Slice.Data)
-					binarization.Decode(sliceContext, br, rbsp)
-
-					logger.Printf("TODO: ae for PrevIntra8x8PredModeFlag[%d]\n", luma8x8BlkIdx)
-				} else {
-					b, err := br.ReadBits(1)
-					if err != nil {
-						return errors.Wrap(err, "could not read PrevIntra8x8PredModeFlag")
-					}
-					v = int(b)
-				}
-				sliceContext.Slice.Data.PrevIntra8x8PredModeFlag = append(
-					sliceContext.Slice.Data.PrevIntra8x8PredModeFlag, v)
-				if sliceContext.Slice.Data.PrevIntra8x8PredModeFlag[luma8x8BlkIdx] == 0 {
-					if sliceContext.PPS.EntropyCodingMode == 1 {
-						// TODO: 3 bits or ae(v)
-						binarization := NewBinarization(
-							"RemIntra8x8PredMode",
-							sliceContext.Slice.Data)
-						binarization.Decode(sliceContext, br, rbsp)
-
-						logger.Printf("TODO: ae for RemIntra8x8PredMode[%d]\n", luma8x8BlkIdx)
-					} else {
-						b, err := br.ReadBits(3)
-						if err != nil {
-							return errors.Wrap(err, "could not read RemIntra8x8PredMode")
-						}
-						v = int(b)
-					}
-					if len(sliceContext.Slice.Data.RemIntra8x8PredMode) < luma8x8BlkIdx {
-						sliceContext.Slice.Data.RemIntra8x8PredMode = append(
-							sliceContext.Slice.Data.RemIntra8x8PredMode,
-							make([]int, luma8x8BlkIdx-len(sliceContext.Slice.Data.RemIntra8x8PredMode)+1)...)
-					}
-					sliceContext.Slice.Data.RemIntra8x8PredMode[luma8x8BlkIdx] = v
-				}
-			}
-
-		}
-		if sliceContext.Slice.Header.ChromaArrayType == 1 || sliceContext.Slice.Header.ChromaArrayType == 2 {
-			if sliceContext.PPS.EntropyCodingMode == 1 {
-				// TODO: ue(v) or ae(v)
-				binarization := NewBinarization(
-					"IntraChromaPredMode",
-					sliceContext.Slice.Data)
-				binarization.Decode(sliceContext, br, rbsp)
-
-				logger.Printf("TODO: ae for IntraChromaPredMode\n")
-			} else {
-				var err error
-				sliceContext.Slice.Data.IntraChromaPredMode, err = readUe(nil)
-				if err != nil {
-					return errors.Wrap(err, "could not parse IntraChromaPredMode")
-				}
-			}
-		}
-
-	} else if mbPartPredMode != direct {
-		for mbPartIdx := 0; mbPartIdx < NumMbPart(sliceContext.NalUnit, sliceContext.SPS, sliceContext.Slice.Header, sliceContext.Slice.Data); mbPartIdx++ {
-			sliceContext.Update(sliceContext.Slice.Header, sliceContext.Slice.Data)
-			m, err := MbPartPredMode(sliceContext.Slice.Data, sliceType, sliceContext.Slice.Data.MbType, mbPartIdx)
-			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("could not get mbPartPredMode for loop 1 mbPartIdx: %d", mbPartIdx))
-			}
-			if (sliceContext.Slice.Header.NumRefIdxL0ActiveMinus1 > 0 || sliceContext.Slice.Data.MbFieldDecodingFlag != sliceContext.Slice.Header.FieldPic) && m != predL1 {
-				logger.Printf("\tTODO: refIdxL0[%d] te or ae(v)\n", mbPartIdx)
-				if len(sliceContext.Slice.Data.RefIdxL0) < mbPartIdx {
-					sliceContext.Slice.Data.RefIdxL0 = append(
-						sliceContext.Slice.Data.RefIdxL0, make([]int, mbPartIdx-len(sliceContext.Slice.Data.RefIdxL0)+1)...)
-				}
-				if sliceContext.PPS.EntropyCodingMode == 1 {
-					// TODO: te(v) or ae(v)
-					binarization := NewBinarization(
-						"RefIdxL0",
-						sliceContext.Slice.Data)
-					binarization.Decode(sliceContext, br, rbsp)
-
-					logger.Printf("TODO: ae for RefIdxL0[%d]\n", mbPartIdx)
-				} else {
-					// TODO: Only one reference picture is used for inter-prediction,
-					// then the value should be 0
-					if MbaffFrameFlag(sliceContext.SPS, sliceContext.Slice.Header) == 0 || !sliceContext.Slice.Data.MbFieldDecodingFlag {
-						sliceContext.Slice.Data.RefIdxL0[mbPartIdx], _ = readTe(
-							nil,
-							uint(sliceContext.Slice.Header.NumRefIdxL0ActiveMinus1))
-					} else {
-						rangeMax := 2*sliceContext.Slice.Header.NumRefIdxL0ActiveMinus1 + 1
-						sliceContext.Slice.Data.RefIdxL0[mbPartIdx], _ = readTe(
-							nil,
-							uint(rangeMax))
-					}
-				}
-			}
-		}
-		for mbPartIdx := 0; mbPartIdx < NumMbPart(sliceContext.NalUnit, sliceContext.SPS, sliceContext.Slice.Header, sliceContext.Slice.Data); mbPartIdx++ {
-			m, err := MbPartPredMode(sliceContext.Slice.Data, sliceType, sliceContext.Slice.Data.MbType, mbPartIdx)
-			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("could not get mbPartPredMode for loop 2 mbPartIdx: %d", mbPartIdx))
-			}
-			if m != predL1 {
-				for compIdx := 0; compIdx < 2; compIdx++ {
-					if len(sliceContext.Slice.Data.MvdL0) < mbPartIdx {
-						sliceContext.Slice.Data.MvdL0 = append(
-							sliceContext.Slice.Data.MvdL0,
-							make([][][]int, mbPartIdx-len(sliceContext.Slice.Data.MvdL0)+1)...)
-					}
-					if len(sliceContext.Slice.Data.MvdL0[mbPartIdx][0]) < compIdx {
-						sliceContext.Slice.Data.MvdL0[mbPartIdx][0] = append(
-							sliceContext.Slice.Data.MvdL0[mbPartIdx][0],
-							make([]int, compIdx-len(sliceContext.Slice.Data.MvdL0[mbPartIdx][0])+1)...)
-					}
-					if sliceContext.PPS.EntropyCodingMode == 1 {
-						// TODO: se(v) or ae(v)
-						if compIdx == 0 {
-							binarization := NewBinarization(
-								"MvdLnEnd0",
-								sliceContext.Slice.Data)
-							binarization.Decode(sliceContext, br, rbsp)
-
-						} else if compIdx == 1 {
-							binarization := NewBinarization(
-								"MvdLnEnd1",
-								sliceContext.Slice.Data)
-							binarization.Decode(sliceContext, br, rbsp)
-
-						}
-						logger.Printf("TODO: ae for MvdL0[%d][0][%d]\n", mbPartIdx, compIdx)
-					} else {
-						sliceContext.Slice.Data.MvdL0[mbPartIdx][0][compIdx], _ = readSe(nil)
-					}
-				}
-			}
-		}
-		for mbPartIdx := 0; mbPartIdx < NumMbPart(sliceContext.NalUnit, sliceContext.SPS, sliceContext.Slice.Header, sliceContext.Slice.Data); mbPartIdx++ {
-			sliceContext.Update(sliceContext.Slice.Header, sliceContext.Slice.Data)
-			m, err := MbPartPredMode(sliceContext.Slice.Data, sliceType, sliceContext.Slice.Data.MbType, mbPartIdx)
-			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("could not get mbPartPredMode for loop 3 mbPartIdx: %d", mbPartIdx))
-			}
-			if m != predL0 {
-				for compIdx := 0; compIdx < 2; compIdx++ {
-					if len(sliceContext.Slice.Data.MvdL1) < mbPartIdx {
-						sliceContext.Slice.Data.MvdL1 = append(
-							sliceContext.Slice.Data.MvdL1,
-							make([][][]int, mbPartIdx-len(sliceContext.Slice.Data.MvdL1)+1)...)
-					}
-					if len(sliceContext.Slice.Data.MvdL1[mbPartIdx][0]) < compIdx {
-						sliceContext.Slice.Data.MvdL1[mbPartIdx][0] = append(
-							sliceContext.Slice.Data.MvdL0[mbPartIdx][0],
-							make([]int, compIdx-len(sliceContext.Slice.Data.MvdL1[mbPartIdx][0])+1)...)
-					}
-					if sliceContext.PPS.EntropyCodingMode == 1 {
-						if compIdx == 0 {
-							binarization := NewBinarization(
-								"MvdLnEnd0",
-								sliceContext.Slice.Data)
-							binarization.Decode(sliceContext, br, rbsp)
-
-						} else if compIdx == 1 {
-							binarization := NewBinarization(
-								"MvdLnEnd1",
-								sliceContext.Slice.Data)
-							binarization.Decode(sliceContext, br, rbsp)
-
-						}
-						// TODO: se(v) or ae(v)
-						logger.Printf("TODO: ae for MvdL1[%d][0][%d]\n", mbPartIdx, compIdx)
-					} else {
-						sliceContext.Slice.Data.MvdL1[mbPartIdx][0][compIdx], _ = readSe(nil)
-					}
-				}
-			}
-		}
-	}
-	return nil
-}
-
 // 8.2.2.1
 func MapUnitToSliceGroupMap(sps *SPS, pps *PPS, header *SliceHeader) []int {
 	mapUnitToSliceGroupMap := []int{}
@@ -592,7 +467,7 @@ func nextMbAddress(n int, sps *SPS, pps *PPS, header *SliceHeader) int {
 	picHeightInMbs := frameHeightInMbs / (1 + flagVal(header.FieldPic))
 	picSizeInMbs := picWidthInMbs * picHeightInMbs
 	mbToSliceGroupMap := MbToSliceGroupMap(sps, pps, header)
-	for i < picSizeInMbs && mbToSliceGroupMap[i] != mbToSliceGroupMap[i] {
+	for i < picSizeInMbs && mbToSliceGroupMap[i] != mbToSliceGroupMap[n] {
 		i++
 	}
 	return i
@@ -604,7 +479,7 @@ func CurrMbAddr(sps *SPS, header *SliceHeader) int {
 		mbaffFrameFlag = 1
 	}
 
-	return header.FirstMbInSlice * (1 * mbaffFrameFlag)
+	return header.FirstMbInSlice * (1 + mbaffFrameFlag)
 }
 
 func MbaffFrameFlag(sps *SPS, header *SliceHeader) int {
@@ -614,29 +489,98 @@ func MbaffFrameFlag(sps *SPS, header *SliceHeader) int {
 	return 0
 }
 
+// pairFieldDecoding returns the mb_field_decoding_flag of the pair of the
+// macroblock with address topMbAddr, the top macroblock of a pair of an MBAFF
+// frame decoded as skipped using CABAC, mb_skip_flag having the given
+// ctxIdxOffset. This is that of the bottom macroblock if it is not skipped,
+// otherwise that inferred (7.4.4). As the bottom macroblock's mb_skip_flag
+// and mb_field_decoding_flag follow in the slice data, they are decoded
+// speculatively, with the bottom macroblock begun for the derivation of
+// ctxIdxInc, and the decoder and macroblock state are then restored, for them
+// to be decoded again with the bottom macroblock.
+func (d *SliceData) pairFieldDecoding(mbs *mbState, topMbAddr, ctxIdxOffset int) (bool, error) {
+	bottom := topMbAddr + 1
+	field := mbs.has(topMbAddr, mbFieldDecoded)
+	s := d.cabac.Save()
+	mbs.beginMb(bottom, mbs.sliceNum[topMbAddr], 0)
+	b, err := d.cabac.decodeDecision(ctxIdxOffset + mbSkipFlagCtxIdxInc(mbs, bottom))
+	if err == nil && b == 0 {
+		b, err = d.cabac.decodeDecision(70 + mbFieldDecodingFlagCtxIdxInc(mbs, bottom))
+		field = b == 1
+	}
+	mbs.sliceNum[bottom], mbs.lastMbAddr = -1, topMbAddr
+	mbs.sliceMbs--
+	if err != nil {
+		return false, fmt.Errorf("could not read mb_skip_flag and mb_field_decoding_flag of macroblock %d: %w", bottom, err)
+	}
+	if err := d.cabac.Restore(s); err != nil {
+		return false, fmt.Errorf("could not restore CABAC decoder after macroblock %d: %w", bottom, err)
+	}
+	return field, nil
+}
+
 func NewSliceData(sliceContext *SliceContext, br *bits.BitReader) (*SliceData, error) {
-	var cabac *CABAC
 	var err error
 	sliceContext.Slice.Data = &SliceData{BitReader: br}
+	if parts := sliceContext.Partitions; parts != nil {
+		d := sliceContext.Slice.Data
+		d.partitioned = true
+		for _, p := range []struct {
+			nalUnit *NalUnit
+			br      **bits.BitReader
+		}{{parts.B, &d.partitionB}, {parts.C, &d.partitionC}} {
+			if p.nalUnit == nil {
+				continue
+			}
+			*p.br, err = partitionReader(p.nalUnit, sliceContext.SPS, sliceContext.PPS, sliceContext.Slice.Header)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	sliceQPY := SliceQPy(sliceContext.PPS, sliceContext.Slice.Header)
 	// TODO: Why is this being initialized here?
 	// initCabac(sliceContext)
 	if sliceContext.PPS.EntropyCodingMode == 1 {
 		for !br.ByteAligned() {
 			b, err := br.ReadBits(1)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read CabacAlignmentOneBit")
+				return nil, fmt.Errorf("could not read CabacAlignmentOneBit: %w", err)
 			}
 			sliceContext.Slice.Data.CabacAlignmentOneBit = int(b)
+			if b != 1 {
+				return nil, fmt.Errorf("cabac_alignment_one_bit at bit %d not equal to 1", br.Off()-1)
+			}
+		}
+		sliceContext.Slice.Data.cabac, err = newCABACDecoder(
+			br,
+			sliceTypeMap[sliceContext.Slice.Header.SliceType],
+			sliceContext.Slice.Header.CabacInit,
+			sliceQPY,
+		)
+		if err != nil {
+			return nil, err
 		}
 	}
-	mbaffFrameFlag := 0
-	if sliceContext.SPS.MBAdaptiveFrameField && !sliceContext.Slice.Header.FieldPic {
-		mbaffFrameFlag = 1
+	mbaffFrameFlag := MbaffFrameFlag(sliceContext.SPS, sliceContext.Slice.Header)
+	currMbAddr := CurrMbAddr(sliceContext.SPS, sliceContext.Slice.Header)
+	picSizeInMbs := PicSizeInMbs(sliceContext.SPS, sliceContext.Slice.Header)
+	if currMbAddr >= picSizeInMbs {
+		return nil, fmt.Errorf("first macroblock %d of slice outside picture of %d macroblocks", currMbAddr, picSizeInMbs)
 	}
-	currMbAddr := sliceContext.Slice.Header.FirstMbInSlice * (1 * mbaffFrameFlag)
+	stopBit := rbspStopBit(sliceContext.NalUnit.RBSP())
+
+	mbs := sliceContext.arena.mbState(PicWidthInMbs(sliceContext.SPS), PicHeightInMbs(sliceContext.SPS, sliceContext.Slice.Header))
+	sliceContext.Slice.Data.pic = sliceContext.arena.picture(sliceContext.SPS)
+	mbs.mbaff = mbaffFrameFlag == 1
+	sliceNum := mbs.startSlice()
+	sliceContext.arena.slices = append(sliceContext.arena.slices, newSliceDeblocking(sliceContext))
+	lists := sliceContext.arena.sliceLists(sliceContext.Slice.Header, sliceContext.SPS)
+	sliceContext.Slice.Data.direct = newDirectPred(sliceContext, lists)
 
 	moreDataFlag := true
 	prevMbSkipped := 0
+	prevMbAddr := -1
 	sliceContext.Slice.Data.SliceTypeName = sliceTypeMap[sliceContext.Slice.Header.SliceType]
 	sliceContext.Slice.Data.MbTypeName = MbTypeName(sliceContext.Slice.Data.SliceTypeName, sliceContext.Slice.Data.MbType)
 	logger.Printf("debug: \tSliceData: Processing moreData: %v\n", moreDataFlag)
@@ -645,278 +589,112 @@ func NewSliceData(sliceContext *SliceContext, br *bits.BitReader) (*SliceData, e
 		if sliceContext.Slice.Data.SliceTypeName != "I" && sliceContext.Slice.Data.SliceTypeName != "SI" {
 			logger.Printf("debug: \tNonI/SI slice, processing moreData\n")
 			if sliceContext.PPS.EntropyCodingMode == 0 {
-				sliceContext.Slice.Data.MbSkipRun, err = readUe(nil)
+				sliceContext.Slice.Data.MbSkipRun, err = readUe(br)
 				if err != nil {
-					return nil, errors.Wrap(err, "could not parse MbSkipRun")
+					return nil, fmt.Errorf("could not parse MbSkipRun: %w", err)
 				}
-
-				if sliceContext.Slice.Data.MbSkipRun > 0 {
-					prevMbSkipped = 1
+				if sliceContext.Slice.Data.MbSkipRun > picSizeInMbs-currMbAddr {
+					return nil, fmt.Errorf("mb_skip_run %d from macroblock %d exceeds picture of %d macroblocks", sliceContext.Slice.Data.MbSkipRun, currMbAddr, picSizeInMbs)
 				}
+
+				prevMbSkipped = flagVal(sliceContext.Slice.Data.MbSkipRun > 0)
 				for i := 0; i < sliceContext.Slice.Data.MbSkipRun; i++ {
-					// nextMbAddress(currMbAdd
+					sliceContext.Slice.Data.MbFieldDecodingFlag = mbs.beginMb(currMbAddr, sliceNum, mbSkipped)
+					mbs.setTotalCoeff(currMbAddr, 0)
+					mbs.qpY[currMbAddr] = int8(qpYPred(mbs, prevMbAddr, sliceQPY))
+					mbs.setIntraPredModes(currMbAddr, intraPredDC)
+					err = mbs.setSkipMotion(currMbAddr, sliceContext.Slice.Data.SliceTypeName, &sliceContext.Slice.Data.direct)
+					if err != nil {
+						return nil, fmt.Errorf("could not derive motion of skipped macroblock %d: %w", currMbAddr, err)
+					}
+					prevMbAddr = currMbAddr
 					currMbAddr = nextMbAddress(currMbAddr, sliceContext.SPS, sliceContext.PPS, sliceContext.Slice.Header)
 				}
 				if sliceContext.Slice.Data.MbSkipRun > 0 {
-					moreDataFlag = moreRBSPData(br)
+					moreDataFlag = moreRBSPData(br, sliceContext.NalUnit.RBSP())
+					if moreDataFlag && currMbAddr >= picSizeInMbs {
+						return nil, fmt.Errorf("slice data continues past last macroblock %d", picSizeInMbs-1)
+					}
 				}
 			} else {
-				b, err := br.ReadBits(1)
+				// The macroblock is begun before mb_skip_flag is decoded, for
+				// its neighbours to be available to the derivation of ctxIdxInc.
+				sliceContext.Slice.Data.MbFieldDecodingFlag = mbs.beginMb(currMbAddr, sliceNum, 0)
+				ctxIdxOffset := 11
+				if sliceContext.Slice.Data.SliceTypeName == "B" {
+					ctxIdxOffset = 24
+				}
+				b, err := sliceContext.Slice.Data.cabac.decodeDecision(ctxIdxOffset + mbSkipFlagCtxIdxInc(mbs, currMbAddr))
 				if err != nil {
-					return nil, errors.Wrap(err, "could not read MbSkipFlag")
+					return nil, fmt.Errorf("could not read MbSkipFlag: %w", err)
 				}
 				sliceContext.Slice.Data.MbSkipFlag = b == 1
+				if sliceContext.Slice.Data.MbSkipFlag {
+					mbs.flags[currMbAddr] |= mbSkipped
+					mbs.setTotalCoeff(currMbAddr, 0)
+					mbs.qpY[currMbAddr] = int8(qpYPred(mbs, prevMbAddr, sliceQPY))
+					mbs.setIntraPredModes(currMbAddr, intraPredDC)
+
+					// The motion of a skipped top macroblock depends on the
+					// mb_field_decoding_flag of its pair, which may only be
+					// present for the bottom macroblock. The inferred value
+					// is kept until then, being that used in decoding the
+					// bottom macroblock's mb_skip_flag.
+					inferred := mbs.has(currMbAddr, mbFieldDecoded)
+					if mbaffFrameFlag == 1 && currMbAddr%2 == 0 {
+						field, err := sliceContext.Slice.Data.pairFieldDecoding(mbs, currMbAddr, ctxIdxOffset)
+						if err != nil {
+							return nil, err
+						}
+						mbs.setFieldDecoding(currMbAddr, field)
+					}
+					err = mbs.setSkipMotion(currMbAddr, sliceContext.Slice.Data.SliceTypeName, &sliceContext.Slice.Data.direct)
+					if err != nil {
+						return nil, fmt.Errorf("could not derive motion of skipped macroblock %d: %w", currMbAddr, err)
+					}
+					if mbaffFrameFlag == 1 && currMbAddr%2 == 0 {
+						mbs.setFieldDecoding(currMbAddr, inferred)
+					}
+				}
 
 				moreDataFlag = !sliceContext.Slice.Data.MbSkipFlag
 			}
 		}
 		if moreDataFlag {
+			if sliceContext.PPS.EntropyCodingMode == 0 || sliceContext.Slice.Data.SliceTypeName == "I" || sliceContext.Slice.Data.SliceTypeName == "SI" {
+				sliceContext.Slice.Data.MbFieldDecodingFlag = mbs.beginMb(currMbAddr, sliceNum, 0)
+			}
 			if mbaffFrameFlag == 1 && (currMbAddr%2 == 0 || (currMbAddr%2 == 1 && prevMbSkipped == 1)) {
 				if sliceContext.PPS.EntropyCodingMode == 1 {
-					// TODO: ae implementation
-					binarization := NewBinarization("MbFieldDecodingFlag", sliceContext.Slice.Data)
-					// TODO: this should take a BitReader where the nil is.
-					binarization.Decode(sliceContext, br, nil)
-
-					logger.Printf("TODO: ae for MbFieldDecodingFlag\n")
+					b, err := sliceContext.Slice.Data.cabac.decodeDecision(70 + mbFieldDecodingFlagCtxIdxInc(mbs, currMbAddr))
+					if err != nil {
+						return nil, fmt.Errorf("could not read MbFieldDecodingFlag: %w", err)
+					}
+					sliceContext.Slice.Data.MbFieldDecodingFlag = b == 1
+					mbs.setFieldDecoding(currMbAddr, b == 1)
 				} else {
 					b, err := br.ReadBits(1)
 					if err != nil {
-						return nil, errors.Wrap(err, "could not read MbFieldDecodingFlag")
+						return nil, fmt.Errorf("could not read MbFieldDecodingFlag: %w", err)
 					}
 					sliceContext.Slice.Data.MbFieldDecodingFlag = b == 1
+					mbs.setFieldDecoding(currMbAddr, b == 1)
 				}
 			}
 
-			// BEGIN: macroblockLayer()
-			if sliceContext.PPS.EntropyCodingMode == 1 {
-				// TODO: ae implementation
-				binarization := NewBinarization("MbType", sliceContext.Slice.Data)
-				cabac = initCabac(binarization, sliceContext)
-				_ = cabac
-				// TODO: remove bytes parameter from this function.
-				binarization.Decode(sliceContext, br, nil)
-				if binarization.PrefixSuffix {
-					logger.Printf("debug: MBType binarization has prefix and suffix\n")
-				}
-				bits := []int{}
-				for binIdx := 0; binarization.IsBinStringMatch(bits); binIdx++ {
-					newBit, err := br.ReadBits(1)
-					if err != nil {
-						return nil, errors.Wrap(err, "could not read bit")
-					}
-					if binarization.UseDecodeBypass == 1 {
-						// DecodeBypass
-						logger.Printf("TODO: decodeBypass is set: 9.3.3.2.3")
-						codIRange, codIOffset, err := initDecodingEngine(sliceContext.Slice.Data.BitReader)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not initialise decoding engine")
-						}
-						// Initialize the decoder
-						// TODO: When should the suffix of MaxBinIdxCtx be used and when just the prefix?
-						// TODO: When should the suffix of CtxIdxOffset be used?
-						arithmeticDecoder, err := NewArithmeticDecoding(
-							sliceContext,
-							binarization,
-							CtxIdx(
-								binarization.binIdx,
-								binarization.MaxBinIdxCtx.Prefix,
-								binarization.CtxIdxOffset.Prefix,
-							),
-							codIRange,
-							codIOffset,
-						)
-						if err != nil {
-							return nil, errors.Wrap(err, "error from NewArithmeticDecoding")
-						}
-						// Bypass decoding
-						codIOffset, _, err = arithmeticDecoder.DecodeBypass(
-							sliceContext.Slice.Data,
-							codIRange,
-							codIOffset,
-						)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not DecodeBypass")
-						}
-						// End DecodeBypass
-
-					} else {
-						// DO 9.3.3.1
-						ctxIdx := CtxIdx(
-							binIdx,
-							binarization.MaxBinIdxCtx.Prefix,
-							binarization.CtxIdxOffset.Prefix)
-						if binarization.MaxBinIdxCtx.IsPrefixSuffix {
-							logger.Printf("TODO: Handle PrefixSuffix binarization\n")
-						}
-						logger.Printf("debug: MBType ctxIdx for %d is %d\n", binIdx, ctxIdx)
-						// Then 9.3.3.2
-						codIRange, codIOffset, err := initDecodingEngine(br)
-						if err != nil {
-							return nil, errors.Wrap(err, "error from initDecodingEngine")
-						}
-						logger.Printf("debug: coding engine initialized: %d/%d\n", codIRange, codIOffset)
-					}
-					bits = append(bits, int(newBit))
-				}
-
-				logger.Printf("TODO: ae for MBType\n")
-			} else {
-				sliceContext.Slice.Data.MbType, err = readUe(nil)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not parse MbType")
-				}
+			err = sliceContext.Slice.Data.readMacroblockLayer(sliceContext, mbs, currMbAddr, prevMbAddr)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse macroblock %d: %w", currMbAddr, err)
 			}
-			if sliceContext.Slice.Data.MbTypeName == "I_PCM" {
-				for !br.ByteAligned() {
-					_, err := br.ReadBits(1)
-					if err != nil {
-						return nil, errors.Wrap(err, "could not read PCMAlignmentZeroBit")
-					}
-				}
-				// 7-3 p95
-				bitDepthY := 8 + sliceContext.SPS.BitDepthLumaMinus8
-				for i := 0; i < 256; i++ {
-					s, err := br.ReadBits(bitDepthY)
-					if err != nil {
-						return nil, errors.Wrap(err, fmt.Sprintf("could not read PcmSampleLuma[%d]", i))
-					}
-					sliceContext.Slice.Data.PcmSampleLuma = append(
-						sliceContext.Slice.Data.PcmSampleLuma,
-						int(s))
-				}
-				// 9.3.1 p 246
-				// cabac = initCabac(binarization, sliceContext)
-				// 6-1 p 47
-				mbWidthC := 16 / SubWidthC(sliceContext.SPS)
-				mbHeightC := 16 / SubHeightC(sliceContext.SPS)
-				// if monochrome
-				if sliceContext.SPS.ChromaFormat == chromaMonochrome || sliceContext.SPS.UseSeparateColorPlane {
-					mbWidthC = 0
-					mbHeightC = 0
-				}
-
-				bitDepthC := 8 + sliceContext.SPS.BitDepthChromaMinus8
-				for i := 0; i < 2*mbWidthC*mbHeightC; i++ {
-					s, err := br.ReadBits(bitDepthC)
-					if err != nil {
-						return nil, errors.Wrap(err, fmt.Sprintf("could not read PcmSampleChroma[%d]", i))
-					}
-					sliceContext.Slice.Data.PcmSampleChroma = append(
-						sliceContext.Slice.Data.PcmSampleChroma,
-						int(s))
-				}
-				// 9.3.1 p 246
-				// cabac = initCabac(binarization, sliceContext)
-
-			} else {
-				noSubMbPartSizeLessThan8x8Flag := 1
-				m, err := MbPartPredMode(sliceContext.Slice.Data, sliceContext.Slice.Data.SliceTypeName, sliceContext.Slice.Data.MbType, 0)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not get mbPartPredMode")
-				}
-				if sliceContext.Slice.Data.MbTypeName == "I_NxN" && m != intra16x16 && NumMbPart(sliceContext.NalUnit, sliceContext.SPS, sliceContext.Slice.Header, sliceContext.Slice.Data) == 4 {
-					logger.Printf("\tTODO: subMbPred\n")
-					/*
-						subMbType := SubMbPred(sliceContext.Slice.Data.MbType)
-						for mbPartIdx := 0; mbPartIdx < 4; mbPartIdx++ {
-							if subMbType[mbPartIdx] != "B_Direct_8x8" {
-								if NumbSubMbPart(subMbType[mbPartIdx]) > 1 {
-									noSubMbPartSizeLessThan8x8Flag = 0
-								}
-							} else if !sliceContext.SPS.Direct8x8Inference {
-								noSubMbPartSizeLessThan8x8Flag = 0
-							}
-						}
-					*/
-				} else {
-					if sliceContext.PPS.Transform8x8Mode == 1 && sliceContext.Slice.Data.MbTypeName == "I_NxN" {
-						// TODO
-						// 1 bit or ae(v)
-						// If sliceContext.PPS.EntropyCodingMode == 1, use ae(v)
-						if sliceContext.PPS.EntropyCodingMode == 1 {
-							binarization := NewBinarization("TransformSize8x8Flag", sliceContext.Slice.Data)
-							cabac = initCabac(binarization, sliceContext)
-							binarization.Decode(sliceContext, br, nil)
-
-							logger.Println("TODO: ae(v) for TransformSize8x8Flag")
-						} else {
-							b, err := br.ReadBits(1)
-							if err != nil {
-								return nil, errors.Wrap(err, "could not read TransformSize8x8Flag")
-							}
-							sliceContext.Slice.Data.TransformSize8x8Flag = b == 1
-						}
-					}
-					// TODO: fix nil argument for.
-					MbPred(sliceContext, br, nil)
-				}
-				m, err = MbPartPredMode(sliceContext.Slice.Data, sliceContext.Slice.Data.SliceTypeName, sliceContext.Slice.Data.MbType, 0)
+			if sliceContext.Slice.Data.pic != nil {
+				err = sliceContext.Slice.Data.constructMb(sliceContext, mbs, currMbAddr)
 				if err != nil {
-					return nil, errors.Wrap(err, "could not get mbPartPredMode")
-				}
-				if m != intra16x16 {
-					// TODO: me, ae
-					logger.Printf("TODO: CodedBlockPattern pending me/ae implementation\n")
-					if sliceContext.PPS.EntropyCodingMode == 1 {
-						binarization := NewBinarization("CodedBlockPattern", sliceContext.Slice.Data)
-						cabac = initCabac(binarization, sliceContext)
-						// TODO: fix nil argument.
-						binarization.Decode(sliceContext, br, nil)
-
-						logger.Printf("TODO: ae for CodedBlockPattern\n")
-					} else {
-						me, _ := readMe(
-							nil,
-							uint(sliceContext.Slice.Header.ChromaArrayType),
-							// TODO: fix this
-							//MbPartPredMode(sliceContext.Slice.Data, sliceContext.Slice.Data.SliceTypeName, sliceContext.Slice.Data.MbType, 0)))
-							0)
-						sliceContext.Slice.Data.CodedBlockPattern = int(me)
-					}
-
-					// sliceContext.Slice.Data.CodedBlockPattern = me(v) | ae(v)
-					if CodedBlockPatternLuma(sliceContext.Slice.Data) > 0 && sliceContext.PPS.Transform8x8Mode == 1 && sliceContext.Slice.Data.MbTypeName != "I_NxN" && noSubMbPartSizeLessThan8x8Flag == 1 && (sliceContext.Slice.Data.MbTypeName != "B_Direct_16x16" || sliceContext.SPS.Direct8x8Inference) {
-						// TODO: 1 bit or ae(v)
-						if sliceContext.PPS.EntropyCodingMode == 1 {
-							binarization := NewBinarization("Transform8x8Flag", sliceContext.Slice.Data)
-							cabac = initCabac(binarization, sliceContext)
-							// TODO: fix nil argument.
-							binarization.Decode(sliceContext, br, nil)
-
-							logger.Printf("TODO: ae for TranformSize8x8Flag\n")
-						} else {
-							b, err := br.ReadBits(1)
-							if err != nil {
-								return nil, errors.Wrap(err, "coult not read TransformSize8x8Flag")
-							}
-							sliceContext.Slice.Data.TransformSize8x8Flag = b == 1
-						}
-					}
-				}
-				m, err = MbPartPredMode(sliceContext.Slice.Data, sliceContext.Slice.Data.SliceTypeName, sliceContext.Slice.Data.MbType, 0)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not get mbPartPredMode")
-				}
-				if CodedBlockPatternLuma(sliceContext.Slice.Data) > 0 || CodedBlockPatternChroma(sliceContext.Slice.Data) > 0 || m == intra16x16 {
-					// TODO: se or ae(v)
-					if sliceContext.PPS.EntropyCodingMode == 1 {
-						binarization := NewBinarization("MbQpDelta", sliceContext.Slice.Data)
-						cabac = initCabac(binarization, sliceContext)
-						// TODO; fix nil argument
-						binarization.Decode(sliceContext, br, nil)
-
-						logger.Printf("TODO: ae for MbQpDelta\n")
-					} else {
-						sliceContext.Slice.Data.MbQpDelta, _ = readSe(nil)
-					}
-
+					return nil, fmt.Errorf("could not construct macroblock %d: %w", currMbAddr, err)
 				}
 			}
-
-		} // END MacroblockLayer
+		}
 		if sliceContext.PPS.EntropyCodingMode == 0 {
-			moreDataFlag = moreRBSPData(br)
+			moreDataFlag = moreRBSPData(br, sliceContext.NalUnit.RBSP())
 		} else {
 			if sliceContext.Slice.Data.SliceTypeName != "I" && sliceContext.Slice.Data.SliceTypeName != "SI" {
 				if sliceContext.Slice.Data.MbSkipFlag {
@@ -928,425 +706,546 @@ func NewSliceData(sliceContext *SliceContext, br *bits.BitReader) (*SliceData, e
 			if mbaffFrameFlag == 1 && currMbAddr%2 == 0 {
 				moreDataFlag = true
 			} else {
-				// TODO: ae implementation
-				b, err := br.ReadBits(1)
+				sliceContext.Slice.Data.EndOfSliceFlag, err = sliceContext.Slice.Data.readEndOfSliceFlag()
 				if err != nil {
-					return nil, errors.Wrap(err, "could not read EndOfSliceFlag")
+					return nil, err
 				}
-				sliceContext.Slice.Data.EndOfSliceFlag = b == 1
 				moreDataFlag = !sliceContext.Slice.Data.EndOfSliceFlag
 			}
 		}
+		prevMbAddr = currMbAddr
 		currMbAddr = nextMbAddress(currMbAddr, sliceContext.SPS, sliceContext.PPS, sliceContext.Slice.Header)
+		if moreDataFlag && currMbAddr >= picSizeInMbs {
+			return nil, fmt.Errorf("slice data continues past last macroblock %d", picSizeInMbs-1)
+		}
 	} // END while moreDataFlag
+
+	// Slices of MBAFF frames hold whole macroblock pairs (see section 7.4.3).
+	if mbaffFrameFlag == 1 && mbs.lastMbAddr%2 == 0 {
+		return nil, fmt.Errorf("slice data ends within macroblock pair at macroblock %d", mbs.lastMbAddr)
+	}
+	sliceContext.Slice.Data.NumMbs = mbs.sliceMbs
+
+	// Under CAVLC parsing stops on reaching the rbsp_stop_one_bit, and under
+	// CABAC the last bit read by the decoding engine on decoding an
+	// end_of_slice_flag of 1 is the rbsp_stop_one_bit (see section 9.3.3.2.4).
+	// Either way, a slice ending elsewhere has been misparsed.
+	end := br.Off()
+	if sliceContext.PPS.EntropyCodingMode == 1 {
+		end--
+	}
+	if end != stopBit {
+		return nil, fmt.Errorf("slice data ends at bit %d, not at rbsp_stop_one_bit %d", end, stopBit)
+	}
+	if sliceContext.PPS.EntropyCodingMode == 1 {
+		sliceContext.Slice.Data.CabacZeroWords, err = cabacZeroWords(sliceContext.NalUnit.RBSP(), stopBit)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return sliceContext.Slice.Data, nil
 }
 
+// readEndOfSliceFlag decodes an end_of_slice_flag using the arithmetic
+// decoding engine of the slice, see section 9.3.3.2.4.
+func (d *SliceData) readEndOfSliceFlag() (bool, error) {
+	var (
+		binVal int
+		err    error
+	)
+	binVal, err = d.cabac.decodeTerminate()
+	if err != nil {
+		return false, fmt.Errorf("could not decode EndOfSliceFlag: %w", err)
+	}
+	return binVal == 1, nil
+}
+
 func (c *SliceContext) Update(header *SliceHeader, data *SliceData) {
 	c.Slice = &Slice{Header: header, Data: data}
 }
+
+// NewSliceContext parses the slice in rbsp, belonging to the given NAL unit,
+// using the parameter sets of videoStream.
 func NewSliceContext(videoStream *VideoStream, nalUnit *NalUnit, rbsp []byte, showPacket bool) (*SliceContext, error) {
-	var err error
-	sps := videoStream.SPS
-	pps := videoStream.PPS
-	logger.Printf("debug: %s RBSP %d bytes %d bits == \n", NALUnitType[nalUnit.Type], len(rbsp), len(rbsp)*8)
-	logger.Printf("debug: \t%#v\n", rbsp[0:8])
-	var idrPic bool
-	if nalUnit.Type == 5 {
-		idrPic = true
-	}
-	header := SliceHeader{}
-	if sps.UseSeparateColorPlane {
-		header.ChromaArrayType = 0
-	} else {
-		header.ChromaArrayType = sps.ChromaFormat
-	}
-	br := bits.NewBitReader(bytes.NewReader(rbsp))
+	return newSliceContext(videoStream, nalUnit, rbsp, showPacket, &arena{}, nil)
+}
 
-	header.FirstMbInSlice, err = readUe(nil)
+// readSliceHeader parses a slice_header, as given by section 7.3.3, of a slice
+// of the given NAL unit using the given active SPS and PPS. Where
+// num_ref_idx_active_override_flag is not set, the number of active reference
+// indices is taken from the PPS defaults.
+func readSliceHeader(br *bits.BitReader, nalUnit *NalUnit, sps *SPS, pps *PPS) (*SliceHeader, error) {
+	header, err := readSliceHeaderStart(br, nalUnit, sps)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse FirstMbInSlice")
+		return nil, err
 	}
-
-	header.SliceType, err = readUe(nil)
+	err = readSliceHeaderPicture(br, header, sps, pps)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse SliceType")
+		return nil, err
 	}
-
 	sliceType := sliceTypeMap[header.SliceType]
-	logger.Printf("debug: %s (%s) slice of %d bytes\n", NALUnitType[nalUnit.Type], sliceType, len(rbsp))
-	header.PPSID, err = readUe(nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not parse PPSID")
-	}
-
-	if sps.UseSeparateColorPlane {
-		b, err := br.ReadBits(2)
+	if sliceType == "B" {
+		err = readFlags(br, []flag{{&header.DirectSpatialMvPred, "DirectSpatialMvPred"}})
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read ColorPlaneID")
+			return nil, err
 		}
-		header.ColorPlaneID = int(b)
 	}
-	// TODO: See 7.4.3
-	// header.FrameNum = b.NextField("FrameNum", 0)
-	if !sps.FrameMbsOnly {
-		b, err := br.ReadBits(1)
+
+	header.NumRefIdxL0ActiveMinus1 = pps.NumRefIdxL0DefaultActiveMinus1
+	header.NumRefIdxL1ActiveMinus1 = pps.NumRefIdxL1DefaultActiveMinus1
+	if sliceType == "P" || sliceType == "SP" || sliceType == "B" {
+		err = readFlags(br, []flag{{&header.NumRefIdxActiveOverride, "NumRefIdxActiveOverride"}})
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read FieldPic")
+			return nil, err
 		}
-		header.FieldPic = b == 1
-		if header.FieldPic {
-			b, err := br.ReadBits(1)
+		if header.NumRefIdxActiveOverride {
+			header.NumRefIdxL0ActiveMinus1, err = readUe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read BottomField")
+				return nil, fmt.Errorf("could not parse NumRefIdxL0ActiveMinus1: %w", err)
+			}
+			if sliceType == "B" {
+				header.NumRefIdxL1ActiveMinus1, err = readUe(br)
+				if err != nil {
+					return nil, fmt.Errorf("could not parse NumRefIdxL1ActiveMinus1: %w", err)
+				}
 			}
-			header.BottomField = b == 1
-		}
-	}
-	if idrPic {
-		header.IDRPicID, err = readUe(nil)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not parse IDRPicID")
 		}
 	}
-	if sps.PicOrderCountType == 0 {
-		b, err := br.ReadBits(sps.Log2MaxPicOrderCntLSBMin4 + 4)
+
+	// ref_pic_list_modification or, for MVC, ref_pic_list_mvc_modification.
+	mvc := nalUnit.Type == NALTypeSliceExtension || nalUnit.Type == NALTypeSliceExtensionDepth
+	if sliceType != "I" && sliceType != "SI" {
+		err = readFlags(br, []flag{{&header.RefPicListModificationFlagL0, "RefPicListModificationFlagL0"}})
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read PicOrderCntLsb")
+			return nil, err
 		}
-		header.PicOrderCntLsb = int(b)
-
-		if pps.BottomFieldPicOrderInFramePresent && !header.FieldPic {
-			header.DeltaPicOrderCntBottom, err = readSe(nil)
+		if header.RefPicListModificationFlagL0 {
+			header.RefPicListModificationL0, err = readRefPicListModification(br, header.NumRefIdxL0ActiveMinus1+1, mvc)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not parse DeltaPicOrderCntBottom")
+				return nil, err
 			}
 		}
 	}
-	if sps.PicOrderCountType == 1 && !sps.DeltaPicOrderAlwaysZero {
-		header.DeltaPicOrderCnt[0], err = readSe(nil)
+	if sliceType == "B" {
+		err = readFlags(br, []flag{{&header.RefPicListModificationFlagL1, "RefPicListModificationFlagL1"}})
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse DeltaPicOrderCnt")
+			return nil, err
 		}
-
-		if pps.BottomFieldPicOrderInFramePresent && !header.FieldPic {
-			header.DeltaPicOrderCnt[1], err = readSe(nil)
+		if header.RefPicListModificationFlagL1 {
+			header.RefPicListModificationL1, err = readRefPicListModification(br, header.NumRefIdxL1ActiveMinus1+1, mvc)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not parse DeltaPicOrderCnt")
+				return nil, err
 			}
 		}
 	}
-	if pps.RedundantPicCntPresent {
-		header.RedundantPicCnt, err = readUe(nil)
+
+	if (pps.WeightedPred && (sliceType == "P" || sliceType == "SP")) || (pps.WeightedBipred == 1 && sliceType == "B") {
+		header.PredWeightTable, err = readPredWeightTable(br, header, sliceType)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse RedundantPicCnt")
+			return nil, err
 		}
 	}
-	if sliceType == "B" {
-		b, err := br.ReadBits(1)
+	// The marking of an IDR picture as a reference picture is given by its
+	// dec_ref_pic_marking, which is only present if nal_ref_idc is not 0.
+	if header.IdrPic && !header.IsReference() {
+		return nil, errors.New("nal_ref_idc is 0 for IDR picture")
+	}
+	if header.IsReference() {
+		err = readDecRefPicMarking(br, header)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read DirectSpatialMvPred")
+			return nil, err
 		}
-		header.DirectSpatialMvPred = b == 1
 	}
-	if sliceType == "B" || sliceType == "SP" {
-		b, err := br.ReadBits(1)
+	if pps.EntropyCodingMode == 1 && sliceType != "I" && sliceType != "SI" {
+		header.CabacInit, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read NumRefIdxActiveOverride")
+			return nil, fmt.Errorf("could not parse CabacInit: %w", err)
 		}
-		header.NumRefIdxActiveOverride = b == 1
+	}
+	header.SliceQpDelta, err = readSe(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse SliceQpDelta: %w", err)
+	}
 
-		if header.NumRefIdxActiveOverride {
-			header.NumRefIdxL0ActiveMinus1, err = readUe(nil)
+	if sliceType == "SP" || sliceType == "SI" {
+		if sliceType == "SP" {
+			err = readFlags(br, []flag{{&header.SpForSwitch, "SpForSwitch"}})
 			if err != nil {
-				return nil, errors.Wrap(err, "could not parse NumRefIdxL0ActiveMinus1")
-			}
-			if sliceType == "B" {
-				header.NumRefIdxL1ActiveMinus1, err = readUe(nil)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not parse NumRefIdxL1ActiveMinus1")
-				}
+				return nil, err
 			}
 		}
+		header.SliceQsDelta, err = readSe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse SliceQsDelta: %w", err)
+		}
 	}
-
-	if nalUnit.Type == 20 || nalUnit.Type == 21 {
-		// Annex H
-		// H.7.3.3.1.1
-		// refPicListMvcModifications()
-	} else {
-		// 7.3.3.1
-		if header.SliceType%5 != 2 && header.SliceType%5 != 4 {
-			b, err := br.ReadBits(1)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not read RefPicListModificationFlagL0")
-			}
-			header.RefPicListModificationFlagL0 = b == 1
-
-			if header.RefPicListModificationFlagL0 {
-				for header.ModificationOfPicNums != 3 {
-					header.ModificationOfPicNums, err = readUe(nil)
-					if err != nil {
-						return nil, errors.Wrap(err, "could not parse ModificationOfPicNums")
-					}
-
-					if header.ModificationOfPicNums == 0 || header.ModificationOfPicNums == 1 {
-						header.AbsDiffPicNumMinus1, err = readUe(nil)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not parse AbsDiffPicNumMinus1")
-						}
-					} else if header.ModificationOfPicNums == 2 {
-						header.LongTermPicNum, err = readUe(nil)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not parse LongTermPicNum")
-						}
-					}
-				}
-			}
-
+	if pps.DeblockingFilterControlPresent {
+		header.DisableDeblockingFilter, err = readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse DisableDeblockingFilter: %w", err)
 		}
-		if header.SliceType%5 == 1 {
-			b, err := br.ReadBits(1)
+		if header.DisableDeblockingFilter != 1 {
+			header.SliceAlphaC0OffsetDiv2, err = readSe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read RefPicListModificationFlagL1")
+				return nil, fmt.Errorf("could not parse SliceAlphaC0OffsetDiv2: %w", err)
 			}
-			header.RefPicListModificationFlagL1 = b == 1
-
-			if header.RefPicListModificationFlagL1 {
-				for header.ModificationOfPicNums != 3 {
-					header.ModificationOfPicNums, err = readUe(nil)
-					if err != nil {
-						return nil, errors.Wrap(err, "could not parse ModificationOfPicNums")
-					}
-
-					if header.ModificationOfPicNums == 0 || header.ModificationOfPicNums == 1 {
-						header.AbsDiffPicNumMinus1, err = readUe(nil)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not parse AbsDiffPicNumMinus1")
-						}
-					} else if header.ModificationOfPicNums == 2 {
-						header.LongTermPicNum, err = readUe(nil)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not parse LongTermPicNum")
-						}
-					}
-				}
+			header.SliceBetaOffsetDiv2, err = readSe(br)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse SliceBetaOffsetDiv2: %w", err)
 			}
 		}
-		// refPicListModification()
 	}
-
-	if (pps.WeightedPred && (sliceType == "P" || sliceType == "SP")) || (pps.WeightedBipred == 1 && sliceType == "B") {
-		// predWeightTable()
-		header.LumaLog2WeightDenom, err = readUe(nil)
+	if pps.NumSliceGroupsMinus1 > 0 && pps.SliceGroupMapType >= 3 && pps.SliceGroupMapType <= 5 {
+		err = readFields(br, []field{{&header.SliceGroupChangeCycle, "SliceGroupChangeCycle", sliceGroupChangeCycleBits(sps, pps)}})
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse LumaLog2WeightDenom")
+			return nil, err
 		}
+	}
+	return header, nil
+}
 
-		if header.ChromaArrayType != 0 {
-			header.ChromaLog2WeightDenom, err = readUe(nil)
+// readSliceHeaderPicture parses the syntax elements of a slice header
+// following those parsed by readSliceHeaderStart that, with them, identify
+// the picture to which the slice belongs: idr_pic_id, those giving the
+// picture order count, and redundant_pic_cnt.
+func readSliceHeaderPicture(br *bits.BitReader, header *SliceHeader, sps *SPS, pps *PPS) error {
+	var err error
+	if header.IdrPic {
+		header.IDRPicID, err = readUe(br)
+		if err != nil {
+			return fmt.Errorf("could not parse IDRPicID: %w", err)
+		}
+	}
+	if sps.PicOrderCountType == 0 {
+		err = readFields(br, []field{{&header.PicOrderCntLsb, "PicOrderCntLsb", sps.Log2MaxPicOrderCntLSBMin4 + 4}})
+		if err != nil {
+			return err
+		}
+		if pps.BottomFieldPicOrderInFramePresent && !header.FieldPic {
+			header.DeltaPicOrderCntBottom, err = readSe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not parse ChromaLog2WeightDenom")
+				return fmt.Errorf("could not parse DeltaPicOrderCntBottom: %w", err)
 			}
 		}
-		for i := 0; i <= header.NumRefIdxL0ActiveMinus1; i++ {
-			b, err := br.ReadBits(1)
+	}
+	if sps.PicOrderCountType == 1 && !sps.DeltaPicOrderAlwaysZero {
+		header.DeltaPicOrderCnt = make([]int, 2)
+		header.DeltaPicOrderCnt[0], err = readSe(br)
+		if err != nil {
+			return fmt.Errorf("could not parse DeltaPicOrderCnt: %w", err)
+		}
+		if pps.BottomFieldPicOrderInFramePresent && !header.FieldPic {
+			header.DeltaPicOrderCnt[1], err = readSe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read LumaWeightL0Flag")
+				return fmt.Errorf("could not parse DeltaPicOrderCnt: %w", err)
 			}
-			header.LumaWeightL0Flag = b == 1
+		}
+	}
+	if pps.RedundantPicCntPresent {
+		header.RedundantPicCnt, err = readUe(br)
+		if err != nil {
+			return fmt.Errorf("could not parse RedundantPicCnt: %w", err)
+		}
+	}
+	return nil
+}
 
-			if header.LumaWeightL0Flag {
-				se, err := readSe(nil)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not parse LumaWeightL0")
-				}
-				header.LumaWeightL0 = append(header.LumaWeightL0, se)
+// readSliceHeaderStart parses the start of a slice_header, up to and including
+// bottom_field_flag, being the part that depends on the SPS alone.
+func readSliceHeaderStart(br *bits.BitReader, nalUnit *NalUnit, sps *SPS) (*SliceHeader, error) {
+	header := &SliceHeader{
+		NalRefIdc:       nalUnit.RefIdc,
+		IdrPic:          nalUnit.Type.IsIDR(),
+		ChromaArrayType: sps.ChromaArrayType(),
+	}
 
-				se, err = readSe(nil)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not parse LumaOffsetL0")
-				}
-				header.LumaOffsetL0 = append(header.LumaOffsetL0, se)
-			}
-			if header.ChromaArrayType != 0 {
-				b, err := br.ReadBits(1)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not read ChromaWeightL0Flag")
-				}
-				header.ChromaWeightL0Flag = b == 1
+	var err error
+	header.FirstMbInSlice, err = readUe(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse FirstMbInSlice: %w", err)
+	}
 
-				if header.ChromaWeightL0Flag {
-					header.ChromaWeightL0 = append(header.ChromaWeightL0, []int{})
-					header.ChromaOffsetL0 = append(header.ChromaOffsetL0, []int{})
-					for j := 0; j < 2; j++ {
-						se, err := readSe(nil)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not parse ChromaWeightL0")
-						}
-						header.ChromaWeightL0[i] = append(header.ChromaWeightL0[i], se)
+	header.SliceType, err = readUe(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse SliceType: %w", err)
+	}
+	sliceType, ok := sliceTypeMap[header.SliceType]
+	if !ok {
+		return nil, fmt.Errorf("invalid SliceType %d", header.SliceType)
+	}
+	logger.Printf("debug: %s (%s) slice\n", nalUnit.Type, sliceType)
 
-						se, err = readSe(nil)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not parse ChromaOffsetL0")
-						}
-						header.ChromaOffsetL0[i] = append(header.ChromaOffsetL0[i], se)
-					}
-				}
+	header.PPSID, err = readUe(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse PPSID: %w", err)
+	}
+
+	if sps.UseSeparateColorPlane {
+		err = readFields(br, []field{{&header.ColorPlaneID, "ColorPlaneID", 2}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = readFields(br, []field{{&header.FrameNum, "FrameNum", sps.Log2MaxFrameNumMinus4 + 4}})
+	if err != nil {
+		return nil, err
+	}
+	if !sps.FrameMbsOnly {
+		err = readFlags(br, []flag{{&header.FieldPic, "FieldPic"}})
+		if err != nil {
+			return nil, err
+		}
+		if header.FieldPic {
+			err = readFlags(br, []flag{{&header.BottomField, "BottomField"}})
+			if err != nil {
+				return nil, err
 			}
 		}
-		if header.SliceType%5 == 1 {
-			for i := 0; i <= header.NumRefIdxL1ActiveMinus1; i++ {
-				b, err := br.ReadBits(1)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not read LumaWeightL1Flag")
-				}
-				header.LumaWeightL1Flag = b == 1
+	}
+	return header, nil
+}
 
-				if header.LumaWeightL1Flag {
-					se, err := readSe(nil)
-					if err != nil {
-						return nil, errors.Wrap(err, "could not parse LumaWeightL1")
-					}
-					header.LumaWeightL1 = append(header.LumaWeightL1, se)
+// sliceGroupChangeCycleBits returns the length of slice_group_change_cycle,
+// being Ceil(Log2(PicSizeInMapUnits ÷ SliceGroupChangeRate + 1)) as given by
+// equation 7-35.
+func sliceGroupChangeCycleBits(sps *SPS, pps *PPS) int {
+	rate := float64(pps.SliceGroupChangeRateMinus1 + 1)
+	return int(math.Ceil(math.Log2(float64(PicSizeInMapUnits(sps))/rate + 1)))
+}
 
-					se, err = readSe(nil)
-					if err != nil {
-						return nil, errors.Wrap(err, "could not parse LumaOffsetL1")
-					}
-					header.LumaOffsetL1 = append(header.LumaOffsetL1, se)
-				}
-				if header.ChromaArrayType != 0 {
-					b, err := br.ReadBits(1)
-					if err != nil {
-						return nil, errors.Wrap(err, "could not read ChromaWeightL1Flag")
-					}
-					header.ChromaWeightL1Flag = b == 1
-
-					if header.ChromaWeightL1Flag {
-						header.ChromaWeightL1 = append(header.ChromaWeightL1, []int{})
-						header.ChromaOffsetL1 = append(header.ChromaOffsetL1, []int{})
-						for j := 0; j < 2; j++ {
-							se, err := readSe(nil)
-							if err != nil {
-								return nil, errors.Wrap(err, "could not parse ChromaWeightL1")
-							}
-							header.ChromaWeightL1[i] = append(header.ChromaWeightL1[i], se)
-
-							se, err = readSe(nil)
-							if err != nil {
-								return nil, errors.Wrap(err, "could not parse ChromaOffsetL1")
-							}
-							header.ChromaOffsetL1[i] = append(header.ChromaOffsetL1[i], se)
-						}
-					}
-				}
-			}
+// readRefPicListModification parses the operations of a
+// ref_pic_list_modification, following a set ref_pic_list_modification_flag,
+// up to and including modification_of_pic_nums_idc equal to 3. For MVC slices
+// the inter-view operations of ref_pic_list_mvc_modification, given by section
+// H.7.3.3.1.1, are also accepted. As required by section 7.4.3.1, there may
+// be no more operations than numRefIdxActive, being the number of active
+// entries of the list, num_ref_idx_lX_active_minus1 + 1.
+func readRefPicListModification(br *bits.BitReader, numRefIdxActive int, mvc bool) ([]RefPicListModification, error) {
+	var mods []RefPicListModification
+	for {
+		var m RefPicListModification
+		var err error
+		m.ModificationOfPicNumsIdc, err = readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse ModificationOfPicNumsIdc: %w", err)
 		}
-	} // end predWeightTable
-	if nalUnit.RefIdc != 0 {
-		// devRefPicMarking()
-		if idrPic {
-			b, err := br.ReadBits(1)
+		switch idc := m.ModificationOfPicNumsIdc; {
+		case idc == 0 || idc == 1:
+			m.AbsDiffPicNumMinus1, err = readUe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read NoOutputOfPriorPicsFlag")
+				return nil, fmt.Errorf("could not parse AbsDiffPicNumMinus1: %w", err)
 			}
-			header.NoOutputOfPriorPicsFlag = b == 1
-
-			b, err = br.ReadBits(1)
+		case idc == 2:
+			m.LongTermPicNum, err = readUe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read LongTermReferenceFlag")
+				return nil, fmt.Errorf("could not parse LongTermPicNum: %w", err)
 			}
-			header.LongTermReferenceFlag = b == 1
-		} else {
-			b, err := br.ReadBits(1)
+		case idc == 3:
+			return mods, nil
+		case mvc && (idc == 4 || idc == 5):
+			m.AbsDiffViewIdxMinus1, err = readUe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read AdaptiveRefPicMarkingModeFlag")
+				return nil, fmt.Errorf("could not parse AbsDiffViewIdxMinus1: %w", err)
 			}
-			header.AdaptiveRefPicMarkingModeFlag = b == 1
+		default:
+			return nil, fmt.Errorf("invalid ModificationOfPicNumsIdc %d", idc)
+		}
+		if len(mods) == numRefIdxActive {
+			return nil, fmt.Errorf("more than %d reference picture list modifications", numRefIdxActive)
+		}
+		mods = append(mods, m)
+	}
+}
 
-			if header.AdaptiveRefPicMarkingModeFlag {
-				header.MemoryManagementControlOperation, err = readUe(nil)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not parse MemoryManagementControlOperation")
-				}
-				for header.MemoryManagementControlOperation != 0 {
-					if header.MemoryManagementControlOperation == 1 || header.MemoryManagementControlOperation == 3 {
-						header.DifferenceOfPicNumsMinus1, err = readUe(nil)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not parse MemoryManagementControlOperation")
-						}
-					}
-					if header.MemoryManagementControlOperation == 2 {
-						header.LongTermPicNum, err = readUe(nil)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not parse LongTermPicNum")
-						}
-					}
-					if header.MemoryManagementControlOperation == 3 || header.MemoryManagementControlOperation == 6 {
-						header.LongTermFrameIdx, err = readUe(nil)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not parse LongTermFrameIdx")
-						}
-					}
-					if header.MemoryManagementControlOperation == 4 {
-						header.MaxLongTermFrameIdxPlus1, err = readUe(nil)
-						if err != nil {
-							return nil, errors.Wrap(err, "could not parse MaxLongTermFrameIdxPlus1")
-						}
-					}
-				}
-			}
-		} // end decRefPicMarking
+// readPredWeightTable parses a pred_weight_table, as given by section
+// 7.3.3.2, for a slice of the given type with the given header. Weights for
+// list 1 are parsed for B slices only, and chroma weights only where
+// ChromaArrayType is not 0.
+func readPredWeightTable(br *bits.BitReader, header *SliceHeader, sliceType string) (*PredWeightTable, error) {
+	t := &PredWeightTable{}
+	var err error
+	t.LumaLog2WeightDenom, err = readUe(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse LumaLog2WeightDenom: %w", err)
 	}
-	if pps.EntropyCodingMode == 1 && sliceType != "I" && sliceType != "SI" {
-		header.CabacInit, err = readUe(nil)
+	if t.LumaLog2WeightDenom > 7 {
+		return nil, fmt.Errorf("invalid LumaLog2WeightDenom %d", t.LumaLog2WeightDenom)
+	}
+	chroma := header.ChromaArrayType != 0
+	if chroma {
+		t.ChromaLog2WeightDenom, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse CabacInit")
+			return nil, fmt.Errorf("could not parse ChromaLog2WeightDenom: %w", err)
+		}
+		if t.ChromaLog2WeightDenom > 7 {
+			return nil, fmt.Errorf("invalid ChromaLog2WeightDenom %d", t.ChromaLog2WeightDenom)
 		}
 	}
-	header.SliceQpDelta, err = readSe(nil)
+
+	t.L0, err = readPredWeights(br, t, header.NumRefIdxL0ActiveMinus1+1, chroma, "L0")
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse SliceQpDelta")
+		return nil, err
 	}
+	if sliceType == "B" {
+		t.L1, err = readPredWeights(br, t, header.NumRefIdxL1ActiveMinus1+1, chroma, "L1")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
 
-	if sliceType == "SP" || sliceType == "SI" {
-		if sliceType == "SP" {
-			b, err := br.ReadBits(1)
+// readPredWeights parses the weights and offsets of n reference indices of
+// the given list, "L0" or "L1", of the pred_weight_table t, inferring those
+// not present.
+func readPredWeights(br *bits.BitReader, t *PredWeightTable, n int, chroma bool, list string) ([]PredWeight, error) {
+	weights := make([]PredWeight, n)
+	for i := range weights {
+		w := &weights[i]
+		w.LumaWeight = 1 << uint(t.LumaLog2WeightDenom)
+		w.ChromaWeight = [2]int{1 << uint(t.ChromaLog2WeightDenom), 1 << uint(t.ChromaLog2WeightDenom)}
+
+		err := readFlags(br, []flag{{&w.LumaWeightFlag, "LumaWeight" + list + "Flag"}})
+		if err != nil {
+			return nil, err
+		}
+		if w.LumaWeightFlag {
+			w.LumaWeight, err = readSe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read SpForSwitch")
+				return nil, fmt.Errorf("could not parse LumaWeight%s: %w", list, err)
 			}
-			header.SpForSwitch = b == 1
+			w.LumaOffset, err = readSe(br)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse LumaOffset%s: %w", list, err)
+			}
+		}
+		if !chroma {
+			continue
 		}
-		header.SliceQsDelta, err = readSe(nil)
+		err = readFlags(br, []flag{{&w.ChromaWeightFlag, "ChromaWeight" + list + "Flag"}})
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse SliceQsDelta")
+			return nil, err
+		}
+		if !w.ChromaWeightFlag {
+			continue
+		}
+		for j := 0; j < 2; j++ {
+			w.ChromaWeight[j], err = readSe(br)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse ChromaWeight%s: %w", list, err)
+			}
+			w.ChromaOffset[j], err = readSe(br)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse ChromaOffset%s: %w", list, err)
+			}
 		}
 	}
-	if pps.DeblockingFilterControlPresent {
-		header.DisableDeblockingFilter, err = readUe(nil)
+	return weights, nil
+}
+
+// readDecRefPicMarking parses a dec_ref_pic_marking, as given by section
+// 7.3.3.3, up to and including memory_management_control_operation equal to 0.
+// The operations of an adaptive marking are kept in the header in the order
+// they are to be performed.
+func readDecRefPicMarking(br *bits.BitReader, header *SliceHeader) error {
+	if header.IdrPic {
+		return readFlags(br, []flag{
+			{&header.NoOutputOfPriorPicsFlag, "NoOutputOfPriorPicsFlag"},
+			{&header.LongTermReferenceFlag, "LongTermReferenceFlag"},
+		})
+	}
+	err := readFlags(br, []flag{{&header.AdaptiveRefPicMarkingModeFlag, "AdaptiveRefPicMarkingModeFlag"}})
+	if err != nil || !header.AdaptiveRefPicMarkingModeFlag {
+		return err
+	}
+	var seen [7]bool
+	for {
+		var op MemoryManagementOp
+		op.MemoryManagementControlOperation, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse DisableDeblockingFilter")
+			return fmt.Errorf("could not parse MemoryManagementControlOperation: %w", err)
+		}
+		mmco := op.MemoryManagementControlOperation
+		switch {
+		case mmco == 0:
+			return nil
+		case mmco > 6:
+			return fmt.Errorf("invalid MemoryManagementControlOperation %d", mmco)
+		case (mmco == 4 || mmco == 5) && seen[mmco]:
+			// Section 7.4.3.3 allows at most one of each in a slice header.
+			return fmt.Errorf("repeated MemoryManagementControlOperation %d", mmco)
 		}
+		seen[mmco] = true
 
-		if header.DisableDeblockingFilter != 1 {
-			header.SliceAlphaC0OffsetDiv2, err = readSe(nil)
+		if mmco == 1 || mmco == 3 {
+			op.DifferenceOfPicNumsMinus1, err = readUe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not parse SliceAlphaC0OffsetDiv2")
+				return fmt.Errorf("could not parse DifferenceOfPicNumsMinus1: %w", err)
 			}
-
-			header.SliceBetaOffsetDiv2, err = readSe(nil)
+		}
+		if mmco == 2 {
+			op.LongTermPicNum, err = readUe(br)
+			if err != nil {
+				return fmt.Errorf("could not parse LongTermPicNum: %w", err)
+			}
+		}
+		if mmco == 3 || mmco == 6 {
+			op.LongTermFrameIdx, err = readUe(br)
+			if err != nil {
+				return fmt.Errorf("could not parse LongTermFrameIdx: %w", err)
+			}
+		}
+		if mmco == 4 {
+			op.MaxLongTermFrameIdxPlus1, err = readUe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not parse SliceBetaOffsetDiv2")
+				return fmt.Errorf("could not parse MaxLongTermFrameIdxPlus1: %w", err)
 			}
 		}
+		header.MemoryManagementOps = append(header.MemoryManagementOps, op)
 	}
-	if pps.NumSliceGroupsMinus1 > 0 && pps.SliceGroupMapType >= 3 && pps.SliceGroupMapType <= 5 {
-		b, err := br.ReadBits(int(math.Ceil(math.Log2(float64(pps.PicSizeInMapUnitsMinus1/pps.SliceGroupChangeRateMinus1 + 1)))))
+}
+
+// checkReferenceMarking returns an error if the slice with header h is
+// inconsistent with the first slice of its picture, with header first, in
+// whether the picture is a reference picture, nal_ref_idc being 0 for either
+// all or none of the slices of a picture (7.4.1), or in its decoded reference
+// picture marking, which is the same in all slices of a picture (7.4.3).
+func checkReferenceMarking(first, h *SliceHeader) error {
+	if h.IsReference() != first.IsReference() {
+		return fmt.Errorf("nal_ref_idc is %d, but %d for first slice of picture", h.NalRefIdc, first.NalRefIdc)
+	}
+	same := h.NoOutputOfPriorPicsFlag == first.NoOutputOfPriorPicsFlag &&
+		h.LongTermReferenceFlag == first.LongTermReferenceFlag &&
+		h.AdaptiveRefPicMarkingModeFlag == first.AdaptiveRefPicMarkingModeFlag &&
+		len(h.MemoryManagementOps) == len(first.MemoryManagementOps)
+	for i := 0; same && i < len(h.MemoryManagementOps); i++ {
+		same = h.MemoryManagementOps[i] == first.MemoryManagementOps[i]
+	}
+	if !same {
+		return errors.New("dec_ref_pic_marking differs from first slice of picture")
+	}
+	return nil
+}
+
+// newSliceContext is NewSliceContext with decoding temporaries allocated from
+// the arena of the picture being decoded. For a slice coded as data
+// partitions, nalUnit is partition A and parts holds partitions B and C.
+func newSliceContext(videoStream *VideoStream, nalUnit *NalUnit, rbsp []byte, showPacket bool, a *arena, parts *DataPartitions) (_ *SliceContext, err error) {
+	sps := videoStream.SPS
+	pps := videoStream.PPS
+	logger.Printf("debug: %s RBSP %d bytes %d bits == \n", nalUnit.Type, len(rbsp), len(rbsp)*8)
+	br := bits.NewBitReader(bytes.NewReader(rbsp))
+	defer func() { err = withBitPos(br, err) }()
+
+	header, err := readSliceHeader(br, nalUnit, sps, pps)
+	if err != nil {
+		return nil, err
+	}
+	if nalUnit.Type == NALTypeSlicePartA {
+		header.SliceID, err = readSliceID(br, sps, header)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read SliceGruopChangeCycle")
+			return nil, err
 		}
-		header.SliceGroupChangeCycle = int(b)
 	}
 
 	sliceContext := &SliceContext{
@@ -1354,12 +1253,27 @@ func NewSliceContext(videoStream *VideoStream, nalUnit *NalUnit, rbsp []byte, sh
 		SPS:     sps,
 		PPS:     pps,
 		Slice: &Slice{
-			Header: &header,
+			Header: header,
 		},
+		Partitions: parts,
+		arena:      a,
+	}
+	if nalUnit.Type.IsIDR() {
+		sliceContext.Flags |= FrameKeyframe
+	}
+	sliceContext.Flags |= header.Structure().flags()
+	if header.IsReference() {
+		sliceContext.Flags |= FrameReference
+	}
+	if a.first == nil {
+		a.first = header
+	} else if err := checkReferenceMarking(a.first, header); err != nil {
+		return nil, err
 	}
 	sliceContext.Slice.Data, err = NewSliceData(sliceContext, br)
+	sliceContext.arena = nil
 	if err != nil {
-		return nil, errors.Wrap(err, "could not create slice data")
+		return nil, fmt.Errorf("could not create slice data: %w", err)
 	}
 	if showPacket {
 		debugPacket("debug: Header", sliceContext.Slice.Header)
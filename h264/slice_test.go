@@ -1,6 +1,13 @@
 package h264
 
-import "testing"
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
 
 var subWidthCTests = []struct {
 	in   SPS
@@ -47,3 +54,564 @@ func TestSubHeightC(t *testing.T) {
 		}
 	}
 }
+
+// TestIsReference checks that SliceHeader.IsReference reflects nal_ref_idc.
+func TestIsReference(t *testing.T) {
+	for refIdc := 0; refIdc < 4; refIdc++ {
+		h := SliceHeader{NalRefIdc: refIdc}
+		if got, want := h.IsReference(), refIdc != 0; got != want {
+			t.Errorf("IsReference() with nal_ref_idc %d = %v, want %v", refIdc, got, want)
+		}
+	}
+}
+
+var chromaArrayTypeTests = []struct {
+	in                  SPS
+	want                int
+	mbWidthC, mbHeightC int
+}{
+	{SPS{ChromaFormat: 0}, 0, 0, 0},
+	{SPS{ChromaFormat: 1}, 1, 8, 8},
+	{SPS{ChromaFormat: 2}, 2, 8, 16},
+	{SPS{ChromaFormat: 3}, 3, 16, 16},
+	{SPS{ChromaFormat: 3, UseSeparateColorPlane: true}, 0, 0, 0},
+}
+
+// TestChromaArrayType tests that the correct ChromaArrayType, MbWidthC and
+// MbHeightC are returned given SPS inputs with various chroma formats.
+func TestChromaArrayType(t *testing.T) {
+	for _, tt := range chromaArrayTypeTests {
+		if got := tt.in.ChromaArrayType(); got != tt.want {
+			t.Errorf("ChromaArrayType(%#v) = %d, want %d", tt.in, got, tt.want)
+		}
+		if w, h := MbWidthC(&tt.in), MbHeightC(&tt.in); w != tt.mbWidthC || h != tt.mbHeightC {
+			t.Errorf("MbWidthC, MbHeightC(%#v) = %d, %d, want %d, %d", tt.in, w, h, tt.mbWidthC, tt.mbHeightC)
+		}
+	}
+}
+
+// seBits returns the Exp-Golomb code of the signed value v as a string of
+// binary digits.
+func seBits(v int) string {
+	if v > 0 {
+		return ueBits(2*v - 1)
+	}
+	return ueBits(-2 * v)
+}
+
+// TestReadSliceHeader checks parsing of slice headers of each slice type
+// against various SPS and PPS.
+func TestReadSliceHeader(t *testing.T) {
+	sps := &SPS{ChromaFormat: 1, FrameMbsOnly: true}
+	fieldSPS := &SPS{ChromaFormat: 1, PicOrderCountType: 1}
+	groupSPS := &SPS{ChromaFormat: 1, FrameMbsOnly: true, PicWidthInMbsMinus1: 1, PicHeightInMapUnitsMinus1: 1}
+
+	tests := []struct {
+		nalType NALType
+		refIdc  int
+		sps     *SPS
+		pps     *PPS
+		bits    string
+		want    *SliceHeader
+		wantErr bool
+	}{
+		{
+			nalType: NALTypeSliceIDRPicture,
+			refIdc:  3,
+			sps:     sps,
+			pps:     &PPS{DeblockingFilterControlPresent: true, NumRefIdxL0DefaultActiveMinus1: 2},
+			bits: ueBits(0) + ueBits(7) + ueBits(0) + "0000" + ueBits(1) + "0000" +
+				"0 1" + // no_output_of_prior_pics_flag, long_term_reference_flag.
+				seBits(-2) + ueBits(0) + seBits(1) + seBits(-1),
+			want: &SliceHeader{
+				NalRefIdc:               3,
+				IdrPic:                  true,
+				SliceType:               7,
+				IDRPicID:                1,
+				NumRefIdxL0ActiveMinus1: 2,
+				LongTermReferenceFlag:   true,
+				SliceQpDelta:            -2,
+				SliceAlphaC0OffsetDiv2:  1,
+				SliceBetaOffsetDiv2:     -1,
+				ChromaArrayType:         1,
+			},
+		},
+		{
+			nalType: NALTypeSliceNonIDRPicture,
+			refIdc:  2,
+			sps:     sps,
+			pps:     &PPS{EntropyCodingMode: 1, NumRefIdxL1DefaultActiveMinus1: 1},
+			bits: ueBits(5) + ueBits(0) + ueBits(0) + "0011" + "0110" +
+				"1" + ueBits(3) + // num_ref_idx_active_override_flag.
+				"1" + ueBits(0) + ueBits(4) + ueBits(2) + ueBits(1) + ueBits(3) + // ref_pic_list_modification.
+				"1" + ueBits(1) + ueBits(2) + ueBits(6) + ueBits(0) + ueBits(0) + // dec_ref_pic_marking.
+				ueBits(2) + seBits(3),
+			want: &SliceHeader{
+				NalRefIdc:                    2,
+				FirstMbInSlice:               5,
+				FrameNum:                     3,
+				PicOrderCntLsb:               6,
+				NumRefIdxActiveOverride:      true,
+				NumRefIdxL0ActiveMinus1:      3,
+				NumRefIdxL1ActiveMinus1:      1,
+				RefPicListModificationFlagL0: true,
+				RefPicListModificationL0: []RefPicListModification{
+					{ModificationOfPicNumsIdc: 0, AbsDiffPicNumMinus1: 4},
+					{ModificationOfPicNumsIdc: 2, LongTermPicNum: 1},
+				},
+				AdaptiveRefPicMarkingModeFlag: true,
+				MemoryManagementOps: []MemoryManagementOp{
+					{MemoryManagementControlOperation: 1, DifferenceOfPicNumsMinus1: 2},
+					{MemoryManagementControlOperation: 6},
+				},
+				CabacInit:       2,
+				SliceQpDelta:    3,
+				ChromaArrayType: 1,
+			},
+		},
+		{
+			nalType: NALTypeSliceNonIDRPicture,
+			sps:     fieldSPS,
+			pps:     &PPS{BottomFieldPicOrderInFramePresent: true, WeightedBipred: 1},
+			bits: ueBits(0) + ueBits(6) + ueBits(0) + "0001" + "1 1" + seBits(-1) +
+				"1" + // direct_spatial_mv_pred_flag.
+				"0" + // num_ref_idx_active_override_flag.
+				"0" + "1" + ueBits(1) + ueBits(0) + ueBits(3) + // ref_pic_list_modification.
+				ueBits(5) + ueBits(4) + // pred_weight_table.
+				"1" + seBits(2) + seBits(-1) + "0" +
+				"0" + "1" + seBits(1) + seBits(0) + seBits(-1) + seBits(1) +
+				seBits(0),
+			want: &SliceHeader{
+				SliceType:                    6,
+				FrameNum:                     1,
+				FieldPic:                     true,
+				BottomField:                  true,
+				DeltaPicOrderCnt:             []int{-1, 0},
+				DirectSpatialMvPred:          true,
+				RefPicListModificationFlagL1: true,
+				RefPicListModificationL1:     []RefPicListModification{{ModificationOfPicNumsIdc: 1}},
+				PredWeightTable: &PredWeightTable{
+					LumaLog2WeightDenom:   5,
+					ChromaLog2WeightDenom: 4,
+					L0:                    []PredWeight{{LumaWeightFlag: true, LumaWeight: 2, LumaOffset: -1, ChromaWeight: [2]int{16, 16}}},
+					L1:                    []PredWeight{{LumaWeight: 32, ChromaWeightFlag: true, ChromaWeight: [2]int{1, -1}, ChromaOffset: [2]int{0, 1}}},
+				},
+				ChromaArrayType: 1,
+			},
+		},
+		{
+			nalType: NALTypeSliceNonIDRPicture,
+			refIdc:  1,
+			sps:     sps,
+			pps:     &PPS{RedundantPicCntPresent: true},
+			bits: ueBits(0) + ueBits(3) + ueBits(0) + "0010" + "0000" + ueBits(1) +
+				"0" + "0" + "0" + // Override, modification and marking flags.
+				seBits(1) + "1" + seBits(-3),
+			want: &SliceHeader{
+				NalRefIdc:       1,
+				SliceType:       3,
+				FrameNum:        2,
+				RedundantPicCnt: 1,
+				SliceQpDelta:    1,
+				SpForSwitch:     true,
+				SliceQsDelta:    -3,
+				ChromaArrayType: 1,
+			},
+		},
+		{
+			nalType: NALTypeSliceNonIDRPicture,
+			refIdc:  1,
+			sps:     groupSPS,
+			pps:     &PPS{NumSliceGroupsMinus1: 1, SliceGroupMapType: 4, SliceGroupChangeRateMinus1: 1},
+			bits:    ueBits(0) + ueBits(4) + ueBits(0) + "0000" + "0000" + "0" + seBits(0) + seBits(2) + "10",
+			want: &SliceHeader{
+				NalRefIdc:             1,
+				SliceType:             4,
+				SliceQsDelta:          2,
+				SliceGroupChangeCycle: 2,
+				ChromaArrayType:       1,
+			},
+		},
+		{
+			nalType: NALTypeSliceNonIDRPicture,
+			sps:     sps,
+			pps:     &PPS{},
+			bits:    ueBits(0) + ueBits(10) + ueBits(0),
+			wantErr: true,
+		},
+		{
+			nalType: NALTypeSliceNonIDRPicture,
+			refIdc:  1,
+			sps:     sps,
+			pps:     &PPS{},
+			bits:    ueBits(0) + ueBits(0) + ueBits(0) + "0000" + "0000" + "0" + "1" + ueBits(7),
+			wantErr: true,
+		},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.bits)))
+		got, err := readSliceHeader(br, &NalUnit{Type: test.nalType, RefIdc: test.refIdc}, test.sps, test.pps)
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, got, test.want)
+		}
+	}
+}
+
+// TestReadRefPicListModification checks parsing of the operations of
+// ref_pic_list_modification for both AVC and MVC slices.
+func TestReadRefPicListModification(t *testing.T) {
+	tests := []struct {
+		bits            string
+		numRefIdxActive int
+		mvc             bool
+		want            []RefPicListModification
+		wantErr         bool
+	}{
+		{bits: ueBits(3), numRefIdxActive: 1},
+		{
+			bits:            ueBits(1) + ueBits(2) + ueBits(0) + ueBits(0) + ueBits(2) + ueBits(5) + ueBits(3),
+			numRefIdxActive: 3,
+			want: []RefPicListModification{
+				{ModificationOfPicNumsIdc: 1, AbsDiffPicNumMinus1: 2},
+				{ModificationOfPicNumsIdc: 0},
+				{ModificationOfPicNumsIdc: 2, LongTermPicNum: 5},
+			},
+		},
+		{
+			bits:            ueBits(5) + ueBits(1) + ueBits(4) + ueBits(0) + ueBits(3),
+			numRefIdxActive: 2,
+			mvc:             true,
+			want: []RefPicListModification{
+				{ModificationOfPicNumsIdc: 5, AbsDiffViewIdxMinus1: 1},
+				{ModificationOfPicNumsIdc: 4},
+			},
+		},
+		{bits: ueBits(4) + ueBits(0) + ueBits(3), numRefIdxActive: 1, wantErr: true},
+		{bits: ueBits(0) + ueBits(0) + ueBits(0) + ueBits(0) + ueBits(3), numRefIdxActive: 1, wantErr: true},
+		{bits: ueBits(0) + ueBits(0), numRefIdxActive: 2, wantErr: true},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.bits)))
+		got, err := readRefPicListModification(br, test.numRefIdxActive, test.mvc)
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, got, test.want)
+		}
+	}
+}
+
+func TestReadPredWeightTable(t *testing.T) {
+	tests := []struct {
+		bits      string
+		header    SliceHeader
+		sliceType string
+		want      *PredWeightTable
+		wantErr   bool
+	}{
+		{
+			// P slice with 2 references, the second having luma and chroma weights.
+			bits:      ueBits(6) + ueBits(2) + "0" + "0" + "1" + seBits(-3) + seBits(4) + "1" + seBits(1) + seBits(-1) + seBits(2) + seBits(0),
+			header:    SliceHeader{ChromaArrayType: 1, NumRefIdxL0ActiveMinus1: 1},
+			sliceType: "P",
+			want: &PredWeightTable{
+				LumaLog2WeightDenom:   6,
+				ChromaLog2WeightDenom: 2,
+				L0: []PredWeight{
+					{LumaWeight: 64, ChromaWeight: [2]int{4, 4}},
+					{LumaWeightFlag: true, LumaWeight: -3, LumaOffset: 4, ChromaWeightFlag: true, ChromaWeight: [2]int{1, 2}, ChromaOffset: [2]int{-1, 0}},
+				},
+			},
+		},
+		{
+			// Monochrome B slice, having no chroma weights.
+			bits:      ueBits(1) + "1" + seBits(3) + seBits(-2) + "0" + "1" + seBits(0) + seBits(5),
+			header:    SliceHeader{NumRefIdxL1ActiveMinus1: 1},
+			sliceType: "B",
+			want: &PredWeightTable{
+				LumaLog2WeightDenom: 1,
+				L0:                  []PredWeight{{LumaWeightFlag: true, LumaWeight: 3, LumaOffset: -2, ChromaWeight: [2]int{1, 1}}},
+				L1: []PredWeight{
+					{LumaWeight: 2, ChromaWeight: [2]int{1, 1}},
+					{LumaWeightFlag: true, LumaWeight: 0, LumaOffset: 5, ChromaWeight: [2]int{1, 1}},
+				},
+			},
+		},
+		{bits: ueBits(8), sliceType: "P", wantErr: true},
+		{bits: ueBits(0) + ueBits(8), header: SliceHeader{ChromaArrayType: 2}, sliceType: "P", wantErr: true},
+		{bits: ueBits(0) + ueBits(0) + "1" + "1", header: SliceHeader{ChromaArrayType: 1}, sliceType: "P", wantErr: true},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.bits)))
+		got, err := readPredWeightTable(br, &test.header, test.sliceType)
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, got, test.want)
+		}
+	}
+}
+
+func TestNewSliceDataEnd(t *testing.T) {
+	// A 2x2 macroblock picture, and an MBAFF frame of 1x2 macroblock pairs.
+	sps := &SPS{PicWidthInMbsMinus1: 1, PicHeightInMapUnitsMinus1: 1, FrameMbsOnly: true}
+	mbaffSPS := &SPS{PicHeightInMapUnitsMinus1: 1, MBAdaptiveFrameField: true}
+
+	tests := []struct {
+		bits     string
+		mbaff    bool
+		firstMb  int
+		wantSkip int
+		wantErr  bool
+	}{
+		{bits: ueBits(4) + "1", wantSkip: 4},
+		{bits: ueBits(3) + "1", firstMb: 1, wantSkip: 3},
+		{bits: ueBits(5) + "1", wantErr: true},
+		{bits: ueBits(4) + "1", firstMb: 4, wantErr: true},
+		{bits: ueBits(4) + ueBits(0) + "1", wantErr: true},
+		{bits: ueBits(4) + "1", mbaff: true, wantSkip: 4},
+		{bits: ueBits(2) + "1", mbaff: true, firstMb: 1, wantSkip: 2},
+		{bits: ueBits(3) + "1", mbaff: true, wantErr: true},
+		{bits: ueBits(3) + "1", mbaff: true, firstMb: 1, wantErr: true},
+	}
+
+	for i, test := range tests {
+		rbsp := binToSlice(test.bits)
+		ctx := &SliceContext{
+			NalUnit: &NalUnit{rbsp: rbsp},
+			SPS:     sps,
+			PPS:     &PPS{},
+			Slice:   &Slice{Header: &SliceHeader{SliceType: 0, FirstMbInSlice: test.firstMb}},
+			arena:   &arena{},
+		}
+		if test.mbaff {
+			ctx.SPS = mbaffSPS
+		}
+		got, err := NewSliceData(ctx, bits.NewBitReader(bytes.NewReader(rbsp)))
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if err == nil && got.MbSkipRun != test.wantSkip {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got.MbSkipRun, test.wantSkip)
+		}
+	}
+}
+
+func TestReadEndOfSliceFlag(t *testing.T) {
+	tests := []struct {
+		codIOffset int
+		want       bool
+		wantRange  int
+	}{
+		{codIOffset: 509, want: true, wantRange: 508},
+		{codIOffset: 508, want: true, wantRange: 508},
+		{codIOffset: 507, want: false, wantRange: 508},
+	}
+
+	for i, test := range tests {
+		d := &SliceData{cabac: &cabacDecoder{codIRange: 510, codIOffset: test.codIOffset}}
+		got, err := d.readEndOfSliceFlag()
+		if err != nil {
+			t.Errorf("unexpected error for test: %d: %v", i, err)
+			continue
+		}
+		if got != test.want || d.cabac.codIRange != test.wantRange {
+			t.Errorf("did not get expected result for test: %d\nGot: %v, %d\nWant: %v, %d", i, got, d.cabac.codIRange, test.want, test.wantRange)
+		}
+	}
+}
+
+// TestPairFieldDecoding checks the mb_field_decoding_flag of the pair of a
+// skipped top macroblock of an MBAFF frame, decoded ahead from the bottom
+// macroblock, and that the decoder and macroblock state are restored for the
+// bottom macroblock to be decoded.
+func TestPairFieldDecoding(t *testing.T) {
+	tests := []struct {
+		skip  int // mb_skip_flag of the bottom macroblock.
+		field int // mb_field_decoding_flag of the bottom macroblock, if not skipped.
+		want  bool
+	}{
+		{skip: 1, want: false},
+		{skip: 0, field: 0, want: false},
+		{skip: 0, field: 1, want: true},
+	}
+
+	for i, test := range tests {
+		// The pair has no neighbours, so its inferred mb_field_decoding_flag
+		// is 0 and both ctxIdxInc are 0.
+		e := newCABACEncoder(t, "P", 0, 26)
+		e.encodeDecision(11, test.skip)
+		if test.skip == 0 {
+			e.encodeDecision(70, test.field)
+		}
+		e.encodeTerminate(1)
+		dec, err := newCABACDecoder(e.reader(), "P", 0, 26)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from newCABACDecoder for test: %d", err, i)
+		}
+		d := &SliceData{cabac: dec}
+		mbs := newMbState(1, 2)
+		mbs.mbaff = true
+		mbs.beginMb(0, mbs.startSlice(), mbSkipped)
+		s := dec.Save()
+
+		got, err := d.pairFieldDecoding(mbs, 0, 11)
+		if err != nil {
+			t.Fatalf("did not expect error: %v for test: %d", err, i)
+		}
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+		if dec.Save() != s {
+			t.Errorf("did not get expected decoder state restored for test: %d", i)
+		}
+		if mbs.sliceNum[1] != -1 || mbs.sliceMbs != 1 || mbs.lastMbAddr != 0 {
+			t.Errorf("did not get expected macroblock state restored for test: %d\nGot: %d, %d, %d\nWant: -1, 1, 0", i, mbs.sliceNum[1], mbs.sliceMbs, mbs.lastMbAddr)
+		}
+		if b, err := dec.decodeDecision(11); err != nil || b != test.skip {
+			t.Errorf("did not get expected mb_skip_flag decoded again for test: %d\nGot: %d, %v\nWant: %d", i, b, err, test.skip)
+		}
+	}
+}
+
+func TestReadDecRefPicMarking(t *testing.T) {
+	tests := []struct {
+		bits    string
+		idr     bool
+		want    SliceHeader
+		wantErr bool
+	}{
+		{bits: "01", idr: true, want: SliceHeader{IdrPic: true, LongTermReferenceFlag: true}},
+		{bits: "0"},
+		{
+			bits: "1" + ueBits(1) + ueBits(0) + ueBits(2) + ueBits(7) + ueBits(3) + ueBits(4) + ueBits(1) +
+				ueBits(4) + ueBits(3) + ueBits(5) + ueBits(6) + ueBits(2) + ueBits(0),
+			want: SliceHeader{
+				AdaptiveRefPicMarkingModeFlag: true,
+				MemoryManagementOps: []MemoryManagementOp{
+					{MemoryManagementControlOperation: 1},
+					{MemoryManagementControlOperation: 2, LongTermPicNum: 7},
+					{MemoryManagementControlOperation: 3, DifferenceOfPicNumsMinus1: 4, LongTermFrameIdx: 1},
+					{MemoryManagementControlOperation: 4, MaxLongTermFrameIdxPlus1: 3},
+					{MemoryManagementControlOperation: 5},
+					{MemoryManagementControlOperation: 6, LongTermFrameIdx: 2},
+				},
+			},
+		},
+		{bits: "1" + ueBits(7) + ueBits(0), wantErr: true},
+		{bits: "1" + ueBits(5) + ueBits(5) + ueBits(0), wantErr: true},
+		{bits: "1" + ueBits(4) + ueBits(1) + ueBits(4) + ueBits(2) + ueBits(0), wantErr: true},
+		{bits: "1" + ueBits(1), wantErr: true},
+	}
+
+	for i, test := range tests {
+		br := bits.NewBitReader(bytes.NewReader(binToSlice(test.bits)))
+		got := SliceHeader{IdrPic: test.idr}
+		err := readDecRefPicMarking(br, &got)
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, got, test.want)
+		}
+	}
+}
+
+// skipSlice returns a P slice NAL unit with the given nal_ref_idc and 4 bit
+// frame_num, skipping the single macroblock of the picture given by testSPS
+// and testPPS.
+func skipSlice(refIdc, frameNum int) []byte {
+	marking := "" // dec_ref_pic_marking, present for reference pictures.
+	if refIdc != 0 {
+		marking = "0"
+	}
+	return append([]byte{byte(refIdc<<5) | byte(NALTypeSliceNonIDRPicture)}, binToSlice(
+		ueBits(0)+"00110 1"+fmt.Sprintf("%04b", frameNum)+ // first_mb_in_slice, slice_type, pic_parameter_set_id, frame_num.
+			"0 0"+marking+ // num_ref_idx_active_override_flag, ref_pic_list_modification_flag_l0.
+			"1 010"+ // slice_qp_delta, disable_deblocking_filter_idc.
+			"010"+ // mb_skip_run.
+			"1", // rbsp_stop_one_bit.
+	)...)
+}
+
+// TestReferenceFlag checks that slices of reference pictures, and only those,
+// are flagged FrameReference, and that an IDR picture with nal_ref_idc 0 is
+// rejected.
+func TestReferenceFlag(t *testing.T) {
+	idr := append([]byte(nil), testIDR...)
+	idr[0] &^= 0x60 // nal_ref_idc 0.
+
+	tests := []struct {
+		nal     []byte
+		want    bool
+		wantErr bool
+	}{
+		{nal: skipSlice(2, 1), want: true},
+		{nal: skipSlice(1, 1), want: true},
+		{nal: skipSlice(0, 1)},
+		{nal: idr, wantErr: true},
+	}
+
+	for i, test := range tests {
+		r, err := NewH264Reader(bytes.NewReader(annexB(test.nal)), WithSPS(testSPS), WithPPS(testPPS))
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader", err)
+		}
+		err = r.Start()
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		flags := r.VideoStreams[0].Slices[0].Flags
+		if flags.Has(FrameReference) != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant reference: %v", i, flags, test.want)
+		}
+	}
+}
+
+// TestCheckReferenceMarking checks that slices of a picture must agree with
+// its first slice in whether it is a reference picture and in its decoded
+// reference picture marking.
+func TestCheckReferenceMarking(t *testing.T) {
+	op := MemoryManagementOp{MemoryManagementControlOperation: 1, DifferenceOfPicNumsMinus1: 2}
+	first := &SliceHeader{NalRefIdc: 2, AdaptiveRefPicMarkingModeFlag: true, MemoryManagementOps: []MemoryManagementOp{op}}
+
+	tests := []struct {
+		first   *SliceHeader
+		h       *SliceHeader
+		wantErr bool
+	}{
+		{first: &SliceHeader{}, h: &SliceHeader{}},
+		{first: &SliceHeader{NalRefIdc: 1}, h: &SliceHeader{NalRefIdc: 3}},
+		{first: &SliceHeader{NalRefIdc: 1}, h: &SliceHeader{}, wantErr: true},
+		{first: &SliceHeader{}, h: &SliceHeader{NalRefIdc: 1}, wantErr: true},
+		{first: first, h: &SliceHeader{NalRefIdc: 3, AdaptiveRefPicMarkingModeFlag: true, MemoryManagementOps: []MemoryManagementOp{op}}},
+		{first: first, h: &SliceHeader{NalRefIdc: 2}, wantErr: true},
+		{first: first, h: &SliceHeader{NalRefIdc: 2, AdaptiveRefPicMarkingModeFlag: true}, wantErr: true},
+		{first: first, h: &SliceHeader{NalRefIdc: 2, AdaptiveRefPicMarkingModeFlag: true, MemoryManagementOps: []MemoryManagementOp{{MemoryManagementControlOperation: 1}}}, wantErr: true},
+		{first: &SliceHeader{NalRefIdc: 3, IdrPic: true}, h: &SliceHeader{NalRefIdc: 3, IdrPic: true, LongTermReferenceFlag: true}, wantErr: true},
+	}
+
+	for i, test := range tests {
+		err := checkReferenceMarking(test.first, test.h)
+		if (err != nil) != test.wantErr {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, test.wantErr)
+		}
+	}
+}
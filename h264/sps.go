@@ -2,26 +2,31 @@ package h264
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/ausocean/h264decode/h264/bits"
-	"github.com/pkg/errors"
 )
 
+// maxBitDepthMinus8 is the maximum value of bit_depth_luma_minus8 and
+// bit_depth_chroma_minus8 (see section 7.4.2.1.1).
+const maxBitDepthMinus8 = 6
+
 // Specification Page 43 7.3.2.1.1
 // Range is always inclusive
 // XRange is always exclusive
 type SPS struct {
 	// 8 bits
-	Profile int
+	ProfileIDC int
 	// 6 bits
 	Constraint0, Constraint1 int
 	Constraint2, Constraint3 int
 	Constraint4, Constraint5 int
 	// 2 bit reserved 0 bits
 	// 8 bits
-	Level int
+	LevelIDC int
 	// Range 0 - 31 ; 6 bits
 	ID                         int
 	ChromaFormat               int
@@ -32,6 +37,8 @@ type SPS struct {
 	SeqScalingMatrixPresent    bool
 	// Delta is (0-12)-1 ; 4 bits
 	SeqScalingList []bool // se
+	// Resolved scaling matrix, flat if none present.
+	ScalingMatrix ScalingMatrix
 	// Range 0 - 12; 4 bits
 	Log2MaxFrameNumMinus4 int
 	// Range 0 - 2; 2 bits
@@ -53,210 +60,49 @@ type SPS struct {
 	// Page 77
 	PicWidthInMbsMinus1 int
 	// Page 77
-	PicHeightInMapUnitsMinus1          int
-	FrameMbsOnly                       bool
-	MBAdaptiveFrameField               bool
-	Direct8x8Inference                 bool
-	FrameCropping                      bool
-	FrameCropLeftOffset                int
-	FrameCropRightOffset               int
-	FrameCropTopOffset                 int
-	FrameCropBottomOffset              int
-	VuiParametersPresent               bool
-	VuiParameters                      []int
-	AspectRatioInfoPresent             bool
-	AspectRatio                        int
-	SarWidth                           int
-	SarHeight                          int
-	OverscanInfoPresent                bool
-	OverscanAppropriate                bool
-	VideoSignalTypePresent             bool
-	VideoFormat                        int
-	VideoFullRange                     bool
-	ColorDescriptionPresent            bool
-	ColorPrimaries                     int
-	TransferCharacteristics            int
-	MatrixCoefficients                 int
-	ChromaLocInfoPresent               bool
-	ChromaSampleLocTypeTopField        int
-	ChromaSampleLocTypeBottomField     int
-	CpbCntMinus1                       int
-	BitRateScale                       int
-	CpbSizeScale                       int
-	BitRateValueMinus1                 []int
-	Cbr                                []bool
-	InitialCpbRemovalDelayLengthMinus1 int
-	CpbRemovalDelayLengthMinus1        int
-	CpbSizeValueMinus1                 []int
-	DpbOutputDelayLengthMinus1         int
-	TimeOffsetLength                   int
-	TimingInfoPresent                  bool
-	NumUnitsInTick                     int
-	TimeScale                          int
-	NalHrdParametersPresent            bool
-	FixedFrameRate                     bool
-	VclHrdParametersPresent            bool
-	LowHrdDelay                        bool
-	PicStructPresent                   bool
-	BitstreamRestriction               bool
-	MotionVectorsOverPicBoundaries     bool
-	MaxBytesPerPicDenom                int
-	MaxBitsPerMbDenom                  int
-	Log2MaxMvLengthHorizontal          int
-	Log2MaxMvLengthVertical            int
-	MaxDecFrameBuffering               int
-	MaxNumReorderFrames                int
+	PicHeightInMapUnitsMinus1 int
+	FrameMbsOnly              bool
+	MBAdaptiveFrameField      bool
+	Direct8x8Inference        bool
+	FrameCropping             bool
+	FrameCropLeftOffset       int
+	FrameCropRightOffset      int
+	FrameCropTopOffset        int
+	FrameCropBottomOffset     int
+	VuiParametersPresent      bool
+	VUI                       *VUIParameters // nil if not present.
 }
 
-var (
-	DefaultScalingMatrix4x4 = [][]int{
-		{6, 13, 20, 28, 13, 20, 28, 32, 20, 28, 32, 37, 28, 32, 37, 42},
-		{10, 14, 20, 24, 14, 20, 24, 27, 20, 24, 27, 30, 24, 27, 30, 34},
-	}
-
-	DefaultScalingMatrix8x8 = [][]int{
-		{6, 10, 13, 16, 18, 23, 25, 27,
-			10, 11, 16, 18, 23, 25, 27, 29,
-			13, 16, 18, 23, 25, 27, 29, 31,
-			16, 18, 23, 25, 27, 29, 31, 33,
-			18, 23, 25, 27, 29, 31, 33, 36,
-			23, 25, 27, 29, 31, 33, 36, 38,
-			25, 27, 29, 31, 33, 36, 38, 40,
-			27, 29, 31, 33, 36, 38, 40, 42},
-		{9, 13, 15, 17, 19, 21, 22, 24,
-			13, 13, 17, 19, 21, 22, 24, 25,
-			15, 17, 19, 21, 22, 24, 25, 27,
-			17, 19, 21, 22, 24, 25, 27, 28,
-			19, 21, 22, 24, 25, 27, 28, 30,
-			21, 22, 24, 25, 27, 28, 30, 32,
-			22, 24, 25, 27, 28, 30, 32, 33,
-			24, 25, 27, 28, 30, 32, 33, 35},
-	}
-	Default4x4IntraList = []int{6, 13, 13, 20, 20, 20, 38, 38, 38, 38, 32, 32, 32, 37, 37, 42}
-	Default4x4InterList = []int{10, 14, 14, 20, 20, 20, 24, 24, 24, 24, 27, 27, 27, 30, 30, 34}
-	Default8x8IntraList = []int{
-		6, 10, 10, 13, 11, 13, 16, 16, 16, 16, 18, 18, 18, 18, 18, 23,
-		23, 23, 23, 23, 23, 25, 25, 25, 25, 25, 25, 25, 27, 27, 27, 27,
-		27, 27, 27, 27, 29, 29, 29, 29, 29, 29, 29, 31, 31, 31, 31, 31,
-		31, 33, 33, 33, 33, 33, 36, 36, 36, 36, 38, 38, 38, 40, 40, 42}
-	Default8x8InterList = []int{
-		9, 13, 13, 15, 13, 15, 17, 17, 17, 17, 19, 19, 19, 19, 19, 21,
-		21, 21, 21, 21, 21, 22, 22, 22, 22, 22, 22, 22, 24, 24, 24, 24,
-		24, 24, 24, 24, 25, 25, 25, 25, 25, 25, 25, 27, 27, 27, 27, 27,
-		27, 28, 28, 28, 28, 28, 30, 30, 30, 30, 32, 32, 32, 33, 33, 35}
-	ScalingList4x4 = map[int][]int{
-		0:  Default4x4IntraList,
-		1:  Default4x4IntraList,
-		2:  Default4x4IntraList,
-		3:  Default4x4InterList,
-		4:  Default4x4InterList,
-		5:  Default4x4InterList,
-		6:  Default8x8IntraList,
-		7:  Default8x8InterList,
-		8:  Default8x8IntraList,
-		9:  Default8x8InterList,
-		10: Default8x8IntraList,
-		11: Default8x8InterList,
-	}
-	ScalingList8x8 = ScalingList4x4
-)
-
-func isInList(l []int, term int) bool {
-	for _, m := range l {
-		if m == term {
-			return true
-		}
-	}
-	return false
-}
 func debugPacket(name string, packet interface{}) {
 	logger.Printf("debug: %s packet\n", name)
 	for _, line := range strings.Split(fmt.Sprintf("%+v", packet), " ") {
 		logger.Printf("debug: \t%#v\n", line)
 	}
 }
-func scalingList(b *bits.BitReader, scalingList []int, sizeOfScalingList int, defaultScalingMatrix []int) error {
-	lastScale := 8
-	nextScale := 8
-	for i := 0; i < sizeOfScalingList; i++ {
-		if nextScale != 0 {
-			deltaScale, err := readSe(nil)
-			if err != nil {
-				return errors.Wrap(err, "could not parse deltaScale")
-			}
-			nextScale = (lastScale + deltaScale + 256) % 256
-			if i == 0 && nextScale == 0 {
-				// Scaling list should use the default list for this point in the matrix
-				_ = defaultScalingMatrix
-			}
-		}
-		if nextScale == 0 {
-			scalingList[i] = lastScale
-		} else {
-			scalingList[i] = nextScale
-		}
-		lastScale = scalingList[i]
-	}
-	return nil
-}
-func NewSPS(rbsp []byte, showPacket bool) (*SPS, error) {
+func NewSPS(rbsp []byte, showPacket bool) (_ *SPS, err error) {
 	logger.Printf("debug: SPS RBSP %d bytes %d bits\n", len(rbsp), len(rbsp)*8)
-	logger.Printf("debug: \t%#v\n", rbsp[0:8])
-	sps := SPS{}
+	logger.Printf("debug: \t%#v\n", rbsp)
 	br := bits.NewBitReader(bytes.NewReader(rbsp))
-	var err error
-	hrdParameters := func() error {
-		sps.CpbCntMinus1, err = readUe(nil)
-		if err != nil {
-			return errors.Wrap(err, "could not parse CpbCntMinus1")
-		}
+	defer func() { err = withBitPos(br, err) }()
 
-		err := readFields(br, []field{
-			{&sps.BitRateScale, "BitRateScale", 4},
-			{&sps.CpbSizeScale, "CpbSizeScale", 4},
-		})
-		if err != nil {
-			return err
-		}
-
-		// SchedSelIdx E1.2
-		for sseli := 0; sseli <= sps.CpbCntMinus1; sseli++ {
-			ue, err := readUe(nil)
-			if err != nil {
-				return errors.Wrap(err, "could not parse BitRateValueMinus1")
-			}
-			sps.BitRateValueMinus1 = append(sps.BitRateValueMinus1, ue)
-
-			ue, err = readUe(nil)
-			if err != nil {
-				return errors.Wrap(err, "could not parse CpbSizeValueMinus1")
-			}
-			sps.CpbSizeValueMinus1 = append(sps.CpbSizeValueMinus1, ue)
-
-			if v, _ := br.ReadBits(1); v == 1 {
-				sps.Cbr = append(sps.Cbr, true)
-			} else {
-				sps.Cbr = append(sps.Cbr, false)
-			}
-
-			err = readFields(br,
-				[]field{
-					{&sps.InitialCpbRemovalDelayLengthMinus1, "InitialCpbRemovalDelayLengthMinus1", 5},
-					{&sps.CpbRemovalDelayLengthMinus1, "CpbRemovalDelayLengthMinus1", 5},
-					{&sps.DpbOutputDelayLengthMinus1, "DpbOutputDelayLengthMinus1", 5},
-					{&sps.TimeOffsetLength, "TimeOffsetLength", 5},
-				},
-			)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+	sps, err := readSPSData(br)
+	if err != nil {
+		return nil, err
 	}
+	if showPacket {
+		debugPacket("SPS", sps)
+	}
+	return sps, nil
+}
 
+// readSPSData reads seq_parameter_set_data as specified in section 7.3.2.1.1,
+// being the whole of an SPS before its trailing bits and the beginning of a
+// subset SPS.
+func readSPSData(br *bits.BitReader) (_ *SPS, err error) {
+	sps := SPS{ScalingMatrix: FlatScalingMatrix()}
 	err = readFields(br,
 		[]field{
-			{&sps.Profile, "ProfileIDC", 8},
+			{&sps.ProfileIDC, "ProfileIDC", 8},
 			{&sps.Constraint0, "Constraint0", 1},
 			{&sps.Constraint1, "Constraint1", 1},
 			{&sps.Constraint2, "Constraint2", 1},
@@ -268,91 +114,76 @@ func NewSPS(rbsp []byte, showPacket bool) (*SPS, error) {
 
 	_, err = br.ReadBits(2)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read ReservedZeroBits")
+		return nil, fmt.Errorf("could not read ReservedZeroBits: %w", err)
 	}
 
 	b, err := br.ReadBits(8)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read Level")
+		return nil, fmt.Errorf("could not read LevelIDC: %w", err)
 	}
-	sps.Level = int(b)
+	sps.LevelIDC = int(b)
 
 	// sps.ID = b.NextField("SPSID", 6) // proper
-	sps.ID, err = readUe(nil)
+	sps.ID, err = readUe(br)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse ID")
+		return nil, fmt.Errorf("could not parse ID: %w", err)
 	}
 
-	sps.ChromaFormat, err = readUe(nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not parse ChromaFormat")
-	}
+	// When chroma_format_idc is not present it is inferred to be 1 (4:2:0).
+	sps.ChromaFormat = chroma420
 
-	// This should be done only for certain ProfileIDC:
-	isProfileIDC := []int{100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135}
 	// SpecialProfileCase1
-	if isInList(isProfileIDC, sps.Profile) {
+	if hasChromaFormat(sps.ProfileIDC) {
+		sps.ChromaFormat, err = readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse ChromaFormat: %w", err)
+		}
+		if sps.ChromaFormat > chroma444 {
+			return nil, fmt.Errorf("chroma_format_idc %d out of range", sps.ChromaFormat)
+		}
+
 		if sps.ChromaFormat == chroma444 {
 			// TODO: should probably deal with error here.
 			b, err := br.ReadBits(1)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not read UseSeparateColorPlaneFlag")
+				return nil, fmt.Errorf("could not read UseSeparateColorPlaneFlag: %w", err)
 			}
 			sps.UseSeparateColorPlane = b == 1
 		}
 
-		sps.BitDepthLumaMinus8, err = readUe(nil)
+		sps.BitDepthLumaMinus8, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse BitDepthLumaMinus8")
+			return nil, fmt.Errorf("could not parse BitDepthLumaMinus8: %w", err)
 		}
 
-		sps.BitDepthChromaMinus8, err = readUe(nil)
+		sps.BitDepthChromaMinus8, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse BitDepthChromaMinus8")
+			return nil, fmt.Errorf("could not parse BitDepthChromaMinus8: %w", err)
+		}
+		if sps.BitDepthLumaMinus8 > maxBitDepthMinus8 || sps.BitDepthChromaMinus8 > maxBitDepthMinus8 {
+			return nil, fmt.Errorf("bit depth %d/%d out of range", sps.BitDepthLumaMinus8+8, sps.BitDepthChromaMinus8+8)
 		}
 
 		b, err := br.ReadBits(1)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read QPrimeYZeroTransformBypass")
+			return nil, fmt.Errorf("could not read QPrimeYZeroTransformBypass: %w", err)
 		}
 		sps.QPrimeYZeroTransformBypass = b == 1
 
 		b, err = br.ReadBits(1)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read SeqScalingMatrixPresent")
+			return nil, fmt.Errorf("could not read SeqScalingMatrixPresent: %w", err)
 		}
 		sps.SeqScalingMatrixPresent = b == 1
 
 		if sps.SeqScalingMatrixPresent {
-			max := 12
+			n := 12
 			if sps.ChromaFormat != chroma444 {
-				max = 8
+				n = 8
 			}
-			logger.Printf("debug: \tbuilding Scaling matrix for %d elements\n", max)
-			for i := 0; i < max; i++ {
-				b, err := br.ReadBits(1)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not read SeqScalingList")
-				}
-				sps.SeqScalingList = append(sps.SeqScalingList, b == 1)
-
-				if sps.SeqScalingList[i] {
-					if i < 6 {
-						scalingList(
-							br,
-							ScalingList4x4[i],
-							16,
-							DefaultScalingMatrix4x4[i])
-						// 4x4: Page 75 bottom
-					} else {
-						// 8x8 Page 76 top
-						scalingList(
-							br,
-							ScalingList8x8[i],
-							64,
-							DefaultScalingMatrix8x8[i-6])
-					}
-				}
+			sps.ScalingMatrix, sps.SeqScalingList, err = parseScalingMatrix(br, n, nil)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse SPS scaling matrix: %w", err)
 			}
 		}
 	} // End SpecialProfileCase1
@@ -360,47 +191,47 @@ func NewSPS(rbsp []byte, showPacket bool) (*SPS, error) {
 	// showSPS()
 	// return sps
 	// Possibly wrong due to no scaling list being built
-	sps.Log2MaxFrameNumMinus4, err = readUe(nil)
+	sps.Log2MaxFrameNumMinus4, err = readUe(br)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse Log2MaxFrameNumMinus4")
+		return nil, fmt.Errorf("could not parse Log2MaxFrameNumMinus4: %w", err)
 	}
 
-	sps.PicOrderCountType, err = readUe(nil)
+	sps.PicOrderCountType, err = readUe(br)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse PicOrderCountType")
+		return nil, fmt.Errorf("could not parse PicOrderCountType: %w", err)
 	}
 
 	if sps.PicOrderCountType == 0 {
-		sps.Log2MaxPicOrderCntLSBMin4, err = readUe(nil)
+		sps.Log2MaxPicOrderCntLSBMin4, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse Log2MaxPicOrderCntLSBMin4")
+			return nil, fmt.Errorf("could not parse Log2MaxPicOrderCntLSBMin4: %w", err)
 		}
 	} else if sps.PicOrderCountType == 1 {
 		b, err = br.ReadBits(1)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read DeltaPicOrderAlwaysZero")
+			return nil, fmt.Errorf("could not read DeltaPicOrderAlwaysZero: %w", err)
 		}
 		sps.DeltaPicOrderAlwaysZero = b == 1
 
-		sps.OffsetForNonRefPic, err = readSe(nil)
+		sps.OffsetForNonRefPic, err = readSe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse OffsetForNonRefPic")
+			return nil, fmt.Errorf("could not parse OffsetForNonRefPic: %w", err)
 		}
 
-		sps.OffsetForTopToBottomField, err = readSe(nil)
+		sps.OffsetForTopToBottomField, err = readSe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse OffsetForTopToBottomField")
+			return nil, fmt.Errorf("could not parse OffsetForTopToBottomField: %w", err)
 		}
 
-		sps.NumRefFramesInPicOrderCntCycle, err = readUe(nil)
+		sps.NumRefFramesInPicOrderCntCycle, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse NumRefFramesInPicOrderCntCycle")
+			return nil, fmt.Errorf("could not parse NumRefFramesInPicOrderCntCycle: %w", err)
 		}
 
 		for i := 0; i < sps.NumRefFramesInPicOrderCntCycle; i++ {
-			se, err := readSe(nil)
+			se, err := readSe(br)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not parse OffsetForRefFrameList")
+				return nil, fmt.Errorf("could not parse OffsetForRefFrameList: %w", err)
 			}
 			sps.OffsetForRefFrameList = append(
 				sps.OffsetForRefFrameList,
@@ -409,37 +240,37 @@ func NewSPS(rbsp []byte, showPacket bool) (*SPS, error) {
 
 	}
 
-	sps.MaxNumRefFrames, err = readUe(nil)
+	sps.MaxNumRefFrames, err = readUe(br)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse MaxNumRefFrames")
+		return nil, fmt.Errorf("could not parse MaxNumRefFrames: %w", err)
 	}
 
 	b, err = br.ReadBits(1)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read GapsInFrameNumValueAllowed")
+		return nil, fmt.Errorf("could not read GapsInFrameNumValueAllowed: %w", err)
 	}
 	sps.GapsInFrameNumValueAllowed = b == 1
 
-	sps.PicWidthInMbsMinus1, err = readUe(nil)
+	sps.PicWidthInMbsMinus1, err = readUe(br)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse PicWidthInMbsMinus1")
+		return nil, fmt.Errorf("could not parse PicWidthInMbsMinus1: %w", err)
 	}
 
-	sps.PicHeightInMapUnitsMinus1, err = readUe(nil)
+	sps.PicHeightInMapUnitsMinus1, err = readUe(br)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not parse PicHeightInMapUnitsMinus1")
+		return nil, fmt.Errorf("could not parse PicHeightInMapUnitsMinus1: %w", err)
 	}
 
 	b, err = br.ReadBits(1)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read FrameMbsOnly")
+		return nil, fmt.Errorf("could not read FrameMbsOnly: %w", err)
 	}
 	sps.FrameMbsOnly = b == 1
 
 	if !sps.FrameMbsOnly {
 		b, err = br.ReadBits(1)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read MBAdaptiveFrameField")
+			return nil, fmt.Errorf("could not read MBAdaptiveFrameField: %w", err)
 		}
 		sps.MBAdaptiveFrameField = b == 1
 	}
@@ -453,238 +284,108 @@ func NewSPS(rbsp []byte, showPacket bool) (*SPS, error) {
 	}
 
 	if sps.FrameCropping {
-		sps.FrameCropLeftOffset, err = readUe(nil)
+		sps.FrameCropLeftOffset, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse FrameCropLeftOffset")
+			return nil, fmt.Errorf("could not parse FrameCropLeftOffset: %w", err)
 		}
 
-		sps.FrameCropRightOffset, err = readUe(nil)
+		sps.FrameCropRightOffset, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse FrameCropRightOffset")
+			return nil, fmt.Errorf("could not parse FrameCropRightOffset: %w", err)
 		}
 
-		sps.FrameCropTopOffset, err = readUe(nil)
+		sps.FrameCropTopOffset, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse FrameCropTopOffset")
+			return nil, fmt.Errorf("could not parse FrameCropTopOffset: %w", err)
 		}
 
-		sps.FrameCropBottomOffset, err = readUe(nil)
+		sps.FrameCropBottomOffset, err = readUe(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not parse FrameCropBottomOffset")
+			return nil, fmt.Errorf("could not parse FrameCropBottomOffset: %w", err)
 		}
 	}
 
 	b, err = br.ReadBits(1)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read VuiParametersPresent")
+		return nil, fmt.Errorf("could not read VuiParametersPresent: %w", err)
 	}
 	sps.VuiParametersPresent = b == 1
 
 	if sps.VuiParametersPresent {
-		// vui_parameters
-		b, err = br.ReadBits(1)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not read AspectRatioInfoPresent")
-		}
-		sps.AspectRatioInfoPresent = b == 1
-
-		if sps.AspectRatioInfoPresent {
-			b, err = br.ReadBits(8)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not read AspectRatio")
-			}
-			sps.AspectRatio = int(b)
-
-			EXTENDED_SAR := 999
-			if sps.AspectRatio == EXTENDED_SAR {
-				b, err = br.ReadBits(16)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not read SarWidth")
-				}
-				sps.SarWidth = int(b)
-
-				b, err = br.ReadBits(16)
-				if err != nil {
-					return nil, errors.Wrap(err, "could not read SarHeight")
-				}
-				sps.SarHeight = int(b)
-			}
-		}
-
-		b, err = br.ReadBits(1)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not read OverscanInfoPresent")
-		}
-		sps.OverscanInfoPresent = b == 1
-
-		if sps.OverscanInfoPresent {
-			b, err = br.ReadBits(1)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not read OverscanAppropriate")
-			}
-			sps.OverscanAppropriate = b == 1
-		}
-
-		b, err = br.ReadBits(1)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not read VideoSignalTypePresent")
-		}
-		sps.VideoSignalTypePresent = b == 1
-
-		if sps.VideoSignalTypePresent {
-			b, err = br.ReadBits(3)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not read VideoFormat")
-			}
-			sps.VideoFormat = int(b)
-		}
-
-		if sps.VideoSignalTypePresent {
-			b, err = br.ReadBits(1)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not read VideoFullRange")
-			}
-			sps.VideoFullRange = b == 1
-
-			b, err = br.ReadBits(1)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not read ColorDescriptionPresent")
-			}
-			sps.ColorDescriptionPresent = b == 1
-
-			if sps.ColorDescriptionPresent {
-				err = readFields(br,
-					[]field{
-						{&sps.ColorPrimaries, "ColorPrimaries", 8},
-						{&sps.TransferCharacteristics, "TransferCharacteristics", 8},
-						{&sps.MatrixCoefficients, "MatrixCoefficients", 8},
-					},
-				)
-				if err != nil {
-					return nil, err
-				}
-			}
-		}
-
-		b, err = br.ReadBits(1)
+		sps.VUI, err = parseVUI(br)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read ChromaLocInfoPresent")
-		}
-		sps.ChromaLocInfoPresent = b == 1
-
-		if sps.ChromaLocInfoPresent {
-			sps.ChromaSampleLocTypeTopField, err = readUe(nil)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not parse ChromaSampleLocTypeTopField")
-			}
-
-			sps.ChromaSampleLocTypeBottomField, err = readUe(nil)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not parse ChromaSampleLocTypeBottomField")
-			}
+			return nil, fmt.Errorf("could not parse VUI parameters: %w", err)
 		}
+	}
+	return &sps, nil
+}
 
-		b, err = br.ReadBits(1)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not read TimingInfoPresent")
-		}
-		sps.TimingInfoPresent = b == 1
-
-		if sps.TimingInfoPresent {
-			err := readFields(br, []field{
-				{&sps.NumUnitsInTick, "NumUnitsInTick", 32},
-				{&sps.TimeScale, "TimeScale", 32},
-			})
-			if err != nil {
-				return nil, err
-			}
-
-			b, err = br.ReadBits(1)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not read FixedFrameRate")
+// Write writes the SPS to w as a NAL unit, without start code prefix, with
+// nal_ref_idc of 3. Syntax elements are written according to the presence
+// flags of the SPS, so that fields, for example the level or VUI timing, may
+// be changed and a valid SPS written from the result.
+func (s *SPS) Write(w io.Writer) error {
+	return writeNalUnit(w, NALTypeSPS, 3, func(w *rbspWriter) {
+		w.u(uint64(s.ProfileIDC), 8)
+		for _, c := range []int{s.Constraint0, s.Constraint1, s.Constraint2, s.Constraint3, s.Constraint4, s.Constraint5} {
+			w.u(uint64(c), 1)
+		}
+		w.u(0, 2) // reserved_zero_2bits
+		w.u(uint64(s.LevelIDC), 8)
+		w.ue(s.ID)
+
+		if hasChromaFormat(s.ProfileIDC) {
+			w.ue(s.ChromaFormat)
+			if s.ChromaFormat == chroma444 {
+				w.flag(s.UseSeparateColorPlane)
 			}
-			sps.FixedFrameRate = b == 1
-		}
-
-		b, err = br.ReadBits(1)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not read NalHrdParametersPresent")
-		}
-		sps.NalHrdParametersPresent = b == 1
-
-		if sps.NalHrdParametersPresent {
-			err = hrdParameters()
-			if err != nil {
-				return nil, errors.Wrap(err, "could not get hrdParameters")
+			w.ue(s.BitDepthLumaMinus8)
+			w.ue(s.BitDepthChromaMinus8)
+			w.flag(s.QPrimeYZeroTransformBypass)
+			w.flag(s.SeqScalingMatrixPresent)
+			if s.SeqScalingMatrixPresent {
+				writeScalingMatrix(w, &s.ScalingMatrix, s.SeqScalingList)
 			}
 		}
 
-		b, err = br.ReadBits(1)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not read VclHrdParametersPresent")
-		}
-		sps.VclHrdParametersPresent = b == 1
-
-		if sps.VclHrdParametersPresent {
-			err = hrdParameters()
-			if err != nil {
-				return nil, errors.Wrap(err, "could not get hrdParameters")
-			}
-		}
-		if sps.NalHrdParametersPresent || sps.VclHrdParametersPresent {
-			b, err = br.ReadBits(1)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not read LowHrdDelay")
+		w.ue(s.Log2MaxFrameNumMinus4)
+		w.ue(s.PicOrderCountType)
+		switch s.PicOrderCountType {
+		case 0:
+			w.ue(s.Log2MaxPicOrderCntLSBMin4)
+		case 1:
+			w.flag(s.DeltaPicOrderAlwaysZero)
+			w.se(s.OffsetForNonRefPic)
+			w.se(s.OffsetForTopToBottomField)
+			w.ue(len(s.OffsetForRefFrameList))
+			for _, o := range s.OffsetForRefFrameList {
+				w.se(o)
 			}
-			sps.LowHrdDelay = b == 1
 		}
 
-		err := readFlags(br, []flag{
-			{&sps.PicStructPresent, "PicStructPresent"},
-			{&sps.BitstreamRestriction, "BitStreamRestriction"},
-		})
-
-		if sps.BitstreamRestriction {
-			b, err = br.ReadBits(1)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not read MotionVectorsOverPicBoundaries")
-			}
-			sps.MotionVectorsOverPicBoundaries = b == 1
-
-			sps.MaxBytesPerPicDenom, err = readUe(nil)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not parse MaxBytesPerPicDenom")
-			}
-
-			sps.MaxBitsPerMbDenom, err = readUe(nil)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not parse MaxBitsPerMbDenom")
-			}
-
-			sps.Log2MaxMvLengthHorizontal, err = readUe(nil)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not parse Log2MaxMvLengthHorizontal")
-			}
-
-			sps.Log2MaxMvLengthVertical, err = readUe(nil)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not parse Log2MaxMvLengthVertical")
-			}
-
-			sps.MaxNumReorderFrames, err = readUe(nil)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not parse MaxNumReorderFrames")
-			}
-
-			sps.MaxDecFrameBuffering, err = readUe(nil)
-			if err != nil {
-				return nil, errors.Wrap(err, "could not parse MaxDecFrameBuffering")
+		w.ue(s.MaxNumRefFrames)
+		w.flag(s.GapsInFrameNumValueAllowed)
+		w.ue(s.PicWidthInMbsMinus1)
+		w.ue(s.PicHeightInMapUnitsMinus1)
+		w.flag(s.FrameMbsOnly)
+		if !s.FrameMbsOnly {
+			w.flag(s.MBAdaptiveFrameField)
+		}
+		w.flag(s.Direct8x8Inference)
+		w.flag(s.FrameCropping)
+		if s.FrameCropping {
+			w.ue(s.FrameCropLeftOffset)
+			w.ue(s.FrameCropRightOffset)
+			w.ue(s.FrameCropTopOffset)
+			w.ue(s.FrameCropBottomOffset)
+		}
+		w.flag(s.VuiParametersPresent)
+		if s.VuiParametersPresent {
+			if s.VUI == nil {
+				w.fail(errors.New("VUI parameters present but nil"))
+				return
 			}
+			writeVUI(w, s.VUI)
 		}
-
-	} // End VuiParameters Annex E.1.1
-	if showPacket {
-		debugPacket("SPS", sps)
-	}
-	return &sps, nil
+	})
 }
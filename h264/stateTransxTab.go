@@ -66,7 +66,7 @@ var stateTransxTab = map[int]StateTransx{
 	56: {36, 57},
 	57: {36, 58},
 	58: {37, 59},
-	59: {37, 61},
+	59: {37, 60},
 	60: {37, 61},
 	61: {38, 62},
 	62: {38, 62},
@@ -0,0 +1,48 @@
+/*
+NAME
+  stateTransxTab_test.go
+
+DESCRIPTION
+  stateTransxTab_test.go provides checks of the transcription of
+  stateTransxTab against the properties of the state transitions of table
+  9-45.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+// TestStateTransxTab checks that decoding the MPS moves to the next state,
+// short of the final two, that decoding the LPS never moves to a later state,
+// that transIdxLPS does not decrease with pStateIdx, and that the final state
+// is never left.
+func TestStateTransxTab(t *testing.T) {
+	if len(stateTransxTab) != 64 {
+		t.Fatalf("did not get expected number of states\nGot: %d\nWant: 64", len(stateTransxTab))
+	}
+	for pStateIdx := 0; pStateIdx < 63; pStateIdx++ {
+		s, ok := stateTransxTab[pStateIdx]
+		if !ok {
+			t.Fatalf("no transitions for pStateIdx: %d", pStateIdx)
+		}
+		wantMPS := pStateIdx + 1
+		if wantMPS > 62 {
+			wantMPS = 62
+		}
+		if s.TransIdxMPS != wantMPS {
+			t.Errorf("did not get expected transIdxMPS for pStateIdx: %d\nGot: %d\nWant: %d", pStateIdx, s.TransIdxMPS, wantMPS)
+		}
+		if s.TransIdxLPS > pStateIdx && pStateIdx != 0 {
+			t.Errorf("transIdxLPS moves to later state for pStateIdx: %d\nGot: %d", pStateIdx, s.TransIdxLPS)
+		}
+		if pStateIdx > 0 && s.TransIdxLPS < stateTransxTab[pStateIdx-1].TransIdxLPS {
+			t.Errorf("transIdxLPS decreases for pStateIdx: %d\nGot: %d\nPrevious: %d", pStateIdx, s.TransIdxLPS, stateTransxTab[pStateIdx-1].TransIdxLPS)
+		}
+	}
+	if got, want := stateTransxTab[63], (StateTransx{63, 63}); got != want {
+		t.Errorf("did not get expected transitions for final state\nGot: %v\nWant: %v", got, want)
+	}
+}
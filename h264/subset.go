@@ -0,0 +1,317 @@
+/*
+NAME
+  subset.go
+
+DESCRIPTION
+  subset.go provides parsing of subset sequence parameter sets, used by the
+  SVC and MVC extensions (Annexes G and H), so that streams containing them
+  may be read and their base layer or view decoded.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// SubsetSPS is a subset sequence parameter set, as specified in section
+// G.7.3.2.1.4 (or H.7.3.2.1.4). Subset SPS are used by the enhancement layers
+// of SVC streams and the non-base views of MVC streams, and have their own
+// seq_parameter_set_id space, separate from that of SPS.
+type SubsetSPS struct {
+	// SPS holds the seq_parameter_set_data of the subset SPS.
+	SPS *SPS
+
+	// SVC is the seq_parameter_set_svc_extension, present for the Scalable
+	// Baseline and Scalable High profiles, and otherwise nil.
+	SVC *SVCExtension
+
+	// MVC is the seq_parameter_set_mvc_extension, present for the Multiview
+	// High, Stereo High and MFC High profiles, and otherwise nil.
+	MVC *MVCExtension
+}
+
+// SVCExtension holds seq_parameter_set_svc_extension, as specified in section
+// G.7.3.2.1.4. Syntax elements that are not present take their inferred
+// values.
+type SVCExtension struct {
+	InterLayerDeblockingFilterControlPresent bool
+	ExtendedSpatialScalabilityIDC            int
+	ChromaPhaseXPlus1                        int
+	ChromaPhaseYPlus1                        int
+	SeqRefLayerChromaPhaseXPlus1             int
+	SeqRefLayerChromaPhaseYPlus1             int
+	SeqScaledRefLayerLeftOffset              int
+	SeqScaledRefLayerTopOffset               int
+	SeqScaledRefLayerRightOffset             int
+	SeqScaledRefLayerBottomOffset            int
+	SeqTCoeffLevelPrediction                 bool
+	AdaptiveTCoeffLevelPrediction            bool
+	SliceHeaderRestriction                   bool
+}
+
+// MVCExtension holds the view and level syntax elements of
+// seq_parameter_set_mvc_extension, as specified in section H.7.3.2.1.4.
+// Per-view elements are indexed by view order index; the reference lists for
+// index 0, the base view, which has no inter-view references, are empty.
+type MVCExtension struct {
+	ViewID         []int
+	AnchorRefL0    [][]int
+	AnchorRefL1    [][]int
+	NonAnchorRefL0 [][]int
+	NonAnchorRefL1 [][]int
+	Levels         []MVCLevel
+}
+
+// MVCLevel is a level signalled in an MVC extension and the operation points
+// to which it applies.
+type MVCLevel struct {
+	LevelIDC      int
+	ApplicableOps []MVCOperationPoint
+}
+
+// MVCOperationPoint is an operation point of an MVC stream, being a temporal
+// subset of a set of target views.
+type MVCOperationPoint struct {
+	TemporalID    int
+	TargetViewIDs []int
+	NumViews      int // Number of views required to decode the target views.
+}
+
+// NewSubsetSPS parses the subset SPS with the given RBSP. Only the
+// seq_parameter_set_data and the SVC or MVC extension are parsed; the VUI
+// extensions and any extensions for other profiles, such as MVCD and 3D-AVC,
+// are not.
+func NewSubsetSPS(rbsp []byte) (_ *SubsetSPS, err error) {
+	br := bits.NewBitReader(bytes.NewReader(rbsp))
+	defer func() { err = withBitPos(br, err) }()
+	return readSubsetSPS(br)
+}
+
+// readSubsetSPS reads a subset_seq_parameter_set_rbsp from br, see
+// NewSubsetSPS.
+func readSubsetSPS(br *bits.BitReader) (*SubsetSPS, error) {
+	sps, err := readSPSData(br)
+	if err != nil {
+		return nil, err
+	}
+	s := &SubsetSPS{SPS: sps}
+
+	switch sps.ProfileIDC {
+	case 83, 86:
+		s.SVC, err = readSVCExtension(br, sps)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse SVC extension: %w", err)
+		}
+	case 118, 128, 134:
+		b, err := br.ReadBits(1)
+		if err != nil {
+			return nil, fmt.Errorf("could not read bit_equal_to_one: %w", err)
+		}
+		if b != 1 {
+			return nil, fmt.Errorf("bit_equal_to_one is 0")
+		}
+		s.MVC, err = readMVCExtension(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse MVC extension: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// readSVCExtension reads seq_parameter_set_svc_extension for the subset SPS
+// with the given seq_parameter_set_data.
+func readSVCExtension(br *bits.BitReader, sps *SPS) (*SVCExtension, error) {
+	e := &SVCExtension{ChromaPhaseXPlus1: 1, ChromaPhaseYPlus1: 1}
+	err := readFlags(br, []flag{{&e.InterLayerDeblockingFilterControlPresent, "InterLayerDeblockingFilterControlPresent"}})
+	if err != nil {
+		return nil, err
+	}
+	err = readFields(br, []field{{&e.ExtendedSpatialScalabilityIDC, "ExtendedSpatialScalabilityIDC", 2}})
+	if err != nil {
+		return nil, err
+	}
+
+	cat := sps.ChromaArrayType()
+	if cat == chroma420 || cat == chroma422 {
+		err = readFields(br, []field{{&e.ChromaPhaseXPlus1, "ChromaPhaseXPlus1", 1}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cat == chroma420 {
+		err = readFields(br, []field{{&e.ChromaPhaseYPlus1, "ChromaPhaseYPlus1", 2}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.SeqRefLayerChromaPhaseXPlus1 = e.ChromaPhaseXPlus1
+	e.SeqRefLayerChromaPhaseYPlus1 = e.ChromaPhaseYPlus1
+	if e.ExtendedSpatialScalabilityIDC == 1 {
+		if cat != chromaMonochrome {
+			err = readFields(br, []field{
+				{&e.SeqRefLayerChromaPhaseXPlus1, "SeqRefLayerChromaPhaseXPlus1", 1},
+				{&e.SeqRefLayerChromaPhaseYPlus1, "SeqRefLayerChromaPhaseYPlus1", 2},
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, f := range []struct {
+			loc  *int
+			name string
+		}{
+			{&e.SeqScaledRefLayerLeftOffset, "SeqScaledRefLayerLeftOffset"},
+			{&e.SeqScaledRefLayerTopOffset, "SeqScaledRefLayerTopOffset"},
+			{&e.SeqScaledRefLayerRightOffset, "SeqScaledRefLayerRightOffset"},
+			{&e.SeqScaledRefLayerBottomOffset, "SeqScaledRefLayerBottomOffset"},
+		} {
+			*f.loc, err = readSe(br)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse %s: %w", f.name, err)
+			}
+		}
+	}
+
+	err = readFlags(br, []flag{{&e.SeqTCoeffLevelPrediction, "SeqTCoeffLevelPrediction"}})
+	if err != nil {
+		return nil, err
+	}
+	if e.SeqTCoeffLevelPrediction {
+		err = readFlags(br, []flag{{&e.AdaptiveTCoeffLevelPrediction, "AdaptiveTCoeffLevelPrediction"}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = readFlags(br, []flag{{&e.SliceHeaderRestriction, "SliceHeaderRestriction"}})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// readMVCExtension reads the view and level syntax elements of
+// seq_parameter_set_mvc_extension.
+func readMVCExtension(br *bits.BitReader) (*MVCExtension, error) {
+	numViewsMinus1, err := readUe(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse num_views_minus1: %w", err)
+	}
+	// view_id is at most 1023, so there are at most 1024 views.
+	if numViewsMinus1 > 1023 {
+		return nil, fmt.Errorf("num_views_minus1 %d out of range", numViewsMinus1)
+	}
+	n := numViewsMinus1 + 1
+
+	e := &MVCExtension{
+		ViewID:         make([]int, n),
+		AnchorRefL0:    make([][]int, n),
+		AnchorRefL1:    make([][]int, n),
+		NonAnchorRefL0: make([][]int, n),
+		NonAnchorRefL1: make([][]int, n),
+	}
+	for i := range e.ViewID {
+		e.ViewID[i], err = readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse view_id: %w", err)
+		}
+	}
+	for i := 1; i < n; i++ {
+		e.AnchorRefL0[i], err = readViewRefs(br, "anchor_ref_l0")
+		if err != nil {
+			return nil, err
+		}
+		e.AnchorRefL1[i], err = readViewRefs(br, "anchor_ref_l1")
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := 1; i < n; i++ {
+		e.NonAnchorRefL0[i], err = readViewRefs(br, "non_anchor_ref_l0")
+		if err != nil {
+			return nil, err
+		}
+		e.NonAnchorRefL1[i], err = readViewRefs(br, "non_anchor_ref_l1")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	numLevelsMinus1, err := readUe(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse num_level_values_signalled_minus1: %w", err)
+	}
+	if numLevelsMinus1 > 63 {
+		return nil, fmt.Errorf("num_level_values_signalled_minus1 %d out of range", numLevelsMinus1)
+	}
+	e.Levels = make([]MVCLevel, numLevelsMinus1+1)
+	for i := range e.Levels {
+		l := &e.Levels[i]
+		err = readFields(br, []field{{&l.LevelIDC, "LevelIDC", 8}})
+		if err != nil {
+			return nil, err
+		}
+		numOpsMinus1, err := readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse num_applicable_ops_minus1: %w", err)
+		}
+		if numOpsMinus1 > 1023 {
+			return nil, fmt.Errorf("num_applicable_ops_minus1 %d out of range", numOpsMinus1)
+		}
+		l.ApplicableOps = make([]MVCOperationPoint, numOpsMinus1+1)
+		for j := range l.ApplicableOps {
+			op := &l.ApplicableOps[j]
+			err = readFields(br, []field{{&op.TemporalID, "ApplicableOpTemporalID", 3}})
+			if err != nil {
+				return nil, err
+			}
+			numTargetsMinus1, err := readUe(br)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse applicable_op_num_target_views_minus1: %w", err)
+			}
+			if numTargetsMinus1 > 1023 {
+				return nil, fmt.Errorf("applicable_op_num_target_views_minus1 %d out of range", numTargetsMinus1)
+			}
+			op.TargetViewIDs = make([]int, numTargetsMinus1+1)
+			for k := range op.TargetViewIDs {
+				op.TargetViewIDs[k], err = readUe(br)
+				if err != nil {
+					return nil, fmt.Errorf("could not parse applicable_op_target_view_id: %w", err)
+				}
+			}
+			op.NumViews, err = readUe(br)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse applicable_op_num_views_minus1: %w", err)
+			}
+			op.NumViews++
+		}
+	}
+	return e, nil
+}
+
+// readViewRefs reads a count followed by that many view IDs, as used for the
+// inter-view reference lists of the MVC extension.
+func readViewRefs(br *bits.BitReader, name string) ([]int, error) {
+	n, err := readUe(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse number of %s: %w", name, err)
+	}
+	if n > 1024 {
+		return nil, fmt.Errorf("number of %s %d out of range", name, n)
+	}
+	refs := make([]int, n)
+	for i := range refs {
+		refs[i], err = readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", name, err)
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,139 @@
+/*
+NAME
+  subset_test.go
+
+DESCRIPTION
+  subset_test.go provides testing for functionality provided in subset.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// subsetSPSData returns the bits of seq_parameter_set_data for a 16x16 4:2:0
+// picture with the given profile_idc bits and seq_parameter_set_id.
+func subsetSPSData(profile string, id int) string {
+	return profile + "00000000 00011110" + // Constraints, level_idc 30.
+		ueBits(id) + "010 1 1 0 0" + // chroma_format_idc 1, 8 bit, no scaling matrices.
+		"1 011 010 0 1 1 1 1 0 0"
+}
+
+// mvcSubsetSPS returns a subset SPS NAL unit with the given
+// seq_parameter_set_id for a stereo high stream of views 0 and 1, with view 1
+// referring to view 0.
+func mvcSubsetSPS(id int) []byte {
+	return append([]byte{0x6f}, binToSlice(
+		subsetSPSData("10000000", id)+
+			"1"+ // bit_equal_to_one.
+			"010 1 010"+ // num_views_minus1 1, view_id 0 and 1.
+			"010 1 1"+ // Anchor references, l0 view 0, l1 none.
+			"010 1 1"+ // Non-anchor references, l0 view 0, l1 none.
+			"1 00011110 1"+ // One level, level_idc 30, one operation point.
+			"000 010 1 010 010"+ // temporal_id 0, target views 0 and 1, 2 views.
+			"0 0"+ // mvc_vui_parameters_present_flag, additional_extension2_flag.
+			"1", // rbsp_stop_one_bit.
+	)...)
+}
+
+// TestNewSubsetSPS checks parsing of the SVC and MVC extensions of subset SPS.
+func TestNewSubsetSPS(t *testing.T) {
+	svc := append([]byte{0x6f}, binToSlice(
+		subsetSPSData("01010011", 2)+
+			"1 01 0 10"+ // Inter-layer deblocking control, ESS 1, chroma phase.
+			"1 00 00100 011 1 1"+ // Reference layer chroma phase and offsets 2, -1, 0, 0.
+			"1 0 1"+ // seq_tcoeff_level_prediction_flag, slice_header_restriction_flag.
+			"0 0 1",
+	)...)
+
+	tests := []struct {
+		nal     []byte
+		wantID  int
+		wantSVC *SVCExtension
+		wantMVC *MVCExtension
+	}{
+		{
+			nal:    svc,
+			wantID: 2,
+			wantSVC: &SVCExtension{
+				InterLayerDeblockingFilterControlPresent: true,
+				ExtendedSpatialScalabilityIDC:            1,
+				ChromaPhaseXPlus1:                        0,
+				ChromaPhaseYPlus1:                        2,
+				SeqRefLayerChromaPhaseXPlus1:             1,
+				SeqRefLayerChromaPhaseYPlus1:             0,
+				SeqScaledRefLayerLeftOffset:              2,
+				SeqScaledRefLayerTopOffset:               -1,
+				SeqTCoeffLevelPrediction:                 true,
+				SliceHeaderRestriction:                   true,
+			},
+		},
+		{
+			nal:    mvcSubsetSPS(1),
+			wantID: 1,
+			wantMVC: &MVCExtension{
+				ViewID:         []int{0, 1},
+				AnchorRefL0:    [][]int{nil, {0}},
+				AnchorRefL1:    [][]int{nil, {}},
+				NonAnchorRefL0: [][]int{nil, {0}},
+				NonAnchorRefL1: [][]int{nil, {}},
+				Levels: []MVCLevel{{
+					LevelIDC:      30,
+					ApplicableOps: []MVCOperationPoint{{TargetViewIDs: []int{0, 1}, NumViews: 2}},
+				}},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		got, err := NewSubsetSPS(test.nal[1:])
+		if err != nil {
+			t.Errorf("did not expect error: %v for test: %d", err, i)
+			continue
+		}
+		if got.SPS.ID != test.wantID || got.SPS.PicWidthInMbsMinus1 != 0 {
+			t.Errorf("did not get expected SPS data for test: %d\nGot: %+v", i, got.SPS)
+		}
+		if !reflect.DeepEqual(got.SVC, test.wantSVC) {
+			t.Errorf("did not get expected SVC extension for test: %d\nGot: %+v\nWant: %+v", i, got.SVC, test.wantSVC)
+		}
+		if !reflect.DeepEqual(got.MVC, test.wantMVC) {
+			t.Errorf("did not get expected MVC extension for test: %d\nGot: %+v\nWant: %+v", i, got.MVC, test.wantMVC)
+		}
+	}
+}
+
+// TestSubsetSPSStream checks that a subset SPS is stored separately from the
+// SPS with the same ID, and that a PPS referring only to a subset SPS is
+// accepted.
+func TestSubsetSPSStream(t *testing.T) {
+	stream := annexB(spsWithID(0), mvcSubsetSPS(0), mvcSubsetSPS(1), ppsWithID(0, 0), ppsWithID(1, 1))
+
+	r, err := NewH264Reader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = r.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+
+	if len(r.ParameterSets.SPS) != 1 || len(r.ParameterSets.SubsetSPS) != 2 {
+		t.Fatalf("did not get expected number of parameter sets\nGot: %d SPS, %d subset SPS\nWant: 1 SPS, 2 subset SPS", len(r.ParameterSets.SPS), len(r.ParameterSets.SubsetSPS))
+	}
+	if r.ParameterSets.SPS[0].ProfileIDC != 66 {
+		t.Errorf("SPS replaced by subset SPS with same ID\nGot profile: %d", r.ParameterSets.SPS[0].ProfileIDC)
+	}
+	if _, ok := r.ParameterSets.PPS[1]; !ok {
+		t.Errorf("PPS referring to subset SPS not stored")
+	}
+	if r.ParameterSets.last != r.ParameterSets.SPS[0] {
+		t.Errorf("subset SPS taken as most recent SPS")
+	}
+}
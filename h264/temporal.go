@@ -0,0 +1,99 @@
+/*
+NAME
+  temporal.go
+
+DESCRIPTION
+  temporal.go provides identification of temporal layers within a stream so
+  that higher layers may be discarded.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "time"
+
+// maxTemporalID is the largest value temporal_id may take (3 bits).
+const maxTemporalID = 7
+
+// temporalID returns the temporal layer of a VCL NAL unit. For SVC and MVC
+// NAL units the temporal_id is taken from the NAL unit header extension; for
+// AVC base layer NAL units it is taken from a preceding prefix NAL unit (see
+// G.7.4.1.1) if there was one. Otherwise nal_ref_idc is used; non-reference
+// pictures can never be referred to, so they are placed in layer 1 and
+// reference pictures in layer 0.
+func (h *H264Reader) temporalID(nalUnit *NalUnit) int {
+	switch nalUnit.Type {
+	case NALTypeSliceExtension, NALTypeSliceExtensionDepth:
+		return nalUnit.TemporalId
+	}
+	if h.havePrefix {
+		return h.prefixTemporalID
+	}
+	if nalUnit.RefIdc == 0 {
+		return 1
+	}
+	return 0
+}
+
+// skipTemporalLayer keeps track of temporal layer information provided by
+// prefix NAL units, updates temporal layer statistics, and returns true if the
+// given NAL unit belongs to a temporal layer above that selected using
+// WithMaxTemporalID, in which case it may be discarded.
+func (h *H264Reader) skipTemporalLayer(nalUnit *NalUnit) bool {
+	if nalUnit.Type == NALTypePrefixNALU {
+		h.prefixTemporalID = nalUnit.TemporalId
+		h.havePrefix = true
+		h.layerStats[nalUnit.TemporalId].Bytes += nalUnit.NumBytes
+		return h.filterTemporal && nalUnit.TemporalId > h.maxTemporalID
+	}
+	if !nalUnit.Type.IsVCL() {
+		return false
+	}
+
+	// A prefix NAL unit applies only to the NAL unit immediately following it.
+	id := h.temporalID(nalUnit)
+	h.havePrefix = false
+
+	stats := &h.layerStats[id]
+	stats.Bytes += nalUnit.NumBytes
+	// Partitions B and C begin with slice_id rather than a slice header.
+	isPartBC := nalUnit.Type == NALTypeSlicePartB || nalUnit.Type == NALTypeSlicePartC
+	if !isPartBC && startsPicture(nalUnit) {
+		stats.Frames++
+	}
+
+	return h.filterTemporal && id > h.maxTemporalID
+}
+
+// TemporalLayerStats holds statistics for a single temporal layer.
+type TemporalLayerStats struct {
+	Frames int // Number of pictures in the layer.
+	Bytes  int // Number of NAL unit bytes in the layer, excluding start codes.
+}
+
+// Bitrate returns the average bitrate of the layer in bits per second given
+// the duration of the stream over which the statistics were collected.
+func (s TemporalLayerStats) Bitrate(d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(s.Bytes*8) / d.Seconds()
+}
+
+// TemporalLayers returns statistics for each temporal layer seen so far,
+// indexed by temporal_id, up to the highest layer seen. Temporal layering is
+// present if more than one layer is returned. Statistics include NAL units
+// discarded due to WithMaxTemporalID, so they describe the whole stream.
+func (h *H264Reader) TemporalLayers() []TemporalLayerStats {
+	n := 0
+	for i, s := range h.layerStats {
+		if s.Bytes != 0 {
+			n = i + 1
+		}
+	}
+	layers := make([]TemporalLayerStats, n)
+	copy(layers, h.layerStats[:n])
+	return layers
+}
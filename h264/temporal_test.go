@@ -0,0 +1,125 @@
+/*
+NAME
+  temporal_test.go
+
+DESCRIPTION
+  temporal_test.go provides testing for functionality provided in temporal.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// prefixNAL returns a prefix NAL unit (type 14) with an SVC header extension
+// giving the provided temporal_id.
+func prefixNAL(tid byte) []byte {
+	return []byte{0x6e, 0x80, 0x80, tid<<5 | 0x07, 0x80}
+}
+
+// TestSkipTemporalLayer checks that VCL NAL units are discarded according to
+// temporal_id from prefix NAL units, or nal_ref_idc in their absence.
+func TestSkipTemporalLayer(t *testing.T) {
+	nonRef := []byte{0x01, 0x9a}
+
+	tests := []struct {
+		stream []byte
+		maxID  int
+		want   int // Number of VCL NAL units kept.
+	}{
+		{
+			stream: annexB(prefixNAL(0), testIDR, prefixNAL(2), testNonIDR, prefixNAL(1), testNonIDR, prefixNAL(2), testNonIDR),
+			maxID:  0,
+			want:   1,
+		},
+		{
+			stream: annexB(prefixNAL(0), testIDR, prefixNAL(2), testNonIDR, prefixNAL(1), testNonIDR, prefixNAL(2), testNonIDR),
+			maxID:  1,
+			want:   2,
+		},
+		{
+			stream: annexB(prefixNAL(0), testIDR, prefixNAL(2), testNonIDR, prefixNAL(1), testNonIDR, prefixNAL(2), testNonIDR),
+			maxID:  2,
+			want:   4,
+		},
+		{
+			stream: annexB(testIDR, nonRef, testNonIDR, nonRef),
+			maxID:  0,
+			want:   2,
+		},
+		{
+			stream: annexB(testIDR, nonRef, testNonIDR, nonRef),
+			maxID:  1,
+			want:   4,
+		},
+	}
+
+	for i, test := range tests {
+		h, err := NewH264Reader(bytes.NewReader(test.stream), WithMaxTemporalID(test.maxID))
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader for test: %d", err, i)
+		}
+
+		var got int
+		for {
+			nalUnit, err := h.readNalUnit()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("did not expect error: %v from readNalUnit for test: %d", err, i)
+			}
+			if !h.skipTemporalLayer(nalUnit) && nalUnit.Type.IsVCL() {
+				got++
+			}
+		}
+
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestTemporalLayers checks that per temporal layer statistics are collected
+// for every layer, including those discarded.
+func TestTemporalLayers(t *testing.T) {
+	stream := annexB(prefixNAL(0), testIDR, prefixNAL(2), testNonIDR, prefixNAL(1), testNonIDR, prefixNAL(2), testNonIDR)
+	h, err := NewH264Reader(bytes.NewReader(stream), WithMaxTemporalID(0))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	for {
+		nalUnit, err := h.readNalUnit()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("did not expect error: %v from readNalUnit", err)
+		}
+		h.skipTemporalLayer(nalUnit)
+	}
+
+	// Prefix NAL units are 5 bytes, the IDR slice 3 and non-IDR slices 2.
+	want := []TemporalLayerStats{
+		{Frames: 1, Bytes: 8},
+		{Frames: 1, Bytes: 7},
+		{Frames: 2, Bytes: 14},
+	}
+	got := h.TemporalLayers()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("did not get expected layer statistics\nGot: %v\nWant: %v", got, want)
+	}
+
+	const wantBitrate = 56
+	if br := got[2].Bitrate(2 * time.Second); br != wantBitrate {
+		t.Errorf("did not get expected bitrate\nGot: %v\nWant: %v", br, wantBitrate)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+NAME
+  timeline.go
+
+DESCRIPTION
+  timeline.go provides recording of the time spent in each stage of decoding,
+  across the goroutines of an H264Reader, for export in the Chrome trace event
+  format, so that pipeline stalls may be visualised using chrome://tracing or
+  similar tools.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stages of decoding recorded by a Timeline.
+const (
+	StageRead    = "read"    // Reading a NAL unit from the stream.
+	StageProcess = "process" // Processing a NAL unit, including decoding unless concurrent.
+	StageDecode  = "decode"  // Decoding a picture concurrently, see WithIntraParallelism.
+	StageWait    = "wait"    // Waiting for a concurrently decoded picture.
+)
+
+// Timeline records the stages of decoding of an H264Reader as Chrome trace
+// events, see WithTimeline. Stages of the goroutine calling Start are recorded
+// on thread 0, and those of goroutines decoding pictures concurrently on
+// threads numbered from 1. A Timeline is safe for concurrent use.
+type Timeline struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []traceEvent
+	tids   int // Number of threads used, being one more than the highest.
+}
+
+// traceEvent is an event of the Chrome trace event format. Times are in
+// microseconds from the start of the Timeline.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   float64                `json:"ts"`
+	Dur  float64                `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// NewTimeline returns a new Timeline, the times of whose events are relative
+// to now.
+func NewTimeline() *Timeline {
+	return &Timeline{start: time.Now()}
+}
+
+// now returns the current time, or the zero time if t is nil, so that no
+// time is taken when timings are not being recorded.
+func (t *Timeline) now() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// span records a stage beginning at start and ending now on thread tid, with
+// the given arguments, which may be nil. It does nothing if t is nil.
+func (t *Timeline) span(stage string, tid int, start time.Time, args map[string]interface{}) {
+	if t == nil {
+		return
+	}
+	end := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, traceEvent{
+		Name: stage,
+		Cat:  "h264",
+		Ph:   "X",
+		Ts:   micros(start.Sub(t.start)),
+		Dur:  micros(end.Sub(start)),
+		Pid:  1,
+		Tid:  tid,
+		Args: args,
+	})
+	if tid >= t.tids {
+		t.tids = tid + 1
+	}
+}
+
+// micros returns d in microseconds.
+func micros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+// nalArgs returns the trace event arguments describing a NAL unit.
+func nalArgs(nalUnit *NalUnit) map[string]interface{} {
+	return map[string]interface{}{"type": nalUnit.Type.String(), "offset": nalUnit.Offset}
+}
+
+// WriteJSON writes the recorded events to w as a Chrome trace event JSON
+// object, which may be loaded by chrome://tracing, preceded by metadata
+// events naming each thread.
+func (t *Timeline) WriteJSON(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events := make([]traceEvent, 0, t.tids+len(t.events))
+	for tid := 0; tid < t.tids; tid++ {
+		name := "reader"
+		if tid > 0 {
+			name = "worker " + strconv.Itoa(tid)
+		}
+		events = append(events, traceEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: tid, Args: map[string]interface{}{"name": name}})
+	}
+	events = append(events, t.events...)
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{events, "ms"})
+}
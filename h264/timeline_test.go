@@ -0,0 +1,118 @@
+/*
+NAME
+  timeline_test.go
+
+DESCRIPTION
+  timeline_test.go provides testing for functionality provided in timeline.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// timelineEvents decodes stream using an H264Reader configured with options
+// and WithTimeline, returning the events of the written trace.
+func timelineEvents(t *testing.T, stream []byte, options ...Option) []traceEvent {
+	tl := NewTimeline()
+	h, err := NewH264Reader(bytes.NewReader(stream), append(options, WithTimeline(tl))...)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewH264Reader", err)
+	}
+	err = h.Start()
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Start", err)
+	}
+	var buf bytes.Buffer
+	err = tl.WriteJSON(&buf)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from WriteJSON", err)
+	}
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	err = json.Unmarshal(buf.Bytes(), &trace)
+	if err != nil {
+		t.Fatalf("could not unmarshal trace: %v", err)
+	}
+	return trace.TraceEvents
+}
+
+func TestTimeline(t *testing.T) {
+	events := timelineEvents(t, annexB(testSPS, testPPS, skipSlice(2, 1), skipSlice(2, 2)))
+
+	counts := make(map[string]int)
+	for _, e := range events {
+		counts[e.Name]++
+		switch e.Name {
+		case "thread_name":
+			if e.Ph != "M" || e.Tid != 0 || e.Args["name"] != "reader" {
+				t.Errorf("did not get expected metadata event\nGot: %+v", e)
+			}
+		case StageRead, StageProcess:
+			if e.Ph != "X" || e.Tid != 0 || e.Ts < 0 || e.Args["type"] == nil {
+				t.Errorf("did not get expected stage event\nGot: %+v", e)
+			}
+		default:
+			t.Errorf("did not expect event\nGot: %+v", e)
+		}
+	}
+	want := map[string]int{"thread_name": 1, StageRead: 4, StageProcess: 4}
+	for name, n := range want {
+		if counts[name] != n {
+			t.Errorf("did not get expected number of %s events\nGot: %d\nWant: %d", name, counts[name], n)
+		}
+	}
+}
+
+// iSlice returns a reference I slice NAL unit with the given 4 bit frame_num
+// whose slice data is not valid, for use with testSPS and testPPS.
+func iSlice(frameNum int) []byte {
+	return append([]byte{2<<5 | byte(NALTypeSliceNonIDRPicture)}, binToSlice(
+		ueBits(0)+ueBits(7)+"1"+fmt.Sprintf("%04b", frameNum)+ // first_mb_in_slice, slice_type, pic_parameter_set_id, frame_num.
+			"0"+ // adaptive_ref_pic_marking_mode_flag.
+			"1 010"+ // slice_qp_delta, disable_deblocking_filter_idc.
+			"1", // rbsp_stop_one_bit.
+	)...)
+}
+
+// TestTimelineParallel checks that pictures decoded concurrently are
+// recorded on the lanes of the worker goroutines.
+func TestTimelineParallel(t *testing.T) {
+	const workers = 2
+	stream := annexB(testSPS, testPPS, iSlice(1), iSlice(2), iSlice(3))
+	events := timelineEvents(t, stream, WithRecovery(), WithIntraParallelism(workers))
+
+	var lanes []int
+	for _, e := range events {
+		if e.Name != StageDecode {
+			continue
+		}
+		lanes = append(lanes, e.Tid)
+		if e.Args["slices"] != 1.0 {
+			t.Errorf("did not get expected decode event\nGot: %+v", e)
+		}
+	}
+	sort.Ints(lanes)
+	want := []int{1, 1, 2}
+	if !reflect.DeepEqual(lanes, want) {
+		t.Errorf("did not get expected decode lanes\nGot: %v\nWant: %v", lanes, want)
+	}
+}
+
+func TestTimelineNil(t *testing.T) {
+	var tl *Timeline
+	if !tl.now().IsZero() {
+		t.Errorf("expected zero time from nil Timeline")
+	}
+	tl.span(StageRead, 0, tl.now(), nil)
+}
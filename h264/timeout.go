@@ -0,0 +1,121 @@
+/*
+NAME
+  timeout.go
+
+DESCRIPTION
+  timeout.go provides an io.Reader wrapper that gives up on reads that take
+  longer than a timeout, so that a stalled live source does not block the
+  reader forever.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// ErrStreamStalled is returned when no data is received from the stream within
+// the read timeout given by WithReadTimeout. Reading may be resumed afterwards.
+var ErrStreamStalled = errors.New("stream stalled")
+
+// deadliner is implemented by sources such as net.Conn that support read
+// deadlines.
+type deadliner interface {
+	SetReadDeadline(time.Time) error
+}
+
+// newTimeoutReader returns an io.Reader that reads from r, returning
+// ErrStreamStalled if a read does not complete within timeout. If r supports
+// read deadlines these are used; otherwise reads are done in a separate
+// goroutine so that the caller is not blocked. In the latter case a read that
+// times out remains outstanding, and its result is returned by the next call to
+// Read.
+func newTimeoutReader(r io.Reader, timeout time.Duration) io.Reader {
+	if d, ok := r.(deadliner); ok {
+		return &deadlineReader{r: r, d: d, timeout: timeout}
+	}
+	return &timeoutReader{r: r, timeout: timeout}
+}
+
+// deadlineReader implements a read timeout using read deadlines.
+type deadlineReader struct {
+	r       io.Reader
+	d       deadliner
+	timeout time.Duration
+}
+
+// Read implements io.Reader.
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	// Some sources refuse to set a deadline once closed; the read below will
+	// then report the underlying condition, so the error is not returned here.
+	r.d.SetReadDeadline(time.Now().Add(r.timeout))
+	n, err := r.r.Read(p)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return n, ErrStreamStalled
+	}
+	return n, err
+}
+
+// readResult holds the result of a read done by a timeoutReader.
+type readResult struct {
+	n   int
+	err error
+}
+
+// timeoutReader implements a read timeout by reading in a separate goroutine.
+type timeoutReader struct {
+	r       io.Reader
+	timeout time.Duration
+	pending chan readResult // Result of an outstanding read, if not nil.
+	buf     []byte          // Buffer used by reads.
+	rem     []byte          // Data read but not yet returned.
+	err     error           // Error to return once rem is consumed.
+}
+
+// Read implements io.Reader.
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if len(r.rem) == 0 && r.err != nil {
+		err := r.err
+		r.err = nil
+		return 0, err
+	}
+
+	if len(r.rem) == 0 {
+		if r.pending == nil {
+			if len(r.buf) < len(p) {
+				r.buf = make([]byte, len(p))
+			}
+			r.pending = make(chan readResult, 1)
+			go func(buf []byte, c chan<- readResult) {
+				n, err := r.r.Read(buf)
+				c <- readResult{n, err}
+			}(r.buf[:len(p)], r.pending)
+		}
+
+		t := time.NewTimer(r.timeout)
+		defer t.Stop()
+		select {
+		case res := <-r.pending:
+			r.pending = nil
+			r.rem = r.buf[:res.n]
+			r.err = res.err
+		case <-t.C:
+			return 0, ErrStreamStalled
+		}
+	}
+
+	n := copy(p, r.rem)
+	r.rem = r.rem[n:]
+	if len(r.rem) == 0 && r.err != nil {
+		err := r.err
+		r.err = nil
+		return n, err
+	}
+	return n, nil
+}
@@ -0,0 +1,74 @@
+/*
+NAME
+  timeout_test.go
+
+DESCRIPTION
+  timeout_test.go provides testing for functionality provided in timeout.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestReadTimeout checks that a stalled stream results in an
+// EventStreamStalled event and ErrStreamStalled, and that reading can be
+// resumed once data arrives, for sources with and without read deadlines.
+func TestReadTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	c1, c2 := net.Pipe()
+
+	tests := []struct {
+		r io.Reader
+		w io.WriteCloser
+	}{
+		{pr, pw},
+		{c1, c2},
+	}
+
+	for i, test := range tests {
+		var events []Event
+		r, err := NewH264Reader(
+			test.r,
+			WithReadTimeout(20*time.Millisecond),
+			WithEventHandler(func(e Event) { events = append(events, e) }),
+		)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader for test: %d", err, i)
+		}
+
+		err = r.Start()
+		if !errors.Is(err, ErrStreamStalled) {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant: %v", i, err, ErrStreamStalled)
+		}
+		if len(events) != 1 || events[0].Type != EventStreamStalled {
+			t.Errorf("did not get expected events for test: %d\nGot: %v", i, events)
+		}
+
+		go func(w io.WriteCloser) {
+			w.Write(annexB(testSPS, testPPS))
+			w.Close()
+		}(test.w)
+
+		for {
+			err = r.Start()
+			if !errors.Is(err, ErrStreamStalled) {
+				break
+			}
+		}
+		if err != nil {
+			t.Errorf("did not expect error: %v after resuming for test: %d", err, i)
+		}
+		if len(r.ParameterSets.SPS) != 1 || len(r.ParameterSets.PPS) != 1 {
+			t.Errorf("parameter sets not decoded after resuming for test: %d", i)
+		}
+	}
+}
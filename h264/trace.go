@@ -0,0 +1,63 @@
+/*
+NAME
+  trace.go
+
+DESCRIPTION
+  trace.go provides recording of the syntax elements read while parsing an
+  RBSP, giving their positions and values, for bit-level debugging.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"strconv"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// TraceElement is a syntax element read from an RBSP.
+type TraceElement struct {
+	Name       string // Name of the syntax element, if known.
+	Descriptor string // Descriptor as used in section 7.2, e.g. "u(4)" or "ue(v)".
+	Bit        int    // RBSP bit offset of the first bit.
+	Len        int    // Length in bits.
+	Value      int64
+}
+
+// traceRecorder is a bits.Tracer recording the syntax elements read from an
+// RBSP. Each read is recorded as an element of descriptor u(n), which the
+// parsing functions of this package may then name or combine with preceding
+// reads using traceElement, e.g. to give a single ue(v) element.
+type traceRecorder struct {
+	elems []TraceElement
+}
+
+// Read implements bits.Tracer.
+func (t *traceRecorder) Read(off, n int, v uint64) {
+	t.elems = append(t.elems, TraceElement{Descriptor: "u(" + strconv.Itoa(n) + ")", Bit: off, Len: n, Value: int64(v)})
+}
+
+// traceElement replaces the elements recorded by the tracer of br, if it is a
+// traceRecorder, from bit offset start with a single element of the given
+// name, descriptor and value. If desc is empty, the descriptor of the last
+// element is kept, being u(n) for an element read by a single read.
+func traceElement(br *bits.BitReader, start int, name, desc string, v int64) {
+	t, ok := br.Tracer().(*traceRecorder)
+	if !ok {
+		return
+	}
+	i := len(t.elems)
+	for i > 0 && t.elems[i-1].Bit >= start {
+		i--
+	}
+	if i == len(t.elems) {
+		return
+	}
+	if desc == "" {
+		desc = t.elems[len(t.elems)-1].Descriptor
+	}
+	t.elems = append(t.elems[:i], TraceElement{Name: name, Descriptor: desc, Bit: start, Len: br.Off() - start, Value: v})
+}
@@ -0,0 +1,236 @@
+/*
+NAME
+  transform.go
+
+DESCRIPTION
+  transform.go provides the scaling and inverse transformation of the
+  transform coefficients of residual blocks to residual samples, as specified
+  by section 8.5.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+// normAdjust4x4 gives the values of v of 8-315 used in normAdjust4x4(m, i, j),
+// indexed by m, for coefficients with i and j both even, both odd, and
+// otherwise.
+var normAdjust4x4 = [6][3]int{
+	{10, 16, 13},
+	{11, 18, 14},
+	{13, 20, 16},
+	{14, 23, 18},
+	{16, 25, 20},
+	{18, 29, 23},
+}
+
+// normAdjust8x8 gives the values of v of 8-318 used in normAdjust8x8(m, i, j),
+// indexed by m, for the six classes of coefficient location, see
+// levelScale8x8.
+var normAdjust8x8 = [6][6]int{
+	{20, 18, 32, 19, 25, 24},
+	{22, 19, 35, 21, 28, 26},
+	{26, 23, 42, 24, 33, 31},
+	{28, 25, 45, 26, 35, 33},
+	{32, 28, 51, 30, 40, 38},
+	{36, 32, 58, 34, 46, 43},
+}
+
+// levelScale4x4 returns LevelScale4x4(m, i, j) in raster order, for the 4x4
+// scaling list in zig-zag order, being weightScale4x4 after inverse scanning,
+// and m of qP % 6 (8.5.9).
+func levelScale4x4(list *[16]int, m int) [16]int {
+	var ls [16]int
+	for k, w := range list {
+		pos := zigZag4x4[k]
+		i, j := pos%4, pos/4
+		var v int
+		switch {
+		case i%2 == 0 && j%2 == 0:
+			v = normAdjust4x4[m][0]
+		case i%2 == 1 && j%2 == 1:
+			v = normAdjust4x4[m][1]
+		default:
+			v = normAdjust4x4[m][2]
+		}
+		ls[pos] = w * v
+	}
+	return ls
+}
+
+// levelScale8x8 returns LevelScale8x8(m, i, j) in raster order, for the 8x8
+// scaling list in zig-zag order and m of qP % 6 (8.5.10).
+func levelScale8x8(list *[maxNumCoeff8x8]int, m int) [maxNumCoeff8x8]int {
+	var ls [maxNumCoeff8x8]int
+	for k, w := range list {
+		pos := zigZag8x8[k]
+		i, j := pos/8, pos%8
+		var v int
+		switch {
+		case i%4 == 0 && j%4 == 0:
+			v = normAdjust8x8[m][0]
+		case i%2 == 1 && j%2 == 1:
+			v = normAdjust8x8[m][1]
+		case i%4 == 2 && j%4 == 2:
+			v = normAdjust8x8[m][2]
+		case i%4 == 0 && j%2 == 1, i%2 == 1 && j%4 == 0:
+			v = normAdjust8x8[m][3]
+		case i%4 == 0 && j%4 == 2, i%4 == 2 && j%4 == 0:
+			v = normAdjust8x8[m][4]
+		default:
+			v = normAdjust8x8[m][5]
+		}
+		ls[pos] = w * v
+	}
+	return ls
+}
+
+// scale4x4 scales the coefficients c of a 4x4 block in raster order using
+// LevelScale4x4 ls and quantisation parameter qP, as specified by section
+// 8.5.12.1. The DC coefficient is left as it is if dc, as for the blocks of
+// Intra16x16 macroblocks and chroma blocks, whose DC coefficients are scaled
+// with the DC transform.
+func scale4x4(c *[16]int, ls *[16]int, qP int, dc bool) {
+	for k := range c {
+		if k == 0 && dc {
+			continue
+		}
+		if qP >= 24 {
+			c[k] = (c[k] * ls[k]) << uint(qP/6-4)
+		} else {
+			c[k] = (c[k]*ls[k] + 1<<uint(3-qP/6)) >> uint(4-qP/6)
+		}
+	}
+}
+
+// scale8x8 scales the coefficients c of an 8x8 block in raster order using
+// LevelScale8x8 ls and quantisation parameter qP, as specified by section
+// 8.5.13.1.
+func scale8x8(c *[maxNumCoeff8x8]int, ls *[maxNumCoeff8x8]int, qP int) {
+	for k := range c {
+		if qP >= 36 {
+			c[k] = (c[k] * ls[k]) << uint(qP/6-6)
+		} else {
+			c[k] = (c[k]*ls[k] + 1<<uint(5-qP/6)) >> uint(6-qP/6)
+		}
+	}
+}
+
+// lumaDCTransform transforms and scales in place the Intra16x16 DC block c,
+// after inverse scanning, to the DC coefficients dcY of the 4x4 luma blocks,
+// in raster order of the blocks, as specified by section 8.5.10. ls00 is
+// LevelScale4x4(qP % 6, 0, 0).
+func lumaDCTransform(c *[16]int, ls00, qP int) {
+	for i := 0; i < 16; i += 4 {
+		row := hadamard4([4]int{c[i], c[i+1], c[i+2], c[i+3]})
+		copy(c[i:], row[:])
+	}
+	for j := 0; j < 4; j++ {
+		col := hadamard4([4]int{c[j], c[4+j], c[8+j], c[12+j]})
+		for i, v := range col {
+			c[4*i+j] = v
+		}
+	}
+	for k := range c {
+		if qP >= 36 {
+			c[k] = (c[k] * ls00) << uint(qP/6-6)
+		} else {
+			c[k] = (c[k]*ls00 + 1<<uint(5-qP/6)) >> uint(6-qP/6)
+		}
+	}
+}
+
+// hadamard4 returns the product of the matrix of the 4x4 luma DC and 2x4
+// chroma DC transforms, of 8-320 and 8-329, and v.
+func hadamard4(v [4]int) [4]int {
+	return [4]int{
+		v[0] + v[1] + v[2] + v[3],
+		v[0] + v[1] - v[2] - v[3],
+		v[0] - v[1] - v[2] + v[3],
+		v[0] - v[1] + v[2] - v[3],
+	}
+}
+
+// idct4x4 transforms the scaled coefficients d of a 4x4 block in raster order
+// in place to residual samples, as specified by section 8.5.12.2.
+func idct4x4(d *[16]int) {
+	for i := 0; i < 16; i += 4 {
+		idct4(d[i:i+4], 1)
+	}
+	for j := 0; j < 4; j++ {
+		idct4(d[j:], 4)
+	}
+	for k := range d {
+		d[k] = (d[k] + 32) >> 6
+	}
+}
+
+// idct4 applies the one-dimensional inverse transform of 8-338 to 8-345 to
+// the 4 values of s at intervals of stride.
+func idct4(s []int, stride int) {
+	s0, s1, s2, s3 := s[0], s[stride], s[2*stride], s[3*stride]
+	e0, e1 := s0+s2, s0-s2
+	e2, e3 := (s1>>1)-s3, s1+(s3>>1)
+	s[0], s[stride], s[2*stride], s[3*stride] = e0+e3, e1+e2, e1-e2, e0-e3
+}
+
+// idct8x8 transforms the scaled coefficients d of an 8x8 block in raster order
+// in place to residual samples, as specified by section 8.5.13.2.
+func idct8x8(d *[maxNumCoeff8x8]int) {
+	for i := 0; i < maxNumCoeff8x8; i += 8 {
+		idct8(d[i:i+8], 1)
+	}
+	for j := 0; j < 8; j++ {
+		idct8(d[j:], 8)
+	}
+	for k := range d {
+		d[k] = (d[k] + 32) >> 6
+	}
+}
+
+// idct8 applies the one-dimensional inverse transform of 8-350 to 8-373 to
+// the 8 values of s at intervals of stride.
+func idct8(s []int, stride int) {
+	var d [8]int
+	for k := range d {
+		d[k] = s[k*stride]
+	}
+	e0 := d[0] + d[4]
+	e1 := -d[3] + d[5] - d[7] - (d[7] >> 1)
+	e2 := d[0] - d[4]
+	e3 := d[1] + d[7] - d[3] - (d[3] >> 1)
+	e4 := (d[2] >> 1) - d[6]
+	e5 := -d[1] + d[7] + d[5] + (d[5] >> 1)
+	e6 := d[2] + (d[6] >> 1)
+	e7 := d[3] + d[5] + d[1] + (d[1] >> 1)
+
+	f0 := e0 + e6
+	f1 := e1 + (e7 >> 2)
+	f2 := e2 + e4
+	f3 := e3 + (e5 >> 2)
+	f4 := e2 - e4
+	f5 := (e3 >> 2) - e5
+	f6 := e0 - e6
+	f7 := e7 - (e1 >> 2)
+
+	g := [8]int{f0 + f7, f2 + f5, f4 + f3, f6 + f1, f6 - f1, f4 - f3, f2 - f5, f0 - f7}
+	for k, v := range g {
+		s[k*stride] = v
+	}
+}
+
+// bypassIntraResidual sets the residual samples r, in raster order, of a
+// block of width nW of a macroblock with TransformBypassModeFlag 1 predicted
+// in vertical or horizontal intra prediction mode to their cumulative sums
+// down each column or along each row, as specified by section 8.5.15.
+func bypassIntraResidual(r []int, nW int, horizontal bool) {
+	for k := range r {
+		switch {
+		case horizontal && k%nW > 0:
+			r[k] += r[k-1]
+		case !horizontal && k >= nW:
+			r[k] += r[k-nW]
+		}
+	}
+}
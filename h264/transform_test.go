@@ -0,0 +1,211 @@
+/*
+NAME
+  transform_test.go
+
+DESCRIPTION
+  transform_test.go provides testing for functionality provided in
+  transform.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import "testing"
+
+func TestLevelScale4x4(t *testing.T) {
+	flat := FlatScalingMatrix().List4x4[0]
+	tests := []struct {
+		list *[16]int
+		m    int
+		want map[int]int // LevelScale4x4 by raster index.
+	}{
+		{list: &flat, m: 0, want: map[int]int{0: 160, 1: 208, 5: 256, 15: 256}},
+		{list: &flat, m: 5, want: map[int]int{0: 288, 4: 368, 10: 288}},
+
+		// The default intra list in zig-zag order, whose third value is at
+		// (0, 1).
+		{list: &Default4x4IntraList, m: 0, want: map[int]int{0: 60, 4: 169, 15: 672}},
+	}
+
+	for i, test := range tests {
+		got := levelScale4x4(test.list, test.m)
+		for pos, want := range test.want {
+			if got[pos] != want {
+				t.Errorf("did not get expected result at (%d, %d) for test: %d\nGot: %d\nWant: %d", pos%4, pos/4, i, got[pos], want)
+			}
+		}
+	}
+}
+
+func TestLevelScale8x8(t *testing.T) {
+	flat := FlatScalingMatrix().List8x8[0]
+	got := levelScale8x8(&flat, 0)
+	want := map[int]int{0: 320, 1: 304, 2: 400, 3: 304, 9: 288, 10: 384, 18: 512, 63: 288}
+	for pos, want := range want {
+		if got[pos] != want {
+			t.Errorf("did not get expected result at (%d, %d)\nGot: %d\nWant: %d", pos%8, pos/8, got[pos], want)
+		}
+	}
+}
+
+func TestScale4x4(t *testing.T) {
+	flat := FlatScalingMatrix().List4x4[0]
+	tests := []struct {
+		qP   int
+		dc   bool
+		want [16]int
+	}{
+		// qP/6 of 4, with no shift.
+		{qP: 28, want: [16]int{-256, 320, 0, 0, 0, 400, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+
+		// qP/6 of 5, shifted left by 1, and of 1, rounded and shifted right
+		// by 3.
+		{qP: 34, want: [16]int{-512, 640, 0, 0, 0, 800, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{qP: 10, want: [16]int{-32, 40, 0, 0, 0, 50, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+
+		// DC already scaled.
+		{qP: 28, dc: true, want: [16]int{-1, 320, 0, 0, 0, 400, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for i, test := range tests {
+		ls := levelScale4x4(&flat, test.qP%6)
+		c := [16]int{-1, 1, 5: 1}
+		scale4x4(&c, &ls, test.qP, test.dc)
+		if c != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, c, test.want)
+		}
+	}
+}
+
+func TestScale8x8(t *testing.T) {
+	flat := FlatScalingMatrix().List8x8[0]
+	tests := []struct {
+		qP   int
+		want [3]int // Of the coefficients at (0, 0), (1, 0) and (1, 1).
+	}{
+		// qP/6 of 6, with no shift, of 7, shifted left by 1, and of 4, rounded
+		// and shifted right by 2.
+		{qP: 36, want: [3]int{-320, 304, 576}},
+		{qP: 42, want: [3]int{-640, 608, 1152}},
+		{qP: 24, want: [3]int{-80, 76, 144}},
+	}
+
+	for i, test := range tests {
+		ls := levelScale8x8(&flat, test.qP%6)
+		var c [maxNumCoeff8x8]int
+		c[0], c[1], c[9] = -1, 1, 2
+		scale8x8(&c, &ls, test.qP)
+		if got := [3]int{c[0], c[1], c[9]}; got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestLumaDCTransform(t *testing.T) {
+	tests := []struct {
+		c    [16]int
+		ls00 int
+		qP   int
+		want [16]int
+	}{
+		// A DC of 1, scaled with and without shift left and by rounding and
+		// shifting right.
+		{c: [16]int{1}, ls00: 160, qP: 36, want: [16]int{160, 160, 160, 160, 160, 160, 160, 160, 160, 160, 160, 160, 160, 160, 160, 160}},
+		{c: [16]int{1}, ls00: 160, qP: 42, want: [16]int{320, 320, 320, 320, 320, 320, 320, 320, 320, 320, 320, 320, 320, 320, 320, 320}},
+		{c: [16]int{1}, ls00: 160, qP: 24, want: [16]int{40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40}},
+
+		// The coefficient at (1, 0).
+		{c: [16]int{1: 1}, ls00: 64, qP: 36, want: [16]int{64, 64, -64, -64, 64, 64, -64, -64, 64, 64, -64, -64, 64, 64, -64, -64}},
+	}
+
+	for i, test := range tests {
+		got := test.c
+		lumaDCTransform(&got, test.ls00, test.qP)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestIDCT4x4(t *testing.T) {
+	tests := []struct {
+		d    [16]int
+		want [16]int
+	}{
+		{d: [16]int{64}, want: [16]int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+
+		// The first horizontal basis function, of 1, 1/2, -1/2 and -1.
+		{d: [16]int{1: 64}, want: [16]int{1, 1, 0, -1, 1, 1, 0, -1, 1, 1, 0, -1, 1, 1, 0, -1}},
+
+		// The first vertical one, scaled.
+		{d: [16]int{4: 640}, want: [16]int{10, 10, 10, 10, 5, 5, 5, 5, -5, -5, -5, -5, -10, -10, -10, -10}},
+
+		// Both together, with a DC coefficient.
+		{
+			d: [16]int{0: 128, 1: 256, 4: 128},
+			want: [16]int{
+				8, 6, 2, 0,
+				7, 5, 1, -1,
+				5, 3, -1, -3,
+				4, 2, -2, -4,
+			},
+		},
+	}
+
+	for i, test := range tests {
+		got := test.d
+		idct4x4(&got)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+func TestIDCT8x8(t *testing.T) {
+	tests := []struct {
+		d       [maxNumCoeff8x8]int
+		wantRow [8]int // Of every row.
+	}{
+		{d: [maxNumCoeff8x8]int{64}, wantRow: [8]int{1, 1, 1, 1, 1, 1, 1, 1}},
+
+		// The first horizontal basis function, in the ratio 12:10:6:3.
+		{d: [maxNumCoeff8x8]int{1: 64}, wantRow: [8]int{2, 1, 1, 0, 0, -1, -1, -1}},
+		{d: [maxNumCoeff8x8]int{1: 304}, wantRow: [8]int{7, 6, 4, 2, -2, -4, -6, -7}},
+	}
+
+	for i, test := range tests {
+		got := test.d
+		idct8x8(&got)
+		for y := 0; y < 8; y++ {
+			var row [8]int
+			copy(row[:], got[8*y:])
+			if row != test.wantRow {
+				t.Errorf("did not get expected row %d for test: %d\nGot: %v\nWant: %v", y, i, row, test.wantRow)
+			}
+		}
+	}
+}
+
+func TestBypassIntraResidual(t *testing.T) {
+	r := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	tests := []struct {
+		horizontal bool
+		want       []int
+	}{
+		{want: []int{1, 2, 3, 4, 6, 8, 10, 12, 15, 18, 21, 24, 28, 32, 36, 40}},
+		{horizontal: true, want: []int{1, 3, 6, 10, 5, 11, 18, 26, 9, 19, 30, 42, 13, 27, 42, 58}},
+	}
+
+	for i, test := range tests {
+		got := append([]int(nil), r...)
+		bypassIntraResidual(got, 4, test.horizontal)
+		for k := range got {
+			if got[k] != test.want[k] {
+				t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+				break
+			}
+		}
+	}
+}
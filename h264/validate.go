@@ -0,0 +1,160 @@
+/*
+NAME
+  validate.go
+
+DESCRIPTION
+  validate.go provides collection of the violations found in strict mode over
+  each access unit, so that all may be reported together rather than only the
+  first.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// defaultMaxViolations is the maximum number of violations collected for an
+// access unit in strict mode, unless given by WithMaxViolations.
+const defaultMaxViolations = 32
+
+// ErrLevelExceeded is wrapped by the errors for an SPS exceeding the limits of
+// its level in strict mode, see SPS.CheckLevel.
+var ErrLevelExceeded = errors.New("level limit exceeded")
+
+// MultiError holds a number of errors, such as the violations found in an
+// access unit in strict mode, each of which is a ParseError locating the
+// violation. errors.Is and errors.As match against any of the errors.
+type MultiError struct {
+	Errs    []error
+	Omitted int // Number of further errors not held, once the cap was reached.
+}
+
+// Error implements the error interface, giving each error on its own line.
+func (e *MultiError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d violations", len(e.Errs)+e.Omitted)
+	if e.Omitted != 0 {
+		fmt.Fprintf(&b, " (%d omitted)", e.Omitted)
+	}
+	b.WriteString(":")
+	for _, err := range e.Errs {
+		b.WriteString("\n\t")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Is returns true if any of the errors matches target, for use by errors.Is.
+func (e *MultiError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target, for use by errors.As.
+func (e *MultiError) As(target interface{}) bool {
+	for _, err := range e.Errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// violationCollector collects the violations of an access unit in strict
+// mode, see WithStrict.
+type violationCollector struct {
+	errs    []error
+	omitted int
+	sawVCL  bool
+}
+
+// startsAU returns true if the NAL unit begins a new access unit, following
+// one having VCL NAL units.
+func (c *violationCollector) startsAU(nalUnit *NalUnit) bool {
+	starts := c.sawVCL && startsAccessUnit(nalUnit)
+	if starts {
+		c.sawVCL = false
+	}
+	if nalUnit.Type.IsVCL() {
+		c.sawVCL = true
+	}
+	return starts
+}
+
+// add adds the violations of err, for the given NAL unit, to those of the
+// access unit, each as a ParseError. The errors of a MultiError are added
+// individually. Violations beyond max are counted but not held. add returns
+// true once max violations are held.
+func (c *violationCollector) add(nalUnit *NalUnit, err error, max int) bool {
+	errs := []error{err}
+	var m *MultiError
+	if errors.As(err, &m) {
+		errs = m.Errs
+	}
+	for _, err := range errs {
+		if len(c.errs) < max {
+			c.errs = append(c.errs, newParseError(nalUnit, err))
+		} else {
+			c.omitted++
+		}
+	}
+	return len(c.errs) >= max
+}
+
+// flush returns a MultiError holding the violations of the access unit, or nil
+// if there are none, and begins collection for a new access unit.
+func (c *violationCollector) flush() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	err := &MultiError{Errs: c.errs, Omitted: c.omitted}
+	c.errs, c.omitted = nil, 0
+	return err
+}
+
+// violations returns all violations of the constraints of section 7.4.1 by the
+// NAL unit header, see Validate.
+func (n *NalUnit) violations() []error {
+	var errs []error
+	if n.ForbiddenZeroBit != 0 {
+		errs = append(errs, fmt.Errorf("%w: forbidden_zero_bit is not 0", ErrInvalidNALHeader))
+	}
+
+	switch n.Type {
+	case NALTypeSPS, NALTypePPS, NALTypeSPSExtension, NALTypeSubsetSPS, NALTypeSliceIDRPicture:
+		if n.RefIdc == 0 {
+			errs = append(errs, fmt.Errorf("%w: nal_ref_idc is 0 for %s NAL unit", ErrInvalidNALHeader, n.Type))
+		}
+	case NALTypeSEI, NALTypeAccessUnitDelimiter, NALTypeEndOfSequence, NALTypeEndOfStream, NALTypeFillerData:
+		if n.RefIdc != 0 {
+			errs = append(errs, fmt.Errorf("%w: nal_ref_idc is %d for %s NAL unit, must be 0", ErrInvalidNALHeader, n.RefIdc, n.Type))
+		}
+	case NALTypeReserved17, NALTypeReserved18, NALTypeReserved22, NALTypeReserved23:
+		errs = append(errs, fmt.Errorf("%w: reserved nal_unit_type %d", ErrInvalidNALHeader, int(n.Type)))
+	}
+	return errs
+}
+
+// levelViolations returns an error wrapping ErrLevelExceeded for each limit
+// of its level exceeded by the SPS, or for an unknown level.
+func levelViolations(sps *SPS) []error {
+	v, err := sps.CheckLevel()
+	if err != nil {
+		return []error{fmt.Errorf("%w: %v", ErrLevelExceeded, err)}
+	}
+	var errs []error
+	for _, l := range v {
+		errs = append(errs, fmt.Errorf("%w: %v", ErrLevelExceeded, l))
+	}
+	return errs
+}
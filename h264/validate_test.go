@@ -0,0 +1,110 @@
+/*
+NAME
+  validate_test.go
+
+DESCRIPTION
+  validate_test.go provides testing for functionality provided in validate.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestMultiError checks the description of a MultiError and matching of its
+// errors using errors.Is and errors.As.
+func TestMultiError(t *testing.T) {
+	errA := errors.New("a")
+	perr := &ParseError{Type: NALTypeSPS, Bit: -1, Err: ErrInvalidNALHeader}
+	m := &MultiError{Errs: []error{errA, perr}, Omitted: 1}
+
+	want := "3 violations (1 omitted):\n\ta\n\t" + perr.Error()
+	if got := m.Error(); got != want {
+		t.Errorf("did not get expected result\nGot: %q\nWant: %q", got, want)
+	}
+	if !errors.Is(m, errA) || !errors.Is(m, ErrInvalidNALHeader) || errors.Is(m, io.EOF) {
+		t.Errorf("did not get expected matches from errors.Is")
+	}
+	var got *ParseError
+	if !errors.As(m, &got) || got != perr {
+		t.Errorf("did not get expected ParseError from errors.As\nGot: %v", got)
+	}
+}
+
+// TestStartViolations checks that in strict mode all violations of an access
+// unit are returned together, up to the cap on violations.
+func TestStartViolations(t *testing.T) {
+	badSPS := append([]byte{0x87}, testSPS[1:]...) // forbidden_zero_bit set and nal_ref_idc 0.
+	badLevel := append([]byte(nil), testSPS...)
+	badLevel[3] = 99
+	badAUD := []byte{0x29, 0xf0} // nal_ref_idc 1.
+
+	tests := []struct {
+		stream       []byte
+		opts         []Option
+		wantErrs     [][]error // Errors matched by the violations of each call to Start.
+		wantOmitted  []int
+		wantLastType NALType // Type of the NAL unit of the last violation of the first call.
+	}{
+		{
+			stream:       annexB(badSPS, testPPS, badAUD, testIDR, testNonIDR),
+			wantErrs:     [][]error{{ErrInvalidNALHeader, ErrInvalidNALHeader, ErrInvalidNALHeader, io.ErrUnexpectedEOF}, {io.ErrUnexpectedEOF}},
+			wantOmitted:  []int{0, 0},
+			wantLastType: NALTypeSliceIDRPicture,
+		},
+		{
+			stream:       annexB(badSPS, testPPS, badAUD, testIDR),
+			opts:         []Option{WithMaxViolations(1)},
+			wantErrs:     [][]error{{ErrInvalidNALHeader}, {ErrInvalidNALHeader}, {io.ErrUnexpectedEOF}},
+			wantOmitted:  []int{1, 0, 0},
+			wantLastType: NALTypeSPS,
+		},
+		{
+			stream:       annexB(badLevel, testPPS),
+			wantErrs:     [][]error{{ErrLevelExceeded}},
+			wantOmitted:  []int{0},
+			wantLastType: NALTypeSPS,
+		},
+	}
+
+	for i, test := range tests {
+		r, err := NewH264Reader(bytes.NewReader(test.stream), append([]Option{WithStrict()}, test.opts...)...)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewH264Reader for test: %d", err, i)
+		}
+		for j, want := range test.wantErrs {
+			err := r.Start()
+			var m *MultiError
+			if !errors.As(err, &m) {
+				t.Fatalf("did not get MultiError for test: %d, call: %d\nGot: %v", i, j, err)
+			}
+			if len(m.Errs) != len(want) || m.Omitted != test.wantOmitted[j] {
+				t.Fatalf("did not get expected violations for test: %d, call: %d\nGot: %v", i, j, m)
+			}
+			for k, e := range m.Errs {
+				var perr *ParseError
+				if !errors.As(e, &perr) || !errors.Is(e, want[k]) {
+					t.Errorf("did not get expected violation %d for test: %d, call: %d\nGot: %v\nWant: %v", k, i, j, e, want[k])
+				}
+				if j == 0 && k == len(m.Errs)-1 && perr != nil && perr.Type != test.wantLastType {
+					t.Errorf("did not get expected NAL type of last violation for test: %d\nGot: %v\nWant: %v", i, perr.Type, test.wantLastType)
+				}
+			}
+		}
+		if err := r.Start(); err != nil {
+			t.Errorf("did not expect error: %v from final call to Start for test: %d", err, i)
+		}
+	}
+
+	_, err := NewH264Reader(nil, WithMaxViolations(0))
+	if err == nil {
+		t.Errorf("did not get expected error for maximum of 0 violations")
+	}
+}
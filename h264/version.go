@@ -0,0 +1,146 @@
+/*
+NAME
+  version.go
+
+DESCRIPTION
+  version.go provides reporting of the version and capabilities of the
+  decoder build, for inclusion in bug reports from deployed units.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// modulePath is the path of the module providing this package.
+const modulePath = "github.com/ausocean/h264decode"
+
+// version is the version of the decoder, used where the module version is not
+// available from the build information, e.g. for development builds.
+const version = "v0.1.0-dev"
+
+// Coding tools whose decoding is complete, from which the capabilities
+// reported by FeatureSet are derived. These must be updated by the changes
+// completing each tool. featureInter also gates the construction of inter
+// macroblocks.
+const (
+	featureCABAC      = true  // CABAC slice data, see cabacDecoder.
+	featureInterlaced = false // Field pictures and MBAFF frames, see mbState and newMbSamples; MBAFF deblocking is unverified.
+	featureInter      = false // Motion compensated P and B macroblocks, see constructMb.
+
+	// Separately coded colour planes of 4:4:4 streams, which are decoded
+	// as monochrome without placing each plane by colour_plane_id.
+	featureSeparateColourPlanes = false
+)
+
+// profileOrder lists the profiles of Annex A in increasing order of the coding
+// tools they permit, an intra profile preceding the profile it is a subset
+// of. The extension profiles of Annexes G, H and I are not decoded.
+var profileOrder = []Profile{
+	ProfileConstrainedBaseline,
+	ProfileBaseline,
+	ProfileMain,
+	ProfileHigh,
+	ProfileHigh10Intra,
+	ProfileHigh10,
+	ProfileHigh422Intra,
+	ProfileHigh422,
+	ProfileHigh444Intra,
+	ProfileHigh444Predictive,
+}
+
+// Version returns the version of the decoder, being the version of the module
+// as recorded in the build information of the binary if available.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	mods := append([]*debug.Module{&info.Main}, info.Deps...)
+	for _, m := range mods {
+		if m.Path != modulePath {
+			continue
+		}
+		if m.Replace != nil {
+			m = m.Replace
+		}
+		if m.Version != "" && m.Version != "(devel)" {
+			return m.Version
+		}
+	}
+	return version
+}
+
+// Features describes the capabilities of the decoder build.
+type Features struct {
+	Version    string   // See Version.
+	GoVersion  string   // Go release used for the build.
+	Platform   string   // Target operating system and architecture, e.g. "linux/arm".
+	CABAC      bool     // Streams using CABAC entropy coding may be decoded.
+	Interlaced bool     // Field and MBAFF coded streams may be decoded.
+	Inter      bool     // P and B slices may be decoded.
+	MaxProfile Profile  // Most capable profile whose streams may be decoded, see Supports, or ProfileUnknown if none.
+	SIMD       []string // Assembly accelerated code paths in use, if any.
+}
+
+// FeatureSet returns the capabilities of the decoder build. The decoder is
+// pure Go, so no SIMD code paths are used.
+func FeatureSet() Features {
+	f := Features{
+		Version:    Version(),
+		GoVersion:  runtime.Version(),
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		CABAC:      featureCABAC,
+		Interlaced: featureInterlaced,
+		Inter:      featureInter,
+	}
+	for _, p := range profileOrder {
+		if f.Supports(p) {
+			f.MaxProfile = p
+		}
+	}
+	return f
+}
+
+// Supports returns true if all streams conforming to profile p may be decoded
+// with the capabilities f, the extension profiles and those with no known
+// profile_idc never being supported.
+func (f Features) Supports(p Profile) bool {
+	switch p {
+	case ProfileUnknown, ProfileExtended, ProfileScalableBaseline, ProfileScalableHigh, ProfileScalableHighIntra,
+		ProfileMultiviewHigh, ProfileStereoHigh, ProfileMFCHigh, ProfileMultiviewDepthHigh,
+		ProfileEnhancedMultiviewDepthHigh, ProfileMFCDepthHigh:
+		return false
+	case ProfileHigh444Intra, ProfileHigh444Predictive, ProfileCAVLC444Intra:
+		if !featureSeparateColourPlanes {
+			return false
+		}
+	}
+	return (f.CABAC || !p.CABAC()) && (f.Interlaced || !p.Interlaced()) && (f.Inter || p.Intra())
+}
+
+// String returns a single line fingerprint of the capabilities, suitable for
+// logs and bug reports.
+func (f Features) String() string {
+	simd := "none"
+	if len(f.SIMD) != 0 {
+		simd = strings.Join(f.SIMD, ",")
+	}
+	return fmt.Sprintf("h264decode %s (%s %s) cabac=%s interlaced=%s inter=%s max-profile=%q simd=%s",
+		f.Version, f.GoVersion, f.Platform, yesNo(f.CABAC), yesNo(f.Interlaced), yesNo(f.Inter), f.MaxProfile, simd)
+}
+
+// yesNo returns "yes" if b is true, and otherwise "no".
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,90 @@
+/*
+NAME
+  version_test.go
+
+DESCRIPTION
+  version_test.go provides testing for functionality provided in version.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"runtime"
+	"testing"
+)
+
+// TestFeatureSet checks the capability fingerprint of the decoder build.
+func TestFeatureSet(t *testing.T) {
+	f := FeatureSet()
+	if f.Version == "" || f.Version != Version() {
+		t.Errorf("did not get expected version\nGot: %q\nWant: %q", f.Version, Version())
+	}
+	if f.GoVersion != runtime.Version() || f.Platform != runtime.GOOS+"/"+runtime.GOARCH {
+		t.Errorf("did not get expected build details\nGot: %s %s", f.GoVersion, f.Platform)
+	}
+
+	tests := []struct {
+		in   Features
+		want string
+	}{
+		{
+			in:   Features{Version: "v1.2.3", GoVersion: "go1.13", Platform: "linux/arm", MaxProfile: ProfileConstrainedBaseline},
+			want: `h264decode v1.2.3 (go1.13 linux/arm) cabac=no interlaced=no inter=no max-profile="Constrained Baseline" simd=none`,
+		},
+		{
+			in:   Features{Version: "v2.0.0", GoVersion: "go1.13", Platform: "linux/amd64", CABAC: true, Interlaced: true, Inter: true, MaxProfile: ProfileHigh, SIMD: []string{"sse2", "avx2"}},
+			want: `h264decode v2.0.0 (go1.13 linux/amd64) cabac=yes interlaced=yes inter=yes max-profile="High" simd=sse2,avx2`,
+		},
+	}
+	for i, test := range tests {
+		got := test.in.String()
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+}
+
+// TestFeatureSupport checks that the reported capabilities follow from the
+// coding tools decoded, so that no profile is claimed whose tools are not.
+func TestFeatureSupport(t *testing.T) {
+	f := FeatureSet()
+	if f.CABAC != featureCABAC || f.Interlaced != featureInterlaced || f.Inter != featureInter {
+		t.Errorf("did not get expected coding tools\nGot: %v", f)
+	}
+	p := f.MaxProfile
+	if p != ProfileUnknown && !f.Supports(p) {
+		t.Errorf("maximum profile %q not supported", p)
+	}
+	if p != ProfileUnknown && ((p.CABAC() && !f.CABAC) || (p.Interlaced() && !f.Interlaced) || (!p.Intra() && !f.Inter)) {
+		t.Errorf("maximum profile %q needs coding tools not decoded\nGot: %v", p, f)
+	}
+
+	tests := []struct {
+		f    Features
+		p    Profile
+		want bool
+	}{
+		{f: Features{CABAC: true, Interlaced: true}, p: ProfileHigh10Intra, want: true},
+		{f: Features{CABAC: true, Interlaced: true}, p: ProfileHigh422Intra, want: true},
+		{f: Features{CABAC: true, Interlaced: true}, p: ProfileConstrainedBaseline, want: false},
+		{f: Features{CABAC: true, Interlaced: true}, p: ProfileHigh, want: false},
+		{f: Features{Inter: true}, p: ProfileConstrainedBaseline, want: true},
+		{f: Features{Inter: true}, p: ProfileMain, want: false},
+		{f: Features{Inter: true, CABAC: true}, p: ProfileProgressiveHigh, want: true},
+		{f: Features{CABAC: true, Interlaced: true, Inter: true}, p: ProfileScalableHigh, want: false},
+		{f: Features{CABAC: true, Interlaced: true, Inter: true}, p: ProfileUnknown, want: false},
+	}
+	for i, test := range tests {
+		got := test.f.Supports(test.p)
+		if got != test.want {
+			t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v", i, got, test.want)
+		}
+	}
+	// Every profile of intra pictures permits interlaced coding.
+	if want := ProfileUnknown; p != want {
+		t.Errorf("did not get expected maximum profile\nGot: %q\nWant: %q", p, want)
+	}
+}
@@ -0,0 +1,550 @@
+/*
+NAME
+  vui.go
+
+DESCRIPTION
+  vui.go provides parsing of video usability information (VUI) parameters
+  carried in the SPS, as specified in Annex E.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// extendedSAR is the value of aspect_ratio_idc indicating that the sample
+// aspect ratio is given explicitly by sar_width and sar_height (table E-1).
+const extendedSAR = 255
+
+// VideoFormat is the representation of the pictures before being coded, as
+// given by video_format in table E-2.
+type VideoFormat int
+
+// Video formats, as defined in table E-2.
+const (
+	VideoFormatComponent VideoFormat = iota
+	VideoFormatPAL
+	VideoFormatNTSC
+	VideoFormatSECAM
+	VideoFormatMAC
+	VideoFormatUnspecified
+)
+
+// String returns the name of the video format.
+func (f VideoFormat) String() string {
+	switch f {
+	case VideoFormatComponent:
+		return "component"
+	case VideoFormatPAL:
+		return "PAL"
+	case VideoFormatNTSC:
+		return "NTSC"
+	case VideoFormatSECAM:
+		return "SECAM"
+	case VideoFormatMAC:
+		return "MAC"
+	case VideoFormatUnspecified:
+		return "unspecified"
+	default:
+		return "VideoFormat(" + strconv.Itoa(int(f)) + ")"
+	}
+}
+
+// VUIParameters describes the vui_parameters syntax structure of an SPS, as
+// defined in section E.1.1. Where a syntax element is not present its value is
+// that inferred by section E.2.1.
+type VUIParameters struct {
+	AspectRatioInfoPresent bool
+	AspectRatioIDC         int
+	SARWidth               int
+	SARHeight              int
+
+	OverscanInfoPresent bool
+	OverscanAppropriate bool
+
+	VideoSignalTypePresent  bool
+	VideoFormat             VideoFormat
+	VideoFullRange          bool
+	ColorDescriptionPresent bool
+	ColorPrimaries          int
+	TransferCharacteristics int
+	MatrixCoefficients      int
+
+	ChromaLocInfoPresent           bool
+	ChromaSampleLocTypeTopField    int
+	ChromaSampleLocTypeBottomField int
+
+	TimingInfoPresent bool
+	NumUnitsInTick    uint32
+	TimeScale         uint32
+	FixedFrameRate    bool
+
+	NalHRDParametersPresent bool
+	NalHRD                  *HRDParameters // nil if not present.
+	VclHRDParametersPresent bool
+	VclHRD                  *HRDParameters // nil if not present.
+	LowDelayHRD             bool
+
+	PicStructPresent bool
+
+	BitstreamRestriction           bool
+	MotionVectorsOverPicBoundaries bool
+	MaxBytesPerPicDenom            int
+	MaxBitsPerMbDenom              int
+	Log2MaxMvLengthHorizontal      int
+	Log2MaxMvLengthVertical        int
+	MaxNumReorderFrames            int
+	MaxDecFrameBuffering           int
+}
+
+// HRDParameters describes the hrd_parameters syntax structure, as defined in
+// section E.1.2.
+type HRDParameters struct {
+	CpbCntMinus1                       int
+	BitRateScale                       int
+	CpbSizeScale                       int
+	BitRateValueMinus1                 []int
+	CpbSizeValueMinus1                 []int
+	Cbr                                []bool
+	InitialCpbRemovalDelayLengthMinus1 int
+	CpbRemovalDelayLengthMinus1        int
+	DpbOutputDelayLengthMinus1         int
+	TimeOffsetLength                   int
+}
+
+// parseVUI parses vui_parameters from br as defined in section E.1.1.
+func parseVUI(br *bits.BitReader) (*VUIParameters, error) {
+	// Inferred values, see section E.2.1.
+	vui := VUIParameters{
+		VideoFormat:               VideoFormatUnspecified,
+		ColorPrimaries:            2,
+		TransferCharacteristics:   2,
+		MatrixCoefficients:        2,
+		MaxBytesPerPicDenom:       2,
+		MaxBitsPerMbDenom:         1,
+		Log2MaxMvLengthHorizontal: 15,
+		Log2MaxMvLengthVertical:   15,
+	}
+
+	err := readFlags(br, []flag{{&vui.AspectRatioInfoPresent, "AspectRatioInfoPresent"}})
+	if err != nil {
+		return nil, err
+	}
+	if vui.AspectRatioInfoPresent {
+		err = readFields(br, []field{{&vui.AspectRatioIDC, "AspectRatioIDC", 8}})
+		if err != nil {
+			return nil, err
+		}
+		if vui.AspectRatioIDC == extendedSAR {
+			err = readFields(br, []field{
+				{&vui.SARWidth, "SARWidth", 16},
+				{&vui.SARHeight, "SARHeight", 16},
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = readFlags(br, []flag{{&vui.OverscanInfoPresent, "OverscanInfoPresent"}})
+	if err != nil {
+		return nil, err
+	}
+	if vui.OverscanInfoPresent {
+		err = readFlags(br, []flag{{&vui.OverscanAppropriate, "OverscanAppropriate"}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = readFlags(br, []flag{{&vui.VideoSignalTypePresent, "VideoSignalTypePresent"}})
+	if err != nil {
+		return nil, err
+	}
+	if vui.VideoSignalTypePresent {
+		b, err := br.ReadBits(3)
+		if err != nil {
+			return nil, fmt.Errorf("could not read VideoFormat: %w", err)
+		}
+		vui.VideoFormat = VideoFormat(b)
+
+		err = readFlags(br, []flag{
+			{&vui.VideoFullRange, "VideoFullRange"},
+			{&vui.ColorDescriptionPresent, "ColorDescriptionPresent"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if vui.ColorDescriptionPresent {
+			err = readFields(br, []field{
+				{&vui.ColorPrimaries, "ColorPrimaries", 8},
+				{&vui.TransferCharacteristics, "TransferCharacteristics", 8},
+				{&vui.MatrixCoefficients, "MatrixCoefficients", 8},
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = readFlags(br, []flag{{&vui.ChromaLocInfoPresent, "ChromaLocInfoPresent"}})
+	if err != nil {
+		return nil, err
+	}
+	if vui.ChromaLocInfoPresent {
+		vui.ChromaSampleLocTypeTopField, err = readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse ChromaSampleLocTypeTopField: %w", err)
+		}
+		vui.ChromaSampleLocTypeBottomField, err = readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse ChromaSampleLocTypeBottomField: %w", err)
+		}
+	}
+
+	err = readFlags(br, []flag{{&vui.TimingInfoPresent, "TimingInfoPresent"}})
+	if err != nil {
+		return nil, err
+	}
+	if vui.TimingInfoPresent {
+		b, err := br.ReadBits(32)
+		if err != nil {
+			return nil, fmt.Errorf("could not read NumUnitsInTick: %w", err)
+		}
+		vui.NumUnitsInTick = uint32(b)
+
+		b, err = br.ReadBits(32)
+		if err != nil {
+			return nil, fmt.Errorf("could not read TimeScale: %w", err)
+		}
+		vui.TimeScale = uint32(b)
+
+		err = readFlags(br, []flag{{&vui.FixedFrameRate, "FixedFrameRate"}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = readFlags(br, []flag{{&vui.NalHRDParametersPresent, "NalHRDParametersPresent"}})
+	if err != nil {
+		return nil, err
+	}
+	if vui.NalHRDParametersPresent {
+		vui.NalHRD, err = parseHRD(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse NAL HRD parameters: %w", err)
+		}
+	}
+
+	err = readFlags(br, []flag{{&vui.VclHRDParametersPresent, "VclHRDParametersPresent"}})
+	if err != nil {
+		return nil, err
+	}
+	if vui.VclHRDParametersPresent {
+		vui.VclHRD, err = parseHRD(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse VCL HRD parameters: %w", err)
+		}
+	}
+
+	if vui.NalHRDParametersPresent || vui.VclHRDParametersPresent {
+		err = readFlags(br, []flag{{&vui.LowDelayHRD, "LowDelayHRD"}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = readFlags(br, []flag{
+		{&vui.PicStructPresent, "PicStructPresent"},
+		{&vui.BitstreamRestriction, "BitstreamRestriction"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !vui.BitstreamRestriction {
+		return &vui, nil
+	}
+
+	err = readFlags(br, []flag{{&vui.MotionVectorsOverPicBoundaries, "MotionVectorsOverPicBoundaries"}})
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		v    *int
+		name string
+	}{
+		{&vui.MaxBytesPerPicDenom, "MaxBytesPerPicDenom"},
+		{&vui.MaxBitsPerMbDenom, "MaxBitsPerMbDenom"},
+		{&vui.Log2MaxMvLengthHorizontal, "Log2MaxMvLengthHorizontal"},
+		{&vui.Log2MaxMvLengthVertical, "Log2MaxMvLengthVertical"},
+		{&vui.MaxNumReorderFrames, "MaxNumReorderFrames"},
+		{&vui.MaxDecFrameBuffering, "MaxDecFrameBuffering"},
+	} {
+		*f.v, err = readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", f.name, err)
+		}
+	}
+	return &vui, nil
+}
+
+// BitRate returns the maximum input bit rate in bits per second of the CPB for
+// the given SchedSelIdx, as given by equation E-37.
+func (h *HRDParameters) BitRate(schedSelIdx int) int {
+	return (h.BitRateValueMinus1[schedSelIdx] + 1) << uint(6+h.BitRateScale)
+}
+
+// CPBSize returns the CPB size in bits for the given SchedSelIdx, as given by
+// equation E-38.
+func (h *HRDParameters) CPBSize(schedSelIdx int) int {
+	return (h.CpbSizeValueMinus1[schedSelIdx] + 1) << uint(4+h.CpbSizeScale)
+}
+
+// FrameRate returns the frame rate of the coded video sequence as the
+// fraction num/den frames per second, in lowest terms, derived from
+// time_scale and num_units_in_tick of the VUI timing information, with a frame
+// lasting two clock ticks (see equation C-13). ok is false if timing
+// information is not present or is invalid, or if fixed_frame_rate_flag is not
+// set, in which case the timing information gives only an upper bound on the
+// frame rate.
+func (s *SPS) FrameRate() (num, den uint32, ok bool) {
+	if s.VUI == nil {
+		return 0, 0, false
+	}
+	vui := s.VUI
+	if !vui.TimingInfoPresent || !vui.FixedFrameRate || vui.NumUnitsInTick == 0 || vui.TimeScale == 0 {
+		return 0, 0, false
+	}
+	n, d := uint64(vui.TimeScale), 2*uint64(vui.NumUnitsInTick)
+	g := gcd(n, d)
+	n, d = n/g, d/g
+	if d > math.MaxUint32 {
+		return 0, 0, false
+	}
+	return uint32(n), uint32(d), true
+}
+
+// sampleAspectRatios holds the sample aspect ratios, as width and height,
+// indicated by values of aspect_ratio_idc from 1 to 16 (table E-1).
+var sampleAspectRatios = [...][2]int{
+	1:  {1, 1},
+	2:  {12, 11},
+	3:  {10, 11},
+	4:  {16, 11},
+	5:  {40, 33},
+	6:  {24, 11},
+	7:  {20, 11},
+	8:  {32, 11},
+	9:  {80, 33},
+	10: {18, 11},
+	11: {15, 11},
+	12: {64, 33},
+	13: {160, 99},
+	14: {4, 3},
+	15: {3, 2},
+	16: {2, 1},
+}
+
+// SampleAspectRatio returns the sample aspect ratio as the fraction w/h, as
+// given by aspect_ratio_idc or, for Extended_SAR, sar_width and sar_height
+// (see table E-1). ok is false if the sample aspect ratio is unspecified or
+// reserved.
+func (s *SPS) SampleAspectRatio() (w, h int, ok bool) {
+	if s.VUI == nil || !s.VUI.AspectRatioInfoPresent {
+		return 0, 0, false
+	}
+	vui := s.VUI
+	switch idc := vui.AspectRatioIDC; {
+	case idc == extendedSAR:
+		if vui.SARWidth == 0 || vui.SARHeight == 0 {
+			return 0, 0, false
+		}
+		return vui.SARWidth, vui.SARHeight, true
+	case idc > 0 && idc < len(sampleAspectRatios):
+		return sampleAspectRatios[idc][0], sampleAspectRatios[idc][1], true
+	}
+	return 0, 0, false
+}
+
+// DisplayAspectRatio returns the aspect ratio of the cropped output picture,
+// as given by PictureSize, when displayed with the sample aspect ratio, as the
+// fraction w/h in lowest terms, e.g. 16/9. ok is false if the sample aspect
+// ratio is unspecified, in which case square samples are usually assumed.
+func (s *SPS) DisplayAspectRatio() (w, h int, ok bool) {
+	sarW, sarH, ok := s.SampleAspectRatio()
+	if !ok {
+		return 0, 0, false
+	}
+	picW, picH := s.PictureSize()
+	if picW <= 0 || picH <= 0 {
+		return 0, 0, false
+	}
+	dw, dh := uint64(picW)*uint64(sarW), uint64(picH)*uint64(sarH)
+	g := gcd(dw, dh)
+	return int(dw / g), int(dh / g), true
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// maxCpbCntMinus1 is the maximum value of cpb_cnt_minus1 (see section E.2.2).
+const maxCpbCntMinus1 = 31
+
+// parseHRD parses hrd_parameters from br as defined in section E.1.2.
+func parseHRD(br *bits.BitReader) (*HRDParameters, error) {
+	var hrd HRDParameters
+	var err error
+	hrd.CpbCntMinus1, err = readUe(br)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse CpbCntMinus1: %w", err)
+	}
+	if hrd.CpbCntMinus1 > maxCpbCntMinus1 {
+		return nil, fmt.Errorf("cpb_cnt_minus1 %d out of range", hrd.CpbCntMinus1)
+	}
+
+	err = readFields(br, []field{
+		{&hrd.BitRateScale, "BitRateScale", 4},
+		{&hrd.CpbSizeScale, "CpbSizeScale", 4},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for schedSelIdx := 0; schedSelIdx <= hrd.CpbCntMinus1; schedSelIdx++ {
+		ue, err := readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse BitRateValueMinus1: %w", err)
+		}
+		hrd.BitRateValueMinus1 = append(hrd.BitRateValueMinus1, ue)
+
+		ue, err = readUe(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse CpbSizeValueMinus1: %w", err)
+		}
+		hrd.CpbSizeValueMinus1 = append(hrd.CpbSizeValueMinus1, ue)
+
+		b, err := br.ReadBits(1)
+		if err != nil {
+			return nil, fmt.Errorf("could not read Cbr: %w", err)
+		}
+		hrd.Cbr = append(hrd.Cbr, b == 1)
+	}
+
+	err = readFields(br, []field{
+		{&hrd.InitialCpbRemovalDelayLengthMinus1, "InitialCpbRemovalDelayLengthMinus1", 5},
+		{&hrd.CpbRemovalDelayLengthMinus1, "CpbRemovalDelayLengthMinus1", 5},
+		{&hrd.DpbOutputDelayLengthMinus1, "DpbOutputDelayLengthMinus1", 5},
+		{&hrd.TimeOffsetLength, "TimeOffsetLength", 5},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &hrd, nil
+}
+
+// writeVUI writes vui as vui_parameters as defined in section E.1.1. Syntax
+// elements are written according to the presence flags of vui.
+func writeVUI(w *rbspWriter, vui *VUIParameters) {
+	w.flag(vui.AspectRatioInfoPresent)
+	if vui.AspectRatioInfoPresent {
+		w.u(uint64(vui.AspectRatioIDC), 8)
+		if vui.AspectRatioIDC == extendedSAR {
+			w.u(uint64(vui.SARWidth), 16)
+			w.u(uint64(vui.SARHeight), 16)
+		}
+	}
+
+	w.flag(vui.OverscanInfoPresent)
+	if vui.OverscanInfoPresent {
+		w.flag(vui.OverscanAppropriate)
+	}
+
+	w.flag(vui.VideoSignalTypePresent)
+	if vui.VideoSignalTypePresent {
+		w.u(uint64(vui.VideoFormat), 3)
+		w.flag(vui.VideoFullRange)
+		w.flag(vui.ColorDescriptionPresent)
+		if vui.ColorDescriptionPresent {
+			w.u(uint64(vui.ColorPrimaries), 8)
+			w.u(uint64(vui.TransferCharacteristics), 8)
+			w.u(uint64(vui.MatrixCoefficients), 8)
+		}
+	}
+
+	w.flag(vui.ChromaLocInfoPresent)
+	if vui.ChromaLocInfoPresent {
+		w.ue(vui.ChromaSampleLocTypeTopField)
+		w.ue(vui.ChromaSampleLocTypeBottomField)
+	}
+
+	w.flag(vui.TimingInfoPresent)
+	if vui.TimingInfoPresent {
+		w.u(uint64(vui.NumUnitsInTick), 32)
+		w.u(uint64(vui.TimeScale), 32)
+		w.flag(vui.FixedFrameRate)
+	}
+
+	w.flag(vui.NalHRDParametersPresent)
+	if vui.NalHRDParametersPresent {
+		writeHRD(w, vui.NalHRD)
+	}
+	w.flag(vui.VclHRDParametersPresent)
+	if vui.VclHRDParametersPresent {
+		writeHRD(w, vui.VclHRD)
+	}
+	if vui.NalHRDParametersPresent || vui.VclHRDParametersPresent {
+		w.flag(vui.LowDelayHRD)
+	}
+
+	w.flag(vui.PicStructPresent)
+	w.flag(vui.BitstreamRestriction)
+	if vui.BitstreamRestriction {
+		w.flag(vui.MotionVectorsOverPicBoundaries)
+		w.ue(vui.MaxBytesPerPicDenom)
+		w.ue(vui.MaxBitsPerMbDenom)
+		w.ue(vui.Log2MaxMvLengthHorizontal)
+		w.ue(vui.Log2MaxMvLengthVertical)
+		w.ue(vui.MaxNumReorderFrames)
+		w.ue(vui.MaxDecFrameBuffering)
+	}
+}
+
+// writeHRD writes hrd as hrd_parameters as defined in section E.1.2.
+func writeHRD(w *rbspWriter, hrd *HRDParameters) {
+	if hrd == nil {
+		w.fail(errors.New("HRD parameters present but nil"))
+		return
+	}
+	n := hrd.CpbCntMinus1 + 1
+	if len(hrd.BitRateValueMinus1) < n || len(hrd.CpbSizeValueMinus1) < n || len(hrd.Cbr) < n {
+		w.fail(fmt.Errorf("HRD parameters have fewer than cpb_cnt_minus1+1 (%d) entries", n))
+		return
+	}
+
+	w.ue(hrd.CpbCntMinus1)
+	w.u(uint64(hrd.BitRateScale), 4)
+	w.u(uint64(hrd.CpbSizeScale), 4)
+	for schedSelIdx := 0; schedSelIdx < n; schedSelIdx++ {
+		w.ue(hrd.BitRateValueMinus1[schedSelIdx])
+		w.ue(hrd.CpbSizeValueMinus1[schedSelIdx])
+		w.flag(hrd.Cbr[schedSelIdx])
+	}
+	w.u(uint64(hrd.InitialCpbRemovalDelayLengthMinus1), 5)
+	w.u(uint64(hrd.CpbRemovalDelayLengthMinus1), 5)
+	w.u(uint64(hrd.DpbOutputDelayLengthMinus1), 5)
+	w.u(uint64(hrd.TimeOffsetLength), 5)
+}
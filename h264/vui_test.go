@@ -0,0 +1,253 @@
+/*
+NAME
+  vui_test.go
+
+DESCRIPTION
+  vui_test.go provides testing for functionality provided in vui.go.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// binToSlice converts a string of binary digits into a byte slice, ignoring
+// spaces and padding the final byte with zero bits.
+func binToSlice(s string) []byte {
+	s = strings.Replace(s, " ", "", -1)
+	b := make([]byte, (len(s)+7)/8)
+	for i, c := range s {
+		if c == '1' {
+			b[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return b
+}
+
+// TestParseVUI checks that VUI parameters are parsed into the expected typed
+// fields, with inferred values used for elements that are not present.
+func TestParseVUI(t *testing.T) {
+	tests := []struct {
+		in   string
+		want VUIParameters
+	}{
+		// Nothing present, so all values are inferred.
+		{
+			in: "0 0 0 0 0 0 0 0 0",
+			want: VUIParameters{
+				VideoFormat:               VideoFormatUnspecified,
+				ColorPrimaries:            2,
+				TransferCharacteristics:   2,
+				MatrixCoefficients:        2,
+				MaxBytesPerPicDenom:       2,
+				MaxBitsPerMbDenom:         1,
+				Log2MaxMvLengthHorizontal: 15,
+				Log2MaxMvLengthVertical:   15,
+			},
+		},
+		// All present other than HRD parameters.
+		{
+			in: "1 11111111 0000000000000100 0000000000000011" + // Extended SAR 4:3.
+				"1 1" + // Overscan appropriate.
+				"1 001 1 1 00000001 00000001 00000001" + // PAL, full range, BT.709.
+				"1 010 1" + // Chroma sample loc types 1 and 0.
+				"1" + fmt.Sprintf("%032b", 1001) + fmt.Sprintf("%032b", 60000) + "1" +
+				"0 0 1 1" + // No HRD, pic_struct and bitstream restriction present.
+				"1 1 1 000010001 000010001 011 00101",
+			want: VUIParameters{
+				AspectRatioInfoPresent:         true,
+				AspectRatioIDC:                 extendedSAR,
+				SARWidth:                       4,
+				SARHeight:                      3,
+				OverscanInfoPresent:            true,
+				OverscanAppropriate:            true,
+				VideoSignalTypePresent:         true,
+				VideoFormat:                    VideoFormatPAL,
+				VideoFullRange:                 true,
+				ColorDescriptionPresent:        true,
+				ColorPrimaries:                 1,
+				TransferCharacteristics:        1,
+				MatrixCoefficients:             1,
+				ChromaLocInfoPresent:           true,
+				ChromaSampleLocTypeTopField:    1,
+				ChromaSampleLocTypeBottomField: 0,
+				TimingInfoPresent:              true,
+				NumUnitsInTick:                 1001,
+				TimeScale:                      60000,
+				FixedFrameRate:                 true,
+				PicStructPresent:               true,
+				BitstreamRestriction:           true,
+				MotionVectorsOverPicBoundaries: true,
+				MaxBytesPerPicDenom:            0,
+				MaxBitsPerMbDenom:              0,
+				Log2MaxMvLengthHorizontal:      16,
+				Log2MaxMvLengthVertical:        16,
+				MaxNumReorderFrames:            2,
+				MaxDecFrameBuffering:           4,
+			},
+		},
+		// NAL and VCL HRD parameters present.
+		{
+			in: "0 0 0 0 0" +
+				"1 1 0000 0001 1 1 1 00000 00000 00000 00000" +
+				"1 1 0000 0010 1 1 0 00000 00000 00000 00000" +
+				"1 0 0",
+			want: VUIParameters{
+				VideoFormat:               VideoFormatUnspecified,
+				ColorPrimaries:            2,
+				TransferCharacteristics:   2,
+				MatrixCoefficients:        2,
+				NalHRDParametersPresent:   true,
+				NalHRD:                    &HRDParameters{CpbSizeScale: 1, BitRateValueMinus1: []int{0}, CpbSizeValueMinus1: []int{0}, Cbr: []bool{true}},
+				VclHRDParametersPresent:   true,
+				VclHRD:                    &HRDParameters{CpbSizeScale: 2, BitRateValueMinus1: []int{0}, CpbSizeValueMinus1: []int{0}, Cbr: []bool{false}},
+				LowDelayHRD:               true,
+				MaxBytesPerPicDenom:       2,
+				MaxBitsPerMbDenom:         1,
+				Log2MaxMvLengthHorizontal: 15,
+				Log2MaxMvLengthVertical:   15,
+			},
+		},
+	}
+
+	for i, test := range tests {
+		got, err := parseVUI(bits.NewBitReader(bytes.NewReader(binToSlice(test.in))))
+		if err != nil {
+			t.Errorf("did not expect error: %v for test: %d", err, i)
+			continue
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %+v\nWant: %+v", i, *got, test.want)
+		}
+	}
+}
+
+// TestParseHRD checks that HRD parameters are parsed for each SchedSelIdx,
+// including the delay lengths following them, and that BitRate and CPBSize
+// are derived correctly.
+func TestParseHRD(t *testing.T) {
+	in := "010 0010 0011" + // cpb_cnt_minus1 1, bit_rate_scale 2, cpb_size_scale 3.
+		"0001010 00101 1" + // SchedSelIdx 0.
+		"1 1 0" + // SchedSelIdx 1.
+		"10111 10111 00100 11000"
+	want := HRDParameters{
+		CpbCntMinus1:                       1,
+		BitRateScale:                       2,
+		CpbSizeScale:                       3,
+		BitRateValueMinus1:                 []int{9, 0},
+		CpbSizeValueMinus1:                 []int{4, 0},
+		Cbr:                                []bool{true, false},
+		InitialCpbRemovalDelayLengthMinus1: 23,
+		CpbRemovalDelayLengthMinus1:        23,
+		DpbOutputDelayLengthMinus1:         4,
+		TimeOffsetLength:                   24,
+	}
+
+	got, err := parseHRD(bits.NewBitReader(bytes.NewReader(binToSlice(in))))
+	if err != nil {
+		t.Fatalf("did not expect error: %v", err)
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("did not get expected result\nGot: %+v\nWant: %+v", *got, want)
+	}
+
+	if br := got.BitRate(0); br != 10<<8 {
+		t.Errorf("did not get expected bit rate\nGot: %v\nWant: %v", br, 10<<8)
+	}
+	if cs := got.CPBSize(1); cs != 1<<7 {
+		t.Errorf("did not get expected CPB size\nGot: %v\nWant: %v", cs, 1<<7)
+	}
+
+	// cpb_cnt_minus1 of 32 is out of range.
+	_, err = parseHRD(bits.NewBitReader(bytes.NewReader(binToSlice("00000100001"))))
+	if err == nil {
+		t.Errorf("expected error for out of range cpb_cnt_minus1")
+	}
+}
+
+// TestFrameRate checks the frame rate derived from VUI timing information.
+func TestFrameRate(t *testing.T) {
+	timing := func(numUnitsInTick, timeScale uint32, fixed bool) *VUIParameters {
+		return &VUIParameters{
+			TimingInfoPresent: true,
+			NumUnitsInTick:    numUnitsInTick,
+			TimeScale:         timeScale,
+			FixedFrameRate:    fixed,
+		}
+	}
+	tests := []struct {
+		vui      *VUIParameters
+		num, den uint32
+		ok       bool
+	}{
+		{vui: timing(1, 50, true), num: 25, den: 1, ok: true},
+		{vui: timing(1001, 60000, true), num: 30000, den: 1001, ok: true},
+		{vui: timing(1000, 25000, true), num: 25, den: 2, ok: true},
+		{vui: timing(math.MaxUint32, math.MaxUint32, true), num: 1, den: 2, ok: true},
+		{vui: timing(math.MaxUint32, 1, true)},
+		{vui: timing(1, 50, false)},
+		{vui: timing(0, 50, true)},
+		{vui: timing(1, 0, true)},
+		{vui: &VUIParameters{NumUnitsInTick: 1, TimeScale: 50, FixedFrameRate: true}},
+		{vui: nil},
+	}
+
+	for i, test := range tests {
+		sps := SPS{VUI: test.vui}
+		num, den, ok := sps.FrameRate()
+		if num != test.num || den != test.den || ok != test.ok {
+			t.Errorf("did not get expected result for test: %d\nGot: %d/%d %v\nWant: %d/%d %v", i, num, den, ok, test.num, test.den, test.ok)
+		}
+	}
+}
+
+// TestAspectRatio checks the sample and display aspect ratios derived from the
+// VUI and cropped picture size.
+func TestAspectRatio(t *testing.T) {
+	// 1920x1080 and 720x576 4:2:0 progressive pictures.
+	hd := SPS{ChromaFormat: chroma420, PicWidthInMbsMinus1: 119, PicHeightInMapUnitsMinus1: 67, FrameMbsOnly: true, FrameCropping: true, FrameCropBottomOffset: 4}
+	sd := SPS{ChromaFormat: chroma420, PicWidthInMbsMinus1: 44, PicHeightInMapUnitsMinus1: 35, FrameMbsOnly: true}
+	withSAR := func(sps SPS, idc, w, h int) SPS {
+		sps.VUI = &VUIParameters{AspectRatioInfoPresent: true, AspectRatioIDC: idc, SARWidth: w, SARHeight: h}
+		return sps
+	}
+
+	tests := []struct {
+		sps        SPS
+		sarW, sarH int
+		darW, darH int
+		ok         bool
+	}{
+		{sps: withSAR(hd, 1, 0, 0), sarW: 1, sarH: 1, darW: 16, darH: 9, ok: true},
+		{sps: withSAR(sd, 4, 0, 0), sarW: 16, sarH: 11, darW: 20, darH: 11, ok: true},
+		{sps: withSAR(sd, 2, 0, 0), sarW: 12, sarH: 11, darW: 15, darH: 11, ok: true},
+		{sps: withSAR(hd, 14, 0, 0), sarW: 4, sarH: 3, darW: 64, darH: 27, ok: true},
+		{sps: withSAR(sd, extendedSAR, 64, 45), sarW: 64, sarH: 45, darW: 16, darH: 9, ok: true},
+		{sps: withSAR(sd, extendedSAR, 0, 45)},
+		{sps: withSAR(sd, 0, 0, 0)},
+		{sps: withSAR(sd, 17, 0, 0)},
+		{sps: hd},
+	}
+
+	for i, test := range tests {
+		w, h, ok := test.sps.SampleAspectRatio()
+		if w != test.sarW || h != test.sarH || ok != test.ok {
+			t.Errorf("did not get expected SAR for test: %d\nGot: %d:%d %v\nWant: %d:%d %v", i, w, h, ok, test.sarW, test.sarH, test.ok)
+		}
+		w, h, ok = test.sps.DisplayAspectRatio()
+		if w != test.darW || h != test.darH || ok != test.ok {
+			t.Errorf("did not get expected DAR for test: %d\nGot: %d:%d %v\nWant: %d:%d %v", i, w, h, ok, test.darW, test.darH, test.ok)
+		}
+	}
+}
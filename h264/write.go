@@ -0,0 +1,135 @@
+/*
+NAME
+  write.go
+
+DESCRIPTION
+  write.go provides writing of syntax elements of the descriptors specified in
+  section 7.2 and the framing of an RBSP as a NAL unit, for use in
+  serialising parameter sets.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	mathbits "math/bits"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+// writeUe writes v as a syntax element of ue(v) descriptor, i.e. an unsigned
+// integer Exp-Golomb-coded element, as specified in section 9.1.
+func writeUe(w *bits.BitWriter, v int) error {
+	if v < 0 {
+		return fmt.Errorf("cannot write negative value %d as ue(v)", v)
+	}
+	code := uint64(v) + 1
+	n := mathbits.Len64(code)
+	return w.WriteBits(code, 2*n-1)
+}
+
+// writeSe writes v as a syntax element of se(v) descriptor, i.e. a signed
+// integer Exp-Golomb-coded element, mapped as given by table 9-3.
+func writeSe(w *bits.BitWriter, v int) error {
+	if v > 0 {
+		return writeUe(w, 2*v-1)
+	}
+	return writeUe(w, -2*v)
+}
+
+// writeRBSPTrailingBits writes rbsp_trailing_bits as defined in section
+// 7.3.2.11, i.e. the stop bit followed by zero bits to byte alignment.
+func writeRBSPTrailingBits(w *bits.BitWriter) error {
+	err := w.WriteBits(1, 1)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// addEmulationPrevention returns the bytes of a NAL unit body for rbsp,
+// inserting emulation_prevention_three_byte where required by section 7.4.1.
+// rbsp must end with rbsp_trailing_bits, so its last byte is never zero.
+func addEmulationPrevention(rbsp []byte) []byte {
+	b := make([]byte, 0, len(rbsp)+len(rbsp)/64)
+	var zeros int
+	for _, c := range rbsp {
+		if zeros >= 2 && c <= emulationPreventionThreeByte {
+			b = append(b, emulationPreventionThreeByte)
+			zeros = 0
+		}
+		b = append(b, c)
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return b
+}
+
+// writeNalUnit writes a NAL unit of type typ and nal_ref_idc refIdc, without
+// start code prefix, with the RBSP produced by calling body. body must not
+// write rbsp_trailing_bits, these are added.
+func writeNalUnit(w io.Writer, typ NALType, refIdc int, body func(*rbspWriter)) error {
+	var rbsp bytes.Buffer
+	rw := &rbspWriter{bw: bits.NewBitWriter(&rbsp)}
+	body(rw)
+	if rw.err != nil {
+		return rw.err
+	}
+	err := writeRBSPTrailingBits(rw.bw)
+	if err != nil {
+		return fmt.Errorf("could not write rbsp_trailing_bits: %w", err)
+	}
+	_, err = w.Write(append([]byte{byte(refIdc<<5) | byte(typ)}, addEmulationPrevention(rbsp.Bytes())...))
+	return err
+}
+
+// rbspWriter writes syntax elements to a BitWriter, keeping the first error
+// encountered so that a syntax structure may be written without checking the
+// error of each element.
+type rbspWriter struct {
+	bw  *bits.BitWriter
+	err error
+}
+
+// u writes v as a syntax element of u(n) descriptor.
+func (w *rbspWriter) u(v uint64, n int) {
+	if w.err == nil {
+		w.err = w.bw.WriteBits(v, n)
+	}
+}
+
+// flag writes b as a syntax element of u(1) descriptor.
+func (w *rbspWriter) flag(b bool) {
+	if w.err == nil {
+		w.err = w.bw.WriteBool(b)
+	}
+}
+
+// ue writes v as a syntax element of ue(v) descriptor.
+func (w *rbspWriter) ue(v int) {
+	if w.err == nil {
+		w.err = writeUe(w.bw, v)
+	}
+}
+
+// se writes v as a syntax element of se(v) descriptor.
+func (w *rbspWriter) se(v int) {
+	if w.err == nil {
+		w.err = writeSe(w.bw, v)
+	}
+}
+
+// fail records err if no error has yet been encountered.
+func (w *rbspWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
@@ -0,0 +1,324 @@
+/*
+NAME
+  write_test.go
+
+DESCRIPTION
+  write_test.go provides testing for functionality in write.go and the
+  serialisation of parameter sets.
+
+AUTHORS
+  Saxon Nelson-Milton <saxon@ausocean.org>, The Australian Ocean Laboratory (AusOcean)
+*/
+
+package h264
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/ausocean/h264decode/h264/bits"
+)
+
+func TestWriteUeSe(t *testing.T) {
+	vals := []int{0, 1, 2, 3, 7, 8, 100, 255, 65535, 1<<31 - 2}
+	var buf bytes.Buffer
+	w := &rbspWriter{bw: bits.NewBitWriter(&buf)}
+	for _, v := range vals {
+		w.ue(v)
+		w.se(v)
+		w.se(-v)
+	}
+	w.flag(true)
+	if w.err != nil {
+		t.Fatalf("did not expect error: %v", w.err)
+	}
+	w.bw.Flush()
+
+	br := bits.NewBitReader(bytes.NewReader(buf.Bytes()))
+	for i, v := range vals {
+		for j, want := range []int{v, v, -v} {
+			var got int
+			var err error
+			if j == 0 {
+				got, err = readUe(br)
+			} else {
+				got, err = readSe(br)
+			}
+			if err != nil {
+				t.Fatalf("did not expect error: %v for test: %d", err, i)
+			}
+			if got != want {
+				t.Errorf("did not get expected result for test: %d\nGot: %v\nWant: %v\n", i, got, want)
+			}
+		}
+	}
+
+	w.ue(-1)
+	if w.err == nil {
+		t.Errorf("expected error writing negative ue(v)")
+	}
+}
+
+func TestAddEmulationPrevention(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want []byte
+	}{
+		{
+			in:   []byte{0x01, 0x02, 0x80},
+			want: []byte{0x01, 0x02, 0x80},
+		},
+		{
+			in:   []byte{0x00, 0x00, 0x01, 0x80},
+			want: []byte{0x00, 0x00, 0x03, 0x01, 0x80},
+		},
+		{
+			in:   []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x80},
+			want: []byte{0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x80},
+		},
+		{
+			in:   []byte{0x00, 0x00, 0x03, 0x00, 0x00, 0x04},
+			want: []byte{0x00, 0x00, 0x03, 0x03, 0x00, 0x00, 0x04},
+		},
+	}
+
+	for i, test := range tests {
+		got := addEmulationPrevention(test.in)
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("did not get expected result for test: %d\nGot: %#v\nWant: %#v\n", i, got, test.want)
+		}
+		rbsp, _ := removeEmulationPrevention(got)
+		if !bytes.Equal(rbsp, test.in) {
+			t.Errorf("did not get original RBSP back for test: %d\nGot: %#v\nWant: %#v\n", i, rbsp, test.in)
+		}
+	}
+}
+
+// TestWriteParameterSets checks that parameter sets parsed from a stream are
+// written back to identical NAL units.
+func TestWriteParameterSets(t *testing.T) {
+	tests := []struct {
+		sps, pps []byte
+	}{
+		{sps: testSPS, pps: testPPS},
+		{sps: spsWithID(7), pps: ppsWithID(200, 7)},
+	}
+
+	for i, test := range tests {
+		var p ParameterSets
+		for _, nal := range [][]byte{test.sps, test.pps} {
+			nalUnit, err := NewNalUnit(nal, len(nal))
+			if err != nil {
+				t.Fatalf("did not expect error: %v from NewNalUnit for test: %d", err, i)
+			}
+			err = p.Parse(nalUnit)
+			if err != nil {
+				t.Fatalf("did not expect error: %v from Parse for test: %d", err, i)
+			}
+		}
+
+		var got bytes.Buffer
+		err := p.last.Write(&got)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from SPS Write for test: %d", err, i)
+		}
+		if !bytes.Equal(got.Bytes(), test.sps) {
+			t.Errorf("did not get expected SPS for test: %d\nGot: %#v\nWant: %#v\n", i, got.Bytes(), test.sps)
+		}
+
+		got.Reset()
+		for _, pps := range p.PPS {
+			err = pps.Write(&got)
+			if err != nil {
+				t.Fatalf("did not expect error: %v from PPS Write for test: %d", err, i)
+			}
+		}
+		if !bytes.Equal(got.Bytes(), test.pps) {
+			t.Errorf("did not get expected PPS for test: %d\nGot: %#v\nWant: %#v\n", i, got.Bytes(), test.pps)
+		}
+	}
+}
+
+// TestWriteSPSRoundTrip checks that a modified High profile SPS, with scaling
+// matrix and VUI, is parsed back unchanged after being written.
+func TestWriteSPSRoundTrip(t *testing.T) {
+	nalUnit, err := NewNalUnit(testSPS, len(testSPS))
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewNalUnit", err)
+	}
+	want, err := NewSPS(nalUnit.RBSP(), false)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewSPS", err)
+	}
+
+	want.ProfileIDC = 100
+	want.LevelIDC = 40
+	want.ChromaFormat = chroma420
+	want.SeqScalingMatrixPresent = true
+	want.SeqScalingList = []bool{true, true, true, true, true, true, true, true}
+	for i := range want.ScalingMatrix.List4x4 {
+		for j := range want.ScalingMatrix.List4x4[i] {
+			want.ScalingMatrix.List4x4[i][j] = 16 + i + j
+			if i == 1 && j > 9 {
+				want.ScalingMatrix.List4x4[i][j] = 26 // Ends early.
+			}
+		}
+	}
+	want.ScalingMatrix.List4x4[2][0] = 200 // deltaScale wraps.
+	want.ScalingMatrix.List4x4[3] = Default4x4InterList
+	want.ScalingMatrix.List8x8[0] = Default8x8IntraList
+	for j := range want.ScalingMatrix.List8x8[1] {
+		want.ScalingMatrix.List8x8[1][j] = 4 + 3*j
+	}
+	// Cb and Cr 8x8 lists are not present for 4:2:0, and fall back to Y.
+	for i := 2; i < 6; i++ {
+		want.ScalingMatrix.List8x8[i] = want.ScalingMatrix.List8x8[i%2]
+	}
+
+	want.VuiParametersPresent = true
+	want.VUI = &VUIParameters{
+		AspectRatioInfoPresent:    true,
+		AspectRatioIDC:            extendedSAR,
+		SARWidth:                  4,
+		SARHeight:                 3,
+		VideoSignalTypePresent:    true,
+		VideoFormat:               VideoFormatPAL,
+		ColorDescriptionPresent:   true,
+		ColorPrimaries:            1,
+		TransferCharacteristics:   1,
+		MatrixCoefficients:        1,
+		TimingInfoPresent:         true,
+		NumUnitsInTick:            1001,
+		TimeScale:                 60000,
+		FixedFrameRate:            true,
+		NalHRDParametersPresent:   true,
+		NalHRD:                    &HRDParameters{CpbCntMinus1: 1, BitRateScale: 2, CpbSizeScale: 3, BitRateValueMinus1: []int{1000, 2000}, CpbSizeValueMinus1: []int{3000, 4000}, Cbr: []bool{false, true}, InitialCpbRemovalDelayLengthMinus1: 23, CpbRemovalDelayLengthMinus1: 23, DpbOutputDelayLengthMinus1: 23, TimeOffsetLength: 24},
+		LowDelayHRD:               true,
+		BitstreamRestriction:      true,
+		MaxBytesPerPicDenom:       2,
+		MaxBitsPerMbDenom:         1,
+		Log2MaxMvLengthHorizontal: 16,
+		Log2MaxMvLengthVertical:   16,
+		MaxNumReorderFrames:       2,
+		MaxDecFrameBuffering:      4,
+	}
+
+	var buf bytes.Buffer
+	err = want.Write(&buf)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from Write", err)
+	}
+	nalUnit, err = NewNalUnit(buf.Bytes(), buf.Len())
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewNalUnit", err)
+	}
+	if nalUnit.Type != NALTypeSPS || nalUnit.RefIdc != 3 {
+		t.Errorf("did not get expected NAL header\nGot: %s, nal_ref_idc %d\nWant: %s, nal_ref_idc 3\n", nalUnit.Type, nalUnit.RefIdc, NALTypeSPS)
+	}
+	got, err := NewSPS(nalUnit.RBSP(), false)
+	if err != nil {
+		t.Fatalf("did not expect error: %v from NewSPS", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("did not get expected result\nGot: %+v\nWant: %+v\n", got, want)
+	}
+}
+
+// TestNewSPSChromaFormatRange checks that an SPS with out of range
+// chroma_format_idc or bit depth is rejected, and that 4:2:2 and 4:4:4 with
+// separate colour planes are parsed.
+func TestNewSPSChromaFormatRange(t *testing.T) {
+	tests := []struct {
+		chromaFormat   int
+		separate       bool
+		bitDepthMinus8 int
+		ok             bool
+	}{
+		{chromaFormat: chroma422, ok: true},
+		{chromaFormat: chroma444, separate: true, bitDepthMinus8: 6, ok: true},
+		{chromaFormat: chromaMonochrome, ok: true},
+		{chromaFormat: 4},
+		{chromaFormat: chroma420, bitDepthMinus8: 7},
+	}
+
+	for i, test := range tests {
+		sps := SPS{
+			ProfileIDC:            244,
+			ChromaFormat:          test.chromaFormat,
+			UseSeparateColorPlane: test.separate,
+			BitDepthLumaMinus8:    test.bitDepthMinus8,
+			BitDepthChromaMinus8:  test.bitDepthMinus8,
+			FrameMbsOnly:          true,
+			ScalingMatrix:         FlatScalingMatrix(),
+		}
+		var buf bytes.Buffer
+		err := sps.Write(&buf)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from Write for test: %d", err, i)
+		}
+		nalUnit, err := NewNalUnit(buf.Bytes(), buf.Len())
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewNalUnit for test: %d", err, i)
+		}
+		got, err := NewSPS(nalUnit.RBSP(), false)
+		if (err == nil) != test.ok {
+			t.Errorf("did not get expected error for test: %d\nGot: %v\nWant error: %v", i, err, !test.ok)
+			continue
+		}
+		if err == nil && (got.ChromaFormat != test.chromaFormat || got.UseSeparateColorPlane != test.separate) {
+			t.Errorf("did not get expected chroma format for test: %d\nGot: %d %v\nWant: %d %v", i, got.ChromaFormat, got.UseSeparateColorPlane, test.chromaFormat, test.separate)
+		}
+	}
+}
+
+// TestWritePPSHighRoundTrip checks that a PPS with the optional trailing
+// syntax elements used by High profiles is parsed back unchanged after being
+// written, and that second_chroma_qp_index_offset is inferred when absent.
+func TestWritePPSHighRoundTrip(t *testing.T) {
+	sps := &SPS{ProfileIDC: 100, ChromaFormat: chroma420, ScalingMatrix: FlatScalingMatrix()}
+
+	withTail := PPS{
+		ChromaQpIndexOffset:       2,
+		Transform8x8Mode:          1,
+		PicScalingMatrixPresent:   true,
+		PicScalingListPresent:     []bool{true, false, false, true, false, false, true, false},
+		ScalingMatrix:             FlatScalingMatrix(),
+		SecondChromaQpIndexOffset: -3,
+	}
+	withTail.ScalingMatrix.List4x4[0] = Default4x4IntraList
+	withTail.ScalingMatrix.List4x4[1] = Default4x4IntraList
+	withTail.ScalingMatrix.List4x4[2] = Default4x4IntraList
+	for j := range withTail.ScalingMatrix.List4x4[3] {
+		withTail.ScalingMatrix.List4x4[3][j] = 10 + j
+	}
+	withTail.ScalingMatrix.List4x4[4] = withTail.ScalingMatrix.List4x4[3]
+	withTail.ScalingMatrix.List4x4[5] = withTail.ScalingMatrix.List4x4[3]
+	withTail.ScalingMatrix.List8x8[0] = Default8x8IntraList
+	withTail.ScalingMatrix.List8x8[1] = Default8x8InterList
+	for i := 2; i < 6; i++ {
+		withTail.ScalingMatrix.List8x8[i] = withTail.ScalingMatrix.List8x8[i%2]
+	}
+
+	secondOnly := PPS{ChromaQpIndexOffset: 1, SecondChromaQpIndexOffset: 4, ScalingMatrix: FlatScalingMatrix()}
+	noTail := PPS{ChromaQpIndexOffset: -2, SecondChromaQpIndexOffset: -2, ScalingMatrix: FlatScalingMatrix()}
+
+	for i, want := range []PPS{withTail, secondOnly, noTail} {
+		var buf bytes.Buffer
+		err := want.Write(&buf)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from Write for test: %d", err, i)
+		}
+		nalUnit, err := NewNalUnit(buf.Bytes(), buf.Len())
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewNalUnit for test: %d", err, i)
+		}
+		got, err := NewPPS(sps, nalUnit.RBSP(), false)
+		if err != nil {
+			t.Fatalf("did not expect error: %v from NewPPS for test: %d", err, i)
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("did not get expected PPS for test: %d\nGot: %+v\nWant: %+v", i, *got, want)
+		}
+	}
+}